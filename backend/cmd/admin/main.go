@@ -0,0 +1,172 @@
+// Command admin manages users and the database directly, for operators who can't or shouldn't go
+// through the HTTP API, such as to create the first admin of a new install. it reads the server's
+// DATABASE_URL, PII_KEYS, PII_INDEX_KEY and REDIS_URL from the environment, or from its flags.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"api/internal/pii"
+	"api/internal/store"
+	"api/internal/tenant"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/cobra"
+)
+
+// connection settings shared by every command
+type options struct {
+	databaseURL string
+	piiKeys     string
+	piiIndexKey string
+	redisURL    string
+	tenant      string
+}
+
+// how long users read here stay in the server's cache; every write here invalidates them all the same
+const cacheTTL = time.Minute
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := newRootCommand().ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	opts := &options{}
+	root := &cobra.Command{
+		Use:          "admin",
+		Short:        "Manage the users and the database of the API",
+		SilenceUsage: true,
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&opts.databaseURL, "database-url", os.Getenv("DATABASE_URL"), "Postgres connection string")
+	flags.StringVar(&opts.piiKeys, "pii-keys", os.Getenv("PII_KEYS"), "keys encrypting user emails and phone numbers, as the server's pii_keys")
+	flags.StringVar(&opts.piiIndexKey, "pii-index-key", os.Getenv("PII_INDEX_KEY"), "key hashing encrypted emails for lookups, as the server's pii_index_key")
+	flags.StringVar(&opts.redisURL, "redis-url", os.Getenv("REDIS_URL"), "Redis the server caches users in, so it sees changes made here at once")
+	flags.StringVar(&opts.tenant, "tenant", "", "slug of the tenant to act within; the default tenant when empty")
+
+	root.AddCommand(newUsersCommand(opts), newMigrateCommand(opts), newPurgeCommand(opts), newSeedCommand(opts))
+	return root
+}
+
+// the connections a command works with
+type env struct {
+	db   *sql.DB
+	pool *pgxpool.Pool
+	rdb  *redis.Client
+}
+
+func (o *options) open(ctx context.Context) (*env, error) {
+	if o.databaseURL == "" {
+		return nil, errors.New("--database-url or DATABASE_URL is required")
+	}
+	db, pool, err := store.Open(o.databaseURL, store.PoolOptions{MaxConns: 2})
+	if err != nil {
+		return nil, err
+	}
+	e := &env{db: db, pool: pool}
+	if err := store.WaitForDB(ctx, db, 0); err != nil {
+		e.close()
+		return nil, err
+	}
+	if o.redisURL != "" {
+		opts, err := redis.ParseURL(o.redisURL)
+		if err != nil {
+			e.close()
+			return nil, err
+		}
+		e.rdb = redis.NewClient(opts)
+	}
+	return e, nil
+}
+
+func (e *env) close() {
+	if e.rdb != nil {
+		e.rdb.Close()
+	}
+	e.db.Close()
+	e.pool.Close()
+}
+
+// the users of the tenant named by --tenant, and ctx scoped to it. writes are audited without an
+// actor, as changes the system made
+func (o *options) users(ctx context.Context, e *env) (context.Context, store.UserRepository, error) {
+	if o.tenant != "" {
+		id, err := store.NewPostgresTenants(e.db).TenantID(ctx, o.tenant)
+		if err != nil {
+			return nil, nil, err
+		}
+		ctx = tenant.WithID(ctx, id)
+	}
+	var keys *pii.Keyring
+	if o.piiKeys != "" {
+		var err error
+		if keys, err = pii.NewKeyring(o.piiKeys, o.piiIndexKey); err != nil {
+			return nil, nil, err
+		}
+	}
+	var users store.UserRepository = store.NewPostgresUserRepository(e.db, nil, keys)
+	users = store.NewAuditedUserRepository(users, store.NewPostgresAuditLog(e.db, keys), func(context.Context) (int, int, string, string) {
+		return 0, 0, "", ""
+	})
+	if e.rdb != nil {
+		users = store.NewCachedUserRepository(users, store.NewRedisCache(e.rdb), cacheTTL)
+	}
+	return ctx, users, nil
+}
+
+func newMigrateCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:       "migrate [up|up-by-one|down|redo|status]",
+		Short:     "Run a migration command, up when none is given",
+		Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"up", "up-by-one", "down", "redo", "status"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			command := "up"
+			if len(args) == 1 {
+				command = args[0]
+			}
+			e, err := opts.open(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer e.close()
+			return store.Migrate(cmd.Context(), e.db, command)
+		},
+	}
+}
+
+func newPurgeCommand(opts *options) *cobra.Command {
+	var olderThan time.Duration
+	cmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Remove soft-deleted users of every tenant for good, with everything of theirs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			e, err := opts.open(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer e.close()
+			n, err := store.NewPostgresMaintenance(e.db).PurgeDeletedUsers(cmd.Context(), time.Now().Add(-olderThan))
+			if err != nil {
+				return err
+			}
+			cmd.Printf("purged %d users\n", n)
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&olderThan, "older-than", 0, "only purge users deleted longer ago than this")
+	return cmd
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spf13/cobra"
+)
+
+// users created per transaction
+const seedBatch = 1000
+
+var (
+	seedFirstNames = []string{"Amara", "Ben", "Chloé", "Dawit", "Elena", "Farid", "Grace", "Hiroshi", "Ingrid", "Jamal", "Kavya", "Liam", "Mei", "Nikolai", "Olivia", "Pedro", "Quinn", "Rahel", "Sofia", "Tomás", "Uma", "Victor", "Wanjiru", "Xavier", "Yara", "Zeynep", "Abebe", "Beatriz", "Chen", "Daniela", "Emeka", "Fatima", "Gabriel", "Hana", "Isaac", "Julia", "Kofi", "Lucía", "Mateo", "Noor"}
+	seedLastNames  = []string{"Abebe", "Bakker", "Castillo", "Dubois", "Eriksen", "Fernandes", "García", "Haile", "Ivanova", "Johnson", "Kim", "Lopez", "Müller", "Nakamura", "Okafor", "Patel", "Quispe", "Rossi", "Suzuki", "Tesfaye", "Usman", "Varga", "Wang", "Xu", "Yilmaz", "Zhang", "Andersen", "Brown", "Chowdhury", "Diallo", "Evans", "Gomez", "Hansen", "Ito", "Jones", "Kowalski", "Li", "Mensah", "Moreau", "Nguyen"}
+	seedTimezones  = []string{"UTC", "America/New_York", "America/Los_Angeles", "America/Sao_Paulo", "Europe/London", "Europe/Paris", "Europe/Berlin", "Africa/Addis_Ababa", "Africa/Lagos", "Asia/Tokyo", "Asia/Kolkata", "Asia/Shanghai", "Australia/Sydney"}
+	seedLocales    = []string{"en-US", "en-GB", "pt-BR", "es-ES", "fr-FR", "de-DE", "am-ET", "ja-JP", "hi-IN", "zh-CN"}
+	seedBios       = []string{"Coffee first, code second.", "Product designer who loves typography.", "Weekend hiker and amateur photographer.", "Backend engineer. Opinions are my own.", "Learning something new every day.", "Runs on tea and curiosity."}
+)
+
+func newSeedCommand(opts *options) *cobra.Command {
+	var (
+		password     string
+		over         time.Duration
+		environment  string
+		allowNonprod bool
+	)
+	cmd := &cobra.Command{
+		Use:   "seed N",
+		Short: "Create N fake users for demos and load tests; users seeded before are kept, not duplicated",
+		Long: `Create N fake users for demos and load tests, with names, emails and profiles that look real
+and sign-up times spread over the period given by --over. The users are the same on every run, so
+running it again only creates those missing, and their emails are all at example.com.
+
+It refuses to run unless the database is known not to be production's: the environment is named
+development, dev, local or test, the database is on this machine, or --allow-nonprod vouches for
+it. A production environment is refused all the same.`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return checkSeedTarget(environment, opts.databaseURL, allowNonprod)
+		},
+		RunE: withUsers(opts, func(cmd *cobra.Command, args []string, users store.UserRepository) error {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("%q is not a number of users", args[0])
+			}
+			// every seeded user shares one hash; bcrypt is far too slow to hash each
+			hash := ""
+			if password != "" {
+				if hash, err = hashPassword(password); err != nil {
+					return err
+				}
+			}
+			now := time.Now()
+			created := 0
+			for from := 0; from < n; from += seedBatch {
+				batch := make([]models.User, 0, seedBatch)
+				emails := make([]string, 0, seedBatch)
+				for i := from; i < min(from+seedBatch, n); i++ {
+					u := seedUser(i, now, over)
+					u.PasswordHash = hash
+					batch = append(batch, u)
+					emails = append(emails, u.Email)
+				}
+				existing, err := users.ExistingEmails(cmd.Context(), emails)
+				if err != nil {
+					return err
+				}
+				missing := batch[:0]
+				for _, u := range batch {
+					if !existing[u.Email] {
+						missing = append(missing, u)
+					}
+				}
+				if _, err := users.CreateMany(cmd.Context(), missing); err != nil {
+					return err
+				}
+				created += len(missing)
+			}
+			cmd.Printf("created %d users, %d were already seeded\n", created, n-created)
+			return nil
+		}),
+	}
+	cmd.Flags().StringVar(&password, "password", "", "password of every seeded user; they can't sign in when empty")
+	cmd.Flags().DurationVar(&over, "over", 365*24*time.Hour, "how far back the users' sign-up times go")
+	cmd.Flags().StringVar(&environment, "environment", os.Getenv("ENVIRONMENT"), "the environment seeded; production is refused")
+	cmd.Flags().BoolVar(&allowNonprod, "allow-nonprod", false, "seed a database that is neither local nor in a development environment, vouching it isn't production")
+	return cmd
+}
+
+// environments seeded without --allow-nonprod
+var seedEnvironments = []string{"development", "dev", "local", "test"}
+
+// refuse to seed a database unless it is known not to be production's, from the environment, its
+// host or the operator's word; when in doubt it refuses, as thousands of fake accounts are no
+// easier to take out of production than to keep out
+func checkSeedTarget(environment, databaseURL string, allowNonprod bool) error {
+	env := strings.ToLower(environment)
+	if env == "production" || env == "prod" {
+		return errors.New("refusing to seed a production environment")
+	}
+	if allowNonprod || slices.Contains(seedEnvironments, env) {
+		return nil
+	}
+	if cfg, err := pgconn.ParseConfig(databaseURL); err == nil && localHost(cfg.Host) {
+		return nil
+	}
+	return errors.New("refusing to seed a database that may be production's: set --environment to development, dev, local or test, or pass --allow-nonprod")
+}
+
+// report whether a database host is on this machine: a Unix socket directory, localhost or a loopback address
+func localHost(host string) bool {
+	if strings.HasPrefix(host, "/") || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// the i-th seeded user, always the same but for the sign-up time, which falls within over before now
+func seedUser(i int, now time.Time, over time.Duration) models.User {
+	r := rand.New(rand.NewPCG(0x5eed, uint64(i)))
+	pick := func(list []string) string { return list[r.IntN(len(list))] }
+	first, last := pick(seedFirstNames), pick(seedLastNames)
+	u := models.User{
+		Name:      first + " " + last,
+		Email:     fmt.Sprintf("%s.%s.%d@example.com", asciiName(first), asciiName(last), i+1),
+		CreatedAt: now.Add(-time.Duration(r.Float64() * float64(over))).Truncate(time.Second),
+	}
+	tz, locale := pick(seedTimezones), pick(seedLocales)
+	u.Timezone, u.Locale = &tz, &locale
+	// some fill in the rest of their profile
+	if r.IntN(3) == 0 {
+		bio := pick(seedBios)
+		u.Bio = &bio
+	}
+	if r.IntN(4) == 0 {
+		// 555-01xx numbers are reserved for fiction
+		phone := fmt.Sprintf("+1%03d55501%02d", 201+r.IntN(700), r.IntN(100))
+		u.Phone = &phone
+	}
+	return u
+}
+
+// a name lowercased for an email's local part, without accents
+func asciiName(name string) string {
+	replacer := strings.NewReplacer("é", "e", "á", "a", "í", "i", "ü", "u")
+	return strings.ToLower(replacer.Replace(name))
+}
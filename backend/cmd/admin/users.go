@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newUsersCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "users",
+		Short: "List, create and change users",
+	}
+	cmd.AddCommand(
+		newListUsersCommand(opts),
+		newCreateUserCommand(opts),
+		newDeleteUsersCommand(opts),
+		newResetPasswordCommand(opts),
+		newSetRoleCommand(opts, "promote", models.RoleAdmin),
+		newSetRoleCommand(opts, "demote", models.RoleUser),
+	)
+	return cmd
+}
+
+// run fn with the users of the tenant the command acts within
+func withUsers(opts *options, fn func(cmd *cobra.Command, args []string, users store.UserRepository) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		e, err := opts.open(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer e.close()
+		ctx, users, err := opts.users(cmd.Context(), e)
+		if err != nil {
+			return err
+		}
+		cmd.SetContext(ctx)
+		return fn(cmd, args, users)
+	}
+}
+
+func newListUsersCommand(opts *options) *cobra.Command {
+	var (
+		f             models.UserFilter
+		limit, offset int
+	)
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List users by id",
+		Args:  cobra.NoArgs,
+		RunE: withUsers(opts, func(cmd *cobra.Command, args []string, users store.UserRepository) error {
+			list, total, err := users.List(cmd.Context(), f, models.UserSort{}, limit, offset)
+			if err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tEMAIL\tNAME\tROLE\tCREATED\tDELETED")
+			for _, u := range list {
+				deleted := ""
+				if u.DeletedAt != nil {
+					deleted = u.DeletedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", u.Id, u.Email, u.Name, u.Role, u.CreatedAt.Format(time.RFC3339), deleted)
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			cmd.Printf("%d of %d users\n", len(list), total)
+			return nil
+		}),
+	}
+	cmd.Flags().StringVar(&f.EmailContains, "email", "", "only users whose email contains this")
+	cmd.Flags().BoolVar(&f.IncludeDeleted, "deleted", false, "include soft-deleted users")
+	cmd.Flags().IntVar(&limit, "limit", 50, "how many users to list")
+	cmd.Flags().IntVar(&offset, "offset", 0, "how many users to skip")
+	return cmd
+}
+
+func newCreateUserCommand(opts *options) *cobra.Command {
+	var (
+		u             models.User
+		role          string
+		passwordStdin bool
+	)
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a user, printing their password unless it is read from stdin",
+		Args:  cobra.NoArgs,
+		RunE: withUsers(opts, func(cmd *cobra.Command, args []string, users store.UserRepository) error {
+			password, err := readPassword(cmd, passwordStdin)
+			if err != nil {
+				return err
+			}
+			if u.PasswordHash, err = hashPassword(password); err != nil {
+				return err
+			}
+			created, err := users.Create(cmd.Context(), u)
+			if err != nil {
+				return err
+			}
+			// the first user of a tenant is made its admin
+			if role != "" && role != created.Role {
+				if created, err = users.SetRole(cmd.Context(), created.Id, role); err != nil {
+					return fmt.Errorf("user %d was created, but not given the role: %w", created.Id, err)
+				}
+			}
+			cmd.Printf("created user %d (%s) with role %s\n", created.Id, created.Email, created.Role)
+			if !passwordStdin {
+				cmd.Printf("password: %s\n", password)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().StringVar(&u.Name, "name", "", "the user's name")
+	cmd.Flags().StringVar(&u.Email, "email", "", "the user's email address")
+	cmd.Flags().StringVar(&role, "role", "", "the user's role; user, or admin for a tenant's first user, when empty")
+	cmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the password from the first line of stdin instead of generating one")
+	cmd.MarkFlagRequired("name")
+	cmd.MarkFlagRequired("email")
+	return cmd
+}
+
+func newDeleteUsersCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete ID...",
+		Short: "Soft-delete users; purge removes them for good",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: withUsers(opts, func(cmd *cobra.Command, args []string, users store.UserRepository) error {
+			ids, err := parseIDs(args)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				if _, err := users.Delete(cmd.Context(), id); err != nil {
+					return fmt.Errorf("user %d: %w", id, err)
+				}
+				cmd.Printf("deleted user %d\n", id)
+			}
+			return nil
+		}),
+	}
+}
+
+func newResetPasswordCommand(opts *options) *cobra.Command {
+	var passwordStdin bool
+	cmd := &cobra.Command{
+		Use:   "reset-password ID",
+		Short: "Set a user's password, printing it unless it is read from stdin",
+		Args:  cobra.ExactArgs(1),
+		RunE: withUsers(opts, func(cmd *cobra.Command, args []string, users store.UserRepository) error {
+			ids, err := parseIDs(args)
+			if err != nil {
+				return err
+			}
+			// SetPasswordHash changes deleted users too, so check the user is live first
+			if _, err := users.Get(cmd.Context(), ids[0], false); err != nil {
+				return err
+			}
+			password, err := readPassword(cmd, passwordStdin)
+			if err != nil {
+				return err
+			}
+			hash, err := hashPassword(password)
+			if err != nil {
+				return err
+			}
+			if err := users.SetPasswordHash(cmd.Context(), ids[0], hash); err != nil {
+				return err
+			}
+			cmd.Printf("reset the password of user %d\n", ids[0])
+			if !passwordStdin {
+				cmd.Printf("password: %s\n", password)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the password from the first line of stdin instead of generating one")
+	return cmd
+}
+
+func newSetRoleCommand(opts *options, use, role string) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " ID",
+		Short: "Give a user the " + role + " role",
+		Args:  cobra.ExactArgs(1),
+		RunE: withUsers(opts, func(cmd *cobra.Command, args []string, users store.UserRepository) error {
+			ids, err := parseIDs(args)
+			if err != nil {
+				return err
+			}
+			u, err := users.SetRole(cmd.Context(), ids[0], role)
+			if err != nil {
+				return err
+			}
+			cmd.Printf("user %d (%s) now has role %s\n", u.Id, u.Email, u.Role)
+			return nil
+		}),
+	}
+}
+
+func parseIDs(args []string) ([]int, error) {
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("%q is not a user id", arg)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// the first line of stdin, or a random password when fromStdin is false
+func readPassword(cmd *cobra.Command, fromStdin bool) (string, error) {
+	if !fromStdin {
+		return rand.Text(), nil
+	}
+	line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		if err == nil {
+			err = errors.New("the password read from stdin is empty")
+		}
+		return "", err
+	}
+	return password, nil
+}
+
+// hashed as the server hashes passwords
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
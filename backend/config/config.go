@@ -0,0 +1,948 @@
+// Package config loads the server configuration from flags, environment variables and an
+// optional config file, in that order of precedence, falling back to built-in defaults.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// the character classes password_required_classes may list, as handlers.PasswordClasses
+var passwordClasses = []string{"upper", "lower", "digit", "symbol"}
+
+// the user fields masked_user_fields may list, as handlers.MaskableFields
+var maskableFields = []string{"email", "phone", "created_at", "updated_at", "email_verified_at", "deleted_at"}
+
+// ErrHelp is returned by Load when -h or --help was requested; usage has already been printed.
+var ErrHelp = pflag.ErrHelp
+
+// Config is the effective configuration of the server.
+type Config struct {
+	HTTPAddr string
+	GRPCAddr string
+
+	DebugEndpoints bool
+	DebugAddr      string
+
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSAutocertDomains  []string
+	TLSAutocertEmail    string
+	TLSAutocertCacheDir string
+	TLSRedirectAddr     string
+
+	DatabaseDriver    string
+	DatabaseURL       string
+	DatabaseReplicas  []string
+	DBMaxOpenConns    int
+	DBMinIdleConns    int
+	DBConnMaxLifetime time.Duration
+	DBConnMaxIdleTime time.Duration
+	DBConnectTimeout  time.Duration
+	DBHealthInterval  time.Duration
+	DBBreakerFailures int
+	DBBreakerCooldown time.Duration
+	AutoMigrate       bool
+	Migrate           string
+	PIIKeys           string
+	PIIIndexKey       string
+	ReencryptPII      bool
+
+	JWTSecret        string
+	LogLevel         string
+	PublicURL        string
+	PasswordResetURL string
+	InvitationURL    string
+
+	TermsVersion   string
+	TermsURL       string
+	PrivacyVersion string
+	PrivacyURL     string
+	RequireConsent bool
+
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+
+	OAuthGoogleClientID     string
+	OAuthGoogleClientSecret string
+	OAuthGitHubClientID     string
+	OAuthGitHubClientSecret string
+	OAuthRedirectURL        string
+
+	AuthBackend        string
+	LDAPURL            string
+	LDAPStartTLS       bool
+	LDAPBindDN         string
+	LDAPBindPassword   string
+	LDAPBaseDN         string
+	LDAPUserFilter     string
+	LDAPEmailAttribute string
+	LDAPNameAttribute  string
+	LDAPTimeout        time.Duration
+
+	CORSAllowedOrigins   []string
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSExposedHeaders   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration
+
+	ContentSecurityPolicy string
+	HSTSMaxAge            time.Duration
+	ServeFrontend         bool
+	LocalesDir            string
+
+	CompressionMinSize int
+	MaxBodySize        int
+
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	RequestTimeout  time.Duration
+	TrustedProxies  []string
+
+	RateLimitRPS   float64
+	RateLimitBurst int
+	RedisURL       string
+	CacheTTL       time.Duration
+	CacheSize      int
+
+	IdempotencyTTL time.Duration
+
+	OutboxRetention          time.Duration
+	WebhookMaxAttempts       int
+	WebhookTimeout           time.Duration
+	WebhookAllowPrivate      bool
+	WebhookDeliveryRetention time.Duration
+
+	JobWorkers   int
+	JobRetention time.Duration
+
+	PurgeDeletedUsersAfter    time.Duration
+	PurgeDeletedUsersInterval time.Duration
+	ResetTokenCleanupInterval time.Duration
+	SessionCleanupInterval    time.Duration
+	LoginHistoryRetention     time.Duration
+
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+
+	PasswordMinLength       int
+	PasswordRequiredClasses []string
+	PasswordBlockCommon     bool
+	PasswordBlockEmail      bool
+
+	EventsBroker string
+	EventsURL    string
+	EventsTopic  string
+	EventsFormat string
+
+	StorageBackend  string
+	StorageDir      string
+	StorageBaseURL  string
+	StorageS3Bucket string
+	ExportDir       string
+	DefaultAvatar   string
+
+	NormalizeNames            bool
+	SearchSimilarityThreshold float64
+	MaskedUserFields          map[string][]string // field -> roles seeing it in full
+}
+
+// each setting is read from the flag --<key with dashes>, the env var <KEY> and the config file key <key>
+type setting struct {
+	key   string
+	value interface{}
+	usage string
+}
+
+var settings = []setting{
+	{"http_addr", ":8000", "address the HTTP server listens on"},
+	{"grpc_addr", ":9000", "address the internal gRPC server listens on"},
+	{"debug_endpoints", false, "serve pprof profiles and expvar variables under /debug/ to admins of the default tenant"},
+	{"debug_addr", "", "loopback address of a listener serving /debug/ without authentication, such as 127.0.0.1:6060; empty for none"},
+	{"tls_cert_file", "", "PEM certificate chain the HTTP server serves HTTPS with, along with tls_key_file"},
+	{"tls_key_file", "", "PEM private key of tls_cert_file"},
+	{"tls_autocert_domains", []string{}, "domains to serve HTTPS for with certificates from Let's Encrypt, instead of tls_cert_file"},
+	{"tls_autocert_email", "", "contact address given to Let's Encrypt for expiry and problem notices"},
+	{"tls_autocert_cache_dir", "certs", "directory Let's Encrypt certificates and the account key are kept in"},
+	{"tls_redirect_addr", "", "address of a plain HTTP listener redirecting to HTTPS and answering Let's Encrypt challenges, such as :80"},
+	{"database_driver", "postgres", "where users are kept: postgres; sqlite, to keep them in a single database file; or memory, to keep them in process memory until exit. Both of the last leave out the features needing Postgres (tenants, organizations, posts, flags, consents, exports, webhooks, jobs, audit log, idempotency keys)"},
+	{"database_url", "", "Postgres connection string, or the path of the database file for the sqlite driver (required for both)"},
+	{"database_replica_urls", []string{}, "connection strings of read replicas serving user reads and searches in turn"},
+	{"db_max_open_conns", 25, "maximum open database connections, 0 for the larger of 4 and the number of CPUs"},
+	{"db_min_idle_conns", 2, "idle database connections kept open for the next queries"},
+	{"db_conn_max_lifetime", 30 * time.Minute, "maximum lifetime of a database connection, 0 to keep forever"},
+	{"db_conn_max_idle_time", 5 * time.Minute, "how long a database connection may sit idle, 0 to keep forever"},
+	{"db_connect_timeout", time.Minute, "how long to keep retrying the database at startup, 0 to try once"},
+	{"db_health_interval", 10 * time.Second, "how often the database is pinged to notice it going away"},
+	{"db_breaker_failures", 5, "failed database queries in a row that fail the next ones fast with 503, 0 to never"},
+	{"db_breaker_cooldown", 10 * time.Second, "how long queries fail fast before the database is tried again"},
+	{"auto_migrate", true, "apply pending migrations on startup"},
+	{"migrate", "", "run a migration command (up, up-by-one, down, redo, status) and exit"},
+	{"pii_keys", "", "comma-separated id=base64 32-byte keys encrypting user emails and phone numbers, the one encrypting new values first; keep old keys until reencrypt_pii has run. sorting by email then follows the ciphertext and email search matches whole addresses only"},
+	{"pii_index_key", "", "base64 32-byte key hashing encrypted emails for lookups (required with pii_keys, can't be changed)"},
+	{"reencrypt_pii", false, "encrypt user emails and phone numbers with the first of pii_keys, then exit"},
+	{"jwt_secret", "", "key used to sign access tokens (required)"},
+	{"log_level", "info", "log level: debug, info, warn or error"},
+	{"public_url", "http://localhost:8000", "externally reachable base URL of the API, used in links sent by email"},
+	{"password_reset_url", "http://localhost:3000/reset-password", "frontend page password reset emails link to, given the token as ?token="},
+	{"invitation_url", "http://localhost:3000/invitations", "frontend page organization invitation emails link to, given the token as ?token="},
+	{"terms_version", "", "current version of the terms of service users accept, such as 2026-10; empty for none"},
+	{"terms_url", "", "where the current terms of service can be read"},
+	{"privacy_version", "", "current version of the privacy policy users accept; empty for none"},
+	{"privacy_url", "", "where the current privacy policy can be read"},
+	{"require_consent", false, "answer authenticated requests 403 until the user has accepted the current terms_version and privacy_version"},
+	{"smtp_host", "", "SMTP server email is sent through; when unset, emails are only logged"},
+	{"smtp_port", 587, "SMTP server port"},
+	{"smtp_username", "", "SMTP login, if the server requires one"},
+	{"smtp_password", "", "SMTP password"},
+	{"oauth_google_client_id", "", "client id of the Google OAuth client offering sign-in with Google; empty disables it"},
+	{"oauth_google_client_secret", "", "client secret of the Google OAuth client"},
+	{"oauth_github_client_id", "", "client id of the GitHub OAuth app offering sign-in with GitHub; empty disables it"},
+	{"oauth_github_client_secret", "", "client secret of the GitHub OAuth app"},
+	{"oauth_redirect_url", "", "frontend page OAuth sign-ins end on, given the tokens or error in the fragment; empty answers with JSON"},
+	{"auth_backend", "local", "what checks login passwords: local, or ldap for a directory such as Active Directory"},
+	{"ldap_url", "", "directory to check logins against, as ldap://host:389 or ldaps://host:636"},
+	{"ldap_start_tls", false, "upgrade an ldap:// connection with StartTLS before binding"},
+	{"ldap_bind_dn", "", "service account that searches the directory for users; empty binds anonymously"},
+	{"ldap_bind_password", "", "password of the ldap_bind_dn account"},
+	{"ldap_base_dn", "", "where the search for users starts, such as DC=corp,DC=example,DC=com"},
+	{"ldap_user_filter", "(&(objectClass=user)(|(sAMAccountName={login})(userPrincipalName={login})(mail={login})))", "filter finding the entry for a login, with {login} in its place"},
+	{"ldap_email_attribute", "mail", "directory attribute holding a user's email"},
+	{"ldap_name_attribute", "displayName", "directory attribute holding a user's name"},
+	{"ldap_timeout", 10 * time.Second, "how long the directory has to answer each login"},
+	{"mail_from", "", "sender address of the emails the API sends; required with smtp_host"},
+	{"cors_allowed_origins", []string{"*"}, "origins allowed to make cross-site requests, * for any; list the frontend's origin in production"},
+	{"cors_allowed_methods", []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}, "methods cross-site requests may use"},
+	{"cors_allowed_headers", []string{"Content-Type", "Authorization", "If-Match", "If-None-Match", "If-Modified-Since", "Idempotency-Key", "X-Request-ID", "X-CSRF-Token"}, "request headers cross-site requests may send"},
+	{"cors_exposed_headers", []string{"ETag", "Last-Modified", "Idempotent-Replayed", "X-Request-ID", "Deprecation", "Link", "X-Server-Version"}, "response headers cross-site scripts may read"},
+	{"cors_allow_credentials", false, "let cross-site requests carry cookies; needs explicit cors_allowed_origins"},
+	{"cors_max_age", 10 * time.Minute, "how long browsers may cache a CORS preflight, 0 to leave it to the browser"},
+	// the API serves JSON and uploaded images, never pages, so nothing needs to load or frame it;
+	// the embedded frontend sends a policy of its own
+	{"content_security_policy", "default-src 'none'; frame-ancestors 'none'", "Content-Security-Policy sent on every response, empty to omit it"},
+	{"hsts_max_age", 365 * 24 * time.Hour, "max-age of Strict-Transport-Security, 0 to omit it (e.g. in development over plain HTTP)"},
+	{"serve_frontend", true, "serve the frontend at / when the binary was built with one embedded"},
+	{"locales_dir", "", "directory of message catalogs for error responses, one <language tag>.json each, adding to or overriding the built-in Spanish and Amharic ones"},
+	{"compression_min_size", 1024, "JSON responses at least this many bytes are gzip or deflate compressed for clients that accept it; negative to disable"},
+	{"max_body_size", 1 << 20, "largest JSON request body accepted, in bytes; uploads have their own limits"},
+	{"read_timeout", 15 * time.Second, "maximum time to read a request, including the body"},
+	// streaming endpoints stay open indefinitely, so there is no write timeout unless one is configured
+	{"write_timeout", time.Duration(0), "maximum time to write a response, 0 for none"},
+	{"idle_timeout", 60 * time.Second, "how long keep-alive connections may sit idle"},
+	{"shutdown_timeout", 15 * time.Second, "how long in-flight requests may drain on shutdown"},
+	{"request_timeout", 10 * time.Second, "deadline for handling a request, cancelling its database queries; streams are exempt. 0 for none"},
+	{"trusted_proxies", []string{}, "CIDRs or addresses of load balancers whose X-Forwarded-For and X-Real-IP give the client's address"},
+	{"rate_limit_rps", 10.0, "per-IP requests per second under /api, 0 to disable"},
+	{"rate_limit_burst", 20, "per-IP burst allowance"},
+	{"redis_url", "", "Redis URL; when set the rate limit and user cache are shared across replicas"},
+	{"cache_ttl", time.Duration(0), "how long user lookups are cached, in Redis when configured and in process otherwise; 0 to disable"},
+	{"cache_size", 10000, "maximum entries in the in-process user cache"},
+	{"idempotency_ttl", 24 * time.Hour, "how long responses to requests with an Idempotency-Key are kept for retries; 0 to ignore the header"},
+	{"outbox_retention", 7 * 24 * time.Hour, "how long user events are kept in the outbox once they have been published"},
+	{"webhook_max_attempts", 8, "times a webhook delivery is tried before it is marked failed, backing off from 30s between tries; 0 disables webhooks"},
+	{"webhook_timeout", 10 * time.Second, "how long a webhook receiver has to answer a delivery"},
+	{"webhook_allow_private", false, "let webhooks deliver to loopback, private, link-local and cloud metadata addresses, such as receivers on a development machine"},
+	{"webhook_delivery_retention", 30 * 24 * time.Hour, "how long sent and failed webhook deliveries are kept for inspection; 0 to keep them"},
+	{"job_workers", 4, "background jobs, such as sending email, run at once; 0 to do that work while the request waits"},
+	{"job_retention", 7 * 24 * time.Hour, "how long finished background jobs are kept for inspection"},
+	{"purge_deleted_users_after", time.Duration(0), "how long soft-deleted users are kept before they are removed for good; 0 to keep them"},
+	{"purge_deleted_users_interval", 24 * time.Hour, "how often users deleted longer ago than purge_deleted_users_after are removed"},
+	{"reset_token_cleanup_interval", time.Hour, "how often used and expired password reset tokens are removed; 0 to keep them"},
+	{"session_cleanup_interval", time.Hour, "how often expired refresh tokens and ended sessions are removed; 0 to keep them"},
+	{"login_history_retention", 90 * 24 * time.Hour, "how long login attempts are kept in users' login history; 0 to keep them"},
+	{"lockout_threshold", 5, "failed logins in a row that lock an account; 0 never locks"},
+	{"lockout_duration", 15 * time.Minute, "how long a locked account refuses logins"},
+	{"password_min_length", 8, "fewest characters a new password may have"},
+	{"password_required_classes", []string{}, "character classes a new password must each contain one of: upper, lower, digit, symbol"},
+	{"password_block_common", true, "refuse new passwords found in the list of common passwords"},
+	{"password_block_email", true, "refuse new passwords that contain the account's email address"},
+	{"events_broker", "", "message broker user events are published to: nats or kafka; empty to publish none"},
+	{"events_url", "", "NATS server URL, or comma-separated Kafka broker addresses"},
+	{"events_topic", "users", "NATS subject or Kafka topic user events are published on"},
+	{"events_format", "json", "encoding of published user events: json or protobuf"},
+	{"storage_backend", "local", "where uploaded avatars are kept: local or s3"},
+	{"storage_dir", "uploads", "directory for uploaded files with the local storage backend"},
+	{"storage_base_url", "", "public URL prefix of uploaded files; defaults to /uploads on this server, or the bucket's S3 URL"},
+	{"storage_s3_bucket", "", "bucket for uploaded files with the s3 storage backend; credentials and region come from the standard AWS_* settings"},
+	{"export_dir", "exports", "directory for the files of user exports with the local storage backend, which unlike storage_dir is never served; with s3 they are kept in the bucket under user-exports/, which must not be publicly readable"},
+	{"default_avatar", "gravatar", "avatar_url of users who haven't uploaded one: gravatar, initials, or none to leave it null"},
+	{"normalize_names", false, "title-case user names on write"},
+	{"masked_user_fields", []string{}, "user fields (email, phone, created_at, updated_at, email_verified_at, deleted_at) masked for callers other than the user, as field or field:role|role naming the roles that see it in full, admin by default"},
+	{"search_similarity_threshold", 0.3, "minimum word similarity for ?mode=fuzzy search"},
+}
+
+// Load parses args (normally os.Args[1:]) and merges them with the environment and the config
+// file named by --config or CONFIG_FILE, then validates the result.
+func Load(args []string) (Config, error) {
+	fs := pflag.NewFlagSet("api", pflag.ContinueOnError)
+	configFile := fs.String("config", "", "path to a YAML, JSON or TOML config file")
+	for _, s := range settings {
+		name := strings.ReplaceAll(s.key, "_", "-")
+		switch v := s.value.(type) {
+		case string:
+			fs.String(name, v, s.usage)
+		case int:
+			fs.Int(name, v, s.usage)
+		case float64:
+			fs.Float64(name, v, s.usage)
+		case bool:
+			fs.Bool(name, v, s.usage)
+		case time.Duration:
+			fs.Duration(name, v, s.usage)
+		case []string:
+			fs.StringSlice(name, v, s.usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	v := viper.New()
+	v.AutomaticEnv()
+	for _, s := range settings {
+		if err := v.BindPFlag(s.key, fs.Lookup(strings.ReplaceAll(s.key, "_", "-"))); err != nil {
+			return Config{}, err
+		}
+	}
+	if *configFile == "" {
+		v.BindEnv("config_file")
+		*configFile = v.GetString("config_file")
+	}
+	if *configFile != "" {
+		v.SetConfigFile(*configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return Config{}, fmt.Errorf("read config file: %w", err)
+		}
+	}
+
+	c := Config{
+		HTTPAddr:                  v.GetString("http_addr"),
+		GRPCAddr:                  v.GetString("grpc_addr"),
+		DebugEndpoints:            v.GetBool("debug_endpoints"),
+		DebugAddr:                 v.GetString("debug_addr"),
+		TLSCertFile:               v.GetString("tls_cert_file"),
+		TLSKeyFile:                v.GetString("tls_key_file"),
+		TLSAutocertDomains:        splitList(v.GetStringSlice("tls_autocert_domains")),
+		TLSAutocertEmail:          v.GetString("tls_autocert_email"),
+		TLSAutocertCacheDir:       v.GetString("tls_autocert_cache_dir"),
+		TLSRedirectAddr:           v.GetString("tls_redirect_addr"),
+		DatabaseDriver:            strings.ToLower(v.GetString("database_driver")),
+		DatabaseURL:               v.GetString("database_url"),
+		DatabaseReplicas:          splitList(v.GetStringSlice("database_replica_urls")),
+		DBMaxOpenConns:            v.GetInt("db_max_open_conns"),
+		DBMinIdleConns:            v.GetInt("db_min_idle_conns"),
+		DBConnMaxLifetime:         v.GetDuration("db_conn_max_lifetime"),
+		DBConnMaxIdleTime:         v.GetDuration("db_conn_max_idle_time"),
+		DBConnectTimeout:          v.GetDuration("db_connect_timeout"),
+		DBHealthInterval:          v.GetDuration("db_health_interval"),
+		DBBreakerFailures:         v.GetInt("db_breaker_failures"),
+		DBBreakerCooldown:         v.GetDuration("db_breaker_cooldown"),
+		AutoMigrate:               v.GetBool("auto_migrate"),
+		Migrate:                   strings.ToLower(v.GetString("migrate")),
+		PIIKeys:                   v.GetString("pii_keys"),
+		PIIIndexKey:               v.GetString("pii_index_key"),
+		ReencryptPII:              v.GetBool("reencrypt_pii"),
+		JWTSecret:                 v.GetString("jwt_secret"),
+		LogLevel:                  strings.ToLower(v.GetString("log_level")),
+		PublicURL:                 v.GetString("public_url"),
+		PasswordResetURL:          v.GetString("password_reset_url"),
+		InvitationURL:             v.GetString("invitation_url"),
+		TermsVersion:              v.GetString("terms_version"),
+		TermsURL:                  v.GetString("terms_url"),
+		PrivacyVersion:            v.GetString("privacy_version"),
+		PrivacyURL:                v.GetString("privacy_url"),
+		RequireConsent:            v.GetBool("require_consent"),
+		SMTPHost:                  v.GetString("smtp_host"),
+		SMTPPort:                  v.GetInt("smtp_port"),
+		SMTPUsername:              v.GetString("smtp_username"),
+		SMTPPassword:              v.GetString("smtp_password"),
+		OAuthGoogleClientID:       v.GetString("oauth_google_client_id"),
+		OAuthGoogleClientSecret:   v.GetString("oauth_google_client_secret"),
+		OAuthGitHubClientID:       v.GetString("oauth_github_client_id"),
+		OAuthGitHubClientSecret:   v.GetString("oauth_github_client_secret"),
+		OAuthRedirectURL:          v.GetString("oauth_redirect_url"),
+		AuthBackend:               strings.ToLower(v.GetString("auth_backend")),
+		LDAPURL:                   v.GetString("ldap_url"),
+		LDAPStartTLS:              v.GetBool("ldap_start_tls"),
+		LDAPBindDN:                v.GetString("ldap_bind_dn"),
+		LDAPBindPassword:          v.GetString("ldap_bind_password"),
+		LDAPBaseDN:                v.GetString("ldap_base_dn"),
+		LDAPUserFilter:            v.GetString("ldap_user_filter"),
+		LDAPEmailAttribute:        v.GetString("ldap_email_attribute"),
+		LDAPNameAttribute:         v.GetString("ldap_name_attribute"),
+		LDAPTimeout:               v.GetDuration("ldap_timeout"),
+		MailFrom:                  v.GetString("mail_from"),
+		CORSAllowedOrigins:        splitList(v.GetStringSlice("cors_allowed_origins")),
+		CORSAllowedMethods:        splitList(v.GetStringSlice("cors_allowed_methods")),
+		CORSAllowedHeaders:        splitList(v.GetStringSlice("cors_allowed_headers")),
+		CORSExposedHeaders:        splitList(v.GetStringSlice("cors_exposed_headers")),
+		CORSAllowCredentials:      v.GetBool("cors_allow_credentials"),
+		CORSMaxAge:                v.GetDuration("cors_max_age"),
+		ContentSecurityPolicy:     v.GetString("content_security_policy"),
+		HSTSMaxAge:                v.GetDuration("hsts_max_age"),
+		ServeFrontend:             v.GetBool("serve_frontend"),
+		LocalesDir:                v.GetString("locales_dir"),
+		CompressionMinSize:        v.GetInt("compression_min_size"),
+		MaxBodySize:               v.GetInt("max_body_size"),
+		ReadTimeout:               v.GetDuration("read_timeout"),
+		WriteTimeout:              v.GetDuration("write_timeout"),
+		IdleTimeout:               v.GetDuration("idle_timeout"),
+		ShutdownTimeout:           v.GetDuration("shutdown_timeout"),
+		RequestTimeout:            v.GetDuration("request_timeout"),
+		TrustedProxies:            splitList(v.GetStringSlice("trusted_proxies")),
+		RateLimitRPS:              v.GetFloat64("rate_limit_rps"),
+		RateLimitBurst:            v.GetInt("rate_limit_burst"),
+		RedisURL:                  v.GetString("redis_url"),
+		CacheTTL:                  v.GetDuration("cache_ttl"),
+		CacheSize:                 v.GetInt("cache_size"),
+		IdempotencyTTL:            v.GetDuration("idempotency_ttl"),
+		OutboxRetention:           v.GetDuration("outbox_retention"),
+		WebhookMaxAttempts:        v.GetInt("webhook_max_attempts"),
+		WebhookTimeout:            v.GetDuration("webhook_timeout"),
+		WebhookAllowPrivate:       v.GetBool("webhook_allow_private"),
+		WebhookDeliveryRetention:  v.GetDuration("webhook_delivery_retention"),
+		JobWorkers:                v.GetInt("job_workers"),
+		JobRetention:              v.GetDuration("job_retention"),
+		PurgeDeletedUsersAfter:    v.GetDuration("purge_deleted_users_after"),
+		PurgeDeletedUsersInterval: v.GetDuration("purge_deleted_users_interval"),
+		ResetTokenCleanupInterval: v.GetDuration("reset_token_cleanup_interval"),
+		SessionCleanupInterval:    v.GetDuration("session_cleanup_interval"),
+		LoginHistoryRetention:     v.GetDuration("login_history_retention"),
+		LockoutThreshold:          v.GetInt("lockout_threshold"),
+		LockoutDuration:           v.GetDuration("lockout_duration"),
+		PasswordMinLength:         v.GetInt("password_min_length"),
+		PasswordRequiredClasses:   splitList(v.GetStringSlice("password_required_classes")),
+		PasswordBlockCommon:       v.GetBool("password_block_common"),
+		PasswordBlockEmail:        v.GetBool("password_block_email"),
+		EventsBroker:              strings.ToLower(v.GetString("events_broker")),
+		EventsURL:                 v.GetString("events_url"),
+		EventsTopic:               v.GetString("events_topic"),
+		EventsFormat:              strings.ToLower(v.GetString("events_format")),
+		StorageBackend:            strings.ToLower(v.GetString("storage_backend")),
+		StorageDir:                v.GetString("storage_dir"),
+		StorageBaseURL:            v.GetString("storage_base_url"),
+		StorageS3Bucket:           v.GetString("storage_s3_bucket"),
+		ExportDir:                 v.GetString("export_dir"),
+		DefaultAvatar:             strings.ToLower(v.GetString("default_avatar")),
+		NormalizeNames:            v.GetBool("normalize_names"),
+		SearchSimilarityThreshold: v.GetFloat64("search_similarity_threshold"),
+		MaskedUserFields:          parseMasks(splitList(v.GetStringSlice("masked_user_fields"))),
+	}
+	return c, c.Validate()
+}
+
+// env vars arrive as one comma-separated string rather than a list
+func splitList(in []string) []string {
+	var out []string
+	for _, item := range in {
+		for _, part := range strings.Split(item, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// a host:port whose host is localhost or a loopback IP
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+// field:role|role entries keyed by field; a field listed without roles is seen in full by admins
+func parseMasks(entries []string) map[string][]string {
+	if len(entries) == 0 {
+		return nil
+	}
+	masks := make(map[string][]string, len(entries))
+	for _, entry := range entries {
+		field, list, _ := strings.Cut(entry, ":")
+		var roles []string
+		for _, role := range strings.Split(list, "|") {
+			if role = strings.TrimSpace(role); role != "" {
+				roles = append(roles, role)
+			}
+		}
+		if len(roles) == 0 {
+			roles = []string{"admin"}
+		}
+		field = strings.ToLower(strings.TrimSpace(field))
+		masks[field] = append(masks[field], roles...)
+	}
+	return masks
+}
+
+// TLS reports whether the HTTP server serves HTTPS, with certificate files or from Let's Encrypt.
+func (c Config) TLS() bool {
+	return c.TLSCertFile != "" || len(c.TLSAutocertDomains) > 0
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	if c.HTTPAddr == "" {
+		errs = append(errs, errors.New("http_addr must be set"))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls_cert_file and tls_key_file must be set together"))
+	}
+	if c.TLSCertFile != "" && len(c.TLSAutocertDomains) > 0 {
+		errs = append(errs, errors.New("tls_cert_file and tls_autocert_domains can't both be set"))
+	}
+	if len(c.TLSAutocertDomains) > 0 && c.TLSAutocertCacheDir == "" {
+		errs = append(errs, errors.New("tls_autocert_cache_dir must be set with tls_autocert_domains"))
+	}
+	if c.TLSRedirectAddr != "" && !c.TLS() {
+		errs = append(errs, errors.New("tls_redirect_addr needs tls_cert_file or tls_autocert_domains"))
+	}
+	if c.GRPCAddr == "" {
+		errs = append(errs, errors.New("grpc_addr must be set"))
+	}
+	// anyone who can reach the debug listener can profile the server
+	if c.DebugAddr != "" && !isLoopback(c.DebugAddr) {
+		errs = append(errs, fmt.Errorf("debug_addr %q must be a loopback address, such as 127.0.0.1:6060", c.DebugAddr))
+	}
+	switch c.DatabaseDriver {
+	case "postgres":
+		if c.DatabaseURL == "" {
+			errs = append(errs, errors.New("database_url must be set"))
+		}
+	case "sqlite":
+		if c.DatabaseURL == "" {
+			errs = append(errs, errors.New("database_url must be set"))
+		}
+		// the file is migrated like Postgres, but existing rows are only re-encrypted there
+		if c.ReencryptPII {
+			errs = append(errs, errors.New("reencrypt_pii needs the postgres database driver"))
+		}
+		if len(c.DatabaseReplicas) > 0 {
+			errs = append(errs, errors.New("database_replica_urls need the postgres database driver"))
+		}
+	case "memory":
+		// there is no database to migrate or re-encrypt
+		if c.Migrate != "" || c.ReencryptPII {
+			errs = append(errs, errors.New("migrate and reencrypt_pii need the postgres database driver"))
+		}
+		if len(c.DatabaseReplicas) > 0 {
+			errs = append(errs, errors.New("database_replica_urls need the postgres database driver"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("database_driver must be postgres, sqlite or memory, got %q", c.DatabaseDriver))
+	}
+	if c.DBMaxOpenConns < 0 {
+		errs = append(errs, errors.New("db_max_open_conns must not be negative"))
+	}
+	if c.DBHealthInterval <= 0 {
+		errs = append(errs, errors.New("db_health_interval must be positive"))
+	}
+	if c.DBBreakerFailures < 0 {
+		errs = append(errs, errors.New("db_breaker_failures must not be negative"))
+	}
+	if c.DBBreakerFailures > 0 && c.DBBreakerCooldown <= 0 {
+		errs = append(errs, errors.New("db_breaker_cooldown must be positive"))
+	}
+	if c.DBMinIdleConns < 0 {
+		errs = append(errs, errors.New("db_min_idle_conns must not be negative"))
+	}
+	if c.DBMaxOpenConns > 0 && c.DBMinIdleConns > c.DBMaxOpenConns {
+		errs = append(errs, errors.New("db_min_idle_conns must not exceed db_max_open_conns"))
+	}
+	switch c.Migrate {
+	case "", "up", "up-by-one", "down", "redo", "status":
+	default:
+		errs = append(errs, fmt.Errorf("migrate must be up, up-by-one, down, redo or status, got %q", c.Migrate))
+	}
+	if c.PIIKeys != "" && c.PIIIndexKey == "" {
+		errs = append(errs, errors.New("pii_index_key must be set with pii_keys"))
+	}
+	if c.ReencryptPII && c.PIIKeys == "" {
+		errs = append(errs, errors.New("reencrypt_pii needs pii_keys"))
+	}
+	// migration and re-encryption commands never start the server, so they don't need its secret
+	if c.JWTSecret == "" && c.Migrate == "" && !c.ReencryptPII {
+		errs = append(errs, errors.New("jwt_secret must be set"))
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log_level must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+	for _, u := range []struct{ name, value string }{
+		{"public_url", c.PublicURL},
+		{"password_reset_url", c.PasswordResetURL},
+		{"invitation_url", c.InvitationURL},
+	} {
+		if parsed, err := url.Parse(u.value); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("%s must be an absolute URL, got %q", u.name, u.value))
+		}
+	}
+	if c.RequireConsent && c.TermsVersion == "" && c.PrivacyVersion == "" {
+		errs = append(errs, errors.New("require_consent needs terms_version or privacy_version"))
+	}
+	for _, u := range []struct{ name, value string }{
+		{"terms_url", c.TermsURL},
+		{"privacy_url", c.PrivacyURL},
+	} {
+		if parsed, err := url.Parse(u.value); u.value != "" && (err != nil || parsed.Scheme == "" || parsed.Host == "") {
+			errs = append(errs, fmt.Errorf("%s must be an absolute URL, got %q", u.name, u.value))
+		}
+	}
+	if c.OAuthRedirectURL != "" {
+		if parsed, err := url.Parse(c.OAuthRedirectURL); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("oauth_redirect_url must be an absolute URL, got %q", c.OAuthRedirectURL))
+		}
+	}
+	for _, p := range []struct{ name, id, secret string }{
+		{"google", c.OAuthGoogleClientID, c.OAuthGoogleClientSecret},
+		{"github", c.OAuthGitHubClientID, c.OAuthGitHubClientSecret},
+	} {
+		if p.id != "" && p.secret == "" {
+			errs = append(errs, fmt.Errorf("oauth_%s_client_secret must be set along with oauth_%s_client_id", p.name, p.name))
+		}
+	}
+	switch c.AuthBackend {
+	case "local":
+	case "ldap":
+		if parsed, err := url.Parse(c.LDAPURL); err != nil || (parsed.Scheme != "ldap" && parsed.Scheme != "ldaps") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("ldap_url must be an ldap:// or ldaps:// URL, got %q", c.LDAPURL))
+		} else if c.LDAPStartTLS && parsed.Scheme == "ldaps" {
+			errs = append(errs, errors.New("ldap_start_tls only applies to ldap:// URLs"))
+		}
+		if c.LDAPBaseDN == "" {
+			errs = append(errs, errors.New("ldap_base_dn must be set for the ldap auth backend"))
+		}
+		if !strings.Contains(c.LDAPUserFilter, "{login}") {
+			errs = append(errs, errors.New("ldap_user_filter must contain {login}"))
+		}
+		if c.LDAPEmailAttribute == "" {
+			errs = append(errs, errors.New("ldap_email_attribute must be set for the ldap auth backend"))
+		}
+		if c.LDAPTimeout <= 0 {
+			errs = append(errs, errors.New("ldap_timeout must be positive"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("auth_backend must be local or ldap, got %q", c.AuthBackend))
+	}
+	if c.SMTPHost != "" {
+		if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+			errs = append(errs, errors.New("smtp_port must be between 1 and 65535"))
+		}
+		if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+			errs = append(errs, fmt.Errorf("mail_from must be an email address when smtp_host is set, got %q", c.MailFrom))
+		}
+	}
+	if len(c.CORSAllowedOrigins) == 0 {
+		errs = append(errs, errors.New("cors_allowed_origins must list at least one origin"))
+	}
+	// echoing every origin with credentials would let any site act as a signed-in user
+	if c.CORSAllowCredentials && slices.Contains(c.CORSAllowedOrigins, "*") {
+		errs = append(errs, errors.New("cors_allow_credentials needs explicit cors_allowed_origins, not *"))
+	}
+	if len(c.CORSAllowedMethods) == 0 {
+		errs = append(errs, errors.New("cors_allowed_methods must list at least one method"))
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"db_conn_max_lifetime", c.DBConnMaxLifetime},
+		{"db_conn_max_idle_time", c.DBConnMaxIdleTime},
+		{"db_connect_timeout", c.DBConnectTimeout},
+		{"read_timeout", c.ReadTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"request_timeout", c.RequestTimeout},
+		{"cache_ttl", c.CacheTTL},
+		{"cors_max_age", c.CORSMaxAge},
+		{"hsts_max_age", c.HSTSMaxAge},
+		{"idempotency_ttl", c.IdempotencyTTL},
+		{"outbox_retention", c.OutboxRetention},
+		{"job_retention", c.JobRetention},
+		{"purge_deleted_users_after", c.PurgeDeletedUsersAfter},
+		{"purge_deleted_users_interval", c.PurgeDeletedUsersInterval},
+		{"reset_token_cleanup_interval", c.ResetTokenCleanupInterval},
+		{"session_cleanup_interval", c.SessionCleanupInterval},
+		{"login_history_retention", c.LoginHistoryRetention},
+		{"webhook_delivery_retention", c.WebhookDeliveryRetention},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", d.name))
+		}
+	}
+	if c.RateLimitRPS < 0 {
+		errs = append(errs, errors.New("rate_limit_rps must not be negative"))
+	}
+	if c.RateLimitBurst < 1 {
+		errs = append(errs, errors.New("rate_limit_burst must be at least 1"))
+	}
+	if c.MaxBodySize < 1 {
+		errs = append(errs, errors.New("max_body_size must be at least 1"))
+	}
+	if c.WebhookMaxAttempts < 0 {
+		errs = append(errs, errors.New("webhook_max_attempts must not be negative"))
+	}
+	if c.WebhookMaxAttempts > 0 && c.WebhookTimeout <= 0 {
+		errs = append(errs, errors.New("webhook_timeout must be positive"))
+	}
+	if c.LockoutThreshold < 0 {
+		errs = append(errs, errors.New("lockout_threshold must not be negative"))
+	}
+	if c.LockoutThreshold > 0 && c.LockoutDuration <= 0 {
+		errs = append(errs, errors.New("lockout_duration must be positive"))
+	}
+	if c.PasswordMinLength < 1 {
+		errs = append(errs, errors.New("password_min_length must be positive"))
+	}
+	for _, class := range c.PasswordRequiredClasses {
+		if !slices.Contains(passwordClasses, class) {
+			errs = append(errs, fmt.Errorf("password_required_classes must be among %s, got %q", strings.Join(passwordClasses, ", "), class))
+		}
+	}
+	if c.JobWorkers < 0 {
+		errs = append(errs, errors.New("job_workers must not be negative"))
+	}
+	switch c.EventsBroker {
+	case "":
+	case "nats", "kafka":
+		if c.EventsURL == "" {
+			errs = append(errs, fmt.Errorf("events_url must be set for the %s events broker", c.EventsBroker))
+		}
+		if c.EventsTopic == "" {
+			errs = append(errs, errors.New("events_topic must be set when an events broker is configured"))
+		}
+		if c.EventsFormat != "json" && c.EventsFormat != "protobuf" {
+			errs = append(errs, fmt.Errorf("events_format must be json or protobuf, got %q", c.EventsFormat))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("events_broker must be nats or kafka, got %q", c.EventsBroker))
+	}
+	if c.CacheSize < 1 {
+		errs = append(errs, errors.New("cache_size must be at least 1"))
+	}
+	switch c.StorageBackend {
+	case "local":
+		if c.StorageDir == "" {
+			errs = append(errs, errors.New("storage_dir must be set for the local storage backend"))
+		}
+		// everything under storage_dir is served to anyone
+		if rel, err := filepath.Rel(filepath.Clean(c.StorageDir), filepath.Clean(c.ExportDir)); c.ExportDir == "" {
+			errs = append(errs, errors.New("export_dir must be set for the local storage backend"))
+		} else if err == nil && !strings.HasPrefix(rel, "..") {
+			errs = append(errs, errors.New("export_dir must not be storage_dir or inside it"))
+		}
+	case "s3":
+		if c.StorageS3Bucket == "" {
+			errs = append(errs, errors.New("storage_s3_bucket must be set for the s3 storage backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage_backend must be local or s3, got %q", c.StorageBackend))
+	}
+	for field := range c.MaskedUserFields {
+		if !slices.Contains(maskableFields, field) {
+			errs = append(errs, fmt.Errorf("masked_user_fields must be among %s, got %q", strings.Join(maskableFields, ", "), field))
+		}
+	}
+	if c.DefaultAvatar != "gravatar" && c.DefaultAvatar != "initials" && c.DefaultAvatar != "none" {
+		errs = append(errs, fmt.Errorf("default_avatar must be gravatar, initials or none, got %q", c.DefaultAvatar))
+	}
+	if c.SearchSimilarityThreshold < 0 || c.SearchSimilarityThreshold > 1 {
+		errs = append(errs, errors.New("search_similarity_threshold must be between 0 and 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// LogValue prints the effective config with secrets and credentials redacted.
+func (c Config) LogValue() slog.Value {
+	secret := ""
+	if c.JWTSecret != "" {
+		secret = "[redacted]"
+	}
+	smtpPassword := ""
+	if c.SMTPPassword != "" {
+		smtpPassword = "[redacted]"
+	}
+	piiKeys, piiIndexKey := "", ""
+	if c.PIIKeys != "" {
+		piiKeys = "[redacted]"
+	}
+	if c.PIIIndexKey != "" {
+		piiIndexKey = "[redacted]"
+	}
+	googleSecret, githubSecret, ldapPassword := "", "", ""
+	if c.OAuthGoogleClientSecret != "" {
+		googleSecret = "[redacted]"
+	}
+	if c.OAuthGitHubClientSecret != "" {
+		githubSecret = "[redacted]"
+	}
+	if c.LDAPBindPassword != "" {
+		ldapPassword = "[redacted]"
+	}
+	replicas := make([]string, len(c.DatabaseReplicas))
+	for i, u := range c.DatabaseReplicas {
+		replicas[i] = redactURL(u)
+	}
+	// Kafka broker lists aren't URLs and carry no credentials
+	eventsURL := c.EventsURL
+	if strings.Contains(eventsURL, "://") {
+		eventsURL = redactURL(eventsURL)
+	}
+	return slog.GroupValue(
+		slog.String("http_addr", c.HTTPAddr),
+		slog.String("grpc_addr", c.GRPCAddr),
+		slog.Bool("debug_endpoints", c.DebugEndpoints),
+		slog.String("debug_addr", c.DebugAddr),
+		slog.String("tls_cert_file", c.TLSCertFile),
+		slog.String("tls_key_file", c.TLSKeyFile),
+		slog.Any("tls_autocert_domains", c.TLSAutocertDomains),
+		slog.String("tls_autocert_email", c.TLSAutocertEmail),
+		slog.String("tls_autocert_cache_dir", c.TLSAutocertCacheDir),
+		slog.String("tls_redirect_addr", c.TLSRedirectAddr),
+		slog.String("database_driver", c.DatabaseDriver),
+		slog.String("database_url", redactURL(c.DatabaseURL)),
+		slog.Any("database_replica_urls", replicas),
+		slog.Int("db_max_open_conns", c.DBMaxOpenConns),
+		slog.Int("db_min_idle_conns", c.DBMinIdleConns),
+		slog.String("db_conn_max_lifetime", c.DBConnMaxLifetime.String()),
+		slog.String("db_conn_max_idle_time", c.DBConnMaxIdleTime.String()),
+		slog.String("db_connect_timeout", c.DBConnectTimeout.String()),
+		slog.String("db_health_interval", c.DBHealthInterval.String()),
+		slog.Int("db_breaker_failures", c.DBBreakerFailures),
+		slog.String("db_breaker_cooldown", c.DBBreakerCooldown.String()),
+		slog.Bool("auto_migrate", c.AutoMigrate),
+		slog.String("pii_keys", piiKeys),
+		slog.String("pii_index_key", piiIndexKey),
+		slog.String("jwt_secret", secret),
+		slog.String("log_level", c.LogLevel),
+		slog.String("public_url", c.PublicURL),
+		slog.String("password_reset_url", c.PasswordResetURL),
+		slog.String("invitation_url", c.InvitationURL),
+		slog.String("terms_version", c.TermsVersion),
+		slog.String("terms_url", c.TermsURL),
+		slog.String("privacy_version", c.PrivacyVersion),
+		slog.String("privacy_url", c.PrivacyURL),
+		slog.Bool("require_consent", c.RequireConsent),
+		slog.String("smtp_host", c.SMTPHost),
+		slog.Int("smtp_port", c.SMTPPort),
+		slog.String("smtp_username", c.SMTPUsername),
+		slog.String("smtp_password", smtpPassword),
+		slog.String("mail_from", c.MailFrom),
+		slog.String("oauth_google_client_id", c.OAuthGoogleClientID),
+		slog.String("oauth_google_client_secret", googleSecret),
+		slog.String("oauth_github_client_id", c.OAuthGitHubClientID),
+		slog.String("oauth_github_client_secret", githubSecret),
+		slog.String("oauth_redirect_url", c.OAuthRedirectURL),
+		slog.String("auth_backend", c.AuthBackend),
+		slog.String("ldap_url", c.LDAPURL),
+		slog.Bool("ldap_start_tls", c.LDAPStartTLS),
+		slog.String("ldap_bind_dn", c.LDAPBindDN),
+		slog.String("ldap_bind_password", ldapPassword),
+		slog.String("ldap_base_dn", c.LDAPBaseDN),
+		slog.String("ldap_user_filter", c.LDAPUserFilter),
+		slog.String("ldap_email_attribute", c.LDAPEmailAttribute),
+		slog.String("ldap_name_attribute", c.LDAPNameAttribute),
+		slog.String("ldap_timeout", c.LDAPTimeout.String()),
+		slog.Any("cors_allowed_origins", c.CORSAllowedOrigins),
+		slog.Any("cors_allowed_methods", c.CORSAllowedMethods),
+		slog.Any("cors_allowed_headers", c.CORSAllowedHeaders),
+		slog.Any("cors_exposed_headers", c.CORSExposedHeaders),
+		slog.Bool("cors_allow_credentials", c.CORSAllowCredentials),
+		slog.String("cors_max_age", c.CORSMaxAge.String()),
+		slog.String("content_security_policy", c.ContentSecurityPolicy),
+		slog.String("hsts_max_age", c.HSTSMaxAge.String()),
+		slog.Bool("serve_frontend", c.ServeFrontend),
+		slog.String("locales_dir", c.LocalesDir),
+		slog.Int("compression_min_size", c.CompressionMinSize),
+		slog.Int("max_body_size", c.MaxBodySize),
+		slog.String("read_timeout", c.ReadTimeout.String()),
+		slog.String("write_timeout", c.WriteTimeout.String()),
+		slog.String("idle_timeout", c.IdleTimeout.String()),
+		slog.String("shutdown_timeout", c.ShutdownTimeout.String()),
+		slog.String("request_timeout", c.RequestTimeout.String()),
+		slog.Any("trusted_proxies", c.TrustedProxies),
+		slog.Float64("rate_limit_rps", c.RateLimitRPS),
+		slog.Int("rate_limit_burst", c.RateLimitBurst),
+		slog.String("redis_url", redactURL(c.RedisURL)),
+		slog.String("cache_ttl", c.CacheTTL.String()),
+		slog.Int("cache_size", c.CacheSize),
+		slog.String("idempotency_ttl", c.IdempotencyTTL.String()),
+		slog.String("outbox_retention", c.OutboxRetention.String()),
+		slog.Int("webhook_max_attempts", c.WebhookMaxAttempts),
+		slog.String("webhook_timeout", c.WebhookTimeout.String()),
+		slog.Bool("webhook_allow_private", c.WebhookAllowPrivate),
+		slog.String("webhook_delivery_retention", c.WebhookDeliveryRetention.String()),
+		slog.Int("job_workers", c.JobWorkers),
+		slog.String("job_retention", c.JobRetention.String()),
+		slog.String("purge_deleted_users_after", c.PurgeDeletedUsersAfter.String()),
+		slog.String("purge_deleted_users_interval", c.PurgeDeletedUsersInterval.String()),
+		slog.String("reset_token_cleanup_interval", c.ResetTokenCleanupInterval.String()),
+		slog.String("session_cleanup_interval", c.SessionCleanupInterval.String()),
+		slog.String("login_history_retention", c.LoginHistoryRetention.String()),
+		slog.Int("lockout_threshold", c.LockoutThreshold),
+		slog.String("lockout_duration", c.LockoutDuration.String()),
+		slog.Int("password_min_length", c.PasswordMinLength),
+		slog.Any("password_required_classes", c.PasswordRequiredClasses),
+		slog.Bool("password_block_common", c.PasswordBlockCommon),
+		slog.Bool("password_block_email", c.PasswordBlockEmail),
+		slog.String("events_broker", c.EventsBroker),
+		slog.String("events_url", eventsURL),
+		slog.String("events_topic", c.EventsTopic),
+		slog.String("events_format", c.EventsFormat),
+		slog.String("storage_backend", c.StorageBackend),
+		slog.String("storage_dir", c.StorageDir),
+		slog.String("storage_base_url", c.StorageBaseURL),
+		slog.String("storage_s3_bucket", c.StorageS3Bucket),
+		slog.String("export_dir", c.ExportDir),
+		slog.String("default_avatar", c.DefaultAvatar),
+		slog.Bool("normalize_names", c.NormalizeNames),
+		slog.Any("masked_user_fields", c.MaskedUserFields),
+		slog.Float64("search_similarity_threshold", c.SearchSimilarityThreshold),
+	)
+}
+
+// hide the password in a connection URL. key/value DSNs such as "host=db password=secret", which
+// url.Parse takes for a path, and anything unparsable are hidden entirely; a plain file path, as
+// the sqlite driver takes, is kept
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	if !strings.Contains(raw, "://") {
+		if strings.Contains(raw, "=") {
+			return "[redacted]"
+		}
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[redacted]"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestLogValueRedactsDatabaseURL(t *testing.T) {
+	for _, tc := range []struct {
+		url, want string
+	}{
+		{"postgres://app:secret@db:5432/app?sslmode=disable", "postgres://app:xxxxx@db:5432/app?sslmode=disable"},
+		{"host=db user=app password=secret dbname=app", "[redacted]"},
+		{"host=db user=app password='se cret'", "[redacted]"},
+		{"/var/lib/api/users.db", "/var/lib/api/users.db"},
+	} {
+		var buf bytes.Buffer
+		slog.New(slog.NewJSONHandler(&buf, nil)).Info("effective config", "config", Config{DatabaseDriver: "postgres", DatabaseURL: tc.url})
+		var logged struct {
+			Config struct {
+				DatabaseURL string `json:"database_url"`
+			} `json:"config"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &logged); err != nil {
+			t.Fatal(err)
+		}
+		if logged.Config.DatabaseURL != tc.want {
+			t.Errorf("logged database_url for %q = %q, want %q", tc.url, logged.Config.DatabaseURL, tc.want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Package codec writes response bodies as JSON, XML or MessagePack and reads XML and MessagePack
+// request bodies back as JSON, so handlers only deal in the JSON shape of their payloads.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/shamaton/msgpack/v2"
+)
+
+// the formats bodies can be written in, named by the media type responses are sent as
+const (
+	JSON    = "application/json"
+	XML     = "application/xml"
+	MsgPack = "application/msgpack"
+)
+
+// JSONAPI is JSON shaped as JSON:API documents. it's only offered to clients that name it in Accept,
+// and is written as plain JSON
+const JSONAPI = "application/vnd.api+json"
+
+// NDJSON is newline-delimited JSON, one value per line, for streamed collections
+const NDJSON = "application/x-ndjson"
+
+// media types understood for each format, in Content-Type and Accept alike
+var mediaTypes = map[string]string{
+	"application/json":        JSON,
+	"application/xml":         XML,
+	"text/xml":                XML,
+	"application/msgpack":     MsgPack,
+	"application/x-msgpack":   MsgPack,
+	"application/vnd.msgpack": MsgPack,
+}
+
+// the format of a media type, counting structured syntax suffixes such as +json and +xml
+func lookup(mediaType string) (string, bool) {
+	if f, ok := mediaTypes[mediaType]; ok {
+		return f, true
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return JSON, true
+	}
+	if strings.HasSuffix(mediaType, "+xml") {
+		return XML, true
+	}
+	return "", false
+}
+
+// Format is the format a body of the given Content-Type is written in, JSON for anything unrecognised.
+func Format(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if f, ok := lookup(mediaType); ok {
+		return f
+	}
+	return JSON
+}
+
+// Negotiate picks the media type a response should be written as from an Accept header: one of the
+// formats, or JSONAPI when asked for by name. JSON wins ties and is also the answer when nothing
+// acceptable is on offer, rather than refusing the request
+func Negotiate(accept string) string {
+	if accept == "" {
+		return JSON
+	}
+	best, bestQ := JSON, 0.0
+	for _, format := range []string{JSON, XML, MsgPack} {
+		if q := quality(accept, format); q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	if q := jsonAPIQuality(accept); q > 0 && q >= bestQ {
+		return JSONAPI
+	}
+	return best
+}
+
+// the q-value accept gives JSONAPI itself; wildcards and the other JSON types don't select it
+func jsonAPIQuality(accept string) float64 {
+	return namedQuality(accept, JSONAPI)
+}
+
+// the q-value accept gives any of mediaTypes by name, 0 when it names none of them
+func namedQuality(accept string, mediaTypes ...string) float64 {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || !slices.Contains(mediaTypes, mediaType) {
+			continue
+		}
+		if v, ok := params["q"]; ok {
+			q, _ := strconv.ParseFloat(v, 64)
+			return q
+		}
+		return 1
+	}
+	return 0
+}
+
+// Names reports whether accept explicitly accepts one of mediaTypes, as opposed to through a wildcard.
+func Names(accept string, mediaTypes ...string) bool {
+	return namedQuality(accept, mediaTypes...) > 0
+}
+
+// the q-value the most specific matching range in accept gives format, 0 when none matches
+func quality(accept, format string) float64 {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		s := -1
+		if f, ok := lookup(mediaType); ok && f == format {
+			s = 2
+		} else if major, ok := strings.CutSuffix(mediaType, "/*"); ok && major != "*" && hasMajorType(format, major) {
+			s = 1
+		} else if mediaType == "*/*" {
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return q
+}
+
+// whether any media type of format is under the major type, e.g. text for text/xml
+func hasMajorType(format, major string) bool {
+	for mediaType, f := range mediaTypes {
+		if f == format && strings.HasPrefix(mediaType, major+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Encode writes v to w in format. XML and MessagePack are rendered from v's JSON encoding, so
+// they carry the same names and values; XML documents are wrapped in a root element
+func Encode(w io.Writer, format string, root xml.Name, v interface{}) error {
+	if format == JSON {
+		return json.NewEncoder(w).Encode(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if format == XML {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		enc := xml.NewEncoder(w)
+		if err := writeXML(dec, enc, xml.StartElement{Name: root}); err != nil {
+			return err
+		}
+		return enc.Flush()
+	}
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+	return msgpack.MarshalWrite(w, msgpackValue(doc))
+}
+
+// translate the next JSON value into an element: object members become child elements, array
+// items become <item> elements and null an empty element
+func writeXML(dec *json.Decoder, enc *xml.Encoder, start xml.StartElement) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		for dec.More() {
+			child := xml.StartElement{Name: xml.Name{Local: "item"}}
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = memberElement(key.(string))
+			}
+			if err := writeXML(dec, enc, child); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	case string:
+		err = enc.EncodeToken(xml.CharData(t))
+	case json.Number:
+		err = enc.EncodeToken(xml.CharData(t.String()))
+	case bool:
+		err = enc.EncodeToken(xml.CharData(strconv.FormatBool(t)))
+	}
+	if err != nil {
+		return err
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// the element for an object member. keys that aren't XML names, such as numeric map keys,
+// become <entry key="..."> instead
+func memberElement(key string) xml.StartElement {
+	if validName(key) {
+		return xml.StartElement{Name: xml.Name{Local: key}}
+	}
+	return xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}}}
+}
+
+func validName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i, c := range s {
+		letter := c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if !letter && (i == 0 || !(c == '-' || c == '.' || c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// a decoded JSON value with its numbers as the integers or floats MessagePack has types for
+func msgpackValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, member := range t {
+			t[k] = msgpackValue(member)
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = msgpackValue(item)
+		}
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			return n
+		}
+		f, _ := t.Float64()
+		return f
+	}
+	return v
+}
+
+// SyntaxError reports a request body that isn't well-formed in the format it was sent as.
+type SyntaxError struct {
+	msg string
+}
+
+func (e *SyntaxError) Error() string { return e.msg }
+
+func syntaxError(format string, args ...interface{}) error {
+	return &SyntaxError{msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,233 @@
+package codec
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shamaton/msgpack/v2"
+)
+
+// ToJSON reads a body sent in format and re-encodes it as JSON for decoding into a value of type t.
+// MessagePack carries its own types; XML is all text, so t decides which elements are numbers,
+// booleans, arrays or objects. elements t has no field for are kept, so decoding can reject them
+func ToJSON(r io.Reader, format string, t reflect.Type) ([]byte, error) {
+	if format == MsgPack {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) == 0 {
+			return nil, io.EOF
+		}
+		var doc interface{}
+		if err := msgpack.Unmarshal(b, &doc); err != nil {
+			return nil, syntaxError("malformed MessagePack: %v", err)
+		}
+		return json.Marshal(jsonValue(doc))
+	}
+	root, err := parseXML(r)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(root.value(t))
+}
+
+// a MessagePack value as one encoding/json can marshal: maps keyed by strings and binary as text
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, member := range t {
+			m[fmt.Sprint(k)] = jsonValue(member)
+		}
+		return m
+	case map[string]interface{}:
+		for k, member := range t {
+			t[k] = jsonValue(member)
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = jsonValue(item)
+		}
+	case []byte:
+		return string(t)
+	}
+	return v
+}
+
+// an element of an XML body: the member name it stands for, its text and its child elements
+type xmlNode struct {
+	name     string
+	text     strings.Builder
+	children []*xmlNode
+}
+
+func parseXML(r io.Reader) (*xmlNode, error) {
+	dec := xml.NewDecoder(r)
+	var stack []*xmlNode
+	var root *xmlNode
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF && root == nil && len(stack) == 0 {
+			return nil, io.EOF
+		} else if err == io.EOF && root != nil {
+			return root, nil
+		}
+		var syntaxErr *xml.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, syntaxError("malformed XML at line %d", syntaxErr.Line)
+		} else if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root != nil && len(stack) == 0 {
+				return nil, syntaxError("malformed XML: more than one root element")
+			}
+			n := &xmlNode{name: t.Name.Local}
+			for _, attr := range t.Attr {
+				if t.Name.Local == "entry" && attr.Name.Local == "key" {
+					n.name = attr.Value
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) == 1 {
+				root = stack[0]
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	rawMessageType      = reflect.TypeOf(json.RawMessage(nil))
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// the JSON value n stands for as a t, or as a string, array or object when t is nil or an interface
+func (n *xmlNode) value(t reflect.Type) interface{} {
+	text := n.text.String()
+	empty := len(n.children) == 0 && strings.TrimSpace(text) == ""
+	for t != nil && t.Kind() == reflect.Pointer {
+		if empty {
+			return nil
+		}
+		t = t.Elem()
+	}
+	if t == nil || t == rawMessageType || t.Kind() == reflect.Interface {
+		return n.untyped()
+	}
+	if t == timeType || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return strings.TrimSpace(text)
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := jsonFields(t)
+		obj := make(map[string]interface{}, len(n.children))
+		for _, c := range n.children {
+			obj[c.name] = c.value(fields[c.name])
+		}
+		return obj
+	case reflect.Map:
+		obj := make(map[string]interface{}, len(n.children))
+		for _, c := range n.children {
+			obj[c.name] = c.value(t.Elem())
+		}
+		return obj
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return text
+		}
+		items := make([]interface{}, len(n.children))
+		for i, c := range n.children {
+			items[i] = c.value(t.Elem())
+		}
+		return items
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(strings.TrimSpace(text)); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		if s := strings.TrimSpace(text); json.Valid([]byte(s)) {
+			if _, err := strconv.ParseFloat(s, 64); err == nil {
+				return json.Number(s)
+			}
+		}
+	}
+	// left as text, so decoding reports what the value should have been
+	return text
+}
+
+// n without a type to go by: elements made only of <item> children are arrays, others with
+// children are objects and the rest text
+func (n *xmlNode) untyped() interface{} {
+	if len(n.children) == 0 {
+		return n.text.String()
+	}
+	items := make([]interface{}, 0, len(n.children))
+	for _, c := range n.children {
+		if c.name != "item" {
+			obj := make(map[string]interface{}, len(n.children))
+			for _, c := range n.children {
+				obj[c.name] = c.untyped()
+			}
+			return obj
+		}
+		items = append(items, c.untyped())
+	}
+	return items
+}
+
+// the types of a struct's fields by JSON member name, including those of embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for member, ft := range jsonFields(embedded) {
+					if _, shadowed := fields[member]; !shadowed {
+						fields[member] = ft
+					}
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
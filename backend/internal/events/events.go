@@ -0,0 +1,134 @@
+// Package events publishes user change events from the outbox to a message broker, NATS or Kafka,
+// so downstream services can follow changes without polling the API.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/models"
+	"api/userpb"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Broker sends messages on a topic: a NATS subject or a Kafka topic.
+type Broker interface {
+	// send one message, returning once the broker has it. key orders the messages of one user
+	// where the broker partitions, as Kafka does
+	Send(ctx context.Context, key string, value []byte) error
+	Close() error
+}
+
+// encodings of published events
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+)
+
+// Event is the JSON form of a published event; the protobuf form is userpb.UserEvent.
+type Event struct {
+	Id         int64           `json:"id"` // increasing with every change; consumers can use it to drop duplicates
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	User       json.RawMessage `json:"user"` // the user after the change, as in API v1
+}
+
+// Publisher publishes outbox events to a broker in the chosen format.
+type Publisher struct {
+	broker Broker
+	format string
+}
+
+// NewPublisher encodes events as FormatJSON or FormatProtobuf.
+func NewPublisher(broker Broker, format string) *Publisher {
+	return &Publisher{broker: broker, format: format}
+}
+
+func (p *Publisher) Publish(ctx context.Context, e models.OutboxEvent) error {
+	if e.EntityType != models.AuditEntityUser {
+		return nil
+	}
+	value, err := p.encode(e)
+	if err != nil {
+		return fmt.Errorf("encode event %d: %w", e.Id, err)
+	}
+	return p.broker.Send(ctx, strconv.Itoa(e.EntityId), value)
+}
+
+func (p *Publisher) encode(e models.OutboxEvent) ([]byte, error) {
+	if p.format != FormatProtobuf {
+		return json.Marshal(Event{Id: e.Id, Type: e.Event, OccurredAt: e.CreatedAt, User: e.Payload})
+	}
+	var u models.User
+	if err := json.Unmarshal(e.Payload, &u); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&userpb.UserEvent{
+		Id:         e.Id,
+		Type:       e.Event,
+		OccurredAt: timestamppb.New(e.CreatedAt),
+		User:       userpb.FromModel(u),
+	})
+}
+
+// NATS publishes on a subject of a NATS server.
+type NATS struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATS connects to the server at url, reconnecting for as long as it runs.
+func NewNATS(url, subject string) (*NATS, error) {
+	conn, err := nats.Connect(url, nats.Name("go-fullstack-app"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &NATS{conn: conn, subject: subject}, nil
+}
+
+// core NATS has no key; the flush waits for the server to take the message so a lost connection
+// fails the publish and the event is relayed again
+func (n *NATS) Send(ctx context.Context, _ string, value []byte) error {
+	if err := n.conn.Publish(n.subject, value); err != nil {
+		return err
+	}
+	return n.conn.FlushWithContext(ctx)
+}
+
+// Close sends what is buffered and disconnects.
+func (n *NATS) Close() error {
+	return n.conn.Drain()
+}
+
+// Kafka writes to a topic of a Kafka cluster, partitioning by user so each user's events stay in order.
+type Kafka struct {
+	writer *kafka.Writer
+}
+
+// NewKafka writes to topic through the comma-separated broker addresses.
+func NewKafka(brokers, topic string) *Kafka {
+	return &Kafka{writer: &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// the relay sends one event at a time, so waiting to fill a batch would only slow it down
+		BatchTimeout: 10 * time.Millisecond,
+	}}
+}
+
+func (k *Kafka) Send(ctx context.Context, key string, value []byte) error {
+	return k.writer.WriteMessages(ctx, kafka.Message{Key: []byte(key), Value: value})
+}
+
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// how many of the latest audit entries the overview carries
+const overviewActivityLimit = 10
+
+// everything the admin dashboard's landing page shows, so it loads with one request
+type adminOverview struct {
+	Users          models.UserStats    `json:"users"`
+	RecentActivity []models.AuditEntry `json:"recent_activity"` // empty when there's no audit log
+	Health         statusResponse      `json:"health"`
+	DBPool         *dbPoolStats        `json:"db_pool"` // null when the pool isn't reported
+	Cache          cacheStats          `json:"cache"`
+}
+
+type dbPoolStats struct {
+	MaxOpen        int   `json:"max_open"`
+	Open           int   `json:"open"`
+	InUse          int   `json:"in_use"`
+	Idle           int   `json:"idle"`
+	WaitCount      int64 `json:"wait_count"`
+	WaitDurationMs int64 `json:"wait_duration_ms"`
+}
+
+// user cache lookups since the process started
+type cacheStats struct {
+	Hits    int64    `json:"hits"`
+	Misses  int64    `json:"misses"`
+	HitRate *float64 `json:"hit_rate"` // null before the first lookup
+}
+
+// user counts, the latest audit entries, dependency health, the DB pool and the user cache in one payload
+func (a *App) getAdminOverview(w http.ResponseWriter, r *http.Request) {
+	st, err := a.users.Stats(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	overview := adminOverview{Users: st, RecentActivity: []models.AuditEntry{}}
+	if a.audit != nil {
+		entries, _, err := a.audit.List(r.Context(), models.AuditFilter{}, overviewActivityLimit, 0)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		overview.RecentActivity = entries
+	}
+	overview.Health = a.runChecks(r.Context())
+	if a.dbStats != nil {
+		s := a.dbStats()
+		overview.DBPool = &dbPoolStats{
+			MaxOpen:        int(s.MaxConns()),
+			Open:           int(s.TotalConns()),
+			InUse:          int(s.AcquiredConns()),
+			Idle:           int(s.IdleConns()),
+			WaitCount:      s.EmptyAcquireCount(),
+			WaitDurationMs: s.EmptyAcquireWaitTime().Milliseconds(),
+		}
+	}
+	hits, misses := store.CacheLookupTotals()
+	overview.Cache = cacheStats{Hits: hits, Misses: misses}
+	if hits+misses > 0 {
+		rate := float64(hits) / float64(hits+misses)
+		overview.Cache.HitRate = &rate
+	}
+	writeBody(w, overview)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+// marks our keys, so they are easy to spot in logs and by secret scanners
+const apiKeyPrefix = "gk_"
+
+// how much of a key is kept in the clear to identify it
+const apiKeyDisplayLength = len(apiKeyPrefix) + 8
+
+const maxAPIKeyNameLength = 100
+
+type apiKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// the response to creating a key, the only time the key itself is shown
+type createdAPIKey struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+func newAPIKey() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *App) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	var req apiKeyRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "API key is invalid", Fields: map[string]string{"name": "name is required"}})
+		return
+	}
+	if utf8.RuneCountInString(req.Name) > maxAPIKeyNameLength {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "API key is invalid", Fields: map[string]string{"name": "name must be at most " + strconv.Itoa(maxAPIKeyNameLength) + " characters"}})
+		return
+	}
+
+	key := newAPIKey()
+	k, err := a.apiKeys.CreateAPIKey(r.Context(), id, req.Name, key[:apiKeyDisplayLength], middleware.HashAPIKey(key))
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, createdAPIKey{APIKey: k, Key: key})
+}
+
+func (a *App) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	keys, err := a.apiKeys.APIKeys(r.Context(), id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, keys)
+}
+
+func (a *App) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	keyID, err := strconv.Atoi(mux.Vars(r)["keyId"])
+	if !ok || err != nil {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "API key not found"})
+		return
+	}
+	err = a.apiKeys.RevokeAPIKey(r.Context(), id, keyID)
+	if err == store.ErrAPIKeyNotFound {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "API key not found"})
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
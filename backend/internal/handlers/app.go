@@ -0,0 +1,568 @@
+// Package handlers serves the users API over REST, GraphQL, WebSocket and gRPC.
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/jobs"
+	"api/internal/ldapauth"
+	"api/internal/mail"
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/oauth"
+	"api/internal/storage"
+	"api/internal/store"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Options tunes an App beyond its required dependencies.
+type Options struct {
+	// Build is served by the version endpoint; its version is reported by the status endpoints too.
+	Build Build
+	// NormalizeNames title-cases user names on write.
+	NormalizeNames bool
+	// SearchSimilarityThreshold is the minimum word similarity for ?mode=fuzzy search.
+	SearchSimilarityThreshold float64
+	// Checks are the readiness checks run by /readyz, keyed by dependency name.
+	Checks map[string]func(context.Context) error
+	// PublicURL is the externally reachable base URL of the API, used in links sent by email and in initials avatar URLs.
+	PublicURL string
+	// PasswordResetURL is the page reset emails link to; it receives the token as ?token=.
+	PasswordResetURL string
+	// InvitationURL is the page organization invitation emails link to; it receives the token as ?token=.
+	InvitationURL string
+	// Mailer delivers verification and password reset emails; it defaults to logging them.
+	Mailer mail.Sender
+	// Avatars stores uploaded avatars; without it the avatar upload route is not registered.
+	Avatars storage.Storage
+	// DefaultAvatar pictures users who haven't uploaded an avatar, as DefaultAvatarGravatar or
+	// DefaultAvatarInitials; anything else, such as "none", presents their avatar_url as null.
+	DefaultAvatar string
+	// MaskedFields hides the named MaskableFields in part from callers other than the user
+	// themselves, keyed by field with the roles that still see it in full: emails keep their first
+	// character and domain, phone numbers their last two digits and timestamps their day.
+	MaskedFields map[string][]string
+	// Audit is read by the audit log endpoint; without it the route is not registered.
+	Audit store.AuditLog
+	// RequestTimeout is the deadline for handling a request, other than streams; zero means none.
+	RequestTimeout time.Duration
+	// Idempotency stores responses to creates sent with an Idempotency-Key; without it the header is ignored.
+	Idempotency store.IdempotencyKeys
+	// MaxBodySize is the largest JSON request body accepted, in bytes; zero means 1 MiB.
+	MaxBodySize int64
+	// Webhooks keeps webhook subscriptions and queues their deliveries; without it the webhook routes are not registered.
+	Webhooks store.Webhooks
+	// WebhookAllowPrivate lets webhooks be registered for URLs on loopback, private and link-local addresses.
+	WebhookAllowPrivate bool
+	// LockoutThreshold is how many failed logins in a row lock an account, for LockoutDuration; zero means never.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// PasswordPolicy is what new passwords must satisfy; the zero policy only asks for 8 characters.
+	PasswordPolicy PasswordPolicy
+	// OAuthProviders offer sign-in with accounts elsewhere, by name; without any the routes are not registered.
+	OAuthProviders map[string]*oauth.Provider
+	// OAuthRedirectURL is the frontend page an OAuth sign-in ends on, with its tokens or error in the
+	// fragment; empty answers the callback with JSON instead.
+	OAuthRedirectURL string
+	// Directory checks logins against LDAP in place of local passwords, which can then no longer be
+	// registered, changed or reset; nil keeps local passwords.
+	Directory *ldapauth.Authenticator
+	// Jobs is the background job queue, read by the admin jobs endpoints; without it they are not registered.
+	Jobs store.Jobs
+	// DBStats reports the database connection pool for the admin overview, which omits it when unset.
+	DBStats func() *pgxpool.Stat
+	// Tenants resolves the tenant clients name in the X-Tenant header, over REST and gRPC; without it
+	// the header is ignored and requests without a token are in the default tenant.
+	Tenants store.Tenants
+	// Organizations keeps the organizations users belong to; without it the organization routes are not registered.
+	Organizations store.Organizations
+	// Posts keeps what users write; without it the post routes are not registered.
+	Posts store.Posts
+	// FeatureFlags keeps the flags gating features of the frontend; without it the flag routes are
+	// not registered.
+	FeatureFlags store.FeatureFlags
+	// Consents keeps which versions of LegalDocuments users accepted; without it the consent routes
+	// are not registered.
+	Consents store.Consents
+	// LegalDocuments are the current versions of the documents users accept, served publicly.
+	LegalDocuments []models.LegalDocument
+	// RequireConsent answers authenticated requests 403 until the caller has accepted every one of
+	// LegalDocuments, except those reading or recording what they accepted.
+	RequireConsent bool
+	// Exports keeps the data exports generated by JobPool for large accounts; without both, every
+	// account is exported while the request waits.
+	Exports store.Exports
+	// UserExports keeps the exports of many users generated by JobPool, with their files in
+	// ExportFiles; without all three the export job routes are not registered, leaving the streamed
+	// /users/export.
+	UserExports store.UserExports
+	// ExportFiles keeps the files of UserExports, served only through their signed download links.
+	// it must not be publicly readable, unlike Avatars.
+	ExportFiles storage.Storage
+	// JobPool runs background jobs; handlers register the kinds they queue on it.
+	JobPool *jobs.Pool
+	// Debug serves the runtime's profiles and expvar variables under /debug/ to admins of the
+	// default tenant, see DebugHandler.
+	Debug bool
+}
+
+// App holds the dependencies shared by every handler.
+type App struct {
+	// each concern of the user repository, so a handler depends on only the ones it calls
+	users          store.UserStore
+	search         store.SearchStore
+	passwords      store.PasswordStore
+	sessions       store.SessionStore
+	logins         store.LoginStore
+	identities     store.IdentityStore
+	impersonations store.ImpersonationStore
+	twoFactor      store.TwoFactorStore
+	preferences    store.PreferenceStore
+	roles          store.RoleStore
+	revisions      store.RevisionStore
+	apiKeys        store.APIKeyStore
+	tags           store.TagStore
+
+	feed        *Feed
+	tokenSecret []byte
+
+	build                     Build
+	normalizeNames            bool
+	searchSimilarityThreshold float64
+	checks                    map[string]func(context.Context) error
+	avatars                   storage.Storage
+	defaultAvatar             string
+	maskedFields              map[string][]string
+	publicURL                 string
+	passwordResetURL          string
+	invitationURL             string
+	mailer                    mail.Sender
+	audit                     store.AuditLog
+	idempotency               store.IdempotencyKeys
+	requestTimeout            time.Duration
+	maxBodySize               int64
+	webhooks                  store.Webhooks
+	webhookAllowPrivate       bool
+	jobs                      store.Jobs
+	lockoutThreshold          int
+	lockoutDuration           time.Duration
+	passwordPolicy            PasswordPolicy
+	oauthProviders            map[string]*oauth.Provider
+	oauthRedirectURL          string
+	directory                 *ldapauth.Authenticator
+	dbStats                   func() *pgxpool.Stat
+	tenants                   store.Tenants
+	orgs                      store.Organizations
+	posts                     store.Posts
+	flags                     store.FeatureFlags
+	consents                  store.Consents
+	legalDocuments            []models.LegalDocument
+	requireConsent            bool
+	exports                   store.Exports
+	userExports               store.UserExports
+	exportFiles               storage.Storage
+	jobPool                   *jobs.Pool
+	debug                     bool
+}
+
+// NewApp wires the handlers to a user repository, the change feed and the token signing secret.
+func NewApp(users store.UserRepository, feed *Feed, tokenSecret []byte, opts Options) *App {
+	if opts.Mailer == nil {
+		opts.Mailer = mail.LogSender{}
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = defaultMaxBodySize
+	}
+	a := &App{
+		users:                     users,
+		search:                    users,
+		passwords:                 users,
+		sessions:                  users,
+		logins:                    users,
+		identities:                users,
+		impersonations:            users,
+		twoFactor:                 users,
+		preferences:               users,
+		roles:                     users,
+		revisions:                 users,
+		apiKeys:                   users,
+		tags:                      users,
+		feed:                      feed,
+		tokenSecret:               tokenSecret,
+		build:                     opts.Build,
+		normalizeNames:            opts.NormalizeNames,
+		searchSimilarityThreshold: opts.SearchSimilarityThreshold,
+		checks:                    opts.Checks,
+		avatars:                   opts.Avatars,
+		defaultAvatar:             opts.DefaultAvatar,
+		maskedFields:              opts.MaskedFields,
+		publicURL:                 strings.TrimSuffix(opts.PublicURL, "/"),
+		passwordResetURL:          opts.PasswordResetURL,
+		invitationURL:             opts.InvitationURL,
+		mailer:                    opts.Mailer,
+		audit:                     opts.Audit,
+		idempotency:               opts.Idempotency,
+		requestTimeout:            opts.RequestTimeout,
+		maxBodySize:               opts.MaxBodySize,
+		webhooks:                  opts.Webhooks,
+		webhookAllowPrivate:       opts.WebhookAllowPrivate,
+		jobs:                      opts.Jobs,
+		lockoutThreshold:          opts.LockoutThreshold,
+		lockoutDuration:           opts.LockoutDuration,
+		passwordPolicy:            opts.PasswordPolicy,
+		oauthProviders:            opts.OAuthProviders,
+		oauthRedirectURL:          opts.OAuthRedirectURL,
+		directory:                 opts.Directory,
+		dbStats:                   opts.DBStats,
+		tenants:                   opts.Tenants,
+		orgs:                      opts.Organizations,
+		posts:                     opts.Posts,
+		flags:                     opts.FeatureFlags,
+		consents:                  opts.Consents,
+		legalDocuments:            opts.LegalDocuments,
+		requireConsent:            opts.RequireConsent && opts.Consents != nil && len(opts.LegalDocuments) > 0,
+		debug:                     opts.Debug,
+	}
+	if opts.Exports != nil && opts.JobPool != nil {
+		a.exports, a.jobPool = opts.Exports, opts.JobPool
+		a.jobPool.Register(jobKindUserExport, a.runExport, jobs.DefaultRetry)
+	}
+	if opts.UserExports != nil && opts.ExportFiles != nil && opts.JobPool != nil {
+		a.userExports, a.exportFiles, a.jobPool = opts.UserExports, opts.ExportFiles, opts.JobPool
+		a.jobPool.Register(jobKindUsersExport, a.runUsersExport, jobs.DefaultRetry)
+	}
+	return a
+}
+
+// what middleware.Auth checks tokens against: sessions, revocations and impersonations
+type tokenRevocations struct {
+	store.SessionStore
+	store.ImpersonationStore
+}
+
+// require a bearer token or API key, as middleware.Auth does
+func (a *App) authenticate(next http.Handler) http.Handler {
+	return middleware.Auth(a.tokenSecret, tokenRevocations{a.sessions, a.impersonations}, a.apiKeys)(next)
+}
+
+// Router registers every route under each API version, /api/v1 and /api/v2, and /api/go as a
+// deprecated alias of v1. endpoints other than auth and the probes require a bearer token and, for
+// most of them, a permission granted by the caller's role. each request acts within the tenant its
+// token was issued in or, before signing in, the one its X-Tenant header names.
+func (a *App) Router() *mux.Router {
+	router := mux.NewRouter().StrictSlash(true)
+	router.NotFoundHandler = notFoundHandler()
+	router.MethodNotAllowedHandler = methodNotAllowedHandler(router)
+	if a.tenants != nil {
+		router.Use(middleware.Tenant(a.tenants))
+	}
+	if a.requestTimeout > 0 {
+		router.Use(middleware.Timeout(a.requestTimeout, isLongLived))
+	}
+
+	router.HandleFunc("/healthz", healthz).Methods("GET")
+	router.HandleFunc("/readyz", a.statusCheck).Methods("GET")
+	router.HandleFunc("/api/go/openapi.json", openapiSpec).Methods("GET")
+	// StrictSlash redirects /api/go/docs here, so the UI's relative asset links resolve
+	router.Handle("/api/go/docs/", docsHandler()).Methods("GET")
+	router.PathPrefix("/api/go/docs/").Handler(docsHandler()).Methods("GET")
+	if a.debug {
+		router.PathPrefix("/debug/").Handler(a.debugHandler()).Methods("GET", "POST")
+	}
+
+	// the subrouters match on their full paths rather than a PathPrefix, whose matcher mux copies
+	// into every route, where it hides a method mismatch from the 405 handler
+	a.routes(router.NewRoute().Subrouter(), "/api/v1", apiV1)
+	a.routes(router.NewRoute().Subrouter(), "/api/v2", apiV2)
+	legacy := router.NewRoute().Subrouter()
+	legacy.Use(deprecated)
+	a.routes(legacy, legacyPrefix, apiV1)
+	return router
+}
+
+// register the API's routes under prefix on api, a subrouter serving the given version
+func (a *App) routes(api *mux.Router, prefix string, version int) {
+	api.Use(withAPIRoutes(apiRoutes{version: version, prefix: prefix, avatars: a.avatars != nil, defaultAvatar: a.defaultAvatar, publicURL: a.publicURL}))
+	if len(a.maskedFields) > 0 {
+		api.Use(a.withFieldMasks)
+	}
+	auth := a.authenticate
+	// what a caller who hasn't accepted the current documents can still do: accept them, and end an
+	// impersonation
+	authOnly := auth
+	if a.requireConsent {
+		auth = func(h http.Handler) http.Handler { return authOnly(a.consentGate(h)) }
+	}
+
+	api.HandleFunc(prefix+"/status", a.statusCheck).Methods("GET")
+	api.HandleFunc(prefix+"/version", a.getVersion).Methods("GET")
+	if a.consents != nil {
+		api.HandleFunc(prefix+"/legal", a.getLegalDocuments).Methods("GET")
+	}
+	// with a directory, accounts and their passwords are managed there
+	if a.directory == nil {
+		api.HandleFunc(prefix+"/auth/register", a.register).Methods("POST")
+		api.HandleFunc(prefix+"/auth/forgot-password", a.forgotPassword).Methods("POST")
+		api.HandleFunc(prefix+"/auth/reset-password", a.resetPassword).Methods("POST")
+	}
+	api.HandleFunc(prefix+"/auth/login", a.login).Methods("POST")
+	api.HandleFunc(prefix+"/auth/2fa", a.loginSecondFactor).Methods("POST")
+	api.HandleFunc(prefix+"/auth/refresh", a.refresh).Methods("POST")
+	api.HandleFunc(prefix+"/auth/logout", a.logout).Methods("POST")
+	api.HandleFunc(prefix+"/auth/csrf", a.getCSRFToken).Methods("GET")
+	if len(a.oauthProviders) > 0 {
+		api.HandleFunc(prefix+"/auth/{provider}/login", a.oauthLogin).Methods("GET")
+		api.HandleFunc(prefix+"/auth/{provider}/callback", a.oauthCallback).Methods("GET")
+	}
+	api.HandleFunc(prefix+"/verify", a.verifyEmail).Methods("GET")
+	// every authenticated route needs a permission from the caller's role, except self-service on their own account
+	allow := func(permission string, h http.HandlerFunc) http.Handler {
+		return auth(middleware.RequirePermission(a.roles, permission)(h))
+	}
+	allowSelfOr := func(permission string, h http.HandlerFunc) http.Handler {
+		return auth(a.requireSelfOr(permission)(h))
+	}
+	// ahead of every /users/{id}/... route, which would otherwise take usernames such as posts or tags
+	api.Handle(prefix+"/users/u/{username}", allow(models.PermUsersRead, a.getUserByUsername)).Methods("GET")
+	api.Handle(prefix+"/ws", allow(models.PermUsersRead, a.userEventsSocket)).Methods("GET")
+	api.Handle(prefix+"/graphql", allow(models.PermUsersRead, a.graphqlHandler(newGraphQLSchema(a)))).Methods("POST")
+	if a.audit != nil {
+		api.Handle(prefix+"/audit", allow(models.PermAuditRead, a.getAuditLog)).Methods("GET")
+	}
+	if a.webhooks != nil {
+		api.Handle(prefix+"/webhooks", allow(models.PermWebhooksManage, a.listWebhooks)).Methods("GET")
+		api.Handle(prefix+"/webhooks", allow(models.PermWebhooksManage, a.createWebhook)).Methods("POST")
+		api.Handle(prefix+"/webhooks/{id}", allow(models.PermWebhooksManage, a.getWebhook)).Methods("GET")
+		api.Handle(prefix+"/webhooks/{id}", allow(models.PermWebhooksManage, a.deleteWebhook)).Methods("DELETE")
+		api.Handle(prefix+"/webhooks/{id}/deliveries", allow(models.PermWebhooksManage, a.listWebhookDeliveries)).Methods("GET")
+	}
+	if a.orgs != nil {
+		api.Handle(prefix+"/orgs", auth(http.HandlerFunc(a.listOrgs))).Methods("GET")
+		api.Handle(prefix+"/orgs", auth(a.idempotent(a.createOrg))).Methods("POST")
+		api.Handle(prefix+"/orgs/{id}", auth(http.HandlerFunc(a.getOrg))).Methods("GET")
+		api.Handle(prefix+"/orgs/{id}", auth(http.HandlerFunc(a.updateOrg))).Methods("PUT")
+		api.Handle(prefix+"/orgs/{id}", auth(http.HandlerFunc(a.deleteOrg))).Methods("DELETE")
+		api.Handle(prefix+"/orgs/{id}/members", auth(http.HandlerFunc(a.listOrgMembers))).Methods("GET")
+		api.Handle(prefix+"/orgs/{id}/members", auth(http.HandlerFunc(a.addOrgMember))).Methods("POST")
+		api.Handle(prefix+"/orgs/{id}/members/{userId}", auth(http.HandlerFunc(a.setOrgMemberRole))).Methods("PUT")
+		api.Handle(prefix+"/orgs/{id}/members/{userId}", auth(http.HandlerFunc(a.removeOrgMember))).Methods("DELETE")
+		api.Handle(prefix+"/orgs/{id}/invitations", auth(a.idempotent(a.createInvitation))).Methods("POST")
+		api.Handle(prefix+"/invitations/accept", auth(http.HandlerFunc(a.acceptInvitation))).Methods("POST")
+		api.HandleFunc(prefix+"/invitations/decline", a.declineInvitation).Methods("POST")
+	}
+	if a.posts != nil {
+		api.Handle(prefix+"/posts", allow(models.PermUsersRead, a.listPosts)).Methods("GET")
+		api.Handle(prefix+"/posts/{id}", allow(models.PermUsersRead, a.getPost)).Methods("GET")
+		// authors change their own posts and comments, see ownPost and ownComment
+		api.Handle(prefix+"/posts/{id}", auth(http.HandlerFunc(a.updatePost))).Methods("PUT")
+		api.Handle(prefix+"/posts/{id}", auth(http.HandlerFunc(a.deletePost))).Methods("DELETE")
+		api.Handle(prefix+"/posts/{id}/comments", allow(models.PermUsersRead, a.listComments)).Methods("GET")
+		api.Handle(prefix+"/posts/{id}/comments", allow(models.PermUsersRead, a.idempotent(a.createComment))).Methods("POST")
+		api.Handle(prefix+"/posts/{id}/comments/{commentId}", auth(http.HandlerFunc(a.updateComment))).Methods("PUT")
+		api.Handle(prefix+"/posts/{id}/comments/{commentId}", auth(http.HandlerFunc(a.deleteComment))).Methods("DELETE")
+		api.Handle(prefix+"/users/{id}/posts", allow(models.PermUsersRead, a.listUserPosts)).Methods("GET")
+		api.Handle(prefix+"/users/{id}/posts", allowSelfOr(models.PermPostsManage, a.idempotent(a.createPost))).Methods("POST")
+	}
+	if a.flags != nil {
+		api.Handle(prefix+"/flags", allow(models.PermFlagsManage, a.listFlags)).Methods("GET")
+		api.Handle(prefix+"/flags", allow(models.PermFlagsManage, a.createFlag)).Methods("POST")
+		api.Handle(prefix+"/flags/{key}", allow(models.PermFlagsManage, a.getFlag)).Methods("GET")
+		api.Handle(prefix+"/flags/{key}", allow(models.PermFlagsManage, a.updateFlag)).Methods("PUT")
+		api.Handle(prefix+"/flags/{key}", allow(models.PermFlagsManage, a.deleteFlag)).Methods("DELETE")
+		api.Handle(prefix+"/users/{id}/flags", allowSelfOr(models.PermFlagsManage, a.getUserFlags)).Methods("GET")
+	}
+	if a.consents != nil {
+		api.Handle(prefix+"/users/{id}/consents", authOnly(a.requireSelfOr(models.PermAuditRead)(http.HandlerFunc(a.listConsents)))).Methods("GET")
+		api.Handle(prefix+"/users/{id}/consents", authOnly(notImpersonating(a.acceptConsent))).Methods("POST")
+	}
+	api.Handle(prefix+"/admin/overview", allow(models.PermAdminRead, a.getAdminOverview)).Methods("GET")
+	// ahead of /admin/impersonate/{id}, which would otherwise take it
+	api.Handle(prefix+"/admin/impersonate/stop", authOnly(http.HandlerFunc(a.stopImpersonation))).Methods("POST")
+	api.Handle(prefix+"/admin/impersonate/{id}", allow(models.PermImpersonate, notImpersonating(a.startImpersonation))).Methods("POST")
+	if a.jobs != nil {
+		api.Handle(prefix+"/admin/jobs", allow(models.PermAdminRead, instanceWide(a.listJobs))).Methods("GET")
+		api.Handle(prefix+"/admin/jobs/{id}", allow(models.PermAdminRead, instanceWide(a.getJob))).Methods("GET")
+	}
+	api.Handle(prefix+"/roles", allow(models.PermRolesManage, a.listRoles)).Methods("GET")
+	api.Handle(prefix+"/tags", allow(models.PermUsersRead, a.listTags)).Methods("GET")
+	api.Handle(prefix+"/users", allow(models.PermUsersRead, a.requireForDeleted(replicaReads(a.getUsers)))).Methods("GET")
+	api.Handle(prefix+"/users", allow(models.PermUsersWrite, a.idempotent(a.createUser))).Methods("POST")
+	api.Handle(prefix+"/users", allow(models.PermUsersWrite, a.upsertUser)).Methods("PUT")
+	api.Handle(prefix+"/users", allow(models.PermUsersDelete, a.deleteUsers)).Methods("DELETE")
+	api.Handle(prefix+"/users/batch", allow(models.PermUsersWrite, a.idempotent(a.createUsersBatch))).Methods("POST")
+	api.Handle(prefix+"/users/aggregate", allow(models.PermUsersRead, a.requireForDeleted(a.getSignupAggregate))).Methods("GET")
+	api.Handle(prefix+"/users/stats", allow(models.PermUsersRead, a.getUserStats)).Methods("GET")
+	api.Handle(prefix+"/users/stream", allow(models.PermUsersRead, a.requireForDeleted(a.streamUsers))).Methods("GET")
+	api.Handle(prefix+"/users/events", allow(models.PermUsersRead, a.userChangeEvents)).Methods("GET")
+	api.Handle(prefix+"/users/changes", allow(models.PermUsersRead, a.getUserChanges)).Methods("GET")
+	api.Handle(prefix+"/users/export", allow(models.PermUsersRead, a.requireForDeleted(a.exportUsers))).Methods("GET")
+	if a.userExports != nil {
+		api.Handle(prefix+"/exports", allow(models.PermUsersRead, a.requireForDeleted(a.startUsersExport))).Methods("POST")
+		api.Handle(prefix+"/exports/{id}", allow(models.PermUsersRead, a.getUsersExport)).Methods("GET")
+		// the signed link grants access, so it has no token to check
+		api.HandleFunc(prefix+"/exports/{id}/download", a.downloadUsersExport).Methods("GET")
+	}
+	api.Handle(prefix+"/users/import", allow(models.PermUsersWrite, a.importUsers)).Methods("POST")
+	api.Handle(prefix+"/users/search", allow(models.PermUsersRead, replicaReads(a.searchUsers))).Methods("GET")
+	api.Handle(prefix+"/users/by-email/{email}", allow(models.PermUsersRead, a.getUserByEmail)).Methods("GET")
+	api.Handle(prefix+"/users/{id}", allow(models.PermUsersRead, a.requireForDeleted(replicaReads(a.getUser)))).Methods("GET")
+	api.Handle(prefix+"/users/{id}", allowSelfOr(models.PermUsersWrite, a.updateUser)).Methods("PUT")
+	api.Handle(prefix+"/users/{id}", allowSelfOr(models.PermUsersWrite, a.patchUser)).Methods("PATCH")
+	api.Handle(prefix+"/users/{id}", allow(models.PermUsersDelete, a.deleteUser)).Methods("DELETE")
+	if a.directory == nil {
+		api.Handle(prefix+"/users/{id}/password", auth(notImpersonating(a.changePassword))).Methods("PUT")
+		api.Handle(prefix+"/users/{id}/change-email", allowSelfOr(models.PermUsersWrite, notImpersonating(a.changeEmail))).Methods("POST")
+	}
+	api.Handle(prefix+"/users/{id}/restore", allow(models.PermUsersDelete, a.restoreUser)).Methods("POST")
+	api.Handle(prefix+"/users/{id}/erase", allowSelfOr(models.PermUsersDelete, notImpersonating(a.eraseUser))).Methods("POST")
+	api.Handle(prefix+"/users/{id}/merge", allow(models.PermUsersDelete, a.mergeUser)).Methods("POST")
+	api.Handle(prefix+"/users/{id}/export", allowSelfOr(models.PermAuditRead, a.exportUserData)).Methods("GET")
+	if a.exports != nil {
+		api.Handle(prefix+"/users/{id}/exports/{exportId}", allowSelfOr(models.PermAuditRead, a.getExport)).Methods("GET")
+		api.Handle(prefix+"/users/{id}/exports/{exportId}/archive", allowSelfOr(models.PermAuditRead, a.downloadExport)).Methods("GET")
+	}
+	api.Handle(prefix+"/users/{id}/revisions", allowSelfOr(models.PermAuditRead, a.getRevisions)).Methods("GET")
+	api.Handle(prefix+"/users/{id}/revisions/{rev}/diff", allowSelfOr(models.PermAuditRead, a.getRevisionDiff)).Methods("GET")
+	api.Handle(prefix+"/users/{id}/role", allow(models.PermRolesManage, a.setUserRole)).Methods("PUT")
+	api.Handle(prefix+"/users/{id}/api-keys", allowSelfOr(models.PermUsersWrite, a.listAPIKeys)).Methods("GET")
+	api.Handle(prefix+"/users/{id}/api-keys", allowSelfOr(models.PermUsersWrite, notImpersonating(a.createAPIKey))).Methods("POST")
+	api.Handle(prefix+"/users/{id}/api-keys/{keyId}", allowSelfOr(models.PermUsersWrite, a.revokeAPIKey)).Methods("DELETE")
+	api.Handle(prefix+"/users/{id}/sessions", allowSelfOr(models.PermUsersWrite, a.listSessions)).Methods("GET")
+	api.Handle(prefix+"/users/{id}/sessions/{sid}", allowSelfOr(models.PermUsersWrite, a.revokeSession)).Methods("DELETE")
+	api.Handle(prefix+"/users/{id}/logins", allowSelfOr(models.PermAuditRead, a.listLogins)).Methods("GET")
+	api.Handle(prefix+"/users/{id}/lock", allow(models.PermUsersWrite, a.unlockUser)).Methods("DELETE")
+	api.Handle(prefix+"/users/{id}/verification", allowSelfOr(models.PermUsersWrite, a.resendVerification)).Methods("POST")
+	api.Handle(prefix+"/users/{id}/tags", allow(models.PermUsersRead, a.getUserTags)).Methods("GET")
+	api.Handle(prefix+"/users/{id}/tags/{tag}", allow(models.PermUsersWrite, a.tagUser)).Methods("PUT")
+	api.Handle(prefix+"/users/{id}/tags/{tag}", allow(models.PermUsersWrite, a.untagUser)).Methods("DELETE")
+	api.Handle(prefix+"/users/{id}/settings", allowSelfOr(models.PermUsersRead, a.getSettings)).Methods("GET")
+	api.Handle(prefix+"/users/{id}/settings", allowSelfOr(models.PermUsersWrite, a.patchSettings)).Methods("PATCH")
+	api.Handle(prefix+"/users/{id}/preferences", allowSelfOr(models.PermUsersRead, a.getPreferences)).Methods("GET")
+	api.Handle(prefix+"/users/{id}/preferences", allowSelfOr(models.PermUsersWrite, a.putPreferences)).Methods("PUT")
+	api.Handle(prefix+"/users/{id}/2fa", auth(notImpersonating(a.enrollTOTP))).Methods("POST")
+	api.Handle(prefix+"/users/{id}/2fa", auth(notImpersonating(a.disableTOTP))).Methods("DELETE")
+	api.Handle(prefix+"/users/{id}/2fa/confirm", auth(notImpersonating(a.confirmTOTP))).Methods("POST")
+	api.Handle(prefix+"/users/{id}/2fa/backup-codes", auth(notImpersonating(a.regenerateBackupCodes))).Methods("POST")
+	if a.avatars != nil {
+		api.Handle(prefix+"/users/{id}/avatar", allowSelfOr(models.PermUsersWrite, a.uploadAvatar)).Methods("POST")
+	}
+	if a.defaultAvatar == DefaultAvatarInitials {
+		// public like uploaded avatars, so an <img> can load it without credentials
+		api.HandleFunc(prefix+"/avatars/initials/{initials}", initialsAvatar).Methods("GET")
+	}
+}
+
+// h with its user reads served by a read replica when there are any, for handlers that write
+// nothing; a replica may trail the primary by a moment
+func replicaReads(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r.WithContext(store.WithReplicaReads(r.Context())))
+	}
+}
+
+// log the underlying error and write a generic 500 so internals don't leak
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	middleware.WriteServerError(w, r, err, "request failed")
+}
+
+// another user already has the address
+var errEmailTaken = models.APIError{Code: models.ErrCodeConflict, Message: "email already in use", Fields: map[string]string{"email": "email is already in use"}}
+
+func writeEmailTaken(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusConflict, errEmailTaken)
+}
+
+var errUsernameTaken = models.APIError{Code: models.ErrCodeConflict, Message: "username already in use", Fields: map[string]string{"username": "username is already in use"}}
+
+func writeUsernameTaken(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusConflict, errUsernameTaken)
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "user not found"})
+}
+
+// routes that stay open for as long as the client listens, so they get no request timeout
+var longLivedRoutes = map[string]bool{
+	"/ws":           true,
+	"/users/stream": true,
+	"/users/events": true,
+	"/users/export": true,
+	"/debug/":       true, // profiles and traces last as long as asked
+}
+
+func isLongLived(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	tmpl, err := route.GetPathTemplate()
+	return err == nil && longLivedRoutes[versionedPath(tmpl)]
+}
+
+// let clients retry h safely with an Idempotency-Key, when idempotency keys are configured
+func (a *App) idempotent(h http.HandlerFunc) http.HandlerFunc {
+	if a.idempotency == nil {
+		return h
+	}
+	return middleware.Idempotent(a.idempotency, a.maxBodySize)(h).ServeHTTP
+}
+
+// methods the router is probed with when building an Allow header
+var routeMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// JSON 404 for paths that match no route
+func notFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "no route for " + r.URL.Path})
+	})
+}
+
+// answers requests for a matched path in a method it has no route for: HEAD is served as GET
+// without the body, OPTIONS lists the methods the path accepts, and anything else gets a JSON 405
+// listing them
+func methodNotAllowedHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range routeMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if router.Match(probe, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+		getAllowed := slices.Contains(allowed, http.MethodGet)
+		if getAllowed {
+			allowed = append(allowed, http.MethodHead)
+		}
+		allowed = append(allowed, http.MethodOptions)
+
+		switch {
+		case r.Method == http.MethodHead && getAllowed:
+			serveHead(router, w, r)
+			return
+		case r.Method == http.MethodOptions:
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			w.Header().Del("Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		models.WriteError(w, http.StatusMethodNotAllowed, models.APIError{Code: models.ErrCodeMethodNotAllowed, Message: r.Method + " is not allowed on " + r.URL.Path})
+	})
+}
+
+// the {id} route variable as an int; ok is false when it is not a number
+func routeID(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	return id, err == nil
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api/internal/models"
+	"api/internal/store"
+)
+
+const testPassword = "Correct-Horse-9-battery"
+
+// an app over an empty in-memory repository, configured with opts
+func newTestApp(t *testing.T, opts Options) (*App, *store.MemoryUserRepository) {
+	t.Helper()
+	users := store.NewMemoryUserRepository()
+	return NewApp(users, NewFeed(), []byte("test-secret"), opts), users
+}
+
+// send a request to h as the holder of token, with body encoded as JSON unless it is nil
+func do(t *testing.T, h http.Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, path, &buf)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// register someone and return their access token; the first to register becomes the admin
+func register(t *testing.T, h http.Handler, name, email string) string {
+	t.Helper()
+	w := do(t, h, "POST", "/api/v1/auth/register", "", map[string]string{"name": name, "email": email, "password": testPassword})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register %s: status %d, body %s", email, w.Code, w.Body)
+	}
+	var resp authResponse
+	decode(t, w, &resp)
+	return resp.Token
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+}
+
+// the error response fields clients match on
+type testProblem struct {
+	Status int               `json:"status"`
+	Code   string            `json:"code"`
+	Detail string            `json:"detail"`
+	Fields map[string]string `json:"fields"`
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	app, _ := newTestApp(t, Options{})
+	h := app.Router()
+
+	for _, path := range []string{"/healthz", "/api/v1/version"} {
+		w := do(t, h, "POST", path, "", nil)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("POST %s: status = %d, want %d; body %s", path, w.Code, http.StatusMethodNotAllowed, w.Body)
+		}
+		if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+			t.Errorf("POST %s: Allow = %q, want %q", path, allow, "GET, HEAD, OPTIONS")
+		}
+		var p testProblem
+		decode(t, w, &p)
+		if p.Status != http.StatusMethodNotAllowed || p.Code != models.ErrCodeMethodNotAllowed {
+			t.Errorf("POST %s: problem = %+v, want status 405 and code %s", path, p, models.ErrCodeMethodNotAllowed)
+		}
+	}
+}
+
+func TestUnknownPath(t *testing.T) {
+	app, _ := newTestApp(t, Options{})
+	w := do(t, app.Router(), "GET", "/api/v1/no-such-thing", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusNotFound, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != models.ProblemContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, models.ProblemContentType)
+	}
+	var p testProblem
+	decode(t, w, &p)
+	if p.Code != models.ErrCodeNotFound || p.Detail != "no route for /api/v1/no-such-thing" {
+		t.Errorf("problem = %+v, want a not_found problem naming the path", p)
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"api/internal/models"
+)
+
+type auditPage struct {
+	Total int                 `json:"total"`
+	Page  int                 `json:"page"`
+	Limit int                 `json:"limit"`
+	Items []models.AuditEntry `json:"items"`
+}
+
+// parse an optional positive id query parameter, collecting problems into fields
+func parseIDParam(r *http.Request, name string, fields map[string]string) int {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		fields[name] = name + " must be a positive integer"
+	}
+	return n
+}
+
+// the audit trail, newest first; ?user_id= narrows it to changes to one user, ?actor_id= to changes by one
+func (a *App) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	limit, offset := parsePagination(r, fields)
+	f := models.AuditFilter{ActorId: parseIDParam(r, "actor_id", fields)}
+	if userID := parseIDParam(r, "user_id", fields); userID != 0 {
+		f.EntityType, f.EntityId = models.AuditEntityUser, userID
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: fields})
+		return
+	}
+
+	entries, total, err := a.audit.List(r.Context(), f, limit, offset)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, auditPage{Total: total, Page: offset/limit + 1, Limit: limit, Items: entries})
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type credentials struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type authResponse struct {
+	Token        string      `json:"token"`
+	ExpiresIn    int         `json:"expires_in"` // seconds until token expires
+	RefreshToken string      `json:"refresh_token"`
+	User         models.User `json:"user"`
+}
+
+// register a new account and sign it in
+func (a *App) register(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if !a.decodeBody(w, r, &c, "request body must be valid JSON credentials") {
+		return
+	}
+
+	u := models.User{Name: a.normalizeName(c.Name), Email: normalizeEmail(c.Email)}
+	fields := validateUser(u)
+	if msg := a.passwordPolicy.validate(c.Password, u.Email); msg != "" {
+		fields["password"] = msg
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "registration is invalid", Fields: fields})
+		return
+	}
+
+	exists, err := a.users.EmailExists(r.Context(), u.Email)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if exists {
+		writeEmailTaken(w)
+		return
+	}
+
+	u.PasswordHash, err = hashPassword(c.Password)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// the check above can race another registration; the unique index settles it
+	u, err = a.users.Create(r.Context(), u)
+	if err == store.ErrEmailTaken {
+		writeEmailTaken(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	a.userCreated(r.Context(), u)
+
+	session, err := a.startSession(r, u)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, presentSession(r, session))
+}
+
+// exchange an email and password for access and refresh tokens
+func (a *App) login(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if !a.decodeBody(w, r, &c, "request body must be valid JSON credentials") {
+		return
+	}
+	if a.directory != nil {
+		a.loginDirectory(w, r, c)
+		return
+	}
+
+	u, err := a.users.GetByEmail(r.Context(), normalizeEmail(c.Email))
+	if err != nil && err != store.ErrUserNotFound {
+		writeInternalError(w, r, err)
+		return
+	}
+	// unknown emails and accounts without a password fail the same way as a wrong password, after
+	// as long a check, so neither the answer nor its timing tells whether the email has an account
+	if err == store.ErrUserNotFound {
+		checkPassword("", c.Password)
+		a.recordLogin(r, models.LoginEvent{Email: normalizeEmail(c.Email), Method: models.LoginMethodPassword}, models.LoginFailedUnknownEmail)
+		models.WriteError(w, http.StatusUnauthorized, models.APIError{Code: models.ErrCodeUnauthorized, Message: "invalid email or password"})
+		return
+	}
+	attempt := models.LoginEvent{UserId: &u.Id, Email: u.Email, Method: models.LoginMethodPassword}
+	if a.lockedOut(w, r, attempt) {
+		return
+	}
+	if !checkPassword(u.PasswordHash, c.Password) {
+		a.recordLogin(r, attempt, models.LoginFailedPassword)
+		a.countFailedLogin(r, u.Id)
+		models.WriteError(w, http.StatusUnauthorized, models.APIError{Code: models.ErrCodeUnauthorized, Message: "invalid email or password"})
+		return
+	}
+
+	a.finishLogin(w, r, u, attempt)
+}
+
+// sign in u, whose credentials checked out, or answer with a challenge when they have two-factor
+// authentication on. the first factor only earns the challenge, to redeem at /auth/2fa, and the
+// login is recorded once that is done
+func (a *App) finishLogin(w http.ResponseWriter, r *http.Request, u models.User, attempt models.LoginEvent) {
+	if _, enabled, err := a.twoFactor.TOTP(r.Context(), u.Id); err != nil {
+		writeInternalError(w, r, err)
+		return
+	} else if enabled {
+		challenge, err := a.issueMFAChallenge(u.Id)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		writeBody(w, mfaChallengeResponse{MFARequired: true, Challenge: challenge})
+		return
+	}
+
+	session, err := a.startSession(r, u)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	a.recordLogin(r, attempt, "")
+
+	writeBody(w, presentSession(r, session))
+}
+
+// add an attempt to the login history, failed for the given reason or successful when there is
+// none. a history that can't be written is logged rather than failing the login
+func (a *App) recordLogin(r *http.Request, e models.LoginEvent, failure string) {
+	client := sessionClient(r)
+	e.IP, e.UserAgent = client.IP, client.UserAgent
+	e.Success = failure == ""
+	if !e.Success {
+		e.FailureReason = &failure
+	}
+	if err := a.logins.RecordLogin(r.Context(), e); err != nil {
+		slog.ErrorContext(r.Context(), "record login failed", "err", err, "email", e.Email)
+	}
+}
+
+// body accepted by the password change endpoint
+type passwordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// a hash of nobody's password, at the cost passwords are hashed with, so checking a login for an
+// unknown email or an account without a password takes as long as a wrong password does
+var noPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("no password"), bcrypt.DefaultCost)
+
+// report whether password matches the stored hash; accounts without a hash never match
+func checkPassword(hash, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(noPasswordHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// hash an optional password; users created without one cannot log in until it is set
+func optionalPasswordHash(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	return hashPassword(password)
+}
+
+// change a user's own password after confirming the current one
+func (a *App) changePassword(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeNotFound(w)
+		return
+	}
+	if caller, _ := middleware.UserID(r.Context()); caller != id {
+		models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "you can only change your own password"})
+		return
+	}
+
+	var req passwordChangeRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+
+	// the policy may refuse passwords containing the user's email
+	u, err := a.users.Get(r.Context(), id, false)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if msg := a.passwordPolicy.validate(req.NewPassword, u.Email); msg != "" {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "password is invalid", Fields: map[string]string{"new_password": msg}})
+		return
+	}
+
+	current, err := a.passwords.PasswordHash(r.Context(), id)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !checkPassword(current, req.CurrentPassword) {
+		models.WriteError(w, http.StatusUnauthorized, models.APIError{Code: models.ErrCodeUnauthorized, Message: "current password is incorrect", Fields: map[string]string{"current_password": "current password is incorrect"}})
+		return
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if err := a.passwords.SetPasswordHash(r.Context(), id, hash); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+// largest avatar accepted, in bytes
+const maxAvatarSize = 5 << 20
+
+// image types accepted as avatars, detected from the file's content rather than its name
+var avatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// upload an image as the multipart field "avatar" and set it as the user's avatar.
+// each user has one stored object, overwritten on every upload; the URL carries a version so caches refetch it
+func (a *App) uploadAvatar(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	if _, err := a.users.Get(r.Context(), id, false); err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	tooLarge := models.APIError{Code: models.ErrCodePayloadTooLarge, Message: fmt.Sprintf("avatar must be at most %d MiB", maxAvatarSize>>20)}
+	// leave room for the multipart framing around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+1<<20)
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			models.WriteError(w, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "upload an image as the multipart field \"avatar\"", Fields: map[string]string{"avatar": "avatar is required"}})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarSize+1))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if len(data) > maxAvatarSize {
+		models.WriteError(w, http.StatusRequestEntityTooLarge, tooLarge)
+		return
+	}
+	contentType := http.DetectContentType(data)
+	if !avatarTypes[contentType] {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "avatar is invalid", Fields: map[string]string{"avatar": "avatar must be a PNG, JPEG, GIF or WebP image"}})
+		return
+	}
+
+	url, err := a.avatars.Put(r.Context(), "avatars/"+strconv.Itoa(id), bytes.NewReader(data), contentType)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	u, err := a.users.SetAvatarURL(r.Context(), id, url+"?v="+strconv.FormatInt(time.Now().Unix(), 10))
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	a.feed.publish(r.Context(), models.EventUserUpdated, u)
+	writeBody(w, presentUser(r, u))
+}
+
+// ways of picturing users who haven't uploaded an avatar, see Options.DefaultAvatar
+const (
+	DefaultAvatarGravatar = "gravatar"
+	DefaultAvatarInitials = "initials"
+)
+
+// u as presented by the API r was routed through: without an uploaded avatar, its avatar_url is
+// the default one, so clients always have an image to show
+func withDefaultAvatar(r *http.Request, u models.User) models.User {
+	if u.AvatarURL != nil {
+		return u
+	}
+	var avatar string
+	switch api := routedAPI(r); api.defaultAvatar {
+	case DefaultAvatarGravatar:
+		avatar = gravatarURL(u.Email)
+	case DefaultAvatarInitials:
+		avatar = initialsAvatarURL(api.publicURL+api.prefix, u)
+	default:
+		return u
+	}
+	u.AvatarURL = &avatar
+	return u
+}
+
+// the Gravatar of an address, falling back to a generated identicon for addresses without one
+func gravatarURL(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "https://www.gravatar.com/avatar/" + hex.EncodeToString(sum[:]) + "?s=256&d=identicon"
+}
+
+// the first letters of the first and last words of a name, uppercased
+func initials(name string) string {
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return "?"
+	}
+	first, _ := utf8.DecodeRuneInString(words[0])
+	s := string(unicode.ToUpper(first))
+	if len(words) > 1 {
+		last, _ := utf8.DecodeRuneInString(words[len(words)-1])
+		s += string(unicode.ToUpper(last))
+	}
+	return s
+}
+
+// the initials avatar of u under the API's base URL, colored by its id so neighbours with the same
+// initials still look apart
+func initialsAvatarURL(base string, u models.User) string {
+	return base + "/avatars/initials/" + url.PathEscape(initials(u.Name)) + "?hue=" + strconv.Itoa(u.Id*137%360)
+}
+
+// an SVG of up to two initials on a colored square, which never changes for the same URL
+func initialsAvatar(w http.ResponseWriter, r *http.Request) {
+	text := mux.Vars(r)["initials"]
+	if n := utf8.RuneCountInString(text); n == 0 || n > 2 {
+		writeNotFound(w)
+		return
+	}
+	hue, err := strconv.Atoi(r.URL.Query().Get("hue"))
+	if err != nil || hue < 0 || hue >= 360 {
+		hue = 210
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="256" height="256" viewBox="0 0 256 256">`+
+		`<rect width="256" height="256" fill="hsl(%d, 45%%, 45%%)"/>`+
+		`<text x="50%%" y="50%%" dy=".35em" text-anchor="middle" font-family="sans-serif" font-size="104" fill="#fff">%s</text></svg>`,
+		hue, html.EscapeString(text))
+}
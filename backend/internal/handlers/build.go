@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build describes the binary serving requests, so the build behind a load balancer can be told apart.
+type Build struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	Modified  bool   `json:"modified"` // built from a tree with uncommitted changes
+	GoVersion string `json:"go_version"`
+}
+
+// ReadBuild describes the running binary by the values injected with -ldflags, taking those left
+// empty from the version control stamp go build records when run in a checkout.
+func ReadBuild(version, commit, buildTime string) Build {
+	b := Build{Version: version, Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if b.Commit == "" {
+				b.Commit = s.Value
+			}
+		case "vcs.time":
+			if b.BuildTime == "" {
+				b.BuildTime = s.Value
+			}
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+// the build serving the request
+func (a *App) getVersion(w http.ResponseWriter, r *http.Request) {
+	writeBody(w, a.build)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+type commentRequest struct {
+	Body string `json:"body"`
+}
+
+type commentPage struct {
+	Total int              `json:"total"`
+	Page  int              `json:"page"`
+	Limit int              `json:"limit"`
+	Items []models.Comment `json:"items"`
+}
+
+func writeCommentNotFound(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "comment not found"})
+}
+
+// decode a comment's body, having written an error unless it is valid
+func (a *App) decodeComment(w http.ResponseWriter, r *http.Request) (models.Comment, bool) {
+	var req commentRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return models.Comment{}, false
+	}
+	c := models.Comment{Body: req.Body}
+	if fields := validationErrors(c); len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "comment is invalid", Fields: fields})
+		return models.Comment{}, false
+	}
+	return c, true
+}
+
+// the comment named by the {commentId} route variable on the post named by {id}, having written
+// an error unless the caller wrote it or may manage everyone's posts. with ofPost, the author of
+// the post may also have it, to moderate what is said under their post
+func (a *App) ownComment(w http.ResponseWriter, r *http.Request, ofPost bool) (models.Comment, bool) {
+	p, ok := a.routePost(w, r)
+	if !ok {
+		return models.Comment{}, false
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["commentId"])
+	if err != nil {
+		writeCommentNotFound(w)
+		return models.Comment{}, false
+	}
+	c, err := a.posts.Comment(r.Context(), p.Id, id)
+	if err == store.ErrCommentNotFound {
+		writeCommentNotFound(w)
+		return models.Comment{}, false
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return models.Comment{}, false
+	}
+
+	caller, _ := middleware.UserID(r.Context())
+	if c.AuthorId == caller || (ofPost && p.AuthorId == caller) {
+		return c, true
+	}
+	granted, err := a.roles.HasPermission(r.Context(), caller, models.PermPostsManage)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return models.Comment{}, false
+	}
+	if !granted {
+		models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "only the author can change this comment", Fields: map[string]string{"permission": models.PermPostsManage + " is required"}})
+		return models.Comment{}, false
+	}
+	return c, true
+}
+
+// a post's comments, oldest first
+func (a *App) listComments(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	limit, offset := parsePagination(r, fields)
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: fields})
+		return
+	}
+	p, ok := a.routePost(w, r)
+	if !ok {
+		return
+	}
+
+	comments, total, err := a.posts.Comments(r.Context(), p.Id, limit, offset)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, commentPage{Total: total, Page: offset/limit + 1, Limit: limit, Items: comments})
+}
+
+// comment on a post as the caller
+func (a *App) createComment(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writePostNotFound(w)
+		return
+	}
+	c, ok := a.decodeComment(w, r)
+	if !ok {
+		return
+	}
+	c.PostId = id
+	c.AuthorId, _ = middleware.UserID(r.Context())
+
+	c, err := a.posts.AddComment(r.Context(), c)
+	switch err {
+	case nil:
+	case store.ErrPostNotFound:
+		writePostNotFound(w)
+		return
+	case store.ErrUserNotFound:
+		writeNotFound(w)
+		return
+	default:
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, c)
+}
+
+func (a *App) updateComment(w http.ResponseWriter, r *http.Request) {
+	changed, ok := a.decodeComment(w, r)
+	if !ok {
+		return
+	}
+	c, ok := a.ownComment(w, r, false)
+	if !ok {
+		return
+	}
+	changed.Id, changed.PostId = c.Id, c.PostId
+	c, err := a.posts.UpdateComment(r.Context(), changed)
+	if err == store.ErrCommentNotFound {
+		writeCommentNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, c)
+}
+
+func (a *App) deleteComment(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.ownComment(w, r, true)
+	if !ok {
+		return
+	}
+	if err := a.posts.DeleteComment(r.Context(), c.PostId, c.Id); err == store.ErrCommentNotFound {
+		writeCommentNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
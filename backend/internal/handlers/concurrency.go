@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"api/internal/models"
+)
+
+// the ETag of a user is its version, so If-Match can carry it back on updates
+func userETag(u models.User) string {
+	return `"` + strconv.Itoa(u.Version) + `"`
+}
+
+func setUserETag(w http.ResponseWriter, u models.User) {
+	w.Header().Set("ETag", userETag(u))
+}
+
+// the version an update must find, from If-Match or else the body's version. fromHeader says which
+// one it came from, since a stale If-Match is a failed precondition (412) while a stale body is a
+// conflict (409). writes the error response when neither is given or If-Match can't be parsed
+func expectedVersion(w http.ResponseWriter, r *http.Request, bodyVersion int) (version int, fromHeader, ok bool) {
+	if match := r.Header.Get("If-Match"); match != "" {
+		// "*" only asks for the user to exist, which the update checks anyway
+		if strings.TrimSpace(match) == "*" {
+			return 0, true, true
+		}
+		v, err := strconv.Atoi(strings.Trim(strings.TrimSpace(match), `"`))
+		if err != nil || v < 1 {
+			models.WriteError(w, http.StatusPreconditionFailed, models.APIError{Code: models.ErrCodePreconditionFailed, Message: "If-Match does not match the user's current version"})
+			return 0, true, false
+		}
+		return v, true, true
+	}
+	if bodyVersion > 0 {
+		return bodyVersion, false, true
+	}
+	models.WriteError(w, http.StatusPreconditionRequired, models.APIError{
+		Code:    models.ErrCodePreconditionRequired,
+		Message: "send the version being edited in If-Match or as version in the body, so concurrent edits aren't lost",
+	})
+	return 0, false, false
+}
+
+func writeVersionConflict(w http.ResponseWriter, fromHeader bool) {
+	if fromHeader {
+		models.WriteError(w, http.StatusPreconditionFailed, models.APIError{Code: models.ErrCodePreconditionFailed, Message: "user has been modified since it was read; fetch it again and retry"})
+		return
+	}
+	models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "user has been modified since it was read; fetch it again and retry", Fields: map[string]string{"version": "version is out of date"}})
+}
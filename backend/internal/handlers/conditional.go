@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"api/internal/codec"
+)
+
+// whether an If-None-Match header lists etag, comparing weakly as RFC 9110 asks for GET
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// whether the client's copy is still current: If-None-Match when sent, else If-Modified-Since.
+// a zero lastModified never satisfies If-Modified-Since
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		// HTTP dates have second precision
+		return err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// set the validators on a response and answer 304 when the client already has it; reports
+// whether it did, in which case there is nothing left to write
+func writeNotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	// let clients keep a copy but check back every time
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if !notModified(r, etag, lastModified) {
+		return false
+	}
+	// a 304 carries no body, so drop the content type set up front
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// encode v with a weak ETag over its bytes, for collections whose pages have no version of their own;
+// the query still runs, but an unchanged page isn't sent again
+func writeWithETag(w http.ResponseWriter, r *http.Request, v interface{}) {
+	var buf bytes.Buffer
+	if err := codec.Encode(&buf, responseFormat(w, v), responseXMLName, v); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if writeNotModified(w, r, `W/"`+hex.EncodeToString(sum[:16])+`"`, time.Time{}) {
+		return
+	}
+	w.Write(buf.Bytes())
+}
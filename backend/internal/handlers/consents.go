@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+)
+
+type acceptRequest struct {
+	Document string `json:"document"`
+	Version  string `json:"version"`
+}
+
+// the current versions of the documents users accept, public so they can be shown before signing up
+func (a *App) getLegalDocuments(w http.ResponseWriter, r *http.Request) {
+	docs := a.legalDocuments
+	if docs == nil {
+		docs = []models.LegalDocument{}
+	}
+	writeBody(w, docs)
+}
+
+func (a *App) listConsents(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	consents, err := a.consents.List(r.Context(), id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, consents)
+}
+
+// record the caller accepting the current version of a document. only users themselves can accept,
+// so an admin impersonating them can't
+func (a *App) acceptConsent(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	if caller, _ := middleware.UserID(r.Context()); caller != id {
+		models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "you can only accept on your own behalf"})
+		return
+	}
+	var req acceptRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	i := slices.IndexFunc(a.legalDocuments, func(d models.LegalDocument) bool { return d.Document == req.Document })
+	fields := map[string]string{}
+	switch {
+	case i < 0:
+		fields["document"] = "document must be one of those at /legal"
+	case req.Version != a.legalDocuments[i].Version:
+		fields["version"] = "version is out of date"
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "request is invalid", Fields: fields})
+		return
+	}
+
+	c, err := a.consents.Accept(r.Context(), models.Consent{UserId: id, Document: req.Document, Version: req.Version,
+		IP: middleware.ClientIPFromContext(r.Context()), UserAgent: r.UserAgent()})
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, c)
+}
+
+// answer 403 to callers who haven't accepted every current document, naming those they haven't in
+// the fields. admins impersonating them get through, as they can't accept for them
+func (a *App) consentGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := middleware.Impersonation(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		caller, _ := middleware.UserID(r.Context())
+		pending, err := a.consents.Pending(r.Context(), caller, a.legalDocuments)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if len(pending) > 0 {
+			fields := map[string]string{}
+			for _, d := range pending {
+				fields[d.Document] = "accept version " + d.Version
+			}
+			models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeConsentRequired, Message: "accept the current terms to continue", Fields: fields})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"api/internal/middleware"
+)
+
+type csrfResponse struct {
+	Token  string `json:"token"`
+	Header string `json:"header"` // where to send the token back
+}
+
+// issue a CSRF token for browser clients authenticating with cookies. it is set as a cookie and
+// returned here, to be echoed in a header on every unsafe request
+func (a *App) getCSRFToken(w http.ResponseWriter, r *http.Request) {
+	secure := strings.HasPrefix(a.publicURL, "https://")
+	token := middleware.IssueCSRFToken(w, a.tokenSecret, secure)
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, csrfResponse{Token: token, Header: middleware.CSRFHeaderName})
+}
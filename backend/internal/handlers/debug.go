@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"api/internal/middleware"
+	"api/internal/models"
+)
+
+// DebugHandler serves the runtime's profiles under /debug/pprof/ and its expvar variables at
+// /debug/vars, without any authentication of its own.
+func DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// DebugHandler for admins of the default tenant, who run the install. CPU profiles and traces
+// can't last longer than the server's write timeout
+func (a *App) debugHandler() http.Handler {
+	return a.authenticate(middleware.RequirePermission(a.roles, models.PermAdminRead)(instanceWide(DebugHandler().ServeHTTP)))
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"api/internal/codec"
+	"api/internal/models"
+)
+
+// default for Options.MaxBodySize
+const defaultMaxBodySize = 1 << 20
+
+// decode the request body into dst, writing the error response on failure. bodies are JSON unless
+// their Content-Type says XML or MessagePack, which are read as the JSON they translate to. bodies
+// over the configured size are refused with 413; anything but exactly one value matching dst,
+// including fields dst doesn't have, is refused with 400. invalid describes what the body should have been
+func (a *App) decodeBody(w http.ResponseWriter, r *http.Request, dst interface{}, invalid string) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, a.maxBodySize)
+	var body io.Reader = r.Body
+	if format := codec.Format(r.Header.Get("Content-Type")); format != codec.JSON {
+		converted, err := codec.ToJSON(r.Body, format, reflect.TypeOf(dst).Elem())
+		if err != nil {
+			writeDecodeError(w, err, invalid)
+			return false
+		}
+		body = bytes.NewReader(converted)
+	}
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errTrailingJSON
+	}
+	if err == nil {
+		return true
+	}
+	writeDecodeError(w, err, invalid)
+	return false
+}
+
+func writeDecodeError(w http.ResponseWriter, err error, invalid string) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		models.WriteError(w, http.StatusRequestEntityTooLarge, models.APIError{Code: models.ErrCodePayloadTooLarge, Message: fmt.Sprintf("request body must be at most %d bytes", maxErr.Limit)})
+		return
+	}
+	apiErr := models.APIError{Code: models.ErrCodeBadRequest, Message: invalid + ": " + jsonErrorDetail(err)}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		field = strings.Trim(field, `"`)
+		apiErr.Fields = map[string]string{field: field + " is not a known field"}
+	}
+	models.WriteError(w, http.StatusBadRequest, apiErr)
+}
+
+// write v as the response body, in the format middleware.Negotiate picked for it
+func writeBody(w http.ResponseWriter, v interface{}) {
+	codec.Encode(w, responseFormat(w, v), responseXMLName, v)
+}
+
+// the format v is written in. when JSON:API was negotiated, only JSON:API documents are labelled
+// as such and anything else goes out as plain JSON
+func responseFormat(w http.ResponseWriter, v interface{}) string {
+	if _, isDocument := v.(jsonAPIDocument); !isDocument && w.Header().Get("Content-Type") == codec.JSONAPI {
+		w.Header().Set("Content-Type", codec.JSON)
+	}
+	return codec.Format(w.Header().Get("Content-Type"))
+}
+
+// root element of XML response bodies
+var responseXMLName = xml.Name{Local: "response"}
+
+var errTrailingJSON = errors.New("body must hold a single JSON value")
+
+// what went wrong decoding a body, in terms a client can act on
+func jsonErrorDetail(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return "body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "body ends in the middle of a JSON value"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("malformed JSON at byte %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("%s must be a JSON %s", typeErr.Field, jsonTypeName(typeErr.Type.Kind()))
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("body must be a JSON %s", jsonTypeName(typeErr.Type.Kind()))
+	}
+	return strings.TrimPrefix(err.Error(), "json: ")
+}
+
+// the JSON name of a Go kind, for type mismatch messages
+func jsonTypeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"api/internal/ldapauth"
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// log in with credentials checked by the directory rather than a local password, creating the local
+// user on their first login and keeping their name in step with the directory after that. the
+// login may be a directory username as well as an email
+func (a *App) loginDirectory(w http.ResponseWriter, r *http.Request, c credentials) {
+	login := strings.TrimSpace(c.Email)
+	attempt := models.LoginEvent{Email: normalizeEmail(login), Method: models.LoginMethodDirectory}
+	// when the login is a known email the lockout applies before the directory is asked, so guesses
+	// don't run into the directory's own lockout. failures at usernames are left to that one
+	known, err := a.users.GetByEmail(r.Context(), normalizeEmail(login))
+	if err != nil && err != store.ErrUserNotFound {
+		writeInternalError(w, r, err)
+		return
+	}
+	if err == nil {
+		attempt.UserId, attempt.Email = &known.Id, known.Email
+		if a.lockedOut(w, r, attempt) {
+			return
+		}
+	}
+
+	entry, err := a.directory.Authenticate(r.Context(), login, c.Password)
+	if err == ldapauth.ErrInvalidCredentials {
+		if attempt.UserId != nil {
+			a.recordLogin(r, attempt, models.LoginFailedPassword)
+			a.countFailedLogin(r, known.Id)
+		} else {
+			a.recordLogin(r, attempt, models.LoginFailedUnknownEmail)
+		}
+		models.WriteError(w, http.StatusUnauthorized, models.APIError{Code: models.ErrCodeUnauthorized, Message: "invalid email or password"})
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	u, err := a.provisionDirectoryUser(r.Context(), entry)
+	if err == store.ErrEmailTaken {
+		// a deleted user still holds the email
+		models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "this account has been deleted"})
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	attempt = models.LoginEvent{UserId: &u.Id, Email: u.Email, Method: models.LoginMethodDirectory}
+	if a.lockedOut(w, r, attempt) {
+		return
+	}
+	a.finishLogin(w, r, u, attempt)
+}
+
+// the local user for a directory entry: created the first time with the directory's verified email,
+// and given the directory's name when it has changed there
+func (a *App) provisionDirectoryUser(ctx context.Context, entry ldapauth.Entry) (models.User, error) {
+	email := normalizeEmail(entry.Email)
+	name := a.normalizeName(entry.Name)
+	if name == "" || len(validateUser(models.User{Name: name, Email: email})) > 0 {
+		name, _, _ = strings.Cut(email, "@")
+	}
+
+	u, err := a.users.GetByEmail(ctx, email)
+	if err == store.ErrUserNotFound {
+		if u, err = a.users.Create(ctx, models.User{Name: name, Email: email}); err != nil {
+			return models.User{}, err
+		}
+		if u, err = a.users.MarkEmailVerified(ctx, u.Id, u.Email); err != nil {
+			return models.User{}, err
+		}
+		a.feed.publish(ctx, models.EventUserCreated, u)
+		return u, nil
+	} else if err != nil {
+		return models.User{}, err
+	}
+
+	if u.Name != name {
+		changed := u
+		changed.Name = name
+		if u, err = a.users.Update(ctx, u.Id, changed, 0); err != nil {
+			return models.User{}, err
+		}
+		a.feed.publish(ctx, models.EventUserUpdated, u)
+	}
+	// the directory vouches for the email as much as a verification link would
+	if u.EmailVerifiedAt == nil {
+		if u, err = a.users.MarkEmailVerified(ctx, u.Id, u.Email); err != nil {
+			return models.User{}, err
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"api/internal/codec"
+
+	swaggerFiles "github.com/swaggo/files/v2"
+	"go.yaml.in/yaml/v3"
+)
+
+//go:embed openapi.yaml
+var openapiYAML []byte
+
+// the spec is kept as YAML for editing and served as JSON, converted once at startup
+var openapiJSON = func() []byte {
+	var spec interface{}
+	if err := yaml.Unmarshal(openapiYAML, &spec); err != nil {
+		panic("openapi.yaml: " + err.Error())
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		panic("openapi.yaml: " + err.Error())
+	}
+	return data
+}()
+
+// points the bundled Swagger UI at our spec instead of its petstore demo
+const swaggerInitializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: "/api/go/openapi.json",
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+// Swagger UI styles itself inline and uses data: images, which the API's default policy forbids
+const docsContentSecurityPolicy = "default-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-ancestors 'none'"
+
+// serve the OpenAPI document describing every route
+func openapiSpec(w http.ResponseWriter, r *http.Request) {
+	// only ever JSON, whatever was negotiated
+	w.Header().Set("Content-Type", codec.JSON)
+	w.Write(openapiJSON)
+}
+
+// serve Swagger UI under /api/go/docs/, reading the spec from /api/go/openapi.json
+func docsHandler() http.Handler {
+	files := http.StripPrefix("/api/go/docs/", http.FileServer(http.FS(swaggerFiles.FS)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", docsContentSecurityPolicy)
+		// the file server picks the type from the file name, unless one is already set
+		w.Header().Del("Content-Type")
+		if r.URL.Path == "/api/go/docs/swagger-initializer.js" {
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+			w.Write([]byte(swaggerInitializer))
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
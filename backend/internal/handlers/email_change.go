@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"api/internal/mail"
+	"api/internal/models"
+	"api/internal/store"
+)
+
+type emailChangeRequest struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+// start moving a user to another address: it is kept as pending until the link emailed to it is
+// followed, and the current address, which stays in use until then, is told about the change
+func (a *App) changeEmail(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	var req emailChangeRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	req.Email = normalizeEmail(req.Email)
+	if fields := validationErrors(req); len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "request is invalid", Fields: fields})
+		return
+	}
+
+	u, err := a.users.Get(r.Context(), id, false)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	err = a.users.RequestEmailChange(r.Context(), id, req.Email)
+	if err == store.ErrEmailTaken {
+		writeEmailTaken(w)
+		return
+	} else if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	token, err := a.issueEmailChangeToken(r.Context(), u, req.Email)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	link := a.publicURL + "/api/v1/verify?token=" + url.QueryEscape(token)
+	m, err := mail.Render(mail.TemplateEmailChange, req.Email, mail.Data{Name: u.Name, Link: link})
+	if err == nil {
+		err = a.mailer.Send(r.Context(), m)
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	// the change goes ahead without the notice; it only warns the owner of the old address
+	m, err = mail.Render(mail.TemplateEmailChangeOld, u.Email, mail.Data{Name: u.Name, NewEmail: req.Email})
+	if err == nil {
+		err = a.mailer.Send(r.Context(), m)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "send email change notice failed", "err", err, "user_id", u.Id)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	writeBody(w, map[string]string{"status": "sent", "pending_email": req.Email})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"api/internal/mail"
+	"api/internal/models"
+)
+
+// a mail.Sender keeping what it is asked to send
+type recordingSender struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (s *recordingSender) Send(ctx context.Context, m mail.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+// the messages sent to an address, in order
+func (s *recordingSender) to(email string) []mail.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var to []mail.Message
+	for _, m := range s.sent {
+		if m.To == email {
+			to = append(to, m)
+		}
+	}
+	return to
+}
+
+func TestChangeEmail(t *testing.T) {
+	mailer := &recordingSender{}
+	app, _ := newTestApp(t, Options{Mailer: mailer})
+	h := app.Router()
+	token := register(t, h, "Ada Lovelace", "ada@example.com")
+
+	w := do(t, h, "POST", "/api/v1/users/1/change-email", token, map[string]string{"email": " Ada@Analytical.example "})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("change-email: status = %d, want %d; body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	var resp map[string]string
+	decode(t, w, &resp)
+	if resp["pending_email"] != "ada@analytical.example" {
+		t.Errorf("response = %v, want pending_email ada@analytical.example", resp)
+	}
+
+	confirm := mailer.to("ada@analytical.example")
+	if len(confirm) != 1 || !strings.Contains(confirm[0].Body, "/api/v1/verify?token=") {
+		t.Fatalf("mail to the new address = %+v, want one confirmation link", confirm)
+	}
+	if notice := mailer.to("ada@example.com"); len(notice) == 0 || !strings.Contains(notice[len(notice)-1].Body, "ada@analytical.example") {
+		t.Errorf("mail to the old address = %+v, want a notice naming the new one", notice)
+	}
+
+	// the current address stays in use until the link is followed
+	w = do(t, h, "GET", "/api/v1/users/1", token, nil)
+	var u models.User
+	decode(t, w, &u)
+	if u.Email != "ada@example.com" {
+		t.Errorf("before confirming email = %q, want ada@example.com", u.Email)
+	}
+
+	_, query, _ := strings.Cut(confirm[0].Body, "/api/v1/verify?")
+	query, _, _ = strings.Cut(query, "\n")
+	w = do(t, h, "GET", "/api/v1/verify?"+query, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("verify: status = %d, want %d; body %s", w.Code, http.StatusOK, w.Body)
+	}
+	u = models.User{}
+	decode(t, w, &u)
+	if u.Email != "ada@analytical.example" || u.EmailVerifiedAt == nil {
+		t.Errorf("after confirming user = %s verified at %v, want ada@analytical.example verified", u.Email, u.EmailVerifiedAt)
+	}
+
+	// confirming signs out every session, and the link only works once
+	if w = do(t, h, "GET", "/api/v1/users/1", token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("old token after confirming: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w = do(t, h, "GET", "/api/v1/verify?"+query, "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("verify again: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestChangeEmailTaken(t *testing.T) {
+	app, _ := newTestApp(t, Options{})
+	h := app.Router()
+	token := register(t, h, "Ada Lovelace", "ada@example.com")
+	register(t, h, "Grace Hopper", "grace@example.com")
+
+	w := do(t, h, "POST", "/api/v1/users/1/change-email", token, map[string]string{"email": "Grace@Example.com"})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusConflict, w.Body)
+	}
+	var p testProblem
+	decode(t, w, &p)
+	if p.Code != models.ErrCodeConflict || p.Fields["email"] == "" {
+		t.Errorf("problem = %+v, want a conflict on email", p)
+	}
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"api/internal/models"
+	"api/internal/store"
+	"api/internal/tenant"
+
+	"github.com/gorilla/mux"
+)
+
+// jobKindUserExport jobs generate one data export, see exportJob
+const jobKindUserExport = "user.export"
+
+// accounts with more logins, posts and audit entries than this are exported in the background
+const maxSyncExportItems = 1000
+
+// how long a generated export can be downloaded for
+const exportTTL = 7 * 24 * time.Hour
+
+// the payload of a jobKindUserExport job
+type exportJob struct {
+	TenantId int    `json:"tenant_id"`
+	ExportId int    `json:"export_id"`
+	UserId   int    `json:"user_id"`
+	Format   string `json:"format"`
+}
+
+// an export as its status endpoint reports it, with where to download it once it is ready
+type exportStatus struct {
+	models.DataExport
+	Download string `json:"download,omitempty"`
+}
+
+func writeExportNotFound(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "export not found"})
+}
+
+// call page with growing offsets until it has returned every one of the total items
+func eachPage(page func(limit, offset int) (n, total int, err error)) error {
+	for offset := 0; ; offset += maxPageLimit {
+		n, total, err := page(maxPageLimit, offset)
+		if err != nil {
+			return err
+		}
+		if n == 0 || offset+n >= total {
+			return nil
+		}
+	}
+}
+
+// how many logins, posts and audit entries a user has, to tell whether exporting them can wait
+func (a *App) exportSize(ctx context.Context, id int) (int, error) {
+	_, size, err := a.logins.Logins(ctx, id, 1, 0)
+	if err != nil {
+		return 0, err
+	}
+	if a.posts != nil {
+		_, n, err := a.posts.List(ctx, id, 1, 0)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	if a.audit != nil {
+		_, n, err := a.audit.List(ctx, models.AuditFilter{EntityType: models.AuditEntityUser, EntityId: id}, 1, 0)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	return size, nil
+}
+
+// everything held about a live user. secrets such as password hashes, TOTP secrets and token
+// hashes are never read, so they can't end up in an export
+func (a *App) collectUserData(ctx context.Context, id int) (models.UserData, error) {
+	u, err := a.users.Get(ctx, id, false)
+	if err != nil {
+		return models.UserData{}, err
+	}
+	data := models.UserData{ExportedAt: time.Now().UTC(), User: u}
+	if data.Settings, err = a.preferences.Settings(ctx, id); err != nil {
+		return models.UserData{}, err
+	}
+	if data.Preferences, err = a.preferences.NotificationPreferences(ctx, id); err != nil {
+		return models.UserData{}, err
+	}
+	if data.Tags, err = a.tags.UserTags(ctx, id); err != nil {
+		return models.UserData{}, err
+	}
+	if data.Sessions, err = a.sessions.Sessions(ctx, id); err != nil {
+		return models.UserData{}, err
+	}
+	if data.APIKeys, err = a.apiKeys.APIKeys(ctx, id); err != nil {
+		return models.UserData{}, err
+	}
+	if data.Revisions, err = a.revisions.Revisions(ctx, id); err != nil {
+		return models.UserData{}, err
+	}
+	err = eachPage(func(limit, offset int) (int, int, error) {
+		logins, total, err := a.logins.Logins(ctx, id, limit, offset)
+		data.Logins = append(data.Logins, logins...)
+		return len(logins), total, err
+	})
+	if err != nil {
+		return models.UserData{}, err
+	}
+	if a.orgs != nil {
+		if data.Organizations, err = a.orgs.ForUser(ctx, id); err != nil {
+			return models.UserData{}, err
+		}
+	}
+	if a.posts != nil {
+		err = eachPage(func(limit, offset int) (int, int, error) {
+			posts, total, err := a.posts.List(ctx, id, limit, offset)
+			data.Posts = append(data.Posts, posts...)
+			return len(posts), total, err
+		})
+		if err != nil {
+			return models.UserData{}, err
+		}
+	}
+	// only entries about the user: entries they made as an admin hold other people's data
+	if a.audit != nil {
+		err = eachPage(func(limit, offset int) (int, int, error) {
+			entries, total, err := a.audit.List(ctx, models.AuditFilter{EntityType: models.AuditEntityUser, EntityId: id}, limit, offset)
+			data.Audit = append(data.Audit, entries...)
+			return len(entries), total, err
+		})
+		if err != nil {
+			return models.UserData{}, err
+		}
+	}
+	return data, nil
+}
+
+// a user's data as an archive in format
+func encodeUserData(data models.UserData, format string) ([]byte, error) {
+	if format == models.ExportJSON {
+		return json.MarshalIndent(data, "", "  ")
+	}
+
+	// a file for each section, named by its JSON member
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(b, &sections); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range slices.Sorted(maps.Keys(sections)) {
+		var section bytes.Buffer
+		if err := json.Indent(&section, sections[name], "", "  "); err != nil {
+			return nil, err
+		}
+		f, err := zw.Create(name + ".json")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(section.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// write an export archive as a download named after the user
+func writeArchive(w http.ResponseWriter, userID int, archive []byte, format string) {
+	contentType := "application/json"
+	if format == models.ExportZIP {
+		contentType = "application/zip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.%s"`, userID, format))
+	w.Write(archive)
+}
+
+// download everything held about a user, as JSON or with ?format=zip as a ZIP. large accounts are
+// exported in the background when the job queue runs: the response is then 202 with the pending
+// export, to be polled at its Location until it can be downloaded
+func (a *App) exportUserData(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = models.ExportJSON
+	}
+	if format != models.ExportJSON && format != models.ExportZIP {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: map[string]string{"format": "format must be json or zip"}})
+		return
+	}
+	if _, err := a.users.Get(r.Context(), id, false); err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if a.exports != nil {
+		size, err := a.exportSize(r.Context(), id)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if size > maxSyncExportItems {
+			a.startExport(w, r, id, format)
+			return
+		}
+	}
+
+	data, err := a.collectUserData(r.Context(), id)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	archive, err := encodeUserData(data, format)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeArchive(w, id, archive, format)
+}
+
+// queue an export of a user's data, answering with where to poll it
+func (a *App) startExport(w http.ResponseWriter, r *http.Request, id int, format string) {
+	e, err := a.exports.Create(r.Context(), id, format, time.Now().Add(exportTTL))
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	job, err := a.jobPool.Enqueue(r.Context(), jobKindUserExport, exportJob{TenantId: tenant.ID(r.Context()), ExportId: e.Id, UserId: id, Format: format})
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if err := a.exports.SetJob(r.Context(), e.Id, job); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	e.JobId = &job
+	w.Header().Set("Location", exportURL(r, e))
+	w.WriteHeader(http.StatusAccepted)
+	writeBody(w, exportStatus{DataExport: e})
+}
+
+func exportURL(r *http.Request, e models.DataExport) string {
+	return routedAPI(r).prefix + "/users/" + strconv.Itoa(e.UserId) + "/exports/" + strconv.Itoa(e.Id)
+}
+
+// the export named by the {id} and {exportId} route variables, having written an error unless it exists
+func (a *App) routeExport(w http.ResponseWriter, r *http.Request) (models.DataExport, bool) {
+	id, ok := routeID(r)
+	if !ok {
+		writeExportNotFound(w)
+		return models.DataExport{}, false
+	}
+	exportID, err := strconv.Atoi(mux.Vars(r)["exportId"])
+	if err != nil {
+		writeExportNotFound(w)
+		return models.DataExport{}, false
+	}
+	e, err := a.exports.Get(r.Context(), id, exportID)
+	if err == store.ErrExportNotFound {
+		writeExportNotFound(w)
+		return models.DataExport{}, false
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return models.DataExport{}, false
+	}
+	return e, true
+}
+
+// how an export is getting on. a pending one whose job ran out of attempts has failed
+func (a *App) getExport(w http.ResponseWriter, r *http.Request) {
+	e, ok := a.routeExport(w, r)
+	if !ok {
+		return
+	}
+	if e.Status == models.ExportPending && e.JobId != nil && a.jobs != nil {
+		job, err := a.jobs.Get(r.Context(), *e.JobId)
+		if err != nil && err != store.ErrJobNotFound {
+			writeInternalError(w, r, err)
+			return
+		}
+		if err == store.ErrJobNotFound || job.Status == models.JobFailed {
+			e.Status = models.ExportFailed
+		}
+	}
+	status := exportStatus{DataExport: e}
+	if e.Status == models.ExportReady {
+		status.Download = exportURL(r, e) + "/archive"
+	}
+	writeBody(w, status)
+}
+
+func (a *App) downloadExport(w http.ResponseWriter, r *http.Request) {
+	e, ok := a.routeExport(w, r)
+	if !ok {
+		return
+	}
+	archive, err := a.exports.Archive(r.Context(), e.UserId, e.Id)
+	if err == store.ErrExportNotFound {
+		models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "export is not ready", Fields: map[string]string{"status": "status is " + e.Status}})
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeArchive(w, e.UserId, archive, e.Format)
+}
+
+// generate the export a job was queued for, in the tenant it was asked for in
+func (a *App) runExport(ctx context.Context, payload []byte) error {
+	var job exportJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	ctx = tenant.WithID(ctx, job.TenantId)
+	data, err := a.collectUserData(ctx, job.UserId)
+	if err == store.ErrUserNotFound {
+		// deleted since it was asked for; there is nothing left to export
+		return a.exports.Fail(ctx, job.ExportId)
+	} else if err != nil {
+		return err
+	}
+	archive, err := encodeUserData(data, job.Format)
+	if err != nil {
+		return err
+	}
+	return a.exports.Finish(ctx, job.ExportId, archive)
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"api/internal/codec"
+	"api/internal/models"
+	"api/internal/tenant"
+)
+
+// number of recent events kept so SSE clients can resume after a reconnect
+const feedHistorySize = 1000
+
+// Feed fans out user change events to every connected stream in the same tenant.
+type Feed struct {
+	mu          sync.Mutex
+	subscribers map[chan models.UserEvent]int // the tenant each stream is in
+	lastID      int64
+	history     []feedEvent
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// an event along with the tenant of its user
+type feedEvent struct {
+	models.UserEvent
+	tenantID int
+}
+
+// NewFeed returns an empty feed.
+func NewFeed() *Feed {
+	return &Feed{subscribers: map[chan models.UserEvent]int{}, done: make(chan struct{})}
+}
+
+// Close tells every streaming subscriber to finish; call it when the server shuts down.
+func (f *Feed) Close() {
+	f.closeOnce.Do(func() { close(f.done) })
+}
+
+// subscribe to the events of the tenant of ctx
+func (f *Feed) subscribe(ctx context.Context) chan models.UserEvent {
+	ch := make(chan models.UserEvent, 16)
+	f.mu.Lock()
+	f.subscribers[ch] = tenant.ID(ctx)
+	f.mu.Unlock()
+	return ch
+}
+
+func (f *Feed) unsubscribe(ch chan models.UserEvent) {
+	f.mu.Lock()
+	delete(f.subscribers, ch)
+	f.mu.Unlock()
+}
+
+// subscribe and, in the same critical section, return retained events newer than
+// lastID so nothing published in between is missed or duplicated
+func (f *Feed) subscribeSince(ctx context.Context, lastID int64) (chan models.UserEvent, []models.UserEvent) {
+	ch := make(chan models.UserEvent, 16)
+	tenantID := tenant.ID(ctx)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subscribers[ch] = tenantID
+	var backlog []models.UserEvent
+	for _, e := range f.history {
+		if e.ID > lastID && e.tenantID == tenantID {
+			backlog = append(backlog, e.UserEvent)
+		}
+	}
+	return ch, backlog
+}
+
+// send to the subscribers in the tenant of ctx, which u is in, without blocking; a subscriber
+// that is not keeping up misses the event
+func (f *Feed) publish(ctx context.Context, eventType string, u models.User) {
+	tenantID := tenant.ID(ctx)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastID++
+	e := models.UserEvent{ID: f.lastID, Type: eventType, User: u, At: time.Now()}
+	f.history = append(f.history, feedEvent{UserEvent: e, tenantID: tenantID})
+	if len(f.history) > feedHistorySize {
+		f.history = f.history[len(f.history)-feedHistorySize:]
+	}
+	for ch, subscribed := range f.subscribers {
+		if subscribed != tenantID {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// stream the existing users as newline-delimited JSON or, only for clients naming
+// Accept: text/event-stream as EventSource does, created users as server-sent events until the
+// client disconnects
+func (a *App) streamUsers(w http.ResponseWriter, r *http.Request) {
+	if !codec.Names(r.Header.Get("Accept"), "text/event-stream") {
+		a.streamUserCollection(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeInternalError(w, r, fmt.Errorf("streaming unsupported by %T", w))
+		return
+	}
+
+	ch := a.feed.subscribe(r.Context())
+	defer a.feed.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.feed.done:
+			return
+		case e := <-ch:
+			if e.Type != models.EventUserCreated {
+				continue
+			}
+			data, err := json.Marshal(linkedVersionedUser(r, e.User))
+			if err != nil {
+				slog.ErrorContext(r.Context(), "encode stream event", "err", err)
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+// interval between SSE comments that keep idle proxies from closing the stream
+const sseKeepAlive = 30 * time.Second
+
+// write one event in SSE framing, with its id so the client can resume from it
+func writeSSEEvent(w http.ResponseWriter, r *http.Request, e models.UserEvent) error {
+	data, err := json.Marshal(presentEvent(r, e))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// stream every user change as server-sent events, replaying what the client
+// missed when it reconnects with Last-Event-ID
+func (a *App) userChangeEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeInternalError(w, r, fmt.Errorf("streaming unsupported by %T", w))
+		return
+	}
+
+	var lastID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "Last-Event-ID must be an event id"})
+			return
+		}
+		lastID = id
+	}
+
+	ch, backlog := a.feed.subscribeSince(r.Context(), lastID)
+	defer a.feed.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range backlog {
+		if err := writeSSEEvent(w, r, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.feed.done:
+			return
+		case e := <-ch:
+			if err := writeSSEEvent(w, r, e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
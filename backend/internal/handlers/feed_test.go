@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api/internal/models"
+)
+
+// open an event stream at path on srv; the server has subscribed once the response arrives
+func openStream(t *testing.T, srv *httptest.Server, path, token string) *bufio.Reader {
+	t.Helper()
+	r, err := http.NewRequest("GET", srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Accept", "text/event-stream")
+	resp, err := srv.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET %s: status %d, Content-Type %q", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// read the next SSE frame, as its field lines, skipping comments
+func readFrame(t *testing.T, r *bufio.Reader) map[string]string {
+	t.Helper()
+	frame := map[string]string{}
+	done := make(chan error, 1)
+	go func() {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				done <- err
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" && len(frame) > 0 {
+				done <- nil
+				return
+			}
+			if field, value, ok := strings.Cut(line, ": "); ok && field != "" {
+				frame[field] = value
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event within 5s")
+	}
+	return frame
+}
+
+func TestStreamSendsCreatedUsers(t *testing.T) {
+	app, _ := newTestApp(t, Options{})
+	srv := httptest.NewServer(app.Router())
+	// cleanups run last first, so the streams are closed before the server waits for them
+	t.Cleanup(srv.Close)
+	token := register(t, srv.Config.Handler, "Ada Lovelace", "ada@example.com")
+
+	stream := openStream(t, srv, "/api/v1/users/stream", token)
+	events := openStream(t, srv, "/api/v1/users/events", token)
+	w := do(t, srv.Config.Handler, "POST", "/api/v1/users", token, map[string]string{"name": "Grace Hopper", "email": "grace@example.com"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: status %d, body %s", w.Code, w.Body)
+	}
+	var created models.User
+	decode(t, w, &created)
+
+	frame := readFrame(t, stream)
+	var u models.User
+	if err := json.Unmarshal([]byte(frame["data"]), &u); err != nil {
+		t.Fatalf("stream data %q: %v", frame["data"], err)
+	}
+	if u.Id != created.Id || u.Email != "grace@example.com" {
+		t.Errorf("stream sent user %d <%s>, want %d <grace@example.com>", u.Id, u.Email, created.Id)
+	}
+
+	// the events endpoint replays what it still has first: the admin's registration
+	for _, want := range []int{1, created.Id} {
+		frame = readFrame(t, events)
+		if frame["event"] != models.EventUserCreated || frame["id"] == "" {
+			t.Errorf("events frame = %v, want a %s event with an id", frame, models.EventUserCreated)
+		}
+		var e models.UserEvent
+		if err := json.Unmarshal([]byte(frame["data"]), &e); err != nil {
+			t.Fatalf("events data %q: %v", frame["data"], err)
+		}
+		if e.Type != models.EventUserCreated || e.User.Id != want {
+			t.Errorf("events sent %s of user %d, want %s of %d", e.Type, e.User.Id, models.EventUserCreated, want)
+		}
+	}
+}
+
+func TestStreamDefaultsToNDJSON(t *testing.T) {
+	app, _ := newTestApp(t, Options{})
+	h := app.Router()
+	token := register(t, h, "Ada Lovelace", "ada@example.com")
+
+	// without an Accept naming text/event-stream, the stream is the collection, and ends
+	for _, accept := range []string{"", "*/*", "application/json"} {
+		r := httptest.NewRequest("GET", "/api/v1/users/stream", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("Accept %q: status %d, Content-Type %q", accept, w.Code, w.Header().Get("Content-Type"))
+		}
+		var u models.User
+		if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil || u.Email != "ada@example.com" {
+			t.Errorf("Accept %q: body %s, want the one user as a line of JSON", accept, w.Body)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"api/internal/models"
+)
+
+// a ?fields= selection for a user listing: the fields read from the store, named as in v1, and
+// the members to keep in each presented user, dotted for the members of v2's profile
+type fieldset struct {
+	columns []string
+	keep    map[string]bool
+}
+
+// the names ?fields= takes under the version r was routed through
+func selectableFields(r *http.Request) []string {
+	if apiVersion(r) != apiV2 {
+		return models.SelectableUserFields
+	}
+	names := []string{"profile"}
+	for _, name := range models.SelectableUserFields {
+		if v2, ok := v2FieldNames[name]; ok {
+			name = v2
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// parse ?fields=, a comma-separated list of user members, recording a field error for any it
+// doesn't know. without the parameter the zero fieldset selects everything
+func parseFieldset(r *http.Request, fields map[string]string) fieldset {
+	raw := r.URL.Query().Get("fields")
+	if raw == "" {
+		return fieldset{}
+	}
+	names := selectableFields(r)
+	fs := fieldset{keep: map[string]bool{}}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(names, name) {
+			fields["fields"] = "fields must be a comma-separated list of " + strings.Join(names, ", ")
+			return fieldset{}
+		}
+		fs.keep[name] = true
+		switch name {
+		case "profile":
+			fs.columns = append(fs.columns, "name", "avatar_url", "phone", "bio", "timezone", "locale")
+		default:
+			fs.columns = append(fs.columns, strings.TrimPrefix(name, "profile."))
+		}
+	}
+	// a default avatar is made from the name or email, which are read for it even if not kept
+	if slices.Contains(fs.columns, "avatar_url") {
+		fs.columns = append(fs.columns, "name", "email")
+	}
+	return fs
+}
+
+// the fieldset r asks for, already validated by the handler
+func requestedFieldset(r *http.Request) fieldset {
+	return parseFieldset(r, map[string]string{})
+}
+
+// v with only the members the fieldset keeps, along with its _links. members of a nested object,
+// such as v2's profile, are kept one by one
+func (fs fieldset) apply(v interface{}) interface{} {
+	if fs.keep == nil {
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var m map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if dec.Decode(&m) != nil {
+		return v
+	}
+	for k, member := range m {
+		if k == "_links" || fs.keep[k] {
+			continue
+		}
+		nested, ok := member.(map[string]interface{})
+		if !ok {
+			delete(m, k)
+			continue
+		}
+		for nk := range nested {
+			if !fs.keep[k+"."+nk] {
+				delete(nested, nk)
+			}
+		}
+		if len(nested) == 0 {
+			delete(m, k)
+		}
+	}
+	return m
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+// flag keys are short lowercase names such as new-dashboard or billing.v2
+var flagKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// longest flag description accepted, in characters
+const maxFlagDescription = 500
+
+// body of creating a flag or, without the key, replacing one
+type flagRequest struct {
+	Key         string   `json:"key"`
+	Description string   `json:"description"`
+	Enabled     bool     `json:"enabled"`
+	Rollout     int      `json:"rollout"`
+	Users       []int    `json:"users"`
+	Roles       []string `json:"roles"`
+}
+
+func writeFlagNotFound(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "feature flag not found"})
+}
+
+// the flag req describes, and field errors when it is invalid; targets listed twice are listed once
+func (a *App) validateFlag(r *http.Request, req flagRequest) (models.FeatureFlag, map[string]string, error) {
+	fields := map[string]string{}
+	f := models.FeatureFlag{Key: req.Key, Description: strings.TrimSpace(req.Description), Enabled: req.Enabled, Rollout: req.Rollout}
+	if utf8.RuneCountInString(f.Description) > maxFlagDescription {
+		fields["description"] = "description must be at most 500 characters"
+	}
+	if f.Rollout < 0 || f.Rollout > 100 {
+		fields["rollout"] = "rollout must be a percentage from 0 to 100"
+	}
+	for _, id := range req.Users {
+		if id <= 0 {
+			fields["users"] = "users must list user ids"
+		} else if !slices.Contains(f.Users, id) {
+			f.Users = append(f.Users, id)
+		}
+	}
+	if len(req.Roles) > 0 {
+		roles, err := a.roles.Roles(r.Context())
+		if err != nil {
+			return f, nil, err
+		}
+		for _, role := range req.Roles {
+			if !slices.ContainsFunc(roles, func(known models.Role) bool { return known.Name == role }) {
+				fields["roles"] = "roles must list existing roles"
+			} else if !slices.Contains(f.Roles, role) {
+				f.Roles = append(f.Roles, role)
+			}
+		}
+	}
+	return f, fields, nil
+}
+
+func (a *App) listFlags(w http.ResponseWriter, r *http.Request) {
+	flags, err := a.flags.List(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, flags)
+}
+
+func (a *App) createFlag(w http.ResponseWriter, r *http.Request) {
+	var req flagRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	f, fields, err := a.validateFlag(r, req)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !flagKeyPattern.MatchString(f.Key) {
+		fields["key"] = "key must be 1 to 64 lowercase letters, digits, ., - or _, starting with a letter or digit"
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "feature flag is invalid", Fields: fields})
+		return
+	}
+
+	f, err = a.flags.Create(r.Context(), f)
+	if err == store.ErrFlagExists {
+		models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "feature flag already exists", Fields: map[string]string{"key": "key is already in use"}})
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, f)
+}
+
+func (a *App) getFlag(w http.ResponseWriter, r *http.Request) {
+	f, err := a.flags.Get(r.Context(), mux.Vars(r)["key"])
+	if err == store.ErrFlagNotFound {
+		writeFlagNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, f)
+}
+
+// replace a flag's settings; its key stays, whatever the body says
+func (a *App) updateFlag(w http.ResponseWriter, r *http.Request) {
+	var req flagRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	f, fields, err := a.validateFlag(r, req)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "feature flag is invalid", Fields: fields})
+		return
+	}
+
+	f, err = a.flags.Update(r.Context(), mux.Vars(r)["key"], f)
+	if err == store.ErrFlagNotFound {
+		writeFlagNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, f)
+}
+
+func (a *App) deleteFlag(w http.ResponseWriter, r *http.Request) {
+	if err := a.flags.Delete(r.Context(), mux.Vars(r)["key"]); err == store.ErrFlagNotFound {
+		writeFlagNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// whether each flag is on for a user, by key, for the frontend to show or hide what they gate
+func (a *App) getUserFlags(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	u, err := a.users.Get(r.Context(), id, false)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	flags, err := a.flags.List(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	on := make(map[string]bool, len(flags))
+	for _, f := range flags {
+		on[f.Key] = f.EnabledFor(u)
+	}
+	writeBody(w, on)
+}
@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// schema served at /graphql under each API version, backed by the same queries as the REST handlers
+const graphqlSchema = `
+	schema {
+		query: Query
+		mutation: Mutation
+	}
+
+	type Query {
+		users(
+			limit: Int = 20
+			offset: Int = 0
+			sort: String
+			order: String
+			emailContains: String
+			createdAfter: String
+			createdBefore: String
+			includeDeleted: Boolean = false
+		): UserPage!
+		user(id: ID!, includeDeleted: Boolean = false): User
+	}
+
+	type Mutation {
+		createUser(input: UserInput!): User!
+		updateUser(id: ID!, input: UserInput!, version: Int): User!
+		deleteUser(id: ID!): Boolean!
+	}
+
+	# like a PUT, an update replaces the profile fields: those left out are cleared
+	input UserInput {
+		name: String!
+		# derived from the name when left out; an update keeps the current one
+		username: String
+		email: String!
+		password: String
+		phone: String
+		bio: String
+		timezone: String
+		locale: String
+	}
+
+	type UserPage {
+		total: Int!
+		page: Int!
+		limit: Int!
+		items: [User!]!
+	}
+
+	type User {
+		id: ID!
+		name: String!
+		username: String!
+		email: String!
+		createdAt: String!
+		deletedAt: String
+		avatarUrl: String
+		phone: String
+		bio: String
+		timezone: String
+		locale: String
+		role: String!
+		version: Int!
+	}
+`
+
+// parse the schema and bind it to resolvers over the app
+func newGraphQLSchema(a *App) *graphql.Schema {
+	return graphql.MustParseSchema(graphqlSchema, &graphqlResolver{app: a})
+}
+
+// execute GraphQL requests, answering malformed bodies with the usual error envelope
+func (a *App) graphqlHandler(schema *graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params struct {
+			Query         string                 `json:"query"`
+			OperationName string                 `json:"operationName"`
+			Variables     map[string]interface{} `json:"variables"`
+			Extensions    map[string]interface{} `json:"extensions"` // sent by some clients, unused here
+		}
+		if !a.decodeBody(w, r, &params, "request body must be a JSON GraphQL request") {
+			return
+		}
+
+		writeBody(w, schema.Exec(r.Context(), params.Query, params.OperationName, params.Variables))
+	}
+}
+
+var errGraphQLNotFound = models.APIError{Code: models.ErrCodeNotFound, Message: "user not found"}
+
+// log the underlying error and hide it behind a generic internal error
+func graphqlInternalError(ctx context.Context, err error) error {
+	var open *store.CircuitOpenError
+	if errors.As(err, &open) {
+		slog.WarnContext(ctx, "graphql resolver failed", "err", err)
+		return models.APIError{Code: models.ErrCodeUnavailable, Message: "database unavailable, try again later"}
+	}
+	slog.ErrorContext(ctx, "graphql resolver failed", "err", err)
+	return models.APIError{Code: models.ErrCodeInternal, Message: "internal server error"}
+}
+
+type graphqlResolver struct {
+	app *App
+}
+
+type userPageResolver struct {
+	total, page, limit int
+	users              []models.User
+}
+
+func (r userPageResolver) Total() int32 { return int32(r.total) }
+
+func (r userPageResolver) Page() int32 { return int32(r.page) }
+
+func (r userPageResolver) Limit() int32 { return int32(r.limit) }
+
+func (r userPageResolver) Items() []userResolver {
+	items := make([]userResolver, len(r.users))
+	for i, u := range r.users {
+		items[i] = userResolver{u}
+	}
+	return items
+}
+
+type userResolver struct {
+	u models.User
+}
+
+func (r userResolver) ID() graphql.ID { return graphql.ID(strconv.Itoa(r.u.Id)) }
+
+func (r userResolver) Name() string { return r.u.Name }
+
+func (r userResolver) Username() string { return r.u.Username }
+
+func (r userResolver) Email() string { return r.u.Email }
+
+func (r userResolver) CreatedAt() string { return r.u.CreatedAt.Format(time.RFC3339) }
+
+func (r userResolver) DeletedAt() *string {
+	if r.u.DeletedAt == nil {
+		return nil
+	}
+	s := r.u.DeletedAt.Format(time.RFC3339)
+	return &s
+}
+
+func (r userResolver) AvatarUrl() *string { return r.u.AvatarURL }
+
+func (r userResolver) Phone() *string { return r.u.Phone }
+
+func (r userResolver) Bio() *string { return r.u.Bio }
+
+func (r userResolver) Timezone() *string { return r.u.Timezone }
+
+func (r userResolver) Locale() *string { return r.u.Locale }
+
+func (r userResolver) Role() string { return r.u.Role }
+
+func (r userResolver) Version() int32 { return int32(r.u.Version) }
+
+// check a mutation's permission, hiding lookup failures behind the usual internal error
+func (r *graphqlResolver) authorize(ctx context.Context, permission string) error {
+	err := r.app.authorize(ctx, permission)
+	if _, denied := err.(models.APIError); err != nil && !denied {
+		return graphqlInternalError(ctx, err)
+	}
+	return err
+}
+
+type usersArgs struct {
+	Limit          int32
+	Offset         int32
+	Sort           *string
+	Order          *string
+	EmailContains  *string
+	CreatedAfter   *string
+	CreatedBefore  *string
+	IncludeDeleted bool
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (r *graphqlResolver) Users(ctx context.Context, args usersArgs) (userPageResolver, error) {
+	fields := map[string]string{}
+	limit, offset := int(args.Limit), int(args.Offset)
+	if limit < 1 || limit > maxPageLimit {
+		fields["limit"] = "limit must be between 1 and " + strconv.Itoa(maxPageLimit)
+	}
+	if offset < 0 {
+		fields["offset"] = "offset must be a non-negative integer"
+	}
+	f := models.UserFilter{
+		EmailContains:  deref(args.EmailContains),
+		CreatedAfter:   parseTimeParam(deref(args.CreatedAfter), "createdAfter", fields),
+		CreatedBefore:  parseTimeParam(deref(args.CreatedBefore), "createdBefore", fields),
+		IncludeDeleted: args.IncludeDeleted,
+	}
+	sort := resolveSort(deref(args.Sort), deref(args.Order), fields)
+	if len(fields) > 0 {
+		return userPageResolver{}, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query arguments", Fields: fields}
+	}
+	if f.IncludeDeleted {
+		if err := r.authorize(ctx, models.PermUsersDelete); err != nil {
+			return userPageResolver{}, err
+		}
+	}
+
+	users, total, err := r.app.users.List(ctx, f, sort, limit, offset)
+	if err != nil {
+		return userPageResolver{}, graphqlInternalError(ctx, err)
+	}
+	return userPageResolver{total: total, page: offset/limit + 1, limit: limit, users: maskUsers(ctx, users)}, nil
+}
+
+func parseGraphQLID(id graphql.ID) (int, error) {
+	n, err := strconv.Atoi(string(id))
+	if err != nil {
+		return 0, errGraphQLNotFound
+	}
+	return n, nil
+}
+
+func (r *graphqlResolver) User(ctx context.Context, args struct {
+	ID             graphql.ID
+	IncludeDeleted bool
+}) (*userResolver, error) {
+	id, err := parseGraphQLID(args.ID)
+	if err != nil {
+		return nil, nil
+	}
+	if args.IncludeDeleted {
+		if err := r.authorize(ctx, models.PermUsersDelete); err != nil {
+			return nil, err
+		}
+	}
+	u, err := r.app.users.Get(ctx, id, args.IncludeDeleted)
+	if err == store.ErrUserNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, graphqlInternalError(ctx, err)
+	}
+	return &userResolver{maskUser(ctx, u)}, nil
+}
+
+type userInput struct {
+	Name     string
+	Username *string
+	Email    string
+	Password *string
+	Phone    *string
+	Bio      *string
+	Timezone *string
+	Locale   *string
+}
+
+func (in userInput) request() newUserRequest {
+	u := models.User{Name: in.Name, Username: deref(in.Username), Email: in.Email, Phone: in.Phone, Bio: in.Bio, Timezone: in.Timezone, Locale: in.Locale}
+	return newUserRequest{User: u, Password: deref(in.Password)}
+}
+
+func (r *graphqlResolver) CreateUser(ctx context.Context, args struct{ Input userInput }) (userResolver, error) {
+	if err := r.authorize(ctx, models.PermUsersWrite); err != nil {
+		return userResolver{}, err
+	}
+	req := args.Input.request()
+	u, fields := r.app.validateNewUser(req)
+	if len(fields) > 0 {
+		return userResolver{}, models.APIError{Code: models.ErrCodeValidationFailed, Message: "user is invalid", Fields: fields}
+	}
+
+	var err error
+	u.PasswordHash, err = optionalPasswordHash(req.Password)
+	if err != nil {
+		return userResolver{}, graphqlInternalError(ctx, err)
+	}
+	u, err = r.app.users.Create(ctx, u)
+	if err == store.ErrEmailTaken {
+		return userResolver{}, errEmailTaken
+	} else if err == store.ErrUsernameTaken {
+		return userResolver{}, errUsernameTaken
+	} else if err != nil {
+		return userResolver{}, graphqlInternalError(ctx, err)
+	}
+	r.app.userCreated(ctx, u)
+	return userResolver{maskUser(ctx, u)}, nil
+}
+
+func (r *graphqlResolver) UpdateUser(ctx context.Context, args struct {
+	ID      graphql.ID
+	Input   userInput
+	Version *int32
+}) (userResolver, error) {
+	id, err := parseGraphQLID(args.ID)
+	if err != nil {
+		return userResolver{}, err
+	}
+	// like PUT /users/{id}, anyone may edit their own profile
+	if caller, _ := middleware.UserID(ctx); caller != id {
+		if err := r.authorize(ctx, models.PermUsersWrite); err != nil {
+			return userResolver{}, err
+		}
+	}
+	u, fields := r.app.validateNewUser(args.Input.request())
+	if len(fields) > 0 {
+		return userResolver{}, models.APIError{Code: models.ErrCodeValidationFailed, Message: "user is invalid", Fields: fields}
+	}
+
+	// without a version the update is unconditional
+	var version int
+	if args.Version != nil {
+		version = int(*args.Version)
+	}
+	u, err = r.app.users.Update(ctx, id, u, version)
+	if err == store.ErrUserNotFound {
+		return userResolver{}, errGraphQLNotFound
+	} else if err == store.ErrVersionConflict {
+		return userResolver{}, models.APIError{Code: models.ErrCodeConflict, Message: "user has been modified since it was read; fetch it again and retry", Fields: map[string]string{"version": "version is out of date"}}
+	} else if err == store.ErrEmailTaken {
+		return userResolver{}, errEmailTaken
+	} else if err == store.ErrUsernameTaken {
+		return userResolver{}, errUsernameTaken
+	} else if err != nil {
+		return userResolver{}, graphqlInternalError(ctx, err)
+	}
+	r.app.feed.publish(ctx, models.EventUserUpdated, u)
+	return userResolver{maskUser(ctx, u)}, nil
+}
+
+func (r *graphqlResolver) DeleteUser(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	if err := r.authorize(ctx, models.PermUsersDelete); err != nil {
+		return false, err
+	}
+	id, err := parseGraphQLID(args.ID)
+	if err != nil {
+		return false, err
+	}
+	u, err := r.app.users.Delete(ctx, id)
+	if err == store.ErrUserNotFound {
+		return false, errGraphQLNotFound
+	} else if err != nil {
+		return false, graphqlInternalError(ctx, err)
+	}
+	r.app.feed.publish(ctx, models.EventUserDeleted, u)
+	return true, nil
+}
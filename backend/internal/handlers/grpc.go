@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+	"api/internal/tenant"
+	"api/userpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// implements userpb.UserServiceServer over the same repository as the REST handlers
+type userServer struct {
+	userpb.UnimplementedUserServiceServer
+	app *App
+}
+
+// GRPCServer exposes the UserService over the app's repository and feed; the caller owns serving and
+// stopping it. calls need the same credentials and permissions as the REST routes, sent as
+// authorization metadata, and see users masked as those do.
+func (a *App) GRPCServer() *grpc.Server {
+	var interceptors []grpc.UnaryServerInterceptor
+	if a.tenants != nil {
+		interceptors = append(interceptors, a.grpcTenant)
+	}
+	interceptors = append(interceptors, a.grpcAuth)
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	userpb.RegisterUserServiceServer(srv, &userServer{app: a})
+	return srv
+}
+
+// the permission each method needs, as its REST route does; callers update their own account without one
+var grpcPermissions = map[string]string{
+	userpb.UserService_Get_FullMethodName:    models.PermUsersRead,
+	userpb.UserService_List_FullMethodName:   models.PermUsersRead,
+	userpb.UserService_Create_FullMethodName: models.PermUsersWrite,
+	userpb.UserService_Update_FullMethodName: models.PermUsersWrite,
+	userpb.UserService_Delete_FullMethodName: models.PermUsersDelete,
+}
+
+// authenticate a call by the bearer token or API key in its authorization metadata, as
+// middleware.Auth does a request, and check the caller's role allows its method
+func (a *App) grpcAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var authorization string
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		token = ""
+	}
+	ctx, err := middleware.Authenticate(ctx, a.tokenSecret, tokenRevocations{a.sessions, a.impersonations}, a.apiKeys, authorization, token)
+	var refused *middleware.AuthError
+	if errors.As(err, &refused) {
+		if refused.Status == http.StatusForbidden {
+			return nil, status.Error(codes.PermissionDenied, refused.Message)
+		}
+		return nil, status.Error(codes.Unauthenticated, refused.Message)
+	} else if err != nil {
+		return nil, internalStatus(err)
+	}
+
+	caller, _ := middleware.UserID(ctx)
+	if a.requireConsent {
+		if _, _, impersonating := middleware.Impersonation(ctx); !impersonating {
+			pending, err := a.consents.Pending(ctx, caller, a.legalDocuments)
+			if err != nil {
+				return nil, internalStatus(err)
+			}
+			if len(pending) > 0 {
+				return nil, status.Error(codes.PermissionDenied, "accept the current terms to continue")
+			}
+		}
+	}
+	permission, ok := grpcPermissions[info.FullMethod]
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "your role does not allow this")
+	}
+	if update, isUpdate := req.(*userpb.UpdateUserRequest); !isUpdate || update.GetId() != int64(caller) {
+		if err := a.authorizeGRPC(ctx, permission); err != nil {
+			return nil, err
+		}
+	}
+	// soft-deleted users are only shown to callers who may delete and restore them
+	if deleted, ok := req.(interface{ GetIncludeDeleted() bool }); ok && deleted.GetIncludeDeleted() {
+		if err := a.authorizeGRPC(ctx, models.PermUsersDelete); err != nil {
+			return nil, err
+		}
+	}
+	return handler(a.withMasking(ctx), req)
+}
+
+// scope a call to the tenant its x-tenant metadata names, as the X-Tenant header does over REST
+func (a *App) grpcTenant(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if slugs := metadata.ValueFromIncomingContext(ctx, strings.ToLower(tenant.Header)); len(slugs) > 0 && slugs[0] != "" {
+		id, err := a.tenants.TenantID(ctx, slugs[0])
+		if err == store.ErrTenantNotFound {
+			return nil, status.Error(codes.NotFound, "tenant "+slugs[0]+" not found")
+		} else if err != nil {
+			return nil, internalStatus(err)
+		}
+		ctx = tenant.WithID(ctx, id)
+	}
+	return handler(ctx, req)
+}
+
+// fold validation problems into a single InvalidArgument status
+func invalidArgument(fields map[string]string) error {
+	msgs := make([]string, 0, len(fields))
+	for field, msg := range fields {
+		msgs = append(msgs, field+": "+msg)
+	}
+	return status.Error(codes.InvalidArgument, strings.Join(msgs, "; "))
+}
+
+// log the underlying error and return a generic Internal status, or Unavailable while the
+// database's circuit breaker is open
+func internalStatus(err error) error {
+	var open *store.CircuitOpenError
+	if errors.As(err, &open) {
+		slog.Warn("gRPC call failed", "err", err)
+		return status.Error(codes.Unavailable, "database unavailable, try again later")
+	}
+	slog.Error("gRPC call failed", "err", err)
+	return status.Error(codes.Internal, "internal server error")
+}
+
+var errEmailTakenStatus = status.Error(codes.AlreadyExists, "email already in use")
+
+var errUsernameTakenStatus = status.Error(codes.AlreadyExists, "username already in use")
+
+func notFoundStatus(id int64) error {
+	return status.Error(codes.NotFound, "user "+strconv.FormatInt(id, 10)+" not found")
+}
+
+// a proto3 string as an optional field, unset when empty
+// authorize as a gRPC status: PermissionDenied naming the permission the caller lacks
+func (a *App) authorizeGRPC(ctx context.Context, permission string) error {
+	err := a.authorize(ctx, permission)
+	if denied, ok := err.(models.APIError); ok {
+		return status.Error(codes.PermissionDenied, denied.Message+": "+permission+" is required")
+	} else if err != nil {
+		return internalStatus(err)
+	}
+	return nil
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func (s *userServer) Get(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	u, err := s.app.users.Get(store.WithReplicaReads(ctx), int(req.GetId()), req.GetIncludeDeleted())
+	if err == store.ErrUserNotFound {
+		return nil, notFoundStatus(req.GetId())
+	} else if err != nil {
+		return nil, internalStatus(err)
+	}
+	return userpb.FromModel(maskUser(ctx, u)), nil
+}
+
+func (s *userServer) List(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	fields := map[string]string{}
+	limit, offset := int(req.GetLimit()), int(req.GetOffset())
+	if limit == 0 {
+		limit = defaultPageLimit
+	}
+	if limit < 1 || limit > maxPageLimit {
+		fields["limit"] = "limit must be between 1 and " + strconv.Itoa(maxPageLimit)
+	}
+	if offset < 0 {
+		fields["offset"] = "offset must be a non-negative integer"
+	}
+	f := models.UserFilter{EmailContains: req.GetEmailContains(), IncludeDeleted: req.GetIncludeDeleted()}
+	if req.CreatedAfter != nil {
+		t := req.CreatedAfter.AsTime()
+		f.CreatedAfter = &t
+	}
+	if req.CreatedBefore != nil {
+		t := req.CreatedBefore.AsTime()
+		f.CreatedBefore = &t
+	}
+	sort := resolveSort(req.GetSort(), req.GetOrder(), fields)
+	if len(fields) > 0 {
+		return nil, invalidArgument(fields)
+	}
+
+	users, total, err := s.app.users.List(store.WithReplicaReads(ctx), f, sort, limit, offset)
+	if err != nil {
+		return nil, internalStatus(err)
+	}
+	resp := &userpb.ListUsersResponse{
+		Total: int32(total),
+		Page:  int32(offset/limit + 1),
+		Limit: int32(limit),
+		Items: make([]*userpb.User, len(users)),
+	}
+	for i, u := range users {
+		resp.Items[i] = userpb.FromModel(maskUser(ctx, u))
+	}
+	return resp, nil
+}
+
+func (s *userServer) Create(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.User, error) {
+	nu := newUserRequest{User: models.User{
+		Name: req.GetName(), Username: req.GetUsername(), Email: req.GetEmail(),
+		Phone: optional(req.GetPhone()), Bio: optional(req.GetBio()), Timezone: optional(req.GetTimezone()), Locale: optional(req.GetLocale()),
+	}, Password: req.GetPassword()}
+	u, fields := s.app.validateNewUser(nu)
+	if len(fields) > 0 {
+		return nil, invalidArgument(fields)
+	}
+
+	var err error
+	u.PasswordHash, err = optionalPasswordHash(nu.Password)
+	if err != nil {
+		return nil, internalStatus(err)
+	}
+	u, err = s.app.users.Create(ctx, u)
+	if err == store.ErrEmailTaken {
+		return nil, errEmailTakenStatus
+	} else if err == store.ErrUsernameTaken {
+		return nil, errUsernameTakenStatus
+	} else if err != nil {
+		return nil, internalStatus(err)
+	}
+	s.app.userCreated(ctx, u)
+	return userpb.FromModel(maskUser(ctx, u)), nil
+}
+
+func (s *userServer) Update(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.User, error) {
+	u := models.User{
+		Name: s.app.normalizeName(req.GetName()), Username: req.GetUsername(), Email: normalizeEmail(req.GetEmail()),
+		Phone: optional(req.GetPhone()), Bio: optional(req.GetBio()), Timezone: optional(req.GetTimezone()), Locale: optional(req.GetLocale()),
+	}
+	normalizeProfile(&u)
+	if fields := validateUser(u); len(fields) > 0 {
+		return nil, invalidArgument(fields)
+	}
+
+	u, err := s.app.users.Update(ctx, int(req.GetId()), u, int(req.GetVersion()))
+	if err == store.ErrUserNotFound {
+		return nil, notFoundStatus(req.GetId())
+	} else if err == store.ErrVersionConflict {
+		return nil, status.Error(codes.Aborted, "user has been modified since it was read")
+	} else if err == store.ErrEmailTaken {
+		return nil, errEmailTakenStatus
+	} else if err == store.ErrUsernameTaken {
+		return nil, errUsernameTakenStatus
+	} else if err != nil {
+		return nil, internalStatus(err)
+	}
+	s.app.feed.publish(ctx, models.EventUserUpdated, u)
+	return userpb.FromModel(maskUser(ctx, u)), nil
+}
+
+func (s *userServer) Delete(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	u, err := s.app.users.Delete(ctx, int(req.GetId()))
+	if err == store.ErrUserNotFound {
+		return nil, notFoundStatus(req.GetId())
+	} else if err != nil {
+		return nil, internalStatus(err)
+	}
+	s.app.feed.publish(ctx, models.EventUserDeleted, u)
+	return &userpb.DeleteUserResponse{}, nil
+}
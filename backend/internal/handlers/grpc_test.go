@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"api/userpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// a client of app's gRPC server, served in memory for the length of the test
+func grpcClient(t *testing.T, app *App) userpb.UserServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := app.GRPCServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return userpb.NewUserServiceClient(conn)
+}
+
+func bearer(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestGRPCRequiresCredentials(t *testing.T) {
+	app, _ := newTestApp(t, Options{MaskedFields: map[string][]string{"email": {"admin"}}})
+	h := app.Router()
+	admin := register(t, h, "Ada Lovelace", "ada@example.com")
+	user := register(t, h, "Grace Hopper", "grace@example.com")
+	client := grpcClient(t, app)
+
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want codes.Code
+	}{
+		{"without a token", context.Background(), codes.Unauthenticated},
+		{"with an invalid token", bearer("not-a-token"), codes.Unauthenticated},
+		{"with a user's token", bearer(user), codes.OK},
+	} {
+		if _, err := client.Get(tc.ctx, &userpb.GetUserRequest{Id: 1}); status.Code(err) != tc.want {
+			t.Errorf("Get %s: %v, want %s", tc.name, err, tc.want)
+		}
+	}
+
+	// plain users can't delete anyone, the admin can
+	if _, err := client.Delete(bearer(user), &userpb.DeleteUserRequest{Id: 1}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Delete as a user: %v, want %s", err, codes.PermissionDenied)
+	}
+	// nor edit anyone but themselves
+	if _, err := client.Update(bearer(user), &userpb.UpdateUserRequest{Id: 1, Name: "Ada King", Email: "ada@example.com"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Update of another as a user: %v, want %s", err, codes.PermissionDenied)
+	}
+	if _, err := client.Update(bearer(user), &userpb.UpdateUserRequest{Id: 2, Name: "Grace B. Hopper", Email: "grace@example.com"}); err != nil {
+		t.Errorf("Update of themselves as a user: %v", err)
+	}
+
+	// users see each other as the REST routes show them
+	u, err := client.Get(bearer(user), &userpb.GetUserRequest{Id: 1})
+	if err != nil || u.GetEmail() != "a***@example.com" {
+		t.Errorf("Get as a user = %v, %v; want the email masked", u, err)
+	}
+	if u, err := client.Get(bearer(admin), &userpb.GetUserRequest{Id: 2}); err != nil || u.GetEmail() != "grace@example.com" {
+		t.Errorf("Get as the admin = %v, %v; want the email in full", u, err)
+	}
+	if _, err := client.Delete(bearer(admin), &userpb.DeleteUserRequest{Id: 2}); err != nil {
+		t.Errorf("Delete as the admin: %v", err)
+	}
+}
+
+func TestGRPCSoftDeletedUsersNeedDeletePermission(t *testing.T) {
+	app, _ := newTestApp(t, Options{})
+	h := app.Router()
+	admin := register(t, h, "Ada Lovelace", "ada@example.com")
+	user := register(t, h, "Grace Hopper", "grace@example.com")
+	client := grpcClient(t, app)
+
+	if _, err := client.Get(bearer(user), &userpb.GetUserRequest{Id: 1, IncludeDeleted: true}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Get with include_deleted as a user: %v, want %s", err, codes.PermissionDenied)
+	}
+	if _, err := client.List(bearer(user), &userpb.ListUsersRequest{IncludeDeleted: true}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("List with include_deleted as a user: %v, want %s", err, codes.PermissionDenied)
+	}
+	if _, err := client.Get(bearer(admin), &userpb.GetUserRequest{Id: 2, IncludeDeleted: true}); err != nil {
+		t.Errorf("Get with include_deleted as the admin: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// answer a HEAD request with the headers the route's GET would send, Content-Length and ETag
+// included, and no body
+func serveHead(router *mux.Router, w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	get := r.Clone(ctx)
+	get.Method = http.MethodGet
+
+	hw := &headWriter{ResponseWriter: w, cancel: cancel}
+	router.ServeHTTP(hw, get)
+	hw.send()
+}
+
+// discards the body of a GET served for a HEAD request, counting it so the headers can carry its
+// Content-Length. a flush means the response is a stream of unknown length, which is sent as is
+// and then ended, since a HEAD never reads past the headers
+type headWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+	sent   bool
+	cancel context.CancelFunc
+}
+
+func (hw *headWriter) WriteHeader(status int) {
+	if hw.status == 0 {
+		hw.status = status
+	}
+}
+
+func (hw *headWriter) Write(b []byte) (int, error) {
+	hw.WriteHeader(http.StatusOK)
+	hw.size += len(b)
+	return len(b), nil
+}
+
+func (hw *headWriter) Flush() {
+	hw.WriteHeader(http.StatusOK)
+	hw.sendHeaders()
+	hw.cancel()
+}
+
+func (hw *headWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// the headers, with the length of the discarded body unless the handler gave its own
+func (hw *headWriter) send() {
+	if hw.sent {
+		return
+	}
+	hw.WriteHeader(http.StatusOK)
+	h := hw.Header()
+	if h.Get("Content-Length") == "" && hw.status != http.StatusNoContent && hw.status != http.StatusNotModified {
+		h.Set("Content-Length", strconv.Itoa(hw.size))
+	}
+	hw.sendHeaders()
+}
+
+func (hw *headWriter) sendHeaders() {
+	if !hw.sent {
+		hw.sent = true
+		hw.ResponseWriter.WriteHeader(hw.status)
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// result of checking a single dependency
+type checkResult struct {
+	OK        bool   `json:"ok"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type statusResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]interface{} `json:"checks"`
+}
+
+// how long each readiness check may take before the dependency counts as down
+const readinessTimeout = 2 * time.Second
+
+// reported for a failing check; the readiness report is served without a token, so the failure
+// itself, which can name hosts, database users and the like, only goes to the log
+const checkFailed = "unavailable"
+
+// run a single dependency check under readinessTimeout and time it
+func runCheck(ctx context.Context, name string, check func(context.Context) error) checkResult {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	res := checkResult{OK: err == nil, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		slog.ErrorContext(ctx, "readiness check failed", "check", name, "err", err)
+		res.Error = checkFailed
+	}
+	return res
+}
+
+// liveness: the process is up and serving, whatever the state of its dependencies
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeBody(w, map[string]string{"status": "ok"})
+}
+
+// run every readiness check, the status being "ok" only when all of them pass
+func (a *App) runChecks(ctx context.Context) statusResponse {
+	checks := map[string]interface{}{"version": a.build.Version}
+	healthy := true
+	for name, check := range a.checks {
+		res := runCheck(ctx, name, check)
+		checks[name] = res
+		healthy = healthy && res.OK
+	}
+
+	resp := statusResponse{Status: "ok", Checks: checks}
+	if !healthy {
+		resp.Status = "degraded"
+	}
+	return resp
+}
+
+// readiness: report the state of each dependency, "ok" only when every check passes
+func (a *App) statusCheck(w http.ResponseWriter, r *http.Request) {
+	resp := a.runChecks(r.Context())
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	writeBody(w, resp)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadinessChecks(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		ping       error
+		wantCode   int
+		wantStatus string
+	}{
+		{"healthy", nil, http.StatusOK, "ok"},
+		{"database down", errors.New("dial tcp db.internal:5432: password authentication failed for user \"app\""), http.StatusServiceUnavailable, "degraded"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app, _ := newTestApp(t, Options{
+				Build:  Build{Version: "1.2.3"},
+				Checks: map[string]func(context.Context) error{"database": func(context.Context) error { return tc.ping }},
+			})
+			h := app.Router()
+
+			for _, path := range []string{"/readyz", "/api/v1/status"} {
+				w := do(t, h, "GET", path, "", nil)
+				if w.Code != tc.wantCode {
+					t.Fatalf("%s: status = %d, want %d; body %s", path, w.Code, tc.wantCode, w.Body)
+				}
+				var resp struct {
+					Status string                     `json:"status"`
+					Checks map[string]json.RawMessage `json:"checks"`
+				}
+				decode(t, w, &resp)
+				if resp.Status != tc.wantStatus {
+					t.Errorf("%s: status = %q, want %q", path, resp.Status, tc.wantStatus)
+				}
+				if string(resp.Checks["version"]) != `"1.2.3"` {
+					t.Errorf("%s: version = %s, want \"1.2.3\"", path, resp.Checks["version"])
+				}
+
+				var db struct {
+					OK        bool   `json:"ok"`
+					LatencyMs *int64 `json:"latency_ms"`
+					Error     string `json:"error"`
+				}
+				if err := json.Unmarshal(resp.Checks["database"], &db); err != nil {
+					t.Fatalf("%s: database check %s: %v", path, resp.Checks["database"], err)
+				}
+				wantErr := ""
+				if tc.ping != nil {
+					wantErr = checkFailed
+					// the readiness report is public, so what the driver said stays in the log
+					for _, secret := range []string{tc.ping.Error(), "db.internal", `\"app\"`} {
+						if strings.Contains(w.Body.String(), secret) {
+							t.Errorf("%s: body %s reveals %s", path, w.Body, secret)
+						}
+					}
+				}
+				if db.OK != (tc.ping == nil) || db.LatencyMs == nil || db.Error != wantErr {
+					t.Errorf("%s: database check = {ok %v, latency %v, error %q}, want {ok %v, a latency, error %q}",
+						path, db.OK, db.LatencyMs, db.Error, tc.ping == nil, wantErr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+	"api/internal/tenant"
+)
+
+// how long an impersonation lasts unless it is ended first; it can't be refreshed
+const impersonationTTL = 15 * time.Minute
+
+type impersonateRequest struct {
+	Reason string `json:"reason"`
+}
+
+// the token to act as the user with, marked as an impersonation so clients can show it
+type impersonationResponse struct {
+	Token         string               `json:"token"`
+	ExpiresIn     int                  `json:"expires_in"` // seconds until token expires
+	Impersonation models.Impersonation `json:"impersonation"`
+	User          interface{}          `json:"user"`
+}
+
+// refuse h to impersonation tokens, for what only the user themselves may do, such as changing
+// their password or their second factor
+func notImpersonating(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := middleware.Impersonation(r.Context()); ok {
+			models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "not allowed while impersonating"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// start acting as the user named by {id}, for support. admins can't be impersonated, so an
+// impersonation never grants more than its admin already has
+func (a *App) startImpersonation(w http.ResponseWriter, r *http.Request) {
+	var req impersonateRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || utf8.RuneCountInString(req.Reason) > 500 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "request is invalid", Fields: map[string]string{"reason": "reason is required, at most 500 characters"}})
+		return
+	}
+
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	caller, _ := middleware.UserID(r.Context())
+	if id == caller {
+		models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "you can't impersonate yourself"})
+		return
+	}
+	u, err := a.users.Get(r.Context(), id, false)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if u.Role == models.RoleAdmin {
+		models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "admins can't be impersonated"})
+		return
+	}
+
+	i, err := a.impersonations.StartImpersonation(r.Context(), caller, id, req.Reason, time.Now().Add(impersonationTTL))
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	token, err := middleware.IssueImpersonationToken(a.tokenSecret, i, tenant.ID(r.Context()))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, impersonationResponse{Token: token, ExpiresIn: int(time.Until(i.ExpiresAt).Seconds()), Impersonation: i, User: versionedUser(r, u)})
+}
+
+// end the impersonation the request's token was issued for, which then stops working
+func (a *App) stopImpersonation(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := middleware.Impersonation(r.Context())
+	if !ok {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "this token isn't an impersonation token"})
+		return
+	}
+	if _, err := a.impersonations.EndImpersonation(r.Context(), id); err != nil && err != store.ErrImpersonationNotFound {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"api/internal/mail"
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// how long an invitation link stays valid
+const invitationTTL = 7 * 24 * time.Hour
+
+type invitationRequest struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+	Role  string `json:"role"`
+}
+
+type invitationToken struct {
+	Token string `json:"token"`
+}
+
+func writeInvalidInvitation(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invitation is invalid or expired", Fields: map[string]string{"token": "token is invalid, used or expired"}})
+}
+
+// decode a body carrying an invitation token, having written an error when there is none
+func (a *App) decodeInvitationToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req invitationToken
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return "", false
+	}
+	if req.Token == "" {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "request is invalid", Fields: map[string]string{"token": "token is required"}})
+		return "", false
+	}
+	return req.Token, true
+}
+
+// email a single-use link inviting an address to the organization. the address needn't have an
+// account yet; it signs up with that address before accepting. admins invite members and admins,
+// only owners invite owners, and a new invitation to an address replaces one still pending
+func (a *App) createInvitation(w http.ResponseWriter, r *http.Request) {
+	var req invitationRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	req.Email = normalizeEmail(req.Email)
+	if req.Role == "" {
+		req.Role = models.OrgRoleMember
+	}
+	fields := validationErrors(req)
+	for field, msg := range validOrgRole(req.Role) {
+		fields[field] = msg
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "invitation is invalid", Fields: fields})
+		return
+	}
+	org, role, ok := a.orgAccess(w, r, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	if req.Role == models.OrgRoleOwner && role != models.OrgRoleOwner {
+		writeOrgRoleTooLow(w, models.OrgRoleOwner)
+		return
+	}
+
+	var name string
+	if u, err := a.users.GetByEmail(r.Context(), req.Email); err == nil {
+		if current, err := a.orgs.Role(r.Context(), org.Id, u.Id); err != nil {
+			writeInternalError(w, r, err)
+			return
+		} else if current != "" {
+			models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "user is already a member", Fields: map[string]string{"email": "user is already a member"}})
+			return
+		}
+		name = u.Name
+	} else if err != store.ErrUserNotFound {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	inv := models.Invitation{OrgId: org.Id, Email: req.Email, Role: req.Role, ExpiresAt: time.Now().Add(invitationTTL)}
+	if caller, ok := middleware.UserID(r.Context()); ok {
+		inv.InvitedBy = &caller
+	}
+	inv, err := a.orgs.Invite(r.Context(), inv, hashToken(token))
+	if err == store.ErrOrgNotFound {
+		writeOrgNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	link := a.invitationURL + "?token=" + url.QueryEscape(token)
+	m, err := mail.Render(mail.TemplateInvitation, inv.Email, mail.Data{Name: name, Link: link, Organization: org.Name})
+	if err == nil {
+		err = a.mailer.Send(r.Context(), m)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "send invitation email failed", "err", err, "invitation_id", inv.Id)
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, inv)
+}
+
+// join an organization with a token from an invitation email, which has to have been sent to the
+// caller's address
+func (a *App) acceptInvitation(w http.ResponseWriter, r *http.Request) {
+	token, ok := a.decodeInvitationToken(w, r)
+	if !ok {
+		return
+	}
+	inv, err := a.orgs.Invitation(r.Context(), hashToken(token))
+	if err == store.ErrInvalidInvitation {
+		writeInvalidInvitation(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	caller, _ := middleware.UserID(r.Context())
+	u, err := a.users.Get(r.Context(), caller, false)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if normalizeEmail(u.Email) != normalizeEmail(inv.Email) {
+		models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "this invitation is for another email address"})
+		return
+	}
+
+	org, err := a.orgs.AcceptInvitation(r.Context(), hashToken(token), u.Id)
+	switch err {
+	case nil:
+	case store.ErrInvalidInvitation:
+		writeInvalidInvitation(w)
+		return
+	case store.ErrUserNotFound:
+		writeNotFound(w)
+		return
+	default:
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, org)
+}
+
+// turn an invitation down. the token is enough, so addresses without an account can decline too
+func (a *App) declineInvitation(w http.ResponseWriter, r *http.Request) {
+	token, ok := a.decodeInvitationToken(w, r)
+	if !ok {
+		return
+	}
+	if err := a.orgs.DeclineInvitation(r.Context(), hashToken(token)); err == store.ErrInvalidInvitation {
+		writeInvalidInvitation(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"api/internal/models"
+	"api/internal/store"
+	"api/internal/tenant"
+
+	"github.com/gorilla/mux"
+)
+
+type jobPage struct {
+	Total int          `json:"total"`
+	Page  int          `json:"page"`
+	Limit int          `json:"limit"`
+	Items []models.Job `json:"items"`
+}
+
+// jobs are run for the whole install rather than a tenant, so only the default tenant, whose
+// admins run the install, gets to see them
+func instanceWide(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tenant.ID(r.Context()) != tenant.DefaultID {
+			models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "only the default tenant can see this"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// background jobs, newest first, optionally only those with a given status or kind
+func (a *App) listJobs(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	limit, offset := parsePagination(r, fields)
+	f := models.JobFilter{Status: r.URL.Query().Get("status"), Kind: r.URL.Query().Get("kind")}
+	if f.Status != "" && !slices.Contains(models.JobStatuses, f.Status) {
+		fields["status"] = "status must be one of " + strings.Join(models.JobStatuses, ", ")
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: fields})
+		return
+	}
+
+	jobs, total, err := a.jobs.List(r.Context(), f, limit, offset)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, jobPage{Total: total, Page: offset/limit + 1, Limit: limit, Items: jobs})
+}
+
+func (a *App) getJob(w http.ResponseWriter, r *http.Request) {
+	writeMissing := func() {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "job not found"})
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeMissing()
+		return
+	}
+	job, err := a.jobs.Get(r.Context(), id)
+	if err == store.ErrJobNotFound {
+		writeMissing()
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, job)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"api/internal/codec"
+	"api/internal/models"
+)
+
+// JSON:API (https://jsonapi.org) renderings of users, for clients that send
+// Accept: application/vnd.api+json. other payloads stay plain JSON, see responseFormat
+
+// a JSON:API top-level document
+type jsonAPIDocument struct {
+	Data  interface{}            `json:"data"` // a jsonAPIResource, or a slice of them for collections
+	Meta  map[string]interface{} `json:"meta,omitempty"`
+	Links map[string]string      `json:"links,omitempty"`
+}
+
+type jsonAPIResource struct {
+	Type          string                         `json:"type"`
+	Id            string                         `json:"id"`
+	Attributes    map[string]interface{}         `json:"attributes"`
+	Relationships map[string]jsonAPIRelationship `json:"relationships,omitempty"`
+	Links         map[string]string              `json:"links"`
+	Meta          map[string]interface{}         `json:"meta,omitempty"`
+}
+
+// a relationship given by a link to where its members can be read
+type jsonAPIRelationship struct {
+	Links map[string]string `json:"links"`
+}
+
+// whether r asked for JSON:API documents
+func wantsJSONAPI(r *http.Request) bool {
+	return codec.Negotiate(r.Header.Get("Accept")) == codec.JSONAPI
+}
+
+// u as a users resource, its attributes being the user as the API version presents it
+func userResource(r *http.Request, u models.User) jsonAPIResource {
+	var attributes map[string]interface{}
+	b, _ := json.Marshal(versionedUser(r, u))
+	json.Unmarshal(b, &attributes)
+	delete(attributes, "id")
+
+	self := routedAPI(r).prefix + "/users/" + strconv.Itoa(u.Id)
+	return jsonAPIResource{
+		Type:       "users",
+		Id:         strconv.Itoa(u.Id),
+		Attributes: attributes,
+		Relationships: map[string]jsonAPIRelationship{
+			"revisions": {Links: map[string]string{"related": self + "/revisions"}},
+			"sessions":  {Links: map[string]string{"related": self + "/sessions"}},
+			"api_keys":  {Links: map[string]string{"related": self + "/api-keys"}},
+		},
+		Links: map[string]string{"self": self},
+	}
+}
+
+// the users of a listing as resources, their attributes trimmed to the ?fields= it asked for
+func userResources(r *http.Request, users []models.User) []jsonAPIResource {
+	fs := requestedFieldset(r)
+	resources := make([]jsonAPIResource, len(users))
+	for i, u := range users {
+		resources[i] = userResource(r, u)
+		if fs.keep != nil {
+			resources[i].Attributes = fs.apply(resources[i].Attributes).(map[string]interface{})
+		}
+	}
+	return resources
+}
+
+// the URL of r with the given query parameters set, in name, value pairs
+func linkWithQuery(r *http.Request, params ...string) string {
+	q := r.URL.Query()
+	for i := 0; i+1 < len(params); i += 2 {
+		q.Set(params[i], params[i+1])
+	}
+	if len(q) == 0 {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + q.Encode()
+}
+
+// an offset page of users, as a page or, for JSON:API, a document linking to its neighbours
+func presentPage(r *http.Request, users []models.User, total, limit, offset int) interface{} {
+	p := page{Total: total, Page: offset/limit + 1, Limit: limit}
+	if !wantsJSONAPI(r) {
+		p.Items = presentUsers(r, users)
+		p.Links = pageLinks(r, total, limit, offset)
+		return p
+	}
+	at := func(offset int) string {
+		return linkWithQuery(r, "offset", strconv.Itoa(offset), "limit", strconv.Itoa(limit))
+	}
+	lastOffset := 0
+	if total > 0 {
+		lastOffset = (total - 1) / limit * limit
+	}
+	links := map[string]string{"self": at(offset), "first": at(0), "last": at(lastOffset)}
+	if offset > 0 {
+		links["prev"] = at(max(offset-limit, 0))
+	}
+	if offset+limit < total {
+		links["next"] = at(offset + limit)
+	}
+	return jsonAPIDocument{
+		Data:  userResources(r, users),
+		Meta:  map[string]interface{}{"total": p.Total, "page": p.Page, "limit": p.Limit},
+		Links: links,
+	}
+}
+
+// a keyset page of users, linking to the next page by its cursor for JSON:API
+func presentCursorPage(r *http.Request, users []models.User, nextCursor string) interface{} {
+	if !wantsJSONAPI(r) {
+		return cursorPage{Items: presentUsers(r, users), NextCursor: nextCursor, Links: cursorLinks(r, nextCursor)}
+	}
+	// an empty cursor is the first page
+	links := map[string]string{"self": linkWithQuery(r), "first": linkWithQuery(r, "cursor", "")}
+	if nextCursor != "" {
+		links["next"] = linkWithQuery(r, "cursor", nextCursor)
+	}
+	return jsonAPIDocument{Data: userResources(r, users), Links: links}
+}
+
+// search results, or for JSON:API a document of users each ranked in its meta
+func presentSearch(r *http.Request, term, mode string, results []models.SearchResult) interface{} {
+	if !wantsJSONAPI(r) {
+		return searchResponse{Query: term, Mode: mode, Items: presentSearchResults(r, results)}
+	}
+	resources := make([]jsonAPIResource, len(results))
+	for i, res := range results {
+		resources[i] = userResource(r, res.User)
+		resources[i].Meta = map[string]interface{}{"rank": res.Rank}
+	}
+	return jsonAPIDocument{
+		Data:  resources,
+		Meta:  map[string]interface{}{"query": term, "mode": mode},
+		Links: map[string]string{"self": linkWithQuery(r)},
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"api/internal/models"
+)
+
+// one operation of an RFC 6902 JSON patch
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"` // nil when absent, as opposed to a JSON null
+}
+
+// the user member a JSON pointer names. users are flat, so only single-token pointers resolve
+func pointerMember(path string) (string, bool) {
+	if !strings.HasPrefix(path, "/") || strings.Count(path, "/") != 1 {
+		return "", false
+	}
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(path[1:]), true
+}
+
+// apply ops in order to the patchable members of current. version is in the document too,
+// so a test on /version can guard the patch, but it can't be changed. the first failing
+// operation stops the patch, leaving the user untouched
+func jsonPatch(current models.User, ops []patchOperation) (map[string]interface{}, error) {
+	doc := patchableUser(current)
+	doc["version"] = float64(current.Version)
+
+	for i, op := range ops {
+		at := "/" + strconv.Itoa(i)
+		member, ok := pointerMember(op.Path)
+		if !ok {
+			return nil, &patchError{path: at, msg: "path " + strconv.Quote(op.Path) + " does not name a user field"}
+		}
+		_, exists := doc[member]
+
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, &patchError{path: at, msg: op.Op + " needs a value"}
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, &patchError{path: at, msg: "value is not valid JSON"}
+			}
+		case "remove":
+		default:
+			return nil, &patchError{path: at, msg: "op must be one of add, replace, remove, test"}
+		}
+
+		if op.Op != "add" && !exists {
+			return nil, &patchError{path: at, msg: op.Path + " does not exist"}
+		}
+		switch op.Op {
+		case "add", "replace":
+			doc[member] = value
+		case "remove":
+			delete(doc, member)
+		case "test":
+			if !reflect.DeepEqual(doc[member], value) {
+				return nil, &patchError{conflict: true, path: at, msg: op.Path + " does not have the tested value"}
+			}
+		}
+	}
+
+	if v, ok := doc["version"]; !ok || v != float64(current.Version) {
+		return nil, &patchError{path: "/version", msg: "version cannot be changed"}
+	}
+	delete(doc, "version")
+	return doc, nil
+}
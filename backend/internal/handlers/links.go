@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"api/internal/models"
+)
+
+// a hypermedia link in a _links object, keyed by its relation. method is given where following
+// the link means something other than GET
+type link struct {
+	Href   string `json:"href"`
+	Method string `json:"method,omitempty"`
+}
+
+// what can be done with u from here, under the prefix r was routed through
+func userLinks(r *http.Request, u models.User) map[string]link {
+	api := routedAPI(r)
+	self := api.prefix + "/users/" + strconv.Itoa(u.Id)
+	links := map[string]link{
+		"self":       {Href: self},
+		"update":     {Href: self, Method: http.MethodPatch},
+		"delete":     {Href: self, Method: http.MethodDelete},
+		"collection": {Href: api.prefix + "/users"},
+	}
+	if api.avatars {
+		links["avatar"] = link{Href: self + "/avatar", Method: http.MethodPost}
+	}
+	return links
+}
+
+type linkedUser struct {
+	models.User
+	Links map[string]link `json:"_links"`
+}
+
+type linkedUserV2 struct {
+	models.UserV2
+	Links map[string]link `json:"_links"`
+}
+
+// u in the shape of the API version r was routed through, with its _links
+func linkedVersionedUser(r *http.Request, u models.User) interface{} {
+	u = maskUser(r.Context(), withDefaultAvatar(r, u))
+	if apiVersion(r) == apiV2 {
+		return linkedUserV2{UserV2: u.V2(), Links: userLinks(r, u)}
+	}
+	return linkedUser{User: u, Links: userLinks(r, u)}
+}
+
+// links to the neighbours of an offset page of a collection, by the URL of r
+func pageLinks(r *http.Request, total, limit, offset int) map[string]link {
+	at := func(offset int) link {
+		return link{Href: linkWithQuery(r, "offset", strconv.Itoa(offset), "limit", strconv.Itoa(limit))}
+	}
+	links := map[string]link{"self": at(offset)}
+	if offset > 0 {
+		links["prev"] = at(max(offset-limit, 0))
+	}
+	if offset+limit < total {
+		links["next"] = at(offset + limit)
+	}
+	return links
+}
+
+// links to the next keyset page of a collection, by the URL of r
+func cursorLinks(r *http.Request, nextCursor string) map[string]link {
+	links := map[string]link{"self": {Href: linkWithQuery(r)}}
+	if nextCursor != "" {
+		links["next"] = link{Href: linkWithQuery(r, "cursor", nextCursor)}
+	}
+	return links
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// refuse a login attempt on an account locked out after too many failures, with 423 and
+// Retry-After. the password isn't checked, so guessing gets nowhere until the lockout ends
+func (a *App) lockedOut(w http.ResponseWriter, r *http.Request, attempt models.LoginEvent) bool {
+	if a.lockoutThreshold == 0 {
+		return false
+	}
+	until, err := a.logins.LockedUntil(r.Context(), *attempt.UserId)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return true
+	}
+	if until == nil {
+		return false
+	}
+	a.recordLogin(r, attempt, models.LoginFailedLocked)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(*until).Seconds()))))
+	models.WriteError(w, http.StatusLocked, models.APIError{Code: models.ErrCodeAccountLocked, Message: "account is locked after too many failed logins; try again later"})
+	return true
+}
+
+// count a wrong password or code against the account, which locks it once there are too many in a row
+func (a *App) countFailedLogin(r *http.Request, id int) {
+	if a.lockoutThreshold == 0 {
+		return
+	}
+	until, err := a.logins.RecordFailedLogin(r.Context(), id, a.lockoutThreshold, a.lockoutDuration)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "count failed login failed", "err", err, "user_id", id)
+	} else if until != nil {
+		slog.WarnContext(r.Context(), "account locked out", "user_id", id, "locked_until", *until)
+	}
+}
+
+// lift a user's lockout before it ends
+func (a *App) unlockUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	if err := a.logins.Unlock(r.Context(), id); err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
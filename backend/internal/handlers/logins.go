@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"api/internal/models"
+	"api/internal/store"
+)
+
+type loginPage struct {
+	LastLoginAt *time.Time          `json:"last_login_at"` // null until the user first logs in
+	Total       int                 `json:"total"`
+	Page        int                 `json:"page"`
+	Limit       int                 `json:"limit"`
+	Items       []models.LoginEvent `json:"items"`
+}
+
+// a user's login attempts, newest first, for spotting access they didn't make
+func (a *App) listLogins(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	fields := map[string]string{}
+	limit, offset := parsePagination(r, fields)
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: fields})
+		return
+	}
+
+	last, err := a.logins.LastLoginAt(r.Context(), id)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	events, total, err := a.logins.Logins(r.Context(), id, limit, offset)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for i := range events {
+		events[i].Device = describeDevice(events[i].UserAgent)
+	}
+	writeBody(w, loginPage{LastLoginAt: last, Total: total, Page: offset/limit + 1, Limit: limit, Items: events})
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"api/internal/middleware"
+	"api/internal/models"
+)
+
+// MaskableFields are the user fields Options.MaskedFields can hide in part.
+var MaskableFields = []string{"email", "phone", "created_at", "updated_at", "email_verified_at", "deleted_at"}
+
+// the masking of one request, resolving the caller's role the first time a user is presented
+type fieldMasks struct {
+	fields map[string][]string // masked field -> roles seeing it in full
+	lookup func(ctx context.Context, userID int) (models.User, error)
+
+	once sync.Once
+	role string // the caller's role; empty when it couldn't be read, which masks every field
+}
+
+type fieldMasksKey struct{}
+
+// ctx set up to mask the users presented in it, when any field is masked
+func (a *App) withMasking(ctx context.Context) context.Context {
+	if len(a.maskedFields) == 0 {
+		return ctx
+	}
+	m := &fieldMasks{fields: a.maskedFields, lookup: func(ctx context.Context, id int) (models.User, error) { return a.users.Get(ctx, id, false) }}
+	return context.WithValue(ctx, fieldMasksKey{}, m)
+}
+
+// set up masking for every request, when any field is masked
+func (a *App) withFieldMasks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(a.withMasking(r.Context())))
+	})
+}
+
+func (m *fieldMasks) callerRole(ctx context.Context, caller int) string {
+	m.once.Do(func() {
+		u, err := m.lookup(ctx, caller)
+		if err != nil {
+			slog.ErrorContext(ctx, "look up caller's role for masking failed", "err", err)
+			return
+		}
+		m.role = u.Role
+	})
+	return m.role
+}
+
+// u with the fields the caller's role may not see masked. callers always see their own account in
+// full, as do requests without a token, which only ever present the account they act on
+func maskUser(ctx context.Context, u models.User) models.User {
+	m, ok := ctx.Value(fieldMasksKey{}).(*fieldMasks)
+	if !ok {
+		return u
+	}
+	caller, authenticated := middleware.UserID(ctx)
+	if !authenticated || caller == u.Id {
+		return u
+	}
+	return maskFields(u, m.fields, m.callerRole(ctx, caller))
+}
+
+// u with the fields role may not see masked, as fields lists for each the roles seeing it in full.
+// an empty role sees none of them in full
+func maskFields(u models.User, fields map[string][]string, role string) models.User {
+	for field, roles := range fields {
+		if role != "" && slices.Contains(roles, role) {
+			continue
+		}
+		switch field {
+		case "email":
+			u.Email = maskEmail(u.Email)
+		case "phone":
+			if u.Phone != nil {
+				phone := maskPhone(*u.Phone)
+				u.Phone = &phone
+			}
+		case "created_at":
+			u.CreatedAt = u.CreatedAt.UTC().Truncate(24 * time.Hour)
+		case "updated_at":
+			u.UpdatedAt = u.UpdatedAt.UTC().Truncate(24 * time.Hour)
+		case "email_verified_at":
+			u.EmailVerifiedAt = maskTime(u.EmailVerifiedAt)
+		case "deleted_at":
+			u.DeletedAt = maskTime(u.DeletedAt)
+		}
+	}
+	return u
+}
+
+func maskUsers(ctx context.Context, users []models.User) []models.User {
+	if _, ok := ctx.Value(fieldMasksKey{}).(*fieldMasks); !ok {
+		return users
+	}
+	masked := make([]models.User, len(users))
+	for i, u := range users {
+		masked[i] = maskUser(ctx, u)
+	}
+	return masked
+}
+
+// m with its email masked as that of the member's user would be
+func maskMember(ctx context.Context, m models.Member) models.Member {
+	m.Email = maskUser(ctx, models.User{Id: m.UserId, Email: m.Email}).Email
+	return m
+}
+
+// the first character of the local part and the whole domain, such as j***@example.com
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+	_, size := utf8.DecodeRuneInString(local)
+	return local[:size] + "***@" + domain
+}
+
+// the last two digits, the rest starred, such as +*********23
+func maskPhone(phone string) string {
+	if len(phone) <= 3 {
+		return strings.Repeat("*", len(phone))
+	}
+	return "+" + strings.Repeat("*", len(phone)-3) + phone[len(phone)-2:]
+}
+
+// timestamps are kept to the day they fell on, in UTC
+func maskTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	day := t.UTC().Truncate(24 * time.Hour)
+	return &day
+}
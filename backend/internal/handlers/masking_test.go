@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api/internal/models"
+)
+
+func TestMaskedFieldsLeaveNoWayAround(t *testing.T) {
+	// no role sees emails in full, so even the admin sees others' masked
+	app, _ := newTestApp(t, Options{MaskedFields: map[string][]string{"email": nil}})
+	srv := httptest.NewServer(app.Router())
+	t.Cleanup(srv.Close)
+	h := srv.Config.Handler
+	token := register(t, h, "Ada Lovelace", "ada@example.com")
+
+	events := openStream(t, srv, "/api/v1/users/events", token)
+	w := do(t, h, "POST", "/api/v1/users", token, map[string]string{"name": "Grace Hopper", "email": "grace@example.com"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: status %d, body %s", w.Code, w.Body)
+	}
+	var created models.User
+	decode(t, w, &created)
+	const masked = "g***@example.com"
+
+	// the admin's own registration comes first, in full
+	for _, want := range []string{"ada@example.com", masked} {
+		var e models.UserEvent
+		if frame := readFrame(t, events); json.Unmarshal([]byte(frame["data"]), &e) != nil || e.User.Email != want {
+			t.Errorf("event data %q, want the user's email as %s", frame["data"], want)
+		}
+	}
+
+	w = do(t, h, "GET", "/api/v1/users/export", token, nil)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "grace@example.com") || !strings.Contains(w.Body.String(), masked) {
+		t.Errorf("export: status %d, body %s; want %s masked", w.Code, w.Body, "grace@example.com")
+	}
+
+	w = do(t, h, "GET", "/api/v1/users/2/revisions", token, nil)
+	var revisions []models.UserRevision
+	decode(t, w, &revisions)
+	if len(revisions) == 0 || revisions[0].User.Email != masked {
+		t.Errorf("revisions = %+v, want the email masked", revisions)
+	}
+	w = do(t, h, "GET", "/api/v1/users/2/revisions/1/diff", token, nil)
+	if strings.Contains(w.Body.String(), "grace@example.com") {
+		t.Errorf("diff = %s, want the email masked", w.Body)
+	}
+}
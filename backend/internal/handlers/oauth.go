@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/models"
+	"api/internal/oauth"
+	"api/internal/store"
+	"api/internal/tenant"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// how long a user has to sign in at the provider and come back to the callback
+const oauthStateTTL = 10 * time.Minute
+
+// audience of the state token kept in oauthStateCookie between login and callback
+const oauthStateAudience = "oauth_state"
+
+// the cookie that ties a callback to the browser that started the login
+const oauthStateCookie = "oauth_state"
+
+// what the login remembers for its callback: the provider, the state it sent along, the PKCE
+// verifier of the challenge it sent and the tenant to sign in to, since the provider's redirect
+// back carries no X-Tenant header
+type oauthStateClaims struct {
+	jwt.RegisteredClaims
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	TenantID int    `json:"tid"`
+}
+
+// the provider named by the {provider} route variable, having written a 404 when it isn't configured
+func (a *App) routeProvider(w http.ResponseWriter, r *http.Request) (*oauth.Provider, bool) {
+	p, ok := a.oauthProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "sign-in provider not found"})
+	}
+	return p, ok
+}
+
+func (a *App) setOAuthStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    value,
+		Path:     "/api",
+		MaxAge:   maxAge,
+		Secure:   strings.HasPrefix(a.publicURL, "https://"),
+		HttpOnly: true,
+		// Lax, not Strict, so the cookie comes along on the provider's redirect back to us
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// send the browser to sign in at the provider, which returns it to oauthCallback
+func (a *App) oauthLogin(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.routeProvider(w, r)
+	if !ok {
+		return
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	state, verifier := base64.RawURLEncoding.EncodeToString(b), oauth.NewVerifier()
+	now := time.Now()
+	token, err := a.signToken(oauthStateClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{oauthStateAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(oauthStateTTL)),
+		},
+		Provider: p.Name,
+		State:    state,
+		Verifier: verifier,
+		TenantID: tenant.ID(r.Context()),
+	})
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	a.setOAuthStateCookie(w, token, int(oauthStateTTL.Seconds()))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, p.AuthCodeURL(state, verifier), http.StatusFound)
+}
+
+// finish a sign-in at the provider: find the user its account is linked to, or link it to the user
+// with the same verified email, or create one, and start a session as with a password login
+func (a *App) oauthCallback(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.routeProvider(w, r)
+	if !ok {
+		return
+	}
+	failed := models.APIError{Code: models.ErrCodeUnauthorized, Message: "sign-in with " + p.Name + " failed; try again"}
+	q := r.URL.Query()
+	if q.Get("error") != "" {
+		// the user declined, or the provider refused the request
+		a.oauthFail(w, r, http.StatusUnauthorized, failed)
+		return
+	}
+	var claims oauthStateClaims
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err == nil {
+		err = a.parseToken(cookie.Value, oauthStateAudience, &claims)
+	}
+	// a callback the browser didn't start here could be an attacker signing the victim in as themselves
+	if err != nil || claims.Provider != p.Name || subtle.ConstantTimeCompare([]byte(claims.State), []byte(q.Get("state"))) != 1 {
+		a.oauthFail(w, r, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "sign-in request is invalid or expired; start again"})
+		return
+	}
+	a.setOAuthStateCookie(w, "", -1)
+	r = r.WithContext(tenant.WithID(r.Context(), cmp.Or(claims.TenantID, tenant.DefaultID)))
+
+	identity, err := p.Identity(r.Context(), q.Get("code"), claims.Verifier)
+	if err != nil {
+		slog.WarnContext(r.Context(), "oauth sign-in failed", "err", err, "provider", p.Name)
+		a.oauthFail(w, r, http.StatusUnauthorized, failed)
+		return
+	}
+	u, err := a.identities.UserByIdentity(r.Context(), p.Name, identity.Subject)
+	if err == store.ErrUserNotFound {
+		if u, ok = a.linkOAuthUser(w, r, p, identity); !ok {
+			return
+		}
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// the provider stands in for the password, so a second factor is still asked for when it is on
+	if _, enabled, err := a.twoFactor.TOTP(r.Context(), u.Id); err != nil {
+		writeInternalError(w, r, err)
+		return
+	} else if enabled {
+		challenge, err := a.issueMFAChallenge(u.Id)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		a.oauthDone(w, r, url.Values{"mfa_required": {"true"}, "challenge": {challenge}}, mfaChallengeResponse{MFARequired: true, Challenge: challenge})
+		return
+	}
+
+	session, err := a.startSession(r, u)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	a.recordLogin(r, models.LoginEvent{UserId: &u.Id, Email: u.Email, Method: models.LoginMethodOAuth}, "")
+	a.oauthDone(w, r, url.Values{
+		"token":         {session.Token},
+		"expires_in":    {strconv.Itoa(session.ExpiresIn)},
+		"refresh_token": {session.RefreshToken},
+	}, presentSession(r, session))
+}
+
+// the user a provider's account should sign in as the first time it is used, having linked it. a
+// verified email settles who that is: the user who already has it, or a new one. a local account
+// whose email was never verified isn't linked, since whoever registered it may not own the address
+// and would keep a way in through its password
+func (a *App) linkOAuthUser(w http.ResponseWriter, r *http.Request, p *oauth.Provider, identity oauth.Identity) (models.User, bool) {
+	if identity.Email == "" || !identity.EmailVerified {
+		a.oauthFail(w, r, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "your " + p.Name + " account has no verified email address"})
+		return models.User{}, false
+	}
+	email := normalizeEmail(identity.Email)
+	u, err := a.users.GetByEmail(r.Context(), email)
+	switch {
+	case err == store.ErrUserNotFound:
+		if u, err = a.createOAuthUser(r, identity, email); err == store.ErrEmailTaken {
+			a.oauthFail(w, r, http.StatusConflict, errEmailTaken)
+			return models.User{}, false
+		} else if err == errInvalidOAuthUser {
+			a.oauthFail(w, r, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "your " + p.Name + " name or email can't be used for an account"})
+			return models.User{}, false
+		}
+	case err == nil && u.EmailVerifiedAt == nil:
+		a.oauthFail(w, r, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "an account with this email exists but hasn't verified it; log in with its password and verify the email first"})
+		return models.User{}, false
+	}
+	if err == nil {
+		err = a.identities.LinkIdentity(r.Context(), u.Id, p.Name, identity.Subject, email)
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return models.User{}, false
+	}
+	return u, true
+}
+
+// the name or email a provider has for a new user doesn't pass validateUser
+var errInvalidOAuthUser = errors.New("provider's name or email is invalid for a user")
+
+// a new user, without a password and with the email the provider verified
+func (a *App) createOAuthUser(r *http.Request, identity oauth.Identity, email string) (models.User, error) {
+	u := models.User{Name: a.normalizeName(identity.Name), Email: email}
+	if u.Name == "" {
+		u.Name, _, _ = strings.Cut(email, "@")
+	}
+	if len(validateUser(u)) > 0 {
+		return models.User{}, errInvalidOAuthUser
+	}
+	u, err := a.users.Create(r.Context(), u)
+	if err != nil {
+		return models.User{}, err
+	}
+	if u, err = a.users.MarkEmailVerified(r.Context(), u.Id, u.Email); err != nil {
+		return models.User{}, err
+	}
+	a.feed.publish(r.Context(), models.EventUserCreated, u)
+	return u, nil
+}
+
+// hand the outcome of a sign-in to the frontend page at OAuthRedirectURL in its fragment, where
+// it stays out of server logs and Referer headers, or write body when there is no such page
+func (a *App) oauthDone(w http.ResponseWriter, r *http.Request, fragment url.Values, body interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	if a.oauthRedirectURL == "" {
+		writeBody(w, body)
+		return
+	}
+	http.Redirect(w, r, a.oauthRedirectURL+"#"+fragment.Encode(), http.StatusFound)
+}
+
+func (a *App) oauthFail(w http.ResponseWriter, r *http.Request, status int, apiErr models.APIError) {
+	if a.oauthRedirectURL == "" {
+		models.WriteError(w, status, apiErr)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, a.oauthRedirectURL+"#"+url.Values{"error": {apiErr.Code}, "error_description": {apiErr.Message}}.Encode(), http.StatusFound)
+}
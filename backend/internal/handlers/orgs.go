@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+type orgRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type memberRequest struct {
+	UserId int    `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type memberPage struct {
+	Total int             `json:"total"`
+	Page  int             `json:"page"`
+	Limit int             `json:"limit"`
+	Items []models.Member `json:"items"`
+}
+
+func writeOrgNotFound(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "organization not found"})
+}
+
+func writeMemberNotFound(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "member not found"})
+}
+
+func writeOrgRoleTooLow(w http.ResponseWriter, min string) {
+	models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "your role in the organization does not allow this", Fields: map[string]string{"role": min + " is required"}})
+}
+
+func writeLastOwner(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "an organization needs at least one owner"})
+}
+
+// field errors for an organization role; empty when it is one
+func validOrgRole(role string) map[string]string {
+	fields := map[string]string{}
+	if !slices.Contains(models.OrgRoles, role) {
+		fields["role"] = "role must be one of " + strings.Join(models.OrgRoles, ", ")
+	}
+	return fields
+}
+
+// the organization named by the {id} route variable and the caller's role in it, having written an
+// error unless that role is at least min. organizations the caller isn't in are not found, unless
+// their role lets them manage every organization, which makes them an owner of each
+func (a *App) orgAccess(w http.ResponseWriter, r *http.Request, min string) (models.Organization, string, bool) {
+	id, ok := routeID(r)
+	if !ok {
+		writeOrgNotFound(w)
+		return models.Organization{}, "", false
+	}
+	caller, _ := middleware.UserID(r.Context())
+	role, err := a.orgs.Role(r.Context(), id, caller)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return models.Organization{}, "", false
+	}
+	if role == "" {
+		manager, err := a.roles.HasPermission(r.Context(), caller, models.PermOrgsManage)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return models.Organization{}, "", false
+		}
+		if !manager {
+			writeOrgNotFound(w)
+			return models.Organization{}, "", false
+		}
+		role = models.OrgRoleOwner
+	}
+
+	org, err := a.orgs.Get(r.Context(), id)
+	if err == store.ErrOrgNotFound {
+		writeOrgNotFound(w)
+		return models.Organization{}, "", false
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return models.Organization{}, "", false
+	}
+	if !models.OrgRoleAtLeast(role, min) {
+		writeOrgRoleTooLow(w, min)
+		return models.Organization{}, "", false
+	}
+	org.Role = role
+	return org, role, true
+}
+
+// the {userId} route variable as an int; ok is false when it is not a number
+func routeMemberID(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["userId"])
+	return id, err == nil
+}
+
+// the organizations the caller belongs to
+func (a *App) listOrgs(w http.ResponseWriter, r *http.Request) {
+	caller, _ := middleware.UserID(r.Context())
+	orgs, err := a.orgs.ForUser(r.Context(), caller)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, orgs)
+}
+
+// create an organization with the caller as its owner
+func (a *App) createOrg(w http.ResponseWriter, r *http.Request) {
+	var req orgRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if fields := validationErrors(req); len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "organization is invalid", Fields: fields})
+		return
+	}
+
+	caller, _ := middleware.UserID(r.Context())
+	org, err := a.orgs.Create(r.Context(), req.Name, caller)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, org)
+}
+
+func (a *App) getOrg(w http.ResponseWriter, r *http.Request) {
+	if org, _, ok := a.orgAccess(w, r, models.OrgRoleMember); ok {
+		writeBody(w, org)
+	}
+}
+
+func (a *App) updateOrg(w http.ResponseWriter, r *http.Request) {
+	var req orgRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if fields := validationErrors(req); len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "organization is invalid", Fields: fields})
+		return
+	}
+	org, role, ok := a.orgAccess(w, r, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	org, err := a.orgs.Rename(r.Context(), org.Id, req.Name)
+	if err == store.ErrOrgNotFound {
+		writeOrgNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	org.Role = role
+	writeBody(w, org)
+}
+
+// delete an organization and every membership of it; the users themselves stay
+func (a *App) deleteOrg(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := a.orgAccess(w, r, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	if err := a.orgs.Delete(r.Context(), org.Id); err == store.ErrOrgNotFound {
+		writeOrgNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) listOrgMembers(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	limit, offset := parsePagination(r, fields)
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: fields})
+		return
+	}
+	org, _, ok := a.orgAccess(w, r, models.OrgRoleMember)
+	if !ok {
+		return
+	}
+
+	members, total, err := a.orgs.Members(r.Context(), org.Id, limit, offset)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for i, m := range members {
+		members[i] = maskMember(r.Context(), m)
+	}
+	writeBody(w, memberPage{Total: total, Page: offset/limit + 1, Limit: limit, Items: members})
+}
+
+// add a user of the tenant to the organization. admins add members and admins; only owners add owners
+func (a *App) addOrgMember(w http.ResponseWriter, r *http.Request) {
+	var req memberRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.OrgRoleMember
+	}
+	fields := validOrgRole(req.Role)
+	if req.UserId <= 0 {
+		fields["user_id"] = "user_id is required"
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "member is invalid", Fields: fields})
+		return
+	}
+	org, role, ok := a.orgAccess(w, r, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	if req.Role == models.OrgRoleOwner && role != models.OrgRoleOwner {
+		writeOrgRoleTooLow(w, models.OrgRoleOwner)
+		return
+	}
+
+	m, err := a.orgs.AddMember(r.Context(), org.Id, req.UserId, req.Role)
+	switch err {
+	case nil:
+	case store.ErrUserNotFound:
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "member is invalid", Fields: map[string]string{"user_id": "user_id must be an existing user"}})
+		return
+	case store.ErrAlreadyMember:
+		models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "user is already a member", Fields: map[string]string{"user_id": "user is already a member"}})
+		return
+	default:
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, maskMember(r.Context(), m))
+}
+
+// the member of org named by the {userId} route variable and their role in it, having written a
+// 404 when there is no such member
+func (a *App) routeMember(w http.ResponseWriter, r *http.Request, org models.Organization) (int, string, bool) {
+	userID, ok := routeMemberID(r)
+	if !ok {
+		writeMemberNotFound(w)
+		return 0, "", false
+	}
+	role, err := a.orgs.Role(r.Context(), org.Id, userID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return 0, "", false
+	}
+	if role == "" {
+		writeMemberNotFound(w)
+		return 0, "", false
+	}
+	return userID, role, true
+}
+
+// change a member's role. admins move members between member and admin; changes to or from owner
+// are for owners, and the last owner can't step down
+func (a *App) setOrgMemberRole(w http.ResponseWriter, r *http.Request) {
+	var req roleAssignment
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	if fields := validOrgRole(req.Role); len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "role is invalid", Fields: fields})
+		return
+	}
+	org, role, ok := a.orgAccess(w, r, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	userID, current, ok := a.routeMember(w, r, org)
+	if !ok {
+		return
+	}
+	if (req.Role == models.OrgRoleOwner || current == models.OrgRoleOwner) && role != models.OrgRoleOwner {
+		writeOrgRoleTooLow(w, models.OrgRoleOwner)
+		return
+	}
+
+	m, err := a.orgs.SetMemberRole(r.Context(), org.Id, userID, req.Role)
+	switch err {
+	case nil:
+	case store.ErrMemberNotFound:
+		writeMemberNotFound(w)
+		return
+	case store.ErrLastOwner:
+		writeLastOwner(w)
+		return
+	default:
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, maskMember(r.Context(), m))
+}
+
+// remove a member from the organization. admins remove members and admins, owners anyone, and
+// any member may leave, except the last owner
+func (a *App) removeOrgMember(w http.ResponseWriter, r *http.Request) {
+	caller, _ := middleware.UserID(r.Context())
+	if userID, ok := routeMemberID(r); ok && userID == caller {
+		a.leaveOrg(w, r, caller)
+		return
+	}
+	org, role, ok := a.orgAccess(w, r, models.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	userID, current, ok := a.routeMember(w, r, org)
+	if !ok {
+		return
+	}
+	if current == models.OrgRoleOwner && role != models.OrgRoleOwner {
+		writeOrgRoleTooLow(w, models.OrgRoleOwner)
+		return
+	}
+	a.removeMember(w, r, org.Id, userID)
+}
+
+func (a *App) leaveOrg(w http.ResponseWriter, r *http.Request, caller int) {
+	org, _, ok := a.orgAccess(w, r, models.OrgRoleMember)
+	if !ok {
+		return
+	}
+	a.removeMember(w, r, org.Id, caller)
+}
+
+func (a *App) removeMember(w http.ResponseWriter, r *http.Request, orgID, userID int) {
+	switch err := a.orgs.RemoveMember(r.Context(), orgID, userID); err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case store.ErrMemberNotFound:
+		writeMemberNotFound(w)
+	case store.ErrLastOwner:
+		writeLastOwner(w)
+	default:
+		writeInternalError(w, r, err)
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy is what a new password has to satisfy on register, create, change and reset.
+type PasswordPolicy struct {
+	MinLength int // in characters; zero means 8
+	// classes of character a password must contain at least one of: upper, lower, digit and symbol
+	RequiredClasses []string
+	BlockCommon     bool // refuse the passwords in common_passwords.txt
+	BlockEmail      bool // refuse passwords that contain the account's email, or the part before the @
+}
+
+// PasswordClasses are the character classes a policy can require.
+var PasswordClasses = []string{"upper", "lower", "digit", "symbol"}
+
+// the default minimum password length
+const minPasswordLength = 8
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]bool {
+	set := map[string]bool{}
+	for _, line := range strings.Split(commonPasswordList, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			set[strings.ToLower(line)] = true
+		}
+	}
+	return set
+}()
+
+var classChecks = map[string]struct {
+	has  func(rune) bool
+	desc string
+}{
+	"upper":  {unicode.IsUpper, "an uppercase letter"},
+	"lower":  {unicode.IsLower, "a lowercase letter"},
+	"digit":  {unicode.IsDigit, "a digit"},
+	"symbol": {func(c rune) bool { return !unicode.IsLetter(c) && !unicode.IsDigit(c) && !unicode.IsSpace(c) }, "a symbol"},
+}
+
+// return a validation message for a password the policy refuses for the account with email, or ""
+// if it is fine. email may be empty when it isn't known yet
+func (p PasswordPolicy) validate(password, email string) string {
+	minLength := p.MinLength
+	if minLength == 0 {
+		minLength = minPasswordLength
+	}
+	if utf8.RuneCountInString(password) < minLength {
+		return fmt.Sprintf("password must be at least %d characters", minLength)
+	}
+	for _, class := range p.RequiredClasses {
+		check := classChecks[class]
+		if !strings.ContainsFunc(password, check.has) {
+			return "password must contain " + check.desc
+		}
+	}
+	lower := strings.ToLower(password)
+	if p.BlockCommon && commonPasswords[lower] {
+		return "password is too common; choose one that is harder to guess"
+	}
+	if p.BlockEmail && email != "" {
+		email = strings.ToLower(email)
+		local, _, _ := strings.Cut(email, "@")
+		// a short local part such as "jo" would rule out too many passwords to be useful
+		if strings.Contains(lower, email) || (utf8.RuneCountInString(local) >= 4 && strings.Contains(lower, local)) {
+			return "password must not contain your email address"
+		}
+	}
+	return ""
+}
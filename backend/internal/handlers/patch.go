@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+
+	"api/internal/models"
+	"api/internal/store"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// the members of a user a patch may change; the rest are managed by the server
+var patchableUserFields = map[string]bool{"name": true, "username": true, "email": true, "phone": true, "bio": true, "timezone": true, "locale": true}
+
+// apply an RFC 7386 merge patch: objects merge member by member, null removes a member
+// and any other value replaces the target outright
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// the patchable members of u as a JSON document. unset profile fields are left out, so a merge
+// patch sets them with a value and clears them with null
+func patchableUser(u models.User) map[string]interface{} {
+	doc := map[string]interface{}{"name": u.Name, "username": u.Username, "email": u.Email}
+	for name, v := range map[string]*string{"phone": u.Phone, "bio": u.Bio, "timezone": u.Timezone, "locale": u.Locale} {
+		if v != nil {
+			doc[name] = *v
+		}
+	}
+	return doc
+}
+
+// read the patched document back into a user, collecting members that can't be set
+func userFromPatched(doc map[string]interface{}) (models.User, map[string]string) {
+	var u models.User
+	fields := map[string]string{}
+	for k, v := range doc {
+		if !patchableUserFields[k] {
+			fields[k] = k + " cannot be changed"
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			fields[k] = k + " must be a string"
+			continue
+		}
+		switch k {
+		case "name":
+			u.Name = s
+		case "username":
+			u.Username = s
+		case "email":
+			u.Email = s
+		case "phone":
+			u.Phone = &s
+		case "bio":
+			u.Bio = &s
+		case "timezone":
+			u.Timezone = &s
+		case "locale":
+			u.Locale = &s
+		}
+	}
+	// every user has one, so unlike the profile fields it can only be replaced
+	if _, ok := doc["username"]; !ok {
+		fields["username"] = "username cannot be removed"
+	}
+	return u, fields
+}
+
+// the version carried in a patch body, if any. it's a precondition rather than a change,
+// so it is taken out before the patch is applied
+func patchVersion(patch map[string]interface{}) (int, bool) {
+	raw, present := patch["version"]
+	if !present {
+		return 0, true
+	}
+	delete(patch, "version")
+	v, ok := raw.(float64)
+	if !ok || v < 1 || v != float64(int(v)) {
+		return 0, false
+	}
+	return int(v), true
+}
+
+// why a patch could not be applied to the user
+type patchError struct {
+	conflict bool // a failed test, as opposed to an operation that doesn't fit the user
+	path     string
+	msg      string
+}
+
+func (e *patchError) Error() string { return e.path + ": " + e.msg }
+
+// a decoded patch body, applied once the user it targets has been read
+type userPatch struct {
+	version int // precondition carried in the body, 0 for none
+	apply   func(current models.User) (map[string]interface{}, error)
+}
+
+// decode the body as the patch format named by its Content-Type, writing the error response on failure
+func (a *App) decodeUserPatch(w http.ResponseWriter, r *http.Request) (userPatch, bool) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchType, "application/json":
+		var patch map[string]interface{}
+		if !a.decodeBody(w, r, &patch, "request body must be a JSON merge patch object") {
+			return userPatch{}, false
+		}
+		if apiVersion(r) == apiV2 {
+			if pe := flattenProfilePatch(patch); pe != nil {
+				models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "patch is invalid", Fields: map[string]string{pe.path: pe.msg}})
+				return userPatch{}, false
+			}
+		}
+		version, ok := patchVersion(patch)
+		if !ok {
+			models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "patch is invalid", Fields: map[string]string{"version": "version must be a positive integer"}})
+			return userPatch{}, false
+		}
+		return userPatch{version: version, apply: func(current models.User) (map[string]interface{}, error) {
+			return mergePatch(patchableUser(current), patch).(map[string]interface{}), nil
+		}}, true
+	case jsonPatchType:
+		var ops []patchOperation
+		if !a.decodeBody(w, r, &ops, "request body must be a JSON patch array of operations") {
+			return userPatch{}, false
+		}
+		if apiVersion(r) == apiV2 {
+			flattenProfileOps(ops)
+		}
+		return userPatch{apply: func(current models.User) (map[string]interface{}, error) {
+			return jsonPatch(current, ops)
+		}}, true
+	}
+	models.WriteError(w, http.StatusUnsupportedMediaType, models.APIError{Code: models.ErrCodeUnsupportedMediaType, Message: "send the patch as " + mergePatchType + " or " + jsonPatchType})
+	return userPatch{}, false
+}
+
+// partially update a user with a JSON merge patch or JSON patch, so only what is sent changes
+func (a *App) patchUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	patch, ok := a.decodeUserPatch(w, r)
+	if !ok {
+		return
+	}
+
+	current, err := a.users.Get(r.Context(), id, false)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// without a precondition the patch still applies to the version just read, so an edit
+	// landing in between is reported rather than overwritten
+	version, fromHeader := current.Version, false
+	if r.Header.Get("If-Match") != "" || patch.version > 0 {
+		if version, fromHeader, ok = expectedVersion(w, r, patch.version); !ok {
+			return
+		}
+	}
+
+	doc, err := patch.apply(current)
+	if pe, isPatchErr := err.(*patchError); isPatchErr && pe.conflict {
+		models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "patch test failed", Fields: map[string]string{pe.path: pe.msg}})
+		return
+	} else if isPatchErr {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "patch is invalid", Fields: map[string]string{pe.path: pe.msg}})
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	u, fields := userFromPatched(doc)
+	u.Name = a.normalizeName(u.Name)
+	u.Email = normalizeEmail(u.Email)
+	normalizeProfile(&u)
+	for field, msg := range validateUser(u) {
+		if _, set := fields[field]; !set {
+			fields[field] = msg
+		}
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "patch is invalid", Fields: presentFields(r, fields)})
+		return
+	}
+
+	updatedUser, err := a.users.Update(r.Context(), id, u, version)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err == store.ErrVersionConflict {
+		writeVersionConflict(w, fromHeader)
+		return
+	} else if err == store.ErrEmailTaken {
+		writeEmailTaken(w)
+		return
+	} else if err == store.ErrUsernameTaken {
+		writeUsernameTaken(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	a.feed.publish(r.Context(), models.EventUserUpdated, updatedUser)
+	setUserETag(w, updatedUser)
+	writeBody(w, presentUser(r, updatedUser))
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+)
+
+type postRequest struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type postPage struct {
+	Total int           `json:"total"`
+	Page  int           `json:"page"`
+	Limit int           `json:"limit"`
+	Items []models.Post `json:"items"`
+}
+
+func writePostNotFound(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "post not found"})
+}
+
+// decode a post's title and body, having written an error unless they are valid
+func (a *App) decodePost(w http.ResponseWriter, r *http.Request) (models.Post, bool) {
+	var req postRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return models.Post{}, false
+	}
+	p := models.Post{Title: strings.TrimSpace(req.Title), Body: req.Body}
+	if fields := validationErrors(p); len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "post is invalid", Fields: fields})
+		return models.Post{}, false
+	}
+	return p, true
+}
+
+// the post named by the {id} route variable, having written a 404 when there is none
+func (a *App) routePost(w http.ResponseWriter, r *http.Request) (models.Post, bool) {
+	id, ok := routeID(r)
+	if !ok {
+		writePostNotFound(w)
+		return models.Post{}, false
+	}
+	p, err := a.posts.Get(r.Context(), id)
+	if err == store.ErrPostNotFound {
+		writePostNotFound(w)
+		return models.Post{}, false
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return models.Post{}, false
+	}
+	return p, true
+}
+
+// the post named by the {id} route variable, having written an error unless the caller wrote it
+// or may manage everyone's
+func (a *App) ownPost(w http.ResponseWriter, r *http.Request) (models.Post, bool) {
+	p, ok := a.routePost(w, r)
+	if !ok {
+		return models.Post{}, false
+	}
+	caller, _ := middleware.UserID(r.Context())
+	if p.AuthorId == caller {
+		return p, true
+	}
+	granted, err := a.roles.HasPermission(r.Context(), caller, models.PermPostsManage)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return models.Post{}, false
+	}
+	if !granted {
+		models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "only the author can change this post", Fields: map[string]string{"permission": models.PermPostsManage + " is required"}})
+		return models.Post{}, false
+	}
+	return p, true
+}
+
+func (a *App) writePostPage(w http.ResponseWriter, r *http.Request, authorID int) {
+	fields := map[string]string{}
+	limit, offset := parsePagination(r, fields)
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: fields})
+		return
+	}
+	posts, total, err := a.posts.List(r.Context(), authorID, limit, offset)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, postPage{Total: total, Page: offset/limit + 1, Limit: limit, Items: posts})
+}
+
+// every post, newest first
+func (a *App) listPosts(w http.ResponseWriter, r *http.Request) {
+	a.writePostPage(w, r, 0)
+}
+
+// the posts of the user named by the {id} route variable, newest first
+func (a *App) listUserPosts(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if ok {
+		if _, err := a.users.Get(r.Context(), id, false); err == store.ErrUserNotFound {
+			ok = false
+		} else if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	}
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	a.writePostPage(w, r, id)
+}
+
+// write a post as the user named by the {id} route variable
+func (a *App) createPost(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	p, ok := a.decodePost(w, r)
+	if !ok {
+		return
+	}
+	p.AuthorId = id
+	p, err := a.posts.Create(r.Context(), p)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, p)
+}
+
+func (a *App) getPost(w http.ResponseWriter, r *http.Request) {
+	if p, ok := a.routePost(w, r); ok {
+		writeBody(w, p)
+	}
+}
+
+func (a *App) updatePost(w http.ResponseWriter, r *http.Request) {
+	changed, ok := a.decodePost(w, r)
+	if !ok {
+		return
+	}
+	p, ok := a.ownPost(w, r)
+	if !ok {
+		return
+	}
+	changed.Id = p.Id
+	p, err := a.posts.Update(r.Context(), changed)
+	if err == store.ErrPostNotFound {
+		writePostNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, p)
+}
+
+func (a *App) deletePost(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.ownPost(w, r)
+	if !ok {
+		return
+	}
+	if err := a.posts.Delete(r.Context(), p.Id); err == store.ErrPostNotFound {
+		writePostNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// both preferences must be given, so a client that doesn't know of one can't switch it off by omission
+type preferencesRequest struct {
+	ProfileChanges *bool `json:"profile_changes"`
+	ProductUpdates *bool `json:"product_updates"`
+}
+
+func (a *App) getPreferences(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	p, err := a.preferences.NotificationPreferences(r.Context(), id)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, p)
+}
+
+// replace a user's notification preferences
+func (a *App) putPreferences(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	var req preferencesRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	fields := map[string]string{}
+	if req.ProfileChanges == nil {
+		fields["profile_changes"] = "profile_changes is required"
+	}
+	if req.ProductUpdates == nil {
+		fields["product_updates"] = "product_updates is required"
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "preferences are invalid", Fields: fields})
+		return
+	}
+
+	p := models.NotificationPreferences{ProfileChanges: *req.ProfileChanges, ProductUpdates: *req.ProductUpdates}
+	if err := a.preferences.SetNotificationPreferences(r.Context(), id, p); err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, p)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+	"api/internal/tenant"
+)
+
+// how long a refresh token stays usable; every refresh replaces it with a new one
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func newRefreshToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// an access token for u's session in the tenant of ctx plus the refresh token that will renew it
+func (a *App) session(ctx context.Context, u models.User, sessionID int, refreshToken string) (authResponse, error) {
+	token, err := middleware.IssueToken(a.tokenSecret, u.Id, sessionID, tenant.ID(ctx))
+	if err != nil {
+		return authResponse{}, err
+	}
+	return authResponse{Token: token, ExpiresIn: int(middleware.AccessTokenTTL.Seconds()), RefreshToken: refreshToken, User: u}, nil
+}
+
+func sessionClient(r *http.Request) models.SessionClient {
+	return models.SessionClient{UserAgent: r.UserAgent(), IP: middleware.ClientIP(r)}
+}
+
+// sign u in on a new session for the requesting device
+func (a *App) startSession(r *http.Request, u models.User) (authResponse, error) {
+	refreshToken := newRefreshToken()
+	sessionID, err := a.sessions.CreateSession(r.Context(), u.Id, sessionClient(r), hashToken(refreshToken), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return authResponse{}, err
+	}
+	return a.session(r.Context(), u, sessionID, refreshToken)
+}
+
+func (a *App) decodeRefreshToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req refreshRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return "", false
+	}
+	if req.RefreshToken == "" {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "request is invalid", Fields: map[string]string{"refresh_token": "refresh_token is required"}})
+		return "", false
+	}
+	return req.RefreshToken, true
+}
+
+// trade a refresh token for a new access token and a replacement refresh token
+func (a *App) refresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken, ok := a.decodeRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	next := newRefreshToken()
+	userID, sessionID, err := a.sessions.RotateRefreshToken(r.Context(), hashToken(refreshToken), hashToken(next), time.Now().Add(refreshTokenTTL), sessionClient(r))
+	switch err {
+	case nil:
+	case store.ErrInvalidRefreshToken, store.ErrRefreshTokenReused:
+		// reuse isn't told apart, so a thief learns nothing from replaying a token
+		models.WriteError(w, http.StatusUnauthorized, models.APIError{Code: models.ErrCodeUnauthorized, Message: "refresh token is invalid or expired; log in again"})
+		return
+	default:
+		writeInternalError(w, r, err)
+		return
+	}
+
+	u, err := a.users.Get(r.Context(), userID, false)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	session, err := a.session(r.Context(), u, sessionID, next)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, presentSession(r, session))
+}
+
+// revoke the session a refresh token belongs to, along with the access tokens issued for it
+func (a *App) logout(w http.ResponseWriter, r *http.Request) {
+	refreshToken, ok := a.decodeRefreshToken(w, r)
+	if !ok {
+		return
+	}
+	if err := a.sessions.RevokeRefreshToken(r.Context(), hashToken(refreshToken)); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"api/internal/mail"
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// how long a password reset link stays valid
+const passwordResetTTL = time.Hour
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// reset and refresh tokens are random and kept only as a hash, so the tables alone can't be used to sign in
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// email a single-use reset link. the response is the same whether or not the email is
+// registered, so the endpoint can't be used to discover accounts
+func (a *App) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	if req.Email == "" {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "request is invalid", Fields: map[string]string{"email": "email is required"}})
+		return
+	}
+
+	u, err := a.users.GetByEmail(r.Context(), normalizeEmail(req.Email))
+	if err == nil {
+		err = a.sendPasswordReset(r, u)
+	}
+	if err != nil && err != store.ErrUserNotFound {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	writeBody(w, map[string]string{"status": "sent"})
+}
+
+func (a *App) sendPasswordReset(r *http.Request, u models.User) error {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if err := a.passwords.CreatePasswordReset(r.Context(), u.Id, hashToken(token), time.Now().Add(passwordResetTTL)); err != nil {
+		return err
+	}
+
+	link := a.passwordResetURL + "?token=" + url.QueryEscape(token)
+	m, err := mail.Render(mail.TemplatePasswordReset, u.Email, mail.Data{Name: u.Name, Link: link})
+	if err == nil {
+		err = a.mailer.Send(r.Context(), m)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "send password reset email failed", "err", err, "user_id", u.Id)
+	}
+	return err
+}
+
+// set a new password with a token from a reset email; every access token issued before now stops working
+func (a *App) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	if req.Token == "" {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "request is invalid", Fields: map[string]string{"token": "token is required"}})
+		return
+	}
+	// the policy may refuse passwords containing the email of the user the token is for
+	email, err := a.passwords.PasswordResetEmail(r.Context(), hashToken(req.Token))
+	if err == store.ErrInvalidResetToken {
+		writeInvalidResetToken(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if msg := a.passwordPolicy.validate(req.NewPassword, email); msg != "" {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "request is invalid", Fields: map[string]string{"new_password": msg}})
+		return
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if _, err := a.passwords.ResetPassword(r.Context(), hashToken(req.Token), hash); err == store.ErrInvalidResetToken {
+		writeInvalidResetToken(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeInvalidResetToken(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "reset link is invalid or expired", Fields: map[string]string{"token": "token is invalid, used or expired"}})
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+func writeRevisionNotFound(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "revision not found"})
+}
+
+// every version of a user, oldest first
+func (a *App) getRevisions(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	revisions, err := a.revisions.Revisions(r.Context(), id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if len(revisions) == 0 {
+		writeNotFound(w)
+		return
+	}
+	writeBody(w, presentRevisions(r, revisions))
+}
+
+// fields that change with every revision, and so say nothing about what changed
+var revisionBookkeeping = map[string]bool{"version": true, "updated_at": true}
+
+// the user's JSON fields, so revisions compare the way clients see them
+func userFields(u *models.User) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if u == nil {
+		return fields
+	}
+	b, _ := json.Marshal(u)
+	json.Unmarshal(b, &fields)
+	return fields
+}
+
+// the fields that differ between two versions of a user, by name; from is nil for a first revision
+func diffUsers(from *models.User, to models.User) []models.FieldChange {
+	before, after := userFields(from), userFields(&to)
+	names := make([]string, 0, len(after))
+	for name := range after {
+		names = append(names, name)
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []models.FieldChange{}
+	for _, name := range names {
+		if revisionBookkeeping[name] {
+			continue
+		}
+		if !reflect.DeepEqual(before[name], after[name]) {
+			changes = append(changes, models.FieldChange{Field: name, From: before[name], To: after[name]})
+		}
+	}
+	return changes
+}
+
+// what changed in revision {rev} since the one before it
+func (a *App) getRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	rev, err := strconv.Atoi(mux.Vars(r)["rev"])
+	if !ok || err != nil || rev < 1 {
+		writeRevisionNotFound(w)
+		return
+	}
+
+	current, err := a.revisions.Revision(r.Context(), id, rev)
+	if err == store.ErrRevisionNotFound {
+		writeRevisionNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	diff := models.RevisionDiff{Rev: rev}
+	var previous *models.User
+	if rev > 1 {
+		prev, err := a.revisions.Revision(r.Context(), id, rev-1)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		// compared as masked, so a change to a masked field shows only as much as the caller may see
+		masked := maskUser(r.Context(), prev.User)
+		previous = &masked
+		diff.PreviousRev = &prev.Rev
+	}
+	diff.Changes = diffUsers(previous, maskUser(r.Context(), current.User))
+	writeBody(w, presentDiff(r, diff))
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// body accepted by the role assignment endpoint
+type roleAssignment struct {
+	Role string `json:"role"`
+}
+
+// require the caller to be the user named by the {id} route variable or to hold permission
+func (a *App) requireSelfOr(permission string) func(http.Handler) http.Handler {
+	require := middleware.RequirePermission(a.roles, permission)
+	return func(next http.Handler) http.Handler {
+		guarded := require(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isSelf(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			guarded.ServeHTTP(w, r)
+		})
+	}
+}
+
+// h, answering 403 to ?include_deleted=true unless the caller may delete and restore users too:
+// nobody else sees soft-deleted users
+func (a *App) requireForDeleted(h http.HandlerFunc) http.HandlerFunc {
+	guarded := middleware.RequirePermission(a.roles, models.PermUsersDelete)(h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if includeDeleted(r) {
+			guarded.ServeHTTP(w, r)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func isSelf(r *http.Request) bool {
+	id, ok := routeID(r)
+	caller, authenticated := middleware.UserID(r.Context())
+	return ok && authenticated && id == caller
+}
+
+// for resolvers outside the router's middleware: an error when the caller lacks permission
+func (a *App) authorize(ctx context.Context, permission string) error {
+	userID, _ := middleware.UserID(ctx)
+	granted, err := a.roles.HasPermission(ctx, userID, permission)
+	if err != nil {
+		return err
+	}
+	if !granted {
+		return models.APIError{Code: models.ErrCodeForbidden, Message: "your role does not allow this", Fields: map[string]string{"permission": permission + " is required"}}
+	}
+	return nil
+}
+
+func (a *App) listRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := a.roles.Roles(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, roles)
+}
+
+func (a *App) setUserRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	var req roleAssignment
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	if req.Role == "" {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "role is invalid", Fields: map[string]string{"role": "role is required"}})
+		return
+	}
+
+	u, err := a.roles.SetRole(r.Context(), id, req.Role)
+	switch err {
+	case nil:
+	case store.ErrUserNotFound:
+		writeNotFound(w)
+		return
+	case store.ErrUnknownRole:
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "role is invalid", Fields: map[string]string{"role": "no role named " + req.Role}})
+		return
+	case store.ErrLastAdmin:
+		models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "promote another admin before demoting the last one"})
+		return
+	default:
+		writeInternalError(w, r, err)
+		return
+	}
+
+	a.feed.publish(r.Context(), models.EventUserUpdated, u)
+	writeBody(w, presentUser(r, u))
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"api/internal/models"
+)
+
+type searchResponse struct {
+	Query string      `json:"query"`
+	Mode  string      `json:"mode"`
+	Items interface{} `json:"items"` // the results, as presented by the API version
+}
+
+// search users by name and email, best matches first; ?mode=fuzzy tolerates typos
+func (a *App) searchUsers(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	limit, offset := parsePagination(r, fields)
+	params := r.URL.Query()
+	term := strings.TrimSpace(params.Get("q"))
+	if term == "" {
+		fields["q"] = "q is required"
+	}
+	mode := params.Get("mode")
+	if mode == "" {
+		mode = "fulltext"
+	}
+	if mode != "fulltext" && mode != "fuzzy" {
+		fields["mode"] = "mode must be fulltext or fuzzy"
+	}
+	threshold := a.searchSimilarityThreshold
+	if v := params.Get("threshold"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 || t > 1 {
+			fields["threshold"] = "threshold must be a number between 0 and 1"
+		}
+		threshold = t
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid search parameters", Fields: fields})
+		return
+	}
+
+	var results []models.SearchResult
+	var err error
+	if mode == "fuzzy" {
+		results, err = a.search.FuzzySearch(r.Context(), term, threshold, limit, offset)
+	} else {
+		results, err = a.search.Search(r.Context(), term, limit, offset)
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeBody(w, presentSearch(r, term, mode, results))
+}
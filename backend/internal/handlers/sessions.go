@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+// user agent fragments to a readable name, most specific first: Edge and Opera both claim to be
+// Chrome, and Chrome claims to be Safari
+var (
+	browserNames = []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"}, {"curl/", "curl"},
+	}
+	osNames = []struct{ token, name string }{
+		{"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Android", "Android"}, {"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	}
+)
+
+// a short description of the device behind a user agent, such as "Firefox on Windows"
+func describeDevice(userAgent string) string {
+	var browser, os string
+	for _, b := range browserNames {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, o := range osNames {
+		if strings.Contains(userAgent, o.token) {
+			os = o.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "Unknown device"
+	}
+}
+
+func (a *App) listSessions(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	sessions, err := a.sessions.Sessions(r.Context(), id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	current, _ := middleware.SessionID(r.Context())
+	for i := range sessions {
+		sessions[i].Device = describeDevice(sessions[i].UserAgent)
+		sessions[i].Current = sessions[i].Id == current
+	}
+	writeBody(w, sessions)
+}
+
+// log a session out everywhere: its refresh token stops working and so do its access tokens
+func (a *App) revokeSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	sessionID, err := strconv.Atoi(mux.Vars(r)["sid"])
+	if !ok || err != nil {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "session not found"})
+		return
+	}
+	err = a.sessions.RevokeSession(r.Context(), id, sessionID)
+	if err == store.ErrSessionNotFound {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "session not found"})
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
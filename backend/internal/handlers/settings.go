@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	// time zones are checked against the embedded database, whatever the host has installed
+	_ "time/tzdata"
+
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// the most settings a user may keep, as JSON, so they stay preferences rather than storage
+const maxSettingsSize = 16 << 10
+
+var languageTagPattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+var themes = []string{"light", "dark", "system"}
+
+// the settings the API knows, each with a check returning why a value doesn't fit, or ""
+var knownSettings = map[string]func(v interface{}) string{
+	"theme": func(v interface{}) string {
+		if s, ok := v.(string); !ok || !slices.Contains(themes, s) {
+			return "theme must be one of " + strings.Join(themes, ", ")
+		}
+		return ""
+	},
+	"language": func(v interface{}) string {
+		if s, ok := v.(string); !ok || !languageTagPattern.MatchString(s) {
+			return "language must be a language tag such as en or pt-BR"
+		}
+		return ""
+	},
+	"timezone": func(v interface{}) string {
+		const msg = "timezone must be an IANA time zone such as Europe/Paris"
+		s, ok := v.(string)
+		if !ok || s == "" || s == "Local" {
+			return msg
+		}
+		if _, err := time.LoadLocation(s); err != nil {
+			return msg
+		}
+		return ""
+	},
+	"page_size": func(v interface{}) string {
+		if n, ok := v.(float64); !ok || n != float64(int(n)) || n < 1 || n > maxPageLimit {
+			return fmt.Sprintf("page_size must be a whole number between 1 and %d", maxPageLimit)
+		}
+		return ""
+	},
+	"sidebar_collapsed": func(v interface{}) string {
+		if _, ok := v.(bool); !ok {
+			return "sidebar_collapsed must be true or false"
+		}
+		return ""
+	},
+}
+
+// field errors for settings about to be saved, keyed by setting; empty when they can be
+func validateSettings(s models.Settings) map[string]string {
+	fields := map[string]string{}
+	for key, check := range knownSettings {
+		if v, ok := s[key]; ok {
+			if msg := check(v); msg != "" {
+				fields[key] = msg
+			}
+		}
+	}
+	if b, err := json.Marshal(s); err != nil || len(b) > maxSettingsSize {
+		fields["settings"] = fmt.Sprintf("settings must be at most %d bytes of JSON", maxSettingsSize)
+	}
+	return fields
+}
+
+// the patched settings didn't validate; the fields are written by the handler
+var errSettingsInvalid = errors.New("settings are invalid")
+
+func (a *App) getSettings(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	s, err := a.preferences.Settings(r.Context(), id)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, s)
+}
+
+// deep merge a JSON merge patch into a user's settings: objects merge key by key and null removes a
+// key, so clients only send what changed and keep what other clients saved
+func (a *App) patchSettings(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != mergePatchType && mediaType != "application/json" {
+		models.WriteError(w, http.StatusUnsupportedMediaType, models.APIError{Code: models.ErrCodeUnsupportedMediaType, Message: "send the patch as " + mergePatchType})
+		return
+	}
+	var patch map[string]interface{}
+	if !a.decodeBody(w, r, &patch, "request body must be a JSON merge patch object") {
+		return
+	}
+
+	var fields map[string]string
+	s, err := a.preferences.UpdateSettings(r.Context(), id, func(current models.Settings) (models.Settings, error) {
+		merged := models.Settings(mergePatch(map[string]interface{}(current), patch).(map[string]interface{}))
+		if fields = validateSettings(merged); len(fields) > 0 {
+			return nil, errSettingsInvalid
+		}
+		return merged, nil
+	})
+	switch err {
+	case nil:
+	case errSettingsInvalid:
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "settings are invalid", Fields: fields})
+		return
+	case store.ErrUserNotFound:
+		writeNotFound(w)
+		return
+	default:
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, s)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"api/internal/models"
+)
+
+// a page of a delta sync; clients apply the changes, drop the deleted users and come back with
+// next_cursor, straight away while has_more is set
+type syncPage struct {
+	Changes    []interface{}          `json:"changes"`
+	Deleted    []models.UserTombstone `json:"deleted"`
+	NextCursor string                 `json:"next_cursor"`
+	HasMore    bool                   `json:"has_more"`
+}
+
+func encodeSyncCursor(c models.SyncCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// a cursor from an earlier sync, or a timestamp to sync the changes made at or after it
+func parseSince(v string) (models.SyncCursor, bool) {
+	if t, err := parseTime(v); err == nil {
+		return models.SyncCursor{ChangedAt: t}, true
+	}
+	var c models.SyncCursor
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || json.Unmarshal(data, &c) != nil {
+		return c, false
+	}
+	return c, true
+}
+
+// the users created, updated and deleted since ?since=, for clients keeping an offline copy.
+// without it the sync starts from scratch, listing live users only
+func (a *App) getUserChanges(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	limit, _ := parsePagination(r, fields)
+	var after *models.SyncCursor
+	if v := r.URL.Query().Get("since"); v != "" {
+		c, ok := parseSince(v)
+		if !ok {
+			fields["since"] = "since must be a cursor or an RFC 3339 timestamp"
+		}
+		after = &c
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: fields})
+		return
+	}
+
+	c, err := a.users.Changes(r.Context(), after, limit)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	changes := make([]interface{}, len(c.Users))
+	for i, u := range c.Users {
+		changes[i] = versionedUser(r, u)
+	}
+	writeBody(w, syncPage{Changes: changes, Deleted: c.Deleted, NextCursor: encodeSyncCursor(c.Next), HasMore: c.More})
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+// tags are short lowercase labels such as beta, vip or early-access
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// a tag as given in a path or query, lowercased, and the message for fields when it isn't one
+func normalizeTag(raw string) (string, string) {
+	tag := strings.ToLower(strings.TrimSpace(raw))
+	if !tagPattern.MatchString(tag) {
+		return tag, "tag must be 1 to 50 letters, digits, - or _, starting with a letter or digit"
+	}
+	return tag, ""
+}
+
+// every tag in use, with how many users have it, for choosing a segment to list
+func (a *App) listTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := a.tags.Tags(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, tags)
+}
+
+func (a *App) getUserTags(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if ok {
+		if _, err := a.users.Get(r.Context(), id, false); err == store.ErrUserNotFound {
+			ok = false
+		} else if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	}
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	tags, err := a.tags.UserTags(r.Context(), id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, tags)
+}
+
+// put the {tag} route variable on a user, creating the tag the first time it is used
+func (a *App) tagUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	tag, msg := normalizeTag(mux.Vars(r)["tag"])
+	if msg != "" {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "tag is invalid", Fields: map[string]string{"tag": msg}})
+		return
+	}
+
+	tags, err := a.tags.TagUser(r.Context(), id, tag)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, tags)
+}
+
+func (a *App) untagUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	tag, _ := normalizeTag(mux.Vars(r)["tag"])
+	if err := a.tags.UntagUser(r.Context(), id, tag); err == store.ErrTagNotFound {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "user does not have this tag"})
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
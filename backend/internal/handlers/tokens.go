@@ -0,0 +1,17 @@
+package handlers
+
+import "github.com/golang-jwt/jwt/v5"
+
+// sign claims with the app's secret. tokens other than access tokens each carry their own audience,
+// so one kind can never be passed off as another
+func (a *App) signToken(claims jwt.Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.tokenSecret)
+}
+
+// verify that token was signed by the app for audience and decode it into claims
+func (a *App) parseToken(token, audience string, claims jwt.Claims) error {
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return a.tokenSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(audience))
+	return err
+}
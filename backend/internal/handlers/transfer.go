@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/codec"
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// header row of the CSV export, matching exportRecord
+var exportHeader = []string{"id", "name", "email", "created_at", "deleted_at"}
+
+func exportRecord(u models.User) []string {
+	deletedAt := ""
+	if u.DeletedAt != nil {
+		deletedAt = u.DeletedAt.Format(time.RFC3339)
+	}
+	return []string{strconv.Itoa(u.Id), u.Name, u.Email, u.CreatedAt.Format(time.RFC3339), deletedAt}
+}
+
+// stream users matching the list filters as CSV straight from the DB cursor
+func (a *App) exportUsers(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	if format := r.URL.Query().Get("format"); format != "" && format != "csv" {
+		fields["format"] = "format must be csv"
+	}
+	f := parseUserFilters(r, fields)
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid export parameters", Fields: fields})
+		return
+	}
+
+	// headers go out with the first row, so a query that fails up front still gets a JSON error
+	cw := csv.NewWriter(w)
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+			cw.Write(exportHeader)
+		}
+	}
+	n := 0
+	err := a.users.Each(r.Context(), f, func(u models.User) error {
+		start()
+		if err := cw.Write(exportRecord(maskUser(r.Context(), u))); err != nil {
+			return err
+		}
+		if n++; n%1000 == 0 {
+			cw.Flush()
+		}
+		return cw.Error()
+	})
+	// the status is already sent once rows start flowing, so later failures are only logged
+	if err != nil && !started {
+		writeInternalError(w, r, err)
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "export users", "err", err)
+	}
+	start()
+	cw.Flush()
+}
+
+// stream users matching the list filters as newline-delimited JSON straight from the DB cursor,
+// flushing each one as it is read so neither end holds the whole collection
+func (a *App) streamUserCollection(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	f := parseUserFilters(r, fields)
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid stream parameters", Fields: fields})
+		return
+	}
+
+	// as with the CSV export, the status goes out with the first user
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", codec.NDJSON)
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	err := a.users.Each(r.Context(), f, func(u models.User) error {
+		start()
+		// bulk readers have no use for each row's _links, so rows are kept lean
+		if err := enc.Encode(versionedUser(r, u)); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && !started {
+		writeInternalError(w, r, err)
+		return
+	} else if err != nil && r.Context().Err() == nil {
+		// a client going away, or a HEAD that only wanted the headers, ends the stream early
+		slog.ErrorContext(r.Context(), "stream users", "err", err)
+	}
+	start()
+}
+
+// largest CSV upload accepted by importUsers
+const maxImportSize = 10 << 20
+
+// a CSV row, numbered by its line in the uploaded file
+type importRow struct {
+	Row   int    `json:"row"`
+	Id    int    `json:"id,omitempty"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type rejectedRow struct {
+	importRow
+	Reasons []string `json:"reasons"`
+}
+
+type importReport struct {
+	Accepted []importRow   `json:"accepted"`
+	Rejected []rejectedRow `json:"rejected"`
+}
+
+// locate the name and email columns in the header row
+func importColumns(header []string) (nameCol, emailCol int, err error) {
+	nameCol, emailCol = -1, -1
+	for i, h := range header {
+		switch strings.ToLower(strings.TrimSpace(h)) {
+		case "name":
+			nameCol = i
+		case "email":
+			emailCol = i
+		}
+	}
+	if nameCol < 0 || emailCol < 0 {
+		return nameCol, emailCol, fmt.Errorf("header row must include name and email columns")
+	}
+	return nameCol, emailCol, nil
+}
+
+// import users from an uploaded CSV, inserting the valid rows and reporting the rest
+func (a *App) importUsers(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			models.WriteError(w, http.StatusRequestEntityTooLarge, models.APIError{Code: models.ErrCodePayloadTooLarge, Message: fmt.Sprintf("CSV file must be at most %d MiB", maxImportSize>>20)})
+			return
+		}
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "upload a CSV as the multipart field \"file\"", Fields: map[string]string{"file": "file is required"}})
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "CSV file is empty or malformed"})
+		return
+	}
+	nameCol, emailCol, err := importColumns(header)
+	if err != nil {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: err.Error()})
+		return
+	}
+
+	// first pass: per-row checks and duplicates within the file
+	report := importReport{Accepted: []importRow{}, Rejected: []rejectedRow{}}
+	var candidates []importRow
+	seen := map[string]int{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: fmt.Sprintf("CSV is malformed at line %d", line)})
+			return
+		}
+
+		row := importRow{Row: line}
+		if nameCol < len(record) {
+			row.Name = a.normalizeName(record[nameCol])
+		}
+		if emailCol < len(record) {
+			row.Email = normalizeEmail(record[emailCol])
+		}
+
+		var reasons []string
+		fields := validateUser(models.User{Name: row.Name, Email: row.Email})
+		if msg, ok := fields["name"]; ok {
+			reasons = append(reasons, msg)
+		}
+		if msg, ok := fields["email"]; ok {
+			reasons = append(reasons, msg)
+		} else if first, dup := seen[row.Email]; dup {
+			reasons = append(reasons, fmt.Sprintf("email duplicates row %d", first))
+		} else {
+			seen[row.Email] = line
+		}
+		if len(reasons) > 0 {
+			report.Rejected = append(report.Rejected, rejectedRow{importRow: row, Reasons: reasons})
+			continue
+		}
+		candidates = append(candidates, row)
+	}
+
+	// second pass: one query for emails that already belong to a user
+	emails := make([]string, len(candidates))
+	for i, row := range candidates {
+		emails[i] = row.Email
+	}
+	existing, err := a.users.ExistingEmails(r.Context(), emails)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	var accepted []importRow
+	var users []models.User
+	for _, row := range candidates {
+		if existing[row.Email] {
+			report.Rejected = append(report.Rejected, rejectedRow{importRow: row, Reasons: []string{"email already belongs to a user"}})
+			continue
+		}
+		accepted = append(accepted, row)
+		users = append(users, models.User{Name: row.Name, Email: row.Email})
+	}
+
+	created, err := a.users.CreateMany(r.Context(), users)
+	if err == store.ErrEmailTaken {
+		writeEmailTaken(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for i, u := range created {
+		accepted[i].Id = u.Id
+		report.Accepted = append(report.Accepted, accepted[i])
+		a.userCreated(r.Context(), u)
+	}
+
+	writeBody(w, report)
+}
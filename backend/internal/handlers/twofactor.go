@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// issuer shown next to the account in authenticator apps
+const totpIssuer = "goapp"
+
+// TOTP parameters every authenticator app supports: 6 digits from HMAC-SHA1 over 30 second steps
+const (
+	totpPeriod = 30
+	totpDigits = otp.DigitsSix
+)
+
+// how long a login challenge may wait for its second factor
+const mfaChallengeTTL = 5 * time.Minute
+
+// audience of the challenge token login returns when a second factor is needed
+const mfaChallengeAudience = "mfa_challenge"
+
+// number of backup codes issued at a time, and the alphabet they are drawn from, minus look-alikes
+const (
+	backupCodeCount    = 10
+	backupCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+type totpEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type backupCodesResponse struct {
+	BackupCodes []string `json:"backup_codes"`
+}
+
+// returned by login instead of a token when the account has two-factor authentication enabled
+type mfaChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	Challenge   string `json:"challenge"`
+}
+
+type mfaLoginRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// the {id} route variable, only when it is the authenticated caller; writes the error response otherwise
+func requireSelf(w http.ResponseWriter, r *http.Request, action string) (int, bool) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return 0, false
+	}
+	if caller, _ := middleware.UserID(r.Context()); caller != id {
+		models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "you can only " + action + " for your own account"})
+		return 0, false
+	}
+	return id, true
+}
+
+func (a *App) decodeTOTPCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req totpCodeRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return "", false
+	}
+	if req.Code == "" {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "request is invalid", Fields: map[string]string{"code": "code is required"}})
+		return "", false
+	}
+	return req.Code, true
+}
+
+func writeInvalidCode(w http.ResponseWriter) {
+	models.WriteError(w, http.StatusUnauthorized, models.APIError{Code: models.ErrCodeUnauthorized, Message: "invalid authentication code", Fields: map[string]string{"code": "code is invalid or already used"}})
+}
+
+// accept a code for the current time step or the one either side of it, to allow for clock drift.
+// each step is accepted once, so a code seen over someone's shoulder can't be replayed
+func (a *App) checkTOTP(ctx context.Context, id int, secret, code string) (bool, error) {
+	now := time.Now().Unix() / totpPeriod
+	for _, step := range []int64{now, now - 1, now + 1} {
+		want, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{Period: totpPeriod, Digits: totpDigits, Algorithm: otp.AlgorithmSHA1})
+		if err != nil {
+			return false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return a.twoFactor.UseTOTPStep(ctx, id, step)
+		}
+	}
+	return false, nil
+}
+
+// accept either a TOTP code or one of the user's unused backup codes
+func (a *App) checkSecondFactor(ctx context.Context, id int, secret, code string) (bool, error) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) == totpDigits.Length() {
+		return a.checkTOTP(ctx, id, secret, code)
+	}
+	return a.twoFactor.UseBackupCode(ctx, id, hashBackupCode(code))
+}
+
+// backup codes are compared on lower case letters and digits, so dashes and case don't matter
+func hashBackupCode(code string) []byte {
+	code = strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
+
+// a fresh set of backup codes formatted as xxxxx-xxxxx, and their hashes for storage
+func newBackupCodes() ([]string, [][]byte) {
+	codes := make([]string, backupCodeCount)
+	hashes := make([][]byte, backupCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		rand.Read(b)
+		for j := range b {
+			b[j] = backupCodeAlphabet[int(b[j])%len(backupCodeAlphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		hashes[i] = hashBackupCode(codes[i])
+	}
+	return codes, hashes
+}
+
+// start enrolling a TOTP authenticator; it takes effect once a code from it is confirmed
+func (a *App) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	id, ok := requireSelf(w, r, "set up two-factor authentication")
+	if !ok {
+		return
+	}
+	u, err := a.users.Get(r.Context(), id, false)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if _, enabled, err := a.twoFactor.TOTP(r.Context(), id); err != nil {
+		writeInternalError(w, r, err)
+		return
+	} else if enabled {
+		models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "two-factor authentication is already enabled"})
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: u.Email, Period: totpPeriod, Digits: totpDigits})
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if err := a.twoFactor.SetTOTPSecret(r.Context(), id, key.Secret()); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, totpEnrollment{Secret: key.Secret(), OTPAuthURI: key.URL()})
+}
+
+// confirm enrollment with a code from the authenticator, turning two-factor authentication on
+// and returning the backup codes, which are never shown again
+func (a *App) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	id, ok := requireSelf(w, r, "set up two-factor authentication")
+	if !ok {
+		return
+	}
+	code, ok := a.decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+	secret, enabled, err := a.twoFactor.TOTP(r.Context(), id)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if enabled {
+		models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "two-factor authentication is already enabled"})
+		return
+	}
+	if secret == "" {
+		models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "enroll an authenticator before confirming it"})
+		return
+	}
+
+	if valid, err := a.checkTOTP(r.Context(), id, secret, code); err != nil {
+		writeInternalError(w, r, err)
+		return
+	} else if !valid {
+		writeInvalidCode(w)
+		return
+	}
+
+	codes, hashes := newBackupCodes()
+	if err := a.twoFactor.EnableTOTP(r.Context(), id, hashes); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, backupCodesResponse{BackupCodes: codes})
+}
+
+// replace the backup codes after checking a current TOTP code
+func (a *App) regenerateBackupCodes(w http.ResponseWriter, r *http.Request) {
+	id, ok := requireSelf(w, r, "manage backup codes")
+	if !ok {
+		return
+	}
+	code, ok := a.decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+	secret, ok := a.enabledTOTP(w, r, id)
+	if !ok {
+		return
+	}
+	if valid, err := a.checkTOTP(r.Context(), id, secret, code); err != nil {
+		writeInternalError(w, r, err)
+		return
+	} else if !valid {
+		writeInvalidCode(w)
+		return
+	}
+
+	codes, hashes := newBackupCodes()
+	if err := a.twoFactor.ReplaceBackupCodes(r.Context(), id, hashes); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, backupCodesResponse{BackupCodes: codes})
+}
+
+// turn two-factor authentication off after checking a TOTP or backup code
+func (a *App) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	id, ok := requireSelf(w, r, "turn off two-factor authentication")
+	if !ok {
+		return
+	}
+	code, ok := a.decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+	secret, ok := a.enabledTOTP(w, r, id)
+	if !ok {
+		return
+	}
+	if valid, err := a.checkSecondFactor(r.Context(), id, secret, code); err != nil {
+		writeInternalError(w, r, err)
+		return
+	} else if !valid {
+		writeInvalidCode(w)
+		return
+	}
+
+	if err := a.twoFactor.DisableTOTP(r.Context(), id); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// the secret of a user with two-factor authentication enabled; writes the error response otherwise
+func (a *App) enabledTOTP(w http.ResponseWriter, r *http.Request, id int) (string, bool) {
+	secret, enabled, err := a.twoFactor.TOTP(r.Context(), id)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return "", false
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return "", false
+	}
+	if !enabled {
+		models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "two-factor authentication is not enabled"})
+		return "", false
+	}
+	return secret, true
+}
+
+func (a *App) issueMFAChallenge(id int) (string, error) {
+	now := time.Now()
+	return a.signToken(jwt.RegisteredClaims{
+		Subject:   strconv.Itoa(id),
+		Audience:  jwt.ClaimStrings{mfaChallengeAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(mfaChallengeTTL)),
+	})
+}
+
+// finish a login that returned a challenge by supplying a TOTP or backup code
+func (a *App) loginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req mfaLoginRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	var claims jwt.RegisteredClaims
+	if err := a.parseToken(req.Challenge, mfaChallengeAudience, &claims); err != nil {
+		models.WriteError(w, http.StatusUnauthorized, models.APIError{Code: models.ErrCodeUnauthorized, Message: "login challenge is invalid or expired; log in again"})
+		return
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		models.WriteError(w, http.StatusUnauthorized, models.APIError{Code: models.ErrCodeUnauthorized, Message: "login challenge is invalid or expired; log in again"})
+		return
+	}
+
+	secret, enabled, err := a.twoFactor.TOTP(r.Context(), id)
+	if err != nil && err != store.ErrUserNotFound {
+		writeInternalError(w, r, err)
+		return
+	}
+	// a deleted account, or one that turned 2FA off mid-login, has to start over
+	if err == store.ErrUserNotFound || !enabled {
+		models.WriteError(w, http.StatusUnauthorized, models.APIError{Code: models.ErrCodeUnauthorized, Message: "login challenge is invalid or expired; log in again"})
+		return
+	}
+	u, err := a.users.Get(r.Context(), id, false)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	attempt := models.LoginEvent{UserId: &u.Id, Email: u.Email, Method: models.LoginMethodTwoFactor}
+	if a.lockedOut(w, r, attempt) {
+		return
+	}
+	if valid, err := a.checkSecondFactor(r.Context(), id, secret, req.Code); err != nil {
+		writeInternalError(w, r, err)
+		return
+	} else if !valid {
+		a.recordLogin(r, attempt, models.LoginFailedCode)
+		a.countFailedLogin(r, u.Id)
+		writeInvalidCode(w)
+		return
+	}
+
+	session, err := a.startSession(r, u)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	a.recordLogin(r, attempt, "")
+	writeBody(w, presentSession(r, session))
+}
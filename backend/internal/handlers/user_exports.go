@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/storage"
+	"api/internal/store"
+	"api/internal/tenant"
+)
+
+// jobKindUsersExport jobs generate one user export, see usersExportJob
+const jobKindUsersExport = "users.export"
+
+// how long a user export is kept, and how long each link to download it works
+const (
+	userExportTTL   = 24 * time.Hour
+	downloadLinkTTL = 15 * time.Minute
+)
+
+// users written between progress updates
+const exportProgressEvery = 1000
+
+// the payload of a jobKindUsersExport job
+type usersExportJob struct {
+	TenantId    int               `json:"tenant_id"`
+	ExportId    int               `json:"export_id"`
+	RequestedBy int               `json:"requested_by"`
+	Format      string            `json:"format"`
+	Filter      models.UserFilter `json:"filter"`
+}
+
+// a user export as its status endpoint reports it, with a link to download it once it is ready
+type userExportStatus struct {
+	models.UserExport
+	Download          string     `json:"download,omitempty"`
+	DownloadExpiresAt *time.Time `json:"download_expires_at,omitempty"`
+}
+
+// queue an export of the users matching the list filters as ?format=csv or json, answering with
+// where to poll it. the export is masked as the caller's role sees users
+func (a *App) startUsersExport(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = models.ExportCSV
+	}
+	if format != models.ExportCSV && format != models.ExportJSON {
+		fields["format"] = "format must be csv or json"
+	}
+	f := parseUserFilters(r, fields)
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: fields})
+		return
+	}
+
+	caller, _ := middleware.UserID(r.Context())
+	e, err := a.userExports.Create(r.Context(), caller, format, time.Now().Add(userExportTTL))
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	job, err := a.jobPool.Enqueue(r.Context(), jobKindUsersExport, usersExportJob{TenantId: tenant.ID(r.Context()), ExportId: e.Id, RequestedBy: caller, Format: format, Filter: f})
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if err := a.userExports.SetJob(r.Context(), e.Id, job); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	e.JobId = &job
+	w.Header().Set("Location", userExportURL(r, e.Id))
+	w.WriteHeader(http.StatusAccepted)
+	writeBody(w, userExportStatus{UserExport: e})
+}
+
+func userExportURL(r *http.Request, id int) string {
+	return routedAPI(r).prefix + "/exports/" + strconv.Itoa(id)
+}
+
+// how the caller's export is getting on, with a fresh download link once it is ready. a pending one
+// whose job ran out of attempts has failed
+func (a *App) getUsersExport(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeExportNotFound(w)
+		return
+	}
+	caller, _ := middleware.UserID(r.Context())
+	e, err := a.userExports.Get(r.Context(), caller, id)
+	if err == store.ErrExportNotFound {
+		writeExportNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if e.Status == models.ExportPending && e.JobId != nil && a.jobs != nil {
+		job, err := a.jobs.Get(r.Context(), *e.JobId)
+		if err != nil && err != store.ErrJobNotFound {
+			writeInternalError(w, r, err)
+			return
+		}
+		if err == store.ErrJobNotFound || job.Status == models.JobFailed {
+			e.Status = models.ExportFailed
+		}
+	}
+	status := userExportStatus{UserExport: e}
+	if e.Status == models.ExportReady {
+		expires := time.Now().Add(downloadLinkTTL).Truncate(time.Second)
+		if expires.After(e.ExpiresAt) {
+			expires = e.ExpiresAt.Truncate(time.Second)
+		}
+		tenantID := tenant.ID(r.Context())
+		q := url.Values{
+			"tenant":    {strconv.Itoa(tenantID)},
+			"expires":   {strconv.FormatInt(expires.Unix(), 10)},
+			"signature": {a.signDownload(tenantID, e.Id, expires.Unix())},
+		}
+		status.Download = userExportURL(r, e.Id) + "/download?" + q.Encode()
+		status.DownloadExpiresAt = &expires
+	}
+	writeBody(w, status)
+}
+
+// the signature of a link to download an export until expires, a Unix time
+func (a *App) signDownload(tenantID, id int, expires int64) string {
+	mac := hmac.New(sha256.New, a.tokenSecret)
+	fmt.Fprintf(mac, "user-export:%d:%d:%d", tenantID, id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// serve a ready export to whoever holds a link from its status, which grants access on its own so
+// browsers can follow it without a token
+func (a *App) downloadUsersExport(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	q := r.URL.Query()
+	tenantID, err := strconv.Atoi(q.Get("tenant"))
+	if err != nil {
+		ok = false
+	}
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		ok = false
+	}
+	if !ok || !hmac.Equal([]byte(q.Get("signature")), []byte(a.signDownload(tenantID, id, expires))) {
+		models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "download link is invalid or expired"})
+		return
+	}
+
+	ctx := tenant.WithID(r.Context(), tenantID)
+	format, key, err := a.userExports.Archive(ctx, id)
+	var file io.ReadCloser
+	if err == nil {
+		file, err = a.exportFiles.Open(ctx, key)
+	}
+	if err == store.ErrExportNotFound || err == storage.ErrNotFound {
+		writeExportNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", exportContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users-%d.%s"`, id, format))
+	w.Header().Set("Cache-Control", "no-store")
+	// the status is already sent should the file stop coming, so that is only logged
+	if _, err := io.Copy(w, file); err != nil {
+		slog.ErrorContext(ctx, "serve user export failed", "err", err, "export_id", id)
+	}
+}
+
+// where the file of a user export is kept in the export storage
+func userExportKey(tenantID, id int, format string) string {
+	return fmt.Sprintf("user-exports/%d/%d.%s", tenantID, id, format)
+}
+
+func exportContentType(format string) string {
+	if format == models.ExportJSON {
+		return "application/json"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// write the users a job was queued for straight to the export storage, noting progress as it goes,
+// in the tenant it was asked in. a retried job writes the same file over
+func (a *App) runUsersExport(ctx context.Context, payload []byte) error {
+	var job usersExportJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	ctx = tenant.WithID(ctx, job.TenantId)
+	requester, err := a.users.Get(ctx, job.RequestedBy, false)
+	if err == store.ErrUserNotFound {
+		// deleted since they asked; the export would go with them
+		return a.userExports.Fail(ctx, job.ExportId)
+	} else if err != nil {
+		return err
+	}
+	_, total, err := a.users.List(ctx, job.Filter, models.UserSort{}, 1, 0)
+	if err != nil {
+		return err
+	}
+	if err := a.userExports.Progress(ctx, job.ExportId, 0, total); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := a.writeUsersExport(ctx, job, requester, total, pw)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+	key := userExportKey(job.TenantId, job.ExportId, job.Format)
+	_, err = a.exportFiles.Put(ctx, key, pr, exportContentType(job.Format))
+	// stops the writer should the upload have given up early
+	pr.Close()
+	if werr := <-written; err == nil {
+		err = werr
+	}
+	if err != nil {
+		return err
+	}
+	return a.userExports.Finish(ctx, job.ExportId, key)
+}
+
+// write the users of an export to w as they are read, masked as the requester's role sees them
+func (a *App) writeUsersExport(ctx context.Context, job usersExportJob, requester models.User, total int, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	if job.Format == models.ExportCSV {
+		cw.Write(exportHeader)
+	} else {
+		bw.WriteString("[")
+	}
+	n := 0
+	err := a.users.Each(ctx, job.Filter, func(u models.User) error {
+		if len(a.maskedFields) > 0 && u.Id != requester.Id {
+			u = maskFields(u, a.maskedFields, requester.Role)
+		}
+		if job.Format == models.ExportCSV {
+			if err := cw.Write(exportRecord(u)); err != nil {
+				return err
+			}
+		} else {
+			if n > 0 {
+				bw.WriteString(",")
+			}
+			b, err := json.Marshal(u)
+			if err != nil {
+				return err
+			}
+			bw.WriteString("\n")
+			if _, err := bw.Write(b); err != nil {
+				return err
+			}
+		}
+		if n++; n%exportProgressEvery == 0 {
+			return a.userExports.Progress(ctx, job.ExportId, n, total)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if job.Format == models.ExportCSV {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	} else {
+		bw.WriteString("\n]\n")
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return a.userExports.Progress(ctx, job.ExportId, n, total)
+}
+
+// how many expired exports are removed at a time
+const expiredExportsBatch = 100
+
+// DeleteExpiredUserExports removes the user exports of every tenant that have expired, files first,
+// returning how many it removed; a maintenance.Task. an export whose file can't be deleted is kept
+// for the next run.
+func (a *App) DeleteExpiredUserExports(ctx context.Context) (int64, error) {
+	if a.userExports == nil {
+		return 0, nil
+	}
+	var deleted int64
+	for {
+		expired, err := a.userExports.Expired(ctx, expiredExportsBatch)
+		if err != nil || len(expired) == 0 {
+			return deleted, err
+		}
+		var ids []int
+		var failed error
+		for id, key := range expired {
+			if key != "" {
+				if err := a.exportFiles.Delete(ctx, key); err != nil {
+					failed = err
+					continue
+				}
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) > 0 {
+			if err := a.userExports.Delete(ctx, ids); err != nil {
+				return deleted, err
+			}
+			deleted += int64(len(ids))
+		}
+		// the files still there are tried again next time rather than over and over now
+		if failed != nil {
+			return deleted, failed
+		}
+	}
+}
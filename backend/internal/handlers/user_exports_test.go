@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"api/internal/models"
+	"api/internal/storage"
+	"api/internal/store"
+	"api/internal/tenant"
+)
+
+// user exports kept in memory, of one tenant
+type memoryUserExports struct {
+	mu      sync.Mutex
+	exports map[int]*models.UserExport
+	keys    map[int]string
+}
+
+var _ store.UserExports = (*memoryUserExports)(nil)
+
+func newMemoryUserExports() *memoryUserExports {
+	return &memoryUserExports{exports: map[int]*models.UserExport{}, keys: map[int]string{}}
+}
+
+func (s *memoryUserExports) Create(ctx context.Context, requestedBy int, format string, expiresAt time.Time) (models.UserExport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := models.UserExport{Id: len(s.exports) + 1, RequestedBy: requestedBy, Format: format, Status: models.ExportPending, CreatedAt: time.Now(), ExpiresAt: expiresAt}
+	s.exports[e.Id] = &e
+	return e, nil
+}
+
+func (s *memoryUserExports) SetJob(ctx context.Context, id int, jobID int64) error { return nil }
+
+func (s *memoryUserExports) Get(ctx context.Context, requestedBy, id int) (models.UserExport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.exports[id]; ok && e.RequestedBy == requestedBy && e.ExpiresAt.After(time.Now()) {
+		return *e, nil
+	}
+	return models.UserExport{}, store.ErrExportNotFound
+}
+
+func (s *memoryUserExports) Progress(ctx context.Context, id, exported, total int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exports[id].Exported, s.exports[id].Total = exported, total
+	return nil
+}
+
+func (s *memoryUserExports) Archive(ctx context.Context, id int) (string, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.exports[id]; ok && e.Status == models.ExportReady && e.ExpiresAt.After(time.Now()) {
+		return e.Format, s.keys[id], nil
+	}
+	return "", "", store.ErrExportNotFound
+}
+
+func (s *memoryUserExports) Finish(ctx context.Context, id int, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exports[id].Status, s.keys[id] = models.ExportReady, key
+	return nil
+}
+
+func (s *memoryUserExports) Fail(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exports[id].Status = models.ExportFailed
+	return nil
+}
+
+func (s *memoryUserExports) Expired(ctx context.Context, limit int) (map[int]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := map[int]string{}
+	for id, e := range s.exports {
+		if !e.ExpiresAt.After(time.Now()) && len(expired) < limit {
+			expired[id] = s.keys[id]
+		}
+	}
+	return expired, nil
+}
+
+func (s *memoryUserExports) Delete(ctx context.Context, ids []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.exports, id)
+		delete(s.keys, id)
+	}
+	return nil
+}
+
+func TestUserExportFile(t *testing.T) {
+	app, _ := newTestApp(t, Options{MaskedFields: map[string][]string{"email": nil}})
+	dir := t.TempDir()
+	files, err := storage.NewLocal(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exports := newMemoryUserExports()
+	// set up here rather than through Options, which would need a job pool to run the job
+	app.userExports, app.exportFiles = exports, files
+	h := app.Router()
+	token := register(t, h, "Ada Lovelace", "ada@example.com")
+	if w := do(t, h, "POST", "/api/v1/users", token, map[string]string{"name": "Grace Hopper", "email": "grace@example.com"}); w.Code != http.StatusOK {
+		t.Fatalf("create: status %d, body %s", w.Code, w.Body)
+	}
+
+	ctx := tenant.WithID(context.Background(), tenant.DefaultID)
+	e, err := exports.Create(ctx, 1, models.ExportCSV, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, _ := json.Marshal(usersExportJob{TenantId: tenant.DefaultID, ExportId: e.Id, RequestedBy: 1, Format: models.ExportCSV})
+	if err := app.runUsersExport(ctx, job); err != nil {
+		t.Fatalf("run export: %v", err)
+	}
+	path := filepath.Join(dir, "user-exports", strconv.Itoa(tenant.DefaultID), strconv.Itoa(e.Id)+".csv")
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("export file: %v", err)
+	}
+	if !strings.Contains(string(written), "ada@example.com") || !strings.Contains(string(written), "g***@example.com") {
+		t.Errorf("export file = %q, want the requester in full and grace masked", written)
+	}
+
+	w := do(t, h, "GET", "/api/v1/exports/"+strconv.Itoa(e.Id), token, nil)
+	var status userExportStatus
+	decode(t, w, &status)
+	if status.Status != models.ExportReady || status.Exported != 2 || status.Download == "" {
+		t.Fatalf("status = %+v, want ready with 2 users and a download link", status)
+	}
+	link, err := url.Parse(status.Download)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w = do(t, h, "GET", link.RequestURI(), "", nil); w.Code != http.StatusOK || w.Body.String() != string(written) {
+		t.Errorf("download: status %d, body %q; want the export file", w.Code, w.Body)
+	}
+
+	// once expired the clean-up takes the file and the export
+	exports.exports[e.Id].ExpiresAt = time.Now().Add(-time.Second)
+	if n, err := app.DeleteExpiredUserExports(ctx); err != nil || n != 1 {
+		t.Fatalf("DeleteExpiredUserExports = %d, %v; want 1", n, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("export file still there after the clean-up: %v", err)
+	}
+	if _, err := exports.Get(ctx, 1, e.Id); err != store.ErrExportNotFound {
+		t.Errorf("export after the clean-up: %v, want ErrExportNotFound", err)
+	}
+}
@@ -0,0 +1,810 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/models"
+	"api/internal/store"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// body accepted by createUser: a user plus an optional initial password
+type newUserRequest struct {
+	models.User
+	Password string `json:"password"`
+}
+
+// trim a name and collapse runs of whitespace, title-casing it when enabled
+func (a *App) normalizeName(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	if a.normalizeNames {
+		name = cases.Title(language.Und).String(name)
+	}
+	return name
+}
+
+// emails are compared case-insensitively, so they are stored trimmed and lowercased
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// trim the optional profile fields, leaving those sent empty unset, and lowercase the username
+func normalizeProfile(u *models.User) {
+	u.Username = strings.ToLower(strings.TrimSpace(u.Username))
+	for _, field := range []**string{&u.Phone, &u.Bio, &u.Timezone, &u.Locale} {
+		if *field == nil {
+			continue
+		}
+		if s := strings.TrimSpace(**field); s != "" {
+			*field = &s
+		} else {
+			*field = nil
+		}
+	}
+}
+
+// check a user against the rules declared on models.User, keyed by field
+func validateUser(u models.User) map[string]string {
+	return validationErrors(u)
+}
+
+// decode and validate a user from the request body, writing the error response on failure
+func (a *App) decodeUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	u, ok := a.decodeVersionedUser(w, r)
+	if !ok {
+		return u, false
+	}
+	u.Name = a.normalizeName(u.Name)
+	u.Email = normalizeEmail(u.Email)
+	normalizeProfile(&u)
+	if fields := validateUser(u); len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "user is invalid", Fields: presentFields(r, fields)})
+		return u, false
+	}
+	return u, true
+}
+
+// normalize the user and collect validation problems, including the optional password
+func (a *App) validateNewUser(req newUserRequest) (models.User, map[string]string) {
+	u := req.User
+	u.Name = a.normalizeName(u.Name)
+	u.Email = normalizeEmail(u.Email)
+	normalizeProfile(&u)
+	fields := validateUser(u)
+	if req.Password != "" {
+		if msg := a.passwordPolicy.validate(req.Password, u.Email); msg != "" {
+			fields["password"] = msg
+		}
+	}
+	return u, fields
+}
+
+// page size defaults and bounds for getUsers
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// one page of a collection along with the total number of matching items
+type page struct {
+	Total int             `json:"total"`
+	Page  int             `json:"page"`
+	Limit int             `json:"limit"`
+	Items interface{}     `json:"items"` // the users, as presented by the API version
+	Links map[string]link `json:"_links"`
+}
+
+// parse ?limit= and ?offset=, collecting problems into fields
+func parsePagination(r *http.Request, fields map[string]string) (limit, offset int) {
+	limit, offset = defaultPageLimit, 0
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			fields["limit"] = fmt.Sprintf("limit must be between 1 and %d", maxPageLimit)
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			fields["offset"] = "offset must be a non-negative integer"
+		}
+		offset = n
+	}
+	return limit, offset
+}
+
+// a page of users fetched by keyset pagination
+type cursorPage struct {
+	Items      interface{}     `json:"items"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+	Links      map[string]link `json:"_links"`
+}
+
+func encodeCursor(c models.UserCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (models.UserCursor, error) {
+	var c models.UserCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// accept either a full RFC 3339 timestamp or a plain date
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// report whether ?include_deleted=true asks for soft-deleted users too
+func includeDeleted(r *http.Request) bool {
+	return r.URL.Query().Get("include_deleted") == "true"
+}
+
+// parse an optional timestamp parameter, recording a field error if it is malformed
+func parseTimeParam(v, name string, fields map[string]string) *time.Time {
+	if v == "" {
+		return nil
+	}
+	t, err := parseTime(v)
+	if err != nil {
+		fields[name] = name + " must be an RFC 3339 timestamp or YYYY-MM-DD date"
+		return nil
+	}
+	return &t
+}
+
+// build the filter from ?email_contains=, ?created_after=, ?created_before=, ?tag= and ?include_deleted=
+func parseUserFilters(r *http.Request, fields map[string]string) models.UserFilter {
+	params := r.URL.Query()
+	f := models.UserFilter{
+		EmailContains:  params.Get("email_contains"),
+		CreatedAfter:   parseTimeParam(params.Get("created_after"), "created_after", fields),
+		CreatedBefore:  parseTimeParam(params.Get("created_before"), "created_before", fields),
+		IncludeDeleted: includeDeleted(r),
+	}
+	if params.Has("tag") {
+		var msg string
+		if f.Tag, msg = normalizeTag(params.Get("tag")); msg != "" {
+			fields["tag"] = msg
+		}
+	}
+	return f
+}
+
+// resolve ?sort= against the whitelist and ?order= to ascending or descending
+func parseSort(r *http.Request, fields map[string]string) models.UserSort {
+	params := r.URL.Query()
+	return resolveSort(params.Get("sort"), params.Get("order"), fields)
+}
+
+// check a requested sort key and order against the whitelist, defaulting to id ascending
+func resolveSort(sort, order string, fields map[string]string) models.UserSort {
+	s := models.UserSort{Field: sort}
+	if sort != "" && !slices.Contains(models.SortableUserFields, sort) {
+		fields["sort"] = "sort must be one of " + strings.Join(models.SortableUserFields, ", ")
+	}
+	switch order {
+	case "", "asc":
+	case "desc":
+		s.Desc = true
+	default:
+		fields["order"] = "order must be asc or desc"
+	}
+	return s
+}
+
+// get all users, one page at a time; ?cursor= switches to keyset pagination and ?fields= trims each user to the members listed
+func (a *App) getUsers(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	limit, offset := parsePagination(r, fields)
+	f := parseUserFilters(r, fields)
+	f.Fields = parseFieldset(r, fields).columns
+	sort := parseSort(r, fields)
+	cursorMode := r.URL.Query().Has("cursor")
+	if cursorMode && sort.Field != "" && sort.Field != "created_at" {
+		fields["sort"] = "cursor pagination only supports sort=created_at"
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: fields})
+		return
+	}
+
+	if cursorMode {
+		a.getUsersByCursor(w, r, f, sort.Desc, limit)
+		return
+	}
+
+	users, total, err := a.users.List(r.Context(), f, sort, limit, offset)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeWithETag(w, r, presentPage(r, users, total, limit, offset))
+}
+
+// serve the page after ?cursor=, or the first page when the cursor is empty
+func (a *App) getUsersByCursor(w http.ResponseWriter, r *http.Request, f models.UserFilter, desc bool, limit int) {
+	var after *models.UserCursor
+	if raw := r.URL.Query().Get("cursor"); raw != "" {
+		c, err := decodeCursor(raw)
+		if err != nil {
+			models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid cursor", Fields: map[string]string{"cursor": "cursor is malformed"}})
+			return
+		}
+		after = &c
+	}
+
+	users, err := a.users.ListAfter(r.Context(), f, after, desc, limit+1)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// one extra row was fetched to learn whether another page exists
+	var nextCursor string
+	if len(users) > limit {
+		users = users[:limit]
+		last := users[limit-1]
+		nextCursor = encodeCursor(models.UserCursor{CreatedAt: last.CreatedAt, Id: last.Id})
+	}
+
+	writeWithETag(w, r, presentCursorPage(r, users, nextCursor))
+}
+
+// get user by id
+func (a *App) getUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+
+	u, err := a.users.Get(r.Context(), id, includeDeleted(r))
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if writeNotModified(w, r, userETag(u), u.UpdatedAt) {
+		return
+	}
+	writeBody(w, presentUser(r, u))
+}
+
+// look up a live user by email, in any case
+func (a *App) getUserByEmail(w http.ResponseWriter, r *http.Request) {
+	u, err := a.users.GetByEmail(r.Context(), normalizeEmail(mux.Vars(r)["email"]))
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if writeNotModified(w, r, userETag(u), u.UpdatedAt) {
+		return
+	}
+	writeBody(w, presentUser(r, u))
+}
+
+// look up a live user by username, for profile URLs such as /u/jane-doe
+func (a *App) getUserByUsername(w http.ResponseWriter, r *http.Request) {
+	u, err := a.users.GetByUsername(r.Context(), strings.ToLower(mux.Vars(r)["username"]))
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if writeNotModified(w, r, userETag(u), u.UpdatedAt) {
+		return
+	}
+	writeBody(w, presentUser(r, u))
+}
+
+// counts of live users for dashboards, so they needn't page through the list to show a number
+func (a *App) getUserStats(w http.ResponseWriter, r *http.Request) {
+	st, err := a.users.Stats(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeWithETag(w, r, st)
+}
+
+// the most buckets an aggregate may span, keeping a mistyped range from producing years of days
+const maxSignupBuckets = 1000
+
+// how far back an aggregate reaches when ?from= is left out
+var defaultSignupRange = map[string]func(time.Time) time.Time{
+	"day":   func(t time.Time) time.Time { return t.AddDate(0, 0, -29) },
+	"week":  func(t time.Time) time.Time { return t.AddDate(0, 0, -7*11) },
+	"month": func(t time.Time) time.Time { return t.AddDate(0, -11, 0) },
+}
+
+type signupAggregate struct {
+	GroupBy string                `json:"group_by"`
+	From    time.Time             `json:"from"`
+	To      time.Time             `json:"to"`
+	Buckets []models.SignupBucket `json:"buckets"`
+}
+
+// signups bucketed by ?group_by= day, week or month between ?from= and ?to=, for growth charts.
+// to defaults to now and from to 30 days, 12 weeks or 12 months of buckets before it
+func (a *App) getSignupAggregate(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	fields := map[string]string{}
+	unit := params.Get("group_by")
+	if unit == "" {
+		unit = "day"
+	} else if !slices.Contains(models.SignupGroupings, unit) {
+		fields["group_by"] = "group_by must be one of " + strings.Join(models.SignupGroupings, ", ")
+	}
+	to := time.Now().UTC().Truncate(time.Second)
+	if t := parseTimeParam(params.Get("to"), "to", fields); t != nil {
+		to = t.UTC()
+	}
+	from := to
+	if t := parseTimeParam(params.Get("from"), "from", fields); t != nil {
+		from = t.UTC()
+	} else if back, ok := defaultSignupRange[unit]; ok {
+		from = back(to)
+	}
+	if len(fields) == 0 && from.After(to) {
+		fields["from"] = "from must not be after to"
+	} else if len(fields) == 0 && signupBuckets(unit, from, to) > maxSignupBuckets {
+		fields["from"] = "from and to may be at most " + strconv.Itoa(maxSignupBuckets) + " " + unit + "s apart"
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: fields})
+		return
+	}
+
+	buckets, err := a.users.Signups(r.Context(), unit, from, to, includeDeleted(r))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeWithETag(w, r, signupAggregate{GroupBy: unit, From: from, To: to, Buckets: buckets})
+}
+
+// roughly how many buckets of unit the range from through to touches, erring high
+func signupBuckets(unit string, from, to time.Time) int {
+	days := int(to.Sub(from).Hours()/24) + 1
+	switch unit {
+	case "week":
+		return days/7 + 2
+	case "month":
+		return (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month()) + 1
+	}
+	return days + 1
+}
+
+// create user
+func (a *App) createUser(w http.ResponseWriter, r *http.Request) {
+	req, ok := a.decodeNewUser(w, r)
+	if !ok {
+		return
+	}
+	u, fields := a.validateNewUser(req)
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "user is invalid", Fields: presentFields(r, fields)})
+		return
+	}
+
+	var err error
+	u.PasswordHash, err = optionalPasswordHash(req.Password)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	u, err = a.users.Create(r.Context(), u)
+	if err == store.ErrEmailTaken {
+		writeEmailTaken(w)
+		return
+	} else if err == store.ErrUsernameTaken {
+		writeUsernameTaken(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	a.userCreated(r.Context(), u)
+
+	setUserETag(w, u)
+	writeBody(w, presentUser(r, u))
+}
+
+// create a user, or update the one that already has the email, for syncs from another system of
+// record. answers 201 when it created the user and 200 when it updated them; a password only
+// applies to a user it creates
+func (a *App) upsertUser(w http.ResponseWriter, r *http.Request) {
+	req, ok := a.decodeNewUser(w, r)
+	if !ok {
+		return
+	}
+	u, fields := a.validateNewUser(req)
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "user is invalid", Fields: presentFields(r, fields)})
+		return
+	}
+
+	var err error
+	u.PasswordHash, err = optionalPasswordHash(req.Password)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	u, created, err := a.users.Upsert(r.Context(), u)
+	if err == store.ErrEmailTaken {
+		writeEmailTaken(w)
+		return
+	} else if err == store.ErrUsernameTaken {
+		writeUsernameTaken(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	setUserETag(w, u)
+	if created {
+		a.userCreated(r.Context(), u)
+		w.Header().Set("Location", routedAPI(r).prefix+"/users/"+strconv.Itoa(u.Id))
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		a.feed.publish(r.Context(), models.EventUserUpdated, u)
+	}
+	writeBody(w, presentUser(r, u))
+}
+
+// largest number of users accepted by a single batch request
+const maxBatchSize = 1000
+
+// outcome for one item of a batch, matched to the request by index
+type batchItemResult struct {
+	Index int              `json:"index"`
+	Id    int              `json:"id,omitempty"`
+	Error *models.APIError `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	Results []batchItemResult `json:"results"`
+}
+
+// the emails used by more than one item, keyed "<index>.email" for each item using one, with
+// the indices sharing it
+func duplicateEmails(emails []string) map[string]string {
+	indices := map[string][]int{}
+	for i, email := range emails {
+		if email != "" {
+			indices[email] = append(indices[email], i)
+		}
+	}
+	fields := map[string]string{}
+	for _, same := range indices {
+		if len(same) < 2 {
+			continue
+		}
+		list := make([]string, len(same))
+		for j, i := range same {
+			list[j] = strconv.Itoa(i)
+		}
+		for _, i := range same {
+			fields[strconv.Itoa(i)+".email"] = "email is repeated at indices " + strings.Join(list, ", ")
+		}
+	}
+	return fields
+}
+
+// create many users in one transaction, reporting invalid items instead of failing the batch
+func (a *App) createUsersBatch(w http.ResponseWriter, r *http.Request) {
+	reqs, ok := a.decodeNewUsers(w, r)
+	if !ok {
+		return
+	}
+	if len(reqs) == 0 || len(reqs) > maxBatchSize {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: fmt.Sprintf("batch must contain between 1 and %d users", maxBatchSize)})
+		return
+	}
+
+	// an email given more than once can't be put down to any one item, so the batch is refused
+	// before anything is looked up, naming every index that uses it
+	emails := make([]string, len(reqs))
+	for i, req := range reqs {
+		emails[i] = normalizeEmail(req.Email)
+	}
+	if fields := duplicateEmails(emails); len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "batch repeats emails", Fields: fields})
+		return
+	}
+	// emails another user has fail their item rather than the batch
+	existing, err := a.users.ExistingEmails(r.Context(), emails)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	resp := batchResponse{Results: make([]batchItemResult, len(reqs))}
+	var valid []int
+	var users []models.User
+	for i, req := range reqs {
+		resp.Results[i].Index = i
+		u, fields := a.validateNewUser(req)
+		if len(fields) > 0 {
+			resp.Results[i].Error = &models.APIError{Code: models.ErrCodeValidationFailed, Message: "user is invalid", Fields: presentFields(r, fields)}
+			resp.Failed++
+			continue
+		}
+		if existing[u.Email] {
+			taken := errEmailTaken
+			resp.Results[i].Error = &taken
+			resp.Failed++
+			continue
+		}
+
+		u.PasswordHash, err = optionalPasswordHash(req.Password)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		valid = append(valid, i)
+		users = append(users, u)
+	}
+
+	// an email claimed since the check above fails the whole transaction
+	created, err := a.users.CreateMany(r.Context(), users)
+	if err == store.ErrEmailTaken {
+		writeEmailTaken(w)
+		return
+	} else if err == store.ErrUsernameTaken {
+		writeUsernameTaken(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for j, u := range created {
+		resp.Results[valid[j]].Id = u.Id
+		resp.Created++
+		a.userCreated(r.Context(), u)
+	}
+
+	writeBody(w, resp)
+}
+
+// update user
+func (a *App) updateUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := a.decodeUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	version, fromHeader, ok := expectedVersion(w, r, u.Version)
+	if !ok {
+		return
+	}
+
+	// Execute the update query, getting the updated user data back
+	updatedUser, err := a.users.Update(r.Context(), id, u, version)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err == store.ErrVersionConflict {
+		writeVersionConflict(w, fromHeader)
+		return
+	} else if err == store.ErrEmailTaken {
+		writeEmailTaken(w)
+		return
+	} else if err == store.ErrUsernameTaken {
+		writeUsernameTaken(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	a.feed.publish(r.Context(), models.EventUserUpdated, updatedUser)
+
+	// Send the updated user data in the response
+	setUserETag(w, updatedUser)
+	writeBody(w, presentUser(r, updatedUser))
+}
+
+// delete user
+func (a *App) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+
+	// soft delete: the row stays so it can be restored
+	u, err := a.users.Delete(r.Context(), id)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	a.feed.publish(r.Context(), models.EventUserDeleted, u)
+
+	writeBody(w, "User deleted")
+}
+
+// criteria selecting the users removed by a bulk delete
+type bulkDeleteFilter struct {
+	Ids           []int64 `json:"ids"`
+	CreatedBefore string  `json:"created_before"`
+}
+
+type bulkDeleteResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// soft delete every user matching the filter; requires ?confirm=true
+func (a *App) deleteUsers(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("confirm") != "true" {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "bulk delete requires ?confirm=true", Fields: map[string]string{"confirm": "must be true"}})
+		return
+	}
+
+	var f bulkDeleteFilter
+	if !a.decodeBody(w, r, &f, "request body must be a valid JSON filter") {
+		return
+	}
+
+	// an empty filter would match everyone, so at least one criterion is required
+	fields := map[string]string{}
+	createdBefore := parseTimeParam(f.CreatedBefore, "created_before", fields)
+	if len(f.Ids) == 0 && f.CreatedBefore == "" {
+		fields["filter"] = "provide ids or created_before"
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "bulk delete filter is invalid", Fields: fields})
+		return
+	}
+
+	deleted, err := a.users.DeleteMatching(r.Context(), f.Ids, createdBefore)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for _, u := range deleted {
+		a.feed.publish(r.Context(), models.EventUserDeleted, u)
+	}
+
+	writeBody(w, bulkDeleteResponse{Deleted: int64(len(deleted))})
+}
+
+// restore a soft-deleted user
+func (a *App) restoreUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "no deleted user with that id"})
+		return
+	}
+
+	u, err := a.users.Restore(r.Context(), id)
+	if err == store.ErrUserNotFound {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "no deleted user with that id"})
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	a.feed.publish(r.Context(), models.EventUserUpdated, u)
+
+	writeBody(w, presentUser(r, u))
+}
+
+// erase a user's personal data for good, unlike a delete, which can be restored. requires
+// ?confirm=true. the user's posts and memberships stay, shown under the placeholder name
+func (a *App) eraseUser(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("confirm") != "true" {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "erasing a user requires ?confirm=true", Fields: map[string]string{"confirm": "must be true"}})
+		return
+	}
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+
+	u, err := a.users.Erase(r.Context(), id)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	// the uploaded avatar is a photo of them too
+	if a.avatars != nil {
+		if err := a.avatars.Delete(r.Context(), "avatars/"+strconv.Itoa(id)); err != nil {
+			slog.ErrorContext(r.Context(), "delete erased user's avatar failed", "err", err, "user_id", id)
+		}
+	}
+	a.feed.publish(r.Context(), models.EventUserDeleted, u)
+
+	writeBody(w, presentUser(r, u))
+}
+
+type mergeRequest struct {
+	SourceId int `json:"source_id"`
+}
+
+// fold a duplicate account, the source, into the user: see UserRepository.Merge for what moves
+func (a *App) mergeUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	var req mergeRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	if req.SourceId <= 0 || req.SourceId == id {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "merge is invalid", Fields: map[string]string{"source_id": "source_id must be another user"}})
+		return
+	}
+
+	u, err := a.users.Merge(r.Context(), id, req.SourceId)
+	switch err {
+	case nil:
+	case store.ErrUserNotFound:
+		writeNotFound(w)
+		return
+	case store.ErrMergeSourceNotFound:
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "merge is invalid", Fields: map[string]string{"source_id": "no live user has this id"}})
+		return
+	default:
+		writeInternalError(w, r, err)
+		return
+	}
+	if source, err := a.users.Get(r.Context(), req.SourceId, true); err == nil {
+		a.feed.publish(r.Context(), models.EventUserDeleted, source)
+	}
+	a.feed.publish(r.Context(), models.EventUserUpdated, u)
+
+	writeBody(w, presentUser(r, u))
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+
+	"api/internal/models"
+)
+
+func TestCreateUserValidationFailure(t *testing.T) {
+	app, _ := newTestApp(t, Options{})
+	h := app.Router()
+	token := register(t, h, "Ada Lovelace", "ada@example.com")
+
+	w := do(t, h, "POST", "/api/v1/users", token, map[string]string{"name": " ", "email": "not-an-email"})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != models.ProblemContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, models.ProblemContentType)
+	}
+	var p testProblem
+	decode(t, w, &p)
+	if p.Status != http.StatusUnprocessableEntity || p.Code != models.ErrCodeValidationFailed {
+		t.Errorf("problem = %+v, want status 422 and code %s", p, models.ErrCodeValidationFailed)
+	}
+	for _, field := range []string{"name", "email"} {
+		if p.Fields[field] == "" {
+			t.Errorf("fields = %v, want a message for %s", p.Fields, field)
+		}
+	}
+}
+
+func TestUserNotFound(t *testing.T) {
+	app, _ := newTestApp(t, Options{})
+	h := app.Router()
+	token := register(t, h, "Ada Lovelace", "ada@example.com")
+
+	for _, method := range []string{"GET", "DELETE"} {
+		w := do(t, h, method, "/api/v1/users/999", token, nil)
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("%s: status = %d, want %d; body %s", method, w.Code, http.StatusNotFound, w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != models.ProblemContentType {
+			t.Errorf("%s: Content-Type = %q, want %q", method, ct, models.ProblemContentType)
+		}
+		var p testProblem
+		decode(t, w, &p)
+		if p.Status != http.StatusNotFound || p.Code != models.ErrCodeNotFound || p.Detail != "user not found" {
+			t.Errorf("%s: problem = %+v, want a user not found problem", method, p)
+		}
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, want string
+		titleCase      bool
+	}{
+		{"trims and collapses whitespace", "  ada \t lovelace\n", "ada lovelace", false},
+		{"keeps the case without the flag", "ADA   de LOVELACE", "ADA de LOVELACE", false},
+		{"title-cases with the flag", "  ada   LOVELACE ", "Ada Lovelace", true},
+		{"title-cases every word", "jean-luc\tpicard", "Jean-Luc Picard", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app, _ := newTestApp(t, Options{NormalizeNames: tc.titleCase})
+			if got := app.normalizeName(tc.in); got != tc.want {
+				t.Errorf("normalizeName(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCreateUserNormalizesName(t *testing.T) {
+	for _, tc := range []struct {
+		titleCase bool
+		want      string
+	}{
+		{false, "grace HOPPER"},
+		{true, "Grace Hopper"},
+	} {
+		app, _ := newTestApp(t, Options{NormalizeNames: tc.titleCase})
+		h := app.Router()
+		token := register(t, h, "Ada Lovelace", "ada@example.com")
+
+		w := do(t, h, "POST", "/api/v1/users", token, map[string]string{"name": "  grace   HOPPER ", "email": " Grace@Example.com "})
+		if w.Code != http.StatusOK {
+			t.Fatalf("title case %v: status = %d, want %d; body %s", tc.titleCase, w.Code, http.StatusOK, w.Body)
+		}
+		var u models.User
+		decode(t, w, &u)
+		if u.Name != tc.want || u.Email != "grace@example.com" {
+			t.Errorf("title case %v: created %q <%s>, want %q <grace@example.com>", tc.titleCase, u.Name, u.Email, tc.want)
+		}
+	}
+}
+
+func TestCreateUsersBatchRepeatedEmail(t *testing.T) {
+	app, users := newTestApp(t, Options{})
+	h := app.Router()
+	token := register(t, h, "Ada Lovelace", "ada@example.com")
+
+	w := do(t, h, "POST", "/api/v1/users/batch", token, []map[string]string{
+		{"name": "Grace Hopper", "email": "grace@example.com"},
+		{"name": "Alan Turing", "email": "alan@example.com"},
+		{"name": "Grace B. Hopper", "email": " Grace@Example.com "},
+	})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+	}
+	var p testProblem
+	decode(t, w, &p)
+	want := map[string]string{"0.email": "email is repeated at indices 0, 2", "2.email": "email is repeated at indices 0, 2"}
+	if p.Code != models.ErrCodeValidationFailed || len(p.Fields) != len(want) || p.Fields["0.email"] != want["0.email"] || p.Fields["2.email"] != want["2.email"] {
+		t.Errorf("problem = %+v, want fields %v", p, want)
+	}
+	// nothing of the batch is created
+	if exists, _ := users.EmailExists(context.Background(), "alan@example.com"); exists {
+		t.Error("alan@example.com was created from a refused batch")
+	}
+}
+
+func TestCreateUsersBatchExistingEmail(t *testing.T) {
+	app, _ := newTestApp(t, Options{})
+	h := app.Router()
+	token := register(t, h, "Ada Lovelace", "ada@example.com")
+
+	w := do(t, h, "POST", "/api/v1/users/batch", token, []map[string]string{
+		{"name": "Grace Hopper", "email": "grace@example.com"},
+		{"name": "Ada King", "email": "ADA@example.com"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusOK, w.Body)
+	}
+	var resp batchResponse
+	decode(t, w, &resp)
+	if resp.Created != 1 || resp.Failed != 1 || len(resp.Results) != 2 {
+		t.Fatalf("response = %+v, want one created and one failed", resp)
+	}
+	if r := resp.Results[0]; r.Index != 0 || r.Id == 0 || r.Error != nil {
+		t.Errorf("result 0 = %+v, want created", r)
+	}
+	if r := resp.Results[1]; r.Index != 1 || r.Id != 0 || r.Error == nil || r.Error.Code != models.ErrCodeConflict || r.Error.Fields["email"] == "" {
+		t.Errorf("result 1 = %+v, want an email conflict", r)
+	}
+}
+
+func TestSoftDeletedUsers(t *testing.T) {
+	app, _ := newTestApp(t, Options{})
+	h := app.Router()
+	admin := register(t, h, "Ada Lovelace", "ada@example.com")
+	member := register(t, h, "Grace Hopper", "grace@example.com")
+	w := do(t, h, "POST", "/api/v1/users", admin, map[string]string{"name": "Alan Turing", "email": "alan@example.com"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: status %d, body %s", w.Code, w.Body)
+	}
+	var alan models.User
+	decode(t, w, &alan)
+	if w := do(t, h, "DELETE", "/api/v1/users/"+strconv.Itoa(alan.Id), admin, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete: status %d, body %s", w.Code, w.Body)
+	}
+	user := "/api/v1/users/" + strconv.Itoa(alan.Id)
+
+	// the ids listed at path, as token sees them
+	listed := func(token, path string) []int {
+		t.Helper()
+		w := do(t, h, "GET", path, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d, body %s", path, w.Code, w.Body)
+		}
+		var p struct{ Items []models.User }
+		decode(t, w, &p)
+		var ids []int
+		for _, u := range p.Items {
+			ids = append(ids, u.Id)
+		}
+		return ids
+	}
+
+	// hidden from everyone unless asked for
+	for _, token := range []string{admin, member} {
+		if ids := listed(token, "/api/v1/users"); slices.Contains(ids, alan.Id) {
+			t.Errorf("list = %v, want deleted user %d left out", ids, alan.Id)
+		}
+		if w := do(t, h, "GET", user, token, nil); w.Code != http.StatusNotFound {
+			t.Errorf("get deleted user: status %d, want %d", w.Code, http.StatusNotFound)
+		}
+	}
+
+	// shown with include_deleted to those who may delete and restore users
+	if ids := listed(admin, "/api/v1/users?include_deleted=true"); !slices.Contains(ids, alan.Id) {
+		t.Errorf("list with include_deleted = %v, want deleted user %d", ids, alan.Id)
+	}
+	w = do(t, h, "GET", user+"?include_deleted=true", admin, nil)
+	var got models.User
+	decode(t, w, &got)
+	if w.Code != http.StatusOK || got.Id != alan.Id || got.DeletedAt == nil {
+		t.Errorf("get with include_deleted: status %d, user %+v; want the deleted user", w.Code, got)
+	}
+
+	// and refused to anyone else
+	for _, path := range []string{"/api/v1/users?include_deleted=true", user + "?include_deleted=true", "/api/v1/users/aggregate?include_deleted=true", "/api/v1/users/export?include_deleted=true"} {
+		w := do(t, h, "GET", path, member, nil)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("GET %s as a member: status %d, want %d", path, w.Code, http.StatusForbidden)
+			continue
+		}
+		var p testProblem
+		decode(t, w, &p)
+		if p.Fields["permission"] != models.PermUsersDelete+" is required" {
+			t.Errorf("GET %s as a member: problem %+v, want %s required", path, p, models.PermUsersDelete)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// checks the validate tags on request and model structs
+var validate = newValidator()
+
+// lowercase letters, digits and single dashes between them
+var usernamePattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// a phone number in E.164: a + and up to 15 digits, the first being a country code
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	// name fields the way clients see them, by their JSON keys
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	})
+	// stricter than the built-in e164, which leaves out the +
+	v.RegisterValidation("e164", func(fl validator.FieldLevel) bool {
+		return e164Pattern.MatchString(fl.Field().String())
+	})
+	return v
+}
+
+// a message for one failed check, in the style of the hand-written ones
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fe.Field() + " is required"
+	case "email":
+		return fe.Field() + " must be a valid email address"
+	case "max":
+		return fe.Field() + " must be at most " + fe.Param() + " characters"
+	case "min":
+		return fe.Field() + " must be at least " + fe.Param() + " characters"
+	case "username":
+		return fe.Field() + " must be lowercase letters and digits, with single dashes between them"
+	case "e164":
+		return fe.Field() + " must be a phone number in E.164 format such as +14155550123"
+	case "timezone":
+		return fe.Field() + " must be an IANA time zone such as Europe/Paris"
+	case "bcp47_language_tag":
+		return fe.Field() + " must be a language tag such as en or pt-BR"
+	}
+	return fe.Field() + " is invalid"
+}
+
+// run the tagged checks on v, keyed by JSON field name; empty when v is valid
+func validationErrors(v interface{}) map[string]string {
+	fields := map[string]string{}
+	var errs validator.ValidationErrors
+	if err := validate.Struct(v); errors.As(err, &errs) {
+		for _, fe := range errs {
+			fields[fe.Field()] = validationMessage(fe)
+		}
+	}
+	return fields
+}
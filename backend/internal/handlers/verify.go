@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"api/internal/mail"
+	"api/internal/models"
+	"api/internal/store"
+	"api/internal/tenant"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// how long a verification link stays valid
+const verificationTokenTTL = 48 * time.Hour
+
+// audience of verification tokens, keeping them apart from access tokens signed with the same secret
+const verificationAudience = "email_verification"
+
+// the token in a verification link; it names the address it was sent to, so it stops
+// working once the user changes their email, and the user's tenant, which a link followed in a
+// browser has no other way to name. a link sent to an address the user is moving to is marked as
+// a change, confirming that address rather than the current one
+type verificationClaims struct {
+	Email    string `json:"email"`
+	TenantID int    `json:"tid,omitempty"`
+	Change   bool   `json:"change,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (a *App) issueVerificationToken(ctx context.Context, u models.User) (string, error) {
+	return a.signVerification(ctx, u, verificationClaims{Email: u.Email})
+}
+
+// a token confirming email as the one u is moving to
+func (a *App) issueEmailChangeToken(ctx context.Context, u models.User, email string) (string, error) {
+	return a.signVerification(ctx, u, verificationClaims{Email: email, Change: true})
+}
+
+func (a *App) signVerification(ctx context.Context, u models.User, claims verificationClaims) (string, error) {
+	now := time.Now()
+	claims.TenantID = tenant.ID(ctx)
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Subject:   strconv.Itoa(u.Id),
+		Audience:  jwt.ClaimStrings{verificationAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(verificationTokenTTL)),
+	}
+	return a.signToken(claims)
+}
+
+func (a *App) parseVerificationToken(token string) (verificationClaims, int, error) {
+	var claims verificationClaims
+	if err := a.parseToken(token, verificationAudience, &claims); err != nil {
+		return claims, 0, err
+	}
+	if claims.TenantID == 0 {
+		claims.TenantID = tenant.DefaultID
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	return claims, id, err
+}
+
+// email u a link to confirm their address, with the given template: a welcome on sign-up or a
+// plain reminder
+func (a *App) sendVerification(ctx context.Context, u models.User, template string) error {
+	token, err := a.issueVerificationToken(ctx, u)
+	if err != nil {
+		return err
+	}
+	link := a.publicURL + "/api/v1/verify?token=" + url.QueryEscape(token)
+	m, err := mail.Render(template, u.Email, mail.Data{Name: u.Name, Link: link})
+	if err != nil {
+		return err
+	}
+	return a.mailer.Send(ctx, m)
+}
+
+// announce a newly created user on the feed and email them a verification link. delivery problems
+// are logged rather than failing the request that created the user; they can ask for another link
+func (a *App) userCreated(ctx context.Context, u models.User) {
+	a.feed.publish(ctx, models.EventUserCreated, u)
+	if err := a.sendVerification(ctx, u, mail.TemplateWelcome); err != nil {
+		slog.ErrorContext(ctx, "send verification email failed", "err", err, "user_id", u.Id)
+	}
+}
+
+// confirm an email address from the link in a verification email
+func (a *App) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "verification token is required", Fields: map[string]string{"token": "token is required"}})
+		return
+	}
+	invalid := models.APIError{Code: models.ErrCodeBadRequest, Message: "verification link is invalid or expired", Fields: map[string]string{"token": "token is invalid or expired"}}
+	claims, id, err := a.parseVerificationToken(token)
+	if err != nil {
+		models.WriteError(w, http.StatusBadRequest, invalid)
+		return
+	}
+	r = r.WithContext(tenant.WithID(r.Context(), claims.TenantID))
+
+	var u models.User
+	if claims.Change {
+		// signs the user out everywhere, as they may have been signed in under the old address
+		u, err = a.users.ConfirmEmailChange(r.Context(), id, claims.Email)
+	} else {
+		u, err = a.users.MarkEmailVerified(r.Context(), id, claims.Email)
+	}
+	if err == store.ErrUserNotFound {
+		// the user is gone or has moved to another address since the link was sent
+		models.WriteError(w, http.StatusBadRequest, invalid)
+		return
+	} else if err == store.ErrEmailTaken {
+		writeEmailTaken(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	a.feed.publish(r.Context(), models.EventUserUpdated, u)
+	writeBody(w, presentUser(r, u))
+}
+
+// send a fresh verification link to a user who hasn't confirmed their address yet
+func (a *App) resendVerification(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	u, err := a.users.Get(r.Context(), id, false)
+	if err == store.ErrUserNotFound {
+		writeNotFound(w)
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if u.EmailVerifiedAt != nil {
+		models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "email is already verified"})
+		return
+	}
+
+	if err := a.sendVerification(r.Context(), u, mail.TemplateVerify); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	writeBody(w, map[string]string{"status": "sent"})
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"api/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// versions of the API, each mounted under /api/v<n>. they share handlers, which shape user
+// payloads for the version they were reached through with the present* helpers below
+const (
+	apiV1 = 1
+	apiV2 = 2
+)
+
+// the unversioned prefix the API started under, kept as a deprecated alias of v1
+const legacyPrefix = "/api/go"
+
+// when the legacy prefix was deprecated, sent in its Deprecation header
+var legacyDeprecatedAt = time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC)
+
+// what a subrouter serves, recorded in the context of the requests it routes
+type apiRoutes struct {
+	version int
+	prefix  string // the path the routes are under, such as /api/v2, for building links
+	avatars bool   // whether avatar uploads are routed
+	// how users without an uploaded avatar are pictured, see Options.DefaultAvatar
+	defaultAvatar string
+	publicURL     string // the API's external base URL, for images loaded from other origins
+}
+
+type apiRoutesKey struct{}
+
+func withAPIRoutes(api apiRoutes) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiRoutesKey{}, api)))
+		})
+	}
+}
+
+// the API r was routed through, v1 when outside any versioned prefix
+func routedAPI(r *http.Request) apiRoutes {
+	if api, ok := r.Context().Value(apiRoutesKey{}).(apiRoutes); ok {
+		return api
+	}
+	return apiRoutes{version: apiV1, prefix: "/api/v1"}
+}
+
+func apiVersion(r *http.Request) int {
+	return routedAPI(r).version
+}
+
+// mark responses under the legacy prefix as deprecated (RFC 9745), linking to the same resource under v1
+func deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecatedAt.Unix()))
+		successor := "/api/v1" + strings.TrimPrefix(r.URL.Path, legacyPrefix)
+		w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// the route's path below its version prefix, e.g. /users/{id} for /api/v2/users/{id}
+func versionedPath(tmpl string) string {
+	rest, ok := strings.CutPrefix(tmpl, "/api/")
+	if !ok {
+		return tmpl
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[i:]
+	}
+	return "/"
+}
+
+// u in the shape of the API version r was routed through
+func versionedUser(r *http.Request, u models.User) interface{} {
+	u = maskUser(r.Context(), withDefaultAvatar(r, u))
+	if apiVersion(r) == apiV2 {
+		return u.V2()
+	}
+	return u
+}
+
+// u as a response body: the versioned user with its links, or a JSON:API document holding it
+func presentUser(r *http.Request, u models.User) interface{} {
+	if wantsJSONAPI(r) {
+		resource := userResource(r, u)
+		return jsonAPIDocument{Data: resource, Links: resource.Links}
+	}
+	return linkedVersionedUser(r, u)
+}
+
+// the users of a listing, trimmed to the ?fields= it asked for
+func presentUsers(r *http.Request, users []models.User) []interface{} {
+	fs := requestedFieldset(r)
+	presented := make([]interface{}, len(users))
+	for i, u := range users {
+		presented[i] = fs.apply(linkedVersionedUser(r, u))
+	}
+	return presented
+}
+
+// v1 field names that v2 moved into the profile, as they appear in validation errors and diffs
+var v2FieldNames = map[string]string{
+	"name":       "profile.name",
+	"avatar_url": "profile.avatar_url",
+	"phone":      "profile.phone",
+	"bio":        "profile.bio",
+	"timezone":   "profile.timezone",
+	"locale":     "profile.locale",
+}
+
+// rename user fields keyed by their v1 names for the version r was routed through
+func presentFields(r *http.Request, fields map[string]string) map[string]string {
+	if apiVersion(r) != apiV2 || len(fields) == 0 {
+		return fields
+	}
+	renamed := make(map[string]string, len(fields))
+	for name, msg := range fields {
+		if v2, ok := v2FieldNames[name]; ok {
+			msg = strings.Replace(msg, name, v2, 1)
+			name = v2
+		}
+		renamed[name] = msg
+	}
+	return renamed
+}
+
+type authResponseV2 struct {
+	authResponse
+	User models.UserV2 `json:"user"` // shadows the embedded v1 user
+}
+
+func presentSession(r *http.Request, s authResponse) interface{} {
+	s.User = withDefaultAvatar(r, s.User)
+	if apiVersion(r) == apiV2 {
+		return authResponseV2{authResponse: s, User: s.User.V2()}
+	}
+	return s
+}
+
+type searchResultV1 struct {
+	linkedUser
+	Rank float64 `json:"rank"`
+}
+
+type searchResultV2 struct {
+	linkedUserV2
+	Rank float64 `json:"rank"`
+}
+
+func presentSearchResults(r *http.Request, results []models.SearchResult) interface{} {
+	presented := make([]interface{}, len(results))
+	for i, res := range results {
+		res.User = maskUser(r.Context(), withDefaultAvatar(r, res.User))
+		links := userLinks(r, res.User)
+		if apiVersion(r) == apiV2 {
+			presented[i] = searchResultV2{linkedUserV2: linkedUserV2{UserV2: res.User.V2(), Links: links}, Rank: res.Rank}
+		} else {
+			presented[i] = searchResultV1{linkedUser: linkedUser{User: res.User, Links: links}, Rank: res.Rank}
+		}
+	}
+	return presented
+}
+
+type userEventV2 struct {
+	ID   int64         `json:"id"`
+	Type string        `json:"type"`
+	User models.UserV2 `json:"user"`
+	At   time.Time     `json:"at"`
+}
+
+func presentEvent(r *http.Request, e models.UserEvent) interface{} {
+	e.User = maskUser(r.Context(), withDefaultAvatar(r, e.User))
+	if apiVersion(r) == apiV2 {
+		return userEventV2{ID: e.ID, Type: e.Type, User: e.User.V2(), At: e.At}
+	}
+	return e
+}
+
+type userRevisionV2 struct {
+	Rev       int           `json:"rev"`
+	CreatedAt time.Time     `json:"created_at"`
+	User      models.UserV2 `json:"user"`
+}
+
+func presentRevisions(r *http.Request, revisions []models.UserRevision) interface{} {
+	masked := make([]models.UserRevision, len(revisions))
+	for i, rev := range revisions {
+		rev.User = maskUser(r.Context(), rev.User)
+		masked[i] = rev
+	}
+	if apiVersion(r) != apiV2 {
+		return masked
+	}
+	v2 := make([]userRevisionV2, len(masked))
+	for i, rev := range masked {
+		v2[i] = userRevisionV2{Rev: rev.Rev, CreatedAt: rev.CreatedAt, User: rev.User.V2()}
+	}
+	return v2
+}
+
+func presentDiff(r *http.Request, diff models.RevisionDiff) models.RevisionDiff {
+	if apiVersion(r) != apiV2 {
+		return diff
+	}
+	changes := make([]models.FieldChange, len(diff.Changes))
+	for i, c := range diff.Changes {
+		if v2, ok := v2FieldNames[c.Field]; ok {
+			c.Field = v2
+		}
+		changes[i] = c
+	}
+	diff.Changes = changes
+	return diff
+}
+
+// body accepted by createUser under v2
+type newUserRequestV2 struct {
+	models.UserV2
+	Password string `json:"password"`
+}
+
+func (req newUserRequestV2) v1() newUserRequest {
+	return newUserRequest{User: req.UserV2.User(), Password: req.Password}
+}
+
+// decode a createUser body in the shape of the version r was routed through
+func (a *App) decodeNewUser(w http.ResponseWriter, r *http.Request) (newUserRequest, bool) {
+	const invalid = "request body must be a valid JSON user"
+	if apiVersion(r) == apiV2 {
+		var req newUserRequestV2
+		ok := a.decodeBody(w, r, &req, invalid)
+		return req.v1(), ok
+	}
+	var req newUserRequest
+	ok := a.decodeBody(w, r, &req, invalid)
+	return req, ok
+}
+
+// decode a createUsersBatch body in the shape of the version r was routed through
+func (a *App) decodeNewUsers(w http.ResponseWriter, r *http.Request) ([]newUserRequest, bool) {
+	const invalid = "request body must be a JSON array of users"
+	if apiVersion(r) != apiV2 {
+		var reqs []newUserRequest
+		ok := a.decodeBody(w, r, &reqs, invalid)
+		return reqs, ok
+	}
+	var v2 []newUserRequestV2
+	if !a.decodeBody(w, r, &v2, invalid) {
+		return nil, false
+	}
+	reqs := make([]newUserRequest, len(v2))
+	for i, req := range v2 {
+		reqs[i] = req.v1()
+	}
+	return reqs, true
+}
+
+// decode a user in the shape of the version r was routed through. a user read from the API
+// can be sent back as is, so its _links are accepted and ignored
+func (a *App) decodeVersionedUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	const invalid = "request body must be a valid JSON user"
+	if apiVersion(r) == apiV2 {
+		var u linkedUserV2
+		ok := a.decodeBody(w, r, &u, invalid)
+		return u.UserV2.User(), ok
+	}
+	var u linkedUser
+	ok := a.decodeBody(w, r, &u, invalid)
+	return u.User, ok
+}
+
+// rewrite a v2 merge patch to the flat fields patches apply to, hoisting the members of profile
+func flattenProfilePatch(patch map[string]interface{}) *patchError {
+	raw, ok := patch["profile"]
+	if !ok {
+		return nil
+	}
+	delete(patch, "profile")
+	profile, ok := raw.(map[string]interface{})
+	if !ok {
+		return &patchError{path: "profile", msg: "profile must be an object"}
+	}
+	for name, value := range profile {
+		patch[name] = value
+	}
+	return nil
+}
+
+// rewrite v2 JSON patch paths into the profile to the flat fields patches apply to
+func flattenProfileOps(ops []patchOperation) {
+	for i, op := range ops {
+		if rest, ok := strings.CutPrefix(op.Path, "/profile/"); ok {
+			ops[i].Path = "/" + rest
+		}
+	}
+}
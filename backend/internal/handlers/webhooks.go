@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/store"
+	"api/internal/webhooks"
+)
+
+// marks webhook signing secrets, like apiKeyPrefix does keys
+const webhookSecretPrefix = "whsec_"
+
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// the response to creating a webhook, the only time its signing secret is shown
+type createdWebhook struct {
+	models.Webhook
+	Secret string `json:"secret"`
+}
+
+type deliveryPage struct {
+	Total int                      `json:"total"`
+	Page  int                      `json:"page"`
+	Limit int                      `json:"limit"`
+	Items []models.WebhookDelivery `json:"items"`
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return webhookSecretPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// field errors for a subscription: an absolute http(s) URL and at least one known event, listed once
+func validateWebhook(req *webhookRequest) map[string]string {
+	fields := map[string]string{}
+	req.URL = strings.TrimSpace(req.URL)
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fields["url"] = "url must be an absolute http or https URL"
+	}
+	if len(req.Events) == 0 {
+		fields["events"] = "events must list at least one of " + strings.Join(models.WebhookEvents, ", ")
+	}
+	var events []string
+	for _, e := range req.Events {
+		if !slices.Contains(models.WebhookEvents, e) {
+			fields["events"] = "events must be among " + strings.Join(models.WebhookEvents, ", ")
+		} else if !slices.Contains(events, e) {
+			events = append(events, e)
+		}
+	}
+	req.Events = events
+	return fields
+}
+
+func (a *App) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if !a.decodeBody(w, r, &req, "request body must be valid JSON") {
+		return
+	}
+	fields := validateWebhook(&req)
+	if _, invalid := fields["url"]; !invalid && !a.webhookAllowPrivate {
+		if err := webhooks.CheckURL(r.Context(), req.URL); errors.Is(err, webhooks.ErrForbiddenDestination) {
+			fields["url"] = "url must not point at a loopback, private, link-local or cloud metadata address"
+		} else if err != nil {
+			fields["url"] = "url's host could not be resolved"
+		}
+	}
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "webhook is invalid", Fields: fields})
+		return
+	}
+
+	hook := models.Webhook{URL: req.URL, Events: req.Events, Secret: newWebhookSecret()}
+	if caller, ok := middleware.UserID(r.Context()); ok {
+		hook.CreatedBy = &caller
+	}
+	hook, err := a.webhooks.Create(r.Context(), hook)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeBody(w, createdWebhook{Webhook: hook, Secret: hook.Secret})
+}
+
+func (a *App) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := a.webhooks.List(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, hooks)
+}
+
+// the webhook named by the {id} route variable, having written a 404 when there is none
+func (a *App) routeWebhook(w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	writeMissing := func() {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "webhook not found"})
+	}
+	id, ok := routeID(r)
+	if !ok {
+		writeMissing()
+		return models.Webhook{}, false
+	}
+	hook, err := a.webhooks.Get(r.Context(), id)
+	if err == store.ErrWebhookNotFound {
+		writeMissing()
+		return models.Webhook{}, false
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return models.Webhook{}, false
+	}
+	return hook, true
+}
+
+func (a *App) getWebhook(w http.ResponseWriter, r *http.Request) {
+	if hook, ok := a.routeWebhook(w, r); ok {
+		writeBody(w, hook)
+	}
+}
+
+// unsubscribe a webhook; deliveries still queued for it are dropped
+func (a *App) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := routeID(r)
+	if ok {
+		if err := a.webhooks.Delete(r.Context(), id); err == store.ErrWebhookNotFound {
+			ok = false
+		} else if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	}
+	if !ok {
+		models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "webhook not found"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// a webhook's deliveries, newest first, with the outcome of their latest attempt
+func (a *App) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+	limit, offset := parsePagination(r, fields)
+	if len(fields) > 0 {
+		models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query parameters", Fields: fields})
+		return
+	}
+	hook, ok := a.routeWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, total, err := a.webhooks.Deliveries(r.Context(), hook.Id, limit, offset)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeBody(w, deliveryPage{Total: total, Page: offset/limit + 1, Limit: limit, Items: deliveries})
+}
@@ -0,0 +1,237 @@
+// Package i18n translates the messages of error responses into the language a client's
+// Accept-Language prefers, from catalogs keyed by the English message. English is the language
+// messages are written in, so it needs no catalog; anything a catalog lacks stays in English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/text/language"
+)
+
+// Catalog maps English messages to their translations in one language. A message may name
+// placeholders in braces, such as "{field} is required", matching any text there; the translation
+// repeats it wherever it names the same placeholder.
+type Catalog map[string]string
+
+// Loader reads catalogs, keyed by BCP 47 language tag such as es or pt-BR.
+type Loader interface {
+	Load() (map[string]Catalog, error)
+}
+
+// LoaderFunc adapts a function to a Loader.
+type LoaderFunc func() (map[string]Catalog, error)
+
+func (f LoaderFunc) Load() (map[string]Catalog, error) {
+	return f()
+}
+
+// FS loads every <tag>.json in the root of fsys, each a JSON object of English messages and their
+// translations.
+func FS(fsys fs.FS) Loader {
+	return LoaderFunc(func() (map[string]Catalog, error) {
+		files, err := fs.Glob(fsys, "*.json")
+		if err != nil {
+			return nil, err
+		}
+		catalogs := map[string]Catalog{}
+		for _, name := range files {
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				return nil, err
+			}
+			var c Catalog
+			if err := json.Unmarshal(data, &c); err != nil {
+				return nil, fmt.Errorf("catalog %s: %w", name, err)
+			}
+			catalogs[strings.TrimSuffix(path.Base(name), ".json")] = c
+		}
+		return catalogs, nil
+	})
+}
+
+// Dir loads the catalogs in a directory as FS does, or none when dir is empty.
+func Dir(dir string) Loader {
+	if dir == "" {
+		return LoaderFunc(func() (map[string]Catalog, error) { return nil, nil })
+	}
+	return FS(os.DirFS(dir))
+}
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// Builtin loads the catalogs the API ships with: Spanish and Amharic.
+var Builtin = LoaderFunc(func() (map[string]Catalog, error) {
+	sub, err := fs.Sub(locales, "locales")
+	if err != nil {
+		return nil, err
+	}
+	return FS(sub).Load()
+})
+
+// Bundle is a set of catalogs to translate with, matched against Accept-Language.
+type Bundle struct {
+	tags     []language.Tag // English first, matched when nothing else is
+	matcher  language.Matcher
+	catalogs map[string]*compiled
+}
+
+// a catalog ready to translate with: messages without placeholders are looked up directly, the
+// rest are tried longest first, so the most specific one wins
+type compiled struct {
+	exact     map[string]string
+	templates []template
+}
+
+type template struct {
+	pattern     *regexp.Regexp
+	names       []string // the placeholders, in the order pattern captures them
+	translation string
+}
+
+var placeholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// NewBundle merges the catalogs of loaders, later ones adding to and overriding earlier ones.
+func NewBundle(loaders ...Loader) (*Bundle, error) {
+	merged := map[string]Catalog{}
+	for _, l := range loaders {
+		catalogs, err := l.Load()
+		if err != nil {
+			return nil, err
+		}
+		for tag, c := range catalogs {
+			t, err := language.Parse(tag)
+			if err != nil {
+				return nil, fmt.Errorf("catalog %q: %w", tag, err)
+			}
+			if t == language.English {
+				continue
+			}
+			if merged[t.String()] == nil {
+				merged[t.String()] = Catalog{}
+			}
+			for msg, translation := range c {
+				merged[t.String()][msg] = translation
+			}
+		}
+	}
+
+	b := &Bundle{tags: []language.Tag{language.English}, catalogs: map[string]*compiled{}}
+	names := make([]string, 0, len(merged))
+	for tag := range merged {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+	for _, tag := range names {
+		b.tags = append(b.tags, language.MustParse(tag))
+		b.catalogs[tag] = compile(merged[tag])
+	}
+	b.matcher = language.NewMatcher(b.tags)
+	return b, nil
+}
+
+func compile(c Catalog) *compiled {
+	cc := &compiled{exact: map[string]string{}}
+	for msg, translation := range c {
+		if !placeholder.MatchString(msg) {
+			cc.exact[msg] = translation
+			continue
+		}
+		t := template{translation: translation}
+		pattern := "^"
+		last := 0
+		for _, m := range placeholder.FindAllStringSubmatchIndex(msg, -1) {
+			pattern += regexp.QuoteMeta(msg[last:m[0]]) + "(.+)"
+			t.names = append(t.names, msg[m[2]:m[3]])
+			last = m[1]
+		}
+		t.pattern = regexp.MustCompile(pattern + regexp.QuoteMeta(msg[last:]) + "$")
+		cc.templates = append(cc.templates, t)
+	}
+	sort.Slice(cc.templates, func(i, j int) bool {
+		return len(cc.templates[i].pattern.String()) > len(cc.templates[j].pattern.String())
+	})
+	return cc
+}
+
+// Languages lists the tags of the bundle's languages, English first.
+func (b *Bundle) Languages() []string {
+	tags := make([]string, len(b.tags))
+	for i, t := range b.tags {
+		tags[i] = t.String()
+	}
+	return tags
+}
+
+// Match returns the tag of the bundle's language an Accept-Language header prefers, English when
+// it names none of them.
+func (b *Bundle) Match(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return b.tags[0].String()
+	}
+	_, i, confidence := b.matcher.Match(tags...)
+	if confidence == language.No {
+		return b.tags[0].String()
+	}
+	return b.tags[i].String()
+}
+
+// Translate returns msg in the language tagged lang, as returned by Match, or msg itself when its
+// catalog has no translation.
+func (b *Bundle) Translate(lang, msg string) string {
+	c := b.catalogs[lang]
+	if c == nil || msg == "" {
+		return msg
+	}
+	if translation, ok := c.exact[msg]; ok {
+		return translation
+	}
+	for _, t := range c.templates {
+		m := t.pattern.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		translation := t.translation
+		for i, name := range t.names {
+			translation = strings.ReplaceAll(translation, "{"+name+"}", m[i+1])
+		}
+		return translation
+	}
+	return msg
+}
+
+var installed atomic.Pointer[Bundle]
+
+func init() {
+	b, err := NewBundle(Builtin)
+	if err != nil {
+		panic(err)
+	}
+	installed.Store(b)
+}
+
+// Install makes b the bundle Match and Translate use, in place of the built-in catalogs.
+func Install(b *Bundle) {
+	installed.Store(b)
+}
+
+// Match returns the language of the installed bundle an Accept-Language header prefers.
+func Match(acceptLanguage string) string {
+	return installed.Load().Match(acceptLanguage)
+}
+
+// Translate returns msg in the language tagged lang from the installed bundle.
+func Translate(lang, msg string) string {
+	return installed.Load().Translate(lang, msg)
+}
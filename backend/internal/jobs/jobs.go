@@ -0,0 +1,165 @@
+// Package jobs runs slow work, such as sending email, in the background. jobs are kept in a
+// persistent queue, so they survive restarts, and failures are retried with exponential backoff.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// Handler does the work of one job, given the payload it was enqueued with. an error fails the
+// attempt, and the job is retried under its kind's RetryPolicy
+type Handler func(ctx context.Context, payload []byte) error
+
+// RetryPolicy is how often a kind of job is tried, and how long failed attempts wait.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration // the wait before the first retry, doubling with each one
+	MaxBackoff  time.Duration
+}
+
+// DefaultRetry tries a job 5 times over about 2.5 minutes.
+var DefaultRetry = RetryPolicy{MaxAttempts: 5, Backoff: 10 * time.Second, MaxBackoff: time.Hour}
+
+// how often the queue is checked for due jobs
+const pollInterval = time.Second
+
+// how long an attempt may run before it is cancelled
+const jobTimeout = 5 * time.Minute
+
+// how often finished jobs older than the retention are deleted
+const pruneInterval = time.Hour
+
+type registration struct {
+	handle Handler
+	policy RetryPolicy
+}
+
+// Pool runs queued jobs with a fixed number of workers. any number of pools can share one queue,
+// as each claims the jobs it runs; a pool only claims the kinds registered with it
+type Pool struct {
+	queue     store.Jobs
+	workers   int
+	retention time.Duration
+	kinds     map[string]registration
+}
+
+// NewPool runs up to workers jobs at once and keeps finished jobs for retention.
+func NewPool(queue store.Jobs, workers int, retention time.Duration) *Pool {
+	return &Pool{queue: queue, workers: workers, retention: retention, kinds: map[string]registration{}}
+}
+
+// Register runs jobs of kind with h. kinds are registered before Run starts.
+func (p *Pool) Register(kind string, h Handler, policy RetryPolicy) {
+	p.kinds[kind] = registration{handle: h, policy: policy}
+}
+
+// Enqueue queues a job of a registered kind, with payload encoded as JSON, returning its id.
+func (p *Pool) Enqueue(ctx context.Context, kind string, payload any) (int64, error) {
+	reg, ok := p.kinds[kind]
+	if !ok {
+		return 0, fmt.Errorf("no handler for job kind %q", kind)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	return p.queue.Enqueue(ctx, kind, b, reg.policy.MaxAttempts)
+}
+
+// Run runs due jobs until ctx is done, then waits for the attempts already under way.
+func (p *Pool) Run(ctx context.Context) {
+	kinds := make([]string, 0, len(p.kinds))
+	for kind := range p.kinds {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	var lastPrune time.Time
+	for {
+		// keep claiming while full batches come back, so a backlog drains without waiting on the ticker
+		for p.runDue(ctx, kinds) == p.workers && ctx.Err() == nil {
+		}
+		if time.Since(lastPrune) >= pruneInterval {
+			lastPrune = time.Now()
+			if err := p.queue.Prune(ctx, lastPrune.Add(-p.retention)); err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "prune jobs failed", "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim a job for each worker and run them, returning how many were claimed
+func (p *Pool) runDue(ctx context.Context, kinds []string) int {
+	// attempts outlive shutdown starting, so one isn't cut off and counted against the job
+	ctx = context.WithoutCancel(ctx)
+	due, err := p.queue.ClaimDue(ctx, kinds, p.workers, jobTimeout+time.Minute)
+	if err != nil {
+		slog.ErrorContext(ctx, "claim jobs failed", "err", err)
+		return 0
+	}
+	var wg sync.WaitGroup
+	for _, job := range due {
+		wg.Go(func() {
+			attempt := p.attempt(ctx, job)
+			if err := p.queue.RecordAttempt(ctx, job.Id, attempt); err != nil {
+				slog.ErrorContext(ctx, "record job failed", "err", err, "job_id", job.Id)
+			}
+		})
+	}
+	wg.Wait()
+	return len(due)
+}
+
+// run a job once, scheduling a retry with backoff on failure until its attempts run out
+func (p *Pool) attempt(ctx context.Context, job models.Job) models.JobAttempt {
+	reg := p.kinds[job.Kind]
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+	err := run(ctx, reg.handle, job.Payload)
+	if err == nil {
+		return models.JobAttempt{Status: models.JobSucceeded}
+	}
+	attempts := job.Attempts + 1
+	slog.WarnContext(ctx, "job failed", "err", err, "job_id", job.Id, "kind", job.Kind, "attempt", attempts)
+	result := models.JobAttempt{Status: models.JobFailed, Error: err.Error()}
+	if attempts < job.MaxAttempts {
+		result.Status = models.JobPending
+		result.RunAt = time.Now().Add(reg.policy.backoff(attempts))
+	}
+	return result
+}
+
+// a panicking handler fails its attempt rather than the process
+func run(ctx context.Context, h Handler, payload []byte) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("job panicked: %v", v)
+		}
+	}()
+	return h(ctx, payload)
+}
+
+// the wait after the given number of failed attempts
+func (rp RetryPolicy) backoff(attempts int) time.Duration {
+	wait := rp.Backoff
+	for i := 1; i < attempts && wait < rp.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, rp.MaxBackoff)
+}
@@ -0,0 +1,33 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+
+	"api/internal/mail"
+)
+
+// KindSendEmail jobs deliver one mail.Message.
+const KindSendEmail = "email.send"
+
+// Mailer queues email rather than sending it while the request waits: each message becomes a job
+// that hands it to sender, retried if the mail server is unavailable.
+func (p *Pool) Mailer(sender mail.Sender) mail.Sender {
+	p.Register(KindSendEmail, func(ctx context.Context, payload []byte) error {
+		var m mail.Message
+		if err := json.Unmarshal(payload, &m); err != nil {
+			return err
+		}
+		return sender.Send(ctx, m)
+	}, DefaultRetry)
+	return queuedMailer{pool: p}
+}
+
+type queuedMailer struct {
+	pool *Pool
+}
+
+func (q queuedMailer) Send(ctx context.Context, m mail.Message) error {
+	_, err := q.pool.Enqueue(ctx, KindSendEmail, m)
+	return err
+}
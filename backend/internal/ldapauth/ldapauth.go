@@ -0,0 +1,131 @@
+// Package ldapauth checks logins against an LDAP directory such as Active Directory: it finds the
+// entry for a login with a service account, then binds as that entry with the password given.
+package ldapauth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrInvalidCredentials is returned by Authenticate when no single entry matches the login or the
+// password is wrong for it.
+var ErrInvalidCredentials = errors.New("invalid login or password")
+
+// the placeholder in Config.UserFilter replaced by the escaped login
+const loginPlaceholder = "{login}"
+
+// Config says where the directory is and how users are found in it.
+type Config struct {
+	URL          string // ldap:// or ldaps://
+	StartTLS     bool   // upgrade an ldap:// connection before sending any credentials
+	BindDN       string // service account that searches for users; empty binds anonymously
+	BindPassword string
+	BaseDN       string // where the search for users starts
+	// filter matching the entry for a login, with {login} where it goes, such as
+	// (&(objectClass=user)(sAMAccountName={login}))
+	UserFilter     string
+	EmailAttribute string // attribute holding the user's email, mail by default
+	NameAttribute  string // attribute holding the user's name, displayName by default
+	Timeout        time.Duration
+}
+
+// Entry is the user a login was checked against.
+type Entry struct {
+	DN    string
+	Email string
+	Name  string
+}
+
+// Authenticator checks logins against the directory, connecting afresh for each, so that a bind as
+// one user never carries over to the next.
+type Authenticator struct {
+	config Config
+}
+
+func NewAuthenticator(config Config) *Authenticator {
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = "mail"
+	}
+	if config.NameAttribute == "" {
+		config.NameAttribute = "displayName"
+	}
+	return &Authenticator{config: config}
+}
+
+// Authenticate checks password for the entry matching login, which has to have an email.
+func (a *Authenticator) Authenticate(ctx context.Context, login, password string) (Entry, error) {
+	// an empty password makes an unauthenticated bind, which many directories accept for anyone
+	if login == "" || password == "" {
+		return Entry{}, ErrInvalidCredentials
+	}
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer conn.Close()
+
+	if a.config.BindDN != "" {
+		err = conn.Bind(a.config.BindDN, a.config.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return Entry{}, fmt.Errorf("bind service account: %w", err)
+	}
+
+	filter := strings.ReplaceAll(a.config.UserFilter, loginPlaceholder, ldap.EscapeFilter(login))
+	res, err := conn.Search(ldap.NewSearchRequest(a.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(a.config.Timeout.Seconds()), false, filter, []string{a.config.EmailAttribute, a.config.NameAttribute}, nil))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return Entry{}, fmt.Errorf("search for user: %w", err)
+	}
+	// a login matching several entries is ambiguous, so none of them is signed in
+	if err != nil || len(res.Entries) != 1 {
+		return Entry{}, ErrInvalidCredentials
+	}
+	found := res.Entries[0]
+
+	if err := conn.Bind(found.DN, password); ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return Entry{}, ErrInvalidCredentials
+	} else if err != nil {
+		return Entry{}, fmt.Errorf("bind user: %w", err)
+	}
+	entry := Entry{
+		DN:    found.DN,
+		Email: found.GetAttributeValue(a.config.EmailAttribute),
+		Name:  found.GetAttributeValue(a.config.NameAttribute),
+	}
+	if entry.Email == "" {
+		return Entry{}, fmt.Errorf("user %s has no %s attribute", found.DN, a.config.EmailAttribute)
+	}
+	return entry, nil
+}
+
+func (a *Authenticator) dial(ctx context.Context) (*ldap.Conn, error) {
+	// the directory gets no longer to answer than the request has left
+	timeout := a.config.Timeout
+	if deadline, ok := ctx.Deadline(); ok && (timeout == 0 || time.Until(deadline) < timeout) {
+		timeout = time.Until(deadline)
+	}
+	conn, err := ldap.DialURL(a.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("connect to directory: %w", err)
+	}
+	conn.SetTimeout(timeout)
+	if a.config.StartTLS {
+		u, _ := url.Parse(a.config.URL)
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("start TLS: %w", err)
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,35 @@
+// Package mail delivers the transactional email the API sends, such as verification links.
+package mail
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Message is an email to a single recipient, in plain text and optionally HTML as well.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	HTML    string `json:"html,omitempty"`
+	// optional email is sent to a user in one of the models.Notify categories, which they can opt
+	// out of; email without a category is always sent
+	UserId   int    `json:"user_id,omitempty"`
+	TenantId int    `json:"tenant_id,omitempty"` // the tenant UserId is in
+	Category string `json:"category,omitempty"`
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// LogSender writes messages to the log instead of delivering them, for development and tests.
+type LogSender struct{}
+
+var _ Sender = LogSender{}
+
+func (LogSender) Send(ctx context.Context, m Message) error {
+	slog.InfoContext(ctx, "email not delivered, no mailer configured", "to", m.To, "subject", m.Subject, "body", m.Body)
+	return nil
+}
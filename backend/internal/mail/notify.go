@@ -0,0 +1,34 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+
+	"api/internal/models"
+)
+
+// ChangeNotifier publishes outbox events as email, telling users whose account details changed,
+// so they notice changes they didn't make. it sends through a Sender that respects preferences
+type ChangeNotifier struct {
+	sender Sender
+}
+
+func NewChangeNotifier(sender Sender) *ChangeNotifier {
+	return &ChangeNotifier{sender: sender}
+}
+
+func (n *ChangeNotifier) Publish(ctx context.Context, e models.OutboxEvent) error {
+	if e.EntityType != models.AuditEntityUser || e.Event != models.EventUserUpdated {
+		return nil
+	}
+	var u models.User
+	if err := json.Unmarshal(e.Payload, &u); err != nil {
+		return err
+	}
+	m, err := Render(TemplateProfileChanged, u.Email, Data{Name: u.Name})
+	if err != nil {
+		return err
+	}
+	m.UserId, m.TenantId, m.Category = u.Id, e.TenantId, models.NotifyProfileChanges
+	return n.sender.Send(ctx, m)
+}
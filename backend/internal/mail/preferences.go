@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"context"
+	"log/slog"
+
+	"api/internal/models"
+	"api/internal/store"
+	"api/internal/tenant"
+)
+
+// Preferences looks up the optional email a user has chosen to receive.
+type Preferences interface {
+	NotificationPreferences(ctx context.Context, id int) (models.NotificationPreferences, error)
+}
+
+// RespectPreferences sends through next only the messages their recipients haven't opted out of,
+// checking at the moment of sending so a change of mind applies to email still queued.
+func RespectPreferences(next Sender, prefs Preferences) Sender {
+	return preferenceSender{next: next, prefs: prefs}
+}
+
+type preferenceSender struct {
+	next  Sender
+	prefs Preferences
+}
+
+func (s preferenceSender) Send(ctx context.Context, m Message) error {
+	if m.Category != "" {
+		// a queued message is sent outside of the request or event that made it
+		prefsCtx := ctx
+		if m.TenantId != 0 {
+			prefsCtx = tenant.WithID(ctx, m.TenantId)
+		}
+		p, err := s.prefs.NotificationPreferences(prefsCtx, m.UserId)
+		if err == store.ErrUserNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !p.Allows(m.Category) {
+			slog.DebugContext(ctx, "email not sent, recipient opted out", "user_id", m.UserId, "category", m.Category)
+			return nil
+		}
+	}
+	return s.next.Send(ctx, m)
+}
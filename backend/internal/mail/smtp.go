@@ -0,0 +1,144 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPSender delivers messages through an SMTP server, upgrading the connection with STARTTLS
+// whenever the server offers it.
+type SMTPSender struct {
+	host     string
+	addr     string
+	username string
+	password string
+	from     string
+}
+
+var _ Sender = (*SMTPSender)(nil)
+
+// NewSMTPSender sends as from through host:port, logging in when username is set.
+func NewSMTPSender(host string, port int, username, password, from string) *SMTPSender {
+	return &SMTPSender{host: host, addr: net.JoinHostPort(host, strconv.Itoa(port)), username: username, password: password, from: from}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, m Message) error {
+	if strings.ContainsAny(m.To, "\r\n") {
+		return errors.New("recipient contains a line break")
+	}
+	body, err := s.compose(m)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	// net/smtp takes no context, so the deadline bounds the whole conversation instead
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.username != "" {
+		// PlainAuth refuses to send the password unless the connection is encrypted or local
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// the message in MIME form: plain text alone, or alongside the HTML as alternatives
+func (s *SMTPSender) compose(m Message) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", s.from)
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", strings.ReplaceAll(m.Subject, "\n", " ")))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	if m.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, m.Body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	var parts bytes.Buffer
+	mw := multipart.NewWriter(&parts)
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Body},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	buf.Write(parts.Bytes())
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,69 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// the emails the API sends, each a template in templates/ defining its subject, its plain-text
+// body and the HTML content placed in the shared layout
+const (
+	TemplateWelcome        = "welcome" // sent on sign-up, with a verification link
+	TemplateVerify         = "verify"  // another verification link, on request
+	TemplatePasswordReset  = "reset"
+	TemplateProfileChanged = "profile_changed"     // optional, see models.NotifyProfileChanges
+	TemplateInvitation     = "invitation"          // to join an organization, to an address that may have no account
+	TemplateEmailChange    = "email_change"        // to an address a user is moving to, with a link confirming it
+	TemplateEmailChangeOld = "email_change_notice" // to the address they are moving from
+)
+
+// Data fills in a template: the recipient's name, when they have an account, and the link the
+// email is about.
+type Data struct {
+	Name         string
+	Link         string
+	Organization string // the organization an invitation is to
+	NewEmail     string // the address an account is moving to
+}
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// parsed once; the templates are embedded, so one that doesn't parse fails every start
+var templates = func() map[string]emailTemplate {
+	names := []string{TemplateWelcome, TemplateVerify, TemplatePasswordReset, TemplateProfileChanged, TemplateInvitation, TemplateEmailChange, TemplateEmailChangeOld}
+	parsed := make(map[string]emailTemplate, len(names))
+	for _, name := range names {
+		file := "templates/" + name + ".tmpl"
+		parsed[name] = emailTemplate{
+			text: texttemplate.Must(texttemplate.ParseFS(templateFS, file)),
+			html: htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/layout.tmpl", file)),
+		}
+	}
+	return parsed
+}()
+
+// Render fills in the named template for a message to to. the HTML escapes data; the subject and
+// plain text are sent as is
+func Render(name, to string, data Data) (Message, error) {
+	t := templates[name]
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := t.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return Message{}, err
+	}
+	if err := t.html.ExecuteTemplate(&html, "html", data); err != nil {
+		return Message{}, err
+	}
+	return Message{To: to, Subject: strings.TrimSpace(subject.String()), Body: text.String(), HTML: html.String()}, nil
+}
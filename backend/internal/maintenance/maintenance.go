@@ -0,0 +1,106 @@
+// Package maintenance runs the periodic clean-up tasks that keep the database free of data that is
+// no longer of use, such as expired sessions, and exports metrics on each run.
+package maintenance
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Task does one round of clean-up, returning how many rows it removed.
+type Task func(ctx context.Context) (int64, error)
+
+// metrics on every run, labelled by task name
+var (
+	runsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "maintenance_runs_total",
+		Help: "Maintenance task runs, by task and result (ok or error).",
+	}, []string{"task", "result"})
+
+	rowsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "maintenance_rows_deleted_total",
+		Help: "Rows removed by maintenance tasks, by task.",
+	}, []string{"task"})
+
+	runDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "maintenance_run_duration_seconds",
+		Help:    "How long maintenance task runs take, by task.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"task"})
+
+	lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "maintenance_last_success_timestamp_seconds",
+		Help: "Unix time of each maintenance task's last successful run.",
+	}, []string{"task"})
+)
+
+// Collectors are the scheduler's metrics, for the metrics registry.
+var Collectors = []prometheus.Collector{runsTotal, rowsDeleted, runDuration, lastSuccess}
+
+// how long a run may take before it is cancelled
+const runTimeout = 10 * time.Minute
+
+type job struct {
+	name     string
+	interval time.Duration
+	task     Task
+}
+
+// Scheduler runs each task on its own interval. the tasks are idempotent deletes, so replicas
+// running the same schedule side by side only repeat each other's work
+type Scheduler struct {
+	jobs []job
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Every runs task once every interval, the first time an interval after Run starts, so a restart
+// loop doesn't run it over and over. a zero interval leaves the task out
+func (s *Scheduler) Every(name string, interval time.Duration, task Task) {
+	if interval > 0 {
+		s.jobs = append(s.jobs, job{name: name, interval: interval, task: task})
+	}
+}
+
+// Run runs tasks on schedule until ctx is done, then waits for the runs under way.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Go(func() {
+			ticker := time.NewTicker(j.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					run(ctx, j)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func run(ctx context.Context, j job) {
+	ctx, cancel := context.WithTimeout(ctx, runTimeout)
+	defer cancel()
+	start := time.Now()
+	n, err := j.task(ctx)
+	runDuration.WithLabelValues(j.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		runsTotal.WithLabelValues(j.name, "error").Inc()
+		slog.ErrorContext(ctx, "maintenance task failed", "err", err, "task", j.name)
+		return
+	}
+	runsTotal.WithLabelValues(j.name, "ok").Inc()
+	rowsDeleted.WithLabelValues(j.name).Add(float64(n))
+	lastSuccess.WithLabelValues(j.name).SetToCurrentTime()
+	slog.InfoContext(ctx, "maintenance task ran", "task", j.name, "deleted", n, "duration", time.Since(start).String())
+}
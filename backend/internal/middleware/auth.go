@@ -0,0 +1,277 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/models"
+	"api/internal/store"
+	"api/internal/tenant"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+// AccessTokenTTL is how long an access token stays valid; clients keep going past it with a refresh token
+const AccessTokenTTL = 15 * time.Minute
+
+// audience of access tokens, so tokens signed with the same secret for other purposes,
+// such as email verification links, are never accepted as bearer tokens
+const accessAudience = "access"
+
+type contextKey string
+
+// context keys holding the id of the authenticated user, for access tokens their session and, for
+// impersonation tokens, the impersonation and the admin behind it
+const (
+	userIDKey          contextKey = "userID"
+	sessionIDKey       contextKey = "sessionID"
+	impersonationIDKey contextKey = "impersonationID"
+	impersonatorKey    contextKey = "impersonator"
+)
+
+// claims of an access token; the subject is the user id
+type accessClaims struct {
+	SessionID       int          `json:"sid,omitempty"` // the login session the token was issued for
+	TenantID        int          `json:"tid,omitempty"` // the tenant the user is in; tokens from before tenants have none
+	ImpersonationID int          `json:"imp,omitempty"` // the impersonation an impersonation token was issued for
+	Actor           *actorClaims `json:"act,omitempty"` // the admin acting as the subject, as in RFC 8693
+	jwt.RegisteredClaims
+}
+
+type actorClaims struct {
+	Subject string `json:"sub"`
+}
+
+// IssueToken signs an access token for a user's session in a tenant
+func IssueToken(secret []byte, userID, sessionID, tenantID int) (string, error) {
+	now := time.Now()
+	claims := accessClaims{
+		SessionID: sessionID,
+		TenantID:  tenantID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(userID),
+			Audience:  jwt.ClaimStrings{accessAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// IssueImpersonationToken signs an access token letting an admin act as a user until expiresAt,
+// without a session, that is rejected once the impersonation has ended.
+func IssueImpersonationToken(secret []byte, i models.Impersonation, tenantID int) (string, error) {
+	claims := accessClaims{
+		TenantID:        tenantID,
+		ImpersonationID: i.Id,
+		Actor:           &actorClaims{Subject: strconv.Itoa(i.AdminId)},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(i.UserId),
+			Audience:  jwt.ClaimStrings{accessAudience},
+			IssuedAt:  jwt.NewNumericDate(i.StartedAt),
+			ExpiresAt: jwt.NewNumericDate(i.ExpiresAt),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// parse and verify a bearer token
+func parseToken(secret []byte, token string) (accessClaims, int, error) {
+	var claims accessClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(accessAudience), jwt.WithIssuedAt())
+	if err != nil {
+		return claims, 0, err
+	}
+	if claims.IssuedAt == nil {
+		return claims, 0, errors.New("token has no issue time")
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	return claims, id, err
+}
+
+// TokenRevocations reports when a user's access tokens were last revoked, such as by a password
+// reset; tokens issued before then are rejected. a zero time means none have been. tokens for a
+// revoked session or an ended impersonation are rejected too
+type TokenRevocations interface {
+	TokensValidAfter(ctx context.Context, userID int) (time.Time, error)
+	SessionRevoked(ctx context.Context, sessionID int) (bool, error)
+	ImpersonationEnded(ctx context.Context, impersonationID int) (bool, error)
+}
+
+// APIKeys resolves an API key, by its HashAPIKey hash, to the user it acts as and their tenant; it
+// reports store.ErrAPIKeyNotFound for unknown and revoked keys
+type APIKeys interface {
+	APIKeyUser(ctx context.Context, keyHash []byte) (userID, tenantID int, err error)
+}
+
+// HashAPIKey is how API keys are stored and looked up. they are long and random, so a fast hash is enough
+func HashAPIKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+// take the token from the Authorization header; browsers can't set headers on
+// WebSocket upgrades or EventSource streams, so those may pass ?access_token= instead
+func bearerToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if websocket.IsWebSocketUpgrade(r) || r.Header.Get("Accept") == "text/event-stream" {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// AuthError is why Authenticate refused a request's credentials, as the status to answer with:
+// 401, or 403 for credentials issued in another tenant than the one the request names.
+type AuthError struct {
+	Status int
+	models.APIError
+}
+
+func unauthorized(msg string) *AuthError {
+	return &AuthError{Status: http.StatusUnauthorized, APIError: models.APIError{Code: models.ErrCodeUnauthorized, Message: msg}}
+}
+
+// scope ctx to the tenant a token or key was issued in, unless the request named a different one,
+// which it isn't allowed into
+func tokenTenant(ctx context.Context, tenantID int) (context.Context, error) {
+	if tenantID == 0 {
+		tenantID = tenant.DefaultID
+	}
+	if named, ok := tenant.FromContext(ctx); ok && named != tenantID {
+		return nil, &AuthError{Status: http.StatusForbidden, APIError: models.APIError{Code: models.ErrCodeForbidden, Message: "your credentials are not for this tenant"}}
+	}
+	return tenant.WithID(ctx, tenantID), nil
+}
+
+// Authenticate checks a request's credentials, an API key given as "ApiKey <key>" in its
+// authorization or else a bearer token, and returns ctx carrying the user they are for, scoped to
+// their tenant. credentials it refuses are reported as an *AuthError; any other error is the
+// server's. Auth answers HTTP requests with it, and the gRPC server its calls.
+func Authenticate(ctx context.Context, secret []byte, revocations TokenRevocations, keys APIKeys, authorization, token string) (context.Context, error) {
+	if key, ok := strings.CutPrefix(authorization, "ApiKey "); ok {
+		userID, tenantID, err := keys.APIKeyUser(ctx, HashAPIKey(key))
+		if err == store.ErrAPIKeyNotFound {
+			return nil, unauthorized("invalid or revoked API key")
+		} else if err != nil {
+			return nil, fmt.Errorf("look up API key: %w", err)
+		}
+		ctx, err := tokenTenant(ctx, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		return context.WithValue(ctx, userIDKey, userID), nil
+	}
+
+	if token == "" {
+		return nil, unauthorized("missing bearer token")
+	}
+	invalid := unauthorized("invalid or expired token")
+	claims, userID, err := parseToken(secret, token)
+	if err != nil {
+		return nil, invalid
+	}
+	ctx, err = tokenTenant(ctx, claims.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	validAfter, err := revocations.TokensValidAfter(ctx, userID)
+	if err == store.ErrUserNotFound {
+		// the account has been deleted since the token was issued
+		return nil, invalid
+	} else if err != nil {
+		return nil, fmt.Errorf("check token revocation: %w", err)
+	}
+	// issue times only have second precision
+	if claims.IssuedAt.Before(validAfter.Truncate(time.Second)) {
+		return nil, unauthorized("token has been revoked")
+	}
+	if claims.SessionID != 0 {
+		revoked, err := revocations.SessionRevoked(ctx, claims.SessionID)
+		if err != nil {
+			return nil, fmt.Errorf("check session revocation: %w", err)
+		}
+		if revoked {
+			return nil, unauthorized("session has been revoked")
+		}
+	}
+
+	ctx = context.WithValue(ctx, userIDKey, userID)
+	if claims.ImpersonationID != 0 {
+		// changes made with an impersonation token are audited as the admin's
+		adminID, err := strconv.Atoi(claims.Actor.subject())
+		if err != nil {
+			return nil, invalid
+		}
+		ended, err := revocations.ImpersonationEnded(ctx, claims.ImpersonationID)
+		if err != nil {
+			return nil, fmt.Errorf("check impersonation: %w", err)
+		}
+		if ended {
+			return nil, unauthorized("impersonation has ended")
+		}
+		ctx = context.WithValue(ctx, impersonationIDKey, claims.ImpersonationID)
+		return context.WithValue(ctx, impersonatorKey, adminID), nil
+	}
+	if claims.SessionID != 0 {
+		ctx = context.WithValue(ctx, sessionIDKey, claims.SessionID)
+	}
+	return ctx, nil
+}
+
+// require a valid, unrevoked bearer token, or an API key sent as "Authorization: ApiKey <key>"
+// by machine clients, and put the user id and their tenant on the context
+func Auth(secret []byte, revocations TokenRevocations, keys APIKeys) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, err := Authenticate(r.Context(), secret, revocations, keys, r.Header.Get("Authorization"), bearerToken(r))
+			var refused *AuthError
+			if errors.As(err, &refused) {
+				models.WriteError(w, refused.Status, refused.APIError)
+				return
+			} else if err != nil {
+				WriteServerError(w, r, err, "authenticate request failed")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// UserID returns the id of the user authenticated by Auth, if any
+func UserID(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(userIDKey).(int)
+	return id, ok
+}
+
+// an impersonation token always names its actor; a token without one isn't valid
+func (a *actorClaims) subject() string {
+	if a == nil {
+		return ""
+	}
+	return a.Subject
+}
+
+// Impersonation returns the impersonation and the id of the admin behind it when Auth
+// authenticated an impersonation token.
+func Impersonation(ctx context.Context) (impersonationID, adminID int, ok bool) {
+	impersonationID, ok = ctx.Value(impersonationIDKey).(int)
+	adminID, _ = ctx.Value(impersonatorKey).(int)
+	return impersonationID, adminID, ok
+}
+
+// SessionID returns the session of the access token authenticated by Auth, if any; API keys have none
+func SessionID(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(sessionIDKey).(int)
+	return id, ok
+}
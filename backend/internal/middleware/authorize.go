@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"api/internal/models"
+)
+
+// Permissions reports whether a user's role grants a permission, such as models.PermUsersDelete
+type Permissions interface {
+	HasPermission(ctx context.Context, userID int, permission string) (bool, error)
+}
+
+// answer 403 unless the user authenticated by Auth has permission. roles are looked up on every
+// request, so changing someone's role takes effect without waiting for their token to expire
+func RequirePermission(perms Permissions, permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := UserID(r.Context())
+			if !ok {
+				models.WriteError(w, http.StatusUnauthorized, models.APIError{Code: models.ErrCodeUnauthorized, Message: "missing bearer token"})
+				return
+			}
+			granted, err := perms.HasPermission(r.Context(), userID, permission)
+			if err != nil {
+				WriteServerError(w, r, err, "check permission failed", "permission", permission)
+				return
+			}
+			if !granted {
+				models.WriteError(w, http.StatusForbidden, models.APIError{Code: models.ErrCodeForbidden, Message: "your role does not allow this", Fields: map[string]string{"permission": permission + " is required"}})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"api/internal/codec"
+)
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	zlibWriters = sync.Pool{New: func() interface{} { return zlib.NewWriter(nil) }}
+)
+
+// the encoding to compress with given an Accept-Encoding header, preferring gzip
+// over deflate at equal weight; "" when the client accepts neither
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name != "gzip" && name != "deflate" || q <= 0 {
+			continue
+		}
+		if q > bestQ || q == bestQ && name == "gzip" {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	// JSON is also what Format answers for types it doesn't know, so it is matched by name
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || codec.Format(mediaType) != codec.JSON
+}
+
+// holds the start of a response back until it is known whether it reaches minSize,
+// then sends it either compressed or as is
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string // "" when the client accepts no compression
+	minSize  int
+	status   int
+	buf      []byte
+	decided  bool
+	enc      io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	// these carry no body to compress
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// send the header and whatever is buffered, compressing from here on when compress is set
+// and the response is JSON the client accepts compressed
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if compressible(h) {
+		h.Add("Vary", "Accept-Encoding")
+		if compress && cw.encoding != "" {
+			h.Set("Content-Encoding", cw.encoding)
+			h.Del("Content-Length")
+			if cw.encoding == "gzip" {
+				gz := gzipWriters.Get().(*gzip.Writer)
+				gz.Reset(cw.ResponseWriter)
+				cw.enc = gz
+			} else {
+				zw := zlibWriters.Get().(*zlib.Writer)
+				zw.Reset(cw.ResponseWriter)
+				cw.enc = zw
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// a flush means the handler is streaming, so what's buffered goes out without waiting for minSize
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// finish the response: anything still buffered was under minSize and goes out as is
+func (cw *compressWriter) close() {
+	if !cw.decided && cw.status != 0 {
+		cw.decide(false)
+	}
+	switch enc := cw.enc.(type) {
+	case *gzip.Writer:
+		enc.Close()
+		gzipWriters.Put(enc)
+	case *zlib.Writer:
+		enc.Close()
+		zlibWriters.Put(enc)
+	}
+}
+
+// Compress gzip or deflate compresses JSON, XML and MessagePack responses of at least minSize bytes for clients whose
+// Accept-Encoding allows it. smaller responses aren't worth the overhead and are sent as is, as are
+// streams, which are flushed before they reach minSize, and WebSocket upgrades
+func Compress(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: negotiateEncoding(r.Header.Get("Accept-Encoding")), minSize: minSize}
+			// not deferred: after a panic nothing may be sent, so Recover can still write its 500
+			next.ServeHTTP(cw, r)
+			cw.close()
+		})
+	}
+}
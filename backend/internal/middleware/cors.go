@@ -0,0 +1,102 @@
+// Package middleware holds the http.Handler wrappers applied around the API routes.
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/codec"
+	"api/internal/i18n"
+)
+
+// CORSOptions configures which cross-site requests browsers are allowed to make.
+type CORSOptions struct {
+	// AllowedOrigins may make requests; "*" allows any origin.
+	AllowedOrigins []string
+	// AllowedMethods and AllowedHeaders are what preflighted requests may use.
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read.
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and HTTP auth; the origin is then echoed, never "*".
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response; zero leaves it to the browser.
+	MaxAge time.Duration
+}
+
+// answer CORS preflights and mark responses to allowed origins as readable cross-site
+func CORS(opts CORSOptions) func(http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, o := range opts.AllowedOrigins {
+		allowed[o] = true
+	}
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	headers := strings.Join(opts.AllowedHeaders, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(opts.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			w.Header().Add("Vary", "Origin")
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if origin != "" && (allowed[origin] || allowed["*"]) {
+				// credentialed responses must name the origin; browsers reject "*" with them
+				if allowed["*"] && !opts.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+				if opts.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if preflight {
+					w.Header().Set("Access-Control-Allow-Methods", methods)
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+					if opts.MaxAge > 0 {
+						w.Header().Set("Access-Control-Max-Age", maxAge)
+					}
+				} else if exposed != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposed)
+				}
+			}
+
+			// a preflight is answered here whatever the origin; without the headers above the browser
+			// refuses the real request
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Negotiate presets every response's Content-Type to the format the client's Accept header prefers:
+// JSON, XML or MessagePack. handlers and error responses write their bodies in whatever it names,
+// unless they override it
+func Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", codec.Negotiate(r.Header.Get("Accept")))
+		w.Header().Add("Vary", "Accept")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Localize presets every response's Content-Language to the language of the installed message
+// catalogs the client's Accept-Language prefers, English when it names none of them. error
+// responses are written in it; the data of other responses is the same in every language
+func Localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Language", i18n.Match(r.Header.Get("Accept-Language")))
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// longest Idempotency-Key accepted
+const maxIdempotencyKeyLength = 255
+
+// captures the status and body of a response while passing it through
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// identifies a request by its method, path and body, so a key reused for a different request is caught
+func requestHash(r *http.Request, body []byte) []byte {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// the headers the handler set or changed, compared with those already set before it ran
+func changedHeaders(before, after http.Header) http.Header {
+	changed := http.Header{}
+	for k, v := range after {
+		if !slices.Equal(before[k], v) {
+			changed[k] = v
+		}
+	}
+	return changed
+}
+
+// Idempotent makes retries of a request sent with an Idempotency-Key header safe: the first response
+// is stored against the key and replayed to later requests using it, marked Idempotent-Replayed.
+// keys are scoped to the signed-in user, so it must run after Auth. server errors aren't stored,
+// leaving the request free to be retried, and bodies over maxBody are refused
+func Idempotent(keys store.IdempotencyKeys, maxBody int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			userID, signedIn := UserID(r.Context())
+			if key == "" || !signedIn {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "Idempotency-Key must be at most 255 characters"})
+				return
+			}
+
+			// the body is read up front to identify the request, then handed on unchanged
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				models.WriteError(w, http.StatusRequestEntityTooLarge, models.APIError{Code: models.ErrCodePayloadTooLarge, Message: "request body is too large"})
+				return
+			} else if err != nil {
+				models.WriteError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "request body could not be read"})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			hash := requestHash(r, body)
+
+			existing, err := keys.Begin(r.Context(), userID, key, hash)
+			if err != nil {
+				WriteServerError(w, r, err, "claim idempotency key failed")
+				return
+			}
+			if existing != nil {
+				replay(w, existing, hash)
+				return
+			}
+
+			// the key is released unless a response is stored, including when the handler panics
+			// or the client goes away, so it never stays stuck in flight
+			ctx := context.WithoutCancel(r.Context())
+			stored := false
+			defer func() {
+				if !stored {
+					if err := keys.Release(ctx, userID, key); err != nil {
+						slog.ErrorContext(ctx, "release idempotency key failed", "err", err)
+					}
+				}
+			}()
+
+			before := w.Header().Clone()
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 || rec.status >= 500 {
+				return
+			}
+			header := changedHeaders(before, w.Header())
+			// the body is in the format negotiated for this request, so a replay keeps its Content-Type
+			header["Content-Type"] = w.Header().Values("Content-Type")
+			resp := models.IdempotentResponse{StatusCode: rec.status, Header: header, Body: rec.body.Bytes()}
+			if err := keys.Complete(ctx, userID, key, resp); err != nil {
+				slog.ErrorContext(ctx, "store idempotent response failed", "err", err)
+				return
+			}
+			stored = true
+		})
+	}
+}
+
+// answer a request whose key was already used, by replaying its response if it was the same request
+func replay(w http.ResponseWriter, existing *models.IdempotencyRecord, hash []byte) {
+	if subtle.ConstantTimeCompare(existing.RequestHash, hash) != 1 {
+		models.WriteError(w, http.StatusUnprocessableEntity, models.APIError{Code: models.ErrCodeValidationFailed, Message: "Idempotency-Key was already used for a different request"})
+		return
+	}
+	if existing.Response == nil {
+		models.WriteError(w, http.StatusConflict, models.APIError{Code: models.ErrCodeConflict, Message: "a request with this Idempotency-Key is still being processed; retry later"})
+		return
+	}
+	for k, v := range existing.Response.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(existing.Response.StatusCode)
+	w.Write(existing.Response.Body)
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"api/internal/models"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter decides whether the client identified by key may make another request,
+// and if not, how long it should wait
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (bool, time.Duration)
+}
+
+// per-IP limiters unused for this long are dropped by the sweeper
+const limiterIdleTTL = 10 * time.Minute
+
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// IPRateLimiter is token-bucket rate limiting keyed by client IP, held in memory
+type IPRateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*ipLimiter
+	rps      rate.Limit
+	burst    int
+}
+
+// create a limiter allowing rps requests per second with the given burst per IP,
+// and start a goroutine that forgets idle clients
+func NewIPRateLimiter(rps float64, burst int) *IPRateLimiter {
+	l := &IPRateLimiter{limiters: map[string]*ipLimiter{}, rps: rate.Limit(rps), burst: burst}
+	go l.sweep()
+	return l
+}
+
+func (l *IPRateLimiter) sweep() {
+	for range time.Tick(limiterIdleTTL) {
+		l.mu.Lock()
+		for ip, il := range l.limiters {
+			if time.Since(il.lastSeen) > limiterIdleTTL {
+				delete(l.limiters, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// take a token for ip, or report how long until one is available
+func (l *IPRateLimiter) Allow(ctx context.Context, ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	il, ok := l.limiters[ip]
+	if !ok {
+		il = &ipLimiter{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.limiters[ip] = il
+	}
+	il.lastSeen = time.Now()
+	l.mu.Unlock()
+
+	res := il.limiter.Reserve()
+	if delay := res.Delay(); delay > 0 {
+		res.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// atomically trims the window, then admits the request if there is room; returns
+// {1, 0} when allowed or {0, ms until the oldest entry leaves the window}
+var slidingWindowScript = redis.NewScript(`
+local key, now, window, limit, member = KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), ARGV[4]
+redis.call("ZREMRANGEBYSCORE", key, 0, now - window)
+if redis.call("ZCARD", key) < limit then
+	redis.call("ZADD", key, now, member)
+	redis.call("PEXPIRE", key, window)
+	return {1, 0}
+end
+local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+return {0, tonumber(oldest[2]) + window - now}
+`)
+
+// RedisRateLimiter is sliding-window rate limiting shared by every replica through Redis
+type RedisRateLimiter struct {
+	client *redis.Client
+	window time.Duration
+	limit  int
+}
+
+// allow burst requests per window of burst/rps seconds, which keeps the same
+// long-run rate and burst size as the in-memory token bucket
+func NewRedisRateLimiter(client *redis.Client, rps float64, burst int) *RedisRateLimiter {
+	window := time.Duration(float64(burst) / rps * float64(time.Second))
+	return &RedisRateLimiter{client: client, window: window, limit: burst}
+}
+
+// fails open: if Redis is unreachable the request is let through and the error logged
+func (l *RedisRateLimiter) Allow(ctx context.Context, ip string) (bool, time.Duration) {
+	var nonce [8]byte
+	rand.Read(nonce[:])
+	now := time.Now().UnixMilli()
+	member := strconv.FormatInt(now, 10) + "-" + hex.EncodeToString(nonce[:])
+
+	res, err := slidingWindowScript.Run(ctx, l.client, []string{"ratelimit:" + ip},
+		now, l.window.Milliseconds(), l.limit, member).Int64Slice()
+	if err != nil {
+		slog.ErrorContext(ctx, "rate limiter unavailable, allowing request", "err", err)
+		return true, 0
+	}
+	if res[0] == 1 {
+		return true, 0
+	}
+	return false, time.Duration(res[1]) * time.Millisecond
+}
+
+// reject /api/* requests over the per-IP limit with 429 and Retry-After
+func RateLimit(l RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if ok, retryAfter := l.Allow(r.Context(), ClientIP(r)); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				models.WriteError(w, http.StatusTooManyRequests, models.APIError{Code: models.ErrCodeRateLimited, Message: "too many requests, slow down"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// context key holding the client address RealIP resolved
+const clientIPKey contextKey = "clientIP"
+
+// ParseTrustedProxies reads the proxies RealIP trusts, each a CIDR such as 10.0.0.0/8 or a single
+// address.
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an address nor a CIDR", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// RealIP resolves the address of the client behind any of the trusted proxies, for ClientIP. when
+// the connection comes from a trusted proxy, the client is the last address in X-Forwarded-For
+// that isn't one, or else X-Real-IP; headers from anyone else are ignored, since they can say
+// anything.
+func RealIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r, trusted)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey, ip)))
+		})
+	}
+}
+
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	peer := remoteHost(r.RemoteAddr)
+	if !isTrusted(peer, trusted) {
+		return peer
+	}
+	// each proxy appends the address it got the request from, so the client is found by reading
+	// from the right past the trusted ones
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// a malformed hop can't be trusted to have come from a proxy
+			break
+		}
+		if !isTrusted(addr.Unmap().String(), trusted) || i == 0 {
+			return addr.Unmap().String()
+		}
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
+	}
+	return peer
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func isTrusted(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP is the client address without its port: the one RealIP resolved, or the peer's outside
+// of it
+func ClientIP(r *http.Request) string {
+	if ip := ClientIPFromContext(r.Context()); ip != "" {
+		return ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// ClientIPFromContext returns the client address RealIP resolved for the request, or "" outside of it
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"api/internal/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var httpPanicsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "http_panics_total",
+	Help: "Panics recovered while serving HTTP requests.",
+})
+
+// Recover turns a panicking handler into a 500 problem response and logs the panic with its stack.
+// a response that has already started can only be cut short. http.ErrAbortHandler is passed on,
+// since it aborts the response on purpose
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			httpPanicsTotal.Inc()
+			slog.ErrorContext(r.Context(), "panic serving request",
+				"panic", fmt.Sprint(v),
+				"stack", string(debug.Stack()),
+				"method", r.Method,
+				"path", r.URL.Path,
+			)
+			if rec.status == 0 {
+				models.WriteError(w, http.StatusInternalServerError, models.APIError{Code: models.ErrCodeInternal, Message: "internal server error"})
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
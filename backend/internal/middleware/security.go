@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SecurityHeaderOptions configures the headers set by SecurityHeaders.
+type SecurityHeaderOptions struct {
+	// ContentSecurityPolicy is sent as is; empty omits the header.
+	ContentSecurityPolicy string
+	// HSTSMaxAge is how long browsers should insist on HTTPS; zero omits Strict-Transport-Security.
+	HSTSMaxAge time.Duration
+}
+
+// SecurityHeaders sets hardening headers on every response: no MIME sniffing, no framing,
+// no referrer sent cross-origin, HTTPS only and the configured content security policy
+func SecurityHeaders(opts SecurityHeaderOptions) func(http.Handler) http.Handler {
+	hsts := "max-age=" + strconv.Itoa(int(opts.HSTSMaxAge.Seconds())) + "; includeSubDomains"
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+			if opts.HSTSMaxAge > 0 {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+			if opts.ContentSecurityPolicy != "" {
+				h.Set("Content-Security-Policy", opts.ContentSecurityPolicy)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
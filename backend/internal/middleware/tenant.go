@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"api/internal/models"
+	"api/internal/store"
+	"api/internal/tenant"
+)
+
+// Tenant scopes the request to the tenant named by the X-Tenant header, answering 404 when there is
+// no such tenant. without the header the request is left for Auth to scope by its token, or falls
+// in the default tenant
+func Tenant(tenants store.Tenants) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			slug := strings.TrimSpace(r.Header.Get(tenant.Header))
+			if slug == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id, err := tenants.TenantID(r.Context(), slug)
+			if err == store.ErrTenantNotFound {
+				models.WriteError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "tenant not found", Fields: map[string]string{tenant.Header: "no tenant is called " + slug}})
+				return
+			} else if err != nil {
+				WriteServerError(w, r, err, "look up tenant failed")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(tenant.WithID(r.Context(), id)))
+		})
+	}
+}
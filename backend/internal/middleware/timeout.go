@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"api/internal/models"
+)
+
+// passes a response through unless a server error is written after the request's deadline,
+// which is then replaced by a timeout problem
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	if status >= 500 && tw.ctx.Err() == context.DeadlineExceeded {
+		tw.timedOut = true
+		models.WriteError(tw.ResponseWriter, http.StatusServiceUnavailable, models.APIError{Code: models.ErrCodeTimeout, Message: "request took too long and was cancelled; try again"})
+		return
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.timedOut {
+		return len(b), nil
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// Timeout gives each request a deadline of d, which the database calls made with its context inherit,
+// so a slow query is cancelled instead of holding its connection. a server error written after the
+// deadline is most likely that cancelled query, so it is reported as 503 request_timeout instead.
+// requests for which skip returns true, such as streams meant to stay open, get no deadline
+func Timeout(d time.Duration, skip func(r *http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(&timeoutWriter{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx))
+		})
+	}
+}
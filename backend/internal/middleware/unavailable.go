@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// WriteServerError logs msg with err and args and answers 500, or 503 with Retry-After when err
+// is the database's circuit breaker failing the request fast.
+func WriteServerError(w http.ResponseWriter, r *http.Request, err error, msg string, args ...interface{}) {
+	var open *store.CircuitOpenError
+	if errors.As(err, &open) {
+		slog.WarnContext(r.Context(), msg, append([]interface{}{"err", err}, args...)...)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(open.RetryAfter.Seconds()))))
+		models.WriteError(w, http.StatusServiceUnavailable, models.APIError{Code: models.ErrCodeUnavailable, Message: "database unavailable, try again later"})
+		return
+	}
+	slog.ErrorContext(r.Context(), msg, append([]interface{}{"err", err}, args...)...)
+	models.WriteError(w, http.StatusInternalServerError, models.APIError{Code: models.ErrCodeInternal, Message: "internal server error"})
+}
@@ -0,0 +1,14 @@
+package middleware
+
+import "net/http"
+
+// ServerVersion sets X-Server-Version on every response, so the build that answered can be told
+// apart from others behind the same load balancer.
+func ServerVersion(version string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Server-Version", version)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
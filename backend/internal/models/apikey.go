@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// APIKey is a long-lived credential a machine client sends as "Authorization: ApiKey <key>".
+// the key itself is only returned once, when it is created
+type APIKey struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // the key's first characters, to tell keys apart
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
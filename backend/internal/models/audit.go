@@ -0,0 +1,59 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// actions recorded in the audit log
+const (
+	AuditUserCreated         = "user.created"
+	AuditUserUpdated         = "user.updated"
+	AuditUserDeleted         = "user.deleted"
+	AuditUserRestored        = "user.restored"
+	AuditUserErased          = "user.erased"
+	AuditUserMerged          = "user.merged" // recorded on both the target and the source, which it deletes
+	AuditAvatarChanged       = "user.avatar_changed"
+	AuditEmailVerified       = "user.email_verified"
+	AuditEmailChanged        = "user.email_changed" // once the new address is confirmed
+	AuditRoleChanged         = "user.role_changed"
+	AuditPasswordChanged     = "user.password_changed"
+	AuditPasswordReset       = "user.password_reset"
+	AuditTOTPEnabled         = "user.totp_enabled"
+	AuditTOTPDisabled        = "user.totp_disabled"
+	AuditBackupCodesReplaced = "user.backup_codes_replaced"
+	AuditAPIKeyCreated       = "user.api_key_created"
+	AuditAPIKeyRevoked       = "user.api_key_revoked"
+	AuditLockedOut           = "user.locked_out"
+	AuditUnlocked            = "user.unlocked"
+	AuditIdentityLinked      = "user.identity_linked"
+	AuditImpersonated        = "user.impersonated" // the actor is the admin who started impersonating the user
+	AuditImpersonationEnded  = "user.impersonation_ended"
+)
+
+// AuditEntityUser is the entity type of entries about a user
+const AuditEntityUser = "user"
+
+// one change in the audit log. Before and After are JSON snapshots of the entity, null when it
+// didn't exist yet or when there is nothing worth keeping, such as a password hash
+type AuditEntry struct {
+	Id      int  `json:"id"`
+	ActorId *int `json:"actor_id"` // null when nobody was signed in, e.g. for a password reset
+	// the admin who made the change while impersonating the actor, null for changes made by the actor themselves
+	ImpersonatorId *int            `json:"impersonator_id"`
+	Action         string          `json:"action"`
+	EntityType     string          `json:"entity_type"`
+	EntityId       int             `json:"entity_id"`
+	Before         json.RawMessage `json:"before"`
+	After          json.RawMessage `json:"after"`
+	RequestId      string          `json:"request_id"`
+	IP             string          `json:"ip"` // of the client, empty when the change wasn't made by a request
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// criteria for listing the audit log; zero fields match everything
+type AuditFilter struct {
+	EntityType string
+	EntityId   int
+	ActorId    int
+}
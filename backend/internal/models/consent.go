@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// documents users consent to, as named in LegalDocument and Consent
+const (
+	DocumentTerms   = "terms"
+	DocumentPrivacy = "privacy"
+)
+
+// LegalDocument is the version of a document users are asked to accept, such as the terms of
+// service.
+type LegalDocument struct {
+	Document string `json:"document"` // DocumentTerms or DocumentPrivacy
+	Version  string `json:"version"`
+	URL      string `json:"url,omitempty"` // where the document can be read
+}
+
+// Consent records a user accepting a version of a LegalDocument.
+type Consent struct {
+	Id         int       `json:"id"`
+	UserId     int       `json:"user_id"`
+	Document   string    `json:"document"`
+	Version    string    `json:"version"`
+	AcceptedAt time.Time `json:"accepted_at"`
+	IP         string    `json:"ip"` // empty once the user is erased
+	UserAgent  string    `json:"user_agent"`
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"api/internal/codec"
+	"api/internal/i18n"
+)
+
+// error codes returned in the "code" field of an error response
+const (
+	ErrCodeValidationFailed     = "validation_failed"
+	ErrCodeBadRequest           = "bad_request"
+	ErrCodeUnauthorized         = "unauthorized"
+	ErrCodeForbidden            = "forbidden"
+	ErrCodeNotFound             = "not_found"
+	ErrCodeMethodNotAllowed     = "method_not_allowed"
+	ErrCodeConflict             = "conflict"
+	ErrCodePreconditionFailed   = "precondition_failed"
+	ErrCodePreconditionRequired = "precondition_required"
+	ErrCodePayloadTooLarge      = "payload_too_large"
+	ErrCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrCodeRateLimited          = "rate_limited"
+	ErrCodeAccountLocked        = "account_locked"
+	ErrCodeConsentRequired      = "consent_required"
+	ErrCodeTimeout              = "request_timeout"
+	ErrCodeInternal             = "internal_error"
+	ErrCodeUnavailable          = "service_unavailable"
+)
+
+// APIError describes a failed request. responses render it as an RFC 7807 problem, see WriteError
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func (e APIError) Error() string {
+	return e.Message
+}
+
+// APIError doubles as a GraphQL error; its code and fields are reported as extensions
+func (e APIError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if len(e.Fields) > 0 {
+		ext["fields"] = e.Fields
+	}
+	return ext
+}
+
+// ProblemContentType is the media type of error responses.
+const ProblemContentType = "application/problem+json"
+
+// ProblemXMLContentType is the media type of error responses to clients that asked for XML.
+const ProblemXMLContentType = "application/problem+xml"
+
+// root element of problems rendered as XML, per RFC 7807 appendix A
+var problemXMLName = xml.Name{Space: "urn:ietf:rfc:7807", Local: "problem"}
+
+// an RFC 7807 problem details object, extended with the error code and per-field messages
+type problem struct {
+	Type     string            `json:"type"`
+	Title    string            `json:"title"`
+	Status   int               `json:"status"`
+	Detail   string            `json:"detail,omitempty"`
+	Instance string            `json:"instance,omitempty"`
+	Code     string            `json:"code"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// write apiErr as an application/problem+json response with the given status code, or in XML,
+// MessagePack or as JSON:API errors when the client negotiated those. codes already tell problems
+// apart, so the type is about:blank and the title is the status text. the instance names the
+// request by the X-Request-ID already set on the response, so a report can be found in the logs.
+// the title, message and field messages are translated into the Content-Language already set on
+// the response when there is one
+func WriteError(w http.ResponseWriter, status int, apiErr APIError) {
+	lang := w.Header().Get("Content-Language")
+	apiErr = localize(lang, apiErr)
+	if w.Header().Get("Content-Type") == codec.JSONAPI {
+		writeJSONAPIErrors(w, status, lang, apiErr)
+		return
+	}
+	p := problem{
+		Type:   "about:blank",
+		Title:  i18n.Translate(lang, http.StatusText(status)),
+		Status: status,
+		Detail: apiErr.Message,
+		Code:   apiErr.Code,
+		Fields: apiErr.Fields,
+	}
+	if id := w.Header().Get("X-Request-ID"); id != "" {
+		p.Instance = "urn:request:" + id
+	}
+	format := codec.Format(w.Header().Get("Content-Type"))
+	switch format {
+	case codec.XML:
+		w.Header().Set("Content-Type", ProblemXMLContentType)
+	case codec.MsgPack:
+		w.Header().Set("Content-Type", codec.MsgPack)
+	default:
+		w.Header().Set("Content-Type", ProblemContentType)
+	}
+	w.WriteHeader(status)
+	codec.Encode(w, format, problemXMLName, p)
+}
+
+// apiErr with its messages in lang; the code and field names stay as they are, for clients to match on
+func localize(lang string, apiErr APIError) APIError {
+	if lang == "" {
+		return apiErr
+	}
+	apiErr.Message = i18n.Translate(lang, apiErr.Message)
+	if len(apiErr.Fields) > 0 {
+		fields := make(map[string]string, len(apiErr.Fields))
+		for field, msg := range apiErr.Fields {
+			fields[field] = i18n.Translate(lang, msg)
+		}
+		apiErr.Fields = fields
+	}
+	return apiErr
+}
+
+// a JSON:API error object
+type jsonAPIError struct {
+	Status string              `json:"status"`
+	Code   string              `json:"code"`
+	Title  string              `json:"title"`
+	Detail string              `json:"detail,omitempty"`
+	Source *jsonAPIErrorSource `json:"source,omitempty"`
+}
+
+// what a JSON:API error is about: a member of the request document or a query parameter
+type jsonAPIErrorSource struct {
+	Pointer   string `json:"pointer,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+}
+
+// write apiErr as a JSON:API errors document, one error per field when it has any. fields of a
+// bad request are usually query parameters; the rest are attributes of the resource sent
+func writeJSONAPIErrors(w http.ResponseWriter, status int, lang string, apiErr APIError) {
+	base := jsonAPIError{Status: strconv.Itoa(status), Code: apiErr.Code, Title: i18n.Translate(lang, http.StatusText(status)), Detail: apiErr.Message}
+	errs := []jsonAPIError{base}
+	if len(apiErr.Fields) > 0 {
+		errs = errs[:0]
+		for _, field := range slices.Sorted(maps.Keys(apiErr.Fields)) {
+			e := base
+			e.Detail = apiErr.Fields[field]
+			if apiErr.Code == ErrCodeBadRequest {
+				e.Source = &jsonAPIErrorSource{Parameter: field}
+			} else {
+				e.Source = &jsonAPIErrorSource{Pointer: "/data/attributes/" + strings.ReplaceAll(field, ".", "/")}
+			}
+			errs = append(errs, e)
+		}
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+}
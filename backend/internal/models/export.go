@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// states of a data export or user export
+const (
+	ExportPending = "pending" // being generated
+	ExportReady   = "ready"   // its archive can be downloaded
+	ExportFailed  = "failed"  // every attempt to generate it failed
+)
+
+// formats a data export can be downloaded in: one JSON document, or a ZIP of a JSON file per section
+const (
+	ExportJSON = "json"
+	ExportZIP  = "zip"
+)
+
+// ExportCSV is the other format a user export comes in, besides an ExportJSON array of users.
+const ExportCSV = "csv"
+
+// UserExport is a file of the users matching a filter, generated in the background for the user
+// who asked for it.
+type UserExport struct {
+	Id          int        `json:"id"`
+	RequestedBy int        `json:"requested_by"`
+	Format      string     `json:"format"`
+	Status      string     `json:"status"`
+	Exported    int        `json:"exported"` // users written so far
+	Total       int        `json:"total"`    // users matching, counted once the job starts
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	ExpiresAt   time.Time  `json:"expires_at"` // after which it is gone
+	JobId       *int64     `json:"-"`
+}
+
+// DataExport is a copy of everything held about a user, generated in the background.
+type DataExport struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	Format     string     `json:"format"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	ExpiresAt  time.Time  `json:"expires_at"` // after which it is gone
+	JobId      *int64     `json:"-"`
+}
+
+// UserData is everything held about a user, as handed to them by a data export. The
+// sections of features the server doesn't run are left out.
+type UserData struct {
+	ExportedAt    time.Time               `json:"exported_at"`
+	User          User                    `json:"user"`
+	Settings      Settings                `json:"settings"`
+	Preferences   NotificationPreferences `json:"notification_preferences"`
+	Tags          []string                `json:"tags"`
+	Sessions      []Session               `json:"sessions"`
+	Logins        []LoginEvent            `json:"logins"`
+	APIKeys       []APIKey                `json:"api_keys"`
+	Revisions     []UserRevision          `json:"revisions"`
+	Organizations []Organization          `json:"organizations,omitempty"`
+	Posts         []Post                  `json:"posts,omitempty"`
+	Audit         []AuditEntry            `json:"audit,omitempty"` // changes made to the user
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// FeatureFlag gates a feature of the frontend. while enabled it is on for the users and roles it
+// targets, then for Rollout percent of everyone else, picked by a hash of the key and the user's
+// id so each user keeps their answer as the rollout grows
+type FeatureFlag struct {
+	Key         string    `json:"key"`
+	Description string    `json:"description"`
+	Enabled     bool      `json:"enabled"` // off for everyone when false, targets included
+	Rollout     int       `json:"rollout"` // percent of users not targeted who get it, 0 to 100
+	Users       []int     `json:"users"`   // ids of users who get it
+	Roles       []string  `json:"roles"`   // roles whose users get it
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// EnabledFor reports whether the flag is on for u.
+func (f FeatureFlag) EnabledFor(u User) bool {
+	if !f.Enabled {
+		return false
+	}
+	if slices.Contains(f.Users, u.Id) || slices.Contains(f.Roles, u.Role) {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(f.Key + ":" + strconv.Itoa(u.Id)))
+	return int(h.Sum32()%100) < f.Rollout
+}
@@ -0,0 +1,16 @@
+package models
+
+import "net/http"
+
+// IdempotentResponse is a response stored against an Idempotency-Key, replayed when the request is retried
+type IdempotentResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// IdempotencyRecord is a key that has already been used
+type IdempotencyRecord struct {
+	RequestHash []byte              // of the request that first used the key
+	Response    *IdempotentResponse // nil while that request is still being handled
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Impersonation is an admin acting as a user to support them, with a token of its own that stops
+// working once it expires or is ended.
+type Impersonation struct {
+	Id        int        `json:"id"`
+	AdminId   int        `json:"admin_id"`
+	UserId    int        `json:"user_id"`
+	Reason    string     `json:"reason"`
+	StartedAt time.Time  `json:"started_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	EndedAt   *time.Time `json:"ended_at"` // null until it is ended
+}
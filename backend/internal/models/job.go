@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// states of a background job
+const (
+	JobPending   = "pending"   // waiting for its first or next attempt
+	JobSucceeded = "succeeded" // its handler returned without error
+	JobFailed    = "failed"    // every attempt failed, or no handler knows its kind
+)
+
+// JobStatuses are the states jobs can be listed by.
+var JobStatuses = []string{JobPending, JobSucceeded, JobFailed}
+
+// Job is a unit of background work, such as sending an email, and how far it has got.
+type Job struct {
+	Id          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"-"` // can carry secrets, such as the link in a password reset email
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       *time.Time      `json:"run_at"` // when the next attempt is due, null once it succeeded or failed
+	LastError   *string         `json:"last_error"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FinishedAt  *time.Time      `json:"finished_at"`
+}
+
+// criteria for listing jobs; empty fields match every job
+type JobFilter struct {
+	Status string
+	Kind   string
+}
+
+// the outcome of one attempt at a job: succeeded, pending again until RunAt, or failed for good
+type JobAttempt struct {
+	Status string
+	Error  string // why the attempt failed, empty when it succeeded
+	RunAt  time.Time
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// how a login attempt authenticated
+const (
+	LoginMethodPassword  = "password"
+	LoginMethodTwoFactor = "two_factor" // the TOTP or backup code that finishes a login with 2FA on
+	LoginMethodOAuth     = "oauth"      // a sign-in at Google, GitHub or another OAuth provider
+	LoginMethodDirectory = "ldap"       // a password checked against the LDAP directory
+)
+
+// why a login attempt failed
+const (
+	LoginFailedUnknownEmail = "unknown_email"
+	LoginFailedPassword     = "invalid_password"
+	LoginFailedCode         = "invalid_code"
+	LoginFailedLocked       = "account_locked" // tried while locked out after too many failures
+)
+
+// LoginEvent is one attempt to log in.
+type LoginEvent struct {
+	Id            int64     `json:"id"`
+	UserId        *int      `json:"-"` // nil when the email belongs to nobody
+	Email         string    `json:"email"`
+	Method        string    `json:"method"`
+	Success       bool      `json:"success"`
+	FailureReason *string   `json:"failure_reason"` // one of the LoginFailed reasons, null on success
+	Device        string    `json:"device"`         // a description such as "Firefox on Windows", from UserAgent
+	UserAgent     string    `json:"user_agent"`
+	IP            string    `json:"ip"`
+	CreatedAt     time.Time `json:"created_at"`
+}
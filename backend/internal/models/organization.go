@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// roles a member can have in an organization, from most to least able
+const (
+	OrgRoleOwner  = "owner"  // everything an admin can, plus managing owners and deleting the organization
+	OrgRoleAdmin  = "admin"  // rename the organization and manage its members
+	OrgRoleMember = "member" // see the organization and its members
+)
+
+// OrgRoles are the organization roles, most able first.
+var OrgRoles = []string{OrgRoleOwner, OrgRoleAdmin, OrgRoleMember}
+
+// OrgRoleAtLeast reports whether role can do everything min can.
+func OrgRoleAtLeast(role, min string) bool {
+	for _, r := range OrgRoles {
+		if r == role {
+			return true
+		}
+		if r == min {
+			return false
+		}
+	}
+	return false
+}
+
+// Organization is a group of users within a tenant, such as a customer's company.
+type Organization struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// the caller's role in it, in the list of their own organizations
+	Role string `json:"role,omitempty"`
+}
+
+// Member is a user's membership of an organization.
+type Member struct {
+	UserId   int       `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Invitation is an offer, emailed to an address, to join an organization with a role. It can be
+// accepted or declined once, before it expires.
+type Invitation struct {
+	Id        int       `json:"id"`
+	OrgId     int       `json:"org_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy *int      `json:"invited_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// a committed change recorded as an event in the outbox, for the relay to publish. Payload is a
+// snapshot of the entity after the change, such as a user in its v1 shape
+type OutboxEvent struct {
+	Id         int64           `json:"id"`
+	Event      string          `json:"event"`
+	EntityType string          `json:"entity_type"`
+	EntityId   int             `json:"entity_id"`
+	TenantId   int             `json:"tenant_id"` // the tenant of the entity
+	Payload    json.RawMessage `json:"payload"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Post is something a user wrote.
+type Post struct {
+	Id        int       `json:"id"`
+	AuthorId  int       `json:"author_id"`
+	Title     string    `json:"title" validate:"required,max=200"`
+	Body      string    `json:"body" validate:"required,max=20000"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Author    Author    `json:"author"` // loaded along with the post
+}
+
+// Author is the user who wrote something, as much of them as is shown alongside it.
+type Author struct {
+	Id        int     `json:"id"`
+	Name      string  `json:"name"`
+	AvatarURL *string `json:"avatar_url"`
+}
+
+// Comment is what a user wrote in reply to a post.
+type Comment struct {
+	Id        int       `json:"id"`
+	PostId    int       `json:"post_id"`
+	AuthorId  int       `json:"author_id"`
+	Body      string    `json:"body" validate:"required,max=5000"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Author    Author    `json:"author"` // loaded along with the comment
+}
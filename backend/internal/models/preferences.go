@@ -0,0 +1,28 @@
+package models
+
+// NotificationPreferences are the optional emails a user has chosen to receive. email they need,
+// such as verification links and password resets, is sent regardless
+type NotificationPreferences struct {
+	ProfileChanges bool `json:"profile_changes"` // an email whenever their account details change
+	ProductUpdates bool `json:"product_updates"` // news about the product
+}
+
+// categories of optional email, one for each of NotificationPreferences
+const (
+	NotifyProfileChanges = "profile_changes"
+	NotifyProductUpdates = "product_updates"
+)
+
+// DefaultNotificationPreferences apply until a user sets their own.
+var DefaultNotificationPreferences = NotificationPreferences{ProfileChanges: true}
+
+// Allows reports whether email in category may be sent; email outside the categories always may.
+func (p NotificationPreferences) Allows(category string) bool {
+	switch category {
+	case NotifyProfileChanges:
+		return p.ProfileChanges
+	case NotifyProductUpdates:
+		return p.ProductUpdates
+	}
+	return true
+}
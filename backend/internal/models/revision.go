@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// one version of a user, numbered from 1
+type UserRevision struct {
+	Rev       int       `json:"rev"`
+	CreatedAt time.Time `json:"created_at"`
+	User      User      `json:"user"`
+}
+
+// a field that differs between two revisions, by its JSON name
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// what changed in a revision since the one before it; PreviousRev is null for the first
+type RevisionDiff struct {
+	Rev         int           `json:"rev"`
+	PreviousRev *int          `json:"previous_rev"`
+	Changes     []FieldChange `json:"changes"`
+}
@@ -0,0 +1,27 @@
+package models
+
+// permissions a role can grant, checked per route
+const (
+	PermUsersRead      = "users:read"        // list, search, export and watch users
+	PermUsersWrite     = "users:write"       // create users and edit anyone's profile
+	PermUsersDelete    = "users:delete"      // delete and restore users
+	PermRolesManage    = "roles:manage"      // list roles and assign them to users
+	PermAuditRead      = "audit:read"        // read the audit log
+	PermAdminRead      = "admin:read"        // read the admin overview of users, activity and system health
+	PermWebhooksManage = "webhooks:manage"   // subscribe URLs to user events and inspect their deliveries
+	PermOrgsManage     = "orgs:manage"       // act as an owner of every organization
+	PermPostsManage    = "posts:manage"      // edit and delete anyone's posts
+	PermFlagsManage    = "flags:manage"      // create and change feature flags and see anyone's
+	PermImpersonate    = "users:impersonate" // act as users other than admins to support them
+)
+
+// built-in roles; new users get RoleUser, except the very first, who gets RoleAdmin
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
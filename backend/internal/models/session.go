@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Session is one login on one device, kept alive by its refresh token
+type Session struct {
+	Id         int       `json:"id"`
+	Device     string    `json:"device"` // a description such as "Firefox on Windows", from UserAgent
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"` // last time the session was refreshed
+	Current    bool      `json:"current"`      // whether this is the session making the request
+}
+
+// where a session was started or last refreshed from
+type SessionClient struct {
+	UserAgent string
+	IP        string
+}
@@ -0,0 +1,5 @@
+package models
+
+// Settings are a user's UI preferences, as the frontend saves them: a JSON object whose known keys
+// are checked, see handlers.validateSettings, and whose other keys are kept as given.
+type Settings map[string]interface{}
@@ -0,0 +1,7 @@
+package models
+
+// Tag is a label put on users to segment them, such as beta or vip.
+type Tag struct {
+	Name  string `json:"name"`
+	Users int    `json:"users"` // how many live users have it
+}
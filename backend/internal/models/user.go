@@ -0,0 +1,185 @@
+// Package models holds the types shared by the store, the handlers and the wire formats.
+package models
+
+import "time"
+
+// the validate tags are the checks every write applies, see handlers.validateUser
+type User struct {
+	Id              int        `json:"id"`
+	Name            string     `json:"name" validate:"required,max=100"`
+	Username        string     `json:"username" validate:"omitempty,max=40,username"` // derived from the name when left empty
+	Email           string     `json:"email" validate:"required,email,max=254"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+	AvatarURL       *string    `json:"avatar_url"`                      // null until an avatar is uploaded
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`               // null until confirmed; changing the email clears it
+	Role            string     `json:"role"`                            // grants the user's permissions, see Role
+	Phone           *string    `json:"phone" validate:"omitempty,e164"` // in E.164, such as +14155550123
+	Bio             *string    `json:"bio" validate:"omitempty,max=500"`
+	Timezone        *string    `json:"timezone" validate:"omitempty,timezone"`         // an IANA name such as Europe/Paris
+	Locale          *string    `json:"locale" validate:"omitempty,bcp47_language_tag"` // a BCP 47 tag such as pt-BR
+	Version         int        `json:"version"`                                        // bumped on every change, for optimistic concurrency
+	PasswordHash    string     `json:"-"`                                              // never serialized
+}
+
+// criteria for listing users, shared by the REST, GraphQL and gRPC APIs
+type UserFilter struct {
+	EmailContains  string
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	IncludeDeleted bool
+	Tag            string // only users with this tag
+	// Fields limits listings to reading these of SelectableUserFields, along with id, and created_at
+	// for keyset pages; the rest are left zero. nil reads every field
+	Fields []string
+}
+
+// fields a listing can be limited to, named as in a v1 user and as columns alike
+var SelectableUserFields = []string{"id", "name", "username", "email", "created_at", "updated_at", "deleted_at", "avatar_url", "email_verified_at", "role", "phone", "bio", "timezone", "locale", "version"}
+
+// fields users can be sorted by, besides the default id
+var SortableUserFields = []string{"name", "email", "created_at"}
+
+// order for a user listing; the zero value is id ascending
+type UserSort struct {
+	Field string // one of SortableUserFields, or "" for id
+	Desc  bool
+}
+
+// position in the (created_at, id) ordering used by keyset pagination
+type UserCursor struct {
+	CreatedAt time.Time `json:"t"`
+	Id        int       `json:"id"`
+}
+
+// position in the (updated_at, id) ordering of changes, where a delta sync resumes
+type SyncCursor struct {
+	ChangedAt time.Time `json:"t"`
+	Id        int       `json:"id"`
+}
+
+// a user deleted since a sync, soft or for good, which clients should drop
+type UserTombstone struct {
+	Id        int       `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// what changed since a SyncCursor, in order: users created or updated and users deleted
+type UserChanges struct {
+	Users   []User
+	Deleted []UserTombstone
+	Next    SyncCursor // where the next sync resumes
+	More    bool       // whether changes past Next are already waiting
+}
+
+// counts of live users for the admin dashboard
+type UserStats struct {
+	Total          int                     `json:"total"`
+	ByVerification UserStatsByVerification `json:"by_verification"`
+	ByRole         map[string]int          `json:"by_role"` // every role, including those nobody has
+	Created        UserStatsCreated        `json:"created"`
+}
+
+type UserStatsByVerification struct {
+	Verified   int `json:"verified"`
+	Unverified int `json:"unverified"`
+}
+
+// users created within each window up to now
+type UserStatsCreated struct {
+	Last24h int `json:"last_24h"`
+	Last7d  int `json:"last_7d"`
+	Last30d int `json:"last_30d"`
+}
+
+// units signups can be bucketed by, as Postgres date_trunc names them
+var SignupGroupings = []string{"day", "week", "month"}
+
+// users created within one bucket of a signup time series, which starts at Start in UTC
+type SignupBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// a search hit with its relevance score
+type SearchResult struct {
+	User
+	Rank float64 `json:"rank"`
+}
+
+// kinds of user change events
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+)
+
+// a change to a user, as broadcast to live subscribers; ids increase monotonically per process
+type UserEvent struct {
+	ID   int64     `json:"id"`
+	Type string    `json:"type"`
+	User User      `json:"user"`
+	At   time.Time `json:"at"`
+}
+
+// a user as represented by API v2, which groups the fields describing the person under
+// profile. v1 keeps the flat User, so each version can evolve without breaking the other
+type UserV2 struct {
+	Id              int         `json:"id"`
+	Username        string      `json:"username"`
+	Email           string      `json:"email"`
+	Profile         UserProfile `json:"profile"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+	DeletedAt       *time.Time  `json:"deleted_at,omitempty"`
+	EmailVerifiedAt *time.Time  `json:"email_verified_at"`
+	Role            string      `json:"role"`
+	Version         int         `json:"version"`
+}
+
+type UserProfile struct {
+	Name      string  `json:"name"`
+	AvatarURL *string `json:"avatar_url"`
+	Phone     *string `json:"phone"`
+	Bio       *string `json:"bio"`
+	Timezone  *string `json:"timezone"`
+	Locale    *string `json:"locale"`
+}
+
+// V2 converts u to its API v2 representation
+func (u User) V2() UserV2 {
+	return UserV2{
+		Id:              u.Id,
+		Username:        u.Username,
+		Email:           u.Email,
+		Profile:         UserProfile{Name: u.Name, AvatarURL: u.AvatarURL, Phone: u.Phone, Bio: u.Bio, Timezone: u.Timezone, Locale: u.Locale},
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+		DeletedAt:       u.DeletedAt,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		Role:            u.Role,
+		Version:         u.Version,
+	}
+}
+
+// User converts a v2 representation, such as a request body, back to a User
+func (u UserV2) User() User {
+	return User{
+		Id:              u.Id,
+		Name:            u.Profile.Name,
+		Username:        u.Username,
+		Email:           u.Email,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+		DeletedAt:       u.DeletedAt,
+		AvatarURL:       u.Profile.AvatarURL,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		Role:            u.Role,
+		Phone:           u.Profile.Phone,
+		Bio:             u.Profile.Bio,
+		Timezone:        u.Profile.Timezone,
+		Locale:          u.Profile.Locale,
+		Version:         u.Version,
+	}
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// events a webhook can subscribe to, the same as the change feed's
+var WebhookEvents = []string{EventUserCreated, EventUserUpdated, EventUserDeleted}
+
+// Webhook is an integrator's subscription of a URL to user events. the secret that signs its
+// deliveries is only returned once, when it is created
+type Webhook struct {
+	Id        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedBy *int      `json:"created_by"` // null once the creator is removed
+	CreatedAt time.Time `json:"created_at"`
+	Secret    string    `json:"-"`
+}
+
+// states of a webhook delivery
+const (
+	DeliveryPending   = "pending"   // waiting for its first or next attempt
+	DeliverySucceeded = "succeeded" // the receiver answered 2xx
+	DeliveryFailed    = "failed"    // every attempt failed
+)
+
+// one event sent, or still to be sent, to a webhook
+type WebhookDelivery struct {
+	Id             int64           `json:"id"`
+	WebhookId      int             `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"` // null once it succeeded or failed
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      *string         `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+// a delivery claimed for an attempt, with where to send it and the secret to sign it with
+type DueDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// the outcome of one delivery attempt: succeeded, pending again until NextAttemptAt, or failed for good
+type DeliveryAttempt struct {
+	Status        string
+	StatusCode    int    // 0 when no response arrived
+	Error         string // why the attempt failed, empty when it succeeded
+	NextAttemptAt time.Time
+}
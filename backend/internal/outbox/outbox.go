@@ -0,0 +1,83 @@
+// Package outbox relays the events recorded in the outbox to where they are published, such as
+// webhooks. events are written in the same transaction as the change they describe, so none is
+// lost to a crash and none is sent for a write that was rolled back.
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"api/internal/models"
+	"api/internal/store"
+	"api/internal/tenant"
+)
+
+// Publisher sends outbox events on, with the context scoped to the event's tenant. an event may be
+// published again if the relay stops before marking it, so publishing has to tolerate duplicates
+type Publisher interface {
+	Publish(ctx context.Context, e models.OutboxEvent) error
+}
+
+// how often the outbox is checked for new events, and how many are relayed at once
+const (
+	pollInterval = time.Second
+	batchSize    = 100
+)
+
+// how often published events older than the retention are deleted
+const pruneInterval = time.Hour
+
+// Relay publishes outbox events in order to every publisher, holding back later events while one
+// fails so none overtakes another.
+type Relay struct {
+	outbox     store.Outbox
+	publishers []Publisher
+	retention  time.Duration
+}
+
+// NewRelay relays events to publishers and keeps published events for retention. with no
+// publishers, events are only marked published and pruned.
+func NewRelay(outbox store.Outbox, retention time.Duration, publishers ...Publisher) *Relay {
+	return &Relay{outbox: outbox, publishers: publishers, retention: retention}
+}
+
+// Run relays events until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	var lastPrune time.Time
+	for {
+		// keep relaying while full batches come back, so a backlog drains without waiting on the ticker
+		for r.relay(ctx) == batchSize && ctx.Err() == nil {
+		}
+		if time.Since(lastPrune) >= pruneInterval {
+			lastPrune = time.Now()
+			if err := r.outbox.Prune(ctx, lastPrune.Add(-r.retention)); err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "prune outbox failed", "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay one batch, returning how many events were published
+func (r *Relay) relay(ctx context.Context) int {
+	n, err := r.outbox.Relay(ctx, batchSize, func(e models.OutboxEvent) error {
+		ctx := tenant.WithID(ctx, e.TenantId)
+		for _, p := range r.publishers {
+			if err := p.Publish(ctx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		slog.ErrorContext(ctx, "relay outbox events failed", "err", err, "published", n)
+	}
+	return n
+}
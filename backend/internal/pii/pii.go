@@ -0,0 +1,197 @@
+// Package pii encrypts personal data, such as email addresses and phone numbers, before it is
+// stored, so a copy of the database can't be read without the keys.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// an encrypted value is this prefix, the id of its key, a colon and base64 of its nonce and ciphertext
+const prefix = "pii:"
+
+// ErrUnknownKey is returned by Decrypt for a value encrypted with a key the Keyring doesn't have.
+var ErrUnknownKey = errors.New("pii: value encrypted with an unknown key")
+
+// Keyring encrypts with its current key, decrypts with any of its keys and hashes values for
+// lookups with its index key.
+//
+// Encryption is deterministic: each value's nonce is derived from the value, so a value written
+// again under the same key is stored unchanged, and only equal values share a ciphertext. A nil
+// *Keyring leaves values as they are, for servers that don't encrypt.
+type Keyring struct {
+	current string
+	keys    map[string]key
+	index   []byte
+}
+
+type key struct {
+	aead  cipher.AEAD
+	nonce []byte // HMAC key deriving the nonce of each value
+}
+
+// NewKeyring reads keys, comma-separated id=base64 pairs of 32-byte keys with the current one
+// first, and indexKey, the base64 key values are hashed with for lookups. the index key can't be
+// rotated, since hashes can only be computed again from the values.
+func NewKeyring(keys, indexKey string) (*Keyring, error) {
+	k := &Keyring{keys: map[string]key{}}
+	for _, pair := range strings.Split(keys, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("pii: key %q must be id=base64", pair)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("pii: key %q is given twice", id)
+		}
+		secret, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("pii: key %q: %w", id, err)
+		}
+		encryption, err := hkdf.Key(sha256.New, secret, nil, "pii encryption", 32)
+		if err != nil {
+			return nil, err
+		}
+		nonce, err := hkdf.Key(sha256.New, secret, nil, "pii nonce", 32)
+		if err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(encryption)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = key{aead: aead, nonce: nonce}
+		if k.current == "" {
+			k.current = id
+		}
+	}
+	index, err := decodeKey(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("pii: index key: %w", err)
+	}
+	k.index = index
+	return k, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.New("not valid base64")
+	}
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("must be 32 bytes, got %d", len(secret))
+	}
+	return secret, nil
+}
+
+// Encrypt seals s with the current key; the empty string stays empty.
+func (k *Keyring) Encrypt(s string) string {
+	if k == nil || s == "" {
+		return s
+	}
+	key := k.keys[k.current]
+	mac := hmac.New(sha256.New, key.nonce)
+	mac.Write([]byte(s))
+	nonce := mac.Sum(nil)[:key.aead.NonceSize()]
+	sealed := key.aead.Seal(nonce, nonce, []byte(s), nil)
+	return prefix + k.current + ":" + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// Decrypt opens a value sealed by Encrypt. values that aren't encrypted, written before encryption
+// was turned on, are returned as they are.
+func (k *Keyring) Decrypt(s string) (string, error) {
+	rest, ok := strings.CutPrefix(s, prefix)
+	if !ok {
+		return s, nil
+	}
+	if k == nil {
+		return "", ErrUnknownKey
+	}
+	id, encoded, _ := strings.Cut(rest, ":")
+	key, ok := k.keys[id]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return "", errors.New("pii: malformed encrypted value")
+	}
+	n := key.aead.NonceSize()
+	plain, err := key.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("pii: decrypt: %w", err)
+	}
+	return string(plain), nil
+}
+
+// Reencrypt seals a value, encrypted or not, with the current key.
+func (k *Keyring) Reencrypt(s string) (string, error) {
+	plain, err := k.Decrypt(s)
+	if err != nil {
+		return "", err
+	}
+	return k.Encrypt(plain), nil
+}
+
+// Index hashes s, compared case-insensitively, so rows can be looked up by it without storing it
+// in plain text. it is nil on a nil Keyring.
+func (k *Keyring) Index(s string) []byte {
+	if k == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte(strings.ToLower(s)))
+	return mac.Sum(nil)
+}
+
+// SealJSON encrypts the string members of a JSON object named in members, such as the email of a
+// user snapshot. anything other than an object is returned as it is.
+func (k *Keyring) SealJSON(b []byte, members ...string) ([]byte, error) {
+	if k == nil {
+		return b, nil
+	}
+	return mapJSON(b, members, func(s string) (string, error) { return k.Encrypt(s), nil })
+}
+
+// OpenJSON decrypts the members of a JSON object sealed by SealJSON.
+func (k *Keyring) OpenJSON(b []byte, members ...string) ([]byte, error) {
+	return mapJSON(b, members, k.Decrypt)
+}
+
+// replace the string members of a JSON object named in members by fn
+func mapJSON(b []byte, members []string, fn func(string) (string, error)) ([]byte, error) {
+	var object map[string]json.RawMessage
+	if json.Unmarshal(b, &object) != nil || object == nil {
+		return b, nil
+	}
+	changed := false
+	for _, m := range members {
+		var s string
+		if json.Unmarshal(object[m], &s) != nil {
+			continue
+		}
+		mapped, err := fn(s)
+		if err != nil {
+			return nil, err
+		}
+		if mapped != s {
+			object[m], _ = json.Marshal(mapped)
+			changed = true
+		}
+	}
+	if !changed {
+		return b, nil
+	}
+	return json.Marshal(object)
+}
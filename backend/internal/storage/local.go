@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local keeps objects as files under a directory and serves them back through Handler.
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+var _ Storage = (*Local)(nil)
+
+// NewLocal stores files under dir, creating it if needed; baseURL is the path or URL Handler is mounted at.
+func NewLocal(dir, baseURL string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Local{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, contentType string) (string, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	// write to a temp file and rename so readers never see a partial upload
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return l.baseURL + "/" + key, nil
+}
+
+func (l *Local) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// keys are slash-separated and must stay inside dir
+func (l *Local) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(clean)), nil
+}
+
+// Handler serves stored files, with the mount prefix already stripped. directory listings are refused
+func (l *Local) Handler() http.Handler {
+	files := http.FileServer(http.Dir(l.dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		// let the file server sniff the type rather than inherit the API's JSON default
+		w.Header().Del("Content-Type")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3 keeps objects in an S3 (or S3-compatible) bucket that clients read from directly.
+type S3 struct {
+	client  *s3.Client
+	bucket  string
+	baseURL string
+}
+
+var _ Storage = (*S3)(nil)
+
+// NewS3 uses the standard AWS credential chain and region settings (AWS_REGION, AWS_ACCESS_KEY_ID,
+// shared config files and so on). baseURL is the public address of the bucket, e.g. a CDN in front
+// of it; when empty the bucket's virtual-hosted S3 URL is used
+func NewS3(ctx context.Context, bucket, baseURL string) (*S3, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if baseURL == "" {
+		if cfg.Region == "" {
+			return nil, fmt.Errorf("no AWS region configured for bucket %q", bucket)
+		}
+		baseURL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, cfg.Region)
+	}
+	return &S3{client: s3.NewFromConfig(cfg), bucket: bucket, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// a body that can't seek, such as a file being generated as it is read, is spooled to a temporary
+// file first, since a single PUT has to state its length up front
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, contentType string) (string, error) {
+	if _, ok := body.(io.Seeker); !ok {
+		spool, err := os.CreateTemp("", "s3-upload-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		if _, err := io.Copy(spool, body); err != nil {
+			return "", err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		body = spool
+	}
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+	return s.baseURL + "/" + key, nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	var missing *types.NoSuchKey
+	if errors.As(err, &missing) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// S3 deletes are idempotent, so a missing key succeeds too
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
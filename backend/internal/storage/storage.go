@@ -0,0 +1,22 @@
+// Package storage keeps uploaded files, such as avatars, on local disk or in S3.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned by Open when there is no object under the key.
+var ErrNotFound = errors.New("object not found")
+
+// Storage stores uploaded objects under caller-chosen keys.
+type Storage interface {
+	// store body under key, replacing any existing object, and return a URL clients can fetch it from
+	Put(ctx context.Context, key string, body io.Reader, contentType string) (string, error)
+	// read the object under key back, for files served through the API rather than from the URL;
+	// ErrNotFound if there is none
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// remove the object under key; there being none is not an error
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,392 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"api/internal/models"
+	"api/internal/pii"
+	"api/internal/tenant"
+)
+
+// AuditLog keeps the record of changes written by AuditedUserRepository, apart for each tenant.
+type AuditLog interface {
+	Record(ctx context.Context, e models.AuditEntry) error
+	// entries matching f, newest first, along with the total number of matches
+	List(ctx context.Context, f models.AuditFilter, limit, offset int) ([]models.AuditEntry, int, error)
+}
+
+// PostgresAuditLog is an AuditLog backed by the audit_log table.
+type PostgresAuditLog struct {
+	db  *sql.DB
+	pii *pii.Keyring
+}
+
+var _ AuditLog = (*PostgresAuditLog)(nil)
+
+// NewPostgresAuditLog encrypts the emails and phone numbers in snapshots with keys, those of the
+// users table, which is nil when they are stored in plain text.
+func NewPostgresAuditLog(db *sql.DB, keys *pii.Keyring) *PostgresAuditLog {
+	return &PostgresAuditLog{db: db, pii: keys}
+}
+
+// nil snapshots are stored as SQL NULL rather than JSON null
+func nullableJSON(b json.RawMessage) interface{} {
+	if b == nil {
+		return nil
+	}
+	return []byte(b)
+}
+
+func (l *PostgresAuditLog) Record(ctx context.Context, e models.AuditEntry) error {
+	var err error
+	if e.Before, err = l.pii.SealJSON(e.Before, piiMembers...); err != nil {
+		return err
+	}
+	if e.After, err = l.pii.SealJSON(e.After, piiMembers...); err != nil {
+		return err
+	}
+	_, err = l.db.ExecContext(ctx, `INSERT INTO audit_log (tenant_id, actor_id, action, entity_type, entity_id, before, after, request_id, ip, impersonator_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`, tenant.ID(ctx), e.ActorId, e.Action, e.EntityType, e.EntityId, nullableJSON(e.Before), nullableJSON(e.After), e.RequestId, e.IP, e.ImpersonatorId)
+	return err
+}
+
+func (l *PostgresAuditLog) List(ctx context.Context, f models.AuditFilter, limit, offset int) ([]models.AuditEntry, int, error) {
+	q := &userQuery{dialect: postgresDialect}
+	q.conds = append(q.conds, "tenant_id = "+q.bind(tenant.ID(ctx)))
+	if f.EntityType != "" {
+		q.conds = append(q.conds, "entity_type = "+q.bind(f.EntityType))
+	}
+	if f.EntityId != 0 {
+		q.conds = append(q.conds, "entity_id = "+q.bind(f.EntityId))
+	}
+	if f.ActorId != 0 {
+		q.conds = append(q.conds, "actor_id = "+q.bind(f.ActorId))
+	}
+
+	var total int
+	if err := l.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log"+q.where(), q.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := l.db.QueryContext(ctx, "SELECT id, actor_id, impersonator_id, action, entity_type, entity_id, before, after, request_id, ip, created_at FROM audit_log"+
+		q.where()+" ORDER BY created_at DESC, id DESC LIMIT "+q.bind(limit)+" OFFSET "+q.bind(offset), q.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var (
+			e             models.AuditEntry
+			before, after []byte
+		)
+		if err := rows.Scan(&e.Id, &e.ActorId, &e.ImpersonatorId, &e.Action, &e.EntityType, &e.EntityId, &before, &after, &e.RequestId, &e.IP, &e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		var err error
+		if before != nil {
+			if e.Before, err = l.pii.OpenJSON(before, piiMembers...); err != nil {
+				return nil, 0, err
+			}
+		}
+		if after != nil {
+			if e.After, err = l.pii.OpenJSON(after, piiMembers...); err != nil {
+				return nil, 0, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+// AuditContext reports who is making a change, in which request and from which address, from the
+// request's context. actorID is zero when nobody is signed in, impersonatorID unless an admin is
+// impersonating the actor
+type AuditContext func(ctx context.Context) (actorID, impersonatorID int, requestID, ip string)
+
+// AuditedUserRepository records every successful write to the wrapped UserRepository in an AuditLog,
+// with snapshots of the user before and after. entries are written after the change, so a failure
+// to record one is logged rather than undoing it
+type AuditedUserRepository struct {
+	UserRepository // reads and bookkeeping such as TOTP steps pass straight through
+	log            AuditLog
+	who            AuditContext
+}
+
+var _ UserRepository = (*AuditedUserRepository)(nil)
+
+func NewAuditedUserRepository(next UserRepository, log AuditLog, who AuditContext) *AuditedUserRepository {
+	return &AuditedUserRepository{UserRepository: next, log: log, who: who}
+}
+
+// encode a snapshot, or nil for no value
+func snapshot(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (r *AuditedUserRepository) record(ctx context.Context, action string, userID int, before, after interface{}) {
+	actorID, impersonatorID, requestID, ip := r.who(ctx)
+	e := models.AuditEntry{
+		Action:     action,
+		EntityType: models.AuditEntityUser,
+		EntityId:   userID,
+		Before:     snapshot(before),
+		After:      snapshot(after),
+		RequestId:  requestID,
+		IP:         ip,
+	}
+	if actorID != 0 {
+		e.ActorId = &actorID
+	}
+	if impersonatorID != 0 {
+		e.ImpersonatorId = &impersonatorID
+	}
+	if err := r.log.Record(ctx, e); err != nil {
+		slog.ErrorContext(ctx, "write audit entry failed", "err", err, "action", action, "user_id", userID)
+	}
+}
+
+// the user as it is now, including when soft-deleted, or nil if it can't be read
+func (r *AuditedUserRepository) before(ctx context.Context, id int) interface{} {
+	u, err := r.UserRepository.Get(ctx, id, true)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+func (r *AuditedUserRepository) Create(ctx context.Context, u models.User) (models.User, error) {
+	u, err := r.UserRepository.Create(ctx, u)
+	if err == nil {
+		r.record(ctx, models.AuditUserCreated, u.Id, nil, u)
+	}
+	return u, err
+}
+
+func (r *AuditedUserRepository) CreateMany(ctx context.Context, users []models.User) ([]models.User, error) {
+	created, err := r.UserRepository.CreateMany(ctx, users)
+	if err == nil {
+		for _, u := range created {
+			r.record(ctx, models.AuditUserCreated, u.Id, nil, u)
+		}
+	}
+	return created, err
+}
+
+func (r *AuditedUserRepository) Upsert(ctx context.Context, u models.User) (models.User, bool, error) {
+	var before interface{}
+	if existing, err := r.UserRepository.GetByEmail(ctx, u.Email); err == nil {
+		before = existing
+	}
+	u, created, err := r.UserRepository.Upsert(ctx, u)
+	if err == nil && created {
+		r.record(ctx, models.AuditUserCreated, u.Id, nil, u)
+	} else if err == nil {
+		r.record(ctx, models.AuditUserUpdated, u.Id, before, u)
+	}
+	return u, created, err
+}
+
+func (r *AuditedUserRepository) Update(ctx context.Context, id int, u models.User, version int) (models.User, error) {
+	before := r.before(ctx, id)
+	u, err := r.UserRepository.Update(ctx, id, u, version)
+	if err == nil {
+		r.record(ctx, models.AuditUserUpdated, id, before, u)
+	}
+	return u, err
+}
+
+func (r *AuditedUserRepository) Delete(ctx context.Context, id int) (models.User, error) {
+	before := r.before(ctx, id)
+	u, err := r.UserRepository.Delete(ctx, id)
+	if err == nil {
+		r.record(ctx, models.AuditUserDeleted, id, before, u)
+	}
+	return u, err
+}
+
+func (r *AuditedUserRepository) DeleteMatching(ctx context.Context, ids []int64, createdBefore *time.Time) ([]models.User, error) {
+	deleted, err := r.UserRepository.DeleteMatching(ctx, ids, createdBefore)
+	if err == nil {
+		for _, u := range deleted {
+			// only live users are deleted, so each was the same row without deleted_at
+			before := u
+			before.DeletedAt = nil
+			r.record(ctx, models.AuditUserDeleted, u.Id, before, u)
+		}
+	}
+	return deleted, err
+}
+
+// the entry says who erased the user and when, but keeps no snapshot of them
+func (r *AuditedUserRepository) Erase(ctx context.Context, id int) (models.User, error) {
+	u, err := r.UserRepository.Erase(ctx, id)
+	if err == nil {
+		r.record(ctx, models.AuditUserErased, id, nil, nil)
+	}
+	return u, err
+}
+
+func (r *AuditedUserRepository) Merge(ctx context.Context, id, sourceID int) (models.User, error) {
+	before, source := r.before(ctx, id), r.before(ctx, sourceID)
+	u, err := r.UserRepository.Merge(ctx, id, sourceID)
+	if err == nil {
+		r.record(ctx, models.AuditUserMerged, id, before, u)
+		r.record(ctx, models.AuditUserMerged, sourceID, source, r.before(ctx, sourceID))
+	}
+	return u, err
+}
+
+func (r *AuditedUserRepository) Restore(ctx context.Context, id int) (models.User, error) {
+	before := r.before(ctx, id)
+	u, err := r.UserRepository.Restore(ctx, id)
+	if err == nil {
+		r.record(ctx, models.AuditUserRestored, id, before, u)
+	}
+	return u, err
+}
+
+func (r *AuditedUserRepository) SetAvatarURL(ctx context.Context, id int, url string) (models.User, error) {
+	before := r.before(ctx, id)
+	u, err := r.UserRepository.SetAvatarURL(ctx, id, url)
+	if err == nil {
+		r.record(ctx, models.AuditAvatarChanged, id, before, u)
+	}
+	return u, err
+}
+
+func (r *AuditedUserRepository) MarkEmailVerified(ctx context.Context, id int, email string) (models.User, error) {
+	before := r.before(ctx, id)
+	u, err := r.UserRepository.MarkEmailVerified(ctx, id, email)
+	if err == nil {
+		r.record(ctx, models.AuditEmailVerified, id, before, u)
+	}
+	return u, err
+}
+
+func (r *AuditedUserRepository) ConfirmEmailChange(ctx context.Context, id int, email string) (models.User, error) {
+	before := r.before(ctx, id)
+	u, err := r.UserRepository.ConfirmEmailChange(ctx, id, email)
+	if err == nil {
+		r.record(ctx, models.AuditEmailChanged, id, before, u)
+	}
+	return u, err
+}
+
+func (r *AuditedUserRepository) SetRole(ctx context.Context, id int, role string) (models.User, error) {
+	before := r.before(ctx, id)
+	u, err := r.UserRepository.SetRole(ctx, id, role)
+	if err == nil {
+		r.record(ctx, models.AuditRoleChanged, id, before, u)
+	}
+	return u, err
+}
+
+// password changes are recorded without snapshots; hashes never leave the users table
+
+func (r *AuditedUserRepository) SetPasswordHash(ctx context.Context, id int, hash string) error {
+	err := r.UserRepository.SetPasswordHash(ctx, id, hash)
+	if err == nil {
+		r.record(ctx, models.AuditPasswordChanged, id, nil, nil)
+	}
+	return err
+}
+
+func (r *AuditedUserRepository) ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string) (int, error) {
+	id, err := r.UserRepository.ResetPassword(ctx, tokenHash, passwordHash)
+	if err == nil {
+		r.record(ctx, models.AuditPasswordReset, id, nil, nil)
+	}
+	return id, err
+}
+
+func (r *AuditedUserRepository) EnableTOTP(ctx context.Context, id int, backupCodeHashes [][]byte) error {
+	err := r.UserRepository.EnableTOTP(ctx, id, backupCodeHashes)
+	if err == nil {
+		r.record(ctx, models.AuditTOTPEnabled, id, nil, nil)
+	}
+	return err
+}
+
+func (r *AuditedUserRepository) ReplaceBackupCodes(ctx context.Context, id int, codeHashes [][]byte) error {
+	err := r.UserRepository.ReplaceBackupCodes(ctx, id, codeHashes)
+	if err == nil {
+		r.record(ctx, models.AuditBackupCodesReplaced, id, nil, nil)
+	}
+	return err
+}
+
+func (r *AuditedUserRepository) DisableTOTP(ctx context.Context, id int) error {
+	err := r.UserRepository.DisableTOTP(ctx, id)
+	if err == nil {
+		r.record(ctx, models.AuditTOTPDisabled, id, nil, nil)
+	}
+	return err
+}
+
+func (r *AuditedUserRepository) CreateAPIKey(ctx context.Context, userID int, name, prefix string, keyHash []byte) (models.APIKey, error) {
+	k, err := r.UserRepository.CreateAPIKey(ctx, userID, name, prefix, keyHash)
+	if err == nil {
+		r.record(ctx, models.AuditAPIKeyCreated, userID, nil, k)
+	}
+	return k, err
+}
+
+func (r *AuditedUserRepository) RevokeAPIKey(ctx context.Context, userID, keyID int) error {
+	err := r.UserRepository.RevokeAPIKey(ctx, userID, keyID)
+	if err == nil {
+		r.record(ctx, models.AuditAPIKeyRevoked, userID, map[string]int{"api_key_id": keyID}, nil)
+	}
+	return err
+}
+
+func (r *AuditedUserRepository) RecordFailedLogin(ctx context.Context, id, threshold int, lockout time.Duration) (*time.Time, error) {
+	lockedUntil, err := r.UserRepository.RecordFailedLogin(ctx, id, threshold, lockout)
+	if err == nil && lockedUntil != nil {
+		r.record(ctx, models.AuditLockedOut, id, nil, map[string]time.Time{"locked_until": *lockedUntil})
+	}
+	return lockedUntil, err
+}
+
+func (r *AuditedUserRepository) Unlock(ctx context.Context, id int) error {
+	err := r.UserRepository.Unlock(ctx, id)
+	if err == nil {
+		r.record(ctx, models.AuditUnlocked, id, nil, nil)
+	}
+	return err
+}
+
+func (r *AuditedUserRepository) LinkIdentity(ctx context.Context, userID int, provider, subject, email string) error {
+	err := r.UserRepository.LinkIdentity(ctx, userID, provider, subject, email)
+	if err == nil {
+		r.record(ctx, models.AuditIdentityLinked, userID, nil, map[string]string{"provider": provider, "email": email})
+	}
+	return err
+}
+
+func (r *AuditedUserRepository) StartImpersonation(ctx context.Context, adminID, userID int, reason string, expiresAt time.Time) (models.Impersonation, error) {
+	i, err := r.UserRepository.StartImpersonation(ctx, adminID, userID, reason, expiresAt)
+	if err == nil {
+		r.record(ctx, models.AuditImpersonated, userID, nil, i)
+	}
+	return i, err
+}
+
+func (r *AuditedUserRepository) EndImpersonation(ctx context.Context, id int) (models.Impersonation, error) {
+	i, err := r.UserRepository.EndImpersonation(ctx, id)
+	if err == nil {
+		r.record(ctx, models.AuditImpersonationEnded, i.UserId, nil, i)
+	}
+	return i, err
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// CircuitOpenError is returned by every query while the Breaker of the database is open, at once
+// rather than after waiting on a database that isn't answering.
+type CircuitOpenError struct {
+	RetryAfter time.Duration // until the breaker lets a query through to try the database again
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("database unavailable, circuit breaker open for another %s", e.RetryAfter.Round(time.Second))
+}
+
+// Breaker stops queries from reaching a database that keeps failing. after threshold failed
+// queries or connections in a row it opens, failing every query with a *CircuitOpenError for
+// cooldown; then it lets one through, closing again if it succeeds and staying open for another
+// cooldown if not. queries the database answers with an error of their own, such as a unique
+// violation, count as successes.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int       // in a row
+	openUntil time.Time // zero while closed
+}
+
+// NewBreaker makes a Breaker for PoolOptions.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// nil while queries may go through. once the cooldown is over, the first caller is let through to
+// try the database and the rest wait out another cooldown
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return &CircuitOpenError{RetryAfter: b.openUntil.Sub(now)}
+	}
+	b.openUntil = now.Add(b.cooldown)
+	return nil
+}
+
+// note how a query or connection went
+func (b *Breaker) record(ctx context.Context, err error) {
+	failed, ok := isDBFailure(err)
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		if !b.openUntil.IsZero() {
+			slog.InfoContext(ctx, "database circuit breaker closed")
+		}
+		b.failures, b.openUntil = 0, time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures < b.threshold {
+		return
+	}
+	if b.openUntil.IsZero() {
+		slog.ErrorContext(ctx, "database circuit breaker opened", "err", err, "failures", b.failures, "cooldown", b.cooldown)
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+}
+
+// whether err means the database is failing rather than answering; ok is false for errors that say
+// nothing either way, such as the caller giving up
+func isDBFailure(err error) (failed, ok bool) {
+	var open *CircuitOpenError
+	switch {
+	case err == nil:
+		return false, true
+	case errors.As(err, &open), errors.Is(err, context.Canceled):
+		return false, false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// connection exceptions, insufficient resources, operator intervention such as a shutdown or
+		// statement timeout, and system errors
+		for _, class := range []string{"08", "53", "57", "58"} {
+			if strings.HasPrefix(pgErr.Code, class) {
+				return true, true
+			}
+		}
+		return false, true
+	}
+	// network errors and timeouts
+	return true, true
+}
+
+// fails connections while the breaker is open, and counts those that can't be made
+type breakerConnector struct {
+	driver.Connector
+	breaker *Breaker
+}
+
+func (c breakerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		c.breaker.record(ctx, err)
+	}
+	return conn, err
+}
+
+// counts the queries and copies run on pgx connections
+type breakerTracer struct {
+	breaker *Breaker
+}
+
+func (t breakerTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (t breakerTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.breaker.record(ctx, data.Err)
+}
+
+func (t breakerTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceCopyFromStartData) context.Context {
+	return ctx
+}
+
+func (t breakerTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	t.breaker.record(ctx, data.Err)
+}
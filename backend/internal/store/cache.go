@@ -0,0 +1,410 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"api/internal/models"
+	"api/internal/tenant"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache is the key-value store behind CachedUserRepository. a missing key is reported with ok false, not an error.
+type Cache interface {
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// atomically add one to the counter at key, treating a missing counter as zero
+	Incr(ctx context.Context, key string) (int64, error)
+}
+
+// RedisCache is a Cache shared by every replica pointed at the same Redis.
+type RedisCache struct {
+	client *redis.Client
+}
+
+var _ Cache = (*RedisCache)(nil)
+
+func NewRedisCache(client *redis.Client) *RedisCache {
+	return &RedisCache{client: client}
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	b, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	return b, err == nil, err
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	return c.client.Del(ctx, keys...).Err()
+}
+
+func (c *RedisCache) Incr(ctx context.Context, key string) (int64, error) {
+	return c.client.Incr(ctx, key).Result()
+}
+
+// CacheLookups counts CachedUserRepository reads by result, hit or miss; register it to export hit rates.
+var CacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "user_cache_lookups_total",
+	Help: "User cache reads, by result (hit or miss).",
+}, []string{"result"})
+
+// CacheLookupTotals reads CacheLookups: the hits and misses counted since the process started.
+func CacheLookupTotals() (hits, misses int64) {
+	count := func(result string) int64 {
+		var m dto.Metric
+		if err := CacheLookups.WithLabelValues(result).Write(&m); err != nil {
+			return 0
+		}
+		return int64(m.GetCounter().GetValue())
+	}
+	return count("hit"), count("miss")
+}
+
+// keys written by CachedUserRepository. list entries embed the list generation, so bumping
+// the generation on any write orphans every cached list at once and lets the TTL reap them.
+// entries are kept per tenant, like the queries they cache
+const (
+	cacheKeyPrefix         = "users:"
+	cacheListGenerationKey = cacheKeyPrefix + "list-generation"
+)
+
+func userCacheKey(ctx context.Context, id int, includeDeleted bool) string {
+	return cacheKeyPrefix + "tenant:" + strconv.Itoa(tenant.ID(ctx)) + ":id:" + strconv.Itoa(id) + ":" + strconv.FormatBool(includeDeleted)
+}
+
+// CachedUserRepository is a read-through cache in front of another UserRepository. Get, List and
+// ListAfter are served from the cache for up to ttl; every write invalidates what it could have changed.
+// concurrent misses on the same key share a single query to the wrapped repository.
+// the cache is best effort: when it fails, reads fall through to the wrapped repository, and a lookup
+// racing a write may cache the old row until ttl expires
+type CachedUserRepository struct {
+	UserRepository // methods that are not cached pass straight through
+	cache          Cache
+	ttl            time.Duration
+	inflight       singleflight.Group
+}
+
+var _ UserRepository = (*CachedUserRepository)(nil)
+
+func NewCachedUserRepository(next UserRepository, cache Cache, ttl time.Duration) *CachedUserRepository {
+	return &CachedUserRepository{UserRepository: next, cache: cache, ttl: ttl}
+}
+
+// decode the entry at key into dest, reporting false on a miss or any cache failure
+func (r *CachedUserRepository) load(ctx context.Context, key string, dest interface{}) bool {
+	b, ok, err := r.cache.Get(ctx, key)
+	if err != nil {
+		slog.WarnContext(ctx, "user cache read failed", "err", err, "key", key)
+		ok = false
+	} else if ok {
+		if err := json.Unmarshal(b, dest); err != nil {
+			slog.WarnContext(ctx, "user cache entry is corrupt", "err", err, "key", key)
+			ok = false
+		}
+	}
+	if ok {
+		CacheLookups.WithLabelValues("hit").Inc()
+	} else {
+		CacheLookups.WithLabelValues("miss").Inc()
+	}
+	return ok
+}
+
+func (r *CachedUserRepository) store(ctx context.Context, key string, value interface{}) {
+	b, err := json.Marshal(value)
+	if err == nil {
+		err = r.cache.Set(ctx, key, b, r.ttl)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "user cache write failed", "err", err, "key", key)
+	}
+}
+
+// key for a list query under the current generation; ok is false when the generation can't be read,
+// in which case the query must not be cached or it could outlive the next invalidation
+func (r *CachedUserRepository) listKey(ctx context.Context, query interface{}) (string, bool) {
+	gen, ok, err := r.cache.Get(ctx, cacheListGenerationKey)
+	if err != nil {
+		slog.WarnContext(ctx, "user cache read failed", "err", err, "key", cacheListGenerationKey)
+		return "", false
+	}
+	if !ok {
+		gen = []byte("0")
+	}
+	q, err := json.Marshal([]interface{}{tenant.ID(ctx), query})
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(q)
+	return cacheKeyPrefix + "list:" + string(gen) + ":" + hex.EncodeToString(sum[:16]), true
+}
+
+// drop every cached list and the cached lookups of the given users
+func (r *CachedUserRepository) invalidate(ctx context.Context, ids ...int) {
+	if _, err := r.cache.Incr(ctx, cacheListGenerationKey); err != nil {
+		slog.WarnContext(ctx, "user cache invalidation failed", "err", err)
+	}
+	if len(ids) == 0 {
+		return
+	}
+	keys := make([]string, 0, 2*len(ids))
+	for _, id := range ids {
+		keys = append(keys, userCacheKey(ctx, id, false), userCacheKey(ctx, id, true))
+	}
+	if err := r.cache.Delete(ctx, keys...); err != nil {
+		slog.WarnContext(ctx, "user cache invalidation failed", "err", err)
+	}
+}
+
+// caches that can drop every entry at once, as MemoryCache can
+type purger interface {
+	Purge()
+}
+
+// drop everything cached, for when changes may have gone unnoticed; a cache that can't be purged
+// only has its lists dropped
+func (r *CachedUserRepository) invalidateAll(ctx context.Context) {
+	if p, ok := r.cache.(purger); ok {
+		p.Purge()
+		return
+	}
+	r.invalidate(ctx)
+}
+
+func (r *CachedUserRepository) Get(ctx context.Context, id int, includeDeleted bool) (models.User, error) {
+	key := userCacheKey(ctx, id, includeDeleted)
+	var u models.User
+	if r.load(ctx, key, &u) {
+		return u, nil
+	}
+	v, err, _ := r.inflight.Do(key, func() (interface{}, error) {
+		u, err := r.UserRepository.Get(ctx, id, includeDeleted)
+		if err == nil {
+			r.store(ctx, key, u)
+		}
+		return u, err
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return v.(models.User), nil
+}
+
+type cachedPage struct {
+	Users []models.User `json:"users"`
+	Total int           `json:"total"`
+}
+
+func (r *CachedUserRepository) List(ctx context.Context, f models.UserFilter, sort models.UserSort, limit, offset int) ([]models.User, int, error) {
+	key, cacheable := r.listKey(ctx, []interface{}{"list", f, sort, limit, offset})
+	if !cacheable {
+		return r.UserRepository.List(ctx, f, sort, limit, offset)
+	}
+	var p cachedPage
+	if r.load(ctx, key, &p) {
+		return p.Users, p.Total, nil
+	}
+	// callers sharing a query also share the returned slice, which handlers only ever read
+	v, err, _ := r.inflight.Do(key, func() (interface{}, error) {
+		users, total, err := r.UserRepository.List(ctx, f, sort, limit, offset)
+		if err != nil {
+			return nil, err
+		}
+		p := cachedPage{Users: users, Total: total}
+		r.store(ctx, key, p)
+		return p, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	p = v.(cachedPage)
+	return p.Users, p.Total, nil
+}
+
+// cached like a list, so any write refreshes it; users only age out of the created windows once it expires
+func (r *CachedUserRepository) Stats(ctx context.Context) (models.UserStats, error) {
+	key, cacheable := r.listKey(ctx, "stats")
+	if !cacheable {
+		return r.UserRepository.Stats(ctx)
+	}
+	var st models.UserStats
+	if r.load(ctx, key, &st) {
+		return st, nil
+	}
+	v, err, _ := r.inflight.Do(key, func() (interface{}, error) {
+		st, err := r.UserRepository.Stats(ctx)
+		if err == nil {
+			r.store(ctx, key, st)
+		}
+		return st, err
+	})
+	if err != nil {
+		return models.UserStats{}, err
+	}
+	return v.(models.UserStats), nil
+}
+
+func (r *CachedUserRepository) ListAfter(ctx context.Context, f models.UserFilter, after *models.UserCursor, desc bool, limit int) ([]models.User, error) {
+	key, cacheable := r.listKey(ctx, []interface{}{"after", f, after, desc, limit})
+	if !cacheable {
+		return r.UserRepository.ListAfter(ctx, f, after, desc, limit)
+	}
+	var users []models.User
+	if r.load(ctx, key, &users) {
+		return users, nil
+	}
+	v, err, _ := r.inflight.Do(key, func() (interface{}, error) {
+		users, err := r.UserRepository.ListAfter(ctx, f, after, desc, limit)
+		if err != nil {
+			return nil, err
+		}
+		r.store(ctx, key, users)
+		return users, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]models.User), nil
+}
+
+func (r *CachedUserRepository) Create(ctx context.Context, u models.User) (models.User, error) {
+	u, err := r.UserRepository.Create(ctx, u)
+	if err == nil {
+		r.invalidate(ctx)
+	}
+	return u, err
+}
+
+func (r *CachedUserRepository) Upsert(ctx context.Context, u models.User) (models.User, bool, error) {
+	u, created, err := r.UserRepository.Upsert(ctx, u)
+	if err == nil {
+		r.invalidate(ctx, u.Id)
+	}
+	return u, created, err
+}
+
+func (r *CachedUserRepository) CreateMany(ctx context.Context, users []models.User) ([]models.User, error) {
+	users, err := r.UserRepository.CreateMany(ctx, users)
+	if err == nil {
+		r.invalidate(ctx)
+	}
+	return users, err
+}
+
+func (r *CachedUserRepository) Update(ctx context.Context, id int, u models.User, version int) (models.User, error) {
+	u, err := r.UserRepository.Update(ctx, id, u, version)
+	if err == nil {
+		r.invalidate(ctx, id)
+	}
+	return u, err
+}
+
+func (r *CachedUserRepository) Delete(ctx context.Context, id int) (models.User, error) {
+	u, err := r.UserRepository.Delete(ctx, id)
+	if err == nil {
+		r.invalidate(ctx, id)
+	}
+	return u, err
+}
+
+func (r *CachedUserRepository) DeleteMatching(ctx context.Context, ids []int64, createdBefore *time.Time) ([]models.User, error) {
+	users, err := r.UserRepository.DeleteMatching(ctx, ids, createdBefore)
+	if err == nil && len(users) > 0 {
+		deleted := make([]int, len(users))
+		for i, u := range users {
+			deleted[i] = u.Id
+		}
+		r.invalidate(ctx, deleted...)
+	}
+	return users, err
+}
+
+func (r *CachedUserRepository) Erase(ctx context.Context, id int) (models.User, error) {
+	u, err := r.UserRepository.Erase(ctx, id)
+	if err == nil {
+		r.invalidate(ctx, id)
+	}
+	return u, err
+}
+
+func (r *CachedUserRepository) Merge(ctx context.Context, id, sourceID int) (models.User, error) {
+	u, err := r.UserRepository.Merge(ctx, id, sourceID)
+	if err == nil {
+		r.invalidate(ctx, id, sourceID)
+	}
+	return u, err
+}
+
+func (r *CachedUserRepository) Restore(ctx context.Context, id int) (models.User, error) {
+	u, err := r.UserRepository.Restore(ctx, id)
+	if err == nil {
+		r.invalidate(ctx, id)
+	}
+	return u, err
+}
+
+func (r *CachedUserRepository) SetAvatarURL(ctx context.Context, id int, url string) (models.User, error) {
+	u, err := r.UserRepository.SetAvatarURL(ctx, id, url)
+	if err == nil {
+		r.invalidate(ctx, id)
+	}
+	return u, err
+}
+
+// tags aren't part of a cached user, but lists may be filtered by them
+func (r *CachedUserRepository) TagUser(ctx context.Context, userID int, tag string) ([]string, error) {
+	tags, err := r.UserRepository.TagUser(ctx, userID, tag)
+	if err == nil {
+		r.invalidate(ctx)
+	}
+	return tags, err
+}
+
+func (r *CachedUserRepository) UntagUser(ctx context.Context, userID int, tag string) error {
+	err := r.UserRepository.UntagUser(ctx, userID, tag)
+	if err == nil {
+		r.invalidate(ctx)
+	}
+	return err
+}
+
+func (r *CachedUserRepository) SetRole(ctx context.Context, id int, role string) (models.User, error) {
+	u, err := r.UserRepository.SetRole(ctx, id, role)
+	if err == nil {
+		r.invalidate(ctx, id)
+	}
+	return u, err
+}
+
+func (r *CachedUserRepository) ConfirmEmailChange(ctx context.Context, id int, email string) (models.User, error) {
+	u, err := r.UserRepository.ConfirmEmailChange(ctx, id, email)
+	if err == nil {
+		r.invalidate(ctx, id)
+	}
+	return u, err
+}
+
+func (r *CachedUserRepository) MarkEmailVerified(ctx context.Context, id int, email string) (models.User, error) {
+	u, err := r.UserRepository.MarkEmailVerified(ctx, id, email)
+	if err == nil {
+		r.invalidate(ctx, id)
+	}
+	return u, err
+}
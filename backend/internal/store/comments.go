@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"api/internal/models"
+	"api/internal/tenant"
+)
+
+// columns of a comment joined to its author as u
+const commentColumns = "c.id, c.post_id, c.author_id, c.body, c.created_at, c.updated_at, u.name, u.avatar_url"
+
+// the posts found in the tenant given as $1, those by its live users
+const visiblePosts = "SELECT p.id FROM posts p JOIN users pu ON pu.id = p.author_id AND pu.tenant_id = $1 AND pu.deleted_at IS NULL"
+
+// comments by live users of the tenant given as $1, on the post given as $2 when it is found there
+const visibleComments = "JOIN users u ON u.id = c.author_id AND u.tenant_id = $1 AND u.deleted_at IS NULL WHERE c.post_id = $2 AND c.post_id IN (" + visiblePosts + ")"
+
+func scanComment(row scanner) (models.Comment, error) {
+	var c models.Comment
+	err := row.Scan(&c.Id, &c.PostId, &c.AuthorId, &c.Body, &c.CreatedAt, &c.UpdatedAt, &c.Author.Name, &c.Author.AvatarURL)
+	if err == sql.ErrNoRows {
+		err = ErrCommentNotFound
+	}
+	c.Author.Id = c.AuthorId
+	return c, err
+}
+
+func (s *PostgresPosts) Comments(ctx context.Context, postID, limit, offset int) ([]models.Comment, int, error) {
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM comments c "+visibleComments, tenant.ID(ctx), postID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT "+commentColumns+" FROM comments c "+visibleComments+
+		" ORDER BY c.created_at, c.id LIMIT $3 OFFSET $4", tenant.ID(ctx), postID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	comments := []models.Comment{}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, total, rows.Err()
+}
+
+func (s *PostgresPosts) Comment(ctx context.Context, postID, id int) (models.Comment, error) {
+	return scanComment(s.db.QueryRowContext(ctx, "SELECT "+commentColumns+" FROM comments c "+visibleComments+" AND c.id = $3",
+		tenant.ID(ctx), postID, id))
+}
+
+func (s *PostgresPosts) AddComment(ctx context.Context, c models.Comment) (models.Comment, error) {
+	var found bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS ("+visiblePosts+" WHERE p.id = $2)", tenant.ID(ctx), c.PostId).Scan(&found); err != nil {
+		return models.Comment{}, err
+	}
+	if !found {
+		return models.Comment{}, ErrPostNotFound
+	}
+	created, err := scanComment(s.db.QueryRowContext(ctx, `WITH c AS (
+			INSERT INTO comments (post_id, author_id, body)
+			SELECT $2, id, $4 FROM users WHERE id = $3 AND tenant_id = $1 AND deleted_at IS NULL
+			RETURNING *)
+		SELECT `+commentColumns+` FROM c `+visibleComments, tenant.ID(ctx), c.PostId, c.AuthorId, c.Body))
+	if err == ErrCommentNotFound {
+		// the post was there a moment ago, so it's the author who isn't
+		err = ErrUserNotFound
+	}
+	return created, err
+}
+
+func (s *PostgresPosts) UpdateComment(ctx context.Context, c models.Comment) (models.Comment, error) {
+	return scanComment(s.db.QueryRowContext(ctx, `WITH c AS (
+			UPDATE comments SET body = $4, updated_at = now()
+			WHERE id = $3 AND id IN (SELECT c.id FROM comments c `+visibleComments+`)
+			RETURNING *)
+		SELECT `+commentColumns+` FROM c `+visibleComments, tenant.ID(ctx), c.PostId, c.Id, c.Body))
+}
+
+func (s *PostgresPosts) DeleteComment(ctx context.Context, postID, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM comments WHERE id IN (SELECT c.id FROM comments c "+visibleComments+" AND c.id = $3)",
+		tenant.ID(ctx), postID, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrCommentNotFound
+	}
+	return nil
+}
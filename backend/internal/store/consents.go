@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"api/internal/models"
+	"api/internal/tenant"
+)
+
+// Consents keeps which versions of the legal documents each user accepted.
+type Consents interface {
+	// record c, filling in its id and time; accepting a version again returns the first acceptance.
+	// ErrUserNotFound if the user doesn't exist or is deleted
+	Accept(ctx context.Context, c models.Consent) (models.Consent, error)
+	// a user's acceptances, newest first
+	List(ctx context.Context, userID int) ([]models.Consent, error)
+	// the documents of current the user hasn't accepted in the version given
+	Pending(ctx context.Context, userID int, current []models.LegalDocument) ([]models.LegalDocument, error)
+}
+
+// PostgresConsents keeps acceptances in the user_consents table.
+type PostgresConsents struct {
+	db *sql.DB
+}
+
+var _ Consents = (*PostgresConsents)(nil)
+
+func NewPostgresConsents(db *sql.DB) *PostgresConsents {
+	return &PostgresConsents{db: db}
+}
+
+const consentColumns = "id, user_id, document, version, accepted_at, COALESCE(ip, ''), COALESCE(user_agent, '')"
+
+func scanConsent(row scanner) (models.Consent, error) {
+	var c models.Consent
+	err := row.Scan(&c.Id, &c.UserId, &c.Document, &c.Version, &c.AcceptedAt, &c.IP, &c.UserAgent)
+	return c, err
+}
+
+func (s *PostgresConsents) Accept(ctx context.Context, c models.Consent) (models.Consent, error) {
+	// the no-op update makes RETURNING yield the row that was already there
+	accepted, err := scanConsent(s.db.QueryRowContext(ctx, `INSERT INTO user_consents (tenant_id, user_id, document, version, ip, user_agent)
+		SELECT $1, id, $3, $4, NULLIF($5, ''), NULLIF($6, '') FROM users WHERE id = $2 AND tenant_id = $1 AND deleted_at IS NULL
+		ON CONFLICT (user_id, document, version) DO UPDATE SET document = EXCLUDED.document
+		RETURNING `+consentColumns, tenant.ID(ctx), c.UserId, c.Document, c.Version, c.IP, c.UserAgent))
+	if err == sql.ErrNoRows {
+		return models.Consent{}, ErrUserNotFound
+	}
+	return accepted, err
+}
+
+func (s *PostgresConsents) List(ctx context.Context, userID int) ([]models.Consent, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+consentColumns+" FROM user_consents WHERE user_id = $1 AND tenant_id = $2 ORDER BY accepted_at DESC, id DESC", userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	consents := []models.Consent{}
+	for rows.Next() {
+		c, err := scanConsent(rows)
+		if err != nil {
+			return nil, err
+		}
+		consents = append(consents, c)
+	}
+	return consents, rows.Err()
+}
+
+func (s *PostgresConsents) Pending(ctx context.Context, userID int, current []models.LegalDocument) ([]models.LegalDocument, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT document, version FROM user_consents WHERE user_id = $1 AND tenant_id = $2", userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	accepted := map[models.LegalDocument]bool{}
+	for rows.Next() {
+		var d models.LegalDocument
+		if err := rows.Scan(&d.Document, &d.Version); err != nil {
+			return nil, err
+		}
+		accepted[d] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var pending []models.LegalDocument
+	for _, d := range current {
+		if !accepted[models.LegalDocument{Document: d.Document, Version: d.Version}] {
+			pending = append(pending, d)
+		}
+	}
+	return pending, nil
+}
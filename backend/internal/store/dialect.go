@@ -0,0 +1,296 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/models"
+	"api/internal/pii"
+	"api/internal/tenant"
+)
+
+// dialect is what the SQL of the databases users are kept in differs by, where queries are built
+// from parts rather than written out for one database
+type dialect struct {
+	// the placeholder binding the nth argument of a query, counting from 1
+	placeholder func(n int) string
+	// a condition holding when the bound substr is in column, whatever the case of either
+	contains func(column, substr string) string
+	// whether RETURNING reports a row as the statement's triggers left it. Postgres's BEFORE
+	// triggers change the row being written; SQLite's can only update it again afterwards, which
+	// RETURNING doesn't see, so writes there are read back by id
+	returnsTriggered bool
+	// ErrEmailTaken or ErrUsernameTaken for a unique violation of those columns, err otherwise
+	taken func(err error) error
+	// how a time is bound, nil when the driver's own encoding of it compares in time order
+	bindTime func(t time.Time) interface{}
+}
+
+var postgresDialect = dialect{
+	placeholder:      func(n int) string { return "$" + strconv.Itoa(n) },
+	contains:         func(column, substr string) string { return "strpos(lower(" + column + "), lower(" + substr + ")) > 0" },
+	returnsTriggered: true,
+	taken:            takenError,
+}
+
+var sqliteDialect = dialect{
+	placeholder: func(n int) string { return "?" + strconv.Itoa(n) },
+	contains:    func(column, substr string) string { return "instr(lower(" + column + "), lower(" + substr + ")) > 0" },
+	taken:       sqliteTakenError,
+	bindTime:    func(t time.Time) interface{} { return sqliteTime(t) },
+}
+
+// columns selected for a user, in the order scanUser expects them
+const userColumns = "id, name, username, email, created_at, updated_at, deleted_at, avatar_url, email_verified_at, role, phone, bio, timezone, locale, version"
+
+// anything with a Scan method, so *sql.Row and *sql.Rows share scanUser
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// a *sql.DB or *sql.Tx, for reads made both in and out of a transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// a querier that reads single rows too
+type rowQuerier interface {
+	querier
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// userColumns one by one
+var userColumnNames = strings.Split(userColumns, ", ")
+
+// where each of userColumns is scanned into u
+func userDest(u *models.User) []interface{} {
+	return []interface{}{&u.Id, &u.Name, &u.Username, &u.Email, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt, &u.AvatarURL, &u.EmailVerifiedAt, &u.Role, &u.Phone, &u.Bio, &u.Timezone, &u.Locale, &u.Version}
+}
+
+// sqlUsers is what the repositories keeping users in a SQL database share: reading users from
+// rows, encrypting emails and phone numbers, and building queries in the database's dialect
+type sqlUsers struct {
+	dialect dialect
+	pii     *pii.Keyring
+}
+
+// scan userColumns into a User, followed by any extra selected columns
+func (s sqlUsers) scanUser(row scanner, extra ...interface{}) (models.User, error) {
+	var u models.User
+	err := row.Scan(append(userDest(&u), extra...)...)
+	if err == sql.ErrNoRows {
+		return u, ErrUserNotFound
+	} else if err != nil {
+		return u, err
+	}
+	return u, s.decrypt(&u)
+}
+
+// the members of user JSON, such as revision snapshots, that are encrypted like their columns
+var piiMembers = []string{"email", "phone"}
+
+// a user's email and phone as they are stored
+func (s sqlUsers) encrypted(u models.User) (email string, phone *string) {
+	email = s.pii.Encrypt(u.Email)
+	if u.Phone != nil {
+		sealed := s.pii.Encrypt(*u.Phone)
+		phone = &sealed
+	}
+	return email, phone
+}
+
+// the lookup hash of an email, NULL when emails aren't encrypted
+func (s sqlUsers) emailIndex(email string) sql.Null[[]byte] {
+	index := s.pii.Index(email)
+	return sql.Null[[]byte]{V: index, Valid: index != nil}
+}
+
+func (s sqlUsers) decrypt(u *models.User) error {
+	var err error
+	if u.Email, err = s.pii.Decrypt(u.Email); err != nil {
+		return err
+	}
+	if u.Phone != nil {
+		phone, err := s.pii.Decrypt(*u.Phone)
+		if err != nil {
+			return err
+		}
+		u.Phone = &phone
+	}
+	return nil
+}
+
+// the columns a listing filtered by f reads: all of userColumns, or its Fields along with id and
+// the required columns, in userColumns order
+func selectedColumns(f models.UserFilter, required ...string) []string {
+	if len(f.Fields) == 0 {
+		return userColumnNames
+	}
+	var columns []string
+	for _, c := range userColumnNames {
+		if c == "id" || slices.Contains(f.Fields, c) || slices.Contains(required, c) {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// collect users from rows selecting the given columns, which must be among userColumns
+func (s sqlUsers) scanUsers(rows *sql.Rows, columns []string) ([]models.User, error) {
+	defer rows.Close()
+	users := []models.User{} // array of users
+	for rows.Next() {
+		var u models.User
+		all := userDest(&u)
+		dest := make([]interface{}, len(columns))
+		for i, c := range columns {
+			dest[i] = all[slices.Index(userColumnNames, c)]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if err := s.decrypt(&u); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s sqlUsers) scanSearchResults(rows *sql.Rows) ([]models.SearchResult, error) {
+	defer rows.Close()
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var rank float64
+		u, err := s.scanUser(rows, &rank)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, models.SearchResult{User: u, Rank: rank})
+	}
+	return results, rows.Err()
+}
+
+// revisions are snapshots written by the users triggers, in the shape of a models.User
+func (s sqlUsers) scanRevision(row scanner) (models.UserRevision, error) {
+	var (
+		rev      models.UserRevision
+		snapshot []byte
+	)
+	if err := row.Scan(&rev.Rev, &rev.CreatedAt, &snapshot); err != nil {
+		return rev, err
+	}
+	snapshot, err := s.pii.OpenJSON(snapshot, piiMembers...)
+	if err != nil {
+		return rev, err
+	}
+	return rev, json.Unmarshal(snapshot, &rev.User)
+}
+
+// run query, an INSERT or UPDATE of at most one user without its RETURNING clause, and read the
+// user as it was left; ErrUserNotFound when nobody was written
+func (s sqlUsers) writeUser(ctx context.Context, q rowQuerier, query string, args ...interface{}) (models.User, error) {
+	if s.dialect.returnsTriggered {
+		return s.scanUser(q.QueryRowContext(ctx, query+" RETURNING "+userColumns, args...))
+	}
+	var id int
+	if err := q.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id); err == sql.ErrNoRows {
+		return models.User{}, ErrUserNotFound
+	} else if err != nil {
+		return models.User{}, err
+	}
+	return s.scanUser(q.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = "+s.dialect.placeholder(1), id))
+}
+
+// writeUser for a statement writing any number of users
+func (s sqlUsers) writeUsers(ctx context.Context, q rowQuerier, query string, args ...interface{}) ([]models.User, error) {
+	if s.dialect.returnsTriggered {
+		rows, err := q.QueryContext(ctx, query+" RETURNING "+userColumns, args...)
+		if err != nil {
+			return nil, err
+		}
+		return s.scanUsers(rows, userColumnNames)
+	}
+	rows, err := q.QueryContext(ctx, query+" RETURNING id", args...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	users := []models.User{}
+	for _, id := range ids {
+		u, err := s.scanUser(q.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = "+s.dialect.placeholder(1), id))
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// WHERE conditions and their positional arguments for a users query
+type userQuery struct {
+	dialect dialect
+	conds   []string
+	args    []interface{}
+}
+
+// add an argument and return its placeholder
+func (q *userQuery) bind(v interface{}) string {
+	if t, ok := v.(time.Time); ok && q.dialect.bindTime != nil {
+		v = q.dialect.bindTime(t)
+	}
+	q.args = append(q.args, v)
+	return q.dialect.placeholder(len(q.args))
+}
+
+func (q *userQuery) where() string {
+	if len(q.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conds, " AND ")
+}
+
+// a query with no conditions yet, in the dialect of s
+func (s sqlUsers) query() *userQuery {
+	return &userQuery{dialect: s.dialect}
+}
+
+// translate the filter into SQL conditions within the tenant, hiding soft-deleted users unless asked
+func (s sqlUsers) filterQuery(ctx context.Context, f models.UserFilter) *userQuery {
+	q := s.query()
+	q.conds = append(q.conds, "tenant_id = "+q.bind(tenant.ID(ctx)))
+	if !f.IncludeDeleted {
+		q.conds = append(q.conds, "deleted_at IS NULL")
+	}
+	// encrypted addresses can only match as a whole
+	if f.EmailContains != "" {
+		q.conds = append(q.conds, "("+s.dialect.contains("email", q.bind(f.EmailContains))+" OR email_index = "+q.bind(s.emailIndex(f.EmailContains))+")")
+	}
+	if f.CreatedAfter != nil {
+		q.conds = append(q.conds, "created_at > "+q.bind(*f.CreatedAfter))
+	}
+	if f.CreatedBefore != nil {
+		q.conds = append(q.conds, "created_at < "+q.bind(*f.CreatedBefore))
+	}
+	if f.Tag != "" {
+		q.conds = append(q.conds, "id IN (SELECT ut.user_id FROM user_tags ut JOIN tags t ON t.id = ut.tag_id WHERE t.name = "+q.bind(f.Tag)+")")
+	}
+	return q
+}
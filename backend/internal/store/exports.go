@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"api/internal/models"
+	"api/internal/tenant"
+)
+
+// ErrExportNotFound is returned by Exports and UserExports lookups when the user has no such
+// unexpired export.
+var ErrExportNotFound = errors.New("export not found")
+
+// Exports keeps the data exports generated for users of the tenant on the context, until they expire.
+type Exports interface {
+	// start a pending export for a live user, clearing out their expired ones; those of users who
+	// ask for no more are left to Maintenance.DeleteExpiredDataExports
+	Create(ctx context.Context, userID int, format string, expiresAt time.Time) (models.DataExport, error)
+	// note the job generating an export
+	SetJob(ctx context.Context, id int, jobID int64) error
+	// ErrExportNotFound if the user has no such unexpired export
+	Get(ctx context.Context, userID, id int) (models.DataExport, error)
+	// the archive of a ready export; ErrExportNotFound unless the user has such an export
+	Archive(ctx context.Context, userID, id int) ([]byte, error)
+	// store the archive of a pending export, making it ready
+	Finish(ctx context.Context, id int, archive []byte) error
+	// give up on a pending export
+	Fail(ctx context.Context, id int) error
+}
+
+// PostgresExports keeps exports, archives included, in the data_exports table.
+type PostgresExports struct {
+	db *sql.DB
+}
+
+var _ Exports = (*PostgresExports)(nil)
+
+func NewPostgresExports(db *sql.DB) *PostgresExports {
+	return &PostgresExports{db: db}
+}
+
+const exportColumns = "id, user_id, format, status, created_at, finished_at, expires_at, job_id"
+
+func scanExport(row scanner) (models.DataExport, error) {
+	var e models.DataExport
+	err := row.Scan(&e.Id, &e.UserId, &e.Format, &e.Status, &e.CreatedAt, &e.FinishedAt, &e.ExpiresAt, &e.JobId)
+	if err == sql.ErrNoRows {
+		err = ErrExportNotFound
+	}
+	return e, err
+}
+
+func (s *PostgresExports) Create(ctx context.Context, userID int, format string, expiresAt time.Time) (models.DataExport, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.DataExport{}, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM data_exports WHERE user_id = $1 AND tenant_id = $2 AND expires_at <= now()", userID, tenant.ID(ctx)); err != nil {
+		return models.DataExport{}, err
+	}
+	e, err := scanExport(tx.QueryRowContext(ctx, `INSERT INTO data_exports (tenant_id, user_id, format, expires_at)
+		SELECT tenant_id, id, $3, $4 FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+		RETURNING `+exportColumns, userID, tenant.ID(ctx), format, expiresAt))
+	if err == ErrExportNotFound {
+		return models.DataExport{}, ErrUserNotFound
+	} else if err != nil {
+		return models.DataExport{}, err
+	}
+	return e, tx.Commit()
+}
+
+func (s *PostgresExports) SetJob(ctx context.Context, id int, jobID int64) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE data_exports SET job_id = $1 WHERE id = $2 AND tenant_id = $3", jobID, id, tenant.ID(ctx))
+	return err
+}
+
+func (s *PostgresExports) Get(ctx context.Context, userID, id int) (models.DataExport, error) {
+	return scanExport(s.db.QueryRowContext(ctx, "SELECT "+exportColumns+` FROM data_exports
+		WHERE id = $1 AND user_id = $2 AND tenant_id = $3 AND expires_at > now()`, id, userID, tenant.ID(ctx)))
+}
+
+func (s *PostgresExports) Archive(ctx context.Context, userID, id int) ([]byte, error) {
+	var archive []byte
+	err := s.db.QueryRowContext(ctx, `SELECT archive FROM data_exports
+		WHERE id = $1 AND user_id = $2 AND tenant_id = $3 AND status = 'ready' AND expires_at > now()`, id, userID, tenant.ID(ctx)).Scan(&archive)
+	if err == sql.ErrNoRows {
+		err = ErrExportNotFound
+	}
+	return archive, err
+}
+
+func (s *PostgresExports) Finish(ctx context.Context, id int, archive []byte) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE data_exports SET status = 'ready', archive = $1, finished_at = now()
+		WHERE id = $2 AND tenant_id = $3 AND status = 'pending'`, archive, id, tenant.ID(ctx))
+	return err
+}
+
+func (s *PostgresExports) Fail(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE data_exports SET status = 'failed', finished_at = now()
+		WHERE id = $1 AND tenant_id = $2 AND status = 'pending'`, id, tenant.ID(ctx))
+	return err
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"api/internal/models"
+	"api/internal/tenant"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// FeatureFlags keeps the feature flags of each tenant, by key.
+type FeatureFlags interface {
+	// every flag, by key
+	List(ctx context.Context) ([]models.FeatureFlag, error)
+	// ErrFlagNotFound if there is no such flag
+	Get(ctx context.Context, key string) (models.FeatureFlag, error)
+	// add f, filling in its timestamps; ErrFlagExists if its key is taken
+	Create(ctx context.Context, f models.FeatureFlag) (models.FeatureFlag, error)
+	// replace everything but the key of the flag with f's; ErrFlagNotFound if there is no such flag
+	Update(ctx context.Context, key string, f models.FeatureFlag) (models.FeatureFlag, error)
+	// ErrFlagNotFound if there is no such flag
+	Delete(ctx context.Context, key string) error
+}
+
+// PostgresFeatureFlags keeps flags in the feature_flags table.
+type PostgresFeatureFlags struct {
+	db *sql.DB
+}
+
+var _ FeatureFlags = (*PostgresFeatureFlags)(nil)
+
+func NewPostgresFeatureFlags(db *sql.DB) *PostgresFeatureFlags {
+	return &PostgresFeatureFlags{db: db}
+}
+
+const flagColumns = "key, description, enabled, rollout, user_ids, roles, created_at, updated_at"
+
+func scanFlag(row scanner) (models.FeatureFlag, error) {
+	var f models.FeatureFlag
+	m := pgtype.NewMap()
+	err := row.Scan(&f.Key, &f.Description, &f.Enabled, &f.Rollout, m.SQLScanner(&f.Users), m.SQLScanner(&f.Roles), &f.CreatedAt, &f.UpdatedAt)
+	return f, err
+}
+
+// arrays are written as empty rather than NULL
+func flagTargets(f models.FeatureFlag) ([]int, []string) {
+	users, roles := f.Users, f.Roles
+	if users == nil {
+		users = []int{}
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	return users, roles
+}
+
+func (s *PostgresFeatureFlags) List(ctx context.Context) ([]models.FeatureFlag, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+flagColumns+" FROM feature_flags WHERE tenant_id = $1 ORDER BY key", tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	flags := []models.FeatureFlag{}
+	for rows.Next() {
+		f, err := scanFlag(rows)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, f)
+	}
+	return flags, rows.Err()
+}
+
+func (s *PostgresFeatureFlags) Get(ctx context.Context, key string) (models.FeatureFlag, error) {
+	f, err := scanFlag(s.db.QueryRowContext(ctx, "SELECT "+flagColumns+" FROM feature_flags WHERE key = $1 AND tenant_id = $2", key, tenant.ID(ctx)))
+	if err == sql.ErrNoRows {
+		return models.FeatureFlag{}, ErrFlagNotFound
+	}
+	return f, err
+}
+
+func (s *PostgresFeatureFlags) Create(ctx context.Context, f models.FeatureFlag) (models.FeatureFlag, error) {
+	users, roles := flagTargets(f)
+	created, err := scanFlag(s.db.QueryRowContext(ctx, `INSERT INTO feature_flags (tenant_id, key, description, enabled, rollout, user_ids, roles)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING `+flagColumns, tenant.ID(ctx), f.Key, f.Description, f.Enabled, f.Rollout, users, roles))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return models.FeatureFlag{}, ErrFlagExists
+	}
+	return created, err
+}
+
+func (s *PostgresFeatureFlags) Update(ctx context.Context, key string, f models.FeatureFlag) (models.FeatureFlag, error) {
+	users, roles := flagTargets(f)
+	updated, err := scanFlag(s.db.QueryRowContext(ctx, `UPDATE feature_flags SET description = $1, enabled = $2, rollout = $3, user_ids = $4, roles = $5, updated_at = now()
+		WHERE key = $6 AND tenant_id = $7 RETURNING `+flagColumns, f.Description, f.Enabled, f.Rollout, users, roles, key, tenant.ID(ctx)))
+	if err == sql.ErrNoRows {
+		return models.FeatureFlag{}, ErrFlagNotFound
+	}
+	return updated, err
+}
+
+func (s *PostgresFeatureFlags) Delete(ctx context.Context, key string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM feature_flags WHERE key = $1 AND tenant_id = $2", key, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrFlagNotFound
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// waits between pings of a database that is down, doubling from the first to the last
+const (
+	firstPingBackoff = 500 * time.Millisecond
+	maxPingBackoff   = 10 * time.Second
+)
+
+// how long a single ping may take before the database counts as down
+const pingTimeout = 2 * time.Second
+
+// WaitForDB pings db until it answers, waiting longer after each failure, for up to within, so
+// the server can start before Postgres has finished starting. it returns the last ping's error
+// when within runs out, and tries only once when within is 0.
+func WaitForDB(ctx context.Context, db *sql.DB, within time.Duration) error {
+	deadline := time.Now().Add(within)
+	wait := firstPingBackoff
+	for attempt := 1; ; attempt++ {
+		err := ping(ctx, db)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		slog.WarnContext(ctx, "database not reachable yet, retrying", "err", err, "attempt", attempt, "retry_in", wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, maxPingBackoff)
+	}
+}
+
+func ping(ctx context.Context, db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+// DBMonitor pings the database in the background to notice when it has gone away, and, while it
+// is down, pings it again with backoff until it is back, dropping the pool's connections so none
+// left broken by the outage are handed out once it is.
+type DBMonitor struct {
+	db       *sql.DB
+	pool     *pgxpool.Pool
+	interval time.Duration
+
+	mu  sync.Mutex
+	err error // the last failed ping's, nil while the database is up
+}
+
+// NewDBMonitor makes a DBMonitor pinging db every interval while it is up; Run starts it.
+func NewDBMonitor(db *sql.DB, pool *pgxpool.Pool, interval time.Duration) *DBMonitor {
+	return &DBMonitor{db: db, pool: pool, interval: interval}
+}
+
+// Run pings the database until ctx is done.
+func (m *DBMonitor) Run(ctx context.Context) {
+	wait := m.interval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		err := ping(ctx, m.db)
+		if ctx.Err() != nil {
+			return
+		}
+		m.mu.Lock()
+		wasDown := m.err != nil
+		m.err = err
+		m.mu.Unlock()
+		switch {
+		case err == nil:
+			if wasDown {
+				slog.InfoContext(ctx, "database reachable again")
+			}
+			wait = m.interval
+		case !wasDown:
+			slog.ErrorContext(ctx, "database unreachable", "err", err)
+			m.pool.Reset()
+			wait = firstPingBackoff
+		default:
+			wait = min(wait*2, maxPingBackoff, m.interval)
+			slog.WarnContext(ctx, "database still unreachable", "err", err, "retry_in", wait)
+		}
+	}
+}
+
+// Check is a readiness check: the last ping's error while the database is down, without waiting
+// on another, and otherwise a fresh ping.
+func (m *DBMonitor) Check(ctx context.Context) error {
+	m.mu.Lock()
+	err := m.err
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return m.db.PingContext(ctx)
+}
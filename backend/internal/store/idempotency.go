@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"api/internal/models"
+)
+
+// IdempotencyKeys remembers the responses to requests sent with an Idempotency-Key, per user.
+type IdempotencyKeys interface {
+	// claim key for a request with the given hash. when the key is already held, nothing is
+	// claimed and the existing record is returned instead
+	Begin(ctx context.Context, userID int, key string, requestHash []byte) (*models.IdempotencyRecord, error)
+	// store the response to the request holding key
+	Complete(ctx context.Context, userID int, key string, resp models.IdempotentResponse) error
+	// give up a claimed key without a response, so a retry runs the request again
+	Release(ctx context.Context, userID int, key string) error
+}
+
+// PostgresIdempotencyKeys keeps idempotency keys in the idempotency_keys table until their TTL passes.
+type PostgresIdempotencyKeys struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+var _ IdempotencyKeys = (*PostgresIdempotencyKeys)(nil)
+
+func NewPostgresIdempotencyKeys(db *sql.DB, ttl time.Duration) *PostgresIdempotencyKeys {
+	return &PostgresIdempotencyKeys{db: db, ttl: ttl}
+}
+
+func (k *PostgresIdempotencyKeys) Begin(ctx context.Context, userID int, key string, requestHash []byte) (*models.IdempotencyRecord, error) {
+	tx, err := k.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// expired keys are forgotten as their owner sends new ones, so a retry after the TTL runs again
+	if _, err := tx.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id = $1 AND expires_at <= now()", userID); err != nil {
+		return nil, err
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO idempotency_keys (user_id, key, request_hash, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, key) DO NOTHING`, userID, key, requestHash, time.Now().Add(k.ttl))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 1 {
+		return nil, tx.Commit()
+	}
+
+	var (
+		rec    models.IdempotencyRecord
+		status sql.NullInt64
+		header []byte
+		body   []byte
+	)
+	err = tx.QueryRowContext(ctx, "SELECT request_hash, status_code, header, body FROM idempotency_keys WHERE user_id = $1 AND key = $2",
+		userID, key).Scan(&rec.RequestHash, &status, &header, &body)
+	if err != nil {
+		return nil, err
+	}
+	if status.Valid {
+		rec.Response = &models.IdempotentResponse{StatusCode: int(status.Int64), Body: body}
+		if err := json.Unmarshal(header, &rec.Response.Header); err != nil {
+			return nil, err
+		}
+	}
+	return &rec, tx.Commit()
+}
+
+func (k *PostgresIdempotencyKeys) Complete(ctx context.Context, userID int, key string, resp models.IdempotentResponse) error {
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+	_, err = k.db.ExecContext(ctx, "UPDATE idempotency_keys SET status_code = $1, header = $2, body = $3 WHERE user_id = $4 AND key = $5",
+		resp.StatusCode, header, resp.Body, userID, key)
+	return err
+}
+
+func (k *PostgresIdempotencyKeys) Release(ctx context.Context, userID int, key string) error {
+	_, err := k.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND status_code IS NULL", userID, key)
+	return err
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"api/internal/models"
+	"api/internal/tenant"
+)
+
+const invitationColumns = "id, org_id, email, role, invited_by, expires_at, created_at"
+
+// an invitation still waiting for an answer, only to organizations of the tenant on the context
+const pendingInvitation = `token_hash = $1 AND accepted_at IS NULL AND declined_at IS NULL AND expires_at > now()
+	AND org_id IN (SELECT id FROM organizations WHERE tenant_id = $2)`
+
+func scanInvitation(row scanner) (models.Invitation, error) {
+	var inv models.Invitation
+	var invitedBy sql.NullInt64
+	err := row.Scan(&inv.Id, &inv.OrgId, &inv.Email, &inv.Role, &invitedBy, &inv.ExpiresAt, &inv.CreatedAt)
+	if err == sql.ErrNoRows {
+		return models.Invitation{}, ErrInvalidInvitation
+	}
+	if invitedBy.Valid {
+		id := int(invitedBy.Int64)
+		inv.InvitedBy = &id
+	}
+	return inv, err
+}
+
+func (s *PostgresOrganizations) Invite(ctx context.Context, inv models.Invitation, tokenHash []byte) (models.Invitation, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+	defer tx.Rollback()
+
+	// only the newest link to an address works, so resending one retires the last
+	if _, err := tx.ExecContext(ctx, `DELETE FROM invitations WHERE org_id = $1 AND lower(email) = lower($2)
+		AND accepted_at IS NULL AND declined_at IS NULL`, inv.OrgId, inv.Email); err != nil {
+		return models.Invitation{}, err
+	}
+	inv, err = scanInvitation(tx.QueryRowContext(ctx, `INSERT INTO invitations (org_id, email, role, token_hash, invited_by, expires_at)
+		SELECT id, $2, $3, $4, $5, $6 FROM organizations WHERE id = $1 AND tenant_id = $7
+		RETURNING `+invitationColumns, inv.OrgId, inv.Email, inv.Role, tokenHash, inv.InvitedBy, inv.ExpiresAt, tenant.ID(ctx)))
+	if err == ErrInvalidInvitation {
+		return models.Invitation{}, ErrOrgNotFound
+	} else if err != nil {
+		return models.Invitation{}, err
+	}
+	return inv, tx.Commit()
+}
+
+func (s *PostgresOrganizations) Invitation(ctx context.Context, tokenHash []byte) (models.Invitation, error) {
+	return scanInvitation(s.db.QueryRowContext(ctx, "SELECT "+invitationColumns+" FROM invitations WHERE "+pendingInvitation, tokenHash, tenant.ID(ctx)))
+}
+
+func (s *PostgresOrganizations) AcceptInvitation(ctx context.Context, tokenHash []byte, userID int) (models.Organization, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Organization{}, err
+	}
+	defer tx.Rollback()
+
+	// marking it accepted first means two concurrent accepts can't both get through
+	inv, err := scanInvitation(tx.QueryRowContext(ctx, "UPDATE invitations SET accepted_at = now() WHERE "+pendingInvitation+
+		" RETURNING "+invitationColumns, tokenHash, tenant.ID(ctx)))
+	if err != nil {
+		return models.Organization{}, err
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO memberships (org_id, user_id, role)
+		SELECT $1, id, $3 FROM users WHERE id = $2 AND tenant_id = $4 AND deleted_at IS NULL
+		ON CONFLICT DO NOTHING`, inv.OrgId, userID, inv.Role, tenant.ID(ctx))
+	if err != nil {
+		return models.Organization{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return models.Organization{}, err
+	} else if n == 0 {
+		// a member already keeps the role they have
+		var member bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM memberships WHERE org_id = $1 AND user_id = $2)", inv.OrgId, userID).Scan(&member); err != nil {
+			return models.Organization{}, err
+		}
+		if !member {
+			return models.Organization{}, ErrUserNotFound
+		}
+	}
+
+	var role string
+	o, err := scanOrg(tx.QueryRowContext(ctx, `SELECT o.id, o.name, o.created_at, o.updated_at, m.role
+		FROM organizations o JOIN memberships m ON m.org_id = o.id WHERE o.id = $1 AND m.user_id = $2`, inv.OrgId, userID), &role)
+	if err != nil {
+		return models.Organization{}, err
+	}
+	o.Role = role
+	return o, tx.Commit()
+}
+
+func (s *PostgresOrganizations) DeclineInvitation(ctx context.Context, tokenHash []byte) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE invitations SET declined_at = now() WHERE "+pendingInvitation, tokenHash, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrInvalidInvitation
+	}
+	return nil
+}
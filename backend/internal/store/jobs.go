@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/models"
+)
+
+// Jobs is the queue of background jobs and the record of how they went.
+type Jobs interface {
+	// queue a job of kind to be tried up to maxAttempts times, returning its id
+	Enqueue(ctx context.Context, kind string, payload []byte, maxAttempts int) (int64, error)
+	// one page of the jobs matching f, newest first, along with their total number
+	List(ctx context.Context, f models.JobFilter, limit, offset int) ([]models.Job, int, error)
+	// ErrJobNotFound if there is no such job
+	Get(ctx context.Context, id int64) (models.Job, error)
+	// claim up to limit pending jobs of the given kinds that are due, holding each back from other
+	// claims for lease, long enough to run it and record the outcome
+	ClaimDue(ctx context.Context, kinds []string, limit int, lease time.Duration) ([]models.Job, error)
+	// record the outcome of an attempt at a claimed job
+	RecordAttempt(ctx context.Context, id int64, attempt models.JobAttempt) error
+	// delete jobs that succeeded or failed before the given time
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// PostgresJobs keeps jobs in the jobs table.
+type PostgresJobs struct {
+	db *sql.DB
+}
+
+var _ Jobs = (*PostgresJobs)(nil)
+
+func NewPostgresJobs(db *sql.DB) *PostgresJobs {
+	return &PostgresJobs{db: db}
+}
+
+const jobColumns = "id, kind, payload, status, attempts, max_attempts, run_at, last_error, created_at, finished_at"
+
+func scanJob(row scanner) (models.Job, error) {
+	var (
+		j       models.Job
+		payload []byte
+		runAt   time.Time
+	)
+	if err := row.Scan(&j.Id, &j.Kind, &payload, &j.Status, &j.Attempts, &j.MaxAttempts, &runAt, &j.LastError, &j.CreatedAt, &j.FinishedAt); err != nil {
+		return models.Job{}, err
+	}
+	j.Payload = payload
+	if j.Status == models.JobPending {
+		j.RunAt = &runAt
+	}
+	return j, nil
+}
+
+func (s *PostgresJobs) Enqueue(ctx context.Context, kind string, payload []byte, maxAttempts int) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, "INSERT INTO jobs (kind, payload, max_attempts) VALUES ($1, $2::jsonb, $3) RETURNING id",
+		kind, payload, maxAttempts).Scan(&id)
+	return id, err
+}
+
+func (s *PostgresJobs) List(ctx context.Context, f models.JobFilter, limit, offset int) ([]models.Job, int, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if f.Status != "" {
+		args = append(args, f.Status)
+		conds = append(conds, "status = $"+strconv.Itoa(len(args)))
+	}
+	if f.Kind != "" {
+		args = append(args, f.Kind)
+		conds = append(conds, "kind = $"+strconv.Itoa(len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM jobs"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, limit, offset)
+	rows, err := s.db.QueryContext(ctx, "SELECT "+jobColumns+" FROM jobs"+where+
+		" ORDER BY created_at DESC, id DESC LIMIT $"+strconv.Itoa(len(args)-1)+" OFFSET $"+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	jobs := []models.Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, total, rows.Err()
+}
+
+func (s *PostgresJobs) Get(ctx context.Context, id int64) (models.Job, error) {
+	j, err := scanJob(s.db.QueryRowContext(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return models.Job{}, ErrJobNotFound
+	}
+	return j, err
+}
+
+// SKIP LOCKED lets several replicas claim jobs side by side without running any twice
+func (s *PostgresJobs) ClaimDue(ctx context.Context, kinds []string, limit int, lease time.Duration) ([]models.Job, error) {
+	rows, err := s.db.QueryContext(ctx, `WITH due AS (
+			SELECT id FROM jobs WHERE status = 'pending' AND run_at <= now() AND kind = ANY ($3)
+			ORDER BY run_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+		UPDATE jobs j SET run_at = now() + $2::float8 * interval '1 millisecond'
+		FROM due WHERE j.id = due.id
+		RETURNING j.id, j.kind, j.payload, j.status, j.attempts, j.max_attempts, j.run_at, j.last_error,
+			j.created_at, j.finished_at`, limit, lease.Milliseconds(), kinds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var due []models.Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, j)
+	}
+	return due, rows.Err()
+}
+
+func (s *PostgresJobs) RecordAttempt(ctx context.Context, id int64, attempt models.JobAttempt) error {
+	var runAt interface{}
+	if attempt.Status == models.JobPending {
+		runAt = attempt.RunAt
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE jobs SET attempts = attempts + 1, status = $2,
+			last_error = NULLIF($3, ''), run_at = COALESCE($4, run_at),
+			finished_at = CASE WHEN $2 <> 'pending' THEN now() END
+		WHERE id = $1`, id, attempt.Status, attempt.Error, runAt)
+	return err
+}
+
+func (s *PostgresJobs) Prune(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM jobs WHERE status <> 'pending' AND finished_at < $1", before)
+	return err
+}
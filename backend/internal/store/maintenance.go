@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Maintenance deletes data that is no longer of use. each method returns how many rows it removed.
+type Maintenance interface {
+	// remove users soft-deleted before the given time for good, along with everything of theirs
+	PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error)
+	// remove password reset tokens that were used or have expired
+	DeleteStaleResetTokens(ctx context.Context) (int64, error)
+	// remove expired refresh tokens, then the sessions left revoked or without any
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+	// remove login attempts made before the given time
+	DeleteLoginEvents(ctx context.Context, before time.Time) (int64, error)
+	// remove the data exports of every tenant that have expired, archives and all
+	DeleteExpiredDataExports(ctx context.Context) (int64, error)
+	// remove webhook deliveries queued before the given time that were sent or gave up on
+	DeleteWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+// PostgresMaintenance cleans up the tables of a Postgres database.
+type PostgresMaintenance struct {
+	db *sql.DB
+}
+
+var _ Maintenance = (*PostgresMaintenance)(nil)
+
+func NewPostgresMaintenance(db *sql.DB) *PostgresMaintenance {
+	return &PostgresMaintenance{db: db}
+}
+
+func rowsAffected(res sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// the foreign keys cascade to the user's sessions, tokens, keys and revisions
+func (m *PostgresMaintenance) PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error) {
+	return rowsAffected(m.db.ExecContext(ctx, "DELETE FROM users WHERE deleted_at < $1", before))
+}
+
+func (m *PostgresMaintenance) DeleteStaleResetTokens(ctx context.Context) (int64, error) {
+	return rowsAffected(m.db.ExecContext(ctx, "DELETE FROM password_resets WHERE used_at IS NOT NULL OR expires_at <= now()"))
+}
+
+// a token can no longer be used once it expires, so neither can it reveal a reused family; the count
+// is of sessions removed
+func (m *PostgresMaintenance) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at <= now()"); err != nil {
+		return 0, err
+	}
+	n, err := rowsAffected(tx.ExecContext(ctx, `DELETE FROM sessions WHERE revoked_at IS NOT NULL
+		OR NOT EXISTS (SELECT 1 FROM refresh_tokens WHERE session_id = sessions.id)`))
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (m *PostgresMaintenance) DeleteLoginEvents(ctx context.Context, before time.Time) (int64, error) {
+	return rowsAffected(m.db.ExecContext(ctx, "DELETE FROM login_events WHERE created_at < $1", before))
+}
+
+func (m *PostgresMaintenance) DeleteExpiredDataExports(ctx context.Context) (int64, error) {
+	return rowsAffected(m.db.ExecContext(ctx, "DELETE FROM data_exports WHERE expires_at <= now()"))
+}
+
+func (m *PostgresMaintenance) DeleteWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	return rowsAffected(m.db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < $1", before))
+}
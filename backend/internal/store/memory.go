@@ -0,0 +1,1388 @@
+package store
+
+import (
+	"context"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"api/internal/models"
+	"api/internal/tenant"
+)
+
+// the permissions of each built-in role, as the migrations grant them
+var memoryRoles = map[string][]string{
+	models.RoleAdmin: {models.PermAdminRead, models.PermAuditRead, models.PermFlagsManage, models.PermOrgsManage, models.PermPostsManage,
+		models.PermRolesManage, models.PermImpersonate, models.PermUsersDelete, models.PermUsersRead, models.PermUsersWrite, models.PermWebhooksManage},
+	models.RoleUser: {models.PermUsersRead},
+}
+
+// MemoryUserRepository is a UserRepository holding everything in process memory, for tests and for
+// demos run without Postgres; it is empty when created and forgotten when the process exits. It keeps
+// to the contract of every store, tenant scoping, soft deletes and versions included. Search matches
+// every word of the term against names and emails, FuzzySearch a substring of either, and both rank
+// matches alike.
+type MemoryUserRepository struct {
+	mu  sync.Mutex
+	now func() time.Time
+
+	// the last id given out of each kind, as the serial columns count them
+	ids struct{ users, sessions, logins, impersonations, apiKeys int }
+
+	users          map[int]*memoryUser
+	sessions       map[int]*memorySession
+	refreshTokens  map[string]*memoryRefreshToken // by hex hash
+	resets         map[string]*memoryReset        // by hex hash
+	logins         []models.LoginEvent
+	identities     map[memoryIdentity]int // -> user id
+	impersonations map[int]*memoryImpersonation
+	apiKeys        map[int]*memoryAPIKey
+}
+
+var _ UserRepository = (*MemoryUserRepository)(nil)
+
+// what MemoryUserRepository keeps for a user besides the user itself
+type memoryUser struct {
+	user             models.User
+	tenantID         int
+	passwordHash     string
+	erased           bool
+	tokensValidAfter time.Time
+	failedLogins     int
+	lockedUntil      *time.Time
+	lastLoginAt      *time.Time
+	totpSecret       string
+	totpEnabled      bool
+	totpLastStep     *int64
+	backupCodes      map[string]bool // hex hash -> used
+	pendingEmail     string          // awaiting confirmation, "" if none
+	preferences      *models.NotificationPreferences
+	settings         models.Settings
+	tags             []string
+	revisions        []models.UserRevision
+}
+
+type memorySession struct {
+	session models.Session
+	userID  int
+	revoked bool
+}
+
+type memoryRefreshToken struct {
+	sessionID, userID int
+	expiresAt         time.Time
+	used              bool
+}
+
+type memoryReset struct {
+	userID    int
+	expiresAt time.Time
+	used      bool
+}
+
+type memoryIdentity struct {
+	tenantID          int
+	provider, subject string
+}
+
+type memoryImpersonation struct {
+	impersonation models.Impersonation
+	tenantID      int
+}
+
+type memoryAPIKey struct {
+	key     models.APIKey
+	hash    string
+	revoked bool
+}
+
+// NewMemoryUserRepository returns an empty repository.
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{
+		now:            time.Now,
+		users:          map[int]*memoryUser{},
+		sessions:       map[int]*memorySession{},
+		refreshTokens:  map[string]*memoryRefreshToken{},
+		resets:         map[string]*memoryReset{},
+		identities:     map[memoryIdentity]int{},
+		impersonations: map[int]*memoryImpersonation{},
+		apiKeys:        map[int]*memoryAPIKey{},
+	}
+}
+
+func nextID(id *int) int {
+	*id++
+	return *id
+}
+
+// the user with id in the context's tenant, nil when there is none or it is soft deleted and
+// includeDeleted is false
+func (s *MemoryUserRepository) user(ctx context.Context, id int, includeDeleted bool) *memoryUser {
+	m, ok := s.users[id]
+	if !ok || m.tenantID != tenant.ID(ctx) || !includeDeleted && m.user.DeletedAt != nil {
+		return nil
+	}
+	return m
+}
+
+// live users of the context's tenant that f matches, in id order
+func (s *MemoryUserRepository) matching(ctx context.Context, f models.UserFilter) []models.User {
+	var users []models.User
+	for _, m := range s.users {
+		u := m.user
+		switch {
+		case m.tenantID != tenant.ID(ctx),
+			!f.IncludeDeleted && u.DeletedAt != nil,
+			f.EmailContains != "" && !strings.Contains(strings.ToLower(u.Email), strings.ToLower(f.EmailContains)),
+			f.CreatedAfter != nil && !u.CreatedAt.After(*f.CreatedAfter),
+			f.CreatedBefore != nil && !u.CreatedAt.Before(*f.CreatedBefore),
+			f.Tag != "" && !slices.Contains(m.tags, f.Tag):
+			continue
+		}
+		users = append(users, u)
+	}
+	slices.SortFunc(users, func(a, b models.User) int { return a.Id - b.Id })
+	return users
+}
+
+// record a change to m: the version goes up and a revision is kept, as the users triggers do
+func (s *MemoryUserRepository) touch(m *memoryUser) {
+	now := s.now()
+	m.user.UpdatedAt = now
+	m.user.Version++
+	m.revisions = append(m.revisions, models.UserRevision{Rev: len(m.revisions) + 1, CreatedAt: now, User: m.user})
+}
+
+// the user holding email in the tenant, soft deleted or not, other than except
+func (s *MemoryUserRepository) emailHolder(tenantID int, email string, except int) *memoryUser {
+	for id, m := range s.users {
+		if id != except && m.tenantID == tenantID && strings.EqualFold(m.user.Email, email) {
+			return m
+		}
+	}
+	return nil
+}
+
+func (s *MemoryUserRepository) usernameTaken(tenantID int, username string, except int) bool {
+	for id, m := range s.users {
+		if id != except && m.tenantID == tenantID && m.user.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// the username derived from name, numbered from 2 when that one is taken, as freeUsername picks it
+func (s *MemoryUserRepository) freeUsername(tenantID int, name string, reserved []string) string {
+	base := usernameBase(name)
+	username := base
+	for n := 2; s.usernameTaken(tenantID, username, 0) || slices.Contains(reserved, username); n++ {
+		username = base + "-" + strconv.Itoa(n)
+	}
+	return username
+}
+
+func (s *MemoryUserRepository) hasUsers(tenantID int) bool {
+	for _, m := range s.users {
+		if m.tenantID == tenantID {
+			return true
+		}
+	}
+	return false
+}
+
+// insert u, which must not clash with anyone, as the first admin of an empty tenant
+func (s *MemoryUserRepository) insert(ctx context.Context, u models.User, reserved []string) models.User {
+	tenantID := tenant.ID(ctx)
+	if u.Username == "" {
+		u.Username = s.freeUsername(tenantID, u.Name, reserved)
+	}
+	u.Role = models.RoleUser
+	if !s.hasUsers(tenantID) {
+		u.Role = models.RoleAdmin
+	}
+	u.Id = nextID(&s.ids.users)
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = s.now()
+	}
+	u.Version = 0
+	m := &memoryUser{user: u, tenantID: tenantID, passwordHash: u.PasswordHash, settings: models.Settings{}, backupCodes: map[string]bool{}}
+	m.user.PasswordHash = ""
+	s.users[u.Id] = m
+	s.touch(m)
+	return m.user
+}
+
+// ErrEmailTaken or ErrUsernameTaken when u can't be stored as the user except without clashing
+func (s *MemoryUserRepository) clash(ctx context.Context, u models.User, except int) error {
+	if s.emailHolder(tenant.ID(ctx), u.Email, except) != nil {
+		return ErrEmailTaken
+	}
+	if u.Username != "" && s.usernameTaken(tenant.ID(ctx), u.Username, except) {
+		return ErrUsernameTaken
+	}
+	return nil
+}
+
+func (s *MemoryUserRepository) List(ctx context.Context, f models.UserFilter, sort models.UserSort, limit, offset int) ([]models.User, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := s.matching(ctx, f)
+	compare := func(a, b models.User) int {
+		switch sort.Field {
+		case "name":
+			return strings.Compare(a.Name, b.Name)
+		case "email":
+			return strings.Compare(a.Email, b.Email)
+		case "created_at":
+			return a.CreatedAt.Compare(b.CreatedAt)
+		}
+		return 0
+	}
+	slices.SortStableFunc(users, func(a, b models.User) int {
+		c := compare(a, b)
+		if c == 0 {
+			c = a.Id - b.Id
+		}
+		if sort.Desc {
+			return -c
+		}
+		return c
+	})
+	return page(users, limit, offset), len(users), nil
+}
+
+// users[offset:offset+limit], empty rather than nil
+func page[T any](items []T, limit, offset int) []T {
+	if offset > len(items) {
+		offset = len(items)
+	}
+	end := len(items)
+	if limit >= 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return append([]T{}, items[offset:end]...)
+}
+
+func (s *MemoryUserRepository) ListAfter(ctx context.Context, f models.UserFilter, after *models.UserCursor, desc bool, limit int) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := s.matching(ctx, f)
+	slices.SortFunc(users, func(a, b models.User) int {
+		c := a.CreatedAt.Compare(b.CreatedAt)
+		if c == 0 {
+			c = a.Id - b.Id
+		}
+		if desc {
+			return -c
+		}
+		return c
+	})
+	if after != nil {
+		users = slices.DeleteFunc(users, func(u models.User) bool {
+			c := u.CreatedAt.Compare(after.CreatedAt)
+			if c == 0 {
+				c = u.Id - after.Id
+			}
+			return desc && c >= 0 || !desc && c <= 0
+		})
+	}
+	return page(users, limit, 0), nil
+}
+
+// every write holds the lock, so there is no transaction in flight to hold changes back for; users
+// are never purged, so the soft deleted ones are the tombstones
+func (s *MemoryUserRepository) Changes(ctx context.Context, after *models.SyncCursor, limit int) (models.UserChanges, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	horizon := s.now()
+	type change struct {
+		cursor models.SyncCursor
+		user   models.User
+	}
+	var changes []change
+	for _, m := range s.users {
+		u := m.user
+		if m.tenantID != tenant.ID(ctx) || after == nil && u.DeletedAt != nil {
+			continue
+		}
+		changes = append(changes, change{cursor: models.SyncCursor{ChangedAt: u.UpdatedAt, Id: u.Id}, user: u})
+	}
+	slices.SortFunc(changes, func(a, b change) int {
+		if c := a.cursor.ChangedAt.Compare(b.cursor.ChangedAt); c != 0 {
+			return c
+		}
+		return a.cursor.Id - b.cursor.Id
+	})
+	if after != nil {
+		changes = slices.DeleteFunc(changes, func(c change) bool {
+			return !syncBefore(after.ChangedAt, after.Id, c.cursor.ChangedAt, c.cursor.Id)
+		})
+	}
+
+	c := models.UserChanges{Users: []models.User{}, Deleted: []models.UserTombstone{}, More: len(changes) > limit}
+	for _, ch := range page(changes, limit, 0) {
+		if ch.user.DeletedAt != nil {
+			c.Deleted = append(c.Deleted, models.UserTombstone{Id: ch.user.Id, DeletedAt: *ch.user.DeletedAt})
+		} else {
+			c.Users = append(c.Users, ch.user)
+		}
+		c.Next = ch.cursor
+	}
+	if !c.More {
+		c.Next = models.SyncCursor{ChangedAt: horizon}
+	}
+	return c, nil
+}
+
+func (s *MemoryUserRepository) Stats(ctx context.Context) (models.UserStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := models.UserStats{ByRole: map[string]int{}}
+	for role := range memoryRoles {
+		st.ByRole[role] = 0
+	}
+	now := s.now()
+	for _, u := range s.matching(ctx, models.UserFilter{}) {
+		st.Total++
+		if u.EmailVerifiedAt != nil {
+			st.ByVerification.Verified++
+		}
+		st.ByRole[u.Role]++
+		age := now.Sub(u.CreatedAt)
+		if age < 24*time.Hour {
+			st.Created.Last24h++
+		}
+		if age < 7*24*time.Hour {
+			st.Created.Last7d++
+		}
+		if age < 30*24*time.Hour {
+			st.Created.Last30d++
+		}
+	}
+	st.ByVerification.Unverified = st.Total - st.ByVerification.Verified
+	return st, nil
+}
+
+// the start of the bucket of unit holding t, in UTC
+func bucketStart(unit string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch unit {
+	case "week":
+		// weeks start on Monday, as date_trunc has them
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day
+}
+
+func nextBucket(unit string, start time.Time) time.Time {
+	switch unit {
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+func (s *MemoryUserRepository) Signups(ctx context.Context, unit string, from, to time.Time, includeDeleted bool) ([]models.SignupBucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := s.matching(ctx, models.UserFilter{IncludeDeleted: includeDeleted})
+	buckets := []models.SignupBucket{}
+	for start := bucketStart(unit, from); !start.After(to.UTC()); start = nextBucket(unit, start) {
+		b := models.SignupBucket{Start: start}
+		end := nextBucket(unit, start)
+		for _, u := range users {
+			if !u.CreatedAt.Before(start) && u.CreatedAt.Before(end) {
+				b.Count++
+			}
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// fn is called without the lock held, so it may use the repository
+func (s *MemoryUserRepository) Each(ctx context.Context, f models.UserFilter, fn func(models.User) error) error {
+	s.mu.Lock()
+	users := s.matching(ctx, f)
+	s.mu.Unlock()
+	for _, u := range users {
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryUserRepository) Get(ctx context.Context, id int, includeDeleted bool) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, id, includeDeleted)
+	if m == nil {
+		return models.User{}, ErrUserNotFound
+	}
+	return m.user, nil
+}
+
+func (s *MemoryUserRepository) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.emailHolder(tenant.ID(ctx), email, 0)
+	if m == nil || m.user.DeletedAt != nil {
+		return models.User{}, ErrUserNotFound
+	}
+	u := m.user
+	u.PasswordHash = m.passwordHash
+	return u, nil
+}
+
+func (s *MemoryUserRepository) GetByUsername(ctx context.Context, username string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.users {
+		if m.tenantID == tenant.ID(ctx) && m.user.DeletedAt == nil && m.user.Username == username {
+			return m.user, nil
+		}
+	}
+	return models.User{}, ErrUserNotFound
+}
+
+func (s *MemoryUserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.emailHolder(tenant.ID(ctx), email, 0) != nil, nil
+}
+
+func (s *MemoryUserRepository) ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing := map[string]bool{}
+	for _, email := range emails {
+		if m := s.emailHolder(tenant.ID(ctx), email, 0); m != nil {
+			existing[strings.ToLower(m.user.Email)] = true
+		}
+	}
+	return existing, nil
+}
+
+func (s *MemoryUserRepository) Create(ctx context.Context, u models.User) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.clash(ctx, u, 0); err != nil {
+		return models.User{}, err
+	}
+	u.CreatedAt = time.Time{}
+	return s.insert(ctx, u, nil), nil
+}
+
+func (s *MemoryUserRepository) Upsert(ctx context.Context, u models.User) (models.User, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.emailHolder(tenant.ID(ctx), u.Email, 0)
+	if m == nil {
+		if err := s.clash(ctx, u, 0); err != nil {
+			return models.User{}, false, err
+		}
+		u.CreatedAt = time.Time{}
+		return s.insert(ctx, u, nil), true, nil
+	}
+	if m.user.DeletedAt != nil {
+		return models.User{}, false, ErrEmailTaken
+	}
+	if u.Username != "" && s.usernameTaken(m.tenantID, u.Username, m.user.Id) {
+		return models.User{}, false, ErrUsernameTaken
+	}
+	s.overwrite(m, u)
+	return m.user, false, nil
+}
+
+// what Update changes on a user: the name, email and profile, and the username when u has one
+func (s *MemoryUserRepository) overwrite(m *memoryUser, u models.User) {
+	if !strings.EqualFold(m.user.Email, u.Email) {
+		m.user.EmailVerifiedAt = nil
+	}
+	m.user.Name, m.user.Email = u.Name, u.Email
+	m.user.Phone, m.user.Bio, m.user.Timezone, m.user.Locale = u.Phone, u.Bio, u.Timezone, u.Locale
+	if u.Username != "" {
+		m.user.Username = u.Username
+	}
+	s.touch(m)
+}
+
+func (s *MemoryUserRepository) CreateMany(ctx context.Context, users []models.User) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	emails := map[string]bool{}
+	var given []string
+	for _, u := range users {
+		if err := s.clash(ctx, u, 0); err != nil {
+			return nil, err
+		}
+		if emails[strings.ToLower(u.Email)] {
+			return nil, ErrEmailTaken
+		}
+		emails[strings.ToLower(u.Email)] = true
+		if u.Username != "" {
+			if slices.Contains(given, u.Username) {
+				return nil, ErrUsernameTaken
+			}
+			given = append(given, u.Username)
+		}
+	}
+	created := make([]models.User, len(users))
+	for i, u := range users {
+		created[i] = s.insert(ctx, u, given)
+	}
+	return created, nil
+}
+
+func (s *MemoryUserRepository) Update(ctx context.Context, id int, u models.User, version int) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, id, false)
+	if m == nil {
+		return models.User{}, ErrUserNotFound
+	}
+	if version != 0 && m.user.Version != version {
+		return models.User{}, ErrVersionConflict
+	}
+	if err := s.clash(ctx, u, id); err != nil {
+		return models.User{}, err
+	}
+	s.overwrite(m, u)
+	return m.user, nil
+}
+
+func (s *MemoryUserRepository) delete(m *memoryUser) {
+	now := s.now()
+	m.user.DeletedAt = &now
+	s.touch(m)
+}
+
+func (s *MemoryUserRepository) Delete(ctx context.Context, id int) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, id, false)
+	if m == nil {
+		return models.User{}, ErrUserNotFound
+	}
+	s.delete(m)
+	return m.user, nil
+}
+
+func (s *MemoryUserRepository) DeleteMatching(ctx context.Context, ids []int64, createdBefore *time.Time) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := []models.User{}
+	for _, u := range s.matching(ctx, models.UserFilter{CreatedBefore: createdBefore}) {
+		if len(ids) > 0 && !slices.Contains(ids, int64(u.Id)) {
+			continue
+		}
+		m := s.users[u.Id]
+		s.delete(m)
+		deleted = append(deleted, m.user)
+	}
+	return deleted, nil
+}
+
+func (s *MemoryUserRepository) Erase(ctx context.Context, id int) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, id, true)
+	if m == nil || m.erased {
+		return models.User{}, ErrUserNotFound
+	}
+	email := m.user.Email
+	now := s.now()
+	m.user = models.User{Id: id, Name: "Erased user", Email: "erased-" + strconv.Itoa(id) + "@erased.invalid", Username: "erased_" + strconv.Itoa(id),
+		CreatedAt: m.user.CreatedAt, DeletedAt: m.user.DeletedAt, Role: m.user.Role, Version: m.user.Version}
+	if m.user.DeletedAt == nil {
+		m.user.DeletedAt = &now
+	}
+	*m = memoryUser{user: m.user, tenantID: m.tenantID, erased: true, tokensValidAfter: now, settings: models.Settings{}, backupCodes: map[string]bool{}, tags: m.tags}
+	s.touch(m)
+	m.revisions = m.revisions[len(m.revisions)-1:]
+	m.revisions[0].Rev = 1
+
+	for hash, t := range s.refreshTokens {
+		if t.userID == id {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	for sid, sess := range s.sessions {
+		if sess.userID == id {
+			delete(s.sessions, sid)
+		}
+	}
+	for kid, k := range s.apiKeys {
+		if k.key.UserId == id {
+			delete(s.apiKeys, kid)
+		}
+	}
+	for hash, r := range s.resets {
+		if r.userID == id {
+			delete(s.resets, hash)
+		}
+	}
+	for key, userID := range s.identities {
+		if userID == id {
+			delete(s.identities, key)
+		}
+	}
+	s.logins = slices.DeleteFunc(s.logins, func(e models.LoginEvent) bool {
+		return e.UserId != nil && *e.UserId == id || e.UserId == nil && strings.EqualFold(e.Email, email)
+	})
+	return m.user, nil
+}
+
+func (s *MemoryUserRepository) Merge(ctx context.Context, id, sourceID int) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target := s.user(ctx, id, false)
+	if target == nil {
+		return models.User{}, ErrUserNotFound
+	}
+	source := s.user(ctx, sourceID, false)
+	if source == nil || sourceID == id {
+		return models.User{}, ErrMergeSourceNotFound
+	}
+
+	for _, sess := range s.sessions {
+		if sess.userID == sourceID {
+			sess.userID = id
+		}
+	}
+	for _, t := range s.refreshTokens {
+		if t.userID == sourceID {
+			t.userID = id
+		}
+	}
+	for _, k := range s.apiKeys {
+		if k.key.UserId == sourceID {
+			k.key.UserId = id
+		}
+	}
+	for key, userID := range s.identities {
+		if userID == sourceID {
+			s.identities[key] = id
+		}
+	}
+	for _, tag := range source.tags {
+		if !slices.Contains(target.tags, tag) {
+			target.tags = append(target.tags, tag)
+		}
+	}
+	slices.Sort(target.tags)
+	source.tags = nil
+
+	newer := source.user.UpdatedAt.After(target.user.UpdatedAt)
+	pick := func(t, s *string) *string {
+		if newer && s != nil || t == nil {
+			return s
+		}
+		return t
+	}
+	u := &target.user
+	if newer {
+		u.Name = source.user.Name
+	}
+	u.Phone, u.Bio = pick(u.Phone, source.user.Phone), pick(u.Bio, source.user.Bio)
+	u.Timezone, u.Locale = pick(u.Timezone, source.user.Timezone), pick(u.Locale, source.user.Locale)
+	u.AvatarURL = pick(u.AvatarURL, source.user.AvatarURL)
+	s.delete(source)
+	s.touch(target)
+	return target.user, nil
+}
+
+func (s *MemoryUserRepository) Restore(ctx context.Context, id int) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, id, true)
+	if m == nil || m.user.DeletedAt == nil || m.erased {
+		return models.User{}, ErrUserNotFound
+	}
+	m.user.DeletedAt = nil
+	s.touch(m)
+	return m.user, nil
+}
+
+// apply fn to a live user and record the change
+func (s *MemoryUserRepository) change(ctx context.Context, id int, fn func(m *memoryUser)) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, id, false)
+	if m == nil {
+		return models.User{}, ErrUserNotFound
+	}
+	fn(m)
+	s.touch(m)
+	return m.user, nil
+}
+
+func (s *MemoryUserRepository) SetAvatarURL(ctx context.Context, id int, url string) (models.User, error) {
+	return s.change(ctx, id, func(m *memoryUser) { m.user.AvatarURL = &url })
+}
+
+func (s *MemoryUserRepository) MarkEmailVerified(ctx context.Context, id int, email string) (models.User, error) {
+	s.mu.Lock()
+	m := s.user(ctx, id, false)
+	current := m != nil && m.user.Email == email
+	s.mu.Unlock()
+	if !current {
+		return models.User{}, ErrUserNotFound
+	}
+	return s.change(ctx, id, func(m *memoryUser) {
+		if m.user.EmailVerifiedAt == nil {
+			now := s.now()
+			m.user.EmailVerifiedAt = &now
+		}
+	})
+}
+
+func (s *MemoryUserRepository) RequestEmailChange(ctx context.Context, id int, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.emailHolder(tenant.ID(ctx), email, 0) != nil {
+		return ErrEmailTaken
+	}
+	m := s.user(ctx, id, false)
+	if m == nil {
+		return ErrUserNotFound
+	}
+	m.pendingEmail = email
+	return nil
+}
+
+func (s *MemoryUserRepository) ConfirmEmailChange(ctx context.Context, id int, email string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, id, false)
+	if m == nil || m.pendingEmail == "" || !strings.EqualFold(m.pendingEmail, email) {
+		return models.User{}, ErrUserNotFound
+	}
+	if s.emailHolder(m.tenantID, m.pendingEmail, id) != nil {
+		return models.User{}, ErrEmailTaken
+	}
+	now := s.now()
+	m.user.Email, m.user.EmailVerifiedAt, m.tokensValidAfter, m.pendingEmail = m.pendingEmail, &now, now, ""
+	for _, sess := range s.sessions {
+		if sess.userID == id {
+			sess.revoked = true
+		}
+	}
+	s.touch(m)
+	return m.user, nil
+}
+
+// read or change something kept for a live user that isn't part of the user itself
+func (s *MemoryUserRepository) with(ctx context.Context, id int, fn func(m *memoryUser)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, id, false)
+	if m == nil {
+		return ErrUserNotFound
+	}
+	fn(m)
+	return nil
+}
+
+func (s *MemoryUserRepository) PasswordHash(ctx context.Context, id int) (string, error) {
+	var hash string
+	err := s.with(ctx, id, func(m *memoryUser) { hash = m.passwordHash })
+	return hash, err
+}
+
+func (s *MemoryUserRepository) SetPasswordHash(ctx context.Context, id int, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.user(ctx, id, true); m != nil {
+		m.passwordHash = hash
+	}
+	return nil
+}
+
+func (s *MemoryUserRepository) TokensValidAfter(ctx context.Context, id int) (time.Time, error) {
+	var after time.Time
+	err := s.with(ctx, id, func(m *memoryUser) { after = m.tokensValidAfter })
+	return after, err
+}
+
+func (s *MemoryUserRepository) CreatePasswordReset(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.user(ctx, userID, true) != nil {
+		s.resets[hex.EncodeToString(tokenHash)] = &memoryReset{userID: userID, expiresAt: expiresAt}
+	}
+	return nil
+}
+
+// the unspent, unexpired reset with the hash and its live user in the context's tenant
+func (s *MemoryUserRepository) reset(ctx context.Context, tokenHash []byte) (*memoryReset, *memoryUser) {
+	r, ok := s.resets[hex.EncodeToString(tokenHash)]
+	if !ok || r.used || !r.expiresAt.After(s.now()) {
+		return nil, nil
+	}
+	m := s.user(ctx, r.userID, false)
+	if m == nil {
+		return nil, nil
+	}
+	return r, m
+}
+
+func (s *MemoryUserRepository) PasswordResetEmail(ctx context.Context, tokenHash []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, m := s.reset(ctx, tokenHash)
+	if m == nil {
+		return "", ErrInvalidResetToken
+	}
+	return m.user.Email, nil
+}
+
+func (s *MemoryUserRepository) ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, m := s.reset(ctx, tokenHash)
+	if m == nil {
+		return 0, ErrInvalidResetToken
+	}
+	m.passwordHash, m.tokensValidAfter = passwordHash, s.now()
+	for _, r := range s.resets {
+		if r.userID == m.user.Id {
+			r.used = true
+		}
+	}
+	for _, sess := range s.sessions {
+		if sess.userID == m.user.Id {
+			sess.revoked = true
+		}
+	}
+	return m.user.Id, nil
+}
+
+func (s *MemoryUserRepository) CreateSession(ctx context.Context, userID int, client models.SessionClient, refreshTokenHash []byte, expiresAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.user(ctx, userID, true) == nil {
+		return 0, ErrUserNotFound
+	}
+	now := s.now()
+	id := nextID(&s.ids.sessions)
+	s.sessions[id] = &memorySession{userID: userID, session: models.Session{Id: id, UserAgent: client.UserAgent, IP: client.IP, CreatedAt: now, LastSeenAt: now}}
+	s.refreshTokens[hex.EncodeToString(refreshTokenHash)] = &memoryRefreshToken{sessionID: id, userID: userID, expiresAt: expiresAt}
+	return id, nil
+}
+
+func (s *MemoryUserRepository) RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash []byte, expiresAt time.Time, client models.SessionClient) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.refreshTokens[hex.EncodeToString(tokenHash)]
+	if !ok || s.user(ctx, t.userID, true) == nil {
+		return 0, 0, ErrInvalidRefreshToken
+	}
+	sess := s.sessions[t.sessionID]
+	if sess == nil || sess.revoked || !t.expiresAt.After(s.now()) || s.user(ctx, t.userID, false) == nil {
+		return 0, 0, ErrInvalidRefreshToken
+	}
+	if t.used {
+		sess.revoked = true
+		return 0, 0, ErrRefreshTokenReused
+	}
+	t.used = true
+	s.refreshTokens[hex.EncodeToString(newTokenHash)] = &memoryRefreshToken{sessionID: t.sessionID, userID: t.userID, expiresAt: expiresAt}
+	sess.session.LastSeenAt, sess.session.UserAgent, sess.session.IP = s.now(), client.UserAgent, client.IP
+	return t.userID, t.sessionID, nil
+}
+
+func (s *MemoryUserRepository) RevokeRefreshToken(ctx context.Context, tokenHash []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.refreshTokens[hex.EncodeToString(tokenHash)]; ok && s.user(ctx, t.userID, true) != nil {
+		if sess := s.sessions[t.sessionID]; sess != nil {
+			sess.revoked = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryUserRepository) Sessions(ctx context.Context, userID int) ([]models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := []models.Session{}
+	if s.user(ctx, userID, true) == nil {
+		return sessions, nil
+	}
+	for id, sess := range s.sessions {
+		if sess.userID != userID || sess.revoked {
+			continue
+		}
+		// a session whose refresh tokens have all expired is over
+		live := false
+		for _, t := range s.refreshTokens {
+			live = live || t.sessionID == id && !t.used && t.expiresAt.After(s.now())
+		}
+		if live {
+			sessions = append(sessions, sess.session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b models.Session) int {
+		if c := b.LastSeenAt.Compare(a.LastSeenAt); c != 0 {
+			return c
+		}
+		return b.Id - a.Id
+	})
+	return sessions, nil
+}
+
+func (s *MemoryUserRepository) RevokeSession(ctx context.Context, userID, sessionID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[sessionID]
+	if !ok || sess.userID != userID || sess.revoked || s.user(ctx, userID, true) == nil {
+		return ErrSessionNotFound
+	}
+	sess.revoked = true
+	return nil
+}
+
+func (s *MemoryUserRepository) SessionRevoked(ctx context.Context, sessionID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[sessionID]
+	return !ok || sess.revoked, nil
+}
+
+func (s *MemoryUserRepository) RecordLogin(ctx context.Context, e models.LoginEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.Id = int64(nextID(&s.ids.logins))
+	e.CreatedAt = s.now()
+	s.logins = append(s.logins, e)
+	if e.Success && e.UserId != nil {
+		if m := s.user(ctx, *e.UserId, true); m != nil {
+			m.lastLoginAt, m.failedLogins = &e.CreatedAt, 0
+		}
+	}
+	return nil
+}
+
+func (s *MemoryUserRepository) RecordFailedLogin(ctx context.Context, id, threshold int, lockout time.Duration) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := s.with(ctx, id, func(m *memoryUser) {
+		m.failedLogins++
+		m.lockedUntil = nil
+		if m.failedLogins >= threshold {
+			until := s.now().Add(lockout)
+			m.failedLogins, m.lockedUntil, lockedUntil = 0, &until, &until
+		}
+	})
+	return lockedUntil, err
+}
+
+func (s *MemoryUserRepository) LockedUntil(ctx context.Context, id int) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := s.with(ctx, id, func(m *memoryUser) {
+		if m.lockedUntil != nil && m.lockedUntil.After(s.now()) {
+			lockedUntil = m.lockedUntil
+		}
+	})
+	return lockedUntil, err
+}
+
+func (s *MemoryUserRepository) Unlock(ctx context.Context, id int) error {
+	return s.with(ctx, id, func(m *memoryUser) { m.failedLogins, m.lockedUntil = 0, nil })
+}
+
+func (s *MemoryUserRepository) UserByIdentity(ctx context.Context, provider, subject string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.identities[memoryIdentity{tenant.ID(ctx), provider, subject}]
+	m := s.user(ctx, id, false)
+	if !ok || m == nil {
+		return models.User{}, ErrUserNotFound
+	}
+	return m.user, nil
+}
+
+func (s *MemoryUserRepository) LinkIdentity(ctx context.Context, userID int, provider, subject, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := memoryIdentity{tenant.ID(ctx), provider, subject}
+	if _, linked := s.identities[key]; !linked && s.user(ctx, userID, true) != nil {
+		s.identities[key] = userID
+	}
+	return nil
+}
+
+func (s *MemoryUserRepository) Logins(ctx context.Context, userID, limit, offset int) ([]models.LoginEvent, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []models.LoginEvent
+	if s.user(ctx, userID, true) != nil {
+		for _, e := range slices.Backward(s.logins) {
+			if e.UserId != nil && *e.UserId == userID {
+				events = append(events, e)
+			}
+		}
+	}
+	return page(events, limit, offset), len(events), nil
+}
+
+func (s *MemoryUserRepository) LastLoginAt(ctx context.Context, id int) (*time.Time, error) {
+	var at *time.Time
+	err := s.with(ctx, id, func(m *memoryUser) { at = m.lastLoginAt })
+	return at, err
+}
+
+func (s *MemoryUserRepository) StartImpersonation(ctx context.Context, adminID, userID int, reason string, expiresAt time.Time) (models.Impersonation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.user(ctx, userID, false) == nil {
+		return models.Impersonation{}, ErrUserNotFound
+	}
+	i := models.Impersonation{Id: nextID(&s.ids.impersonations), AdminId: adminID, UserId: userID, Reason: reason, StartedAt: s.now(), ExpiresAt: expiresAt}
+	s.impersonations[i.Id] = &memoryImpersonation{impersonation: i, tenantID: tenant.ID(ctx)}
+	return i, nil
+}
+
+func (s *MemoryUserRepository) EndImpersonation(ctx context.Context, id int) (models.Impersonation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.impersonations[id]
+	if !ok || i.tenantID != tenant.ID(ctx) || i.impersonation.EndedAt != nil || !i.impersonation.ExpiresAt.After(s.now()) {
+		return models.Impersonation{}, ErrImpersonationNotFound
+	}
+	now := s.now()
+	i.impersonation.EndedAt = &now
+	return i.impersonation, nil
+}
+
+func (s *MemoryUserRepository) ImpersonationEnded(ctx context.Context, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.impersonations[id]
+	return !ok || i.impersonation.EndedAt != nil || !i.impersonation.ExpiresAt.After(s.now()), nil
+}
+
+func (s *MemoryUserRepository) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	return s.with(ctx, id, func(m *memoryUser) { m.totpSecret, m.totpEnabled, m.totpLastStep = secret, false, nil })
+}
+
+func (s *MemoryUserRepository) TOTP(ctx context.Context, id int) (string, bool, error) {
+	var secret string
+	var enabled bool
+	err := s.with(ctx, id, func(m *memoryUser) { secret, enabled = m.totpSecret, m.totpEnabled })
+	return secret, enabled, err
+}
+
+func (s *MemoryUserRepository) UseTOTPStep(ctx context.Context, id int, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, id, true)
+	if m == nil || m.totpLastStep != nil && *m.totpLastStep >= step {
+		return false, nil
+	}
+	m.totpLastStep = &step
+	return true, nil
+}
+
+func (s *MemoryUserRepository) EnableTOTP(ctx context.Context, id int, backupCodeHashes [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.user(ctx, id, true); m != nil {
+		m.totpEnabled = true
+		m.backupCodes = backupCodes(backupCodeHashes)
+	}
+	return nil
+}
+
+func backupCodes(hashes [][]byte) map[string]bool {
+	codes := map[string]bool{}
+	for _, h := range hashes {
+		codes[hex.EncodeToString(h)] = false
+	}
+	return codes
+}
+
+func (s *MemoryUserRepository) ReplaceBackupCodes(ctx context.Context, id int, codeHashes [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.user(ctx, id, true); m != nil {
+		m.backupCodes = backupCodes(codeHashes)
+	}
+	return nil
+}
+
+func (s *MemoryUserRepository) UseBackupCode(ctx context.Context, id int, codeHash []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, id, true)
+	if m == nil {
+		return false, nil
+	}
+	used, ok := m.backupCodes[hex.EncodeToString(codeHash)]
+	if !ok || used {
+		return false, nil
+	}
+	m.backupCodes[hex.EncodeToString(codeHash)] = true
+	return true, nil
+}
+
+func (s *MemoryUserRepository) DisableTOTP(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.user(ctx, id, true); m != nil {
+		m.totpSecret, m.totpEnabled, m.totpLastStep, m.backupCodes = "", false, nil, map[string]bool{}
+	}
+	return nil
+}
+
+func (s *MemoryUserRepository) NotificationPreferences(ctx context.Context, id int) (models.NotificationPreferences, error) {
+	p := models.DefaultNotificationPreferences
+	err := s.with(ctx, id, func(m *memoryUser) {
+		if m.preferences != nil {
+			p = *m.preferences
+		}
+	})
+	return p, err
+}
+
+func (s *MemoryUserRepository) SetNotificationPreferences(ctx context.Context, id int, p models.NotificationPreferences) error {
+	return s.with(ctx, id, func(m *memoryUser) { m.preferences = &p })
+}
+
+func (s *MemoryUserRepository) Settings(ctx context.Context, id int) (models.Settings, error) {
+	var settings models.Settings
+	err := s.with(ctx, id, func(m *memoryUser) { settings = maps.Clone(m.settings) })
+	return settings, err
+}
+
+// fn runs with the repository locked, so it must not call back into it
+func (s *MemoryUserRepository) UpdateSettings(ctx context.Context, id int, fn func(models.Settings) (models.Settings, error)) (models.Settings, error) {
+	var settings models.Settings
+	var fnErr error
+	err := s.with(ctx, id, func(m *memoryUser) {
+		if settings, fnErr = fn(maps.Clone(m.settings)); fnErr == nil {
+			m.settings = maps.Clone(settings)
+		}
+	})
+	if err == nil {
+		err = fnErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (s *MemoryUserRepository) HasPermission(ctx context.Context, userID int, permission string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, userID, false)
+	return m != nil && slices.Contains(memoryRoles[m.user.Role], permission), nil
+}
+
+func (s *MemoryUserRepository) Roles(ctx context.Context) ([]models.Role, error) {
+	roles := []models.Role{}
+	for _, name := range slices.Sorted(maps.Keys(memoryRoles)) {
+		roles = append(roles, models.Role{Name: name, Permissions: slices.Sorted(slices.Values(memoryRoles[name]))})
+	}
+	return roles, nil
+}
+
+func (s *MemoryUserRepository) SetRole(ctx context.Context, id int, role string) (models.User, error) {
+	if _, ok := memoryRoles[role]; !ok {
+		return models.User{}, ErrUnknownRole
+	}
+	s.mu.Lock()
+	var admins []int
+	for _, u := range s.matching(ctx, models.UserFilter{}) {
+		if u.Role == models.RoleAdmin {
+			admins = append(admins, u.Id)
+		}
+	}
+	s.mu.Unlock()
+	if role != models.RoleAdmin && len(admins) == 1 && admins[0] == id {
+		return models.User{}, ErrLastAdmin
+	}
+	return s.change(ctx, id, func(m *memoryUser) { m.user.Role = role })
+}
+
+func (s *MemoryUserRepository) Revisions(ctx context.Context, userID int) ([]models.UserRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revisions := []models.UserRevision{}
+	if m := s.user(ctx, userID, true); m != nil {
+		revisions = append(revisions, m.revisions...)
+	}
+	return revisions, nil
+}
+
+func (s *MemoryUserRepository) Revision(ctx context.Context, userID, rev int) (models.UserRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, userID, true)
+	if m == nil || rev < 1 || rev > len(m.revisions) {
+		return models.UserRevision{}, ErrRevisionNotFound
+	}
+	return m.revisions[rev-1], nil
+}
+
+func (s *MemoryUserRepository) CreateAPIKey(ctx context.Context, userID int, name, prefix string, keyHash []byte) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.user(ctx, userID, false) == nil {
+		return models.APIKey{}, ErrUserNotFound
+	}
+	k := models.APIKey{Id: nextID(&s.ids.apiKeys), UserId: userID, Name: name, Prefix: prefix, CreatedAt: s.now()}
+	s.apiKeys[k.Id] = &memoryAPIKey{key: k, hash: hex.EncodeToString(keyHash)}
+	return k, nil
+}
+
+func (s *MemoryUserRepository) APIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []models.APIKey{}
+	if s.user(ctx, userID, true) == nil {
+		return keys, nil
+	}
+	for _, k := range s.apiKeys {
+		if k.key.UserId == userID && !k.revoked {
+			keys = append(keys, k.key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b models.APIKey) int { return b.Id - a.Id })
+	return keys, nil
+}
+
+func (s *MemoryUserRepository) RevokeAPIKey(ctx context.Context, userID, keyID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.apiKeys[keyID]
+	if !ok || k.key.UserId != userID || k.revoked || s.user(ctx, userID, true) == nil {
+		return ErrAPIKeyNotFound
+	}
+	k.revoked = true
+	return nil
+}
+
+// the key says which tenant its user is in, so this lookup isn't scoped
+func (s *MemoryUserRepository) APIKeyUser(ctx context.Context, keyHash []byte) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.apiKeys {
+		if k.hash != hex.EncodeToString(keyHash) || k.revoked {
+			continue
+		}
+		m := s.users[k.key.UserId]
+		if m == nil || m.user.DeletedAt != nil {
+			break
+		}
+		now := s.now()
+		k.key.LastUsedAt = &now
+		return m.user.Id, m.tenantID, nil
+	}
+	return 0, 0, ErrAPIKeyNotFound
+}
+
+// whether every word of term is one of the words of a user's name or email, telling their parts
+// apart, which stands in for full text search where there is none
+func wordMatch(term string) func(models.User) bool {
+	words := strings.Fields(strings.ToLower(term))
+	return func(u models.User) bool {
+		text := strings.Fields(strings.ToLower(u.Name + " " + strings.NewReplacer("@", " ", ".", " ").Replace(u.Email)))
+		for _, w := range words {
+			if !slices.Contains(text, w) {
+				return false
+			}
+		}
+		return len(words) > 0
+	}
+}
+
+// whether term is in a user's name or email, whatever the case, which stands in for similarity
+// where there are no trigrams to rank by
+func substringMatch(term string) func(models.User) bool {
+	term = strings.ToLower(term)
+	return func(u models.User) bool {
+		return term != "" && (strings.Contains(strings.ToLower(u.Name), term) || strings.Contains(strings.ToLower(u.Email), term))
+	}
+}
+
+func (s *MemoryUserRepository) Search(ctx context.Context, term string, limit, offset int) ([]models.SearchResult, error) {
+	return s.search(ctx, limit, offset, wordMatch(term))
+}
+
+func (s *MemoryUserRepository) FuzzySearch(ctx context.Context, term string, threshold float64, limit, offset int) ([]models.SearchResult, error) {
+	return s.search(ctx, limit, offset, substringMatch(term))
+}
+
+func (s *MemoryUserRepository) search(ctx context.Context, limit, offset int, match func(models.User) bool) ([]models.SearchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []models.SearchResult
+	for _, u := range s.matching(ctx, models.UserFilter{}) {
+		if match(u) {
+			results = append(results, models.SearchResult{User: u, Rank: 1})
+		}
+	}
+	return page(results, limit, offset), nil
+}
+
+func (s *MemoryUserRepository) Tags(ctx context.Context) ([]models.Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := map[string]int{}
+	for _, u := range s.matching(ctx, models.UserFilter{}) {
+		for _, tag := range s.users[u.Id].tags {
+			counts[tag]++
+		}
+	}
+	tags := []models.Tag{}
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		tags = append(tags, models.Tag{Name: name, Users: counts[name]})
+	}
+	return tags, nil
+}
+
+func (s *MemoryUserRepository) UserTags(ctx context.Context, userID int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := []string{}
+	if m := s.user(ctx, userID, true); m != nil {
+		tags = append(tags, m.tags...)
+	}
+	return tags, nil
+}
+
+func (s *MemoryUserRepository) TagUser(ctx context.Context, userID int, tag string) ([]string, error) {
+	var tags []string
+	err := s.with(ctx, userID, func(m *memoryUser) {
+		if !slices.Contains(m.tags, tag) {
+			m.tags = append(m.tags, tag)
+			slices.Sort(m.tags)
+		}
+		tags = slices.Clone(m.tags)
+	})
+	return tags, err
+}
+
+func (s *MemoryUserRepository) UntagUser(ctx context.Context, userID int, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.user(ctx, userID, true)
+	if m == nil || !slices.Contains(m.tags, tag) {
+		return ErrTagNotFound
+	}
+	m.tags = slices.DeleteFunc(m.tags, func(t string) bool { return t == tag })
+	return nil
+}
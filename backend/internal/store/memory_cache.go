@@ -0,0 +1,108 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryCache is an in-process LRU Cache for deployments without Redis. each replica keeps its own
+// entries, so a write on one replica only invalidates that replica's cache; a UserChangeListener
+// evicts the rest, and the TTL bounds what it misses
+type MemoryCache struct {
+	mu      sync.Mutex
+	size    int
+	entries *list.List // most recently used at the front
+	index   map[string]*list.Element
+	// counters are kept apart from the entries so eviction can never roll a generation back
+	counters map[string]int64
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+var _ Cache = (*MemoryCache)(nil)
+
+// NewMemoryCache holds at most size entries, evicting the least recently used first.
+func NewMemoryCache(size int) *MemoryCache {
+	return &MemoryCache{
+		size:     size,
+		entries:  list.New(),
+		index:    map[string]*list.Element{},
+		counters: map[string]int64{},
+	}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.counters[key]; ok {
+		return []byte(strconv.FormatInt(n, 10)), true, nil
+	}
+	el, ok := c.index[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*memoryEntry)
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		return nil, false, nil
+	}
+	c.entries.MoveToFront(el)
+	return e.value, true, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(ttl)
+	if el, ok := c.index[key]; ok {
+		e := el.Value.(*memoryEntry)
+		e.value, e.expires = value, expires
+		c.entries.MoveToFront(el)
+		return nil
+	}
+	c.index[key] = c.entries.PushFront(&memoryEntry{key: key, value: value, expires: expires})
+	for c.entries.Len() > c.size {
+		c.remove(c.entries.Back())
+	}
+	return nil
+}
+
+func (c *MemoryCache) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := c.index[key]; ok {
+			c.remove(el)
+		}
+		delete(c.counters, key)
+	}
+	return nil
+}
+
+func (c *MemoryCache) Incr(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters[key]++
+	return c.counters[key], nil
+}
+
+// Purge drops every entry, keeping the counters.
+func (c *MemoryCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries.Init()
+	clear(c.index)
+}
+
+// callers hold mu
+func (c *MemoryCache) remove(el *list.Element) {
+	c.entries.Remove(el)
+	delete(c.index, el.Value.(*memoryEntry).key)
+}
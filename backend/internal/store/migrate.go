@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+
+	"api/migrations"
+
+	"github.com/pressly/goose/v3"
+)
+
+// Migrate runs a goose command (up, up-by-one, down, redo or status) against the embedded
+// migrations, logging each migration it touches.
+func Migrate(ctx context.Context, db *sql.DB, command string) error {
+	return migrate(ctx, goose.DialectPostgres, migrations.FS, db, command)
+}
+
+// MigrateSQLite runs a goose command, as Migrate does, against the schema of a database opened
+// with OpenSQLite.
+func MigrateSQLite(ctx context.Context, db *sql.DB, command string) error {
+	return migrate(ctx, goose.DialectSQLite3, migrations.SQLiteFS, db, command)
+}
+
+func migrate(ctx context.Context, dialect goose.Dialect, fsys fs.FS, db *sql.DB, command string) error {
+	p, err := goose.NewProvider(dialect, db, fsys)
+	if err != nil {
+		return err
+	}
+
+	var results []*goose.MigrationResult
+	switch command {
+	case "up":
+		results, err = p.Up(ctx)
+	case "up-by-one":
+		var res *goose.MigrationResult
+		if res, err = p.UpByOne(ctx); res != nil {
+			results = append(results, res)
+		}
+	case "down":
+		var res *goose.MigrationResult
+		if res, err = p.Down(ctx); res != nil {
+			results = append(results, res)
+		}
+	case "redo":
+		var down, up *goose.MigrationResult
+		if down, err = p.Down(ctx); err == nil {
+			results = append(results, down)
+			if up, err = p.UpByOne(ctx); up != nil {
+				results = append(results, up)
+			}
+		}
+	case "status":
+		statuses, err := p.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			slog.Info("migration", "version", st.Source.Version, "file", st.Source.Path, "state", string(st.State), "applied_at", st.AppliedAt)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migration command %q", command)
+	}
+
+	for _, res := range results {
+		slog.Info("migration applied", "version", res.Source.Version, "file", res.Source.Path, "direction", res.Direction, "duration", res.Duration.String())
+	}
+	return err
+}
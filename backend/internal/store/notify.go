@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"api/internal/tenant"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// UserChangesChannel is the channel the users triggers announce every change to a user on.
+const UserChangesChannel = "user_changes"
+
+// a user_changes notification's payload
+type userChange struct {
+	TenantID int `json:"tenant_id"`
+	ID       int `json:"id"`
+}
+
+// UserChangeListener keeps a CachedUserRepository kept in process from serving users another
+// replica changed: it listens on UserChangesChannel and evicts each user announced there, with
+// every cached list. changes made while it is disconnected go unannounced, so each time it starts
+// listening it drops the whole cache.
+type UserChangeListener struct {
+	pool  *pgxpool.Pool
+	users *CachedUserRepository
+}
+
+// NewUserChangeListener listens on a connection of pool for changes to evict from users; Run starts it.
+func NewUserChangeListener(pool *pgxpool.Pool, users *CachedUserRepository) *UserChangeListener {
+	return &UserChangeListener{pool: pool, users: users}
+}
+
+// Run listens until ctx is done, reconnecting with backoff whenever the connection is lost.
+func (l *UserChangeListener) Run(ctx context.Context) {
+	wait := firstPingBackoff
+	for {
+		err := l.listen(ctx, func() { wait = firstPingBackoff })
+		if ctx.Err() != nil {
+			return
+		}
+		slog.WarnContext(ctx, "lost user change notifications, reconnecting", "err", err, "retry_in", wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, maxPingBackoff)
+	}
+}
+
+// listen on a connection of its own until it fails, calling listening once LISTEN took effect
+func (l *UserChangeListener) listen(ctx context.Context, listening func()) error {
+	pooled, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// the connection is closed rather than put back, where it would go on listening
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+UserChangesChannel); err != nil {
+		return err
+	}
+	l.users.invalidateAll(ctx)
+	listening()
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		l.handle(ctx, n.Payload)
+	}
+}
+
+// evict the user a notification names
+func (l *UserChangeListener) handle(ctx context.Context, payload string) {
+	var c userChange
+	if err := json.Unmarshal([]byte(payload), &c); err != nil {
+		slog.WarnContext(ctx, "malformed user change notification", "err", err, "payload", payload)
+		return
+	}
+	l.users.invalidate(tenant.WithID(ctx, c.TenantID), c.ID)
+}
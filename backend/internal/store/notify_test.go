@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"api/internal/models"
+	"api/internal/tenant"
+)
+
+// a cache in front of users, and a listener evicting from it; writes made on users directly stand
+// in for those of another replica, which this one's cache doesn't see
+func newCachedUsers(t *testing.T) (*MemoryUserRepository, *CachedUserRepository, *UserChangeListener) {
+	t.Helper()
+	users := NewMemoryUserRepository()
+	cached := NewCachedUserRepository(users, NewMemoryCache(100), time.Hour)
+	return users, cached, NewUserChangeListener(nil, cached)
+}
+
+func TestUserChangeNotificationEvicts(t *testing.T) {
+	ctx := tenant.WithID(context.Background(), 2)
+	users, cached, listener := newCachedUsers(t)
+	u, err := cached.Create(ctx, models.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cached.Get(ctx, u.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cached.List(ctx, models.UserFilter{}, models.UserSort{}, 10, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := users.Update(ctx, u.Id, models.User{Name: "Ada King", Email: u.Email}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cached.Get(ctx, u.Id, false); got.Name != "Ada Lovelace" {
+		t.Fatalf("before the notification Get = %q, want the cached %q", got.Name, "Ada Lovelace")
+	}
+
+	// a notification for the same id in another tenant evicts nothing
+	listener.handle(context.Background(), `{"tenant_id": 1, "id": 1}`)
+	if got, _ := cached.Get(ctx, u.Id, false); got.Name != "Ada Lovelace" {
+		t.Fatalf("after another tenant's notification Get = %q, want the cached %q", got.Name, "Ada Lovelace")
+	}
+	listener.handle(context.Background(), `not json`)
+
+	listener.handle(context.Background(), `{"tenant_id": 2, "id": 1}`)
+	if got, _ := cached.Get(ctx, u.Id, false); got.Name != "Ada King" {
+		t.Errorf("after the notification Get = %q, want %q", got.Name, "Ada King")
+	}
+	list, _, err := cached.List(ctx, models.UserFilter{}, models.UserSort{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "Ada King" {
+		t.Errorf("after the notification List = %+v, want Ada King alone", list)
+	}
+}
+
+func TestUserChangeListenerReconnectPurges(t *testing.T) {
+	ctx := context.Background()
+	users, cached, _ := newCachedUsers(t)
+	u, err := cached.Create(ctx, models.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cached.Get(ctx, u.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Update(ctx, u.Id, models.User{Name: "Ada King", Email: u.Email}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// what listen does once it is listening again, having missed whatever changed meanwhile
+	cached.invalidateAll(ctx)
+	if got, _ := cached.Get(ctx, u.Id, false); got.Name != "Ada King" {
+		t.Errorf("after reconnecting Get = %q, want %q", got.Name, "Ada King")
+	}
+}
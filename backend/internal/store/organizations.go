@@ -0,0 +1,270 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"api/internal/models"
+	"api/internal/pii"
+	"api/internal/tenant"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Organizations keeps the organizations of the tenant on the context and who belongs to them.
+// organizations and memberships of users elsewhere are reported as not found
+type Organizations interface {
+	// create an organization with ownerID as its first owner
+	Create(ctx context.Context, name string, ownerID int) (models.Organization, error)
+	// the organizations userID belongs to, by name, with their role in each
+	ForUser(ctx context.Context, userID int) ([]models.Organization, error)
+	// ErrOrgNotFound if there is no such organization
+	Get(ctx context.Context, id int) (models.Organization, error)
+	Rename(ctx context.Context, id int, name string) (models.Organization, error)
+	// remove an organization along with its memberships
+	Delete(ctx context.Context, id int) error
+	// one page of an organization's live members, in the order they joined, along with their total number
+	Members(ctx context.Context, orgID, limit, offset int) ([]models.Member, int, error)
+	// userID's role in the organization, or "" when they aren't a member
+	Role(ctx context.Context, orgID, userID int) (string, error)
+	// ErrUserNotFound if there is no such live user, ErrAlreadyMember if they are a member already
+	AddMember(ctx context.Context, orgID, userID int, role string) (models.Member, error)
+	// ErrMemberNotFound if the user isn't a member, ErrLastOwner if it would leave no owner
+	SetMemberRole(ctx context.Context, orgID, userID int, role string) (models.Member, error)
+	// ErrMemberNotFound if the user isn't a member, ErrLastOwner if it would leave no owner
+	RemoveMember(ctx context.Context, orgID, userID int) error
+	// store an invitation under the hash of its token, replacing any still pending to the same address
+	Invite(ctx context.Context, inv models.Invitation, tokenHash []byte) (models.Invitation, error)
+	// the pending invitation with the token, ErrInvalidInvitation otherwise
+	Invitation(ctx context.Context, tokenHash []byte) (models.Invitation, error)
+	// use up the invitation, making userID a member with its role unless they are one already, and
+	// return the organization with their role in it; ErrInvalidInvitation if it isn't pending
+	AcceptInvitation(ctx context.Context, tokenHash []byte, userID int) (models.Organization, error)
+	// ErrInvalidInvitation if it isn't pending
+	DeclineInvitation(ctx context.Context, tokenHash []byte) error
+}
+
+// PostgresOrganizations keeps organizations in the organizations table and their members in memberships.
+type PostgresOrganizations struct {
+	db  *sql.DB
+	pii *pii.Keyring
+}
+
+var _ Organizations = (*PostgresOrganizations)(nil)
+
+// NewPostgresOrganizations decrypts members' emails with keys, the users' own, which is nil when
+// they are stored in plain text.
+func NewPostgresOrganizations(db *sql.DB, keys *pii.Keyring) *PostgresOrganizations {
+	return &PostgresOrganizations{db: db, pii: keys}
+}
+
+const orgColumns = "id, name, created_at, updated_at"
+
+func scanOrg(row scanner, extra ...interface{}) (models.Organization, error) {
+	var o models.Organization
+	err := row.Scan(append([]interface{}{&o.Id, &o.Name, &o.CreatedAt, &o.UpdatedAt}, extra...)...)
+	if err == sql.ErrNoRows {
+		err = ErrOrgNotFound
+	}
+	return o, err
+}
+
+func (s *PostgresOrganizations) scanMember(row scanner) (models.Member, error) {
+	var m models.Member
+	if err := row.Scan(&m.UserId, &m.Name, &m.Email, &m.Role, &m.JoinedAt); err != nil {
+		return m, err
+	}
+	var err error
+	m.Email, err = s.pii.Decrypt(m.Email)
+	return m, err
+}
+
+func (s *PostgresOrganizations) Create(ctx context.Context, name string, ownerID int) (models.Organization, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Organization{}, err
+	}
+	defer tx.Rollback()
+
+	o, err := scanOrg(tx.QueryRowContext(ctx, "INSERT INTO organizations (tenant_id, name) VALUES ($1, $2) RETURNING "+orgColumns, tenant.ID(ctx), name))
+	if err != nil {
+		return models.Organization{}, err
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO memberships (org_id, user_id, role)
+		SELECT $1, id, $3 FROM users WHERE id = $2 AND tenant_id = $4 AND deleted_at IS NULL`, o.Id, ownerID, models.OrgRoleOwner, tenant.ID(ctx))
+	if err != nil {
+		return models.Organization{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return models.Organization{}, err
+	} else if n == 0 {
+		return models.Organization{}, ErrUserNotFound
+	}
+	o.Role = models.OrgRoleOwner
+	return o, tx.Commit()
+}
+
+func (s *PostgresOrganizations) ForUser(ctx context.Context, userID int) ([]models.Organization, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT o.id, o.name, o.created_at, o.updated_at, m.role
+		FROM organizations o JOIN memberships m ON m.org_id = o.id
+		WHERE m.user_id = $1 AND o.tenant_id = $2 ORDER BY o.name, o.id`, userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	orgs := []models.Organization{}
+	for rows.Next() {
+		var role string
+		o, err := scanOrg(rows, &role)
+		if err != nil {
+			return nil, err
+		}
+		o.Role = role
+		orgs = append(orgs, o)
+	}
+	return orgs, rows.Err()
+}
+
+func (s *PostgresOrganizations) Get(ctx context.Context, id int) (models.Organization, error) {
+	return scanOrg(s.db.QueryRowContext(ctx, "SELECT "+orgColumns+" FROM organizations WHERE id = $1 AND tenant_id = $2", id, tenant.ID(ctx)))
+}
+
+func (s *PostgresOrganizations) Rename(ctx context.Context, id int, name string) (models.Organization, error) {
+	return scanOrg(s.db.QueryRowContext(ctx, "UPDATE organizations SET name = $1, updated_at = now() WHERE id = $2 AND tenant_id = $3 RETURNING "+orgColumns, name, id, tenant.ID(ctx)))
+}
+
+func (s *PostgresOrganizations) Delete(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM organizations WHERE id = $1 AND tenant_id = $2", id, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrOrgNotFound
+	}
+	return nil
+}
+
+// members of deleted users stay, to come back with them on a restore, but aren't shown
+func (s *PostgresOrganizations) Members(ctx context.Context, orgID, limit, offset int) ([]models.Member, int, error) {
+	const from = ` FROM memberships m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1 AND u.tenant_id = $2 AND u.deleted_at IS NULL`
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*)"+from, orgID, tenant.ID(ctx)).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT m.user_id, u.name, u.email, m.role, m.created_at"+from+
+		" ORDER BY m.created_at, m.user_id LIMIT $3 OFFSET $4", orgID, tenant.ID(ctx), limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	members := []models.Member{}
+	for rows.Next() {
+		m, err := s.scanMember(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		members = append(members, m)
+	}
+	return members, total, rows.Err()
+}
+
+func (s *PostgresOrganizations) Role(ctx context.Context, orgID, userID int) (string, error) {
+	var role string
+	err := s.db.QueryRowContext(ctx, `SELECT m.role FROM memberships m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1 AND m.user_id = $2 AND u.tenant_id = $3 AND u.deleted_at IS NULL`, orgID, userID, tenant.ID(ctx)).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+func (s *PostgresOrganizations) AddMember(ctx context.Context, orgID, userID int, role string) (models.Member, error) {
+	m, err := s.scanMember(s.db.QueryRowContext(ctx, `WITH added AS (
+			INSERT INTO memberships (org_id, user_id, role)
+			SELECT o.id, u.id, $3 FROM organizations o, users u
+			WHERE o.id = $1 AND o.tenant_id = $4 AND u.id = $2 AND u.tenant_id = $4 AND u.deleted_at IS NULL
+			RETURNING user_id, role, created_at)
+		SELECT added.user_id, u.name, u.email, added.role, added.created_at FROM added JOIN users u ON u.id = added.user_id`,
+		orgID, userID, role, tenant.ID(ctx)))
+	var pgErr *pgconn.PgError
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	} else if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		err = ErrAlreadyMember
+	}
+	return m, err
+}
+
+// lock the organization's owners, so two concurrent changes can't both see another owner left,
+// and report whether userID is the only one
+func lastOwner(ctx context.Context, tx *sql.Tx, orgID, userID int) (bool, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT user_id FROM memberships WHERE org_id = $1 AND role = $2 FOR UPDATE", orgID, models.OrgRoleOwner)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	var owners []int
+	for rows.Next() {
+		var owner int
+		if err := rows.Scan(&owner); err != nil {
+			return false, err
+		}
+		owners = append(owners, owner)
+	}
+	return len(owners) == 1 && owners[0] == userID, rows.Err()
+}
+
+func (s *PostgresOrganizations) SetMemberRole(ctx context.Context, orgID, userID int, role string) (models.Member, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Member{}, err
+	}
+	defer tx.Rollback()
+
+	if last, err := lastOwner(ctx, tx, orgID, userID); err != nil {
+		return models.Member{}, err
+	} else if last && role != models.OrgRoleOwner {
+		return models.Member{}, ErrLastOwner
+	}
+	m, err := s.scanMember(tx.QueryRowContext(ctx, `WITH changed AS (
+			UPDATE memberships SET role = $3 WHERE org_id = $1 AND user_id = $2
+				AND org_id IN (SELECT id FROM organizations WHERE tenant_id = $4)
+			RETURNING user_id, role, created_at)
+		SELECT changed.user_id, u.name, u.email, changed.role, changed.created_at FROM changed JOIN users u ON u.id = changed.user_id`,
+		orgID, userID, role, tenant.ID(ctx)))
+	if err == sql.ErrNoRows {
+		return models.Member{}, ErrMemberNotFound
+	} else if err != nil {
+		return models.Member{}, err
+	}
+	return m, tx.Commit()
+}
+
+func (s *PostgresOrganizations) RemoveMember(ctx context.Context, orgID, userID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if last, err := lastOwner(ctx, tx, orgID, userID); err != nil {
+		return err
+	} else if last {
+		return ErrLastOwner
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM memberships WHERE org_id = $1 AND user_id = $2
+		AND org_id IN (SELECT id FROM organizations WHERE tenant_id = $3)`, orgID, userID, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrMemberNotFound
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"api/internal/models"
+	"api/internal/pii"
+)
+
+// Outbox holds the events written alongside each change, until the relay has published them.
+type Outbox interface {
+	// lock up to limit unpublished events, oldest first, and hand them to publish one at a time,
+	// stopping at its first error. the events published before it are marked so; returns their number.
+	// events locked by another relay are skipped, so relays can run side by side
+	Relay(ctx context.Context, limit int, publish func(models.OutboxEvent) error) (int, error)
+	// delete events published before the given time
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// PostgresOutbox is an Outbox backed by the outbox table, which the users triggers write to.
+type PostgresOutbox struct {
+	db  *sql.DB
+	pii *pii.Keyring
+}
+
+var _ Outbox = (*PostgresOutbox)(nil)
+
+// NewPostgresOutbox decrypts the payloads the triggers copied from encrypted users columns with
+// keys, which is nil when they are stored in plain text.
+func NewPostgresOutbox(db *sql.DB, keys *pii.Keyring) *PostgresOutbox {
+	return &PostgresOutbox{db: db, pii: keys}
+}
+
+func (o *PostgresOutbox) Relay(ctx context.Context, limit int, publish func(models.OutboxEvent) error) (int, error) {
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, event, entity_type, entity_id, tenant_id, payload, created_at FROM outbox
+		WHERE published_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`, limit)
+	if err != nil {
+		return 0, err
+	}
+	var events []models.OutboxEvent
+	for rows.Next() {
+		var (
+			e       models.OutboxEvent
+			payload []byte
+		)
+		if err := rows.Scan(&e.Id, &e.Event, &e.EntityType, &e.EntityId, &e.TenantId, &payload, &e.CreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if e.Payload, err = o.pii.OpenJSON(payload, piiMembers...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var published []int64
+	var publishErr error
+	for _, e := range events {
+		if publishErr = publish(e); publishErr != nil {
+			break
+		}
+		published = append(published, e.Id)
+	}
+	if len(published) > 0 {
+		if _, err := tx.ExecContext(ctx, "UPDATE outbox SET published_at = now() WHERE id = ANY ($1)", published); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(published), publishErr
+}
+
+func (o *PostgresOutbox) Prune(ctx context.Context, before time.Time) error {
+	_, err := o.db.ExecContext(ctx, "DELETE FROM outbox WHERE published_at < $1", before)
+	return err
+}
@@ -0,0 +1,291 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"api/internal/pii"
+)
+
+// rows re-encrypted per transaction, so writes to the users in a batch wait only briefly
+const reencryptBatch = 500
+
+// ReencryptPII moves the emails and phone numbers of every tenant's users to the current key of
+// keys, encrypting those still in plain text, along with the copies in revisions, the audit log and
+// the outbox and the addresses users are changing theirs to. keys must still hold every key in use; once it returns, keys other than the current
+// one can be dropped. users aren't changed as far as clients can tell: neither versions nor
+// revisions nor events are written.
+func ReencryptPII(ctx context.Context, db *sql.DB, keys *pii.Keyring) error {
+	users, err := reencryptUsers(ctx, db, keys)
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "re-encrypted users", "rows", users)
+	pending, err := reencryptPendingEmails(ctx, db, keys)
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "re-encrypted email_changes", "rows", pending)
+	for _, t := range []struct {
+		table   string
+		columns []string
+	}{
+		{"audit_log", []string{"before", "after"}},
+		{"outbox", []string{"payload"}},
+	} {
+		n, err := reencryptJSONRows(ctx, db, keys, t.table, t.columns)
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "re-encrypted "+t.table, "rows", n)
+	}
+	return nil
+}
+
+// re-encrypt users and their revisions in batches by id, returning how many users changed
+func reencryptUsers(ctx context.Context, db *sql.DB, keys *pii.Keyring) (int, error) {
+	changed := 0
+	for after := 0; ; {
+		n, last, err := reencryptUserBatch(ctx, db, keys, after)
+		if err != nil {
+			return changed, err
+		}
+		if last == 0 {
+			return changed, nil
+		}
+		changed += n
+		after = last
+	}
+}
+
+// returns the number of users changed and the last id seen, 0 once there are none left
+func reencryptUserBatch(ctx context.Context, db *sql.DB, keys *pii.Keyring, after int) (int, int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	// the users triggers skip updates made while this is set, see migration 00039
+	if _, err := tx.ExecContext(ctx, "SET LOCAL app.reencrypting_pii = 'on'"); err != nil {
+		return 0, 0, err
+	}
+
+	type row struct {
+		id    int
+		email string
+		phone sql.NullString
+		index []byte
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT id, email, phone, email_index FROM users WHERE id > $1 ORDER BY id LIMIT $2 FOR UPDATE", after, reencryptBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.email, &r.phone, &r.index); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(batch) == 0 {
+		return 0, 0, nil
+	}
+
+	changed := 0
+	ids := make([]int64, len(batch))
+	for i, r := range batch {
+		ids[i] = int64(r.id)
+		plain, err := keys.Decrypt(r.email)
+		if err != nil {
+			return 0, 0, err
+		}
+		email, index := keys.Encrypt(plain), keys.Index(plain)
+		phone := r.phone
+		if phone.Valid {
+			if phone.String, err = keys.Reencrypt(phone.String); err != nil {
+				return 0, 0, err
+			}
+		}
+		if email == r.email && phone == r.phone && bytes.Equal(index, r.index) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET email = $1, phone = $2, email_index = $3 WHERE id = $4", email, phone, index, r.id); err != nil {
+			return 0, 0, takenError(err)
+		}
+		changed++
+	}
+
+	revisions, err := tx.QueryContext(ctx, "SELECT user_id, rev, snapshot FROM user_revisions WHERE user_id = ANY($1) FOR UPDATE", ids)
+	if err != nil {
+		return 0, 0, err
+	}
+	type revision struct {
+		userID, rev int
+		snapshot    []byte
+	}
+	var resealed []revision
+	for revisions.Next() {
+		var r revision
+		if err := revisions.Scan(&r.userID, &r.rev, &r.snapshot); err != nil {
+			revisions.Close()
+			return 0, 0, err
+		}
+		snapshot, err := reseal(keys, r.snapshot)
+		if err != nil {
+			revisions.Close()
+			return 0, 0, err
+		}
+		if !bytes.Equal(snapshot, r.snapshot) {
+			r.snapshot = snapshot
+			resealed = append(resealed, r)
+		}
+	}
+	revisions.Close()
+	if err := revisions.Err(); err != nil {
+		return 0, 0, err
+	}
+	for _, r := range resealed {
+		if _, err := tx.ExecContext(ctx, "UPDATE user_revisions SET snapshot = $1 WHERE user_id = $2 AND rev = $3", r.snapshot, r.userID, r.rev); err != nil {
+			return 0, 0, err
+		}
+	}
+	return changed, batch[len(batch)-1].id, tx.Commit()
+}
+
+// re-encrypt the addresses awaiting confirmation in one go: there are only ever a few
+func reencryptPendingEmails(ctx context.Context, db *sql.DB, keys *pii.Keyring) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, "SELECT user_id, pending_email FROM email_changes FOR UPDATE")
+	if err != nil {
+		return 0, err
+	}
+	resealed := map[int]string{}
+	for rows.Next() {
+		var id int
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		sealed, err := keys.Reencrypt(email)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if sealed != email {
+			resealed[id] = sealed
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for id, email := range resealed {
+		if _, err := tx.ExecContext(ctx, "UPDATE email_changes SET pending_email = $1 WHERE user_id = $2", email, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(resealed), tx.Commit()
+}
+
+// a JSON snapshot with its encrypted members moved to the current key
+func reseal(keys *pii.Keyring, b []byte) ([]byte, error) {
+	opened, err := keys.OpenJSON(b, piiMembers...)
+	if err != nil {
+		return nil, err
+	}
+	return keys.SealJSON(opened, piiMembers...)
+}
+
+// re-encrypt the JSON columns of every row of a table with a BIGSERIAL id, returning how many rows
+// changed. table and columns are fixed names, never input
+func reencryptJSONRows(ctx context.Context, db *sql.DB, keys *pii.Keyring, table string, columns []string) (int, error) {
+	changed := 0
+	for after := int64(0); ; {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return changed, err
+		}
+		n, last, err := reencryptJSONBatch(ctx, tx, keys, table, columns, after)
+		if err == nil {
+			err = tx.Commit()
+		}
+		tx.Rollback()
+		if err != nil {
+			return changed, err
+		}
+		if last == 0 {
+			return changed, nil
+		}
+		changed += n
+		after = last
+	}
+}
+
+func reencryptJSONBatch(ctx context.Context, tx *sql.Tx, keys *pii.Keyring, table string, columns []string, after int64) (int, int64, error) {
+	list := ""
+	for _, c := range columns {
+		list += ", " + c
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT id"+list+" FROM "+table+" WHERE id > $1 ORDER BY id LIMIT $2 FOR UPDATE", after, reencryptBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+	type row struct {
+		id     int64
+		values [][]byte
+	}
+	var batch []row
+	for rows.Next() {
+		r := row{values: make([][]byte, len(columns))}
+		dest := []interface{}{&r.id}
+		for i := range r.values {
+			dest = append(dest, &r.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(batch) == 0 {
+		return 0, 0, nil
+	}
+
+	changed := 0
+	for _, r := range batch {
+		for i, c := range columns {
+			if r.values[i] == nil {
+				continue
+			}
+			sealed, err := reseal(keys, r.values[i])
+			if err != nil {
+				return 0, 0, err
+			}
+			if bytes.Equal(sealed, r.values[i]) {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET "+c+" = $1 WHERE id = $2", sealed, r.id); err != nil {
+				return 0, 0, err
+			}
+			changed++
+		}
+	}
+	return changed, batch[len(batch)-1].id, nil
+}
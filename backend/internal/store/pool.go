@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/prometheus/client_golang/prometheus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+)
+
+// PoolOptions sizes the pool of connections Open keeps to Postgres.
+type PoolOptions struct {
+	MaxConns        int           // 0 for the pgx default, the larger of 4 and the number of CPUs
+	MinIdleConns    int           // idle connections kept open for the next queries
+	MaxConnLifetime time.Duration // 0 keeps connections forever
+	MaxConnIdleTime time.Duration // 0 keeps idle connections forever
+	Breaker         *Breaker      // fails queries fast while the database keeps failing; nil to always try
+}
+
+// Open connects to Postgres through a pgx pool, returning a *sql.DB over it, through otelsql so
+// every query becomes a child span of the request that ran it, and the pool itself for its
+// statistics. closing the *sql.DB leaves the pool open; close both.
+func Open(dsn string, opts PoolOptions) (*sql.DB, *pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.MaxConns > 0 {
+		config.MaxConns = int32(opts.MaxConns)
+	}
+	config.MinIdleConns = int32(opts.MinIdleConns)
+	config.MaxConnLifetime = opts.MaxConnLifetime
+	// pgx closes connections idle for longer than MaxConnIdleTime, all of them when it is 0
+	config.MaxConnIdleTime = opts.MaxConnIdleTime
+	if config.MaxConnIdleTime == 0 {
+		config.MaxConnIdleTime = math.MaxInt64
+	}
+	if opts.Breaker != nil {
+		config.ConnConfig.Tracer = breakerTracer{breaker: opts.Breaker}
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, nil, err
+	}
+	connector := stdlib.GetPoolConnector(pool)
+	if opts.Breaker != nil {
+		// every query connects, there being no idle connections in database/sql
+		connector = breakerConnector{Connector: connector, breaker: opts.Breaker}
+	}
+	db := otelsql.OpenDB(connector,
+		otelsql.WithAttributes(semconv.DBSystemNamePostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
+	// the pool keeps the idle connections; ones idling in database/sql would be counted as acquired
+	db.SetMaxIdleConns(0)
+	return db, pool, nil
+}
+
+// COPY rows into table on conn, within any transaction open on it. conn must come from a *sql.DB
+// made by Open
+func copyFrom(ctx context.Context, conn *sql.Conn, table string, columns []string, rows [][]interface{}) error {
+	return conn.Raw(func(dc interface{}) error {
+		// otelsql wraps the pgx connection
+		if wrapped, ok := dc.(interface{ Raw() driver.Conn }); ok {
+			dc = wrapped.Raw()
+		}
+		c, ok := dc.(*stdlib.Conn)
+		if !ok {
+			return errors.New("store: COPY needs a pgx connection")
+		}
+		_, err := c.Conn().CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromRows(rows))
+		return err
+	})
+}
+
+// PoolCollector exports the statistics of a pool made by Open to Prometheus.
+func PoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	return poolCollector{pool: pool}
+}
+
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+var (
+	poolMaxConns = prometheus.NewDesc("db_pool_max_conns", "Maximum connections the pool opens.", nil, nil)
+	poolConns    = prometheus.NewDesc("db_pool_conns", "Open connections, by state (acquired, idle or constructing).",
+		[]string{"state"}, nil)
+	poolAcquires = prometheus.NewDesc("db_pool_acquires_total", "Connections acquired from the pool.", nil, nil)
+	poolWaits    = prometheus.NewDesc("db_pool_empty_acquires_total",
+		"Acquires that waited for a connection because none was idle.", nil, nil)
+	poolWaitTime = prometheus.NewDesc("db_pool_empty_acquire_wait_seconds_total",
+		"Time spent waiting by acquires that found no idle connection.", nil, nil)
+	poolCanceled = prometheus.NewDesc("db_pool_canceled_acquires_total",
+		"Acquires canceled before a connection was available.", nil, nil)
+	poolClosed = prometheus.NewDesc("db_pool_closed_conns_total",
+		"Connections the pool closed, by reason (lifetime or idle).", []string{"reason"}, nil)
+)
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{poolMaxConns, poolConns, poolAcquires, poolWaits, poolWaitTime, poolCanceled, poolClosed} {
+		ch <- d
+	}
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolConns, prometheus.GaugeValue, float64(s.AcquiredConns()), "acquired")
+	ch <- prometheus.MustNewConstMetric(poolConns, prometheus.GaugeValue, float64(s.IdleConns()), "idle")
+	ch <- prometheus.MustNewConstMetric(poolConns, prometheus.GaugeValue, float64(s.ConstructingConns()), "constructing")
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaits, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaitTime, prometheus.CounterValue, s.EmptyAcquireWaitTime().Seconds())
+	ch <- prometheus.MustNewConstMetric(poolCanceled, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolClosed, prometheus.CounterValue, float64(s.MaxLifetimeDestroyCount()), "lifetime")
+	ch <- prometheus.MustNewConstMetric(poolClosed, prometheus.CounterValue, float64(s.MaxIdleDestroyCount()), "idle")
+}
@@ -0,0 +1,1610 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/models"
+	"api/internal/pii"
+	"api/internal/tenant"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// PostgresUserRepository is a UserRepository backed by the users table in Postgres. every query
+// is scoped to the tenant on its context, so users of other tenants are never found, and neither
+// are their sessions, keys or other rows
+type PostgresUserRepository struct {
+	sqlUsers
+	db       *sql.DB
+	replicas *Replicas
+}
+
+var _ UserRepository = (*PostgresUserRepository)(nil)
+
+// NewPostgresUserRepository encrypts emails and phone numbers with keys, or stores them in plain
+// text when keys is nil. Get, List, ListAfter, Search and FuzzySearch read from replicas on
+// contexts from WithReplicaReads; everything else goes to db, the primary.
+func NewPostgresUserRepository(db *sql.DB, replicas *Replicas, keys *pii.Keyring) *PostgresUserRepository {
+	return &PostgresUserRepository{sqlUsers: sqlUsers{dialect: postgresDialect, pii: keys}, db: db, replicas: replicas}
+}
+
+// an empty hash is stored as NULL, leaving the user unable to log in until a password is set
+func nullablePasswordHash(hash string) sql.NullString {
+	return sql.NullString{String: hash, Valid: hash != ""}
+}
+
+// the first user created in a tenant becomes an admin, so a fresh tenant has someone who can assign roles
+const insertUserQuery = `INSERT INTO users (name, email, password_hash, tenant_id, phone, bio, timezone, locale, username, email_index, role)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CASE WHEN EXISTS (SELECT 1 FROM users WHERE tenant_id = $4) THEN 'user' ELSE 'admin' END)
+	RETURNING id, created_at, updated_at, role, version`
+
+// SQL columns for each of models.SortableUserFields, plus the default id
+var sortColumns = map[string]string{
+	"":           "id",
+	"name":       "name",
+	"email":      "email",
+	"created_at": "created_at",
+}
+
+func sortDirection(desc bool) string {
+	if desc {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+func (s *PostgresUserRepository) List(ctx context.Context, f models.UserFilter, sort models.UserSort, limit, offset int) ([]models.User, int, error) {
+	column, ok := sortColumns[sort.Field]
+	if !ok {
+		return nil, 0, fmt.Errorf("cannot sort users by %q", sort.Field)
+	}
+	direction := sortDirection(sort.Desc)
+
+	q := s.filterQuery(ctx, f)
+	countQuery := "SELECT COUNT(*) FROM users" + q.where()
+	countArgs := slices.Clone(q.args)
+
+	// column and direction come from fixed strings above, never from raw input, as do the selected columns
+	columns := selectedColumns(f)
+	query := "SELECT " + strings.Join(columns, ", ") + " FROM users" + q.where() +
+		" ORDER BY " + column + " " + direction + ", id " + direction +
+		" LIMIT " + q.bind(limit) + " OFFSET " + q.bind(offset)
+	var users []models.User
+	var total int
+	err := s.replicas.read(ctx, s.db, func(db *sql.DB) error {
+		if err := db.QueryRowContext(ctx, countQuery, countArgs...).Scan(&total); err != nil {
+			return err
+		}
+		rows, err := db.QueryContext(ctx, query, q.args...)
+		if err != nil {
+			return err
+		}
+		users, err = s.scanUsers(rows, columns)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (s *PostgresUserRepository) ListAfter(ctx context.Context, f models.UserFilter, after *models.UserCursor, desc bool, limit int) ([]models.User, error) {
+	direction := sortDirection(desc)
+	q := s.filterQuery(ctx, f)
+	if after != nil {
+		cmp := ">"
+		if desc {
+			cmp = "<"
+		}
+		q.conds = append(q.conds, "(created_at, id) "+cmp+" ("+q.bind(after.CreatedAt)+", "+q.bind(after.Id)+")")
+	}
+
+	// created_at is read whatever the fields, as the next cursor is made from it
+	columns := selectedColumns(f, "created_at")
+	query := "SELECT " + strings.Join(columns, ", ") + " FROM users" + q.where() +
+		" ORDER BY created_at " + direction + ", id " + direction +
+		" LIMIT " + q.bind(limit)
+	var users []models.User
+	err := s.replicas.read(ctx, s.db, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, q.args...)
+		if err != nil {
+			return err
+		}
+		users, err = s.scanUsers(rows, columns)
+		return err
+	})
+	return users, err
+}
+
+// the start of the oldest transaction in flight. updated_at is the time its transaction started, so
+// no change before this can still commit. sessions of other roles aren't seen, so the API is assumed
+// to be the only one writing users
+const syncHorizonQuery = "SELECT COALESCE(min(xact_start), now()) FROM pg_stat_activity WHERE datname = current_database()"
+
+// read from the primary, as a replica's changes can trail the horizon
+func (s *PostgresUserRepository) Changes(ctx context.Context, after *models.SyncCursor, limit int) (models.UserChanges, error) {
+	var horizon time.Time
+	if err := s.db.QueryRowContext(ctx, syncHorizonQuery).Scan(&horizon); err != nil {
+		return models.UserChanges{}, err
+	}
+
+	q := s.query()
+	q.conds = append(q.conds, "tenant_id = "+q.bind(tenant.ID(ctx)), "updated_at < "+q.bind(horizon))
+	if after == nil {
+		q.conds = append(q.conds, "deleted_at IS NULL")
+	} else {
+		q.conds = append(q.conds, "(updated_at, id) > ("+q.bind(after.ChangedAt)+", "+q.bind(after.Id)+")")
+	}
+	// one more of each than needed, to learn whether more are waiting
+	rows, err := s.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users"+q.where()+" ORDER BY updated_at, id LIMIT "+q.bind(limit+1), q.args...)
+	if err != nil {
+		return models.UserChanges{}, err
+	}
+	users, err := s.scanUsers(rows, userColumnNames)
+	if err != nil {
+		return models.UserChanges{}, err
+	}
+
+	var gone []models.UserTombstone
+	if after != nil {
+		rows, err := s.db.QueryContext(ctx, `SELECT user_id, deleted_at FROM user_tombstones
+			WHERE tenant_id = $1 AND deleted_at < $2 AND (deleted_at, user_id) > ($3, $4)
+			ORDER BY deleted_at, user_id LIMIT $5`, tenant.ID(ctx), horizon, after.ChangedAt, after.Id, limit+1)
+		if err != nil {
+			return models.UserChanges{}, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var t models.UserTombstone
+			if err := rows.Scan(&t.Id, &t.DeletedAt); err != nil {
+				return models.UserChanges{}, err
+			}
+			gone = append(gone, t)
+		}
+		if err := rows.Err(); err != nil {
+			return models.UserChanges{}, err
+		}
+	}
+
+	return mergeChanges(users, gone, after, horizon, limit), nil
+}
+
+// one page of changes from users and tombstones read past after, each in (changed, id) order and
+// one longer than limit when more are waiting, before horizon
+func mergeChanges(users []models.User, gone []models.UserTombstone, after *models.SyncCursor, horizon time.Time, limit int) models.UserChanges {
+	// merge the two in order; a user deleted for good has no row left, so no id is in both
+	c := models.UserChanges{Users: []models.User{}, Deleted: []models.UserTombstone{}}
+	var last models.SyncCursor
+	i, j := 0, 0
+	for n := 0; n < limit && (i < len(users) || j < len(gone)); n++ {
+		if j == len(gone) || i < len(users) && syncBefore(users[i].UpdatedAt, users[i].Id, gone[j].DeletedAt, gone[j].Id) {
+			u := users[i]
+			i++
+			last = models.SyncCursor{ChangedAt: u.UpdatedAt, Id: u.Id}
+			if u.DeletedAt != nil {
+				c.Deleted = append(c.Deleted, models.UserTombstone{Id: u.Id, DeletedAt: *u.DeletedAt})
+			} else {
+				c.Users = append(c.Users, u)
+			}
+		} else {
+			t := gone[j]
+			j++
+			last = models.SyncCursor{ChangedAt: t.DeletedAt, Id: t.Id}
+			c.Deleted = append(c.Deleted, t)
+		}
+	}
+	c.More = i < len(users) || j < len(gone)
+	switch {
+	case c.More:
+		c.Next = last
+	case after != nil && !after.ChangedAt.Before(horizon):
+		c.Next = *after
+	default:
+		// everything before the horizon has been seen
+		c.Next = models.SyncCursor{ChangedAt: horizon}
+	}
+	return c
+}
+
+func syncBefore(t1 time.Time, id1 int, t2 time.Time, id2 int) bool {
+	return t1.Before(t2) || t1.Equal(t2) && id1 < id2
+}
+
+// every count comes from one query, so they agree with each other
+func (s *PostgresUserRepository) Stats(ctx context.Context) (models.UserStats, error) {
+	var st models.UserStats
+	var byRole []byte
+	err := s.db.QueryRowContext(ctx, `SELECT count(*), count(email_verified_at),
+			count(*) FILTER (WHERE created_at > now() - interval '24 hours'),
+			count(*) FILTER (WHERE created_at > now() - interval '7 days'),
+			count(*) FILTER (WHERE created_at > now() - interval '30 days'),
+			(SELECT COALESCE(json_object_agg(name, n), '{}') FROM (
+				SELECT roles.name, count(u.id) AS n FROM roles
+				LEFT JOIN users u ON u.role = roles.name AND u.tenant_id = $1 AND u.deleted_at IS NULL
+				GROUP BY roles.name) AS by_role)
+		FROM users WHERE tenant_id = $1 AND deleted_at IS NULL`, tenant.ID(ctx)).Scan(&st.Total, &st.ByVerification.Verified,
+		&st.Created.Last24h, &st.Created.Last7d, &st.Created.Last30d, &byRole)
+	if err != nil {
+		return models.UserStats{}, err
+	}
+	st.ByVerification.Unverified = st.Total - st.ByVerification.Verified
+	if err := json.Unmarshal(byRole, &st.ByRole); err != nil {
+		return models.UserStats{}, err
+	}
+	return st, nil
+}
+
+func (s *PostgresUserRepository) Signups(ctx context.Context, unit string, from, to time.Time, includeDeleted bool) ([]models.SignupBucket, error) {
+	// buckets are computed on UTC wall-clock time, so a day runs midnight to midnight UTC
+	// whatever the session's time zone
+	rows, err := s.db.QueryContext(ctx, `WITH buckets AS (
+			SELECT generate_series(date_trunc($1, $2::timestamptz AT TIME ZONE 'UTC'),
+				$3::timestamptz AT TIME ZONE 'UTC', ('1 ' || $1)::interval) AS start)
+		SELECT buckets.start AT TIME ZONE 'UTC', count(users.id) FROM buckets
+		LEFT JOIN users ON users.created_at AT TIME ZONE 'UTC' >= buckets.start
+			AND users.created_at AT TIME ZONE 'UTC' < buckets.start + ('1 ' || $1)::interval
+			AND users.tenant_id = $5 AND ($4 OR users.deleted_at IS NULL)
+		GROUP BY buckets.start ORDER BY buckets.start`, unit, from, to, includeDeleted, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	buckets := []models.SignupBucket{}
+	for rows.Next() {
+		var b models.SignupBucket
+		if err := rows.Scan(&b.Start, &b.Count); err != nil {
+			return nil, err
+		}
+		b.Start = b.Start.UTC()
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// streams straight from the DB cursor so large exports never sit in memory
+func (s *PostgresUserRepository) Each(ctx context.Context, f models.UserFilter, fn func(models.User) error) error {
+	q := s.filterQuery(ctx, f)
+	rows, err := s.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users"+q.where()+" ORDER BY id", q.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := s.scanUser(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *PostgresUserRepository) Get(ctx context.Context, id int, includeDeleted bool) (models.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE id = $1 AND tenant_id = $2"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+	var u models.User
+	err := s.replicas.read(ctx, s.db, func(db *sql.DB) error {
+		var err error
+		u, err = s.scanUser(db.QueryRowContext(ctx, query, id, tenant.ID(ctx)))
+		return err
+	})
+	return u, err
+}
+
+func (s *PostgresUserRepository) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	var hash string
+	u, err := s.scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+", COALESCE(password_hash, '') FROM users WHERE (lower(email) = lower($1) OR email_index = $3) AND tenant_id = $2 AND deleted_at IS NULL", email, tenant.ID(ctx), s.emailIndex(email)), &hash)
+	u.PasswordHash = hash
+	return u, err
+}
+
+func (s *PostgresUserRepository) GetByUsername(ctx context.Context, username string) (models.User, error) {
+	return s.scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1 AND tenant_id = $2 AND deleted_at IS NULL", username, tenant.ID(ctx)))
+}
+
+func (s *PostgresUserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE (lower(email) = lower($1) OR email_index = $3) AND tenant_id = $2)", email, tenant.ID(ctx), s.emailIndex(email)).Scan(&exists)
+	return exists, err
+}
+
+func (s *PostgresUserRepository) ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	var indexes [][]byte
+	if s.pii != nil {
+		for _, email := range emails {
+			indexes = append(indexes, s.pii.Index(email))
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT email FROM users WHERE (lower(email) = ANY($1) OR email_index = ANY($3)) AND tenant_id = $2", emails, tenant.ID(ctx), indexes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	existing := map[string]bool{}
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		if email, err = s.pii.Decrypt(email); err != nil {
+			return nil, err
+		}
+		existing[strings.ToLower(email)] = true
+	}
+	return existing, rows.Err()
+}
+
+// report a unique violation on users.email or its lookup hash within the tenant as ErrEmailTaken, and on
+// users.username as ErrUsernameTaken
+func takenError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "users_email_key", "users_email_index_key":
+			return ErrEmailTaken
+		case "users_username_key":
+			return ErrUsernameTaken
+		}
+	}
+	return err
+}
+
+func (s *PostgresUserRepository) Create(ctx context.Context, u models.User) (models.User, error) {
+	derive := u.Username == ""
+	for attempt := 1; ; attempt++ {
+		if derive {
+			var err error
+			if u.Username, err = freeUsername(ctx, s.dialect, s.db, u.Name); err != nil {
+				return models.User{}, err
+			}
+		}
+		email, phone := s.encrypted(u)
+		err := s.db.QueryRowContext(ctx, insertUserQuery, u.Name, email, nullablePasswordHash(u.PasswordHash), tenant.ID(ctx), phone, u.Bio, u.Timezone, u.Locale, u.Username, s.emailIndex(u.Email)).Scan(&u.Id, &u.CreatedAt, &u.UpdatedAt, &u.Role, &u.Version)
+		// a concurrent insert took the derived username between choosing it and inserting
+		if err = takenError(err); err == ErrUsernameTaken && derive && attempt < usernameAttempts {
+			continue
+		}
+		return u, err
+	}
+}
+
+// insertUserQuery, updating the user already holding the email instead. $12 keeps their username.
+// the conflict is on the column emails are unique by, which is email_index with encryption on
+const upsertUserQuery = `INSERT INTO users (name, email, password_hash, tenant_id, phone, bio, timezone, locale, username, email_index, role)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CASE WHEN EXISTS (SELECT 1 FROM users WHERE tenant_id = $4) THEN 'user' ELSE 'admin' END)
+	ON CONFLICT %s DO UPDATE SET name = EXCLUDED.name, email = EXCLUDED.email, phone = EXCLUDED.phone, bio = EXCLUDED.bio,
+	timezone = EXCLUDED.timezone, locale = EXCLUDED.locale, username = CASE WHEN $12 THEN users.username ELSE EXCLUDED.username END
+	WHERE users.deleted_at IS NULL
+	RETURNING ` + userColumns + `, xmax = 0`
+
+func (s *PostgresUserRepository) Upsert(ctx context.Context, u models.User) (models.User, bool, error) {
+	conflict := "(tenant_id, lower(email))"
+	if s.emailIndex(u.Email).Valid {
+		conflict = "(tenant_id, email_index)"
+	}
+	q := fmt.Sprintf(upsertUserQuery, conflict)
+	derive := u.Username == ""
+	for attempt := 1; ; attempt++ {
+		username := u.Username
+		if derive {
+			var err error
+			if username, err = freeUsername(ctx, s.dialect, s.db, u.Name); err != nil {
+				return models.User{}, false, err
+			}
+		}
+		email, phone := s.encrypted(u)
+		var created bool
+		upserted, err := s.scanUser(s.db.QueryRowContext(ctx, q, u.Name, email, nullablePasswordHash(u.PasswordHash), tenant.ID(ctx), phone, u.Bio, u.Timezone, u.Locale, username, s.emailIndex(u.Email), derive), &created)
+		if err == ErrUserNotFound {
+			// the update skipped a soft-deleted holder of the email
+			return models.User{}, false, ErrEmailTaken
+		}
+		if err = takenError(err); err == ErrUsernameTaken && derive && attempt < usernameAttempts {
+			continue
+		}
+		return upserted, created, err
+	}
+}
+
+// CreateMany copies its users into users_import with COPY and inserts them from there in a single
+// statement, in order, so large imports cost a few round trips rather than several per user. the
+// first of them is the tenant's admin when the tenant has no users yet
+const insertImportedUsersQuery = `INSERT INTO users (name, email, password_hash, tenant_id, phone, bio, timezone, locale, username, email_index, role, created_at, updated_at)
+	SELECT name, email, password_hash, $1, phone, bio, timezone, locale, username, email_index,
+		CASE WHEN ord = 0 AND NOT EXISTS (SELECT 1 FROM users WHERE tenant_id = $1) THEN 'admin' ELSE 'user' END,
+		COALESCE(created_at, now()), COALESCE(created_at, now())
+	FROM users_import ORDER BY ord
+	RETURNING id, email, created_at, updated_at, role, version`
+
+var importedUserColumns = []string{"ord", "name", "email", "password_hash", "phone", "bio", "timezone", "locale", "username", "email_index", "created_at"}
+
+func (s *PostgresUserRepository) CreateMany(ctx context.Context, users []models.User) ([]models.User, error) {
+	if len(users) == 0 {
+		return []models.User{}, nil
+	}
+	// COPY needs the pgx connection the transaction runs on
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// usernames are derived all at once, steering clear of those given in the batch
+	var derive []int
+	var names, given []string
+	for i, u := range users {
+		if u.Username == "" {
+			derive = append(derive, i)
+			names = append(names, u.Name)
+		} else {
+			given = append(given, u.Username)
+		}
+	}
+	created := slices.Clone(users)
+	if len(derive) > 0 {
+		usernames, err := freeUsernames(ctx, tx, names, given)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range derive {
+			created[i].Username = usernames[j]
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `CREATE TEMPORARY TABLE users_import (ord INTEGER, name TEXT, email TEXT, password_hash TEXT,
+		phone TEXT, bio TEXT, timezone TEXT, locale TEXT, username TEXT, email_index BYTEA, created_at TIMESTAMPTZ) ON COMMIT DROP`); err != nil {
+		return nil, err
+	}
+	byEmail := make(map[string]int, len(created)) // stored email -> index in users
+	imported := make([][]interface{}, len(created))
+	for i, u := range created {
+		email, phone := s.encrypted(u)
+		byEmail[email] = i
+		// a CreatedAt backdates the user, as seeded demo data is
+		var createdAt *time.Time
+		if !u.CreatedAt.IsZero() {
+			createdAt = &u.CreatedAt
+		}
+		imported[i] = []interface{}{i, u.Name, email, nullablePasswordHash(u.PasswordHash), phone, u.Bio, u.Timezone, u.Locale, u.Username, s.emailIndex(u.Email), createdAt}
+	}
+	if err := copyFrom(ctx, conn, "users_import", importedUserColumns, imported); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, insertImportedUsersQuery, tenant.ID(ctx))
+	if err != nil {
+		return nil, takenError(err)
+	}
+	for rows.Next() {
+		var u models.User
+		var email string
+		if err := rows.Scan(&u.Id, &email, &u.CreatedAt, &u.UpdatedAt, &u.Role, &u.Version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		i := byEmail[email]
+		created[i].Id, created[i].CreatedAt, created[i].UpdatedAt, created[i].Role, created[i].Version = u.Id, u.CreatedAt, u.UpdatedAt, u.Role, u.Version
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, takenError(err)
+	}
+	return created, tx.Commit()
+}
+
+func (s *PostgresUserRepository) Update(ctx context.Context, id int, u models.User, version int) (models.User, error) {
+	// a new address has to be verified again; the CASE sees the row's old email, or its hash when
+	// the row was encrypted under an earlier key
+	email, phone := s.encrypted(u)
+	updated, err := s.writeUser(ctx, s.db, `UPDATE users SET name = $1, email = $2, email_index = $11,
+		email_verified_at = CASE WHEN email = $2 OR email_index = $11 THEN email_verified_at END,
+		phone = $6, bio = $7, timezone = $8, locale = $9, username = COALESCE(NULLIF($10, ''), username)
+		WHERE id = $3 AND tenant_id = $5 AND deleted_at IS NULL AND ($4 = 0 OR version = $4)`,
+		u.Name, email, id, version, tenant.ID(ctx), phone, u.Bio, u.Timezone, u.Locale, u.Username, s.emailIndex(u.Email))
+	err = takenError(err)
+	if err == ErrUserNotFound && version != 0 {
+		// tell a stale version apart from a missing user
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenant.ID(ctx)).Scan(&exists); err != nil {
+			return models.User{}, err
+		}
+		if exists {
+			return models.User{}, ErrVersionConflict
+		}
+	}
+	return updated, err
+}
+
+func (s *PostgresUserRepository) Delete(ctx context.Context, id int) (models.User, error) {
+	return s.writeUser(ctx, s.db, "UPDATE users SET deleted_at = now() WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.ID(ctx))
+}
+
+func (s *PostgresUserRepository) DeleteMatching(ctx context.Context, ids []int64, createdBefore *time.Time) ([]models.User, error) {
+	q := s.query()
+	q.conds = append(q.conds, "deleted_at IS NULL")
+	q.conds = append(q.conds, "tenant_id = "+q.bind(tenant.ID(ctx)))
+	if len(ids) > 0 {
+		q.conds = append(q.conds, "id = ANY("+q.bind(ids)+")")
+	}
+	if createdBefore != nil {
+		q.conds = append(q.conds, "created_at < "+q.bind(*createdBefore))
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deleted, err := s.writeUsers(ctx, tx, "UPDATE users SET deleted_at = now()"+q.where(), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	return deleted, tx.Commit()
+}
+
+// what an erased user's email and username become. neither can be signed up with: .invalid never
+// resolves and usernames can't hold an underscore
+const erasedUserQuery = `UPDATE users SET name = 'Erased user', email = 'erased-' || id || '@erased.invalid', username = 'erased_' || id,
+	email_index = NULL, phone = NULL, bio = NULL, timezone = NULL, locale = NULL, avatar_url = NULL, email_verified_at = NULL,
+	password_hash = NULL, totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL, settings = '{}',
+	failed_logins = 0, locked_until = NULL, last_login_at = NULL, tokens_valid_after = now(),
+	deleted_at = COALESCE(deleted_at, now()), erased_at = now()
+	WHERE id = $1 AND tenant_id = $2 AND erased_at IS NULL`
+
+func (s *PostgresUserRepository) Erase(ctx context.Context, id int) (models.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1 AND tenant_id = $2 AND erased_at IS NULL FOR UPDATE", id, tenant.ID(ctx)).Scan(&email)
+	if err == sql.ErrNoRows {
+		return models.User{}, ErrUserNotFound
+	} else if err != nil {
+		return models.User{}, err
+	}
+	if email, err = s.pii.Decrypt(email); err != nil {
+		return models.User{}, err
+	}
+	addresses := []string{strings.ToLower(email)}
+	var pending string
+	err = tx.QueryRowContext(ctx, "SELECT pending_email FROM email_changes WHERE user_id = $1", id).Scan(&pending)
+	if err != nil && err != sql.ErrNoRows {
+		return models.User{}, err
+	} else if err == nil {
+		if pending, err = s.pii.Decrypt(pending); err != nil {
+			return models.User{}, err
+		}
+		addresses = append(addresses, strings.ToLower(pending))
+	}
+	u, err := s.writeUser(ctx, tx, erasedUserQuery, id, tenant.ID(ctx))
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// the revision the update just wrote is anonymous, but the earlier ones aren't
+	for _, q := range []string{
+		"DELETE FROM user_revisions WHERE user_id = $1",
+		"DELETE FROM refresh_tokens WHERE user_id = $1",
+		"DELETE FROM sessions WHERE user_id = $1",
+		"DELETE FROM api_keys WHERE user_id = $1",
+		"DELETE FROM password_resets WHERE user_id = $1",
+		"DELETE FROM totp_backup_codes WHERE user_id = $1",
+		"DELETE FROM user_identities WHERE user_id = $1",
+		"DELETE FROM login_events WHERE user_id = $1",
+		"DELETE FROM notification_preferences WHERE user_id = $1",
+		"DELETE FROM idempotency_keys WHERE user_id = $1",
+		"DELETE FROM data_exports WHERE user_id = $1",
+		"DELETE FROM email_changes WHERE user_id = $1",
+		// the exports they asked for expire at once; their files go with the next clean-up
+		"UPDATE user_exports SET expires_at = now() WHERE requested_by = $1 AND expires_at > now()",
+		"UPDATE audit_log SET before = NULL, after = NULL WHERE entity_type = 'user' AND entity_id = $1",
+		// events already recorded, and the webhook deliveries made of them, keep what they say
+		// happened but take the erased user's details in place of the ones they had, see migration 00048
+		"UPDATE outbox SET payload = redact_user_json(payload, $1) WHERE entity_type = 'user' AND entity_id = $1",
+		`UPDATE webhook_deliveries SET payload = jsonb_set(payload, '{user}', redact_user_json(payload -> 'user', $1))
+			WHERE payload ? 'user' AND outbox_id IN (SELECT id FROM outbox WHERE entity_type = 'user' AND entity_id = $1)`,
+		// what the user consented to is still evidence, where they did it from isn't needed for that
+		"UPDATE user_consents SET ip = NULL, user_agent = NULL WHERE user_id = $1",
+	} {
+		if _, err := tx.ExecContext(ctx, q, id); err != nil {
+			return models.User{}, err
+		}
+	}
+	// failed logins and invitations only know the address
+	if _, err := tx.ExecContext(ctx, "DELETE FROM login_events WHERE user_id IS NULL AND lower(email) = lower($1)", email); err != nil {
+		return models.User{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM invitations WHERE lower(email) = lower($1)
+		AND org_id IN (SELECT id FROM organizations WHERE tenant_id = $2)`, email, tenant.ID(ctx)); err != nil {
+		return models.User{}, err
+	}
+	// as does queued email, sent or not, including to an address being changed to; optional email
+	// also names the user. mail to the address in another tenant is left alone where the message
+	// says which tenant it is for
+	if _, err := tx.ExecContext(ctx, `DELETE FROM jobs WHERE kind = 'email.send' AND ((payload ->> 'user_id')::int = $1
+		OR lower(payload ->> 'to') = ANY ($2) AND COALESCE((payload ->> 'tenant_id')::int, $3) = $3)`, id, addresses, tenant.ID(ctx)); err != nil {
+		return models.User{}, err
+	}
+	return u, tx.Commit()
+}
+
+// what moves from a merged user to the one it is merged into, binding the target as $1 and the
+// source as $2. rows the target already has, such as a tag, stay with the source
+var mergedUserQueries = []string{
+	"UPDATE posts SET author_id = $1 WHERE author_id = $2",
+	"UPDATE comments SET author_id = $1 WHERE author_id = $2",
+	"UPDATE sessions SET user_id = $1 WHERE user_id = $2",
+	"UPDATE refresh_tokens SET user_id = $1 WHERE user_id = $2",
+	"UPDATE api_keys SET user_id = $1 WHERE user_id = $2",
+	"UPDATE user_identities SET user_id = $1 WHERE user_id = $2",
+	"UPDATE user_tags SET user_id = $1 WHERE user_id = $2 AND tag_id NOT IN (SELECT tag_id FROM user_tags WHERE user_id = $1)",
+	// in an organization both belong to, the target takes the source's role when it is higher
+	`UPDATE memberships t SET role = s.role FROM memberships s
+		WHERE t.org_id = s.org_id AND t.user_id = $1 AND s.user_id = $2
+		AND (s.role = 'owner' AND t.role <> 'owner' OR s.role = 'admin' AND t.role = 'member')`,
+	"UPDATE memberships SET user_id = $1 WHERE user_id = $2 AND org_id NOT IN (SELECT org_id FROM memberships WHERE user_id = $1)",
+	"DELETE FROM memberships WHERE user_id = $2",
+}
+
+func (s *PostgresUserRepository) Merge(ctx context.Context, id, sourceID int) (models.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	// locked in id order, so merges running the other way round can't deadlock
+	rows, err := tx.QueryContext(ctx, `SELECT id, updated_at, name, phone, bio, timezone, locale, avatar_url FROM users
+		WHERE id IN ($1, $2) AND tenant_id = $3 AND deleted_at IS NULL ORDER BY id FOR UPDATE`, id, sourceID, tenant.ID(ctx))
+	if err != nil {
+		return models.User{}, err
+	}
+	var found []int
+	var updatedAt time.Time
+	var name string
+	var phone, bio, timezone, locale, avatarURL sql.NullString
+	for rows.Next() {
+		var rowID int
+		var t time.Time
+		var n string
+		var p, b, tz, l, a sql.NullString
+		if err := rows.Scan(&rowID, &t, &n, &p, &b, &tz, &l, &a); err != nil {
+			rows.Close()
+			return models.User{}, err
+		}
+		found = append(found, rowID)
+		if rowID == sourceID {
+			updatedAt, name, phone, bio, timezone, locale, avatarURL = t, n, p, b, tz, l, a
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return models.User{}, err
+	}
+	if !slices.Contains(found, id) {
+		return models.User{}, ErrUserNotFound
+	}
+	if !slices.Contains(found, sourceID) {
+		return models.User{}, ErrMergeSourceNotFound
+	}
+
+	for _, q := range mergedUserQueries {
+		if _, err := tx.ExecContext(ctx, q, id, sourceID); err != nil {
+			return models.User{}, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at = now() WHERE id = $1", sourceID); err != nil {
+		return models.User{}, err
+	}
+	// the newer of the two wins each field it has a value for; $3 is when the source was updated
+	u, err := s.writeUser(ctx, tx, `UPDATE users SET
+		name = CASE WHEN $3 > updated_at THEN $4 ELSE name END,
+		phone = CASE WHEN $3 > updated_at THEN COALESCE($5, phone) ELSE COALESCE(phone, $5) END,
+		bio = CASE WHEN $3 > updated_at THEN COALESCE($6, bio) ELSE COALESCE(bio, $6) END,
+		timezone = CASE WHEN $3 > updated_at THEN COALESCE($7, timezone) ELSE COALESCE(timezone, $7) END,
+		locale = CASE WHEN $3 > updated_at THEN COALESCE($8, locale) ELSE COALESCE(locale, $8) END,
+		avatar_url = CASE WHEN $3 > updated_at THEN COALESCE($9, avatar_url) ELSE COALESCE(avatar_url, $9) END
+		WHERE id = $1 AND tenant_id = $2`,
+		id, tenant.ID(ctx), updatedAt, name, phone, bio, timezone, locale, avatarURL)
+	if err != nil {
+		return models.User{}, err
+	}
+	return u, tx.Commit()
+}
+
+func (s *PostgresUserRepository) Restore(ctx context.Context, id int) (models.User, error) {
+	return s.writeUser(ctx, s.db, "UPDATE users SET deleted_at = NULL WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NOT NULL AND erased_at IS NULL", id, tenant.ID(ctx))
+}
+
+func (s *PostgresUserRepository) SetAvatarURL(ctx context.Context, id int, url string) (models.User, error) {
+	return s.writeUser(ctx, s.db, "UPDATE users SET avatar_url = $1 WHERE id = $2 AND tenant_id = $3 AND deleted_at IS NULL", url, id, tenant.ID(ctx))
+}
+
+func (s *PostgresUserRepository) MarkEmailVerified(ctx context.Context, id int, email string) (models.User, error) {
+	return s.writeUser(ctx, s.db, `UPDATE users SET email_verified_at = COALESCE(email_verified_at, now())
+		WHERE id = $1 AND (email = $2 OR email_index = $4) AND tenant_id = $3 AND deleted_at IS NULL`, id, email, tenant.ID(ctx), s.emailIndex(email))
+}
+
+func (s *PostgresUserRepository) RequestEmailChange(ctx context.Context, id int, email string) error {
+	taken, err := s.EmailExists(ctx, email)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+	res, err := s.db.ExecContext(ctx, `INSERT INTO email_changes (user_id, pending_email)
+		SELECT id, $3 FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+		ON CONFLICT (user_id) DO UPDATE SET pending_email = EXCLUDED.pending_email, requested_at = now()`, id, tenant.ID(ctx), s.pii.Encrypt(email))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *PostgresUserRepository) ConfirmEmailChange(ctx context.Context, id int, email string) (models.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	// the pending address is encrypted afresh each time, so it is compared once opened
+	var pending string
+	err = tx.QueryRowContext(ctx, `SELECT c.pending_email FROM email_changes c JOIN users u ON u.id = c.user_id
+		WHERE c.user_id = $1 AND u.tenant_id = $2 AND u.deleted_at IS NULL FOR UPDATE`, id, tenant.ID(ctx)).Scan(&pending)
+	if err == sql.ErrNoRows {
+		return models.User{}, ErrUserNotFound
+	} else if err != nil {
+		return models.User{}, err
+	}
+	if pending, err = s.pii.Decrypt(pending); err != nil {
+		return models.User{}, err
+	}
+	if !strings.EqualFold(pending, email) {
+		return models.User{}, ErrUserNotFound
+	}
+
+	sealed, _ := s.encrypted(models.User{Email: pending})
+	u, err := s.writeUser(ctx, tx, `UPDATE users SET email = $1, email_index = $2, email_verified_at = now(), tokens_valid_after = now()
+		WHERE id = $3 AND tenant_id = $4`, sealed, s.emailIndex(pending), id, tenant.ID(ctx))
+	if err != nil {
+		return models.User{}, takenError(err)
+	}
+	for _, q := range []string{
+		"DELETE FROM email_changes WHERE user_id = $1",
+		"UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL",
+	} {
+		if _, err := tx.ExecContext(ctx, q, id); err != nil {
+			return models.User{}, err
+		}
+	}
+	return u, tx.Commit()
+}
+
+func (s *PostgresUserRepository) PasswordHash(ctx context.Context, id int) (string, error) {
+	var hash string
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(password_hash, '') FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.ID(ctx)).Scan(&hash)
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	}
+	return hash, err
+}
+
+func (s *PostgresUserRepository) SetPasswordHash(ctx context.Context, id int, hash string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE users SET password_hash = $1 WHERE id = $2 AND tenant_id = $3", hash, id, tenant.ID(ctx))
+	return err
+}
+
+func (s *PostgresUserRepository) TokensValidAfter(ctx context.Context, id int) (time.Time, error) {
+	var after sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT tokens_valid_after FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.ID(ctx)).Scan(&after)
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	}
+	return after.Time, err
+}
+
+func (s *PostgresUserRepository) CreatePasswordReset(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO password_resets (user_id, token_hash, expires_at)
+		SELECT id, $2, $3 FROM users WHERE id = $1 AND tenant_id = $4`, userID, tokenHash, expiresAt, tenant.ID(ctx))
+	return err
+}
+
+func (s *PostgresUserRepository) PasswordResetEmail(ctx context.Context, tokenHash []byte) (string, error) {
+	var email string
+	err := s.db.QueryRowContext(ctx, `SELECT u.email FROM password_resets r JOIN users u ON u.id = r.user_id
+		WHERE r.token_hash = $1 AND r.used_at IS NULL AND r.expires_at > now() AND u.tenant_id = $2 AND u.deleted_at IS NULL`, tokenHash, tenant.ID(ctx)).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidResetToken
+	} else if err != nil {
+		return "", err
+	}
+	return s.pii.Decrypt(email)
+}
+
+func (s *PostgresUserRepository) ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRowContext(ctx, `UPDATE password_resets SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+			AND user_id IN (SELECT id FROM users WHERE tenant_id = $2) RETURNING user_id`, tokenHash, tenant.ID(ctx)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidResetToken
+	} else if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE users SET password_hash = $1, tokens_valid_after = now() WHERE id = $2 AND deleted_at IS NULL", passwordHash, userID)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, ErrInvalidResetToken
+	}
+	// any other links the user asked for are spent too
+	if _, err := tx.ExecContext(ctx, "UPDATE password_resets SET used_at = now() WHERE user_id = $1 AND used_at IS NULL", userID); err != nil {
+		return 0, err
+	}
+	// and every session has to log in again with the new password
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+func (s *PostgresUserRepository) CreateSession(ctx context.Context, userID int, client models.SessionClient, refreshTokenHash []byte, expiresAt time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var sessionID int
+	err = tx.QueryRowContext(ctx, `INSERT INTO sessions (user_id, user_agent, ip)
+		SELECT id, $2, $3 FROM users WHERE id = $1 AND tenant_id = $4 RETURNING id`, userID, client.UserAgent, client.IP, tenant.ID(ctx)).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (session_id, user_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)", sessionID, userID, refreshTokenHash, expiresAt); err != nil {
+		return 0, err
+	}
+	return sessionID, tx.Commit()
+}
+
+func (s *PostgresUserRepository) RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash []byte, expiresAt time.Time, client models.SessionClient) (int, int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var (
+		sessionID, userID   int
+		used, revoked, live bool
+	)
+	err = tx.QueryRowContext(ctx, `SELECT refresh_tokens.session_id, refresh_tokens.user_id,
+			refresh_tokens.used_at IS NOT NULL, refresh_tokens.revoked_at IS NOT NULL OR sessions.revoked_at IS NOT NULL,
+			refresh_tokens.expires_at > now() AND users.deleted_at IS NULL
+		FROM refresh_tokens
+		JOIN sessions ON sessions.id = refresh_tokens.session_id
+		JOIN users ON users.id = refresh_tokens.user_id
+		WHERE refresh_tokens.token_hash = $1 AND users.tenant_id = $2
+		FOR UPDATE OF refresh_tokens, sessions`, tokenHash, tenant.ID(ctx)).Scan(&sessionID, &userID, &used, &revoked, &live)
+	if err == sql.ErrNoRows {
+		return 0, 0, ErrInvalidRefreshToken
+	} else if err != nil {
+		return 0, 0, err
+	}
+	if revoked || !live {
+		return 0, 0, ErrInvalidRefreshToken
+	}
+	if used {
+		// someone is replaying a rotated token: whoever holds the session's current token can't be trusted either
+		if _, err := tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = now() WHERE id = $1", sessionID); err != nil {
+			return 0, 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, 0, err
+		}
+		return 0, 0, ErrRefreshTokenReused
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = now() WHERE token_hash = $1", tokenHash); err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (session_id, user_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)", sessionID, userID, newTokenHash, expiresAt); err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET last_seen_at = now(), user_agent = $1, ip = $2 WHERE id = $3", client.UserAgent, client.IP, sessionID); err != nil {
+		return 0, 0, err
+	}
+	return userID, sessionID, tx.Commit()
+}
+
+func (s *PostgresUserRepository) RevokeRefreshToken(ctx context.Context, tokenHash []byte) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE sessions SET revoked_at = now()
+		WHERE id = (SELECT session_id FROM refresh_tokens WHERE token_hash = $1) AND revoked_at IS NULL
+			AND user_id IN (SELECT id FROM users WHERE tenant_id = $2)`, tokenHash, tenant.ID(ctx))
+	return err
+}
+
+func (s *PostgresUserRepository) Sessions(ctx context.Context, userID int) ([]models.Session, error) {
+	// a session whose refresh tokens have all expired is over, even if nobody logged out
+	rows, err := s.db.QueryContext(ctx, `SELECT id, user_agent, ip, created_at, last_seen_at FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND user_id IN (SELECT id FROM users WHERE tenant_id = $2)
+			AND EXISTS (SELECT 1 FROM refresh_tokens WHERE session_id = sessions.id AND used_at IS NULL AND expires_at > now())
+		ORDER BY last_seen_at DESC, id DESC`, userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []models.Session{}
+	for rows.Next() {
+		var sess models.Session
+		if err := rows.Scan(&sess.Id, &sess.UserAgent, &sess.IP, &sess.CreatedAt, &sess.LastSeenAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *PostgresUserRepository) RecordLogin(ctx context.Context, e models.LoginEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `INSERT INTO login_events (user_id, email, method, success, failure_reason, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, e.UserId, e.Email, e.Method, e.Success, e.FailureReason, e.IP, e.UserAgent); err != nil {
+		return err
+	}
+	if e.Success && e.UserId != nil {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET last_login_at = now(), failed_logins = 0 WHERE id = $1 AND tenant_id = $2", *e.UserId, tenant.ID(ctx)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresUserRepository) RecordFailedLogin(ctx context.Context, id, threshold int, lockout time.Duration) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := s.db.QueryRowContext(ctx, `UPDATE users SET
+			failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+			locked_until = CASE WHEN failed_logins + 1 >= $2 THEN now() + $3::float8 * interval '1 millisecond' END
+		WHERE id = $1 AND tenant_id = $4 AND deleted_at IS NULL RETURNING locked_until`, id, threshold, lockout.Milliseconds(), tenant.ID(ctx)).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	return lockedUntil, err
+}
+
+func (s *PostgresUserRepository) LockedUntil(ctx context.Context, id int) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := s.db.QueryRowContext(ctx, "SELECT CASE WHEN locked_until > now() THEN locked_until END FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.ID(ctx)).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	return lockedUntil, err
+}
+
+func (s *PostgresUserRepository) Unlock(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *PostgresUserRepository) UserByIdentity(ctx context.Context, provider, subject string) (models.User, error) {
+	return s.scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+` FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE tenant_id = $3 AND provider = $1 AND subject = $2)
+			AND tenant_id = $3 AND deleted_at IS NULL`, provider, subject, tenant.ID(ctx)))
+}
+
+func (s *PostgresUserRepository) LinkIdentity(ctx context.Context, userID int, provider, subject, email string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO user_identities (tenant_id, provider, subject, user_id, email)
+		SELECT tenant_id, $1, $2, id, $4 FROM users WHERE id = $3 AND tenant_id = $5
+		ON CONFLICT (tenant_id, provider, subject) DO NOTHING`, provider, subject, userID, email, tenant.ID(ctx))
+	return err
+}
+
+func (s *PostgresUserRepository) Logins(ctx context.Context, userID, limit, offset int) ([]models.LoginEvent, int, error) {
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM login_events WHERE user_id = $1 AND user_id IN (SELECT id FROM users WHERE tenant_id = $2)", userID, tenant.ID(ctx)).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT id, user_id, email, method, success, failure_reason, ip, user_agent, created_at
+		FROM login_events WHERE user_id = $1 AND user_id IN (SELECT id FROM users WHERE tenant_id = $4)
+		ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3`, userID, limit, offset, tenant.ID(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	events := []models.LoginEvent{}
+	for rows.Next() {
+		var e models.LoginEvent
+		if err := rows.Scan(&e.Id, &e.UserId, &e.Email, &e.Method, &e.Success, &e.FailureReason, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
+
+func (s *PostgresUserRepository) LastLoginAt(ctx context.Context, id int) (*time.Time, error) {
+	var at *time.Time
+	err := s.db.QueryRowContext(ctx, "SELECT last_login_at FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.ID(ctx)).Scan(&at)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	return at, err
+}
+
+func (s *PostgresUserRepository) RevokeSession(ctx context.Context, userID, sessionID int) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE sessions SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+		AND user_id IN (SELECT id FROM users WHERE tenant_id = $3)`, sessionID, userID, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *PostgresUserRepository) SessionRevoked(ctx context.Context, sessionID int) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, "SELECT revoked_at IS NOT NULL FROM sessions WHERE id = $1", sessionID).Scan(&revoked)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return revoked, err
+}
+
+const impersonationColumns = "id, admin_id, user_id, reason, started_at, expires_at, ended_at"
+
+func scanImpersonation(row scanner) (models.Impersonation, error) {
+	var i models.Impersonation
+	err := row.Scan(&i.Id, &i.AdminId, &i.UserId, &i.Reason, &i.StartedAt, &i.ExpiresAt, &i.EndedAt)
+	return i, err
+}
+
+func (s *PostgresUserRepository) StartImpersonation(ctx context.Context, adminID, userID int, reason string, expiresAt time.Time) (models.Impersonation, error) {
+	i, err := scanImpersonation(s.db.QueryRowContext(ctx, `INSERT INTO impersonations (tenant_id, admin_id, user_id, reason, expires_at)
+		SELECT $1, $2, id, $4, $5 FROM users WHERE id = $3 AND tenant_id = $1 AND deleted_at IS NULL
+		RETURNING `+impersonationColumns, tenant.ID(ctx), adminID, userID, reason, expiresAt))
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	}
+	return i, err
+}
+
+func (s *PostgresUserRepository) EndImpersonation(ctx context.Context, id int) (models.Impersonation, error) {
+	i, err := scanImpersonation(s.db.QueryRowContext(ctx, `UPDATE impersonations SET ended_at = now()
+		WHERE id = $1 AND tenant_id = $2 AND ended_at IS NULL AND expires_at > now() RETURNING `+impersonationColumns, id, tenant.ID(ctx)))
+	if err == sql.ErrNoRows {
+		err = ErrImpersonationNotFound
+	}
+	return i, err
+}
+
+func (s *PostgresUserRepository) ImpersonationEnded(ctx context.Context, id int) (bool, error) {
+	var ended bool
+	err := s.db.QueryRowContext(ctx, "SELECT ended_at IS NOT NULL OR expires_at <= now() FROM impersonations WHERE id = $1", id).Scan(&ended)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return ended, err
+}
+
+func (s *PostgresUserRepository) NotificationPreferences(ctx context.Context, id int) (models.NotificationPreferences, error) {
+	defaults := models.DefaultNotificationPreferences
+	var p models.NotificationPreferences
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(p.profile_changes, $2), COALESCE(p.product_updates, $3)
+		FROM users u LEFT JOIN notification_preferences p ON p.user_id = u.id
+		WHERE u.id = $1 AND u.tenant_id = $4 AND u.deleted_at IS NULL`, id, defaults.ProfileChanges, defaults.ProductUpdates, tenant.ID(ctx)).Scan(&p.ProfileChanges, &p.ProductUpdates)
+	if err == sql.ErrNoRows {
+		return models.NotificationPreferences{}, ErrUserNotFound
+	}
+	return p, err
+}
+
+func (s *PostgresUserRepository) SetNotificationPreferences(ctx context.Context, id int, p models.NotificationPreferences) error {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO notification_preferences (user_id, profile_changes, product_updates)
+		SELECT id, $2, $3 FROM users WHERE id = $1 AND tenant_id = $4 AND deleted_at IS NULL
+		ON CONFLICT (user_id) DO UPDATE SET profile_changes = EXCLUDED.profile_changes,
+			product_updates = EXCLUDED.product_updates, updated_at = now()`, id, p.ProfileChanges, p.ProductUpdates, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func scanSettings(row scanner) (models.Settings, error) {
+	var raw []byte
+	if err := row.Scan(&raw); err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	settings := models.Settings{}
+	return settings, json.Unmarshal(raw, &settings)
+}
+
+func (s *PostgresUserRepository) Settings(ctx context.Context, id int) (models.Settings, error) {
+	return scanSettings(s.db.QueryRowContext(ctx, "SELECT settings FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.ID(ctx)))
+}
+
+func (s *PostgresUserRepository) UpdateSettings(ctx context.Context, id int, fn func(models.Settings) (models.Settings, error)) (models.Settings, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	current, err := scanSettings(tx.QueryRowContext(ctx, "SELECT settings FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", id, tenant.ID(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	settings, err := fn(current)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET settings = $1 WHERE id = $2", raw, id); err != nil {
+		return nil, err
+	}
+	return settings, tx.Commit()
+}
+
+func (s *PostgresUserRepository) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET totp_secret = $1, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = $2 AND tenant_id = $3 AND deleted_at IS NULL", secret, id, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *PostgresUserRepository) TOTP(ctx context.Context, id int) (string, bool, error) {
+	var secret sql.NullString
+	var enabled bool
+	err := s.db.QueryRowContext(ctx, "SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", id, tenant.ID(ctx)).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	}
+	return secret.String, enabled, err
+}
+
+func (s *PostgresUserRepository) UseTOTPStep(ctx context.Context, id int, step int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET totp_last_step = $1 WHERE id = $2 AND tenant_id = $3 AND (totp_last_step IS NULL OR totp_last_step < $1)", step, id, tenant.ID(ctx))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *PostgresUserRepository) EnableTOTP(ctx context.Context, id int, backupCodeHashes [][]byte) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_enabled_at = COALESCE(totp_enabled_at, now()) WHERE id = $1 AND tenant_id = $2", id, tenant.ID(ctx)); err != nil {
+		return err
+	}
+	if err := replaceBackupCodes(ctx, tx, id, backupCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresUserRepository) ReplaceBackupCodes(ctx context.Context, id int, codeHashes [][]byte) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceBackupCodes(ctx, tx, id, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceBackupCodes(ctx context.Context, tx *sql.Tx, id int, codeHashes [][]byte) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM totp_backup_codes WHERE user_id = $1 AND user_id IN (SELECT id FROM users WHERE tenant_id = $2)", id, tenant.ID(ctx)); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO totp_backup_codes (user_id, code_hash) SELECT id, $2 FROM users WHERE id = $1 AND tenant_id = $3", id, h, tenant.ID(ctx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgresUserRepository) UseBackupCode(ctx context.Context, id int, codeHash []byte) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE totp_backup_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+		AND user_id IN (SELECT id FROM users WHERE tenant_id = $3)`, id, codeHash, tenant.ID(ctx))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *PostgresUserRepository) DisableTOTP(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = $1 AND tenant_id = $2", id, tenant.ID(ctx)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM totp_backup_codes WHERE user_id = $1 AND user_id IN (SELECT id FROM users WHERE tenant_id = $2)", id, tenant.ID(ctx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresUserRepository) Revisions(ctx context.Context, userID int) ([]models.UserRevision, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT rev, created_at, snapshot FROM user_revisions
+		WHERE user_id = $1 AND user_id IN (SELECT id FROM users WHERE tenant_id = $2) ORDER BY rev`, userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []models.UserRevision{}
+	for rows.Next() {
+		rev, err := s.scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *PostgresUserRepository) Revision(ctx context.Context, userID, rev int) (models.UserRevision, error) {
+	r, err := s.scanRevision(s.db.QueryRowContext(ctx, `SELECT rev, created_at, snapshot FROM user_revisions
+		WHERE user_id = $1 AND rev = $2 AND user_id IN (SELECT id FROM users WHERE tenant_id = $3)`, userID, rev, tenant.ID(ctx)))
+	if err == sql.ErrNoRows {
+		err = ErrRevisionNotFound
+	}
+	return r, err
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, created_at, last_used_at"
+
+func scanAPIKey(row scanner) (models.APIKey, error) {
+	var k models.APIKey
+	err := row.Scan(&k.Id, &k.UserId, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt)
+	return k, err
+}
+
+func (s *PostgresUserRepository) CreateAPIKey(ctx context.Context, userID int, name, prefix string, keyHash []byte) (models.APIKey, error) {
+	k, err := scanAPIKey(s.db.QueryRowContext(ctx, `INSERT INTO api_keys (user_id, name, prefix, key_hash)
+		SELECT id, $2, $3, $4 FROM users WHERE id = $1 AND tenant_id = $5 AND deleted_at IS NULL RETURNING `+apiKeyColumns, userID, name, prefix, keyHash, tenant.ID(ctx)))
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	}
+	return k, err
+}
+
+func (s *PostgresUserRepository) APIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+` FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL AND user_id IN (SELECT id FROM users WHERE tenant_id = $2)
+		ORDER BY created_at DESC, id DESC`, userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []models.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *PostgresUserRepository) RevokeAPIKey(ctx context.Context, userID, keyID int) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+		AND user_id IN (SELECT id FROM users WHERE tenant_id = $3)`, keyID, userID, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// the key says which tenant its user is in, so unlike every other lookup this one isn't scoped
+func (s *PostgresUserRepository) APIKeyUser(ctx context.Context, keyHash []byte) (int, int, error) {
+	var userID, tenantID int
+	err := s.db.QueryRowContext(ctx, `UPDATE api_keys SET last_used_at = now() FROM users
+		WHERE key_hash = $1 AND revoked_at IS NULL AND users.id = api_keys.user_id AND users.deleted_at IS NULL
+		RETURNING api_keys.user_id, users.tenant_id`, keyHash).Scan(&userID, &tenantID)
+	if err == sql.ErrNoRows {
+		err = ErrAPIKeyNotFound
+	}
+	return userID, tenantID, err
+}
+
+func (s *PostgresUserRepository) HasPermission(ctx context.Context, userID int, permission string) (bool, error) {
+	var granted bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users JOIN role_permissions ON role_permissions.role = users.role
+		WHERE users.id = $1 AND users.tenant_id = $3 AND users.deleted_at IS NULL AND role_permissions.permission = $2)`, userID, permission, tenant.ID(ctx)).Scan(&granted)
+	return granted, err
+}
+
+func (s *PostgresUserRepository) Roles(ctx context.Context) ([]models.Role, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT roles.name, COALESCE(array_agg(role_permissions.permission ORDER BY role_permissions.permission)
+		FILTER (WHERE role_permissions.permission IS NOT NULL), '{}')
+		FROM roles LEFT JOIN role_permissions ON role_permissions.role = roles.name
+		GROUP BY roles.name ORDER BY roles.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []models.Role{}
+	for rows.Next() {
+		var r models.Role
+		if err := rows.Scan(&r.Name, pgtype.NewMap().SQLScanner(&r.Permissions)); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+func (s *PostgresUserRepository) SetRole(ctx context.Context, id int, role string) (models.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)", role).Scan(&exists); err != nil {
+		return models.User{}, err
+	}
+	if !exists {
+		return models.User{}, ErrUnknownRole
+	}
+
+	// lock the tenant's admins so two concurrent demotions can't both see another admin left
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM users WHERE role = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", models.RoleAdmin, tenant.ID(ctx))
+	if err != nil {
+		return models.User{}, err
+	}
+	var admins []int
+	for rows.Next() {
+		var admin int
+		if err := rows.Scan(&admin); err != nil {
+			rows.Close()
+			return models.User{}, err
+		}
+		admins = append(admins, admin)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return models.User{}, err
+	}
+	if role != models.RoleAdmin && len(admins) == 1 && admins[0] == id {
+		return models.User{}, ErrLastAdmin
+	}
+
+	u, err := s.writeUser(ctx, tx, "UPDATE users SET role = $1 WHERE id = $2 AND tenant_id = $3 AND deleted_at IS NULL", role, id, tenant.ID(ctx))
+	if err != nil {
+		return models.User{}, err
+	}
+	return u, tx.Commit()
+}
+
+func (s *PostgresUserRepository) Search(ctx context.Context, term string, limit, offset int) ([]models.SearchResult, error) {
+	var results []models.SearchResult
+	err := s.replicas.read(ctx, s.db, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, `SELECT `+userColumns+`, ts_rank(search_vector, query) AS rank
+			FROM users, websearch_to_tsquery('simple', $1) query
+			WHERE search_vector @@ query AND tenant_id = $4 AND deleted_at IS NULL
+			ORDER BY rank DESC, id
+			LIMIT $2 OFFSET $3`, term, limit, offset, tenant.ID(ctx))
+		if err != nil {
+			return err
+		}
+		results, err = s.scanSearchResults(rows)
+		return err
+	})
+	return results, err
+}
+
+// ranks by trigram word similarity so "jhon" still finds "John"
+func (s *PostgresUserRepository) FuzzySearch(ctx context.Context, term string, threshold float64, limit, offset int) ([]models.SearchResult, error) {
+	var results []models.SearchResult
+	err := s.replicas.read(ctx, s.db, func(db *sql.DB) error {
+		var err error
+		results, err = s.fuzzySearch(ctx, db, term, threshold, limit, offset)
+		return err
+	})
+	return results, err
+}
+
+func (s *PostgresUserRepository) fuzzySearch(ctx context.Context, db *sql.DB, term string, threshold float64, limit, offset int) ([]models.SearchResult, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the <% operator filters on this setting, which lets it use the trigram indexes
+	_, err = tx.ExecContext(ctx, "SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)", strconv.FormatFloat(threshold, 'f', -1, 64))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+userColumns+`, greatest(word_similarity($1, name), word_similarity($1, email)) AS rank
+		FROM users
+		WHERE ($1 <% name OR $1 <% email) AND tenant_id = $4 AND deleted_at IS NULL
+		ORDER BY rank DESC, id
+		LIMIT $2 OFFSET $3`, term, limit, offset, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.scanSearchResults(rows)
+	if err != nil {
+		return nil, err
+	}
+	return results, tx.Commit()
+}
+
+func (s *PostgresUserRepository) Tags(ctx context.Context) ([]models.Tag, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT t.name, COUNT(*) FROM tags t
+		JOIN user_tags ut ON ut.tag_id = t.id JOIN users u ON u.id = ut.user_id AND u.deleted_at IS NULL
+		WHERE t.tenant_id = $1 GROUP BY t.name ORDER BY t.name`, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []models.Tag{}
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.Name, &t.Users); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func userTags(ctx context.Context, q querier, userID int) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT t.name FROM user_tags ut JOIN tags t ON t.id = ut.tag_id
+		WHERE ut.user_id = $1 AND t.tenant_id = $2 ORDER BY t.name`, userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+	return tags, rows.Err()
+}
+
+func (s *PostgresUserRepository) UserTags(ctx context.Context, userID int) ([]string, error) {
+	return userTags(ctx, s.db, userID)
+}
+
+func (s *PostgresUserRepository) TagUser(ctx context.Context, userID int, tag string) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var live bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", userID, tenant.ID(ctx)).Scan(&live); err != nil {
+		return nil, err
+	}
+	if !live {
+		return nil, ErrUserNotFound
+	}
+	// tags stay once created, so one can't disappear between these two statements
+	if _, err := tx.ExecContext(ctx, "INSERT INTO tags (tenant_id, name) VALUES ($1, $2) ON CONFLICT (tenant_id, name) DO NOTHING", tenant.ID(ctx), tag); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO user_tags (user_id, tag_id)
+		SELECT $1, id FROM tags WHERE tenant_id = $2 AND name = $3 ON CONFLICT DO NOTHING`, userID, tenant.ID(ctx), tag); err != nil {
+		return nil, err
+	}
+	tags, err := userTags(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return tags, tx.Commit()
+}
+
+func (s *PostgresUserRepository) UntagUser(ctx context.Context, userID int, tag string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM user_tags WHERE user_id = $1
+		AND tag_id IN (SELECT id FROM tags WHERE tenant_id = $2 AND name = $3)`, userID, tenant.ID(ctx), tag)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"api/internal/models"
+	"api/internal/tenant"
+)
+
+// Posts keeps what users write, each loaded along with its author. only posts by live users of the
+// tenant on the context are found
+type Posts interface {
+	// ErrUserNotFound if there is no such live author
+	Create(ctx context.Context, p models.Post) (models.Post, error)
+	// ErrPostNotFound if there is no such post
+	Get(ctx context.Context, id int) (models.Post, error)
+	// one page of posts, newest first, along with their total number; authorID 0 lists every author's
+	List(ctx context.Context, authorID, limit, offset int) ([]models.Post, int, error)
+	// change a post's title and body
+	Update(ctx context.Context, p models.Post) (models.Post, error)
+	// remove a post along with its comments
+	Delete(ctx context.Context, id int) error
+	// one page of a post's comments, oldest first, along with their total number. comments by
+	// deleted users are hidden
+	Comments(ctx context.Context, postID, limit, offset int) ([]models.Comment, int, error)
+	// ErrCommentNotFound if the post has no such comment
+	Comment(ctx context.Context, postID, id int) (models.Comment, error)
+	// ErrPostNotFound if there is no such post, ErrUserNotFound if there is no such live author
+	AddComment(ctx context.Context, c models.Comment) (models.Comment, error)
+	// change a comment's body
+	UpdateComment(ctx context.Context, c models.Comment) (models.Comment, error)
+	DeleteComment(ctx context.Context, postID, id int) error
+}
+
+// PostgresPosts keeps posts in the posts table and their comments in comments.
+type PostgresPosts struct {
+	db *sql.DB
+}
+
+var _ Posts = (*PostgresPosts)(nil)
+
+func NewPostgresPosts(db *sql.DB) *PostgresPosts {
+	return &PostgresPosts{db: db}
+}
+
+// columns of a post joined to its author as u, wherever the post itself comes from
+const postColumns = "p.id, p.author_id, p.title, p.body, p.created_at, p.updated_at, u.name, u.avatar_url"
+
+// live authors of the tenant given as $1
+const postAuthors = "JOIN users u ON u.id = p.author_id AND u.tenant_id = $1 AND u.deleted_at IS NULL"
+
+func scanPost(row scanner) (models.Post, error) {
+	var p models.Post
+	err := row.Scan(&p.Id, &p.AuthorId, &p.Title, &p.Body, &p.CreatedAt, &p.UpdatedAt, &p.Author.Name, &p.Author.AvatarURL)
+	if err == sql.ErrNoRows {
+		err = ErrPostNotFound
+	}
+	p.Author.Id = p.AuthorId
+	return p, err
+}
+
+func (s *PostgresPosts) Create(ctx context.Context, p models.Post) (models.Post, error) {
+	created, err := scanPost(s.db.QueryRowContext(ctx, `WITH p AS (
+			INSERT INTO posts (author_id, title, body)
+			SELECT id, $3, $4 FROM users WHERE id = $2 AND tenant_id = $1 AND deleted_at IS NULL
+			RETURNING *)
+		SELECT `+postColumns+` FROM p `+postAuthors, tenant.ID(ctx), p.AuthorId, p.Title, p.Body))
+	if err == ErrPostNotFound {
+		err = ErrUserNotFound
+	}
+	return created, err
+}
+
+func (s *PostgresPosts) Get(ctx context.Context, id int) (models.Post, error) {
+	return scanPost(s.db.QueryRowContext(ctx, "SELECT "+postColumns+" FROM posts p "+postAuthors+" WHERE p.id = $2", tenant.ID(ctx), id))
+}
+
+func (s *PostgresPosts) List(ctx context.Context, authorID, limit, offset int) ([]models.Post, int, error) {
+	where := " WHERE $2 = 0 OR p.author_id = $2"
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM posts p "+postAuthors+where, tenant.ID(ctx), authorID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT "+postColumns+" FROM posts p "+postAuthors+where+
+		" ORDER BY p.created_at DESC, p.id DESC LIMIT $3 OFFSET $4", tenant.ID(ctx), authorID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	posts := []models.Post{}
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, total, rows.Err()
+}
+
+func (s *PostgresPosts) Update(ctx context.Context, p models.Post) (models.Post, error) {
+	return scanPost(s.db.QueryRowContext(ctx, `WITH p AS (
+			UPDATE posts SET title = $3, body = $4, updated_at = now()
+			WHERE id = $2 AND author_id IN (SELECT id FROM users WHERE tenant_id = $1 AND deleted_at IS NULL)
+			RETURNING *)
+		SELECT `+postColumns+` FROM p `+postAuthors, tenant.ID(ctx), p.Id, p.Title, p.Body))
+}
+
+func (s *PostgresPosts) Delete(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM posts WHERE id = $2
+		AND author_id IN (SELECT id FROM users WHERE tenant_id = $1 AND deleted_at IS NULL)`, tenant.ID(ctx), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPostNotFound
+	}
+	return nil
+}
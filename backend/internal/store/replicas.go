@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Replicas spreads reads over read replicas of the primary database, taking turns. only reads on a
+// context from WithReplicaReads go to them, since a replica may trail the primary, and a read
+// falls back to the primary when its replica fails. a nil *Replicas reads from the primary.
+type Replicas struct {
+	dbs  []*sql.DB
+	next atomic.Uint64
+}
+
+// NewReplicas spreads reads over dbs, or returns nil when there are none.
+func NewReplicas(dbs ...*sql.DB) *Replicas {
+	if len(dbs) == 0 {
+		return nil
+	}
+	return &Replicas{dbs: dbs}
+}
+
+type replicaReadsKey struct{}
+
+// WithReplicaReads lets the user reads made with ctx be served by a replica, for requests that
+// write nothing and can show data a moment old.
+func WithReplicaReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaReadsKey{}, true)
+}
+
+// run read on the next replica when ctx allows it, and on primary otherwise or when the replica
+// fails rather than answering
+func (r *Replicas) read(ctx context.Context, primary *sql.DB, read func(db *sql.DB) error) error {
+	if r == nil || ctx.Value(replicaReadsKey{}) == nil {
+		return read(primary)
+	}
+	i := r.next.Add(1) % uint64(len(r.dbs))
+	err := read(r.dbs[i])
+	if failed, _ := isDBFailure(err); !failed {
+		return err
+	}
+	slog.WarnContext(ctx, "read replica failed, reading from the primary", "err", err, "replica", i)
+	return read(primary)
+}
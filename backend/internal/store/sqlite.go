@@ -0,0 +1,1443 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"api/internal/models"
+	"api/internal/pii"
+	"api/internal/tenant"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteUserRepository is a UserRepository kept in a SQLite database file, for deployments that
+// run as a single binary without a database server. like PostgresUserRepository, every query is
+// scoped to the tenant on its context. there are no full text or trigram indexes, so Search and
+// FuzzySearch match words and substrings as MemoryUserRepository does
+type SQLiteUserRepository struct {
+	sqlUsers
+	db *sql.DB
+}
+
+var _ UserRepository = (*SQLiteUserRepository)(nil)
+
+// NewSQLiteUserRepository keeps users in db, opened with OpenSQLite and migrated with
+// MigrateSQLite. emails and phone numbers are encrypted with keys, or stored in plain text when
+// keys is nil.
+func NewSQLiteUserRepository(db *sql.DB, keys *pii.Keyring) *SQLiteUserRepository {
+	return &SQLiteUserRepository{sqlUsers: sqlUsers{dialect: sqliteDialect, pii: keys}, db: db}
+}
+
+// OpenSQLite opens the SQLite database file at path, creating it when it is missing. foreign keys
+// are enforced, writers wait for each other rather than fail, and every transaction takes the
+// write lock as it begins, standing in for the row locks Postgres transactions take.
+func OpenSQLite(path string) (*sql.DB, error) {
+	return sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite&_timezone=UTC&_txlock=immediate")
+}
+
+// times are stored as UTC text to the millisecond, as strftime writes them in the schema, so they
+// compare as text in time order and one read back binds as the same text
+const sqliteTimeLayout = "2006-01-02 15:04:05.000+00:00"
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+// the current time, bound where the Postgres queries call now()
+func sqliteNow() string {
+	return sqliteTime(time.Now())
+}
+
+// a unique violation names the columns of the index it broke, or the index when that is on an expression
+func sqliteTakenError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		switch msg := sqliteErr.Error(); {
+		case strings.Contains(msg, "users_email_key"), strings.Contains(msg, "users.email_index"):
+			return ErrEmailTaken
+		case strings.Contains(msg, "users.username"):
+			return ErrUsernameTaken
+		}
+	}
+	return err
+}
+
+// insertUserQuery with the creation time bound as ?11
+const sqliteInsertUserQuery = `INSERT INTO users (name, email, password_hash, tenant_id, phone, bio, timezone, locale, username, email_index, role, created_at, updated_at)
+	VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, CASE WHEN EXISTS (SELECT 1 FROM users WHERE tenant_id = ?4) THEN 'user' ELSE 'admin' END, ?11, ?11)`
+
+func (s *SQLiteUserRepository) List(ctx context.Context, f models.UserFilter, sort models.UserSort, limit, offset int) ([]models.User, int, error) {
+	column, ok := sortColumns[sort.Field]
+	if !ok {
+		return nil, 0, fmt.Errorf("cannot sort users by %q", sort.Field)
+	}
+	direction := sortDirection(sort.Desc)
+
+	q := s.filterQuery(ctx, f)
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+q.where(), q.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	// column and direction come from fixed strings above, never from raw input, as do the selected columns
+	columns := selectedColumns(f)
+	rows, err := s.db.QueryContext(ctx, "SELECT "+strings.Join(columns, ", ")+" FROM users"+q.where()+
+		" ORDER BY "+column+" "+direction+", id "+direction+
+		" LIMIT "+q.bind(limit)+" OFFSET "+q.bind(offset), q.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	users, err := s.scanUsers(rows, columns)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (s *SQLiteUserRepository) ListAfter(ctx context.Context, f models.UserFilter, after *models.UserCursor, desc bool, limit int) ([]models.User, error) {
+	direction := sortDirection(desc)
+	q := s.filterQuery(ctx, f)
+	if after != nil {
+		cmp := ">"
+		if desc {
+			cmp = "<"
+		}
+		q.conds = append(q.conds, "(created_at, id) "+cmp+" ("+q.bind(after.CreatedAt)+", "+q.bind(after.Id)+")")
+	}
+
+	// created_at is read whatever the fields, as the next cursor is made from it
+	columns := selectedColumns(f, "created_at")
+	rows, err := s.db.QueryContext(ctx, "SELECT "+strings.Join(columns, ", ")+" FROM users"+q.where()+
+		" ORDER BY created_at "+direction+", id "+direction+
+		" LIMIT "+q.bind(limit), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanUsers(rows, columns)
+}
+
+// the reads run in a transaction, which holds the write lock, so no change before the horizon can
+// still be in flight and both reads see the same users
+func (s *SQLiteUserRepository) Changes(ctx context.Context, after *models.SyncCursor, limit int) (models.UserChanges, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.UserChanges{}, err
+	}
+	defer tx.Rollback()
+	horizon := time.Now().UTC().Truncate(time.Millisecond)
+
+	q := s.query()
+	q.conds = append(q.conds, "tenant_id = "+q.bind(tenant.ID(ctx)), "updated_at < "+q.bind(horizon))
+	if after == nil {
+		q.conds = append(q.conds, "deleted_at IS NULL")
+	} else {
+		q.conds = append(q.conds, "(updated_at, id) > ("+q.bind(after.ChangedAt)+", "+q.bind(after.Id)+")")
+	}
+	// one more of each than needed, to learn whether more are waiting
+	rows, err := tx.QueryContext(ctx, "SELECT "+userColumns+" FROM users"+q.where()+" ORDER BY updated_at, id LIMIT "+q.bind(limit+1), q.args...)
+	if err != nil {
+		return models.UserChanges{}, err
+	}
+	users, err := s.scanUsers(rows, userColumnNames)
+	if err != nil {
+		return models.UserChanges{}, err
+	}
+
+	var gone []models.UserTombstone
+	if after != nil {
+		rows, err := tx.QueryContext(ctx, `SELECT user_id, deleted_at FROM user_tombstones
+			WHERE tenant_id = ?1 AND deleted_at < ?2 AND (deleted_at, user_id) > (?3, ?4)
+			ORDER BY deleted_at, user_id LIMIT ?5`, tenant.ID(ctx), sqliteTime(horizon), sqliteTime(after.ChangedAt), after.Id, limit+1)
+		if err != nil {
+			return models.UserChanges{}, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var t models.UserTombstone
+			if err := rows.Scan(&t.Id, &t.DeletedAt); err != nil {
+				return models.UserChanges{}, err
+			}
+			gone = append(gone, t)
+		}
+		if err := rows.Err(); err != nil {
+			return models.UserChanges{}, err
+		}
+	}
+	return mergeChanges(users, gone, after, horizon, limit), tx.Commit()
+}
+
+// every count comes from one query, so they agree with each other
+func (s *SQLiteUserRepository) Stats(ctx context.Context) (models.UserStats, error) {
+	var st models.UserStats
+	var byRole []byte
+	now := time.Now()
+	err := s.db.QueryRowContext(ctx, `SELECT count(*), count(email_verified_at),
+			count(CASE WHEN created_at > ?2 THEN 1 END),
+			count(CASE WHEN created_at > ?3 THEN 1 END),
+			count(CASE WHEN created_at > ?4 THEN 1 END),
+			(SELECT json_group_object(name, n) FROM (
+				SELECT roles.name, count(u.id) AS n FROM roles
+				LEFT JOIN users u ON u.role = roles.name AND u.tenant_id = ?1 AND u.deleted_at IS NULL
+				GROUP BY roles.name))
+		FROM users WHERE tenant_id = ?1 AND deleted_at IS NULL`, tenant.ID(ctx),
+		sqliteTime(now.Add(-24*time.Hour)), sqliteTime(now.AddDate(0, 0, -7)), sqliteTime(now.AddDate(0, 0, -30))).Scan(&st.Total, &st.ByVerification.Verified,
+		&st.Created.Last24h, &st.Created.Last7d, &st.Created.Last30d, &byRole)
+	if err != nil {
+		return models.UserStats{}, err
+	}
+	st.ByVerification.Unverified = st.Total - st.ByVerification.Verified
+	if err := json.Unmarshal(byRole, &st.ByRole); err != nil {
+		return models.UserStats{}, err
+	}
+	return st, nil
+}
+
+// there is no generate_series, so the creation times are read and counted into buckets here
+func (s *SQLiteUserRepository) Signups(ctx context.Context, unit string, from, to time.Time, includeDeleted bool) ([]models.SignupBucket, error) {
+	buckets := []models.SignupBucket{}
+	end := bucketStart(unit, from)
+	for ; !end.After(to.UTC()); end = nextBucket(unit, end) {
+		buckets = append(buckets, models.SignupBucket{Start: end})
+	}
+	if len(buckets) == 0 {
+		return buckets, nil
+	}
+
+	query := "SELECT created_at FROM users WHERE tenant_id = ?1 AND created_at >= ?2 AND created_at < ?3"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+	rows, err := s.db.QueryContext(ctx, query, tenant.ID(ctx), sqliteTime(buckets[0].Start), sqliteTime(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var createdAt time.Time
+		if err := rows.Scan(&createdAt); err != nil {
+			return nil, err
+		}
+		// the last bucket starting at or before the user was created
+		i, found := slices.BinarySearchFunc(buckets, createdAt, func(b models.SignupBucket, t time.Time) int { return b.Start.Compare(t) })
+		if !found {
+			i--
+		}
+		buckets[i].Count++
+	}
+	return buckets, rows.Err()
+}
+
+func (s *SQLiteUserRepository) Each(ctx context.Context, f models.UserFilter, fn func(models.User) error) error {
+	q := s.filterQuery(ctx, f)
+	rows, err := s.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users"+q.where()+" ORDER BY id", q.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := s.scanUser(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLiteUserRepository) Get(ctx context.Context, id int, includeDeleted bool) (models.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE id = ?1 AND tenant_id = ?2"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+	return s.scanUser(s.db.QueryRowContext(ctx, query, id, tenant.ID(ctx)))
+}
+
+func (s *SQLiteUserRepository) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	var hash string
+	u, err := s.scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+", COALESCE(password_hash, '') FROM users WHERE (lower(email) = lower(?1) OR email_index = ?3) AND tenant_id = ?2 AND deleted_at IS NULL", email, tenant.ID(ctx), s.emailIndex(email)), &hash)
+	u.PasswordHash = hash
+	return u, err
+}
+
+func (s *SQLiteUserRepository) GetByUsername(ctx context.Context, username string) (models.User, error) {
+	return s.scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = ?1 AND tenant_id = ?2 AND deleted_at IS NULL", username, tenant.ID(ctx)))
+}
+
+func (s *SQLiteUserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE (lower(email) = lower(?1) OR email_index = ?3) AND tenant_id = ?2)", email, tenant.ID(ctx), s.emailIndex(email)).Scan(&exists)
+	return exists, err
+}
+
+// there are no arrays to bind, so each address and hash is a placeholder of its own
+func (s *SQLiteUserRepository) ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	existing := map[string]bool{}
+	if len(emails) == 0 {
+		return existing, nil
+	}
+	q := s.query()
+	var addresses, indexes []string
+	for _, email := range emails {
+		addresses = append(addresses, q.bind(email))
+		if s.pii != nil {
+			indexes = append(indexes, q.bind(s.pii.Index(email)))
+		}
+	}
+	match := "lower(email) IN (" + strings.Join(addresses, ", ") + ")"
+	if len(indexes) > 0 {
+		match = "(" + match + " OR email_index IN (" + strings.Join(indexes, ", ") + "))"
+	}
+	q.conds = append(q.conds, match, "tenant_id = "+q.bind(tenant.ID(ctx)))
+	rows, err := s.db.QueryContext(ctx, "SELECT email FROM users"+q.where(), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		if email, err = s.pii.Decrypt(email); err != nil {
+			return nil, err
+		}
+		existing[strings.ToLower(email)] = true
+	}
+	return existing, rows.Err()
+}
+
+// insert u, created at createdAt, and fill in what the database generated
+func (s *SQLiteUserRepository) insert(ctx context.Context, q rowQuerier, u models.User, createdAt time.Time) (models.User, error) {
+	email, phone := s.encrypted(u)
+	created, err := s.writeUser(ctx, q, sqliteInsertUserQuery, u.Name, email, nullablePasswordHash(u.PasswordHash), tenant.ID(ctx),
+		phone, u.Bio, u.Timezone, u.Locale, u.Username, s.emailIndex(u.Email), sqliteTime(createdAt))
+	if err != nil {
+		return models.User{}, sqliteTakenError(err)
+	}
+	u.Id, u.CreatedAt, u.UpdatedAt, u.Role, u.Version = created.Id, created.CreatedAt, created.UpdatedAt, created.Role, created.Version
+	return u, nil
+}
+
+func (s *SQLiteUserRepository) Create(ctx context.Context, u models.User) (models.User, error) {
+	derive := u.Username == ""
+	for attempt := 1; ; attempt++ {
+		if derive {
+			var err error
+			if u.Username, err = freeUsername(ctx, s.dialect, s.db, u.Name); err != nil {
+				return models.User{}, err
+			}
+		}
+		created, err := s.insert(ctx, s.db, u, time.Now())
+		// a concurrent insert took the derived username between choosing it and inserting
+		if err == ErrUsernameTaken && derive && attempt < usernameAttempts {
+			continue
+		}
+		return created, err
+	}
+}
+
+// the holder of the email is looked up before writing, in a transaction that keeps anyone else
+// from taking the email or the derived username meanwhile
+func (s *SQLiteUserRepository) Upsert(ctx context.Context, u models.User) (models.User, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, false, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var deleted bool
+	err = tx.QueryRowContext(ctx, "SELECT id, deleted_at IS NOT NULL FROM users WHERE (lower(email) = lower(?1) OR email_index = ?3) AND tenant_id = ?2",
+		u.Email, tenant.ID(ctx), s.emailIndex(u.Email)).Scan(&id, &deleted)
+	switch {
+	case err == sql.ErrNoRows:
+		if u.Username == "" {
+			if u.Username, err = freeUsername(ctx, s.dialect, tx, u.Name); err != nil {
+				return models.User{}, false, err
+			}
+		}
+		created, err := s.insert(ctx, tx, u, time.Now())
+		if err != nil {
+			return models.User{}, false, err
+		}
+		return created, true, tx.Commit()
+	case err != nil:
+		return models.User{}, false, err
+	case deleted:
+		return models.User{}, false, ErrEmailTaken
+	}
+
+	email, phone := s.encrypted(u)
+	updated, err := s.writeUser(ctx, tx, `UPDATE users SET name = ?1, email = ?2, email_index = ?3, phone = ?4, bio = ?5, timezone = ?6, locale = ?7,
+		username = COALESCE(NULLIF(?8, ''), username) WHERE id = ?9`,
+		u.Name, email, s.emailIndex(u.Email), phone, u.Bio, u.Timezone, u.Locale, u.Username, id)
+	if err != nil {
+		return models.User{}, false, sqliteTakenError(err)
+	}
+	return updated, false, tx.Commit()
+}
+
+// users are inserted one by one in a single transaction. usernames are derived as each is
+// inserted, steering clear of those given in the batch
+func (s *SQLiteUserRepository) CreateMany(ctx context.Context, users []models.User) ([]models.User, error) {
+	if len(users) == 0 {
+		return []models.User{}, nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var given []string
+	for _, u := range users {
+		if u.Username != "" {
+			given = append(given, u.Username)
+		}
+	}
+	created := make([]models.User, len(users))
+	now := time.Now()
+	for i, u := range users {
+		if u.Username == "" {
+			if u.Username, err = freeUsername(ctx, s.dialect, tx, u.Name, given...); err != nil {
+				return nil, err
+			}
+		}
+		// a CreatedAt backdates the user, as seeded demo data is
+		createdAt := now
+		if !u.CreatedAt.IsZero() {
+			createdAt = u.CreatedAt
+		}
+		if created[i], err = s.insert(ctx, tx, u, createdAt); err != nil {
+			return nil, err
+		}
+	}
+	return created, tx.Commit()
+}
+
+func (s *SQLiteUserRepository) Update(ctx context.Context, id int, u models.User, version int) (models.User, error) {
+	// a new address has to be verified again; the CASE sees the row's old email, or its hash when
+	// the row was encrypted under an earlier key
+	email, phone := s.encrypted(u)
+	updated, err := s.writeUser(ctx, s.db, `UPDATE users SET name = ?1, email = ?2, email_index = ?11,
+		email_verified_at = CASE WHEN email = ?2 OR email_index = ?11 THEN email_verified_at END,
+		phone = ?6, bio = ?7, timezone = ?8, locale = ?9, username = COALESCE(NULLIF(?10, ''), username)
+		WHERE id = ?3 AND tenant_id = ?5 AND deleted_at IS NULL AND (?4 = 0 OR version = ?4)`,
+		u.Name, email, id, version, tenant.ID(ctx), phone, u.Bio, u.Timezone, u.Locale, u.Username, s.emailIndex(u.Email))
+	err = sqliteTakenError(err)
+	if err == ErrUserNotFound && version != 0 {
+		// tell a stale version apart from a missing user
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL)", id, tenant.ID(ctx)).Scan(&exists); err != nil {
+			return models.User{}, err
+		}
+		if exists {
+			return models.User{}, ErrVersionConflict
+		}
+	}
+	return updated, err
+}
+
+func (s *SQLiteUserRepository) Delete(ctx context.Context, id int) (models.User, error) {
+	return s.writeUser(ctx, s.db, "UPDATE users SET deleted_at = ?3 WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL", id, tenant.ID(ctx), sqliteNow())
+}
+
+func (s *SQLiteUserRepository) DeleteMatching(ctx context.Context, ids []int64, createdBefore *time.Time) ([]models.User, error) {
+	q := s.query()
+	q.conds = append(q.conds, "deleted_at IS NULL")
+	q.conds = append(q.conds, "tenant_id = "+q.bind(tenant.ID(ctx)))
+	if len(ids) > 0 {
+		in := make([]string, len(ids))
+		for i, id := range ids {
+			in[i] = q.bind(id)
+		}
+		q.conds = append(q.conds, "id IN ("+strings.Join(in, ", ")+")")
+	}
+	if createdBefore != nil {
+		q.conds = append(q.conds, "created_at < "+q.bind(*createdBefore))
+	}
+
+	// the deleted users are read back after the update, so nobody may write them in between
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deleted, err := s.writeUsers(ctx, tx, "UPDATE users SET deleted_at = "+q.bind(sqliteNow())+q.where(), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	return deleted, tx.Commit()
+}
+
+// erasedUserQuery, binding the time of erasure as ?3
+const sqliteErasedUserQuery = `UPDATE users SET name = 'Erased user', email = 'erased-' || id || '@erased.invalid', username = 'erased_' || id,
+	email_index = NULL, phone = NULL, bio = NULL, timezone = NULL, locale = NULL, avatar_url = NULL, email_verified_at = NULL,
+	password_hash = NULL, totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL, settings = '{}',
+	failed_logins = 0, locked_until = NULL, last_login_at = NULL, tokens_valid_after = ?3,
+	deleted_at = COALESCE(deleted_at, ?3), erased_at = ?3
+	WHERE id = ?1 AND tenant_id = ?2 AND erased_at IS NULL`
+
+// only the tables SQLite has are cleared: exports, queued email, audit entries and events are
+// kept by features that need Postgres
+func (s *SQLiteUserRepository) Erase(ctx context.Context, id int) (models.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRowContext(ctx, "SELECT email FROM users WHERE id = ?1 AND tenant_id = ?2 AND erased_at IS NULL", id, tenant.ID(ctx)).Scan(&email)
+	if err == sql.ErrNoRows {
+		return models.User{}, ErrUserNotFound
+	} else if err != nil {
+		return models.User{}, err
+	}
+	if email, err = s.pii.Decrypt(email); err != nil {
+		return models.User{}, err
+	}
+	u, err := s.writeUser(ctx, tx, sqliteErasedUserQuery, id, tenant.ID(ctx), sqliteNow())
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// the revision the update just wrote is anonymous, but the earlier ones aren't
+	for _, q := range []string{
+		"DELETE FROM user_revisions WHERE user_id = ?1 AND rev < (SELECT max(rev) FROM user_revisions WHERE user_id = ?1)",
+		"UPDATE user_revisions SET rev = 1 WHERE user_id = ?1",
+		"DELETE FROM refresh_tokens WHERE user_id = ?1",
+		"DELETE FROM sessions WHERE user_id = ?1",
+		"DELETE FROM api_keys WHERE user_id = ?1",
+		"DELETE FROM password_resets WHERE user_id = ?1",
+		"DELETE FROM totp_backup_codes WHERE user_id = ?1",
+		"DELETE FROM user_identities WHERE user_id = ?1",
+		"DELETE FROM login_events WHERE user_id = ?1",
+		"DELETE FROM notification_preferences WHERE user_id = ?1",
+		"DELETE FROM email_changes WHERE user_id = ?1",
+	} {
+		if _, err := tx.ExecContext(ctx, q, id); err != nil {
+			return models.User{}, err
+		}
+	}
+	// failed logins only know the address
+	if _, err := tx.ExecContext(ctx, "DELETE FROM login_events WHERE user_id IS NULL AND lower(email) = lower(?1)", email); err != nil {
+		return models.User{}, err
+	}
+	return u, tx.Commit()
+}
+
+// mergedUserQueries for the tables SQLite has, binding the target as ?1 and the source as ?2
+var sqliteMergedUserQueries = []string{
+	"UPDATE sessions SET user_id = ?1 WHERE user_id = ?2",
+	"UPDATE refresh_tokens SET user_id = ?1 WHERE user_id = ?2",
+	"UPDATE api_keys SET user_id = ?1 WHERE user_id = ?2",
+	"UPDATE user_identities SET user_id = ?1 WHERE user_id = ?2",
+	"UPDATE user_tags SET user_id = ?1 WHERE user_id = ?2 AND tag_id NOT IN (SELECT tag_id FROM user_tags WHERE user_id = ?1)",
+}
+
+func (s *SQLiteUserRepository) Merge(ctx context.Context, id, sourceID int) (models.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, updated_at, name, phone, bio, timezone, locale, avatar_url FROM users
+		WHERE id IN (?1, ?2) AND tenant_id = ?3 AND deleted_at IS NULL`, id, sourceID, tenant.ID(ctx))
+	if err != nil {
+		return models.User{}, err
+	}
+	var found []int
+	var updatedAt time.Time
+	var name string
+	var phone, bio, timezone, locale, avatarURL sql.NullString
+	for rows.Next() {
+		var rowID int
+		var t time.Time
+		var n string
+		var p, b, tz, l, a sql.NullString
+		if err := rows.Scan(&rowID, &t, &n, &p, &b, &tz, &l, &a); err != nil {
+			rows.Close()
+			return models.User{}, err
+		}
+		found = append(found, rowID)
+		if rowID == sourceID {
+			updatedAt, name, phone, bio, timezone, locale, avatarURL = t, n, p, b, tz, l, a
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return models.User{}, err
+	}
+	if !slices.Contains(found, id) {
+		return models.User{}, ErrUserNotFound
+	}
+	if !slices.Contains(found, sourceID) || sourceID == id {
+		return models.User{}, ErrMergeSourceNotFound
+	}
+
+	for _, q := range sqliteMergedUserQueries {
+		if _, err := tx.ExecContext(ctx, q, id, sourceID); err != nil {
+			return models.User{}, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at = ?2 WHERE id = ?1", sourceID, sqliteNow()); err != nil {
+		return models.User{}, err
+	}
+	// the newer of the two wins each field it has a value for; ?3 is when the source was updated
+	u, err := s.writeUser(ctx, tx, `UPDATE users SET
+		name = CASE WHEN ?3 > updated_at THEN ?4 ELSE name END,
+		phone = CASE WHEN ?3 > updated_at THEN COALESCE(?5, phone) ELSE COALESCE(phone, ?5) END,
+		bio = CASE WHEN ?3 > updated_at THEN COALESCE(?6, bio) ELSE COALESCE(bio, ?6) END,
+		timezone = CASE WHEN ?3 > updated_at THEN COALESCE(?7, timezone) ELSE COALESCE(timezone, ?7) END,
+		locale = CASE WHEN ?3 > updated_at THEN COALESCE(?8, locale) ELSE COALESCE(locale, ?8) END,
+		avatar_url = CASE WHEN ?3 > updated_at THEN COALESCE(?9, avatar_url) ELSE COALESCE(avatar_url, ?9) END
+		WHERE id = ?1 AND tenant_id = ?2`,
+		id, tenant.ID(ctx), sqliteTime(updatedAt), name, phone, bio, timezone, locale, avatarURL)
+	if err != nil {
+		return models.User{}, err
+	}
+	return u, tx.Commit()
+}
+
+func (s *SQLiteUserRepository) Restore(ctx context.Context, id int) (models.User, error) {
+	return s.writeUser(ctx, s.db, "UPDATE users SET deleted_at = NULL WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NOT NULL AND erased_at IS NULL", id, tenant.ID(ctx))
+}
+
+func (s *SQLiteUserRepository) SetAvatarURL(ctx context.Context, id int, url string) (models.User, error) {
+	return s.writeUser(ctx, s.db, "UPDATE users SET avatar_url = ?1 WHERE id = ?2 AND tenant_id = ?3 AND deleted_at IS NULL", url, id, tenant.ID(ctx))
+}
+
+func (s *SQLiteUserRepository) MarkEmailVerified(ctx context.Context, id int, email string) (models.User, error) {
+	return s.writeUser(ctx, s.db, `UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?5)
+		WHERE id = ?1 AND (email = ?2 OR email_index = ?4) AND tenant_id = ?3 AND deleted_at IS NULL`, id, email, tenant.ID(ctx), s.emailIndex(email), sqliteNow())
+}
+
+func (s *SQLiteUserRepository) RequestEmailChange(ctx context.Context, id int, email string) error {
+	taken, err := s.EmailExists(ctx, email)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+	res, err := s.db.ExecContext(ctx, `INSERT INTO email_changes (user_id, pending_email, requested_at)
+		SELECT id, ?3, ?4 FROM users WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL
+		ON CONFLICT (user_id) DO UPDATE SET pending_email = excluded.pending_email, requested_at = excluded.requested_at`, id, tenant.ID(ctx), s.pii.Encrypt(email), sqliteNow())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteUserRepository) ConfirmEmailChange(ctx context.Context, id int, email string) (models.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	// the pending address is encrypted afresh each time, so it is compared once opened
+	var pending string
+	err = tx.QueryRowContext(ctx, `SELECT c.pending_email FROM email_changes c JOIN users u ON u.id = c.user_id
+		WHERE c.user_id = ?1 AND u.tenant_id = ?2 AND u.deleted_at IS NULL`, id, tenant.ID(ctx)).Scan(&pending)
+	if err == sql.ErrNoRows {
+		return models.User{}, ErrUserNotFound
+	} else if err != nil {
+		return models.User{}, err
+	}
+	if pending, err = s.pii.Decrypt(pending); err != nil {
+		return models.User{}, err
+	}
+	if !strings.EqualFold(pending, email) {
+		return models.User{}, ErrUserNotFound
+	}
+
+	now := sqliteNow()
+	sealed, _ := s.encrypted(models.User{Email: pending})
+	u, err := s.writeUser(ctx, tx, `UPDATE users SET email = ?1, email_index = ?2, email_verified_at = ?5, tokens_valid_after = ?5
+		WHERE id = ?3 AND tenant_id = ?4`, sealed, s.emailIndex(pending), id, tenant.ID(ctx), now)
+	if err != nil {
+		return models.User{}, sqliteTakenError(err)
+	}
+	for _, q := range []string{
+		"DELETE FROM email_changes WHERE user_id = ?1",
+		"UPDATE sessions SET revoked_at = ?2 WHERE user_id = ?1 AND revoked_at IS NULL",
+	} {
+		if _, err := tx.ExecContext(ctx, q, id, now); err != nil {
+			return models.User{}, err
+		}
+	}
+	return u, tx.Commit()
+}
+
+func (s *SQLiteUserRepository) PasswordHash(ctx context.Context, id int) (string, error) {
+	var hash string
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(password_hash, '') FROM users WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL", id, tenant.ID(ctx)).Scan(&hash)
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	}
+	return hash, err
+}
+
+func (s *SQLiteUserRepository) SetPasswordHash(ctx context.Context, id int, hash string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE users SET password_hash = ?1 WHERE id = ?2 AND tenant_id = ?3", hash, id, tenant.ID(ctx))
+	return err
+}
+
+func (s *SQLiteUserRepository) TokensValidAfter(ctx context.Context, id int) (time.Time, error) {
+	var after sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT tokens_valid_after FROM users WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL", id, tenant.ID(ctx)).Scan(&after)
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	}
+	return after.Time, err
+}
+
+func (s *SQLiteUserRepository) CreatePasswordReset(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO password_resets (user_id, token_hash, expires_at)
+		SELECT id, ?2, ?3 FROM users WHERE id = ?1 AND tenant_id = ?4`, userID, tokenHash, sqliteTime(expiresAt), tenant.ID(ctx))
+	return err
+}
+
+func (s *SQLiteUserRepository) PasswordResetEmail(ctx context.Context, tokenHash []byte) (string, error) {
+	var email string
+	err := s.db.QueryRowContext(ctx, `SELECT u.email FROM password_resets r JOIN users u ON u.id = r.user_id
+		WHERE r.token_hash = ?1 AND r.used_at IS NULL AND r.expires_at > ?3 AND u.tenant_id = ?2 AND u.deleted_at IS NULL`, tokenHash, tenant.ID(ctx), sqliteNow()).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidResetToken
+	} else if err != nil {
+		return "", err
+	}
+	return s.pii.Decrypt(email)
+}
+
+func (s *SQLiteUserRepository) ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteNow()
+	var userID int
+	err = tx.QueryRowContext(ctx, `UPDATE password_resets SET used_at = ?3
+		WHERE token_hash = ?1 AND used_at IS NULL AND expires_at > ?3
+			AND user_id IN (SELECT id FROM users WHERE tenant_id = ?2) RETURNING user_id`, tokenHash, tenant.ID(ctx), now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidResetToken
+	} else if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE users SET password_hash = ?1, tokens_valid_after = ?3 WHERE id = ?2 AND deleted_at IS NULL", passwordHash, userID, now)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, ErrInvalidResetToken
+	}
+	// any other links the user asked for are spent too
+	if _, err := tx.ExecContext(ctx, "UPDATE password_resets SET used_at = ?2 WHERE user_id = ?1 AND used_at IS NULL", userID, now); err != nil {
+		return 0, err
+	}
+	// and every session has to log in again with the new password
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = ?2 WHERE user_id = ?1 AND revoked_at IS NULL", userID, now); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+func (s *SQLiteUserRepository) CreateSession(ctx context.Context, userID int, client models.SessionClient, refreshTokenHash []byte, expiresAt time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteNow()
+	var sessionID int
+	err = tx.QueryRowContext(ctx, `INSERT INTO sessions (user_id, user_agent, ip, created_at, last_seen_at)
+		SELECT id, ?2, ?3, ?5, ?5 FROM users WHERE id = ?1 AND tenant_id = ?4 RETURNING id`, userID, client.UserAgent, client.IP, tenant.ID(ctx), now).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (session_id, user_id, token_hash, expires_at) VALUES (?1, ?2, ?3, ?4)", sessionID, userID, refreshTokenHash, sqliteTime(expiresAt)); err != nil {
+		return 0, err
+	}
+	return sessionID, tx.Commit()
+}
+
+func (s *SQLiteUserRepository) RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash []byte, expiresAt time.Time, client models.SessionClient) (int, int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteNow()
+	var (
+		sessionID, userID   int
+		used, revoked, live bool
+	)
+	err = tx.QueryRowContext(ctx, `SELECT refresh_tokens.session_id, refresh_tokens.user_id,
+			refresh_tokens.used_at IS NOT NULL, refresh_tokens.revoked_at IS NOT NULL OR sessions.revoked_at IS NOT NULL,
+			refresh_tokens.expires_at > ?3 AND users.deleted_at IS NULL
+		FROM refresh_tokens
+		JOIN sessions ON sessions.id = refresh_tokens.session_id
+		JOIN users ON users.id = refresh_tokens.user_id
+		WHERE refresh_tokens.token_hash = ?1 AND users.tenant_id = ?2`, tokenHash, tenant.ID(ctx), now).Scan(&sessionID, &userID, &used, &revoked, &live)
+	if err == sql.ErrNoRows {
+		return 0, 0, ErrInvalidRefreshToken
+	} else if err != nil {
+		return 0, 0, err
+	}
+	if revoked || !live {
+		return 0, 0, ErrInvalidRefreshToken
+	}
+	if used {
+		// someone is replaying a rotated token: whoever holds the session's current token can't be trusted either
+		if _, err := tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = ?2 WHERE id = ?1", sessionID, now); err != nil {
+			return 0, 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, 0, err
+		}
+		return 0, 0, ErrRefreshTokenReused
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = ?2 WHERE token_hash = ?1", tokenHash, now); err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (session_id, user_id, token_hash, expires_at) VALUES (?1, ?2, ?3, ?4)", sessionID, userID, newTokenHash, sqliteTime(expiresAt)); err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET last_seen_at = ?4, user_agent = ?1, ip = ?2 WHERE id = ?3", client.UserAgent, client.IP, sessionID, now); err != nil {
+		return 0, 0, err
+	}
+	return userID, sessionID, tx.Commit()
+}
+
+func (s *SQLiteUserRepository) RevokeRefreshToken(ctx context.Context, tokenHash []byte) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE sessions SET revoked_at = ?3
+		WHERE id = (SELECT session_id FROM refresh_tokens WHERE token_hash = ?1) AND revoked_at IS NULL
+			AND user_id IN (SELECT id FROM users WHERE tenant_id = ?2)`, tokenHash, tenant.ID(ctx), sqliteNow())
+	return err
+}
+
+func (s *SQLiteUserRepository) Sessions(ctx context.Context, userID int) ([]models.Session, error) {
+	// a session whose refresh tokens have all expired is over, even if nobody logged out
+	rows, err := s.db.QueryContext(ctx, `SELECT id, user_agent, ip, created_at, last_seen_at FROM sessions
+		WHERE user_id = ?1 AND revoked_at IS NULL AND user_id IN (SELECT id FROM users WHERE tenant_id = ?2)
+			AND EXISTS (SELECT 1 FROM refresh_tokens WHERE session_id = sessions.id AND used_at IS NULL AND expires_at > ?3)
+		ORDER BY last_seen_at DESC, id DESC`, userID, tenant.ID(ctx), sqliteNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []models.Session{}
+	for rows.Next() {
+		var sess models.Session
+		if err := rows.Scan(&sess.Id, &sess.UserAgent, &sess.IP, &sess.CreatedAt, &sess.LastSeenAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *SQLiteUserRepository) RecordLogin(ctx context.Context, e models.LoginEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := sqliteNow()
+	if _, err := tx.ExecContext(ctx, `INSERT INTO login_events (user_id, email, method, success, failure_reason, ip, user_agent, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)`, e.UserId, e.Email, e.Method, e.Success, e.FailureReason, e.IP, e.UserAgent, now); err != nil {
+		return err
+	}
+	if e.Success && e.UserId != nil {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET last_login_at = ?3, failed_logins = 0 WHERE id = ?1 AND tenant_id = ?2", *e.UserId, tenant.ID(ctx), now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteUserRepository) RecordFailedLogin(ctx context.Context, id, threshold int, lockout time.Duration) (*time.Time, error) {
+	until := time.Now().Add(lockout).UTC().Truncate(time.Millisecond)
+	var locked bool
+	err := s.db.QueryRowContext(ctx, `UPDATE users SET
+			failed_logins = CASE WHEN failed_logins + 1 >= ?2 THEN 0 ELSE failed_logins + 1 END,
+			locked_until = CASE WHEN failed_logins + 1 >= ?2 THEN ?3 END
+		WHERE id = ?1 AND tenant_id = ?4 AND deleted_at IS NULL RETURNING locked_until IS NOT NULL`, id, threshold, sqliteTime(until), tenant.ID(ctx)).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	} else if err != nil || !locked {
+		return nil, err
+	}
+	return &until, nil
+}
+
+func (s *SQLiteUserRepository) LockedUntil(ctx context.Context, id int) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := s.db.QueryRowContext(ctx, "SELECT locked_until FROM users WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL", id, tenant.ID(ctx)).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	// a lockout that has ended is left in place until the next one
+	if lockedUntil != nil && !lockedUntil.After(time.Now()) {
+		lockedUntil = nil
+	}
+	return lockedUntil, err
+}
+
+func (s *SQLiteUserRepository) Unlock(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL", id, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteUserRepository) UserByIdentity(ctx context.Context, provider, subject string) (models.User, error) {
+	return s.scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+` FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE tenant_id = ?3 AND provider = ?1 AND subject = ?2)
+			AND tenant_id = ?3 AND deleted_at IS NULL`, provider, subject, tenant.ID(ctx)))
+}
+
+func (s *SQLiteUserRepository) LinkIdentity(ctx context.Context, userID int, provider, subject, email string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO user_identities (tenant_id, provider, subject, user_id, email)
+		SELECT tenant_id, ?1, ?2, id, ?4 FROM users WHERE id = ?3 AND tenant_id = ?5
+		ON CONFLICT (tenant_id, provider, subject) DO NOTHING`, provider, subject, userID, email, tenant.ID(ctx))
+	return err
+}
+
+func (s *SQLiteUserRepository) Logins(ctx context.Context, userID, limit, offset int) ([]models.LoginEvent, int, error) {
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM login_events WHERE user_id = ?1 AND user_id IN (SELECT id FROM users WHERE tenant_id = ?2)", userID, tenant.ID(ctx)).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT id, user_id, email, method, success, failure_reason, ip, user_agent, created_at
+		FROM login_events WHERE user_id = ?1 AND user_id IN (SELECT id FROM users WHERE tenant_id = ?4)
+		ORDER BY created_at DESC, id DESC LIMIT ?2 OFFSET ?3`, userID, limit, offset, tenant.ID(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	events := []models.LoginEvent{}
+	for rows.Next() {
+		var e models.LoginEvent
+		if err := rows.Scan(&e.Id, &e.UserId, &e.Email, &e.Method, &e.Success, &e.FailureReason, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
+
+func (s *SQLiteUserRepository) LastLoginAt(ctx context.Context, id int) (*time.Time, error) {
+	var at *time.Time
+	err := s.db.QueryRowContext(ctx, "SELECT last_login_at FROM users WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL", id, tenant.ID(ctx)).Scan(&at)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	return at, err
+}
+
+func (s *SQLiteUserRepository) RevokeSession(ctx context.Context, userID, sessionID int) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE sessions SET revoked_at = ?4 WHERE id = ?1 AND user_id = ?2 AND revoked_at IS NULL
+		AND user_id IN (SELECT id FROM users WHERE tenant_id = ?3)`, sessionID, userID, tenant.ID(ctx), sqliteNow())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteUserRepository) SessionRevoked(ctx context.Context, sessionID int) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, "SELECT revoked_at IS NOT NULL FROM sessions WHERE id = ?1", sessionID).Scan(&revoked)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return revoked, err
+}
+
+func (s *SQLiteUserRepository) StartImpersonation(ctx context.Context, adminID, userID int, reason string, expiresAt time.Time) (models.Impersonation, error) {
+	i, err := scanImpersonation(s.db.QueryRowContext(ctx, `INSERT INTO impersonations (tenant_id, admin_id, user_id, reason, started_at, expires_at)
+		SELECT ?1, ?2, id, ?4, ?6, ?5 FROM users WHERE id = ?3 AND tenant_id = ?1 AND deleted_at IS NULL
+		RETURNING `+impersonationColumns, tenant.ID(ctx), adminID, userID, reason, sqliteTime(expiresAt), sqliteNow()))
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	}
+	return i, err
+}
+
+func (s *SQLiteUserRepository) EndImpersonation(ctx context.Context, id int) (models.Impersonation, error) {
+	i, err := scanImpersonation(s.db.QueryRowContext(ctx, `UPDATE impersonations SET ended_at = ?3
+		WHERE id = ?1 AND tenant_id = ?2 AND ended_at IS NULL AND expires_at > ?3 RETURNING `+impersonationColumns, id, tenant.ID(ctx), sqliteNow()))
+	if err == sql.ErrNoRows {
+		err = ErrImpersonationNotFound
+	}
+	return i, err
+}
+
+func (s *SQLiteUserRepository) ImpersonationEnded(ctx context.Context, id int) (bool, error) {
+	var ended bool
+	err := s.db.QueryRowContext(ctx, "SELECT ended_at IS NOT NULL OR expires_at <= ?2 FROM impersonations WHERE id = ?1", id, sqliteNow()).Scan(&ended)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return ended, err
+}
+
+func (s *SQLiteUserRepository) NotificationPreferences(ctx context.Context, id int) (models.NotificationPreferences, error) {
+	defaults := models.DefaultNotificationPreferences
+	var p models.NotificationPreferences
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(p.profile_changes, ?2), COALESCE(p.product_updates, ?3)
+		FROM users u LEFT JOIN notification_preferences p ON p.user_id = u.id
+		WHERE u.id = ?1 AND u.tenant_id = ?4 AND u.deleted_at IS NULL`, id, defaults.ProfileChanges, defaults.ProductUpdates, tenant.ID(ctx)).Scan(&p.ProfileChanges, &p.ProductUpdates)
+	if err == sql.ErrNoRows {
+		return models.NotificationPreferences{}, ErrUserNotFound
+	}
+	return p, err
+}
+
+func (s *SQLiteUserRepository) SetNotificationPreferences(ctx context.Context, id int, p models.NotificationPreferences) error {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO notification_preferences (user_id, profile_changes, product_updates, updated_at)
+		SELECT id, ?2, ?3, ?5 FROM users WHERE id = ?1 AND tenant_id = ?4 AND deleted_at IS NULL
+		ON CONFLICT (user_id) DO UPDATE SET profile_changes = excluded.profile_changes,
+			product_updates = excluded.product_updates, updated_at = excluded.updated_at`, id, p.ProfileChanges, p.ProductUpdates, tenant.ID(ctx), sqliteNow())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteUserRepository) Settings(ctx context.Context, id int) (models.Settings, error) {
+	return scanSettings(s.db.QueryRowContext(ctx, "SELECT settings FROM users WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL", id, tenant.ID(ctx)))
+}
+
+func (s *SQLiteUserRepository) UpdateSettings(ctx context.Context, id int, fn func(models.Settings) (models.Settings, error)) (models.Settings, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	current, err := scanSettings(tx.QueryRowContext(ctx, "SELECT settings FROM users WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL", id, tenant.ID(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	settings, err := fn(current)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET settings = ?1 WHERE id = ?2", string(raw), id); err != nil {
+		return nil, err
+	}
+	return settings, tx.Commit()
+}
+
+func (s *SQLiteUserRepository) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET totp_secret = ?1, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = ?2 AND tenant_id = ?3 AND deleted_at IS NULL", secret, id, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteUserRepository) TOTP(ctx context.Context, id int) (string, bool, error) {
+	var secret sql.NullString
+	var enabled bool
+	err := s.db.QueryRowContext(ctx, "SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL", id, tenant.ID(ctx)).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	}
+	return secret.String, enabled, err
+}
+
+func (s *SQLiteUserRepository) UseTOTPStep(ctx context.Context, id int, step int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET totp_last_step = ?1 WHERE id = ?2 AND tenant_id = ?3 AND (totp_last_step IS NULL OR totp_last_step < ?1)", step, id, tenant.ID(ctx))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteUserRepository) EnableTOTP(ctx context.Context, id int, backupCodeHashes [][]byte) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_enabled_at = COALESCE(totp_enabled_at, ?3) WHERE id = ?1 AND tenant_id = ?2", id, tenant.ID(ctx), sqliteNow()); err != nil {
+		return err
+	}
+	if err := sqliteReplaceBackupCodes(ctx, tx, id, backupCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteUserRepository) ReplaceBackupCodes(ctx context.Context, id int, codeHashes [][]byte) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := sqliteReplaceBackupCodes(ctx, tx, id, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func sqliteReplaceBackupCodes(ctx context.Context, tx *sql.Tx, id int, codeHashes [][]byte) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM totp_backup_codes WHERE user_id = ?1 AND user_id IN (SELECT id FROM users WHERE tenant_id = ?2)", id, tenant.ID(ctx)); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO totp_backup_codes (user_id, code_hash) SELECT id, ?2 FROM users WHERE id = ?1 AND tenant_id = ?3", id, h, tenant.ID(ctx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteUserRepository) UseBackupCode(ctx context.Context, id int, codeHash []byte) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE totp_backup_codes SET used_at = ?4 WHERE user_id = ?1 AND code_hash = ?2 AND used_at IS NULL
+		AND user_id IN (SELECT id FROM users WHERE tenant_id = ?3)`, id, codeHash, tenant.ID(ctx), sqliteNow())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteUserRepository) DisableTOTP(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = ?1 AND tenant_id = ?2", id, tenant.ID(ctx)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM totp_backup_codes WHERE user_id = ?1 AND user_id IN (SELECT id FROM users WHERE tenant_id = ?2)", id, tenant.ID(ctx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteUserRepository) Revisions(ctx context.Context, userID int) ([]models.UserRevision, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT rev, created_at, snapshot FROM user_revisions
+		WHERE user_id = ?1 AND user_id IN (SELECT id FROM users WHERE tenant_id = ?2) ORDER BY rev`, userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []models.UserRevision{}
+	for rows.Next() {
+		rev, err := s.scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *SQLiteUserRepository) Revision(ctx context.Context, userID, rev int) (models.UserRevision, error) {
+	r, err := s.scanRevision(s.db.QueryRowContext(ctx, `SELECT rev, created_at, snapshot FROM user_revisions
+		WHERE user_id = ?1 AND rev = ?2 AND user_id IN (SELECT id FROM users WHERE tenant_id = ?3)`, userID, rev, tenant.ID(ctx)))
+	if err == sql.ErrNoRows {
+		err = ErrRevisionNotFound
+	}
+	return r, err
+}
+
+func (s *SQLiteUserRepository) CreateAPIKey(ctx context.Context, userID int, name, prefix string, keyHash []byte) (models.APIKey, error) {
+	k, err := scanAPIKey(s.db.QueryRowContext(ctx, `INSERT INTO api_keys (user_id, name, prefix, key_hash, created_at)
+		SELECT id, ?2, ?3, ?4, ?6 FROM users WHERE id = ?1 AND tenant_id = ?5 AND deleted_at IS NULL RETURNING `+apiKeyColumns, userID, name, prefix, keyHash, tenant.ID(ctx), sqliteNow()))
+	if err == sql.ErrNoRows {
+		err = ErrUserNotFound
+	}
+	return k, err
+}
+
+func (s *SQLiteUserRepository) APIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+` FROM api_keys
+		WHERE user_id = ?1 AND revoked_at IS NULL AND user_id IN (SELECT id FROM users WHERE tenant_id = ?2)
+		ORDER BY created_at DESC, id DESC`, userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []models.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLiteUserRepository) RevokeAPIKey(ctx context.Context, userID, keyID int) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = ?4 WHERE id = ?1 AND user_id = ?2 AND revoked_at IS NULL
+		AND user_id IN (SELECT id FROM users WHERE tenant_id = ?3)`, keyID, userID, tenant.ID(ctx), sqliteNow())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// the key says which tenant its user is in, so unlike every other lookup this one isn't scoped
+func (s *SQLiteUserRepository) APIKeyUser(ctx context.Context, keyHash []byte) (int, int, error) {
+	var userID, tenantID int
+	err := s.db.QueryRowContext(ctx, `UPDATE api_keys SET last_used_at = ?2
+		WHERE key_hash = ?1 AND revoked_at IS NULL AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+		RETURNING user_id, (SELECT tenant_id FROM users WHERE id = api_keys.user_id)`, keyHash, sqliteNow()).Scan(&userID, &tenantID)
+	if err == sql.ErrNoRows {
+		err = ErrAPIKeyNotFound
+	}
+	return userID, tenantID, err
+}
+
+func (s *SQLiteUserRepository) HasPermission(ctx context.Context, userID int, permission string) (bool, error) {
+	var granted bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users JOIN role_permissions ON role_permissions.role = users.role
+		WHERE users.id = ?1 AND users.tenant_id = ?3 AND users.deleted_at IS NULL AND role_permissions.permission = ?2)`, userID, permission, tenant.ID(ctx)).Scan(&granted)
+	return granted, err
+}
+
+// there are no arrays, so each role's permissions come as a JSON array
+func (s *SQLiteUserRepository) Roles(ctx context.Context) ([]models.Role, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT roles.name, (SELECT json_group_array(permission) FROM
+			(SELECT permission FROM role_permissions WHERE role = roles.name ORDER BY permission))
+		FROM roles ORDER BY roles.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []models.Role{}
+	for rows.Next() {
+		var r models.Role
+		var permissions []byte
+		if err := rows.Scan(&r.Name, &permissions); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(permissions, &r.Permissions); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+func (s *SQLiteUserRepository) SetRole(ctx context.Context, id int, role string) (models.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM roles WHERE name = ?1)", role).Scan(&exists); err != nil {
+		return models.User{}, err
+	}
+	if !exists {
+		return models.User{}, ErrUnknownRole
+	}
+
+	// the transaction holds the write lock, so two concurrent demotions can't both see another admin left
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM users WHERE role = ?1 AND tenant_id = ?2 AND deleted_at IS NULL", models.RoleAdmin, tenant.ID(ctx))
+	if err != nil {
+		return models.User{}, err
+	}
+	var admins []int
+	for rows.Next() {
+		var admin int
+		if err := rows.Scan(&admin); err != nil {
+			rows.Close()
+			return models.User{}, err
+		}
+		admins = append(admins, admin)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return models.User{}, err
+	}
+	if role != models.RoleAdmin && len(admins) == 1 && admins[0] == id {
+		return models.User{}, ErrLastAdmin
+	}
+
+	u, err := s.writeUser(ctx, tx, "UPDATE users SET role = ?1 WHERE id = ?2 AND tenant_id = ?3 AND deleted_at IS NULL", role, id, tenant.ID(ctx))
+	if err != nil {
+		return models.User{}, err
+	}
+	return u, tx.Commit()
+}
+
+func (s *SQLiteUserRepository) Search(ctx context.Context, term string, limit, offset int) ([]models.SearchResult, error) {
+	return s.search(ctx, limit, offset, wordMatch(term))
+}
+
+func (s *SQLiteUserRepository) FuzzySearch(ctx context.Context, term string, threshold float64, limit, offset int) ([]models.SearchResult, error) {
+	return s.search(ctx, limit, offset, substringMatch(term))
+}
+
+// live users of the tenant that match, in id order, read whole as encrypted columns can only be
+// matched once opened
+func (s *SQLiteUserRepository) search(ctx context.Context, limit, offset int, match func(models.User) bool) ([]models.SearchResult, error) {
+	results := []models.SearchResult{}
+	err := s.Each(ctx, models.UserFilter{}, func(u models.User) error {
+		if match(u) {
+			results = append(results, models.SearchResult{User: u, Rank: 1})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page(results, limit, offset), nil
+}
+
+func (s *SQLiteUserRepository) Tags(ctx context.Context) ([]models.Tag, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT t.name, COUNT(*) FROM tags t
+		JOIN user_tags ut ON ut.tag_id = t.id JOIN users u ON u.id = ut.user_id AND u.deleted_at IS NULL
+		WHERE t.tenant_id = ?1 GROUP BY t.name ORDER BY t.name`, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []models.Tag{}
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.Name, &t.Users); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func (s *SQLiteUserRepository) UserTags(ctx context.Context, userID int) ([]string, error) {
+	return sqliteUserTags(ctx, s.db, userID)
+}
+
+func sqliteUserTags(ctx context.Context, q querier, userID int) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT t.name FROM user_tags ut JOIN tags t ON t.id = ut.tag_id
+		WHERE ut.user_id = ?1 AND t.tenant_id = ?2 ORDER BY t.name`, userID, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+	return tags, rows.Err()
+}
+
+func (s *SQLiteUserRepository) TagUser(ctx context.Context, userID int, tag string) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var live bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = ?1 AND tenant_id = ?2 AND deleted_at IS NULL)", userID, tenant.ID(ctx)).Scan(&live); err != nil {
+		return nil, err
+	}
+	if !live {
+		return nil, ErrUserNotFound
+	}
+	now := sqliteNow()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO tags (tenant_id, name, created_at) VALUES (?1, ?2, ?3) ON CONFLICT (tenant_id, name) DO NOTHING", tenant.ID(ctx), tag, now); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO user_tags (user_id, tag_id, created_at)
+		SELECT ?1, id, ?4 FROM tags WHERE tenant_id = ?2 AND name = ?3 ON CONFLICT DO NOTHING`, userID, tenant.ID(ctx), tag, now); err != nil {
+		return nil, err
+	}
+	tags, err := sqliteUserTags(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return tags, tx.Commit()
+}
+
+func (s *SQLiteUserRepository) UntagUser(ctx context.Context, userID int, tag string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM user_tags WHERE user_id = ?1
+		AND tag_id IN (SELECT id FROM tags WHERE tenant_id = ?2 AND name = ?3)`, userID, tenant.ID(ctx), tag)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
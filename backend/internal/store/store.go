@@ -0,0 +1,339 @@
+// Package store persists users behind the UserRepository interfaces, with a Postgres implementation.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"api/internal/models"
+)
+
+// ErrUserNotFound is returned by UserStore lookups and writes when no matching user exists.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrEmailTaken is returned by Create, CreateMany and Update when another user already has the email.
+var ErrEmailTaken = errors.New("email already in use")
+
+// ErrUsernameTaken is returned by Create, CreateMany and Update when another user already has the
+// username they were given.
+var ErrUsernameTaken = errors.New("username already in use")
+
+// ErrVersionConflict is returned by Update when the user has changed since the expected version.
+var ErrVersionConflict = errors.New("user has been modified")
+
+// ErrInvalidResetToken is returned by ResetPassword for unknown, used or expired tokens.
+var ErrInvalidResetToken = errors.New("password reset token is invalid or expired")
+
+// ErrInvalidRefreshToken is returned by RotateRefreshToken for unknown, expired or revoked tokens.
+var ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+
+// ErrRefreshTokenReused is returned by RotateRefreshToken when a token that was already rotated is
+// presented again; the token's session has been revoked.
+var ErrRefreshTokenReused = errors.New("refresh token was already used")
+
+// ErrSessionNotFound is returned by RevokeSession when the user has no such active session.
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrRevisionNotFound is returned by Revision when the user has no such revision.
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// ErrAPIKeyNotFound is returned for API keys that are unknown, revoked or belong to a deleted user.
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// ErrMergeSourceNotFound is returned by Merge when the user to merge in is missing or deleted.
+var ErrMergeSourceNotFound = errors.New("merge source not found")
+
+// ErrUnknownRole is returned by SetRole for a role that doesn't exist.
+var ErrUnknownRole = errors.New("unknown role")
+
+// ErrLastAdmin is returned by SetRole when it would leave the tenant with no live admin.
+var ErrLastAdmin = errors.New("cannot demote the last admin")
+
+// ErrWebhookNotFound is returned by Webhooks lookups and deletes when there is no such webhook.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// ErrJobNotFound is returned by Jobs lookups when there is no such job.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrTenantNotFound is returned by Tenants lookups when there is no such tenant.
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// ErrFlagNotFound is returned by FeatureFlags lookups and writes when there is no such flag.
+var ErrFlagNotFound = errors.New("feature flag not found")
+
+// ErrImpersonationNotFound is returned by EndImpersonation when there is no such impersonation under way.
+var ErrImpersonationNotFound = errors.New("impersonation not found")
+
+// ErrFlagExists is returned by FeatureFlags.Create when the tenant already has a flag with the key.
+var ErrFlagExists = errors.New("feature flag already exists")
+
+// ErrOrgNotFound is returned by Organizations lookups and writes when there is no such organization.
+var ErrOrgNotFound = errors.New("organization not found")
+
+// ErrMemberNotFound is returned by Organizations writes to a membership the user doesn't have.
+var ErrMemberNotFound = errors.New("member not found")
+
+// ErrAlreadyMember is returned by AddMember for a user who is already a member.
+var ErrAlreadyMember = errors.New("user is already a member")
+
+// ErrLastOwner is returned by SetMemberRole and RemoveMember when the organization would be left
+// without an owner.
+var ErrLastOwner = errors.New("cannot remove the last owner")
+
+// ErrInvalidInvitation is returned for invitation tokens that are unknown, used or expired.
+var ErrInvalidInvitation = errors.New("invitation is invalid or expired")
+
+// ErrPostNotFound is returned by Posts lookups and writes when there is no such post.
+var ErrPostNotFound = errors.New("post not found")
+
+// ErrCommentNotFound is returned by Posts comment lookups and writes when the post has no such comment.
+var ErrCommentNotFound = errors.New("comment not found")
+
+// ErrTagNotFound is returned by UntagUser when the user doesn't have the tag.
+var ErrTagNotFound = errors.New("tag not found")
+
+// the stores below make up what is kept about users, split by concern so each consumer depends on
+// only the methods it calls. everything is scoped to the tenant on the context, as set by
+// tenant.WithID; users of other tenants are reported as not found
+
+// UserRepository is every user store at once, as the Postgres implementation and the wrappers
+// around it, such as CachedUserRepository, provide them.
+type UserRepository interface {
+	UserStore
+	SearchStore
+	PasswordStore
+	SessionStore
+	LoginStore
+	IdentityStore
+	ImpersonationStore
+	TwoFactorStore
+	PreferenceStore
+	RoleStore
+	RevisionStore
+	APIKeyStore
+	TagStore
+}
+
+// UserStore keeps the users themselves, shared by the REST, GraphQL and gRPC APIs. lookups and
+// single-user writes skip soft-deleted users and report ErrUserNotFound when nothing matches.
+type UserStore interface {
+	// one page of users matching f in the given order, along with the total number of matches
+	List(ctx context.Context, f models.UserFilter, sort models.UserSort, limit, offset int) ([]models.User, int, error)
+	// up to limit users matching f in (created_at, id) order, starting after the cursor when one is given
+	ListAfter(ctx context.Context, f models.UserFilter, after *models.UserCursor, desc bool, limit int) ([]models.User, error)
+	// up to limit changes after the cursor in (updated_at, id) order, as users and tombstones. a nil
+	// cursor starts from scratch, with live users only. changes of transactions that might still
+	// commit are held back for the next sync, so one resuming from Next never misses any
+	Changes(ctx context.Context, after *models.SyncCursor, limit int) (models.UserChanges, error)
+	// counts of live users in total, by verification and role, and created recently
+	Stats(ctx context.Context) (models.UserStats, error)
+	// users created per unit of time, one of models.SignupGroupings, in UTC buckets from the one
+	// holding from through the one holding to. buckets without signups are included with a count of 0
+	Signups(ctx context.Context, unit string, from, to time.Time, includeDeleted bool) ([]models.SignupBucket, error)
+	// call fn for every user matching f in id order, stopping at the first error
+	Each(ctx context.Context, f models.UserFilter, fn func(models.User) error) error
+	Get(ctx context.Context, id int, includeDeleted bool) (models.User, error)
+	// the live user with this email, compared case-insensitively, including its PasswordHash
+	GetByEmail(ctx context.Context, email string) (models.User, error)
+	// the live user with this username, given in lowercase
+	GetByUsername(ctx context.Context, username string) (models.User, error)
+	EmailExists(ctx context.Context, email string) (bool, error)
+	// the subset of emails, given in lowercase, that already belong to a user
+	ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error)
+	// insert u, storing u.PasswordHash when it is set, and fill in its generated id and created_at.
+	// without a Username, one is derived from the name, numbered when the name's is in use
+	Create(ctx context.Context, u models.User) (models.User, error)
+	// create u, or update the live user with its email as Update would, without a version check and
+	// keeping their username unless u has one; reports whether it created. ErrEmailTaken if a
+	// soft-deleted user has the email
+	Upsert(ctx context.Context, u models.User) (models.User, bool, error)
+	// insert every user in one transaction; either all are created or none are. users with a
+	// CreatedAt are created at that time rather than now
+	CreateMany(ctx context.Context, users []models.User) ([]models.User, error)
+	// overwrite a live user's name, email and profile fields, clearing the verification when the
+	// email changes. an empty Username keeps the user's current one
+	// unless version is 0, the user must still be at that version or ErrVersionConflict is returned
+	Update(ctx context.Context, id int, u models.User, version int) (models.User, error)
+	// soft delete a live user
+	Delete(ctx context.Context, id int) (models.User, error)
+	// soft delete every live user with one of ids and/or created before createdBefore, returning them
+	DeleteMatching(ctx context.Context, ids []int64, createdBefore *time.Time) ([]models.User, error)
+	// irreversibly replace a user's personal data, live or soft-deleted, with placeholders and drop what
+	// else identifies them: sessions, keys, logins, revisions, queued email, their exports and the
+	// snapshots in their audit entries. events recorded of them, and the webhook deliveries of those,
+	// take the placeholders in place of their details. the user is soft deleted but keeps its id, so their posts and memberships stay in place.
+	// ErrUserNotFound if it is missing or already erased
+	Erase(ctx context.Context, id int) (models.User, error)
+	// fold the live user sourceID into the live user id: posts, comments, sessions, API keys, linked
+	// identities, tags and memberships move over, keeping the higher of two roles in an organization,
+	// and each profile field is taken from whichever of the two was updated last, when set there.
+	// the target keeps its email, username and role; the source is soft deleted. ErrUserNotFound if
+	// the target is missing, ErrMergeSourceNotFound if the source is
+	Merge(ctx context.Context, id, sourceID int) (models.User, error)
+	// clear deleted_at on a soft-deleted user; ErrUserNotFound if it is missing, not deleted or erased
+	Restore(ctx context.Context, id int) (models.User, error)
+	// point a live user's avatar at url
+	SetAvatarURL(ctx context.Context, id int, url string) (models.User, error)
+	// record that a live user confirmed email, keeping the first confirmation time; ErrUserNotFound
+	// if the user is gone or their address has changed since
+	MarkEmailVerified(ctx context.Context, id int, email string) (models.User, error)
+	// keep email as the address a live user is moving to, replacing any earlier one, until
+	// ConfirmEmailChange makes it theirs; ErrUserNotFound if the user is missing, ErrEmailTaken if
+	// another user has the address
+	RequestEmailChange(ctx context.Context, id int, email string) error
+	// make email, the pending address of a live user, their verified email and end every session,
+	// as they may have been signed in under the old one; ErrUserNotFound if it is no longer pending,
+	// ErrEmailTaken if another user took the address meanwhile
+	ConfirmEmailChange(ctx context.Context, id int, email string) (models.User, error)
+}
+
+// SearchStore finds live users by what they are called.
+type SearchStore interface {
+	// live users matching a websearch-style query, best matches first
+	Search(ctx context.Context, term string, limit, offset int) ([]models.SearchResult, error)
+	// live users whose name or email is at least threshold similar to term, best matches first
+	FuzzySearch(ctx context.Context, term string, threshold float64, limit, offset int) ([]models.SearchResult, error)
+}
+
+// PasswordStore keeps users' password hashes and the tokens that reset them.
+type PasswordStore interface {
+	// a live user's password hash, empty when none is set
+	PasswordHash(ctx context.Context, id int) (string, error)
+	SetPasswordHash(ctx context.Context, id int, hash string) error
+	// record a password reset token, stored only as its hash, for a live user
+	CreatePasswordReset(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error
+	// the email of the live user an unspent, unexpired reset token is for, without spending it;
+	// ErrInvalidResetToken otherwise
+	PasswordResetEmail(ctx context.Context, tokenHash []byte) (string, error)
+	// spend the reset token with this hash and every other outstanding one for its user, set their
+	// password and revoke their access and refresh tokens, all in one transaction; returns the user's id
+	ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string) (int, error)
+}
+
+// SessionStore keeps the sessions users sign in to and what revokes their tokens.
+type SessionStore interface {
+	// the moment before which a live user's access tokens are revoked, zero when none are
+	TokensValidAfter(ctx context.Context, id int) (time.Time, error)
+	// start a session for a user with its first refresh token, stored only as a hash; returns the session id
+	CreateSession(ctx context.Context, userID int, client models.SessionClient, refreshTokenHash []byte, expiresAt time.Time) (int, error)
+	// spend a refresh token, replacing it with newTokenHash in the same session, and return the user and
+	// session ids. presenting a spent token revokes its session and reports ErrRefreshTokenReused
+	RotateRefreshToken(ctx context.Context, tokenHash, newTokenHash []byte, expiresAt time.Time, client models.SessionClient) (int, int, error)
+	// revoke the session of the refresh token with this hash; unknown tokens are ignored
+	RevokeRefreshToken(ctx context.Context, tokenHash []byte) error
+	// a user's active sessions, most recently seen first
+	Sessions(ctx context.Context, userID int) ([]models.Session, error)
+	// revoke one of a user's sessions; ErrSessionNotFound if they have no such active session
+	RevokeSession(ctx context.Context, userID, sessionID int) error
+	// whether a session has been revoked; sessions that don't exist count as revoked
+	SessionRevoked(ctx context.Context, sessionID int) (bool, error)
+}
+
+// LoginStore keeps the history of login attempts and the lockouts failed ones lead to.
+type LoginStore interface {
+	// record a login attempt; a successful one also becomes its user's last login
+	RecordLogin(ctx context.Context, e models.LoginEvent) error
+	// count a failed login against a live user. reaching threshold failures in a row locks them out
+	// for lockout and starts the count again; returns when the lockout ends, nil when none began
+	RecordFailedLogin(ctx context.Context, id, threshold int, lockout time.Duration) (*time.Time, error)
+	// when a live user's lockout ends, nil if they aren't locked out
+	LockedUntil(ctx context.Context, id int) (*time.Time, error)
+	// lift a live user's lockout and forget their failed logins
+	Unlock(ctx context.Context, id int) error
+	// one page of a user's login attempts, newest first, along with their total number
+	Logins(ctx context.Context, userID, limit, offset int) ([]models.LoginEvent, int, error)
+	// when a live user last logged in, nil if they never have
+	LastLoginAt(ctx context.Context, id int) (*time.Time, error)
+}
+
+// IdentityStore links users to their accounts at OAuth providers.
+type IdentityStore interface {
+	// the live user an OAuth provider's account is linked to; ErrUserNotFound if it isn't linked
+	UserByIdentity(ctx context.Context, provider, subject string) (models.User, error)
+	// link a provider's account, which knows the user as email, so it signs in as them
+	LinkIdentity(ctx context.Context, userID int, provider, subject, email string) error
+}
+
+// ImpersonationStore keeps the record of admins acting as other users.
+type ImpersonationStore interface {
+	// record an admin starting to impersonate a live user until expiresAt
+	StartImpersonation(ctx context.Context, adminID, userID int, reason string, expiresAt time.Time) (models.Impersonation, error)
+	// end an impersonation under way, so its token is no longer accepted; ErrImpersonationNotFound
+	// if there is none with the id or it has already ended
+	EndImpersonation(ctx context.Context, id int) (models.Impersonation, error)
+	// whether an impersonation has ended or expired; unknown ones have
+	ImpersonationEnded(ctx context.Context, id int) (bool, error)
+}
+
+// TwoFactorStore keeps users' TOTP secrets and backup codes.
+type TwoFactorStore interface {
+	// store a new, not yet enabled TOTP secret for a live user, replacing any earlier one
+	SetTOTPSecret(ctx context.Context, id int, secret string) error
+	// a live user's TOTP secret, empty when none is enrolled, and whether it is enabled
+	TOTP(ctx context.Context, id int) (secret string, enabled bool, err error)
+	// record that the TOTP code for time step was used; false if that step or a later one already was
+	UseTOTPStep(ctx context.Context, id int, step int64) (bool, error)
+	// turn on the enrolled TOTP secret and replace the user's backup codes, in one transaction
+	EnableTOTP(ctx context.Context, id int, backupCodeHashes [][]byte) error
+	ReplaceBackupCodes(ctx context.Context, id int, codeHashes [][]byte) error
+	// spend an unused backup code with this hash; false if there is none
+	UseBackupCode(ctx context.Context, id int, codeHash []byte) (bool, error)
+	// remove the TOTP secret and every backup code
+	DisableTOTP(ctx context.Context, id int) error
+}
+
+// PreferenceStore keeps what users chose about their notifications and user interface.
+type PreferenceStore interface {
+	// a live user's notification preferences, the defaults until they set their own
+	NotificationPreferences(ctx context.Context, id int) (models.NotificationPreferences, error)
+	SetNotificationPreferences(ctx context.Context, id int, p models.NotificationPreferences) error
+	// a live user's settings, empty until they save any
+	Settings(ctx context.Context, id int) (models.Settings, error)
+	// replace a live user's settings with what fn makes of the current ones, with the user locked in
+	// between so concurrent changes apply one after the other; an error from fn changes nothing
+	UpdateSettings(ctx context.Context, id int, fn func(models.Settings) (models.Settings, error)) (models.Settings, error)
+}
+
+// RoleStore keeps the roles users have and the permissions those grant.
+type RoleStore interface {
+	// whether a live user's role grants permission
+	HasPermission(ctx context.Context, userID int, permission string) (bool, error)
+	// every role with the permissions it grants, by name
+	Roles(ctx context.Context) ([]models.Role, error)
+	// assign a live user a role; ErrUnknownRole if there is no such role, ErrLastAdmin if it would leave the tenant no admin
+	SetRole(ctx context.Context, id int, role string) (models.User, error)
+}
+
+// RevisionStore keeps the history of changes to each user.
+type RevisionStore interface {
+	// every revision of a user, including a soft-deleted one, oldest first
+	Revisions(ctx context.Context, userID int) ([]models.UserRevision, error)
+	// one revision of a user; ErrRevisionNotFound if there is none
+	Revision(ctx context.Context, userID, rev int) (models.UserRevision, error)
+}
+
+// APIKeyStore keeps the keys machine clients act as users with.
+type APIKeyStore interface {
+	// record an API key for a live user, stored only as its hash
+	CreateAPIKey(ctx context.Context, userID int, name, prefix string, keyHash []byte) (models.APIKey, error)
+	// a user's unrevoked API keys, newest first
+	APIKeys(ctx context.Context, userID int) ([]models.APIKey, error)
+	// revoke one of a user's keys; ErrAPIKeyNotFound if they have no such unrevoked key
+	RevokeAPIKey(ctx context.Context, userID, keyID int) error
+	// the owner of the unrevoked key with this hash and the tenant they are in, whichever tenant is
+	// on the context, noting that the key was used
+	APIKeyUser(ctx context.Context, keyHash []byte) (userID, tenantID int, err error)
+}
+
+// TagStore keeps the tags users are labelled with.
+type TagStore interface {
+	// every tag some live user has, by name, with how many have it
+	Tags(ctx context.Context) ([]models.Tag, error)
+	// a user's tags, by name
+	UserTags(ctx context.Context, userID int) ([]string, error)
+	// give a live user a tag, creating it on first use, and return their tags; tagging twice changes nothing
+	TagUser(ctx context.Context, userID int, tag string) ([]string, error)
+	// take a tag off a user; ErrTagNotFound if they don't have it
+	UntagUser(ctx context.Context, userID int, tag string) error
+}
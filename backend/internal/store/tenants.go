@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Tenants resolves the tenants clients name in requests.
+type Tenants interface {
+	// the id of the tenant with the given slug; ErrTenantNotFound if there is none
+	TenantID(ctx context.Context, slug string) (int, error)
+}
+
+// PostgresTenants is a Tenants backed by the tenants table.
+type PostgresTenants struct {
+	db *sql.DB
+}
+
+var _ Tenants = (*PostgresTenants)(nil)
+
+func NewPostgresTenants(db *sql.DB) *PostgresTenants {
+	return &PostgresTenants{db: db}
+}
+
+func (t *PostgresTenants) TenantID(ctx context.Context, slug string) (int, error) {
+	var id int
+	err := t.db.QueryRowContext(ctx, "SELECT id FROM tenants WHERE slug = $1", slug).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = ErrTenantNotFound
+	}
+	return id, err
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"api/internal/models"
+	"api/internal/tenant"
+)
+
+// UserExports keeps the user exports of the tenant on the context, until they expire. their files
+// are kept elsewhere, under the key each export notes.
+type UserExports interface {
+	// start a pending export for the user asking for it
+	Create(ctx context.Context, requestedBy int, format string, expiresAt time.Time) (models.UserExport, error)
+	// note the job generating an export
+	SetJob(ctx context.Context, id int, jobID int64) error
+	// ErrExportNotFound unless the user asked for such an unexpired export
+	Get(ctx context.Context, requestedBy, id int) (models.UserExport, error)
+	// note how many of the total users a pending export has written
+	Progress(ctx context.Context, id, exported, total int) error
+	// the format and file key of a ready export; ErrExportNotFound unless there is such an export
+	Archive(ctx context.Context, id int) (string, string, error)
+	// note the key of the file written for a pending export, making it ready
+	Finish(ctx context.Context, id int, key string) error
+	// give up on a pending export
+	Fail(ctx context.Context, id int) error
+	// up to limit expired exports of any tenant, by id, with their file keys; "" for those without a file
+	Expired(ctx context.Context, limit int) (map[int]string, error)
+	// forget exports of any tenant, once their files are gone
+	Delete(ctx context.Context, ids []int) error
+}
+
+// PostgresUserExports keeps user exports in the user_exports table.
+type PostgresUserExports struct {
+	db *sql.DB
+}
+
+var _ UserExports = (*PostgresUserExports)(nil)
+
+func NewPostgresUserExports(db *sql.DB) *PostgresUserExports {
+	return &PostgresUserExports{db: db}
+}
+
+const userExportColumns = "id, requested_by, format, status, exported, total, created_at, finished_at, expires_at, job_id"
+
+func scanUserExport(row scanner) (models.UserExport, error) {
+	var e models.UserExport
+	err := row.Scan(&e.Id, &e.RequestedBy, &e.Format, &e.Status, &e.Exported, &e.Total, &e.CreatedAt, &e.FinishedAt, &e.ExpiresAt, &e.JobId)
+	if err == sql.ErrNoRows {
+		err = ErrExportNotFound
+	}
+	return e, err
+}
+
+func (s *PostgresUserExports) Create(ctx context.Context, requestedBy int, format string, expiresAt time.Time) (models.UserExport, error) {
+	e, err := scanUserExport(s.db.QueryRowContext(ctx, `INSERT INTO user_exports (tenant_id, requested_by, format, expires_at)
+		SELECT tenant_id, id, $3, $4 FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+		RETURNING `+userExportColumns, requestedBy, tenant.ID(ctx), format, expiresAt))
+	if err == ErrExportNotFound {
+		return models.UserExport{}, ErrUserNotFound
+	}
+	return e, err
+}
+
+func (s *PostgresUserExports) SetJob(ctx context.Context, id int, jobID int64) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE user_exports SET job_id = $1 WHERE id = $2 AND tenant_id = $3", jobID, id, tenant.ID(ctx))
+	return err
+}
+
+func (s *PostgresUserExports) Get(ctx context.Context, requestedBy, id int) (models.UserExport, error) {
+	return scanUserExport(s.db.QueryRowContext(ctx, "SELECT "+userExportColumns+` FROM user_exports
+		WHERE id = $1 AND requested_by = $2 AND tenant_id = $3 AND expires_at > now()`, id, requestedBy, tenant.ID(ctx)))
+}
+
+func (s *PostgresUserExports) Progress(ctx context.Context, id, exported, total int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE user_exports SET exported = $1, total = $2
+		WHERE id = $3 AND tenant_id = $4 AND status = 'pending'`, exported, total, id, tenant.ID(ctx))
+	return err
+}
+
+func (s *PostgresUserExports) Archive(ctx context.Context, id int) (string, string, error) {
+	var format, key string
+	err := s.db.QueryRowContext(ctx, `SELECT format, archive_key FROM user_exports
+		WHERE id = $1 AND tenant_id = $2 AND status = 'ready' AND expires_at > now()`, id, tenant.ID(ctx)).Scan(&format, &key)
+	if err == sql.ErrNoRows {
+		err = ErrExportNotFound
+	}
+	return format, key, err
+}
+
+func (s *PostgresUserExports) Finish(ctx context.Context, id int, key string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE user_exports SET status = 'ready', archive_key = $1, finished_at = now()
+		WHERE id = $2 AND tenant_id = $3 AND status = 'pending'`, key, id, tenant.ID(ctx))
+	return err
+}
+
+func (s *PostgresUserExports) Fail(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE user_exports SET status = 'failed', finished_at = now()
+		WHERE id = $1 AND tenant_id = $2 AND status = 'pending'`, id, tenant.ID(ctx))
+	return err
+}
+
+func (s *PostgresUserExports) Expired(ctx context.Context, limit int) (map[int]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, COALESCE(archive_key, '') FROM user_exports WHERE expires_at <= now() ORDER BY id LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	expired := map[int]string{}
+	for rows.Next() {
+		var id int
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			return nil, err
+		}
+		expired[id] = key
+	}
+	return expired, rows.Err()
+}
+
+func (s *PostgresUserExports) Delete(ctx context.Context, ids []int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM user_exports WHERE id = ANY ($1)", ids)
+	return err
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"api/internal/tenant"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// derived usernames are cut to this many characters, leaving room for a number within the 40 allowed
+const maxUsernameBase = 30
+
+// how many times Create derives a username again after a concurrent insert took the one it chose
+const usernameAttempts = 3
+
+// a name as a username: accents dropped, lowercased, and every run of anything other than a letter
+// or digit made a single dash. names with nothing left become "user"
+func usernameBase(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFKD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			dash = true
+		}
+	}
+	// only ASCII is left, so bytes are characters
+	base := b.String()
+	if len(base) > maxUsernameBase {
+		base = strings.TrimRight(base[:maxUsernameBase], "-")
+	}
+	if base == "" {
+		return "user"
+	}
+	return base
+}
+
+// the username derived from name, or the first of it numbered from 2 that nobody in the tenant has
+// and that isn't reserved
+func freeUsername(ctx context.Context, d dialect, q querier, name string, reserved ...string) (string, error) {
+	base := usernameBase(name)
+	query := &userQuery{dialect: d}
+	query.conds = append(query.conds, "tenant_id = "+query.bind(tenant.ID(ctx)))
+	b := query.bind(base)
+	query.conds = append(query.conds, "(username = "+b+" OR username LIKE "+b+" || '-%')")
+	rows, err := q.QueryContext(ctx, "SELECT username FROM users"+query.where(), query.args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	taken := map[int]bool{}
+	take := func(username string) {
+		if username == base {
+			taken[1] = true
+		} else if n, err := strconv.Atoi(strings.TrimPrefix(username, base+"-")); err == nil {
+			taken[n] = true
+		}
+	}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return "", err
+		}
+		take(username)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	for _, username := range reserved {
+		take(username)
+	}
+	if !taken[1] {
+		return base, nil
+	}
+	n := 2
+	for taken[n] {
+		n++
+	}
+	return base + "-" + strconv.Itoa(n), nil
+}
+
+// the usernames derived from names, as freeUsername would choose them one after another, read
+// with one query however many there are. reserved usernames are skipped too, such as those given
+// to other users of the same batch
+func freeUsernames(ctx context.Context, q querier, names, reserved []string) ([]string, error) {
+	taken := map[string]map[int]bool{} // base -> numbers in use, 1 for the base itself
+	bases := make([]string, len(names))
+	for i, name := range names {
+		bases[i] = usernameBase(name)
+		taken[bases[i]] = map[int]bool{}
+	}
+	take := func(username string) {
+		if numbers, ok := taken[username]; ok {
+			numbers[1] = true
+		}
+		if i := strings.LastIndexByte(username, '-'); i > 0 {
+			if n, err := strconv.Atoi(username[i+1:]); err == nil {
+				if numbers, ok := taken[username[:i]]; ok {
+					numbers[n] = true
+				}
+			}
+		}
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT username FROM users WHERE tenant_id = $1
+		AND (username = ANY($2) OR regexp_replace(username, '-[0-9]+$', '') = ANY($2))`, tenant.ID(ctx), slices.Collect(maps.Keys(taken)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		take(username)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, username := range reserved {
+		take(username)
+	}
+
+	usernames := make([]string, len(names))
+	for i, base := range bases {
+		n := 1
+		for taken[base][n] {
+			n++
+		}
+		usernames[i] = base
+		if n > 1 {
+			usernames[i] = base + "-" + strconv.Itoa(n)
+		}
+		// a username chosen here may be the base of a later name, such as jane-2
+		take(usernames[i])
+	}
+	return usernames, nil
+}
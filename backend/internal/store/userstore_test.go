@@ -0,0 +1,586 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"api/internal/models"
+	"api/internal/pii"
+	"api/internal/tenant"
+)
+
+// run test against each UserRepository, each fresh: in memory, and in SQLite with emails in plain
+// text and encrypted
+func eachUserRepository(t *testing.T, test func(t *testing.T, users UserRepository)) {
+	t.Run("memory", func(t *testing.T) { test(t, NewMemoryUserRepository()) })
+	t.Run("sqlite", func(t *testing.T) { test(t, newSQLiteUsers(t, nil)) })
+	t.Run("sqlite encrypted", func(t *testing.T) {
+		keys, err := pii.NewKeyring("k1=MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, newSQLiteUsers(t, keys))
+	})
+}
+
+func newSQLiteUsers(t *testing.T, keys *pii.Keyring) *SQLiteUserRepository {
+	t.Helper()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := MigrateSQLite(context.Background(), db, "up"); err != nil {
+		t.Fatal(err)
+	}
+	return NewSQLiteUserRepository(db, keys)
+}
+
+func mustCreate(t *testing.T, ctx context.Context, users UserRepository, name, email string) models.User {
+	t.Helper()
+	u, err := users.Create(ctx, models.User{Name: name, Email: email, PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("Create %s: %v", email, err)
+	}
+	return u
+}
+
+func TestUserStoreCreate(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		namesake := mustCreate(t, ctx, users, "Ada Lovelace", "ada.l@example.com")
+		if ada.Role != models.RoleAdmin || namesake.Role != models.RoleUser {
+			t.Errorf("roles = %q, %q; want the first user to be the admin", ada.Role, namesake.Role)
+		}
+		if ada.Username != "ada-lovelace" || namesake.Username != "ada-lovelace-2" {
+			t.Errorf("usernames = %q, %q; want ada-lovelace numbered from 2", ada.Username, namesake.Username)
+		}
+		if _, err := users.Create(ctx, models.User{Name: "Ada", Email: "ADA@example.com"}); !errors.Is(err, ErrEmailTaken) {
+			t.Errorf("Create with a taken email in another case: %v, want %v", err, ErrEmailTaken)
+		}
+		if _, err := users.Create(ctx, models.User{Name: "Ada", Email: "ada.k@example.com", Username: "ada-lovelace"}); !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("Create with a taken username: %v, want %v", err, ErrUsernameTaken)
+		}
+
+		got, err := users.GetByEmail(ctx, "Ada@Example.com")
+		if err != nil || got.Id != ada.Id || got.PasswordHash != "hash" || got.Email != "ada@example.com" {
+			t.Errorf("GetByEmail = %+v, %v; want Ada with their password hash", got, err)
+		}
+		if got, err := users.GetByUsername(ctx, "ada-lovelace-2"); err != nil || got.Id != namesake.Id {
+			t.Errorf("GetByUsername = %+v, %v; want the namesake", got, err)
+		}
+		existing, err := users.ExistingEmails(ctx, []string{"ada@example.com", "grace@example.com"})
+		if err != nil || len(existing) != 1 || !existing["ada@example.com"] {
+			t.Errorf("ExistingEmails = %v, %v; want only ada@example.com", existing, err)
+		}
+
+		// tenants don't see each other's users, and have admins of their own
+		other := tenant.WithID(context.Background(), 2)
+		if _, err := users.Get(other, ada.Id, false); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Get from another tenant: %v, want %v", err, ErrUserNotFound)
+		}
+		if exists, err := users.EmailExists(other, "ada@example.com"); err != nil || exists {
+			t.Errorf("EmailExists in another tenant = %v, %v; want false", exists, err)
+		}
+		if u := mustCreate(t, other, users, "Ada Lovelace", "ada@example.com"); u.Role != models.RoleAdmin || u.Username != "ada-lovelace" {
+			t.Errorf("first user of another tenant = %q, %q; want the admin ada-lovelace", u.Role, u.Username)
+		}
+	})
+}
+
+func TestUserStoreCreateMany(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		backdated := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		created, err := users.CreateMany(ctx, []models.User{
+			{Name: "Grace Hopper", Email: "grace@example.com"},
+			{Name: "Someone", Email: "gh@example.com", Username: "grace-hopper"},
+			{Name: "Grace Hopper", Email: "grace.h@example.com", CreatedAt: backdated},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created[0].Role != models.RoleAdmin || created[1].Role != models.RoleUser {
+			t.Errorf("roles = %q, %q; want the first to be the admin", created[0].Role, created[1].Role)
+		}
+		// the username given in the batch is steered clear of
+		if created[0].Username != "grace-hopper-2" || created[2].Username != "grace-hopper-3" {
+			t.Errorf("derived usernames = %q, %q; want grace-hopper-2 and -3", created[0].Username, created[2].Username)
+		}
+		if !created[2].CreatedAt.Equal(backdated) {
+			t.Errorf("CreatedAt = %v, want %v", created[2].CreatedAt, backdated)
+		}
+
+		// one clash and none are created
+		if _, err := users.CreateMany(ctx, []models.User{{Name: "Alan Turing", Email: "alan@example.com"}, {Name: "Grace", Email: "grace@example.com"}}); err == nil {
+			t.Error("CreateMany with a taken email succeeded")
+		}
+		if exists, _ := users.EmailExists(ctx, "alan@example.com"); exists {
+			t.Error("CreateMany kept the users before the clash")
+		}
+	})
+}
+
+func TestUserStoreUpsert(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		u, created, err := users.Upsert(ctx, models.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+		if err != nil || !created || u.Username != "ada-lovelace" {
+			t.Fatalf("first Upsert = %+v, %v, %v; want Ada created", u, created, err)
+		}
+		u, created, err = users.Upsert(ctx, models.User{Name: "Ada King", Email: "ADA@example.com"})
+		if err != nil || created || u.Name != "Ada King" || u.Username != "ada-lovelace" {
+			t.Errorf("second Upsert = %+v, %v, %v; want Ada renamed, keeping their username", u, created, err)
+		}
+		if _, err := users.Delete(ctx, u.Id); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := users.Upsert(ctx, models.User{Name: "Ada", Email: "ada@example.com"}); !errors.Is(err, ErrEmailTaken) {
+			t.Errorf("Upsert onto a soft-deleted user: %v, want %v", err, ErrEmailTaken)
+		}
+	})
+}
+
+func TestUserStoreListsSkipDeleted(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		grace := mustCreate(t, ctx, users, "Grace Hopper", "grace@example.com")
+		alan := mustCreate(t, ctx, users, "Alan Turing", "alan@example.com")
+		if deleted, err := users.Delete(ctx, grace.Id); err != nil || deleted.DeletedAt == nil {
+			t.Fatalf("Delete = %+v, %v", deleted, err)
+		}
+
+		list, total, err := users.List(ctx, models.UserFilter{}, models.UserSort{Field: "name"}, 10, 0)
+		if err != nil || total != 2 || len(list) != 2 || list[0].Id != ada.Id || list[1].Id != alan.Id {
+			t.Errorf("List = %v, %d, %v; want Ada and Alan by name", list, total, err)
+		}
+		if _, total, _ := users.List(ctx, models.UserFilter{IncludeDeleted: true}, models.UserSort{}, 10, 0); total != 3 {
+			t.Errorf("List including the deleted: %d users, want 3", total)
+		}
+		if _, err := users.Get(ctx, grace.Id, false); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Get of a deleted user: %v, want %v", err, ErrUserNotFound)
+		}
+		if got, err := users.Get(ctx, grace.Id, true); err != nil || got.DeletedAt == nil {
+			t.Errorf("Get including the deleted = %+v, %v", got, err)
+		}
+		if _, err := users.Delete(ctx, grace.Id); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("second Delete: %v, want %v", err, ErrUserNotFound)
+		}
+		if list, total, _ := users.List(ctx, models.UserFilter{EmailContains: "alan@example.com"}, models.UserSort{}, 10, 0); total != 1 || list[0].Id != alan.Id {
+			t.Errorf("List by email = %v, want Alan", list)
+		}
+
+		// pages follow on from the cursor
+		page1, err := users.ListAfter(ctx, models.UserFilter{}, nil, false, 1)
+		if err != nil || len(page1) != 1 || page1[0].Id != ada.Id {
+			t.Fatalf("first page = %v, %v; want Ada", page1, err)
+		}
+		page2, err := users.ListAfter(ctx, models.UserFilter{}, &models.UserCursor{CreatedAt: page1[0].CreatedAt, Id: page1[0].Id}, false, 10)
+		if err != nil || len(page2) != 1 || page2[0].Id != alan.Id {
+			t.Errorf("second page = %v, %v; want Alan", page2, err)
+		}
+
+		if restored, err := users.Restore(ctx, grace.Id); err != nil || restored.DeletedAt != nil {
+			t.Errorf("Restore = %+v, %v", restored, err)
+		}
+		if _, err := users.Restore(ctx, grace.Id); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Restore of a live user: %v, want %v", err, ErrUserNotFound)
+		}
+
+		deleted, err := users.DeleteMatching(ctx, []int64{int64(ada.Id), int64(grace.Id)}, nil)
+		if err != nil || len(deleted) != 2 {
+			t.Errorf("DeleteMatching = %v, %v; want Ada and Grace", deleted, err)
+		}
+		if _, total, _ := users.List(ctx, models.UserFilter{}, models.UserSort{}, 10, 0); total != 1 {
+			t.Errorf("after DeleteMatching %d users are left, want 1", total)
+		}
+	})
+}
+
+func TestUserStoreUpdate(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		if _, err := users.MarkEmailVerified(ctx, ada.Id, "ada@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		verified, err := users.Get(ctx, ada.Id, false)
+		if err != nil || verified.EmailVerifiedAt == nil || verified.Version != ada.Version+1 {
+			t.Fatalf("after MarkEmailVerified = %+v, %v; want verified at the next version", verified, err)
+		}
+
+		bio := "Analyst"
+		updated, err := users.Update(ctx, ada.Id, models.User{Name: "Ada King", Email: "ada@example.com", Bio: &bio}, verified.Version)
+		if err != nil || updated.Name != "Ada King" || updated.Version != verified.Version+1 || updated.EmailVerifiedAt == nil || updated.Username != "ada-lovelace" {
+			t.Errorf("Update = %+v, %v; want renamed at the next version, still verified", updated, err)
+		}
+		if _, err := users.Update(ctx, ada.Id, models.User{Name: "Ada", Email: "ada@example.com"}, verified.Version); !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("Update at a stale version: %v, want %v", err, ErrVersionConflict)
+		}
+		if _, err := users.Update(ctx, ada.Id+100, models.User{Name: "Ada", Email: "ada@example.com"}, 1); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Update of a missing user: %v, want %v", err, ErrUserNotFound)
+		}
+		moved, err := users.Update(ctx, ada.Id, models.User{Name: "Ada King", Email: "ada@example.org", Bio: &bio}, 0)
+		if err != nil || moved.EmailVerifiedAt != nil {
+			t.Errorf("Update of the email = %+v, %v; want the verification cleared", moved, err)
+		}
+
+		revisions, err := users.Revisions(ctx, ada.Id)
+		if err != nil || len(revisions) != 4 {
+			t.Fatalf("Revisions = %d, %v; want one per write", len(revisions), err)
+		}
+		if first := revisions[0].User; first.Name != "Ada Lovelace" || first.Email != "ada@example.com" {
+			t.Errorf("first revision = %+v, want Ada as created", first)
+		}
+		if rev, err := users.Revision(ctx, ada.Id, 4); err != nil || rev.User.Email != "ada@example.org" || rev.User.Bio == nil || *rev.User.Bio != bio {
+			t.Errorf("Revision 4 = %+v, %v; want the new address and bio", rev.User, err)
+		}
+		if _, err := users.Revision(ctx, ada.Id, 5); !errors.Is(err, ErrRevisionNotFound) {
+			t.Errorf("Revision 5: %v, want %v", err, ErrRevisionNotFound)
+		}
+	})
+}
+
+func TestUserStoreEmailChange(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		mustCreate(t, ctx, users, "Grace Hopper", "grace@example.com")
+		if err := users.RequestEmailChange(ctx, ada.Id, "grace@example.com"); !errors.Is(err, ErrEmailTaken) {
+			t.Errorf("RequestEmailChange to a taken address: %v, want %v", err, ErrEmailTaken)
+		}
+		if err := users.RequestEmailChange(ctx, ada.Id, "ada@example.org"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := users.ConfirmEmailChange(ctx, ada.Id, "other@example.org"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("ConfirmEmailChange of another address: %v, want %v", err, ErrUserNotFound)
+		}
+		u, err := users.ConfirmEmailChange(ctx, ada.Id, "ADA@example.org")
+		if err != nil || u.Email != "ada@example.org" || u.EmailVerifiedAt == nil {
+			t.Errorf("ConfirmEmailChange = %+v, %v; want the new address verified", u, err)
+		}
+		if _, err := users.ConfirmEmailChange(ctx, ada.Id, "ada@example.org"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("second ConfirmEmailChange: %v, want %v", err, ErrUserNotFound)
+		}
+	})
+}
+
+func TestUserStoreSessions(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		client := models.SessionClient{UserAgent: "test", IP: "192.0.2.1"}
+		expires := time.Now().Add(time.Hour)
+		sessionID, err := users.CreateSession(ctx, ada.Id, client, []byte("first"), expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		userID, rotated, err := users.RotateRefreshToken(ctx, []byte("first"), []byte("second"), expires, client)
+		if err != nil || userID != ada.Id || rotated != sessionID {
+			t.Fatalf("RotateRefreshToken = %d, %d, %v; want Ada's session", userID, rotated, err)
+		}
+		if sessions, err := users.Sessions(ctx, ada.Id); err != nil || len(sessions) != 1 || sessions[0].Id != sessionID {
+			t.Errorf("Sessions = %v, %v; want the one session", sessions, err)
+		}
+
+		// replaying the spent token ends the session
+		if _, _, err := users.RotateRefreshToken(ctx, []byte("first"), []byte("third"), expires, client); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Errorf("RotateRefreshToken with a spent token: %v, want %v", err, ErrRefreshTokenReused)
+		}
+		if revoked, err := users.SessionRevoked(ctx, sessionID); err != nil || !revoked {
+			t.Errorf("SessionRevoked after a replay = %v, %v; want true", revoked, err)
+		}
+		if _, _, err := users.RotateRefreshToken(ctx, []byte("second"), []byte("third"), expires, client); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("RotateRefreshToken in a revoked session: %v, want %v", err, ErrInvalidRefreshToken)
+		}
+		if sessions, _ := users.Sessions(ctx, ada.Id); len(sessions) != 0 {
+			t.Errorf("Sessions after a replay = %v, want none", sessions)
+		}
+	})
+}
+
+func TestUserStoreLockout(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		if until, err := users.RecordFailedLogin(ctx, ada.Id, 2, time.Minute); err != nil || until != nil {
+			t.Errorf("first failure = %v, %v; want no lockout", until, err)
+		}
+		until, err := users.RecordFailedLogin(ctx, ada.Id, 2, time.Minute)
+		if err != nil || until == nil {
+			t.Fatalf("second failure = %v, %v; want a lockout", until, err)
+		}
+		if locked, err := users.LockedUntil(ctx, ada.Id); err != nil || locked == nil || !locked.Equal(*until) {
+			t.Errorf("LockedUntil = %v, %v; want %v", locked, err, *until)
+		}
+		if err := users.Unlock(ctx, ada.Id); err != nil {
+			t.Fatal(err)
+		}
+		if locked, err := users.LockedUntil(ctx, ada.Id); err != nil || locked != nil {
+			t.Errorf("LockedUntil after Unlock = %v, %v; want nil", locked, err)
+		}
+	})
+}
+
+func TestUserStoreErase(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		grace := mustCreate(t, ctx, users, "Grace Hopper", "grace@example.com")
+		if _, err := users.Update(ctx, grace.Id, models.User{Name: "Grace B. Hopper", Email: "grace@example.com"}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := users.CreateSession(ctx, grace.Id, models.SessionClient{}, []byte("token"), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := users.CreateAPIKey(ctx, grace.Id, "ci", "ak_1", []byte("key")); err != nil {
+			t.Fatal(err)
+		}
+
+		erased, err := users.Erase(ctx, grace.Id)
+		if err != nil || erased.Name != "Erased user" || erased.Email == "grace@example.com" || erased.DeletedAt == nil {
+			t.Fatalf("Erase = %+v, %v; want placeholders, soft deleted", erased, err)
+		}
+		if exists, _ := users.EmailExists(ctx, "grace@example.com"); exists {
+			t.Error("the erased address is still in use")
+		}
+		revisions, err := users.Revisions(ctx, grace.Id)
+		if err != nil || len(revisions) != 1 || revisions[0].Rev != 1 || revisions[0].User.Name != "Erased user" {
+			t.Errorf("Revisions after Erase = %+v, %v; want only the erased one", revisions, err)
+		}
+		if sessions, _ := users.Sessions(ctx, grace.Id); len(sessions) != 0 {
+			t.Errorf("Sessions after Erase = %v, want none", sessions)
+		}
+		if _, _, err := users.APIKeyUser(ctx, []byte("key")); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("APIKeyUser after Erase: %v, want %v", err, ErrAPIKeyNotFound)
+		}
+		if _, err := users.Erase(ctx, grace.Id); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("second Erase: %v, want %v", err, ErrUserNotFound)
+		}
+		if _, err := users.Restore(ctx, grace.Id); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Restore of an erased user: %v, want %v", err, ErrUserNotFound)
+		}
+	})
+}
+
+func TestUserStoreMerge(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		bio := "Mathematician"
+		time.Sleep(2 * time.Millisecond)
+		dupe, err := users.Create(ctx, models.User{Name: "Ada King", Email: "ada.king@example.com", Bio: &bio})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := users.TagUser(ctx, dupe.Id, "founder"); err != nil {
+			t.Fatal(err)
+		}
+
+		merged, err := users.Merge(ctx, ada.Id, dupe.Id)
+		if err != nil || merged.Name != "Ada King" || merged.Bio == nil || *merged.Bio != bio || merged.Email != "ada@example.com" {
+			t.Errorf("Merge = %+v, %v; want the newer name and bio with Ada's email", merged, err)
+		}
+		if tags, err := users.UserTags(ctx, ada.Id); err != nil || len(tags) != 1 || tags[0] != "founder" {
+			t.Errorf("UserTags after Merge = %v, %v; want the source's tag", tags, err)
+		}
+		if _, err := users.Get(ctx, dupe.Id, false); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Get of the merged source: %v, want %v", err, ErrUserNotFound)
+		}
+		if _, err := users.Merge(ctx, ada.Id, dupe.Id); !errors.Is(err, ErrMergeSourceNotFound) {
+			t.Errorf("Merge of a deleted source: %v, want %v", err, ErrMergeSourceNotFound)
+		}
+	})
+}
+
+func TestUserStoreRolesAndPermissions(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		grace := mustCreate(t, ctx, users, "Grace Hopper", "grace@example.com")
+		if ok, err := users.HasPermission(ctx, grace.Id, models.PermUsersDelete); err != nil || ok {
+			t.Errorf("a user may delete users: %v, %v", ok, err)
+		}
+		if ok, err := users.HasPermission(ctx, ada.Id, models.PermUsersDelete); err != nil || !ok {
+			t.Errorf("the admin may not delete users: %v, %v", ok, err)
+		}
+		if _, err := users.SetRole(ctx, ada.Id, models.RoleUser); !errors.Is(err, ErrLastAdmin) {
+			t.Errorf("demoting the last admin: %v, want %v", err, ErrLastAdmin)
+		}
+		if _, err := users.SetRole(ctx, grace.Id, "owner"); !errors.Is(err, ErrUnknownRole) {
+			t.Errorf("SetRole to an unknown role: %v, want %v", err, ErrUnknownRole)
+		}
+		if u, err := users.SetRole(ctx, grace.Id, models.RoleAdmin); err != nil || u.Role != models.RoleAdmin {
+			t.Errorf("SetRole = %+v, %v", u, err)
+		}
+
+		roles, err := users.Roles(ctx)
+		if err != nil || len(roles) != 2 || roles[0].Name != models.RoleAdmin || roles[1].Name != models.RoleUser {
+			t.Fatalf("Roles = %v, %v; want admin and user", roles, err)
+		}
+		if len(roles[1].Permissions) != 1 || roles[1].Permissions[0] != models.PermUsersRead {
+			t.Errorf("user role grants %v, want %s alone", roles[1].Permissions, models.PermUsersRead)
+		}
+	})
+}
+
+func TestUserStoreTagsAndKeys(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 3)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		grace := mustCreate(t, ctx, users, "Grace Hopper", "grace@example.com")
+		for _, tag := range []string{"vip", "beta", "vip"} {
+			if _, err := users.TagUser(ctx, ada.Id, tag); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := users.TagUser(ctx, grace.Id, "vip"); err != nil {
+			t.Fatal(err)
+		}
+		if tags, err := users.UserTags(ctx, ada.Id); err != nil || len(tags) != 2 || tags[0] != "beta" || tags[1] != "vip" {
+			t.Errorf("UserTags = %v, %v; want beta and vip", tags, err)
+		}
+		if tags, err := users.Tags(ctx); err != nil || len(tags) != 2 || tags[1].Name != "vip" || tags[1].Users != 2 {
+			t.Errorf("Tags = %v, %v; want vip on two users", tags, err)
+		}
+		if list, total, _ := users.List(ctx, models.UserFilter{Tag: "beta"}, models.UserSort{}, 10, 0); total != 1 || list[0].Id != ada.Id {
+			t.Errorf("List by tag = %v, want Ada", list)
+		}
+		if err := users.UntagUser(ctx, grace.Id, "beta"); !errors.Is(err, ErrTagNotFound) {
+			t.Errorf("UntagUser of a tag they don't have: %v, want %v", err, ErrTagNotFound)
+		}
+
+		key, err := users.CreateAPIKey(ctx, grace.Id, "ci", "ak_1", []byte("key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the key finds its tenant from any other
+		userID, tenantID, err := users.APIKeyUser(context.Background(), []byte("key"))
+		if err != nil || userID != grace.Id || tenantID != 3 {
+			t.Errorf("APIKeyUser = %d, %d, %v; want Grace in tenant 3", userID, tenantID, err)
+		}
+		if keys, err := users.APIKeys(ctx, grace.Id); err != nil || len(keys) != 1 || keys[0].LastUsedAt == nil {
+			t.Errorf("APIKeys = %+v, %v; want the key, used", keys, err)
+		}
+		if err := users.RevokeAPIKey(ctx, grace.Id, key.Id); err != nil {
+			t.Fatal(err)
+		}
+		if err := users.RevokeAPIKey(ctx, grace.Id, key.Id); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("second RevokeAPIKey: %v, want %v", err, ErrAPIKeyNotFound)
+		}
+	})
+}
+
+func TestUserStoreChanges(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		grace := mustCreate(t, ctx, users, "Grace Hopper", "grace@example.com")
+		time.Sleep(2 * time.Millisecond)
+
+		c, err := users.Changes(ctx, nil, 10)
+		if err != nil || len(c.Users) != 2 || c.More {
+			t.Fatalf("first sync = %+v, %v; want both users", c, err)
+		}
+		time.Sleep(2 * time.Millisecond)
+		if _, err := users.Delete(ctx, grace.Id); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+		c, err = users.Changes(ctx, &c.Next, 10)
+		if err != nil || len(c.Users) != 0 || len(c.Deleted) != 1 || c.Deleted[0].Id != grace.Id {
+			t.Fatalf("second sync = %+v, %v; want Grace deleted", c, err)
+		}
+		if c, err = users.Changes(ctx, &c.Next, 10); err != nil || len(c.Users)+len(c.Deleted) != 0 {
+			t.Errorf("third sync = %+v, %v; want nothing new", c, err)
+		}
+	})
+}
+
+func TestUserStoreStatsAndSignups(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		mustCreate(t, ctx, users, "Grace Hopper", "grace@example.com")
+		alan := mustCreate(t, ctx, users, "Alan Turing", "alan@example.com")
+		if _, err := users.MarkEmailVerified(ctx, ada.Id, "ada@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := users.Delete(ctx, alan.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		st, err := users.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Total != 2 || st.ByVerification.Verified != 1 || st.ByVerification.Unverified != 1 || st.Created.Last24h != 2 {
+			t.Errorf("Stats = %+v; want 2 live users, one verified, both new", st)
+		}
+		if st.ByRole[models.RoleAdmin] != 1 || st.ByRole[models.RoleUser] != 1 {
+			t.Errorf("Stats.ByRole = %v; want one of each", st.ByRole)
+		}
+
+		now := time.Now()
+		buckets, err := users.Signups(ctx, "day", now.AddDate(0, 0, -2), now, false)
+		if err != nil || len(buckets) != 3 {
+			t.Fatalf("Signups = %v, %v; want three days", buckets, err)
+		}
+		if buckets[0].Count != 0 || buckets[2].Count != 2 || !buckets[2].Start.Equal(bucketStart("day", now)) {
+			t.Errorf("Signups = %v; want today's two", buckets)
+		}
+		if buckets, _ := users.Signups(ctx, "day", now, now, true); len(buckets) != 1 || buckets[0].Count != 3 {
+			t.Errorf("Signups including the deleted = %v; want three today", buckets)
+		}
+	})
+}
+
+func TestUserStoreSearch(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		mustCreate(t, ctx, users, "Grace Hopper", "grace@example.com")
+		if results, err := users.Search(ctx, "lovelace", 10, 0); err != nil || len(results) != 1 || results[0].User.Id != ada.Id {
+			t.Errorf("Search = %v, %v; want Ada", results, err)
+		}
+		if results, err := users.FuzzySearch(ctx, "LOVE", 0.3, 10, 0); err != nil || len(results) != 1 || results[0].User.Id != ada.Id {
+			t.Errorf("FuzzySearch = %v, %v; want Ada", results, err)
+		}
+		if results, err := users.Search(ctx, "example", 1, 1); err != nil || len(results) != 1 {
+			t.Errorf("second page of Search = %v, %v; want one user", results, err)
+		}
+	})
+}
+
+func TestUserStorePreferences(t *testing.T) {
+	eachUserRepository(t, func(t *testing.T, users UserRepository) {
+		ctx := tenant.WithID(context.Background(), 1)
+		ada := mustCreate(t, ctx, users, "Ada Lovelace", "ada@example.com")
+		if p, err := users.NotificationPreferences(ctx, ada.Id); err != nil || p != models.DefaultNotificationPreferences {
+			t.Errorf("NotificationPreferences = %+v, %v; want the defaults", p, err)
+		}
+		p := models.NotificationPreferences{ProfileChanges: false, ProductUpdates: true}
+		if err := users.SetNotificationPreferences(ctx, ada.Id, p); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := users.NotificationPreferences(ctx, ada.Id); err != nil || got != p {
+			t.Errorf("NotificationPreferences = %+v, %v; want %+v", got, err, p)
+		}
+
+		settings, err := users.UpdateSettings(ctx, ada.Id, func(s models.Settings) (models.Settings, error) {
+			s["theme"] = "dark"
+			return s, nil
+		})
+		if err != nil || settings["theme"] != "dark" {
+			t.Fatalf("UpdateSettings = %v, %v", settings, err)
+		}
+		if got, err := users.Settings(ctx, ada.Id); err != nil || got["theme"] != "dark" {
+			t.Errorf("Settings = %v, %v; want the dark theme", got, err)
+		}
+	})
+}
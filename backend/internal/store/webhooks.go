@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"api/internal/models"
+	"api/internal/tenant"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Webhooks keeps subscriptions to user events and the queue of deliveries made to them. a tenant's
+// webhooks only hear of its own users, and are only seen and managed within it
+type Webhooks interface {
+	// subscribe w.URL to w.Events, signed with w.Secret, and fill in its generated id and created_at
+	Create(ctx context.Context, w models.Webhook) (models.Webhook, error)
+	// every webhook, oldest first
+	List(ctx context.Context) ([]models.Webhook, error)
+	// ErrWebhookNotFound if there is no such webhook
+	Get(ctx context.Context, id int) (models.Webhook, error)
+	// remove a webhook along with its deliveries; ErrWebhookNotFound if there is no such webhook
+	Delete(ctx context.Context, id int) error
+	// one page of a webhook's deliveries, newest first, along with their total number
+	Deliveries(ctx context.Context, webhookID, limit, offset int) ([]models.WebhookDelivery, int, error)
+	// queue payload for delivery to every webhook subscribed to event, once per outbox event
+	// however often it is enqueued
+	Enqueue(ctx context.Context, outboxID int64, event string, payload []byte) error
+	// claim up to limit pending deliveries that are due, holding each back from other claims for
+	// lease, long enough to make the attempt and record it
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.DueDelivery, error)
+	// record the outcome of an attempt at a claimed delivery
+	RecordAttempt(ctx context.Context, id int64, attempt models.DeliveryAttempt) error
+}
+
+// PostgresWebhooks keeps webhooks in the webhooks table and their deliveries in webhook_deliveries.
+type PostgresWebhooks struct {
+	db *sql.DB
+}
+
+var _ Webhooks = (*PostgresWebhooks)(nil)
+
+func NewPostgresWebhooks(db *sql.DB) *PostgresWebhooks {
+	return &PostgresWebhooks{db: db}
+}
+
+const webhookColumns = "id, url, events, created_by, created_at"
+
+func scanWebhook(row scanner) (models.Webhook, error) {
+	var w models.Webhook
+	err := row.Scan(&w.Id, &w.URL, pgtype.NewMap().SQLScanner(&w.Events), &w.CreatedBy, &w.CreatedAt)
+	return w, err
+}
+
+func (s *PostgresWebhooks) Create(ctx context.Context, w models.Webhook) (models.Webhook, error) {
+	err := s.db.QueryRowContext(ctx, "INSERT INTO webhooks (url, events, secret, created_by, tenant_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+		w.URL, w.Events, w.Secret, w.CreatedBy, tenant.ID(ctx)).Scan(&w.Id, &w.CreatedAt)
+	return w, err
+}
+
+func (s *PostgresWebhooks) List(ctx context.Context) ([]models.Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE tenant_id = $1 ORDER BY id", tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *PostgresWebhooks) Get(ctx context.Context, id int) (models.Webhook, error) {
+	w, err := scanWebhook(s.db.QueryRowContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = $1 AND tenant_id = $2", id, tenant.ID(ctx)))
+	if err == sql.ErrNoRows {
+		return models.Webhook{}, ErrWebhookNotFound
+	}
+	return w, err
+}
+
+func (s *PostgresWebhooks) Delete(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1 AND tenant_id = $2", id, tenant.ID(ctx))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (s *PostgresWebhooks) Deliveries(ctx context.Context, webhookID, limit, offset int) ([]models.WebhookDelivery, int, error) {
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM webhook_deliveries
+		WHERE webhook_id = $1 AND webhook_id IN (SELECT id FROM webhooks WHERE tenant_id = $2)`, webhookID, tenant.ID(ctx)).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at,
+			last_status_code, last_error, created_at, delivered_at
+		FROM webhook_deliveries WHERE webhook_id = $1 AND webhook_id IN (SELECT id FROM webhooks WHERE tenant_id = $4)
+		ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3`, webhookID, limit, offset, tenant.ID(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var (
+			d           models.WebhookDelivery
+			payload     []byte
+			nextAttempt time.Time
+		)
+		if err := rows.Scan(&d.Id, &d.WebhookId, &d.Event, &payload, &d.Status, &d.Attempts, &nextAttempt,
+			&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			return nil, 0, err
+		}
+		d.Payload = payload
+		if d.Status == models.DeliveryPending {
+			d.NextAttemptAt = &nextAttempt
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, total, rows.Err()
+}
+
+func (s *PostgresWebhooks) Enqueue(ctx context.Context, outboxID int64, event string, payload []byte) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO webhook_deliveries (webhook_id, outbox_id, event, payload)
+		SELECT id, $1, $2, $3::jsonb FROM webhooks WHERE $2 = ANY (events) AND tenant_id = $4
+		ON CONFLICT (webhook_id, outbox_id) DO NOTHING`, outboxID, event, payload, tenant.ID(ctx))
+	return err
+}
+
+// SKIP LOCKED lets several replicas claim deliveries side by side without sending any twice
+func (s *PostgresWebhooks) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.DueDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `WITH due AS (
+			SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+		UPDATE webhook_deliveries d SET next_attempt_at = now() + $2::float8 * interval '1 millisecond'
+		FROM due, webhooks w WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, d.event, d.payload, d.attempts, d.created_at, w.url, w.secret`, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var due []models.DueDelivery
+	for rows.Next() {
+		var (
+			d       models.DueDelivery
+			payload []byte
+		)
+		if err := rows.Scan(&d.Id, &d.WebhookId, &d.Event, &payload, &d.Attempts, &d.CreatedAt, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		d.Status = models.DeliveryPending
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+func (s *PostgresWebhooks) RecordAttempt(ctx context.Context, id int64, attempt models.DeliveryAttempt) error {
+	var nextAttempt interface{}
+	if attempt.Status == models.DeliveryPending {
+		nextAttempt = attempt.NextAttemptAt
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE webhook_deliveries SET attempts = attempts + 1, status = $2,
+			last_status_code = NULLIF($3, 0), last_error = NULLIF($4, ''),
+			next_attempt_at = COALESCE($5, next_attempt_at),
+			delivered_at = CASE WHEN $2 = 'succeeded' THEN now() END
+		WHERE id = $1`, id, attempt.Status, attempt.StatusCode, attempt.Error, nextAttempt)
+	return err
+}
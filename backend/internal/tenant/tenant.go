@@ -0,0 +1,33 @@
+// Package tenant carries the tenant a request acts within on its context. the store scopes every
+// query to it, so one tenant's users and everything about them stay out of reach of another's.
+package tenant
+
+import "context"
+
+// DefaultID is the tenant every install starts with, which holds the users created before
+// tenants existed and serves requests that name no other.
+const DefaultID = 1
+
+// Header is the request header naming a tenant by its slug.
+const Header = "X-Tenant"
+
+type contextKey struct{}
+
+// WithID returns ctx scoped to the tenant with the given id.
+func WithID(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant ctx was scoped to, if it was.
+func FromContext(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(contextKey{}).(int)
+	return id, ok
+}
+
+// ID returns the tenant ctx was scoped to, or DefaultID when it wasn't.
+func ID(ctx context.Context) int {
+	if id, ok := FromContext(ctx); ok {
+		return id
+	}
+	return DefaultID
+}
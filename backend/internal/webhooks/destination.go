@@ -0,0 +1,78 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"syscall"
+)
+
+// ErrForbiddenDestination is reported for webhook URLs whose host is, or resolves to, an address
+// of this host or its networks, which integrators mustn't get us to send requests to.
+var ErrForbiddenDestination = errors.New("webhook URLs must not point at loopback, private, link-local or cloud metadata addresses")
+
+// ranges not covered by netip's predicates: shared address space, which cloud providers such as
+// Alibaba serve metadata from, and the IPv4 "this network" block
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("0.0.0.0/8"),
+}
+
+// report whether deliveries may not be sent to addr: loopback, RFC 1918 and unique local,
+// link-local, which holds the 169.254.169.254 metadata endpoint, and unspecified addresses
+func forbidden(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsUnspecified() || addr.IsMulticast() {
+		return true
+	}
+	for _, p := range forbiddenPrefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL reports ErrForbiddenDestination when the host of a webhook URL is, or resolves to, an
+// address deliveries may not be sent to, and the lookup's error when it doesn't resolve. the
+// dispatcher checks each address it connects to as well, as DNS can answer differently later.
+func CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if forbidden(addr) {
+			return ErrForbiddenDestination
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if forbidden(addr) {
+			return ErrForbiddenDestination
+		}
+	}
+	return nil
+}
+
+// refuse connections to forbidden addresses, as a net.Dialer's Control, which sees the address
+// each connection is made to after resolution
+func dialControl(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("webhook destination %q: %w", address, err)
+	}
+	if forbidden(addrPort.Addr()) {
+		return fmt.Errorf("webhook destination %s: %w", addrPort.Addr(), ErrForbiddenDestination)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckURL(t *testing.T) {
+	for _, tc := range []struct {
+		url       string
+		forbidden bool
+	}{
+		{"https://127.0.0.1/hook", true},
+		{"http://[::1]:8080/hook", true},
+		{"http://10.1.2.3/hook", true},
+		{"http://172.16.0.1/hook", true},
+		{"http://192.168.1.10/hook", true},
+		{"http://169.254.169.254/latest/meta-data/", true},
+		{"http://100.100.100.200/latest/meta-data/", true},
+		{"http://[fd00:ec2::254]/latest/meta-data/", true},
+		{"http://[::ffff:127.0.0.1]/hook", true},
+		{"http://0.0.0.0/hook", true},
+		{"https://93.184.215.14/hook", false},
+		{"https://[2606:4700::1111]/hook", false},
+	} {
+		if err := CheckURL(context.Background(), tc.url); errors.Is(err, ErrForbiddenDestination) != tc.forbidden || (!tc.forbidden && err != nil) {
+			t.Errorf("CheckURL(%q) = %v, want forbidden %v", tc.url, err, tc.forbidden)
+		}
+	}
+}
+
+func TestDialControlRefusesForbiddenAddresses(t *testing.T) {
+	// what a rebinding host resolves to by the time a delivery is sent
+	if err := dialControl("tcp", "127.0.0.1:443", nil); !errors.Is(err, ErrForbiddenDestination) {
+		t.Errorf("dialControl to loopback = %v, want %v", err, ErrForbiddenDestination)
+	}
+	if err := dialControl("tcp6", "[2606:4700::1111]:443", nil); err != nil {
+		t.Errorf("dialControl to a public address = %v, want nil", err)
+	}
+}
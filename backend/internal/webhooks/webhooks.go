@@ -0,0 +1,201 @@
+// Package webhooks queues user events from the outbox for the URLs integrators subscribed and
+// delivers them, signing each request with the subscription's secret and retrying failures with
+// exponential backoff.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"api/internal/models"
+	"api/internal/store"
+)
+
+// headers sent with every delivery
+const (
+	// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256>", see Sign
+	SignatureHeader = "X-Webhook-Signature"
+	// EventHeader names the event, such as user.created
+	EventHeader = "X-Webhook-Event"
+	// DeliveryHeader is the delivery's id, the same on every retry, so receivers can drop duplicates
+	DeliveryHeader = "X-Webhook-Delivery"
+)
+
+// Sign is the signature of a delivery made at t: the HMAC-SHA256 under secret of the unix time,
+// a dot and the body. receivers recompute it to check the request came from us, and can refuse
+// old timestamps to stop replays
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Payload is the body POSTed to a webhook for each event it subscribes to.
+type Payload struct {
+	Event      string          `json:"event"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	User       json.RawMessage `json:"user"` // the user after the change, as in API v1
+}
+
+// Queue publishes outbox events to webhooks, queueing a delivery of each to every webhook subscribed to it.
+type Queue struct {
+	webhooks store.Webhooks
+}
+
+func NewQueue(webhooks store.Webhooks) *Queue {
+	return &Queue{webhooks: webhooks}
+}
+
+func (q *Queue) Publish(ctx context.Context, e models.OutboxEvent) error {
+	if e.EntityType != models.AuditEntityUser {
+		return nil
+	}
+	payload, err := json.Marshal(Payload{Event: e.Event, OccurredAt: e.CreatedAt, User: e.Payload})
+	if err != nil {
+		return err
+	}
+	return q.webhooks.Enqueue(ctx, e.Id, e.Event, payload)
+}
+
+// how often the queue is checked for due deliveries, and how many are claimed at once
+const (
+	pollInterval = time.Second
+	batchSize    = 20
+)
+
+// the wait before the first retry, doubling with each one up to maxBackoff
+const (
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+)
+
+// Dispatcher sends the deliveries queued in a store.Webhooks. any number of dispatchers can share
+// one queue, as each claims the deliveries it sends
+type Dispatcher struct {
+	queue       store.Webhooks
+	client      *http.Client
+	maxAttempts int
+}
+
+// NewDispatcher sends each delivery up to maxAttempts times, giving receivers timeout to answer.
+// unless allowPrivate is set, it refuses to connect to the addresses CheckURL refuses, whatever the
+// receiver's host resolves to by then, and connects directly rather than through any proxy the
+// environment names, whose own address would be the one checked.
+func NewDispatcher(queue store.Webhooks, maxAttempts int, timeout time.Duration, allowPrivate bool) *Dispatcher {
+	client := &http.Client{
+		Timeout: timeout,
+		// a redirect counts as a failed attempt rather than re-sending the signed body elsewhere
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	if !allowPrivate {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: dialControl}).DialContext
+		client.Transport = transport
+	}
+	return &Dispatcher{queue: queue, client: client, maxAttempts: maxAttempts}
+}
+
+// Run sends due deliveries until ctx is done, then waits for the attempts already under way.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		// keep claiming while full batches come back, so a backlog drains without waiting on the ticker
+		for d.sendDue(ctx) == batchSize && ctx.Err() == nil {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim a batch of due deliveries and attempt each, returning how many were claimed
+func (d *Dispatcher) sendDue(ctx context.Context) int {
+	// attempts outlive shutdown starting, so one isn't cut off and counted against the delivery
+	ctx = context.WithoutCancel(ctx)
+	due, err := d.queue.ClaimDue(ctx, batchSize, d.client.Timeout+time.Minute)
+	if err != nil {
+		slog.ErrorContext(ctx, "claim webhook deliveries failed", "err", err)
+		return 0
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt := d.attempt(ctx, delivery)
+			if err := d.queue.RecordAttempt(ctx, delivery.Id, attempt); err != nil {
+				slog.ErrorContext(ctx, "record webhook delivery failed", "err", err, "delivery_id", delivery.Id)
+			}
+		}()
+	}
+	wg.Wait()
+	return len(due)
+}
+
+// send a delivery once, scheduling a retry with backoff on failure until its attempts run out
+func (d *Dispatcher) attempt(ctx context.Context, delivery models.DueDelivery) models.DeliveryAttempt {
+	status, err := d.send(ctx, delivery)
+	if err == nil {
+		return models.DeliveryAttempt{Status: models.DeliverySucceeded, StatusCode: status}
+	}
+	attempts := delivery.Attempts + 1
+	slog.WarnContext(ctx, "webhook delivery failed", "err", err, "delivery_id", delivery.Id, "webhook_id", delivery.WebhookId, "attempt", attempts)
+	result := models.DeliveryAttempt{Status: models.DeliveryFailed, StatusCode: status, Error: err.Error()}
+	if attempts < d.maxAttempts {
+		result.Status = models.DeliveryPending
+		result.NextAttemptAt = time.Now().Add(backoff(attempts))
+	}
+	return result
+}
+
+// the wait after the given number of failed attempts
+func backoff(attempts int) time.Duration {
+	wait := baseBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
+
+// POST the payload, reporting the status the receiver answered with, 0 when none arrived
+func (d *Dispatcher) send(ctx context.Context, delivery models.DueDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-fullstack-app-webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.Id, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"api/internal/middleware"
+)
+
+// install a JSON logger on stdout as the slog default, tagging lines logged during a request with its id;
+// unknown or empty levels fall back to info
+func setupLogger(level string) {
+	var l slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		l = slog.LevelDebug
+	case "warn", "warning":
+		l = slog.LevelWarn
+	case "error":
+		l = slog.LevelError
+	default:
+		l = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(middleware.RequestIDLogHandler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: l}))))
+}
+
+// log at error level and exit, the slog counterpart of log.Fatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -1,183 +1,596 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
-	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
+	"api/config"
+	"api/internal/events"
+	"api/internal/handlers"
+	"api/internal/i18n"
+	"api/internal/jobs"
+	"api/internal/ldapauth"
+	"api/internal/mail"
+	"api/internal/maintenance"
+	"api/internal/middleware"
+	"api/internal/models"
+	"api/internal/oauth"
+	"api/internal/outbox"
+	"api/internal/pii"
+	"api/internal/storage"
+	"api/internal/store"
+	"api/internal/webhooks"
+	"api/web"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
-type User struct {
-	Id       int    `json:"id"`
-	Name		 string `json:"name"`
-	Email    string `json:"email"`
-}
+// injected at build time with -ldflags "-X main.version=<version> -X main.commit=<sha> -X main.buildTime=<RFC 3339>";
+// the commit and build time otherwise come from the binary's version control stamp
+var (
+	version   = "dev"
+	commit    string
+	buildTime string
+)
 
 // main function
 func main() {
-	//connect to database
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Fatal(err)
+	// flags, env vars and the optional config file, validated up front
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, config.ErrHelp) {
+		return
+	} else if err != nil {
+		setupLogger("info")
+		fatal("invalid configuration", "err", err)
 	}
-	defer db.Close()
 
-	// create table if not exists
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS users (id SERIAL PRIMARY KEY, name TEXT, email TEXT)")
+	// JSON logs on stdout at the configured level
+	setupLogger(cfg.LogLevel)
+	build := handlers.ReadBuild(version, commit, buildTime)
+	slog.Info("starting", "version", build.Version, "commit", build.Commit, "build_time", build.BuildTime)
+	slog.Info("effective config", "config", cfg)
+
+	// OTLP tracing, configured with the standard OTEL_* environment variables
+	shutdownTracing, err := setupTracing(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		fatal("set up tracing", "err", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	// create router
-	router := mux.NewRouter()
-	router.HandleFunc("/api/go/users", getUsers(db)).Methods("GET")
-	router.HandleFunc("/api/go/users", createUser(db)).Methods("POST")
-	router.HandleFunc("/api/go/users/{id}", getUser(db)).Methods("GET")
-	router.HandleFunc("/api/go/users/{id}", updateUser(db)).Methods("PUT")
-	router.HandleFunc("/api/go/users/{id}", deleteUser(db)).Methods("DELETE")
-
-	// wrap the router with CORS and JSON content type middlewares
-	enhancedRouter := enableCORS(jsonContentTypeMiddleware(router))
-
-	// start server
-	log.Fatal(http.ListenAndServe(":8000", enhancedRouter))
-}
-
-func enableCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow any origin
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		// Check if the request is for CORS preflight
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+	// users are kept in Postgres, along with everything else, unless the sqlite driver keeps them in
+	// a database file or the memory driver in process memory; the features needing Postgres are then
+	// left out
+	postgres := cfg.DatabaseDriver == "postgres"
+	sqlite := cfg.DatabaseDriver == "sqlite"
+	var db *sql.DB
+	var pool *pgxpool.Pool
+	var replicaDBs []*sql.DB
+	// user emails and phone numbers are encrypted at rest once keys are configured
+	var piiKeys *pii.Keyring
+	if cfg.PIIKeys != "" {
+		if piiKeys, err = pii.NewKeyring(cfg.PIIKeys, cfg.PIIIndexKey); err != nil {
+			fatal("invalid PII_KEYS", "err", err)
 		}
-
-		// Pass down the request to the next middleware (or final handler)
-		next.ServeHTTP(w, r)
-	})
-
-}
-
-func jsonContentTypeMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set JSON Content-Type
-		w.Header().Set("Content-Type", "application/json")
-		next.ServeHTTP(w, r)
-	})
-}
-
-// get all users
-func getUsers(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT * FROM users")
+	}
+	if postgres {
+		//connect to database, failing queries fast while it keeps failing
+		var breaker *store.Breaker
+		if cfg.DBBreakerFailures > 0 {
+			breaker = store.NewBreaker(cfg.DBBreakerFailures, cfg.DBBreakerCooldown)
+		}
+		db, pool, err = store.Open(cfg.DatabaseURL, store.PoolOptions{
+			MaxConns:        cfg.DBMaxOpenConns,
+			MinIdleConns:    cfg.DBMinIdleConns,
+			MaxConnLifetime: cfg.DBConnMaxLifetime,
+			MaxConnIdleTime: cfg.DBConnMaxIdleTime,
+			Breaker:         breaker,
+		})
 		if err != nil {
-			log.Fatal(err)
+			fatal("open database", "err", err)
+		}
+		defer pool.Close()
+		defer db.Close()
+		// user reads and searches that write nothing are spread over any read replicas, which aren't
+		// waited for: reads fall back to the primary while a replica is down
+		for _, url := range cfg.DatabaseReplicas {
+			replica, replicaPool, err := store.Open(url, store.PoolOptions{
+				MaxConns:        cfg.DBMaxOpenConns,
+				MinIdleConns:    cfg.DBMinIdleConns,
+				MaxConnLifetime: cfg.DBConnMaxLifetime,
+				MaxConnIdleTime: cfg.DBConnMaxIdleTime,
+			})
+			if err != nil {
+				fatal("open read replica", "err", err)
+			}
+			defer replicaPool.Close()
+			defer replica.Close()
+			replicaDBs = append(replicaDBs, replica)
+		}
+		// Postgres may still be starting, as when both come up together
+		if err := store.WaitForDB(context.Background(), db, cfg.DBConnectTimeout); err != nil {
+			fatal("connect to database", "err", err, "waited", cfg.DBConnectTimeout)
 		}
-		defer rows.Close()
 
-		users := []User{} // array of users
-		for rows.Next() {
-			var u User
-			if err := rows.Scan(&u.Id, &u.Name, &u.Email); err != nil {
-				log.Fatal(err)
+		// --migrate runs a single migration command instead of the server
+		if cfg.Migrate != "" {
+			if err := store.Migrate(context.Background(), db, cfg.Migrate); err != nil {
+				fatal("migrate", "err", err, "command", cfg.Migrate)
 			}
-			users = append(users, u)
+			return
 		}
-		if err := rows.Err(); err != nil {
-			log.Fatal(err)
+		// bring the schema up to date
+		if cfg.AutoMigrate {
+			if err := store.Migrate(context.Background(), db, "up"); err != nil {
+				fatal("apply migrations", "err", err)
+			}
 		}
 
-		json.NewEncoder(w).Encode(users)
+		// --reencrypt-pii moves existing rows to the current key instead of running the server
+		if cfg.ReencryptPII {
+			if err := store.ReencryptPII(context.Background(), db, piiKeys); err != nil {
+				fatal("re-encrypt personal data", "err", err)
+			}
+			return
+		}
+	}
+	if sqlite {
+		if db, err = store.OpenSQLite(cfg.DatabaseURL); err != nil {
+			fatal("open database", "err", err)
+		}
+		defer db.Close()
+		// --migrate runs a single migration command instead of the server
+		if cfg.Migrate != "" {
+			if err := store.MigrateSQLite(context.Background(), db, cfg.Migrate); err != nil {
+				fatal("migrate", "err", err, "command", cfg.Migrate)
+			}
+			return
+		}
+		if cfg.AutoMigrate {
+			if err := store.MigrateSQLite(context.Background(), db, "up"); err != nil {
+				fatal("apply migrations", "err", err)
+			}
+		}
 	}
-}
-
-//get user by id
-func getUser(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
 
-		var u User
-		err := db.QueryRow("SELECT * FROM users WHERE id = $1", id).Scan(&u.Id, &u.Name, &u.Email)
+	// optional Redis, shared by the rate limiter and the user cache and reported by the readiness check
+	var rdb *redis.Client
+	if cfg.RedisURL != "" {
+		opts, err := redis.ParseURL(cfg.RedisURL)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
-			return
+			fatal("invalid REDIS_URL", "err", err)
 		}
+		rdb = redis.NewClient(opts)
+	}
 
-		json.NewEncoder(w).Encode(u)
+	// live feed of user changes for the stream, event and WebSocket endpoints
+	feed := handlers.NewFeed()
+
+	// readiness checks for every configured dependency; the database's fails as soon as the
+	// monitor notices it is gone, until it is back
+	checks := map[string]func(context.Context) error{}
+	var dbMonitor *store.DBMonitor
+	if postgres {
+		dbMonitor = store.NewDBMonitor(db, pool, cfg.DBHealthInterval)
+		checks["database"] = dbMonitor.Check
+	} else if sqlite {
+		checks["database"] = db.PingContext
+	}
+	if rdb != nil {
+		checks["redis"] = func(ctx context.Context) error { return rdb.Ping(ctx).Err() }
 	}
-}
 
-// create user
-func createUser(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var u User
-		json.NewDecoder(r.Body).Decode(&u)
+	// uploaded avatars live on local disk, served from /uploads, or in an S3 bucket clients read directly.
+	// the files of user exports are only served through the API: on disk they are kept apart, in S3
+	// under keys the bucket is expected not to make public
+	var avatars, exportFiles storage.Storage
+	var localFiles *storage.Local
+	if cfg.StorageBackend == "s3" {
+		avatars, err = storage.NewS3(context.Background(), cfg.StorageS3Bucket, cfg.StorageBaseURL)
+		exportFiles = avatars
+	} else if localFiles, err = storage.NewLocal(cfg.StorageDir, cmp.Or(cfg.StorageBaseURL, "/uploads")); err == nil {
+		avatars = localFiles
+		exportFiles, err = storage.NewLocal(cfg.ExportDir, "")
+	}
+	if err != nil {
+		fatal("set up file storage", "err", err, "backend", cfg.StorageBackend)
+	}
 
-		err := db.QueryRow("INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id", u.Name, u.Email).Scan(&u.Id)
-		if err != nil {
-			log.Fatal(err)
+	// every handler reads and writes users through the repository rather than raw SQL
+	var users store.UserRepository = store.NewMemoryUserRepository()
+	var auditLog store.AuditLog
+	if postgres {
+		users = store.NewPostgresUserRepository(db, store.NewReplicas(replicaDBs...), piiKeys)
+		// every write is recorded with who made it; wrapped inside the cache so cached reads skip it
+		auditLog = store.NewPostgresAuditLog(db, piiKeys)
+		users = store.NewAuditedUserRepository(users, auditLog, func(ctx context.Context) (int, int, string, string) {
+			actorID, _ := middleware.UserID(ctx)
+			_, impersonatorID, _ := middleware.Impersonation(ctx)
+			return actorID, impersonatorID, middleware.RequestID(ctx), middleware.ClientIPFromContext(ctx)
+		})
+	} else if sqlite {
+		users = store.NewSQLiteUserRepository(db, piiKeys)
+	}
+	// read-through cache for user lookups and lists, invalidated on every write.
+	// Redis shares it across replicas; without Redis each process keeps its own LRU, which evicts
+	// the users other replicas change as Postgres announces them
+	var changeListener *store.UserChangeListener
+	if cfg.CacheTTL > 0 {
+		var cache store.Cache = store.NewMemoryCache(cfg.CacheSize)
+		if rdb != nil {
+			cache = store.NewRedisCache(rdb)
+		}
+		cached := store.NewCachedUserRepository(users, cache, cfg.CacheTTL)
+		if rdb == nil && postgres {
+			changeListener = store.NewUserChangeListener(pool, cached)
+		}
+		users = cached
+	}
+	// responses to retried creates are replayed for as long as their Idempotency-Key is kept
+	var idempotency store.IdempotencyKeys
+	if cfg.IdempotencyTTL > 0 && postgres {
+		idempotency = store.NewPostgresIdempotencyKeys(db, cfg.IdempotencyTTL)
+	}
+	// every committed change to a user leaves an event in the outbox, which the relay started below
+	// publishes to webhook subscriptions, for the dispatcher to send their deliveries,
+	var webhookStore store.Webhooks
+	var publishers []outbox.Publisher
+	if cfg.WebhookMaxAttempts > 0 && postgres {
+		webhookStore = store.NewPostgresWebhooks(db)
+		publishers = append(publishers, webhooks.NewQueue(webhookStore))
+	}
+	// and to the message broker, when one is configured
+	var broker events.Broker
+	switch cfg.EventsBroker {
+	case "nats":
+		if broker, err = events.NewNATS(cfg.EventsURL, cfg.EventsTopic); err != nil {
+			fatal("connect to NATS", "err", err)
+		}
+	case "kafka":
+		broker = events.NewKafka(cfg.EventsURL, cfg.EventsTopic)
+	}
+	if broker != nil {
+		publishers = append(publishers, events.NewPublisher(broker, cfg.EventsFormat))
+	}
+	// slow work such as sending email runs on the job queue's workers, started below
+	var mailer mail.Sender = mail.LogSender{}
+	if cfg.SMTPHost != "" {
+		mailer = mail.NewSMTPSender(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	}
+	mailer = mail.RespectPreferences(mailer, users)
+	var jobStore store.Jobs
+	var jobPool *jobs.Pool
+	if cfg.JobWorkers > 0 && postgres {
+		jobStore = store.NewPostgresJobs(db)
+		jobPool = jobs.NewPool(jobStore, cfg.JobWorkers, cfg.JobRetention)
+		mailer = jobPool.Mailer(mailer)
+	}
+	// users are told of changes to their account, unless they opted out
+	publishers = append(publishers, mail.NewChangeNotifier(mailer))
+	var directory *ldapauth.Authenticator
+	if cfg.AuthBackend == "ldap" {
+		directory = ldapauth.NewAuthenticator(ldapauth.Config{
+			URL:            cfg.LDAPURL,
+			StartTLS:       cfg.LDAPStartTLS,
+			BindDN:         cfg.LDAPBindDN,
+			BindPassword:   cfg.LDAPBindPassword,
+			BaseDN:         cfg.LDAPBaseDN,
+			UserFilter:     cfg.LDAPUserFilter,
+			EmailAttribute: cfg.LDAPEmailAttribute,
+			NameAttribute:  cfg.LDAPNameAttribute,
+			Timeout:        cfg.LDAPTimeout,
+		})
+	}
+	// each provider sends users back to the v1 callback, which is what its client must have registered
+	oauthProviders := map[string]*oauth.Provider{}
+	callbackURL := func(provider string) string {
+		return strings.TrimSuffix(cfg.PublicURL, "/") + "/api/v1/auth/" + provider + "/callback"
+	}
+	if cfg.OAuthGoogleClientID != "" {
+		oauthProviders[oauth.ProviderGoogle] = oauth.Google(cfg.OAuthGoogleClientID, cfg.OAuthGoogleClientSecret, callbackURL(oauth.ProviderGoogle))
+	}
+	if cfg.OAuthGitHubClientID != "" {
+		oauthProviders[oauth.ProviderGitHub] = oauth.GitHub(cfg.OAuthGitHubClientID, cfg.OAuthGitHubClientSecret, callbackURL(oauth.ProviderGitHub))
+	}
+	// the documents users are asked to accept, those without a version left out
+	legalDocuments := []models.LegalDocument{}
+	for _, d := range []models.LegalDocument{
+		{Document: models.DocumentTerms, Version: cfg.TermsVersion, URL: cfg.TermsURL},
+		{Document: models.DocumentPrivacy, Version: cfg.PrivacyVersion, URL: cfg.PrivacyURL},
+	} {
+		if d.Version != "" {
+			legalDocuments = append(legalDocuments, d)
 		}
+	}
+	opts := handlers.Options{
+		Build:                     build,
+		NormalizeNames:            cfg.NormalizeNames,
+		SearchSimilarityThreshold: cfg.SearchSimilarityThreshold,
+		Checks:                    checks,
+		Avatars:                   avatars,
+		DefaultAvatar:             cfg.DefaultAvatar,
+		MaskedFields:              cfg.MaskedUserFields,
+		PublicURL:                 cfg.PublicURL,
+		PasswordResetURL:          cfg.PasswordResetURL,
+		InvitationURL:             cfg.InvitationURL,
+		Mailer:                    mailer,
+		Audit:                     auditLog,
+		Idempotency:               idempotency,
+		RequestTimeout:            cfg.RequestTimeout,
+		MaxBodySize:               int64(cfg.MaxBodySize),
+		Webhooks:                  webhookStore,
+		WebhookAllowPrivate:       cfg.WebhookAllowPrivate,
+		Jobs:                      jobStore,
+		LockoutThreshold:          cfg.LockoutThreshold,
+		LockoutDuration:           cfg.LockoutDuration,
+		OAuthProviders:            oauthProviders,
+		OAuthRedirectURL:          cfg.OAuthRedirectURL,
+		Directory:                 directory,
+		LegalDocuments:            legalDocuments,
+		RequireConsent:            cfg.RequireConsent,
+		JobPool:                   jobPool,
+		PasswordPolicy: handlers.PasswordPolicy{
+			MinLength:       cfg.PasswordMinLength,
+			RequiredClasses: cfg.PasswordRequiredClasses,
+			BlockCommon:     cfg.PasswordBlockCommon,
+			BlockEmail:      cfg.PasswordBlockEmail,
+		},
+		Debug: cfg.DebugEndpoints,
+	}
+	if postgres {
+		opts.DBStats = pool.Stat
+		opts.Tenants = store.NewPostgresTenants(db)
+		opts.Organizations = store.NewPostgresOrganizations(db, piiKeys)
+		opts.Posts = store.NewPostgresPosts(db)
+		opts.FeatureFlags = store.NewPostgresFeatureFlags(db)
+		opts.Consents = store.NewPostgresConsents(db)
+		opts.Exports = store.NewPostgresExports(db)
+		opts.UserExports = store.NewPostgresUserExports(db)
+		opts.ExportFiles = exportFiles
+	}
+	app := handlers.NewApp(users, feed, []byte(cfg.JWTSecret), opts)
 
-		json.NewEncoder(w).Encode(u)
+	// create router
+	router := app.Router()
+	router.Use(otelmux.Middleware(tracingServiceName), middleware.Metrics)
+	// Prometheus scrape endpoint
+	collectors := append(maintenance.Collectors, store.CacheLookups)
+	if postgres {
+		collectors = append(collectors, store.PoolCollector(pool))
+	}
+	router.Handle("/metrics", middleware.MetricsHandler(middleware.NewMetricsRegistry(collectors...))).Methods("GET")
+	if localFiles != nil {
+		router.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads", localFiles.Handler())).Methods("GET")
+	}
+	// the embedded frontend takes every other path, registered last so it never shadows a route
+	if frontend, ok := web.Handler(router.NotFoundHandler); ok && cfg.ServeFrontend {
+		router.PathPrefix("/").Handler(frontend).Methods("GET")
+		slog.Info("serving the embedded frontend")
 	}
-}
 
-//update user
-func updateUser(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var u User
-		json.NewDecoder(r.Body).Decode(&u)
+	// per-IP rate limit; a zero rate disables it.
+	// with Redis configured the limit is shared across replicas, otherwise each process keeps its own buckets
+	var handler http.Handler = router
+	if cfg.RateLimitRPS > 0 {
+		var limiter middleware.RateLimiter = middleware.NewIPRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+		if rdb != nil {
+			limiter = middleware.NewRedisRateLimiter(rdb, cfg.RateLimitRPS, cfg.RateLimitBurst)
+		}
+		handler = middleware.RateLimit(limiter)(router)
+	}
+	if cfg.CompressionMinSize >= 0 {
+		handler = middleware.Compress(cfg.CompressionMinSize)(handler)
+	}
 
-		vars := mux.Vars(r)
-		id := vars["id"]
+	corsOptions := middleware.CORSOptions{
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowedMethods:   cfg.CORSAllowedMethods,
+		AllowedHeaders:   cfg.CORSAllowedHeaders,
+		ExposedHeaders:   cfg.CORSExposedHeaders,
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           cfg.CORSMaxAge,
+	}
+	securityHeaders := middleware.SecurityHeaders(middleware.SecurityHeaderOptions{
+		ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+		HSTSMaxAge:            cfg.HSTSMaxAge,
+	})
+	// client addresses come from the forwarding headers of trusted proxies only
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		fatal("invalid TRUSTED_PROXIES", "err", err)
+	}
+	// error messages in the built-in languages and any catalogs of LOCALES_DIR
+	catalogs, err := i18n.NewBundle(i18n.Builtin, i18n.Dir(cfg.LocalesDir))
+	if err != nil {
+		fatal("load message catalogs", "err", err)
+	}
+	i18n.Install(catalogs)
+	slog.Info("error messages localized", "languages", catalogs.Languages())
+	// wrap the router with client address resolution, the version header, access logging, panic recovery, content and language negotiation, security headers, CORS and CSRF checks.
+	// negotiation comes early so errors from the middlewares inside it are written in the client's format and language too
+	enhancedRouter := middleware.RealIP(trustedProxies)(middleware.ServerVersion(build.Version)(middleware.AccessLog(middleware.Recover(middleware.Negotiate(middleware.Localize(securityHeaders(middleware.CORS(corsOptions)(middleware.CSRF([]byte(cfg.JWTSecret))(handler)))))))))
 
-		// Execute the update query
-		_, err := db.Exec("UPDATE users SET name = $1, email = $2 WHERE id = $3", u.Name, u.Email, id)
-		if err != nil {
-			log.Fatal(err)
-		}
+	// internal gRPC API, meant for other services on the private network rather than the public internet
+	grpcListener, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		fatal("listen for gRPC", "err", err, "addr", cfg.GRPCAddr)
+	}
+	grpcServer := app.GRPCServer()
 
-		// Retrieve the updated user data from the database
-		var updatedUser User
-		err = db.QueryRow("SELECT id, name, email FROM users WHERE id = $1", id).Scan(&updatedUser.Id, &updatedUser.Name, &updatedUser.Email)
-		if err != nil {
-			log.Fatal(err)
+	// HTTPS with the configured certificate or ones from Let's Encrypt, plain HTTP without either
+	tlsConfig, redirectHandler, err := setupTLS(cfg)
+	if err != nil {
+		fatal("load TLS certificate", "err", err, "cert", cfg.TLSCertFile, "key", cfg.TLSKeyFile)
+	}
+	srv := &http.Server{
+		Addr:         cfg.HTTPAddr,
+		Handler:      enhancedRouter,
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+	var redirectSrv *http.Server
+	if cfg.TLSRedirectAddr != "" {
+		redirectSrv = &http.Server{
+			Addr:         cfg.TLSRedirectAddr,
+			Handler:      redirectHandler,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
 		}
-
-		// Send the updated user data in the response
-		json.NewEncoder(w).Encode(updatedUser)
 	}
-}
+	// profiles over the loopback listener aren't cut short by the write timeout, nor need a token
+	var debugSrv *http.Server
+	if cfg.DebugAddr != "" {
+		debugSrv = &http.Server{
+			Addr:              cfg.DebugAddr,
+			Handler:           handlers.DebugHandler(),
+			ReadHeaderTimeout: cfg.ReadTimeout,
+		}
+	}
+	// streams and sockets never finish on their own, so end them when shutdown begins
+	srv.RegisterOnShutdown(feed.Close)
 
-// delete user
-func deleteUser(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		var u User
-		err := db.QueryRow("SELECT * FROM users WHERE id = $1", id).Scan(&u.Id, &u.Name, &u.Email)
-		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+	// start servers
+	serverErr := make(chan error, 4)
+	go func() {
+		slog.Info("gRPC server listening", "addr", cfg.GRPCAddr)
+		serverErr <- grpcServer.Serve(grpcListener)
+	}()
+	go func() {
+		if tlsConfig != nil {
+			slog.Info("HTTPS server listening", "addr", srv.Addr)
+			serverErr <- srv.ListenAndServeTLS("", "")
 			return
-		} else {
-			_, err := db.Exec("DELETE FROM users WHERE id = $1", id)
-			if err != nil {
-				//todo : fix error handling
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
+		}
+		slog.Info("HTTP server listening", "addr", srv.Addr)
+		serverErr <- srv.ListenAndServe()
+	}()
+	if redirectSrv != nil {
+		go func() {
+			slog.Info("HTTP redirect listening", "addr", redirectSrv.Addr)
+			serverErr <- redirectSrv.ListenAndServe()
+		}()
+	}
+	if debugSrv != nil {
+		go func() {
+			slog.Info("debug server listening", "addr", debugSrv.Addr)
+			serverErr <- debugSrv.ListenAndServe()
+		}()
+	}
+	// clean-up on the schedules configured, of what Postgres keeps
+	scheduler := maintenance.NewScheduler()
+	if postgres {
+		maint := store.NewPostgresMaintenance(db)
+		if cfg.PurgeDeletedUsersAfter > 0 {
+			scheduler.Every("purge_deleted_users", cfg.PurgeDeletedUsersInterval, func(ctx context.Context) (int64, error) {
+				return maint.PurgeDeletedUsers(ctx, time.Now().Add(-cfg.PurgeDeletedUsersAfter))
+			})
+		}
+		scheduler.Every("delete_stale_reset_tokens", cfg.ResetTokenCleanupInterval, maint.DeleteStaleResetTokens)
+		scheduler.Every("delete_expired_sessions", cfg.SessionCleanupInterval, maint.DeleteExpiredSessions)
+		if cfg.LoginHistoryRetention > 0 {
+			scheduler.Every("delete_old_logins", time.Hour, func(ctx context.Context) (int64, error) {
+				return maint.DeleteLoginEvents(ctx, time.Now().Add(-cfg.LoginHistoryRetention))
+			})
+		}
+		scheduler.Every("delete_expired_user_exports", time.Hour, app.DeleteExpiredUserExports)
+		scheduler.Every("delete_expired_data_exports", time.Hour, maint.DeleteExpiredDataExports)
+		if cfg.WebhookDeliveryRetention > 0 {
+			scheduler.Every("delete_old_webhook_deliveries", time.Hour, func(ctx context.Context) (int64, error) {
+				return maint.DeleteWebhookDeliveries(ctx, time.Now().Add(-cfg.WebhookDeliveryRetention))
+			})
+		}
+	}
 
-			json.NewEncoder(w).Encode("User deleted")
+	// background workers stop when shutdown begins
+	var workers sync.WaitGroup
+	if postgres {
+		relay := outbox.NewRelay(store.NewPostgresOutbox(db, piiKeys), cfg.OutboxRetention, publishers...)
+		workers.Go(func() { relay.Run(ctx) })
+		workers.Go(func() { dbMonitor.Run(ctx) })
+	}
+	if jobPool != nil {
+		workers.Go(func() { jobPool.Run(ctx) })
+	}
+	if changeListener != nil {
+		workers.Go(func() { changeListener.Run(ctx) })
+	}
+	workers.Go(func() { scheduler.Run(ctx) })
+	if webhookStore != nil {
+		workers.Go(func() {
+			webhooks.NewDispatcher(webhookStore, cfg.WebhookMaxAttempts, cfg.WebhookTimeout, cfg.WebhookAllowPrivate).Run(ctx)
+		})
+	}
+	workersStopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersStopped)
+	}()
+
+	select {
+	case err := <-serverErr:
+		fatal("server stopped", "err", err)
+	case <-ctx.Done():
+	}
+	stop()
+	slog.Info("shutting down", "timeout", cfg.ShutdownTimeout.String())
+
+	// stop accepting connections and drain both servers, forcing them closed once the timeout passes
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	if redirectSrv != nil {
+		go redirectSrv.Shutdown(shutdownCtx)
+	}
+	// a profile under way isn't worth waiting for
+	if debugSrv != nil {
+		debugSrv.Close()
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("HTTP server did not drain in time", "err", err)
+		srv.Close()
+	}
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		slog.Warn("gRPC server did not drain in time")
+		grpcServer.Stop()
+	}
+	// events and deliveries cut off here are relayed again, or claimed again once their lease runs out
+	select {
+	case <-workersStopped:
+	case <-shutdownCtx.Done():
+		slog.Warn("background workers did not finish in time")
+	}
+	if broker != nil {
+		if err := broker.Close(); err != nil {
+			slog.Warn("close events broker", "err", err)
 		}
 	}
-}
\ No newline at end of file
+	if rdb != nil {
+		rdb.Close()
+	}
+	// the deferred db.Close and tracing flush run as main returns
+	slog.Info("shutdown complete")
+}
@@ -0,0 +1,10 @@
+-- +goose Up
+-- full-text search over name and email, splitting addresses so "example" matches "john@example.com"
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple',
+        coalesce(name, '') || ' ' || coalesce(email, '') || ' ' || translate(coalesce(email, ''), '@.', '  '))) STORED;
+CREATE INDEX IF NOT EXISTS users_search_vector_idx ON users USING GIN (search_vector);
+
+-- +goose Down
+DROP INDEX IF EXISTS users_search_vector_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- keyset pagination walks users in (created_at, id) order
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
+
+-- +goose Down
+DROP INDEX IF EXISTS users_created_at_id_idx;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- only a hash of each token is stored, so a leaked table can't be used to reset passwords
+CREATE TABLE IF NOT EXISTS password_resets (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash BYTEA NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS password_resets_user_id_idx ON password_resets (user_id);
+-- access tokens issued before this moment are rejected, e.g. after a password reset
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS tokens_valid_after;
+DROP TABLE IF EXISTS password_resets;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- the secret is set on enrollment and only takes effect once totp_enabled_at is set by confirming a code
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMPTZ;
+-- last accepted time step, so a code can't be replayed within its validity window
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
+CREATE TABLE IF NOT EXISTS totp_backup_codes (
+    id        BIGSERIAL PRIMARY KEY,
+    user_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash BYTEA NOT NULL,
+    used_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS totp_backup_codes_user_id_idx ON totp_backup_codes (user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS totp_backup_codes;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled_at;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
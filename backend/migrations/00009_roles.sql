@@ -0,0 +1,30 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS roles (
+    name TEXT PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role       TEXT NOT NULL REFERENCES roles (name) ON UPDATE CASCADE ON DELETE CASCADE,
+    permission TEXT NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+INSERT INTO roles (name) VALUES ('admin'), ('user') ON CONFLICT DO NOTHING;
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'users:read'),
+    ('admin', 'users:write'),
+    ('admin', 'users:delete'),
+    ('admin', 'roles:manage'),
+    ('user', 'users:read')
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user' REFERENCES roles (name) ON UPDATE CASCADE;
+CREATE INDEX IF NOT EXISTS users_role_idx ON users (role);
+-- existing deployments keep someone able to manage roles: the oldest live user becomes an admin
+UPDATE users SET role = 'admin'
+WHERE id = (SELECT id FROM users WHERE deleted_at IS NULL ORDER BY id LIMIT 1)
+  AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin');
+
+-- +goose Down
+DROP INDEX IF EXISTS users_role_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
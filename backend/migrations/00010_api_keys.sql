@@ -0,0 +1,16 @@
+-- +goose Up
+-- keys act as their owner; only a hash is stored, plus a prefix so owners can tell their keys apart
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    prefix       TEXT NOT NULL,
+    key_hash     BYTEA NOT NULL UNIQUE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS api_keys;
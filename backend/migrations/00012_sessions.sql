@@ -0,0 +1,29 @@
+-- +goose Up
+-- a session is one login on one device; its refresh token family is what keeps it going
+CREATE TABLE IF NOT EXISTS sessions (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent   TEXT NOT NULL DEFAULT '',
+    ip           TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+-- existing refresh token families become sessions with the same ids
+INSERT INTO sessions (id, user_id, created_at, last_seen_at, revoked_at)
+SELECT family_id, user_id, min(created_at), max(created_at), CASE WHEN bool_and(revoked_at IS NOT NULL) THEN max(revoked_at) END
+FROM refresh_tokens GROUP BY family_id, user_id
+ON CONFLICT DO NOTHING;
+SELECT setval(pg_get_serial_sequence('sessions', 'id'), (SELECT COALESCE(max(id), 0) + 1 FROM sessions), false);
+
+ALTER TABLE refresh_tokens RENAME COLUMN family_id TO session_id;
+ALTER INDEX IF EXISTS refresh_tokens_family_id_idx RENAME TO refresh_tokens_session_id_idx;
+ALTER TABLE refresh_tokens ADD CONSTRAINT refresh_tokens_session_id_fkey FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE;
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS refresh_tokens_session_id_fkey;
+ALTER INDEX IF EXISTS refresh_tokens_session_id_idx RENAME TO refresh_tokens_family_id_idx;
+ALTER TABLE refresh_tokens RENAME COLUMN session_id TO family_id;
+DROP TABLE IF EXISTS sessions;
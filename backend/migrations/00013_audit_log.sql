@@ -0,0 +1,21 @@
+-- +goose Up
+-- append-only record of every change; no foreign keys, so entries outlive what they describe
+CREATE TABLE IF NOT EXISTS audit_log (
+    id          BIGSERIAL PRIMARY KEY,
+    actor_id    INTEGER,
+    action      TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id   INTEGER NOT NULL,
+    before      JSONB,
+    after       JSONB,
+    request_id  TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity_type, entity_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id, created_at DESC);
+
+INSERT INTO role_permissions (role, permission) VALUES ('admin', 'audit:read') ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'audit:read';
+DROP TABLE IF EXISTS audit_log;
@@ -0,0 +1,58 @@
+-- +goose Up
+-- a snapshot of the public fields of every version of a user, numbered from 1 per user.
+-- written by a trigger so every path that changes a user, SQL included, leaves a revision
+CREATE TABLE IF NOT EXISTS user_revisions (
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    rev        INTEGER NOT NULL,
+    snapshot   JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, rev)
+);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_revision() RETURNS trigger AS $$
+BEGIN
+    -- the row lock held by the write serializes revisions of the same user
+    INSERT INTO user_revisions (user_id, rev, snapshot)
+    SELECT NEW.id, COALESCE(max(rev), 0) + 1, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role)
+    FROM user_revisions WHERE user_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- secrets and bookkeeping such as password hashes and token revocations are not revisions
+CREATE TRIGGER users_record_revision_insert AFTER INSERT ON users
+    FOR EACH ROW EXECUTE FUNCTION record_user_revision();
+CREATE TRIGGER users_record_revision_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role))
+    EXECUTE FUNCTION record_user_revision();
+
+-- existing users start from their current state
+INSERT INTO user_revisions (user_id, rev, snapshot, created_at)
+SELECT id, 1, jsonb_build_object(
+    'id', id,
+    'name', name,
+    'email', email,
+    'created_at', created_at,
+    'deleted_at', deleted_at,
+    'avatar_url', avatar_url,
+    'email_verified_at', email_verified_at,
+    'role', role), created_at
+FROM users
+ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DROP TRIGGER IF EXISTS users_record_revision_update ON users;
+DROP TRIGGER IF EXISTS users_record_revision_insert ON users;
+DROP FUNCTION IF EXISTS record_user_revision();
+DROP TABLE IF EXISTS user_revisions;
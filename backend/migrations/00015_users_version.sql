@@ -0,0 +1,23 @@
+-- +goose Up
+-- bumped on every change a client can see, so a stale copy can't overwrite a newer one
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION bump_user_version() RETURNS trigger AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- the same fields that start a new revision
+CREATE TRIGGER users_bump_version BEFORE UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role))
+    EXECUTE FUNCTION bump_user_version();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS users_bump_version ON users;
+DROP FUNCTION IF EXISTS bump_user_version();
+ALTER TABLE users DROP COLUMN IF EXISTS version;
@@ -0,0 +1,67 @@
+-- +goose Up
+-- when the user last changed in a way clients can see, for Last-Modified
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+UPDATE users SET updated_at = COALESCE(deleted_at, created_at) WHERE updated_at IS NULL;
+ALTER TABLE users ALTER COLUMN updated_at SET DEFAULT now();
+ALTER TABLE users ALTER COLUMN updated_at SET NOT NULL;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION bump_user_version() RETURNS trigger AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    NEW.updated_at := now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- revisions snapshot the new columns too; version and updated_at are set by the time AFTER triggers run
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_revision() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO user_revisions (user_id, rev, snapshot)
+    SELECT NEW.id, COALESCE(max(rev), 0) + 1, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'updated_at', NEW.updated_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role,
+        'version', NEW.version)
+    FROM user_revisions WHERE user_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_revision() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO user_revisions (user_id, rev, snapshot)
+    SELECT NEW.id, COALESCE(max(rev), 0) + 1, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role)
+    FROM user_revisions WHERE user_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION bump_user_version() RETURNS trigger AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+ALTER TABLE users DROP COLUMN IF EXISTS updated_at;
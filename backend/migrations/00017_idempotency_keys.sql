@@ -0,0 +1,17 @@
+-- +goose Up
+-- responses to requests sent with an Idempotency-Key, replayed when a client retries the same request.
+-- keys are scoped to the user sending them; a row without a status is a request still in flight
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    key          TEXT NOT NULL,
+    request_hash BYTEA NOT NULL,
+    status_code  INTEGER,
+    header       JSONB,
+    body         BYTEA,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS idempotency_keys;
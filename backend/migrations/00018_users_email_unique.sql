@@ -0,0 +1,7 @@
+-- +goose Up
+-- one account per address, soft-deleted ones included, so a restored user never collides.
+-- fails if duplicates already exist; merge or rename them before migrating
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email);
+
+-- +goose Down
+DROP INDEX IF EXISTS users_email_key;
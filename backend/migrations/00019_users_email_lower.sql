@@ -0,0 +1,10 @@
+-- +goose Up
+-- emails are case-insensitive: store them trimmed and lowercased, and keep them unique in any case.
+-- fails if two users differ only by the case of their email; merge or rename them before migrating
+UPDATE users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+DROP INDEX IF EXISTS users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users (lower(email));
+
+-- +goose Down
+DROP INDEX IF EXISTS users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users (email);
@@ -0,0 +1,5 @@
+-- +goose Up
+INSERT INTO role_permissions (role, permission) VALUES ('admin', 'admin:read') ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'admin:read';
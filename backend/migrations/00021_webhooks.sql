@@ -0,0 +1,36 @@
+-- +goose Up
+-- integrators' subscriptions to user events. the secret signs each delivery, so it has to be kept
+-- in the clear, unlike API keys
+CREATE TABLE IF NOT EXISTS webhooks (
+    id         BIGSERIAL PRIMARY KEY,
+    url        TEXT NOT NULL,
+    events     TEXT[] NOT NULL,
+    secret     TEXT NOT NULL,
+    created_by INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- one event to send to one webhook, retried until it succeeds or runs out of attempts
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id               BIGSERIAL PRIMARY KEY,
+    webhook_id       BIGINT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event            TEXT NOT NULL,
+    payload          JSONB NOT NULL,
+    status           TEXT NOT NULL DEFAULT 'pending',
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_status_code INTEGER,
+    last_error       TEXT,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at     TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at DESC);
+-- the dispatcher only ever looks for pending deliveries that are due
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+INSERT INTO role_permissions (role, permission) VALUES ('admin', 'webhooks:manage') ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'webhooks:manage';
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
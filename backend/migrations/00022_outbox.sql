@@ -0,0 +1,63 @@
+-- +goose Up
+-- user events, written by triggers in the same transaction as the change they describe, so an
+-- event exists exactly when its change was committed. the relay publishes them in id order and
+-- marks them published; published events are pruned after a while
+CREATE TABLE IF NOT EXISTS outbox (
+    id           BIGSERIAL PRIMARY KEY,
+    event        TEXT NOT NULL,
+    entity_type  TEXT NOT NULL,
+    entity_id    INTEGER NOT NULL,
+    payload      JSONB NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    published_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_published_at_idx ON outbox (published_at) WHERE published_at IS NOT NULL;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_event() RETURNS trigger AS $$
+DECLARE
+    kind TEXT := 'user.updated';
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        kind := 'user.created';
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        kind := 'user.deleted';
+    END IF;
+    INSERT INTO outbox (event, entity_type, entity_id, payload)
+    VALUES (kind, 'user', NEW.id, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'updated_at', NEW.updated_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role,
+        'version', NEW.version));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- the same changes as leave a revision: secrets and bookkeeping aren't events
+CREATE TRIGGER users_record_event_insert AFTER INSERT ON users
+    FOR EACH ROW EXECUTE FUNCTION record_user_event();
+CREATE TRIGGER users_record_event_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role))
+    EXECUTE FUNCTION record_user_event();
+
+-- webhook deliveries are queued by the relay from outbox events, once per webhook even when an
+-- event is relayed again after a crash
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS outbox_id BIGINT;
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_outbox_id_idx ON webhook_deliveries (webhook_id, outbox_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS webhook_deliveries_outbox_id_idx;
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS outbox_id;
+DROP TRIGGER IF EXISTS users_record_event_update ON users;
+DROP TRIGGER IF EXISTS users_record_event_insert ON users;
+DROP FUNCTION IF EXISTS record_user_event();
+DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- slow work done in the background, such as sending email, retried until it succeeds or runs out
+-- of attempts
+CREATE TABLE IF NOT EXISTS jobs (
+    id           BIGSERIAL PRIMARY KEY,
+    kind         TEXT NOT NULL,
+    payload      JSONB NOT NULL,
+    status       TEXT NOT NULL DEFAULT 'pending',
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error   TEXT,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at  TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS jobs_created_at_idx ON jobs (created_at DESC);
+-- workers only ever look for pending jobs that are due
+CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs (run_at) WHERE status = 'pending';
+
+-- +goose Down
+DROP TABLE IF EXISTS jobs;
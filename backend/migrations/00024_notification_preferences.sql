@@ -0,0 +1,11 @@
+-- +goose Up
+-- the optional emails each user has chosen to receive; users without a row have the defaults
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id         INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    profile_changes BOOLEAN NOT NULL,
+    product_updates BOOLEAN NOT NULL,
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS notification_preferences;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- bookkeeping rather than a change clients see, so it doesn't bump the version or leave a revision
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMPTZ;
+
+-- every attempt to log in, successful or not. attempts on an unknown email have no user but keep
+-- the email tried
+CREATE TABLE IF NOT EXISTS login_events (
+    id             BIGSERIAL PRIMARY KEY,
+    user_id        INTEGER REFERENCES users (id) ON DELETE CASCADE,
+    email          TEXT NOT NULL,
+    method         TEXT NOT NULL,
+    success        BOOLEAN NOT NULL,
+    failure_reason TEXT,
+    ip             TEXT NOT NULL DEFAULT '',
+    user_agent     TEXT NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS login_events_user_id_idx ON login_events (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS login_events_created_at_idx ON login_events (created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS login_events;
+ALTER TABLE users DROP COLUMN IF EXISTS last_login_at;
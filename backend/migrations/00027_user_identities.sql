@@ -0,0 +1,14 @@
+-- +goose Up
+-- accounts at OAuth providers that sign in as a user, by the provider's own id for the account
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider   TEXT NOT NULL,
+    subject    TEXT NOT NULL,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email      TEXT NOT NULL, -- as the provider had it when the account was linked
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (provider, subject)
+);
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,105 @@
+-- +goose Up
+-- organizations sharing the install, each with users the others can't see. existing users and
+-- everything about them move into the default tenant, id 1
+CREATE TABLE IF NOT EXISTS tenants (
+    id         SERIAL PRIMARY KEY,
+    slug       TEXT NOT NULL UNIQUE, -- as clients name it in the X-Tenant header
+    name       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+INSERT INTO tenants (id, slug, name) VALUES (1, 'default', 'Default') ON CONFLICT DO NOTHING;
+SELECT setval('tenants_id_seq', (SELECT max(id) FROM tenants));
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+CREATE INDEX IF NOT EXISTS users_tenant_id_idx ON users (tenant_id, id);
+-- an address is taken within its tenant only
+DROP INDEX IF EXISTS users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users (tenant_id, lower(email));
+
+-- the same provider account may sign in to a user in each tenant
+ALTER TABLE user_identities ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE user_identities ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE user_identities DROP CONSTRAINT IF EXISTS user_identities_pkey;
+ALTER TABLE user_identities ADD PRIMARY KEY (tenant_id, provider, subject);
+
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE audit_log ALTER COLUMN tenant_id DROP DEFAULT;
+CREATE INDEX IF NOT EXISTS audit_log_tenant_id_idx ON audit_log (tenant_id, created_at DESC);
+
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE webhooks ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- events say which tenant their user is in, so they only go to that tenant's webhooks
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE outbox ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_event() RETURNS trigger AS $$
+DECLARE
+    kind TEXT := 'user.updated';
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        kind := 'user.created';
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        kind := 'user.deleted';
+    END IF;
+    INSERT INTO outbox (event, entity_type, entity_id, tenant_id, payload)
+    VALUES (kind, 'user', NEW.id, NEW.tenant_id, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'updated_at', NEW.updated_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role,
+        'version', NEW.version));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_event() RETURNS trigger AS $$
+DECLARE
+    kind TEXT := 'user.updated';
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        kind := 'user.created';
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        kind := 'user.deleted';
+    END IF;
+    INSERT INTO outbox (event, entity_type, entity_id, payload)
+    VALUES (kind, 'user', NEW.id, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'updated_at', NEW.updated_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role,
+        'version', NEW.version));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- fails if an address or a provider account is in use in more than one tenant; rename or unlink
+-- the duplicates before migrating
+ALTER TABLE outbox DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS tenant_id;
+DROP INDEX IF EXISTS audit_log_tenant_id_idx;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE user_identities DROP CONSTRAINT IF EXISTS user_identities_pkey;
+ALTER TABLE user_identities DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE user_identities ADD PRIMARY KEY (provider, subject);
+DROP INDEX IF EXISTS users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users (lower(email));
+DROP INDEX IF EXISTS users_tenant_id_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,28 @@
+-- +goose Up
+-- groups of users within a tenant, such as a customer's company. a user may belong to several,
+-- with a role in each
+CREATE TABLE IF NOT EXISTS organizations (
+    id         SERIAL PRIMARY KEY,
+    tenant_id  INTEGER NOT NULL REFERENCES tenants (id),
+    name       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS organizations_tenant_id_idx ON organizations (tenant_id, id);
+
+CREATE TABLE IF NOT EXISTS memberships (
+    org_id     INTEGER NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role       TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (org_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS memberships_user_id_idx ON memberships (user_id);
+
+-- lets admins act as an owner of any organization in their tenant
+INSERT INTO role_permissions (role, permission) VALUES ('admin', 'orgs:manage') ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'orgs:manage';
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS organizations;
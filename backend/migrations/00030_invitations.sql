@@ -0,0 +1,19 @@
+-- +goose Up
+-- invitations to join an organization, emailed to an address that may not have an account yet.
+-- only a hash of each token is stored, like password resets, and each is used once
+CREATE TABLE IF NOT EXISTS invitations (
+    id          SERIAL PRIMARY KEY,
+    org_id      INTEGER NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    email       TEXT NOT NULL,
+    role        TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    token_hash  BYTEA NOT NULL UNIQUE,
+    invited_by  INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    declined_at TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS invitations_org_id_idx ON invitations (org_id, lower(email));
+
+-- +goose Down
+DROP TABLE IF EXISTS invitations;
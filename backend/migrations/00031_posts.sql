@@ -0,0 +1,20 @@
+-- +goose Up
+-- posts written by users. they go when their author is purged, and are hidden while the author is
+-- soft-deleted, coming back with them on a restore
+CREATE TABLE IF NOT EXISTS posts (
+    id         SERIAL PRIMARY KEY,
+    author_id  INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    title      TEXT NOT NULL,
+    body       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS posts_author_id_idx ON posts (author_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS posts_created_at_idx ON posts (created_at DESC);
+
+-- lets admins edit and delete anyone's posts
+INSERT INTO role_permissions (role, permission) VALUES ('admin', 'posts:manage') ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'posts:manage';
+DROP TABLE IF EXISTS posts;
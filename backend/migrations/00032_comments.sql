@@ -0,0 +1,16 @@
+-- +goose Up
+-- comments on posts. they go with the post, and with their author when the author is purged; while
+-- the author is soft-deleted they are hidden, like the author's posts
+CREATE TABLE IF NOT EXISTS comments (
+    id         SERIAL PRIMARY KEY,
+    post_id    INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    author_id  INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    body       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS comments_post_id_idx ON comments (post_id, created_at);
+CREATE INDEX IF NOT EXISTS comments_author_id_idx ON comments (author_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS comments;
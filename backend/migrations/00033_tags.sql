@@ -0,0 +1,22 @@
+-- +goose Up
+-- labels admins put on users to segment them, such as beta or vip, one set per tenant
+CREATE TABLE IF NOT EXISTS tags (
+    id         SERIAL PRIMARY KEY,
+    tenant_id  INTEGER NOT NULL REFERENCES tenants (id),
+    name       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (tenant_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS user_tags (
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    tag_id     INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, tag_id)
+);
+-- for ?tag= on the user listing
+CREATE INDEX IF NOT EXISTS user_tags_tag_id_idx ON user_tags (tag_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_tags;
+DROP TABLE IF EXISTS tags;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- UI preferences the frontend keeps for each user. the triggers on users only watch the profile
+-- columns, so saving settings makes no revision, version or event
+ALTER TABLE users ADD COLUMN IF NOT EXISTS settings JSONB NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS settings;
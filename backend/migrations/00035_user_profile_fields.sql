@@ -0,0 +1,148 @@
+-- +goose Up
+-- optional profile details, null until the user fills them in. they are checked by the API:
+-- phone in E.164, timezone an IANA name and locale a BCP 47 tag
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bio TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale TEXT;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_revision() RETURNS trigger AS $$
+BEGIN
+    -- the row lock held by the write serializes revisions of the same user
+    INSERT INTO user_revisions (user_id, rev, snapshot)
+    SELECT NEW.id, COALESCE(max(rev), 0) + 1, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role,
+        'phone', NEW.phone,
+        'bio', NEW.bio,
+        'timezone', NEW.timezone,
+        'locale', NEW.locale)
+    FROM user_revisions WHERE user_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_event() RETURNS trigger AS $$
+DECLARE
+    kind TEXT := 'user.updated';
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        kind := 'user.created';
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        kind := 'user.deleted';
+    END IF;
+    INSERT INTO outbox (event, entity_type, entity_id, tenant_id, payload)
+    VALUES (kind, 'user', NEW.id, NEW.tenant_id, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'updated_at', NEW.updated_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role,
+        'phone', NEW.phone,
+        'bio', NEW.bio,
+        'timezone', NEW.timezone,
+        'locale', NEW.locale,
+        'version', NEW.version));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- a change to any of them is a change a client can see: a new version, revision and event
+DROP TRIGGER IF EXISTS users_bump_version ON users;
+CREATE TRIGGER users_bump_version BEFORE UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale))
+    EXECUTE FUNCTION bump_user_version();
+DROP TRIGGER IF EXISTS users_record_revision_update ON users;
+CREATE TRIGGER users_record_revision_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale))
+    EXECUTE FUNCTION record_user_revision();
+DROP TRIGGER IF EXISTS users_record_event_update ON users;
+CREATE TRIGGER users_record_event_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale))
+    EXECUTE FUNCTION record_user_event();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS users_record_event_update ON users;
+CREATE TRIGGER users_record_event_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role))
+    EXECUTE FUNCTION record_user_event();
+DROP TRIGGER IF EXISTS users_record_revision_update ON users;
+CREATE TRIGGER users_record_revision_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role))
+    EXECUTE FUNCTION record_user_revision();
+DROP TRIGGER IF EXISTS users_bump_version ON users;
+CREATE TRIGGER users_bump_version BEFORE UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role))
+    EXECUTE FUNCTION bump_user_version();
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_event() RETURNS trigger AS $$
+DECLARE
+    kind TEXT := 'user.updated';
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        kind := 'user.created';
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        kind := 'user.deleted';
+    END IF;
+    INSERT INTO outbox (event, entity_type, entity_id, tenant_id, payload)
+    VALUES (kind, 'user', NEW.id, NEW.tenant_id, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'updated_at', NEW.updated_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role,
+        'version', NEW.version));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_revision() RETURNS trigger AS $$
+BEGIN
+    -- the row lock held by the write serializes revisions of the same user
+    INSERT INTO user_revisions (user_id, rev, snapshot)
+    SELECT NEW.id, COALESCE(max(rev), 0) + 1, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role)
+    FROM user_revisions WHERE user_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS timezone;
+ALTER TABLE users DROP COLUMN IF EXISTS bio;
+ALTER TABLE users DROP COLUMN IF EXISTS phone;
@@ -0,0 +1,166 @@
+-- +goose Up
+-- the handle a user is found by in profile URLs, unique within the tenant. existing users get one
+-- from their name, numbered from 2 when the name is already in use
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username TEXT;
+WITH slugs AS (
+    SELECT id, tenant_id, COALESCE(NULLIF(trim(BOTH '-' FROM left(regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g'), 30)), ''), 'user') AS slug
+    FROM users WHERE username IS NULL
+), numbered AS (
+    SELECT id, slug, row_number() OVER (PARTITION BY tenant_id, slug ORDER BY id) AS n FROM slugs
+)
+UPDATE users SET username = numbered.slug || CASE WHEN numbered.n = 1 THEN '' ELSE '-' || numbered.n END
+FROM numbered WHERE users.id = numbered.id;
+ALTER TABLE users ALTER COLUMN username SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON users (tenant_id, username);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_revision() RETURNS trigger AS $$
+BEGIN
+    -- the row lock held by the write serializes revisions of the same user
+    INSERT INTO user_revisions (user_id, rev, snapshot)
+    SELECT NEW.id, COALESCE(max(rev), 0) + 1, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role,
+        'phone', NEW.phone,
+        'bio', NEW.bio,
+        'timezone', NEW.timezone,
+        'locale', NEW.locale,
+        'username', NEW.username)
+    FROM user_revisions WHERE user_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_event() RETURNS trigger AS $$
+DECLARE
+    kind TEXT := 'user.updated';
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        kind := 'user.created';
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        kind := 'user.deleted';
+    END IF;
+    INSERT INTO outbox (event, entity_type, entity_id, tenant_id, payload)
+    VALUES (kind, 'user', NEW.id, NEW.tenant_id, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'updated_at', NEW.updated_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role,
+        'phone', NEW.phone,
+        'bio', NEW.bio,
+        'timezone', NEW.timezone,
+        'locale', NEW.locale,
+        'username', NEW.username,
+        'version', NEW.version));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- a new username is a change a client can see, like the other profile fields
+DROP TRIGGER IF EXISTS users_bump_version ON users;
+CREATE TRIGGER users_bump_version BEFORE UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale, OLD.username)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale, NEW.username))
+    EXECUTE FUNCTION bump_user_version();
+DROP TRIGGER IF EXISTS users_record_revision_update ON users;
+CREATE TRIGGER users_record_revision_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale, OLD.username)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale, NEW.username))
+    EXECUTE FUNCTION record_user_revision();
+DROP TRIGGER IF EXISTS users_record_event_update ON users;
+CREATE TRIGGER users_record_event_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale, OLD.username)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale, NEW.username))
+    EXECUTE FUNCTION record_user_event();
+
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_revision() RETURNS trigger AS $$
+BEGIN
+    -- the row lock held by the write serializes revisions of the same user
+    INSERT INTO user_revisions (user_id, rev, snapshot)
+    SELECT NEW.id, COALESCE(max(rev), 0) + 1, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role,
+        'phone', NEW.phone,
+        'bio', NEW.bio,
+        'timezone', NEW.timezone,
+        'locale', NEW.locale)
+    FROM user_revisions WHERE user_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_event() RETURNS trigger AS $$
+DECLARE
+    kind TEXT := 'user.updated';
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        kind := 'user.created';
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        kind := 'user.deleted';
+    END IF;
+    INSERT INTO outbox (event, entity_type, entity_id, tenant_id, payload)
+    VALUES (kind, 'user', NEW.id, NEW.tenant_id, jsonb_build_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', NEW.created_at,
+        'updated_at', NEW.updated_at,
+        'deleted_at', NEW.deleted_at,
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', NEW.email_verified_at,
+        'role', NEW.role,
+        'phone', NEW.phone,
+        'bio', NEW.bio,
+        'timezone', NEW.timezone,
+        'locale', NEW.locale,
+        'version', NEW.version));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- the triggers as they were before usernames
+DROP TRIGGER IF EXISTS users_bump_version ON users;
+CREATE TRIGGER users_bump_version BEFORE UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale))
+    EXECUTE FUNCTION bump_user_version();
+DROP TRIGGER IF EXISTS users_record_revision_update ON users;
+CREATE TRIGGER users_record_revision_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale))
+    EXECUTE FUNCTION record_user_revision();
+DROP TRIGGER IF EXISTS users_record_event_update ON users;
+CREATE TRIGGER users_record_event_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale))
+    EXECUTE FUNCTION record_user_event();
+
+
+DROP INDEX IF EXISTS users_username_key;
+ALTER TABLE users DROP COLUMN IF EXISTS username;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- copies of everything held about a user, generated in the background for large accounts and kept
+-- until they expire. they go with the user when the user is purged
+CREATE TABLE IF NOT EXISTS data_exports (
+    id          SERIAL PRIMARY KEY,
+    tenant_id   INTEGER NOT NULL REFERENCES tenants (id),
+    user_id     INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    format      TEXT NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'pending',
+    job_id      BIGINT, -- the job generating it, to tell when every attempt has failed
+    archive     BYTEA,  -- set once it is ready
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ,
+    expires_at  TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS data_exports_user_id_idx ON data_exports (user_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS data_exports;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- when a user's personal data was erased. an erased user keeps its row, so what it wrote and the
+-- organizations it belonged to still refer to it, but it can't be restored
+ALTER TABLE users ADD COLUMN IF NOT EXISTS erased_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS erased_at;
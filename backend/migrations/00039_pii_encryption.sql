@@ -0,0 +1,46 @@
+-- +goose Up
+-- with encryption on, email and phone hold ciphertext and email_index a keyed hash of the lowercased
+-- address, so users are still found by email and addresses stay unique within the tenant
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_index BYTEA;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_index_key ON users (tenant_id, email_index);
+
+-- moving values to a new key isn't a change clients can see, so --reencrypt-pii sets
+-- app.reencrypting_pii for its transactions and the triggers leave those updates alone
+DROP TRIGGER IF EXISTS users_bump_version ON users;
+CREATE TRIGGER users_bump_version BEFORE UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale, OLD.username)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale, NEW.username)
+        AND current_setting('app.reencrypting_pii', true) IS DISTINCT FROM 'on')
+    EXECUTE FUNCTION bump_user_version();
+DROP TRIGGER IF EXISTS users_record_revision_update ON users;
+CREATE TRIGGER users_record_revision_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale, OLD.username)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale, NEW.username)
+        AND current_setting('app.reencrypting_pii', true) IS DISTINCT FROM 'on')
+    EXECUTE FUNCTION record_user_revision();
+DROP TRIGGER IF EXISTS users_record_event_update ON users;
+CREATE TRIGGER users_record_event_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale, OLD.username)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale, NEW.username)
+        AND current_setting('app.reencrypting_pii', true) IS DISTINCT FROM 'on')
+    EXECUTE FUNCTION record_user_event();
+
+-- +goose Down
+-- the triggers as 00036 left them
+DROP TRIGGER IF EXISTS users_bump_version ON users;
+CREATE TRIGGER users_bump_version BEFORE UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale, OLD.username)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale, NEW.username))
+    EXECUTE FUNCTION bump_user_version();
+DROP TRIGGER IF EXISTS users_record_revision_update ON users;
+CREATE TRIGGER users_record_revision_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale, OLD.username)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale, NEW.username))
+    EXECUTE FUNCTION record_user_revision();
+DROP TRIGGER IF EXISTS users_record_event_update ON users;
+CREATE TRIGGER users_record_event_update AFTER UPDATE ON users
+    FOR EACH ROW WHEN ((OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale, OLD.username)
+        IS DISTINCT FROM (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale, NEW.username))
+    EXECUTE FUNCTION record_user_event();
+DROP INDEX IF EXISTS users_email_index_key;
+ALTER TABLE users DROP COLUMN IF EXISTS email_index;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- the address of the client that made the change, behind any trusted proxies
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE audit_log DROP COLUMN IF EXISTS ip;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- flags gating features of the frontend, one set per tenant, see models.FeatureFlag
+CREATE TABLE IF NOT EXISTS feature_flags (
+    id          SERIAL PRIMARY KEY,
+    tenant_id   INTEGER NOT NULL REFERENCES tenants (id),
+    key         TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    enabled     BOOLEAN NOT NULL DEFAULT false,
+    rollout     INTEGER NOT NULL DEFAULT 0 CHECK (rollout BETWEEN 0 AND 100),
+    user_ids    INTEGER[] NOT NULL DEFAULT '{}',
+    roles       TEXT[] NOT NULL DEFAULT '{}',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (tenant_id, key)
+);
+
+INSERT INTO role_permissions (role, permission) VALUES ('admin', 'flags:manage') ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'flags:manage';
+DROP TABLE IF EXISTS feature_flags;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- admins acting as users to support them, each with a token that stops working once ended
+CREATE TABLE IF NOT EXISTS impersonations (
+    id         SERIAL PRIMARY KEY,
+    tenant_id  INTEGER NOT NULL REFERENCES tenants (id),
+    admin_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    reason     TEXT NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    ended_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS impersonations_user_id_idx ON impersonations (user_id, started_at DESC);
+
+-- the admin who made a change while impersonating its actor
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS impersonator_id INTEGER;
+
+INSERT INTO role_permissions (role, permission) VALUES ('admin', 'users:impersonate') ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'users:impersonate';
+ALTER TABLE audit_log DROP COLUMN IF EXISTS impersonator_id;
+DROP TABLE IF EXISTS impersonations;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- users deleted for good, kept so delta syncs can tell clients to drop them. soft-deleted users
+-- need none: they keep their row, with deleted_at set
+CREATE TABLE IF NOT EXISTS user_tombstones (
+    user_id    INTEGER PRIMARY KEY,
+    tenant_id  INTEGER NOT NULL,
+    deleted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS user_tombstones_tenant_deleted_at_idx ON user_tombstones (tenant_id, deleted_at, user_id);
+
+-- changes are read in (updated_at, id) order
+CREATE INDEX IF NOT EXISTS users_tenant_updated_at_idx ON users (tenant_id, updated_at, id);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_user_tombstone() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO user_tombstones (user_id, tenant_id) VALUES (OLD.id, OLD.tenant_id)
+    ON CONFLICT (user_id) DO UPDATE SET deleted_at = now();
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER users_record_tombstone AFTER DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION record_user_tombstone();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS users_record_tombstone ON users;
+DROP FUNCTION IF EXISTS record_user_tombstone();
+DROP INDEX IF EXISTS users_tenant_updated_at_idx;
+DROP TABLE IF EXISTS user_tombstones;
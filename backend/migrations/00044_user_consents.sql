@@ -0,0 +1,17 @@
+-- +goose Up
+-- the versions of the terms of service and privacy policy each user accepted, kept as evidence of
+-- consent. erasing a user keeps these, without the address and user agent they were given from
+CREATE TABLE IF NOT EXISTS user_consents (
+    id          SERIAL PRIMARY KEY,
+    tenant_id   INTEGER NOT NULL REFERENCES tenants (id),
+    user_id     INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    document    TEXT NOT NULL,
+    version     TEXT NOT NULL,
+    accepted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ip          TEXT,
+    user_agent  TEXT,
+    UNIQUE (user_id, document, version)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_consents;
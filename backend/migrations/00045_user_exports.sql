@@ -0,0 +1,21 @@
+-- +goose Up
+-- files of the users matching a filter, generated in the background for whoever asked and kept
+-- until they expire. they go with the user who asked when that user is purged
+CREATE TABLE IF NOT EXISTS user_exports (
+    id           SERIAL PRIMARY KEY,
+    tenant_id    INTEGER NOT NULL REFERENCES tenants (id),
+    requested_by INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    format       TEXT NOT NULL,
+    status       TEXT NOT NULL DEFAULT 'pending',
+    job_id       BIGINT,
+    exported     INTEGER NOT NULL DEFAULT 0, -- users written so far
+    total        INTEGER NOT NULL DEFAULT 0, -- users matching when the job started
+    archive      BYTEA,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at  TIMESTAMPTZ,
+    expires_at   TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS user_exports_tenant_id_idx ON user_exports (tenant_id, expires_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_exports;
@@ -0,0 +1,49 @@
+-- +goose Up
+-- every change to a user, or to the tags lists filter on, is announced on the user_changes channel
+-- as {"tenant_id": ..., "id": ...}, so replicas caching users in process evict what went stale.
+-- the notification is sent inside the writing transaction and delivered once it commits
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_user_change() RETURNS trigger AS $$
+DECLARE
+    changed users%ROWTYPE;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed := OLD;
+    ELSE
+        changed := NEW;
+    END IF;
+    PERFORM pg_notify('user_changes', json_build_object('tenant_id', changed.tenant_id, 'id', changed.id)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER users_notify_change AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION notify_user_change();
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_user_tags_change() RETURNS trigger AS $$
+DECLARE
+    changed_user_id INTEGER;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_user_id := OLD.user_id;
+    ELSE
+        changed_user_id := NEW.user_id;
+    END IF;
+    -- tags removed along with their user were announced with the user
+    PERFORM pg_notify('user_changes', json_build_object('tenant_id', tenant_id, 'id', id)::text)
+    FROM users WHERE id = changed_user_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER user_tags_notify_change AFTER INSERT OR DELETE ON user_tags
+    FOR EACH ROW EXECUTE FUNCTION notify_user_tags_change();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS user_tags_notify_change ON user_tags;
+DROP FUNCTION IF EXISTS notify_user_tags_change();
+DROP TRIGGER IF EXISTS users_notify_change ON users;
+DROP FUNCTION IF EXISTS notify_user_change();
@@ -0,0 +1,12 @@
+-- +goose Up
+-- the address a user asked to move to, kept apart from users until they confirm it from the link
+-- sent there, so neither their email nor their version changes in the meantime. pending_email is
+-- encrypted like users.email
+CREATE TABLE IF NOT EXISTS email_changes (
+    user_id       INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    pending_email TEXT NOT NULL,
+    requested_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS email_changes;
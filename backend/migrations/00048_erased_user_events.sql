@@ -0,0 +1,26 @@
+-- +goose Up
+-- a user as an event recorded them, with the details erasure clears taken from their now erased
+-- row instead. members doc doesn't have aren't added, and doc is returned as is when it isn't an
+-- object. used by Erase on the outbox and on webhook deliveries, which copy its events
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION redact_user_json(doc JSONB, erased_id INTEGER) RETURNS JSONB AS $$
+    SELECT CASE WHEN jsonb_typeof(doc) = 'object' THEN (
+        SELECT COALESCE(jsonb_object_agg(d.key, COALESCE(erased.fields -> d.key, d.value)), '{}'::jsonb)
+        FROM jsonb_each(doc) d, (
+            SELECT jsonb_build_object(
+                'name', name,
+                'email', email,
+                'username', username,
+                'avatar_url', avatar_url,
+                'email_verified_at', email_verified_at,
+                'phone', phone,
+                'bio', bio,
+                'timezone', timezone,
+                'locale', locale) AS fields
+            FROM users WHERE id = erased_id) erased)
+    ELSE doc END
+$$ LANGUAGE sql STABLE;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP FUNCTION IF EXISTS redact_user_json(JSONB, INTEGER);
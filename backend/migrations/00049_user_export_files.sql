@@ -0,0 +1,14 @@
+-- +goose Up
+-- the files of user exports move to file storage, with the row keeping where. exports already
+-- generated are dropped rather than moved; they are kept for a day and can be asked for again
+DELETE FROM user_exports WHERE archive IS NOT NULL;
+ALTER TABLE user_exports DROP COLUMN IF EXISTS archive;
+ALTER TABLE user_exports ADD COLUMN IF NOT EXISTS archive_key TEXT; -- set once it is ready
+-- the clean-up looks for expired exports of every tenant
+CREATE INDEX IF NOT EXISTS user_exports_expires_at_idx ON user_exports (expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS user_exports_expires_at_idx;
+DELETE FROM user_exports WHERE archive_key IS NOT NULL;
+ALTER TABLE user_exports DROP COLUMN IF EXISTS archive_key;
+ALTER TABLE user_exports ADD COLUMN IF NOT EXISTS archive BYTEA;
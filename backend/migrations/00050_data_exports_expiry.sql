@@ -0,0 +1,6 @@
+-- +goose Up
+-- the clean-up looks for expired data exports of every tenant
+CREATE INDEX IF NOT EXISTS data_exports_expires_at_idx ON data_exports (expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS data_exports_expires_at_idx;
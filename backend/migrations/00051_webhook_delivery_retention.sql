@@ -0,0 +1,6 @@
+-- +goose Up
+-- the clean-up looks for old deliveries that are no longer pending
+CREATE INDEX IF NOT EXISTS webhook_deliveries_created_at_idx ON webhook_deliveries (created_at) WHERE status <> 'pending';
+
+-- +goose Down
+DROP INDEX IF EXISTS webhook_deliveries_created_at_idx;
@@ -0,0 +1,23 @@
+// Package migrations embeds the versioned SQL schema migrations applied with goose.
+//
+// New migrations go in this directory as NNNNN_description.sql, each with a
+// "-- +goose Up" section and a "-- +goose Down" section that reverses it.
+// The SQLite driver's schema is migrated apart, from the sqlite directory.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// FS holds every migration file, in version order by name.
+//
+//go:embed *.sql
+var FS embed.FS
+
+//go:embed sqlite/*.sql
+var sqliteFS embed.FS
+
+// SQLiteFS holds the migrations of the SQLite driver's schema, which only has the tables users
+// are kept in.
+var SQLiteFS, _ = fs.Sub(sqliteFS, "sqlite")
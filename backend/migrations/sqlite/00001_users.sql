@@ -0,0 +1,277 @@
+-- +goose Up
+-- the tables the SQLite driver keeps users in, as the Postgres migrations up to 00051 leave
+-- them, leaving out the features that need Postgres. times are stored as UTC text in the
+-- format the driver writes them in, so they sort in time order; SQL writes them with strftime
+CREATE TABLE roles (
+    name TEXT PRIMARY KEY
+);
+CREATE TABLE role_permissions (
+    role       TEXT NOT NULL REFERENCES roles (name) ON UPDATE CASCADE ON DELETE CASCADE,
+    permission TEXT NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+INSERT INTO roles (name) VALUES ('admin'), ('user');
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'admin:read'),
+    ('admin', 'audit:read'),
+    ('admin', 'flags:manage'),
+    ('admin', 'orgs:manage'),
+    ('admin', 'posts:manage'),
+    ('admin', 'roles:manage'),
+    ('admin', 'users:delete'),
+    ('admin', 'users:impersonate'),
+    ('admin', 'users:read'),
+    ('admin', 'users:write'),
+    ('admin', 'webhooks:manage'),
+    ('user', 'users:read');
+
+-- AUTOINCREMENT, as a serial column would, never gives a purged user's id to another
+CREATE TABLE users (
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    tenant_id          INTEGER NOT NULL,
+    name               TEXT NOT NULL,
+    email              TEXT NOT NULL,
+    email_index        BLOB,
+    username           TEXT NOT NULL,
+    password_hash      TEXT,
+    role               TEXT NOT NULL DEFAULT 'user' REFERENCES roles (name) ON UPDATE CASCADE,
+    phone              TEXT,
+    bio                TEXT,
+    timezone           TEXT,
+    locale             TEXT,
+    avatar_url         TEXT,
+    email_verified_at  TIMESTAMP,
+    settings           TEXT NOT NULL DEFAULT '{}',
+    version            INTEGER NOT NULL DEFAULT 1,
+    created_at         TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    updated_at         TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    deleted_at         TIMESTAMP,
+    erased_at          TIMESTAMP,
+    tokens_valid_after TIMESTAMP,
+    totp_secret        TEXT,
+    totp_enabled_at    TIMESTAMP,
+    totp_last_step     INTEGER,
+    last_login_at      TIMESTAMP,
+    failed_logins      INTEGER NOT NULL DEFAULT 0,
+    locked_until       TIMESTAMP
+);
+CREATE UNIQUE INDEX users_email_key ON users (tenant_id, lower(email));
+CREATE UNIQUE INDEX users_email_index_key ON users (tenant_id, email_index);
+CREATE UNIQUE INDEX users_username_key ON users (tenant_id, username);
+CREATE INDEX users_tenant_created_at_idx ON users (tenant_id, created_at, id);
+CREATE INDEX users_tenant_updated_at_idx ON users (tenant_id, updated_at, id);
+
+CREATE TABLE user_revisions (
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    rev        INTEGER NOT NULL,
+    snapshot   TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    PRIMARY KEY (user_id, rev)
+);
+
+CREATE TABLE user_tombstones (
+    user_id    INTEGER PRIMARY KEY,
+    tenant_id  INTEGER NOT NULL,
+    deleted_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+CREATE INDEX user_tombstones_tenant_deleted_at_idx ON user_tombstones (tenant_id, deleted_at, user_id);
+
+-- the snapshot a revision keeps, with times in RFC 3339 form as Postgres writes them in JSON
+-- +goose StatementBegin
+CREATE TRIGGER users_record_revision_insert AFTER INSERT ON users
+BEGIN
+    INSERT INTO user_revisions (user_id, rev, snapshot)
+    SELECT NEW.id, COALESCE(max(rev), 0) + 1, json_object(
+        'id', NEW.id,
+        'name', NEW.name,
+        'email', NEW.email,
+        'created_at', replace(NEW.created_at, ' ', 'T'),
+        'updated_at', replace(NEW.updated_at, ' ', 'T'),
+        'deleted_at', replace(NEW.deleted_at, ' ', 'T'),
+        'avatar_url', NEW.avatar_url,
+        'email_verified_at', replace(NEW.email_verified_at, ' ', 'T'),
+        'role', NEW.role,
+        'phone', NEW.phone,
+        'bio', NEW.bio,
+        'timezone', NEW.timezone,
+        'locale', NEW.locale,
+        'username', NEW.username,
+        'version', NEW.version)
+    FROM user_revisions WHERE user_id = NEW.id;
+END;
+-- +goose StatementEnd
+
+-- triggers can't change the row being written, so the version is bumped by another update,
+-- which recursive_triggers being off keeps from firing this again. RETURNING reports rows as
+-- the statement wrote them, before this runs
+-- +goose StatementBegin
+CREATE TRIGGER users_bump_version AFTER UPDATE ON users
+    WHEN (OLD.name, OLD.email, OLD.deleted_at, OLD.avatar_url, OLD.email_verified_at, OLD.role, OLD.phone, OLD.bio, OLD.timezone, OLD.locale, OLD.username)
+        IS NOT (NEW.name, NEW.email, NEW.deleted_at, NEW.avatar_url, NEW.email_verified_at, NEW.role, NEW.phone, NEW.bio, NEW.timezone, NEW.locale, NEW.username)
+BEGIN
+    UPDATE users SET version = OLD.version + 1, updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE id = NEW.id;
+    INSERT INTO user_revisions (user_id, rev, snapshot)
+    SELECT u.id, (SELECT COALESCE(max(rev), 0) + 1 FROM user_revisions WHERE user_id = u.id), json_object(
+        'id', u.id,
+        'name', u.name,
+        'email', u.email,
+        'created_at', replace(u.created_at, ' ', 'T'),
+        'updated_at', replace(u.updated_at, ' ', 'T'),
+        'deleted_at', replace(u.deleted_at, ' ', 'T'),
+        'avatar_url', u.avatar_url,
+        'email_verified_at', replace(u.email_verified_at, ' ', 'T'),
+        'role', u.role,
+        'phone', u.phone,
+        'bio', u.bio,
+        'timezone', u.timezone,
+        'locale', u.locale,
+        'username', u.username,
+        'version', u.version)
+    FROM users u WHERE u.id = NEW.id;
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER users_record_tombstone AFTER DELETE ON users
+BEGIN
+    INSERT INTO user_tombstones (user_id, tenant_id) VALUES (OLD.id, OLD.tenant_id)
+    ON CONFLICT (user_id) DO UPDATE SET deleted_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now');
+END;
+-- +goose StatementEnd
+
+CREATE TABLE email_changes (
+    user_id       INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    pending_email TEXT NOT NULL,
+    requested_at  TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE password_resets (
+    id         INTEGER PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash BLOB NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+CREATE INDEX password_resets_user_id_idx ON password_resets (user_id);
+
+CREATE TABLE sessions (
+    id           INTEGER PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent   TEXT NOT NULL DEFAULT '',
+    ip           TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_seen_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    revoked_at   TIMESTAMP
+);
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+
+CREATE TABLE refresh_tokens (
+    id         INTEGER PRIMARY KEY,
+    session_id INTEGER NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash BLOB NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    used_at    TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+CREATE INDEX refresh_tokens_session_id_idx ON refresh_tokens (session_id);
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+CREATE TABLE login_events (
+    id             INTEGER PRIMARY KEY,
+    user_id        INTEGER REFERENCES users (id) ON DELETE CASCADE,
+    email          TEXT NOT NULL,
+    method         TEXT NOT NULL,
+    success        INTEGER NOT NULL,
+    failure_reason TEXT,
+    ip             TEXT NOT NULL DEFAULT '',
+    user_agent     TEXT NOT NULL DEFAULT '',
+    created_at     TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+CREATE INDEX login_events_user_id_idx ON login_events (user_id, created_at DESC);
+
+CREATE TABLE user_identities (
+    tenant_id  INTEGER NOT NULL,
+    provider   TEXT NOT NULL,
+    subject    TEXT NOT NULL,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email      TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    PRIMARY KEY (tenant_id, provider, subject)
+);
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
+
+CREATE TABLE impersonations (
+    id         INTEGER PRIMARY KEY,
+    tenant_id  INTEGER NOT NULL,
+    admin_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    reason     TEXT NOT NULL,
+    started_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    expires_at TIMESTAMP NOT NULL,
+    ended_at   TIMESTAMP
+);
+CREATE INDEX impersonations_user_id_idx ON impersonations (user_id, started_at DESC);
+
+CREATE TABLE notification_preferences (
+    user_id         INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    profile_changes INTEGER NOT NULL,
+    product_updates INTEGER NOT NULL,
+    updated_at      TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE api_keys (
+    id           INTEGER PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    prefix       TEXT NOT NULL,
+    key_hash     BLOB NOT NULL UNIQUE,
+    created_at   TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_used_at TIMESTAMP,
+    revoked_at   TIMESTAMP
+);
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);
+
+CREATE TABLE totp_backup_codes (
+    id        INTEGER PRIMARY KEY,
+    user_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash BLOB NOT NULL,
+    used_at   TIMESTAMP
+);
+CREATE INDEX totp_backup_codes_user_id_idx ON totp_backup_codes (user_id);
+
+CREATE TABLE tags (
+    id         INTEGER PRIMARY KEY,
+    tenant_id  INTEGER NOT NULL,
+    name       TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    UNIQUE (tenant_id, name)
+);
+CREATE TABLE user_tags (
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    tag_id     INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    PRIMARY KEY (user_id, tag_id)
+);
+CREATE INDEX user_tags_tag_id_idx ON user_tags (tag_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS totp_backup_codes;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS notification_preferences;
+DROP TABLE IF EXISTS impersonations;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS login_events;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS password_resets;
+DROP TABLE IF EXISTS email_changes;
+DROP TABLE IF EXISTS user_tombstones;
+DROP TABLE IF EXISTS user_revisions;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
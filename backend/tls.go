@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+
+	"api/config"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// the TLS config the HTTP server serves HTTPS with, nil for plain HTTP, and the handler of the
+// redirect listener: autocert's when certificates come from Let's Encrypt, so it also answers the
+// HTTP-01 challenges, and a plain redirect otherwise
+func setupTLS(cfg config.Config) (*tls.Config, http.Handler, error) {
+	redirect := redirectToHTTPS(cfg.HTTPAddr)
+	if len(cfg.TLSAutocertDomains) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLSAutocertDomains...),
+			Cache:      autocert.DirCache(cfg.TLSAutocertCacheDir),
+			Email:      cfg.TLSAutocertEmail,
+		}
+		// TLSConfig also answers TLS-ALPN-01 challenges, for servers on :443 without a redirect listener
+		return m.TLSConfig(), m.HTTPHandler(redirect), nil
+	}
+	if cfg.TLSCertFile == "" {
+		return nil, nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, redirect, nil
+}
+
+// send requests to the same URL over HTTPS on httpsAddr's port, which only appears in the URL when
+// it isn't 443
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	})
+}
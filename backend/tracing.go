@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+)
+
+// service name reported on spans unless OTEL_SERVICE_NAME overrides it
+const tracingServiceName = "goapp"
+
+// install an OTLP trace exporter when OTEL_EXPORTER_OTLP_ENDPOINT (or the traces-specific variant) is set.
+// the exporter reads the standard OTEL_* variables for endpoint, headers, TLS and sampling; without an
+// endpoint the global provider stays a no-op. the returned func flushes pending spans
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(tracingServiceName), semconv.ServiceVersion(version)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// resource.Default already applied OTEL_SERVICE_NAME, so let the env win over our default name
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		res, _ = resource.Merge(res, resource.NewSchemaless(semconv.ServiceName(name)))
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,39 @@
+package userpb
+
+import (
+	"api/internal/models"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// FromModel is the wire form of a user, shared by the gRPC service and published events.
+func FromModel(u models.User) *User {
+	pu := &User{
+		Id:        int64(u.Id),
+		Name:      u.Name,
+		Username:  u.Username,
+		Email:     u.Email,
+		CreatedAt: timestamppb.New(u.CreatedAt),
+		Role:      u.Role,
+		Version:   int32(u.Version),
+	}
+	if u.DeletedAt != nil {
+		pu.DeletedAt = timestamppb.New(*u.DeletedAt)
+	}
+	if u.AvatarURL != nil {
+		pu.AvatarUrl = *u.AvatarURL
+	}
+	if u.Phone != nil {
+		pu.Phone = *u.Phone
+	}
+	if u.Bio != nil {
+		pu.Bio = *u.Bio
+	}
+	if u.Timezone != nil {
+		pu.Timezone = *u.Timezone
+	}
+	if u.Locale != nil {
+		pu.Locale = *u.Locale
+	}
+	return pu
+}
@@ -0,0 +1,82 @@
+// Package web embeds the frontend's static export, so one binary can serve both the API and the
+// app that uses it.
+//
+// Build the export with `NEXT_OUTPUT=export npm run build` in ../frontend, copy its out directory
+// to dist here, then build the server. Without an export in dist the binary serves the API alone.
+package web
+
+import (
+	"embed"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+//go:embed all:dist
+var files embed.FS
+
+var dist, _ = fs.Sub(files, "dist")
+
+// Next.js fingerprints everything under here, so it can be cached for good
+const staticPrefix = "_next/static/"
+
+// the frontend sets its own policy: its pages load their scripts and styles from this origin and
+// call the API on it, while avatars may come from Gravatar or a bucket
+const contentSecurityPolicy = "default-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data: https:; frame-ancestors 'none'"
+
+// Handler serves the embedded frontend, routing paths that name no file to index.html so the
+// app's client-side routing can take them. requests under /api/, and for missing files with an
+// extension, go to notFound. ok is false when the binary was built without a frontend.
+func Handler(notFound http.Handler) (h http.Handler, ok bool) {
+	if _, err := fs.Stat(dist, "index.html"); err != nil {
+		return nil, false
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		name, found := resolve(strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/"))
+		if !found {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		f, err := dist.Open(name)
+		if err != nil {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+
+		h := w.Header()
+		h.Set("Content-Security-Policy", contentSecurityPolicy)
+		// served as the file's own type, not the API's negotiated one
+		h.Del("Content-Type")
+		if strings.HasPrefix(name, staticPrefix) {
+			h.Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			h.Set("Cache-Control", "no-cache")
+		}
+		http.ServeContent(w, r, name, time.Time{}, f.(io.ReadSeeker))
+	}), true
+}
+
+// the file in dist serving name: the file itself, the page exported for it, or index.html for a
+// route only the app knows. found is false for a missing asset, which no page stands in for
+func resolve(name string) (file string, found bool) {
+	if name == "" {
+		return "index.html", true
+	}
+	for _, candidate := range []string{name, name + ".html", path.Join(name, "index.html")} {
+		if info, err := fs.Stat(dist, candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+	if path.Ext(name) != "" || strings.HasPrefix(name, "_next/") {
+		return "", false
+	}
+	return "index.html", true
+}
@@ -1,6 +1,7 @@
 /** @type {import('next').NextConfig} */
 const nextConfig = {
-  output: 'standalone'
+  // NEXT_OUTPUT=export builds static files for the Go server to embed, see backend/web
+  output: process.env.NEXT_OUTPUT === 'export' ? 'export' : 'standalone'
 }
 
 module.exports = nextConfig
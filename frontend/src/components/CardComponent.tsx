@@ -4,14 +4,22 @@ interface Card {
   id: number;
   name: string;
   email: string;
+  avatar_url: string | null;
 }
 
 const CardComponent: React.FC<{ card: Card }> = ({ card }) => {
   return (
-    <div className="bg-white shadow-lg rounded-lg p-2 mb-2 hover:bg-gray-100">
-      <div className="text-sm text-gray-600">Id: {card.id}</div>
-      <div className="text-lg font-semibold text-gray-800">{card.name}</div>
-      <div className="text-md text-gray-700">{card.email}</div>
+    <div className="bg-white shadow-lg rounded-lg p-2 mb-2 hover:bg-gray-100 flex items-center gap-3">
+      {card.avatar_url && (
+        // avatars come from Gravatar, the API or the upload bucket, so next/image can't know their hosts
+        // eslint-disable-next-line @next/next/no-img-element
+        <img src={card.avatar_url} alt="" className="w-12 h-12 rounded-full" />
+      )}
+      <div>
+        <div className="text-sm text-gray-600">Id: {card.id}</div>
+        <div className="text-lg font-semibold text-gray-800">{card.name}</div>
+        <div className="text-md text-gray-700">{card.email}</div>
+      </div>
     </div>
   );
 }
@@ -0,0 +1,104 @@
+import React, { useState } from 'react';
+import { AxiosInstance, isAxiosError } from 'axios';
+
+export interface Session {
+  token: string;
+  refresh_token: string;
+}
+
+interface LoginFormProps {
+  api: AxiosInstance;
+  onSignedIn: (session: Session) => void;
+  btnColor: string;
+}
+
+// what a problem response says went wrong, or a generic message when there was no answer
+const problemDetail = (error: unknown): string => {
+  if (isAxiosError(error) && error.response?.data?.detail) {
+    return error.response.data.detail;
+  }
+  return 'Something went wrong, try again';
+};
+
+const LoginForm: React.FC<LoginFormProps> = ({ api, onSignedIn, btnColor }) => {
+  const [registering, setRegistering] = useState(false);
+  const [credentials, setCredentials] = useState({ name: '', email: '', password: '' });
+  // set when the account has two-factor authentication on, to redeem with a code
+  const [challenge, setChallenge] = useState<string | null>(null);
+  const [code, setCode] = useState('');
+  const [error, setError] = useState<string | null>(null);
+
+  const submit = async (e: React.FormEvent<HTMLFormElement>) => {
+    e.preventDefault();
+    setError(null);
+    try {
+      if (challenge) {
+        const response = await api.post<Session>('/auth/2fa', { challenge, code });
+        onSignedIn(response.data);
+        return;
+      }
+      const response = registering
+        ? await api.post('/auth/register', credentials)
+        : await api.post('/auth/login', { email: credentials.email, password: credentials.password });
+      if (response.data.mfa_required) {
+        setChallenge(response.data.challenge);
+        return;
+      }
+      onSignedIn(response.data);
+    } catch (error) {
+      setError(problemDetail(error));
+    }
+  };
+
+  return (
+    <form onSubmit={submit} className="mb-6 p-4 bg-blue-100 rounded shadow">
+      {challenge ? (
+        <input
+          placeholder="Authentication code"
+          value={code}
+          onChange={(e) => setCode(e.target.value)}
+          autoComplete="one-time-code"
+          className="mb-2 w-full p-2 border border-gray-300 rounded"
+        />
+      ) : (
+        <>
+          {registering && (
+            <input
+              placeholder="Name"
+              value={credentials.name}
+              onChange={(e) => setCredentials({ ...credentials, name: e.target.value })}
+              className="mb-2 w-full p-2 border border-gray-300 rounded"
+            />
+          )}
+          <input
+            placeholder="Email"
+            type="email"
+            value={credentials.email}
+            onChange={(e) => setCredentials({ ...credentials, email: e.target.value })}
+            autoComplete="username"
+            className="mb-2 w-full p-2 border border-gray-300 rounded"
+          />
+          <input
+            placeholder="Password"
+            type="password"
+            value={credentials.password}
+            onChange={(e) => setCredentials({ ...credentials, password: e.target.value })}
+            autoComplete={registering ? 'new-password' : 'current-password'}
+            className="mb-2 w-full p-2 border border-gray-300 rounded"
+          />
+        </>
+      )}
+      {error && <div className="mb-2 text-sm text-red-700">{error}</div>}
+      <button type="submit" className={`w-full p-2 text-white rounded ${btnColor}`}>
+        {challenge ? 'Verify' : registering ? 'Register' : 'Log In'}
+      </button>
+      {!challenge && (
+        <button type="button" onClick={() => setRegistering(!registering)} className="mt-2 w-full text-sm text-gray-700 underline">
+          {registering ? 'Have an account? Log in' : 'Need an account? Register'}
+        </button>
+      )}
+    </form>
+  );
+};
+
+export default LoginForm;
@@ -1,11 +1,14 @@
-import React, { useState, useEffect } from 'react';
-import axios from 'axios';
+import React, { useState, useEffect, useMemo, useRef, useCallback } from 'react';
+import axios, { AxiosResponse, InternalAxiosRequestConfig } from 'axios';
 import CardComponent from './CardComponent';
+import LoginForm, { Session } from './LoginForm';
 
 interface User {
   id: number;
   name: string;
   email: string;
+  avatar_url: string | null;
+  version: number;
 }
 
 interface UserInterfaceProps {
@@ -13,7 +16,14 @@ interface UserInterfaceProps {
 }
 
 const UserInterface: React.FC<UserInterfaceProps> = ({ backendName }) => {
-  const apiUrl = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8000';
+  // set but empty when embedded in the Go server, which serves the API on the same origin
+  const apiUrl = process.env.NEXT_PUBLIC_API_URL ?? 'http://localhost:8000';
+  const api = useMemo(() => axios.create({ baseURL: `${apiUrl}/api/${backendName}` }), [apiUrl, backendName]);
+  // the access token is only kept in memory; the refresh token outlives a reload, to sign back in with
+  const refreshTokenKey = `${backendName}_refresh_token`;
+  const accessToken = useRef<string | null>(null);
+  const refreshing = useRef<Promise<boolean> | null>(null);
+  const [signedIn, setSignedIn] = useState(false);
   const [users, setUsers] = useState<User[]>([]);
   const [newUser, setNewUser] = useState({ name: '', email: '' });
   const [updateUser, setUpdateUser] = useState({ id: '', name: '', email: '' });
@@ -30,26 +40,107 @@ const UserInterface: React.FC<UserInterfaceProps> = ({ backendName }) => {
   const bgColor = backgroundColors[backendName as keyof typeof backgroundColors] || 'bg-gray-200';
   const btnColor = buttonColors[backendName as keyof typeof buttonColors] || 'bg-gray-500 hover:bg-gray-600';
 
+  const startSession = useCallback((session: Session) => {
+    accessToken.current = session.token;
+    localStorage.setItem(refreshTokenKey, session.refresh_token);
+    setSignedIn(true);
+  }, [refreshTokenKey]);
+
+  const endSession = useCallback(() => {
+    accessToken.current = null;
+    localStorage.removeItem(refreshTokenKey);
+    setSignedIn(false);
+    setUsers([]);
+  }, [refreshTokenKey]);
+
+  // trade the refresh token for a new session. requests failing together share one refresh, as the
+  // API revokes the whole session when a refresh token is used twice
+  const refreshSession = useCallback(() => {
+    if (!refreshing.current) {
+      const refreshToken = localStorage.getItem(refreshTokenKey);
+      const refreshed: Promise<AxiosResponse<Session>> = refreshToken
+        ? api.post<Session>('/auth/refresh', { refresh_token: refreshToken })
+        : Promise.reject(new Error('no refresh token'));
+      refreshing.current = refreshed
+        .then((response) => {
+          startSession(response.data);
+          return true;
+        })
+        .catch(() => {
+          endSession();
+          return false;
+        })
+        .finally(() => {
+          refreshing.current = null;
+        });
+    }
+    return refreshing.current;
+  }, [api, refreshTokenKey, startSession, endSession]);
+
+  // send the access token with every request, and retry one refused for an expired token once refreshed
+  useEffect(() => {
+    const request = api.interceptors.request.use((config) => {
+      if (accessToken.current) {
+        config.headers.Authorization = `Bearer ${accessToken.current}`;
+      }
+      return config;
+    });
+    const response = api.interceptors.response.use(undefined, async (error) => {
+      const original: (InternalAxiosRequestConfig & { retried?: boolean }) | undefined = error.config;
+      if (error.response?.status !== 401 || !original || original.retried || original.url?.startsWith('/auth/')) {
+        throw error;
+      }
+      original.retried = true;
+      if (!(await refreshSession())) {
+        throw error;
+      }
+      return api(original);
+    });
+    return () => {
+      api.interceptors.request.eject(request);
+      api.interceptors.response.eject(response);
+    };
+  }, [api, refreshSession]);
+
+  // sign back in with the refresh token kept from the last visit
+  useEffect(() => {
+    if (localStorage.getItem(refreshTokenKey)) {
+      refreshSession();
+    }
+  }, [refreshTokenKey, refreshSession]);
+
   // Fetch all users
   useEffect(() => {
+    if (!signedIn) {
+      return;
+    }
     const fetchData = async () => {
       try {
-        const response = await axios.get(`${apiUrl}/api/${backendName}/users`);
-        setUsers(response.data.reverse());
+        const response = await api.get('/users');
+        setUsers(response.data.items.reverse());
       } catch (error) {
         console.error('Error fetching data:', error);
       }
     };
 
     fetchData();
-  }, [backendName, apiUrl]);
+  }, [api, signedIn]);
+
+  const logout = async () => {
+    try {
+      await api.post('/auth/logout', { refresh_token: localStorage.getItem(refreshTokenKey) });
+    } catch (error) {
+      console.error('Error logging out:', error);
+    }
+    endSession();
+  };
 
   // Create a new user
   const createUser = async (e: React.FormEvent<HTMLFormElement>) => {
     e.preventDefault();
 
     try {
-      const response = await axios.post(`${apiUrl}/api/${backendName}/users`, newUser);
+      const response = await api.post('/users', newUser);
       setUsers([response.data, ...users]);
       setNewUser({ name: '', email: '' });
     } catch (error) {
@@ -61,12 +152,18 @@ const UserInterface: React.FC<UserInterfaceProps> = ({ backendName }) => {
   const handleUpdateUser = async (e: React.FormEvent<HTMLFormElement>) => {
     e.preventDefault();
     try {
-      await axios.put(`${apiUrl}/api/${backendName}/users/${updateUser.id}`, { name: updateUser.name, email: updateUser.email });
+      // send the version we last saw so the API rejects the edit if someone else changed the user first
+      const current = users.find((user) => user.id === parseInt(updateUser.id));
+      const response = await api.put(`/users/${updateUser.id}`, {
+        name: updateUser.name,
+        email: updateUser.email,
+        version: current?.version,
+      });
       setUpdateUser({ id: '', name: '', email: '' });
       setUsers(
         users.map((user) => {
           if (user.id === parseInt(updateUser.id)) {
-            return { ...user, name: updateUser.name, email: updateUser.email };
+            return response.data;
           }
           return user;
         })
@@ -79,7 +176,7 @@ const UserInterface: React.FC<UserInterfaceProps> = ({ backendName }) => {
   // Delete a user
   const deleteUser = async (userId: number) => {
     try {
-      await axios.delete(`${apiUrl}/api/${backendName}/users/${userId}`);
+      await api.delete(`/users/${userId}`);
       setUsers(users.filter((user) => user.id !== userId));
     } catch (error) {
       console.error('Error deleting user:', error);
@@ -91,6 +188,14 @@ const UserInterface: React.FC<UserInterfaceProps> = ({ backendName }) => {
       <img src={`/${backendName}logo.svg`} alt={`${backendName} Logo`} className="w-20 h-20 mb-6 mx-auto" />
       <h2 className="text-xl font-bold text-center text-white mb-6">{`${backendName.charAt(0).toUpperCase() + backendName.slice(1)} Backend`}</h2>
 
+      {!signedIn ? (
+        <LoginForm api={api} onSignedIn={startSession} btnColor={btnColor} />
+      ) : (
+      <>
+      <button onClick={logout} className={`${btnColor} mb-6 w-full text-white py-2 px-4 rounded`}>
+        Log Out
+      </button>
+
       {/* Create user */}
       <form onSubmit={createUser} className="mb-6 p-4 bg-blue-100 rounded shadow">
         <input
@@ -146,6 +251,8 @@ const UserInterface: React.FC<UserInterfaceProps> = ({ backendName }) => {
           </div>
         ))}
       </div>
+      </>
+      )}
     </div>
   );
 };